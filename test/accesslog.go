@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	// ACCESS_LOG is combined (the Apache/NGINX format) or json; unset, no
+	// access log is written. It goes to ACCESS_LOG_FILE, or stderr so it
+	// stays apart from the application log on stdout.
+	accessLogFormat = os.Getenv("ACCESS_LOG")
+	accessLog       = openAccessLog(accessLogFormat, os.Getenv("ACCESS_LOG_FILE"))
+)
+
+// AccessLogEntry is one line of the json access log.
+type AccessLogEntry struct {
+	Time       string `json:"time"`
+	RemoteAddr string `json:"remote_addr"`
+	Principal  string `json:"principal,omitempty"`
+	Method     string `json:"method"`
+	URI        string `json:"uri"`
+	Protocol   string `json:"protocol"`
+	Route      string `json:"route,omitempty"`
+	Status     int    `json:"status"`
+	Bytes      int64  `json:"bytes"`
+	DurationMS int64  `json:"duration_ms"`
+	Referer    string `json:"referer,omitempty"`
+	UserAgent  string `json:"user_agent,omitempty"`
+	RequestID  string `json:"request_id"`
+	ClientCert string `json:"client_cert,omitempty"`
+}
+
+type accessLogger struct {
+	format string
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func openAccessLog(format, path string) *accessLogger {
+	switch format {
+	case "":
+		return nil
+	case "combined", "json":
+	default:
+		fatal("Invalid ACCESS_LOG: must be combined or json", "format", format)
+	}
+	if path == "" {
+		return &accessLogger{format: format, w: os.Stderr}
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		fatal("Failed to open ACCESS_LOG_FILE", "path", path, "err", err)
+	}
+	return &accessLogger{format: format, w: f}
+}
+
+func (l *accessLogger) write(entry AccessLogEntry) {
+	var line []byte
+	if l.format == "json" {
+		line, _ = json.Marshal(entry)
+	} else {
+		line = []byte(combinedLine(entry))
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(append(line, '\n'))
+}
+
+// combinedLine formats entry in the combined log format, so existing log
+// tooling can read it.
+func combinedLine(entry AccessLogEntry) string {
+	t, _ := time.Parse(time.RFC3339Nano, entry.Time)
+	return fmt.Sprintf(`%s - %s [%s] "%s %s %s" %d %s "%s" "%s"`,
+		entry.RemoteAddr,
+		orDash(url.PathEscape(entry.Principal)),
+		t.Format("02/Jan/2006:15:04:05 -0700"),
+		entry.Method, escapeCombined(entry.URI), entry.Protocol,
+		entry.Status,
+		orDash(fmt.Sprint(entry.Bytes)),
+		orDash(escapeCombined(entry.Referer)),
+		orDash(escapeCombined(entry.UserAgent)),
+	)
+}
+
+func orDash(s string) string {
+	if s == "" || s == "0" {
+		return "-"
+	}
+	return s
+}
+
+// escapeCombined keeps caller-supplied text from breaking out of its quoted
+// field or onto another line.
+func escapeCombined(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(c)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// logAccess writes an access log line per request once it has been
+// answered. Query parameters that carry credentials are redacted, as in
+// captures.
+func logAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if accessLog == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		uri := r.URL.EscapedPath()
+		if r.URL.RawQuery != "" {
+			uri += "?" + sanitizeQuery(r.URL.Query())
+		}
+		entry := AccessLogEntry{
+			Time:       start.UTC().Format(time.RFC3339Nano),
+			RemoteAddr: clientAddr(r),
+			Method:     r.Method,
+			URI:        uri,
+			Protocol:   r.Proto,
+			Status:     rec.status,
+			Bytes:      rec.bytes,
+			DurationMS: time.Since(start).Milliseconds(),
+			Referer:    r.Referer(),
+			UserAgent:  r.UserAgent(),
+			ClientCert: clientCertSubject(r.TLS),
+		}
+		if route := mux.CurrentRoute(r); route != nil {
+			entry.Route = route.GetName()
+		}
+		if info, ok := requestInfoFrom(r.Context()); ok {
+			entry.RequestID = info.ID
+			info.mu.Lock()
+			entry.Principal = info.principal
+			info.mu.Unlock()
+		}
+		accessLog.write(entry)
+	})
+}
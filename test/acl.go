@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+)
+
+// ACLs are kept as empty marker objects under this prefix of each namespace,
+// one per role: .acl/<filename>/<owner|read|write>/<escaped principal>. A
+// whole namespace's ACLs can then be read with a single listing.
+const aclPrefix = ".acl/"
+
+const (
+	roleOwner = "owner"
+	permRead  = "read"
+	permWrite = "write"
+
+	// anyPrincipal in a grant stands for every caller in the tenant
+	anyPrincipal = "*"
+)
+
+// With ACLS_ENABLED=true uploads record their uploader as owner, and files
+// with an ACL are only visible to their owner and grantees. Files without
+// one stay open to the whole tenant.
+var aclsEnabled = os.Getenv("ACLS_ENABLED") == "true"
+
+var errAccessDenied = errors.New("access denied")
+
+// fileACL is the owner of a file and the permissions granted on it.
+type fileACL struct {
+	Owner  string
+	Grants map[string]string
+
+	// entries are the marker keys the ACL was read from
+	entries []string
+}
+
+// allows reports whether subject may use the file for perm. Write implies
+// read; a nil ACL allows everything.
+func (a *fileACL) allows(subject, perm string) bool {
+	if a == nil || subject == a.Owner {
+		return true
+	}
+	for _, who := range []string{subject, anyPrincipal} {
+		if granted, ok := a.Grants[who]; ok && (granted == perm || granted == permWrite) {
+			return true
+		}
+	}
+	return false
+}
+
+type ACLGrant struct {
+	Principal  string `json:"principal"`
+	Permission string `json:"permission"`
+}
+
+type ACLResponse struct {
+	Filename   string     `json:"filename"`
+	Restricted bool       `json:"restricted"`
+	Owner      string     `json:"owner,omitempty"`
+	Grants     []ACLGrant `json:"grants"`
+}
+
+// requestSubject is who ACLs are checked against: the authenticated subject,
+// or the tenant for credentials that don't name one.
+func requestSubject(r *http.Request) string {
+	return contextSubject(r.Context())
+}
+
+// contextSubject is requestSubject for code that only has the request's
+// context.
+func contextSubject(ctx context.Context) string {
+	p, ok := ctx.Value(principalKey{}).(principal)
+	switch {
+	case !ok:
+		return defaultTenant
+	case p.Subject != "":
+		return p.Subject
+	}
+	return p.Tenant
+}
+
+func aclEntryKey(ctx context.Context, key, role, principal string) string {
+	ns := namespaceFrom(ctx)
+	return ns.key(aclPrefix + ns.name(key) + "/" + role + "/" + url.PathEscape(principal))
+}
+
+// parseACLEntry splits a marker key, relative to the ACL prefix, into the
+// filename it belongs to and the role and principal it records.
+func parseACLEntry(entry string) (name, role, principal string, ok bool) {
+	rest, escaped, ok := cutLast(entry, "/")
+	if !ok {
+		return "", "", "", false
+	}
+	name, role, ok = cutLast(rest, "/")
+	if !ok || (role != roleOwner && role != permRead && role != permWrite) {
+		return "", "", "", false
+	}
+	principal, err := url.PathUnescape(escaped)
+	if err != nil {
+		return "", "", "", false
+	}
+	return name, role, principal, true
+}
+
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// loadACLs reads every ACL under prefix, a key in the namespace of ctx, keyed
+// by filename.
+func loadACLs(ctx context.Context, prefix string) (map[string]*fileACL, error) {
+	ns := namespaceFrom(ctx)
+	root := ns.key(aclPrefix)
+	keys, err := listPrefix(ctx, root+ns.name(prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	acls := map[string]*fileACL{}
+	for _, key := range keys {
+		name, role, principal, ok := parseACLEntry(strings.TrimPrefix(key, root))
+		if !ok {
+			continue
+		}
+		acl := acls[name]
+		if acl == nil {
+			acl = &fileACL{Grants: map[string]string{}}
+			acls[name] = acl
+		}
+		acl.entries = append(acl.entries, key)
+		if role == roleOwner {
+			acl.Owner = principal
+		} else {
+			acl.Grants[principal] = role
+		}
+	}
+	return acls, nil
+}
+
+// loadACL returns the ACL of key, or nil if it has none.
+func loadACL(ctx context.Context, key string) (*fileACL, error) {
+	acls, err := loadACLs(ctx, key+"/")
+	if err != nil {
+		return nil, err
+	}
+	return acls[namespaceFrom(ctx).name(key)], nil
+}
+
+func putACLEntry(ctx context.Context, key, role, principal string) error {
+	_, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(bucketFor(ctx)),
+		Key:    aws.String(aclEntryKey(ctx, key, role, principal)),
+		Body:   bytes.NewReader(nil),
+	})
+	return err
+}
+
+// deleteACLEntries removes marker keys without the events and replication
+// deleteKeys applies to files.
+func deleteACLEntries(ctx context.Context, keys []string) error {
+	return deleteInBatches(ctx, keys, func(int, *s3.DeleteObjectsOutput) {})
+}
+
+// checkAccess loads the ACL of key and returns errAccessDenied unless subject
+// may use it for perm. The ACL is returned so callers needn't load it again,
+// and is nil when ACLs are off or the file has none.
+func checkAccess(ctx context.Context, subject, key, perm string) (*fileACL, error) {
+	if !aclsEnabled {
+		return nil, nil
+	}
+	acl, err := loadACL(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !acl.allows(subject, perm) {
+		return acl, errAccessDenied
+	}
+	return acl, nil
+}
+
+// authorizeFile writes a 403 or 500 and returns false unless the caller may
+// use key for perm.
+func authorizeFile(w http.ResponseWriter, r *http.Request, key, perm string) bool {
+	_, err := checkAccess(r.Context(), requestSubject(r), key, perm)
+	if err == nil {
+		return true
+	}
+	respondAccessError(w, r, key, perm, err)
+	return false
+}
+
+func respondAccessError(w http.ResponseWriter, r *http.Request, key, perm string, err error) {
+	if errors.Is(err, errAccessDenied) {
+		respondJSON(w, http.StatusForbidden, ErrorResponse{
+			Error:   "Access denied",
+			Details: fmt.Sprintf("%s access to %s has not been granted", perm, requestNamespace(r).name(key)),
+		})
+		return
+	}
+	respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+		Error:   "Failed to check access",
+		Details: err.Error(),
+	})
+}
+
+func aclsDisabled(w http.ResponseWriter) {
+	respondJSON(w, http.StatusNotFound, ErrorResponse{
+		Error: "ACLs are disabled",
+	})
+}
+
+func aclUpdateFailed(w http.ResponseWriter, err error) {
+	respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+		Error:   "Failed to update ACL",
+		Details: err.Error(),
+	})
+}
+
+func aclResponse(filename string, acl *fileACL) ACLResponse {
+	resp := ACLResponse{Filename: filename, Grants: []ACLGrant{}}
+	if acl == nil {
+		return resp
+	}
+	resp.Restricted, resp.Owner = true, acl.Owner
+	for _, principal := range sortedKeys(acl.Grants) {
+		resp.Grants = append(resp.Grants, ACLGrant{Principal: principal, Permission: acl.Grants[principal]})
+	}
+	return resp
+}
+
+func getACLHandler(w http.ResponseWriter, r *http.Request) {
+	if !aclsEnabled {
+		aclsDisabled(w)
+		return
+	}
+	filename := mux.Vars(r)["filename"]
+	key := requestNamespace(r).key(filename)
+
+	acl, err := checkAccess(r.Context(), requestSubject(r), key, permRead)
+	if err != nil {
+		respondAccessError(w, r, key, permRead, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, aclResponse(filename, acl))
+}
+
+// authorizeOwner loads the ACL of an existing file for a change by its owner.
+// Nobody owns a file without an ACL yet, so whoever first sets one claims it.
+func authorizeOwner(w http.ResponseWriter, r *http.Request, key string) (*fileACL, bool) {
+	ctx := r.Context()
+	exists, err := objectExists(ctx, key)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to check file",
+			Details: err.Error(),
+		})
+		return nil, false
+	}
+	if !exists {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{
+			Error: "File not found",
+		})
+		return nil, false
+	}
+
+	acl, err := loadACL(ctx, key)
+	if err != nil {
+		respondAccessError(w, r, key, roleOwner, err)
+		return nil, false
+	}
+	subject := requestSubject(r)
+	if acl == nil {
+		if err := putACLEntry(ctx, key, roleOwner, subject); err != nil {
+			aclUpdateFailed(w, err)
+			return nil, false
+		}
+		acl = &fileACL{Owner: subject, Grants: map[string]string{}}
+	}
+	if acl.Owner != subject {
+		respondJSON(w, http.StatusForbidden, ErrorResponse{
+			Error:   "Access denied",
+			Details: "only the owner can change the ACL",
+		})
+		return nil, false
+	}
+	return acl, true
+}
+
+func grantAccessHandler(w http.ResponseWriter, r *http.Request) {
+	if !aclsEnabled {
+		aclsDisabled(w)
+		return
+	}
+
+	var grant ACLGrant
+	if err := json.NewDecoder(r.Body).Decode(&grant); err != nil {
+		respondInvalidBody(w, err)
+		return
+	}
+	if grant.Principal == "" || (grant.Permission != permRead && grant.Permission != permWrite) {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid grant",
+			Details: "principal is required and permission must be read or write",
+		})
+		return
+	}
+
+	ctx := r.Context()
+	filename := mux.Vars(r)["filename"]
+	key := requestNamespace(r).key(filename)
+	acl, ok := authorizeOwner(w, r, key)
+	if !ok {
+		return
+	}
+
+	if err := putACLEntry(ctx, key, grant.Permission, grant.Principal); err != nil {
+		aclUpdateFailed(w, err)
+		return
+	}
+	// A principal holds one permission; granting the other replaces it
+	if previous, ok := acl.Grants[grant.Principal]; ok && previous != grant.Permission {
+		if err := deleteACLEntries(ctx, []string{aclEntryKey(ctx, key, previous, grant.Principal)}); err != nil {
+			aclUpdateFailed(w, err)
+			return
+		}
+	}
+	acl.Grants[grant.Principal] = grant.Permission
+
+	recordEvent(ctx, eventACLChanged, key, map[string]string{"principal": grant.Principal, "permission": grant.Permission})
+	respondJSON(w, http.StatusOK, aclResponse(filename, acl))
+}
+
+func revokeAccessHandler(w http.ResponseWriter, r *http.Request) {
+	if !aclsEnabled {
+		aclsDisabled(w)
+		return
+	}
+
+	principal := r.URL.Query().Get("principal")
+	if principal == "" {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Missing principal parameter",
+		})
+		return
+	}
+
+	ctx := r.Context()
+	filename := mux.Vars(r)["filename"]
+	key := requestNamespace(r).key(filename)
+	acl, ok := authorizeOwner(w, r, key)
+	if !ok {
+		return
+	}
+
+	permission, granted := acl.Grants[principal]
+	if !granted {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{
+			Error: "Grant not found",
+		})
+		return
+	}
+	if err := deleteACLEntries(ctx, []string{aclEntryKey(ctx, key, permission, principal)}); err != nil {
+		aclUpdateFailed(w, err)
+		return
+	}
+	delete(acl.Grants, principal)
+
+	recordEvent(ctx, eventACLChanged, key, map[string]string{"principal": principal, "permission": "none"})
+	respondJSON(w, http.StatusOK, aclResponse(filename, acl))
+}
+
+// readableNames filters names, relative to the namespace of ctx, down to the
+// ones subject may read. It reads the namespace's ACLs in one listing.
+func readableNames(ctx context.Context, subject string, names []string) ([]string, error) {
+	if !aclsEnabled {
+		return names, nil
+	}
+	acls, err := loadACLs(ctx, namespaceFrom(ctx).key(""))
+	if err != nil {
+		return nil, err
+	}
+
+	readable := names[:0]
+	for _, name := range names {
+		if acls[name].allows(subject, permRead) {
+			readable = append(readable, name)
+		}
+	}
+	return readable, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// bearer signs a JWT for sub in tenant acme with the fake provider's key.
+func (idp *fakeIdP) bearer(t *testing.T, sub string) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"sub":    sub,
+		"tenant": "acme",
+		"exp":    time.Now().Add(time.Hour).Unix(),
+	})
+	token.Header["kid"] = "k1"
+	signed, err := token.SignedString(idp.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "Bearer " + signed
+}
+
+func TestFileACLs(t *testing.T) {
+	srv, fake := newTestServer(t)
+	idp := newFakeIdP(t, nil)
+	override(t, &jwtJWKSURL, idp.URL+"/jwks")
+	override(t, &jwtTenantClaim, "tenant")
+	override(t, &aclsEnabled, true)
+	alice, bob := idp.bearer(t, "alice"), idp.bearer(t, "bob")
+
+	mustUpload(t, srv, "/api", "alice.txt", "private", "Authorization", alice)
+	mustUpload(t, srv, "/api", "shared/notes.txt", "team", "Authorization", bob)
+	if _, _, ok := fake.Object(bucketName, "tenants/acme/.acl/alice.txt/owner/alice"); !ok {
+		t.Fatalf("owner not recorded, bucket holds %v", fake.Keys(bucketName))
+	}
+
+	var files FilesResponse
+	call(t, srv, "GET", "/api/files", nil, "Authorization", bob).decode(t, &files)
+	if !reflect.DeepEqual(files.Files, []string{"shared/notes.txt"}) {
+		t.Fatalf("bob lists %v", files.Files)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+		status int
+	}{
+		{"read denied", "GET", "/api/files/alice.txt", nil, http.StatusForbidden},
+		{"metadata denied", "GET", "/api/files/alice.txt/metadata", nil, http.StatusForbidden},
+		{"delete denied", "DELETE", "/api/files/alice.txt", nil, http.StatusForbidden},
+		{"overwrite denied", "POST", "/api/upload", upload("alice.txt", "mine now"), http.StatusForbidden},
+		{"grant by non-owner", "POST", "/api/files/alice.txt/acl/grants", ACLGrant{Principal: "bob", Permission: permWrite}, http.StatusForbidden},
+		{"grant on missing file", "POST", "/api/files/nope.txt/acl/grants", ACLGrant{Principal: "bob", Permission: permRead}, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expectStatus(t, call(t, srv, tt.method, tt.path, tt.body, "Authorization", bob), tt.status)
+		})
+	}
+
+	grant := call(t, srv, "POST", "/api/files/alice.txt/acl/grants", ACLGrant{Principal: "bob", Permission: permRead}, "Authorization", alice)
+	expectStatus(t, grant, http.StatusOK)
+	var acl ACLResponse
+	grant.decode(t, &acl)
+	if !acl.Restricted || acl.Owner != "alice" || !reflect.DeepEqual(acl.Grants, []ACLGrant{{"bob", permRead}}) {
+		t.Fatalf("acl %+v", acl)
+	}
+
+	if got := call(t, srv, "GET", "/api/files/alice.txt", nil, "Authorization", bob); string(got.body) != "private" {
+		t.Fatalf("bob read %d %q", got.StatusCode, got.body)
+	}
+	expectStatus(t, call(t, srv, "DELETE", "/api/files/alice.txt", nil, "Authorization", bob), http.StatusForbidden)
+
+	expectStatus(t, call(t, srv, "DELETE", "/api/files/alice.txt/acl/grants?principal=bob", nil, "Authorization", alice), http.StatusOK)
+	expectStatus(t, call(t, srv, "GET", "/api/files/alice.txt", nil, "Authorization", bob), http.StatusForbidden)
+	expectStatus(t, call(t, srv, "DELETE", "/api/files/alice.txt/acl/grants?principal=bob", nil, "Authorization", alice), http.StatusNotFound)
+
+	expectStatus(t, call(t, srv, "DELETE", "/api/files/alice.txt", nil, "Authorization", alice), http.StatusOK)
+	for _, key := range fake.Keys(bucketName) {
+		if key == "tenants/acme/.acl/alice.txt/owner/alice" {
+			t.Fatal("ACL left behind after delete")
+		}
+	}
+}
+
+func TestFolderDeleteSkipsUnwritableFiles(t *testing.T) {
+	srv, fake := newTestServer(t)
+	idp := newFakeIdP(t, nil)
+	override(t, &jwtJWKSURL, idp.URL+"/jwks")
+	override(t, &jwtTenantClaim, "tenant")
+	override(t, &aclsEnabled, true)
+	alice, bob := idp.bearer(t, "alice"), idp.bearer(t, "bob")
+
+	mustUpload(t, srv, "/api", "logs/alice.log", "a", "Authorization", alice)
+	mustUpload(t, srv, "/api", "logs/bob.log", "b", "Authorization", bob)
+
+	var resp FolderDeleteResponse
+	call(t, srv, "DELETE", "/api/folders/logs", nil, "Authorization", bob).decode(t, &resp)
+	if resp.Count != 1 || len(resp.Errors) != 1 || resp.Errors[0].Key != "logs/alice.log" {
+		t.Fatalf("folder delete %+v", resp)
+	}
+	if _, _, ok := fake.Object(bucketName, "tenants/acme/logs/alice.log"); !ok {
+		t.Fatal("deleted a file bob can't write")
+	}
+	if _, _, ok := fake.Object(bucketName, "tenants/acme/.acl/logs/bob.log/owner/bob"); ok {
+		t.Fatal("ACL of deleted file left behind")
+	}
+}
+
+func TestPurgedTrashLosesACL(t *testing.T) {
+	srv, fake := newTestServer(t)
+	idp := newFakeIdP(t, nil)
+	override(t, &jwtJWKSURL, idp.URL+"/jwks")
+	override(t, &jwtTenantClaim, "tenant")
+	override(t, &aclsEnabled, true)
+	override(t, &softDeleteEnabled, true)
+	alice, bob := idp.bearer(t, "alice"), idp.bearer(t, "bob")
+
+	mustUpload(t, srv, "/api", "alice.txt", "private", "Authorization", alice)
+	mustUpload(t, srv, "/api", "bob.txt", "old", "Authorization", bob)
+	expectStatus(t, call(t, srv, "DELETE", "/api/files/alice.txt", nil, "Authorization", alice), http.StatusOK)
+	expectStatus(t, call(t, srv, "DELETE", "/api/files/bob.txt", nil, "Authorization", bob), http.StatusOK)
+	// The ACL goes with the file to the trash, and to one uploaded in its place
+	if _, _, ok := fake.Object(bucketName, "tenants/acme/.acl/alice.txt/owner/alice"); !ok {
+		t.Fatal("ACL of trashed file removed")
+	}
+	mustUpload(t, srv, "/api", "bob.txt", "new", "Authorization", bob)
+
+	override(t, &trashRetention, -time.Hour)
+	if err := purgeTrash(withNamespace(context.Background(), namespaceFor("acme"))); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := fake.Object(bucketName, "tenants/acme/.trash/alice.txt"); ok {
+		t.Fatal("trash not purged")
+	}
+	if _, _, ok := fake.Object(bucketName, "tenants/acme/.acl/alice.txt/owner/alice"); ok {
+		t.Error("ACL of purged file left behind")
+	}
+	if _, _, ok := fake.Object(bucketName, "tenants/acme/.acl/bob.txt/owner/bob"); !ok {
+		t.Error("ACL of re-uploaded file removed")
+	}
+}
@@ -0,0 +1,695 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/gorilla/mux"
+)
+
+// The GitHub Actions cache protocol, which actions/cache and the toolkit
+// speak to ACTIONS_CACHE_URL, so self-hosted runners can keep their caches
+// in the files bucket. A runner is given the URL and nothing else, so the
+// credential is part of it: ACTIONS_CACHE_URL=https://host/api/actions-cache/<token>/.
+//
+// Caches are stored in the tenant's namespace under actionsCachePrefix, by
+// scope, version and key, so restore keys are matched by listing a prefix.
+// Within a tenant, the scopes a job may read and write are the refs in the
+// "ac" claim of the runtime token the runner sends. GitHub signs that token
+// and this service can't check it, so the scopes keep jobs from reading and
+// poisoning other branches' caches, but the path token is what keeps others
+// out.
+const actionsCachePrefix = ".actions-cache/"
+
+// Chunks are uploaded as parts of one multipart upload, numbered by their
+// offset in these units. S3 takes at most 10000 parts, and the toolkit sends
+// 32 MiB chunks.
+const actionsCachePartAlign = 1 << 20
+
+var (
+	// ACTIONS_CACHE_TOKENS="<token>=<tenant>,..." enables the cache. The
+	// tenant is ignored when tenancy is off.
+	actionsCacheTokens = parseAssignments(os.Getenv("ACTIONS_CACHE_TOKENS"))
+
+	actionsCacheMaxSize = int64(intFromEnv("ACTIONS_CACHE_MAX_BYTES", 10<<30))
+	// A reservation older than this whose upload never committed, e.g. of a
+	// cancelled job, no longer stops another job saving the same cache
+	actionsCacheReservationTTL = durationFromEnv("ACTIONS_CACHE_RESERVATION_TTL", time.Hour)
+)
+
+func init() {
+	for _, tenant := range actionsCacheTokens {
+		if tenancyEnabled() && !validTenantID.MatchString(tenant) {
+			fatal("Invalid tenant id in ACTIONS_CACHE_TOKENS", "tenant", tenant)
+		}
+	}
+	// Chunks are the only raw bodies the API takes, and are bigger than the
+	// JSON ones. A limit set for them is applied after this, in bodylimit.go.
+	if len(actionsCacheTokens) > 0 {
+		bodyLimitsByType["application/octet-stream"] = 64 << 20
+	}
+}
+
+const (
+	actionsCacheRead  = 1
+	actionsCacheWrite = 2
+)
+
+// actionsCacheScope is a ref whose caches a job may read or write, as the
+// runtime token's "ac" claim lists them.
+type actionsCacheScope struct {
+	Scope      string
+	Permission int
+}
+
+type ActionsCacheEntry struct {
+	CacheKey        string `json:"cacheKey"`
+	Scope           string `json:"scope"`
+	CacheVersion    string `json:"cacheVersion"`
+	CreationTime    string `json:"creationTime"`
+	ArchiveLocation string `json:"archiveLocation"`
+}
+
+type ActionsCacheReserveRequest struct {
+	Key       string `json:"key"`
+	Version   string `json:"version"`
+	CacheSize int64  `json:"cacheSize,omitempty"`
+}
+
+type ActionsCacheReserveResponse struct {
+	CacheID int64 `json:"cacheId"`
+}
+
+type ActionsCacheCommitRequest struct {
+	Size int64 `json:"size"`
+}
+
+// actionsCacheUpload is a reserved cache, kept after it is committed so its
+// archive can be downloaded by ID. Its size is the one the runner reserved,
+// if it said, until it is committed.
+type actionsCacheUpload struct {
+	ID        int64     `json:"id"`
+	Key       string    `json:"key"`
+	UploadID  string    `json:"upload_id"`
+	CacheKey  string    `json:"cache_key"`
+	Version   string    `json:"version"`
+	Scope     string    `json:"scope"`
+	Size      int64     `json:"size,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Committed bool      `json:"committed,omitempty"`
+}
+
+// actionsCachePart is an uploaded chunk. Each has a record of its own so
+// concurrent chunks don't contend for one.
+type actionsCachePart struct {
+	Number int32  `json:"number"`
+	Start  int64  `json:"start"`
+	End    int64  `json:"end"`
+	ETag   string `json:"etag"`
+}
+
+type actionsCacheReservation struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// authorizeActionsCache resolves the token in the path to its tenant and
+// scopes the request to the tenant's namespace.
+func authorizeActionsCache(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(actionsCacheTokens) == 0 {
+			respondJSON(w, http.StatusNotFound, ErrorResponse{
+				Error: "Actions cache is disabled",
+			})
+			return
+		}
+		// Compare against every token so timing doesn't reveal how close a guess was
+		given, tenant, found := mux.Vars(r)["token"], "", false
+		for token, t := range actionsCacheTokens {
+			if subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1 {
+				tenant, found = t, true
+			}
+		}
+		if !found {
+			respondJSON(w, http.StatusUnauthorized, ErrorResponse{
+				Error: "Valid cache token required",
+			})
+			return
+		}
+
+		ctx := r.Context()
+		if tenancyEnabled() {
+			p := principal{Subject: "actions-cache", Tenant: tenant, Method: "actions_cache_token"}
+			ctx = context.WithValue(ctx, principalKey{}, p)
+			setLogPrincipal(ctx, "actions-cache:"+tenant)
+			ctx = withNamespace(ctx, namespaceFor(tenant))
+		} else {
+			setLogPrincipal(ctx, "actions-cache")
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// actionsCacheScopes reads the scopes from the runtime token the runner
+// sends. Without any, every job shares one unnamed scope.
+func actionsCacheScopes(r *http.Request) []actionsCacheScope {
+	everything := []actionsCacheScope{{Scope: "", Permission: actionsCacheRead | actionsCacheWrite}}
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return everything
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return everything
+	}
+	var claims struct {
+		AC string `json:"ac"`
+	}
+	var scopes []actionsCacheScope
+	if json.Unmarshal(payload, &claims) != nil || json.Unmarshal([]byte(claims.AC), &scopes) != nil || len(scopes) == 0 {
+		return everything
+	}
+	return scopes
+}
+
+func actionsCacheObjectKey(ns namespace, scope, version, cacheKey string) string {
+	return ns.key(actionsCachePrefix + "caches/" + url.PathEscape(scope) + "/" + url.PathEscape(version) + "/" + url.PathEscape(cacheKey))
+}
+
+func actionsCacheUploadKey(ns namespace, id int64) string {
+	return ns.key(actionsCachePrefix + "uploads/" + strconv.FormatInt(id, 10))
+}
+
+func actionsCachePartsPrefix(ns namespace, id int64) string {
+	return ns.key(actionsCachePrefix + "parts/" + strconv.FormatInt(id, 10) + "/")
+}
+
+// actionsCacheReservationKey is named by a hash, as keys and scopes together
+// can be longer than an S3 key.
+func actionsCacheReservationKey(ns namespace, scope, version, cacheKey string) string {
+	sum := sha256.Sum256([]byte(scope + "\x00" + version + "\x00" + cacheKey))
+	return ns.key(actionsCachePrefix + "reservations/" + hex.EncodeToString(sum[:]))
+}
+
+func putActionsCacheRecord(ctx context.Context, key string, v any, ifNoneMatch bool, ifMatch *string) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(bucketFor(ctx)),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+		IfMatch:     ifMatch,
+	}
+	if ifNoneMatch {
+		input.IfNoneMatch = aws.String("*")
+	}
+	_, err = s3Client.PutObject(ctx, input)
+	return err
+}
+
+func loadActionsCacheRecord(ctx context.Context, key string, v any) (string, error) {
+	result, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketFor(ctx)),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", err
+	}
+	defer result.Body.Close()
+	if err := json.NewDecoder(result.Body).Decode(v); err != nil {
+		return "", fmt.Errorf("decoding %s: %w", key, err)
+	}
+	return aws.ToString(result.ETag), nil
+}
+
+func actionsCacheError(w http.ResponseWriter, err error) {
+	respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+		Error:   "Actions cache storage failed",
+		Details: err.Error(),
+	})
+}
+
+// getActionsCacheHandler finds the cache to restore. Like GitHub, it looks
+// in the job's own scope before the others it may read, and in each tries
+// the keys in turn, first exactly, then as a prefix the newest match of wins.
+func getActionsCacheHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	ns := requestNamespace(r)
+	version := r.URL.Query().Get("version")
+	keys := splitList(r.URL.Query().Get("keys"))
+	if version == "" || len(keys) == 0 || len(keys) > 10 {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error: "keys (at most 10) and version are required",
+		})
+		return
+	}
+	var readable []string
+	for _, s := range actionsCacheScopes(r) {
+		if s.Permission&actionsCacheRead != 0 {
+			readable = append(readable, s.Scope)
+		}
+	}
+
+	for _, scope := range readable {
+		for _, cacheKey := range keys {
+			objectKey := actionsCacheObjectKey(ns, scope, version, cacheKey)
+			head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(ns.Bucket), Key: aws.String(objectKey)})
+			if isNotFound(err) {
+				if objectKey, err = newestActionsCache(ctx, objectKey); err == nil && objectKey != "" {
+					head, err = s3Client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(ns.Bucket), Key: aws.String(objectKey)})
+				}
+			}
+			if err != nil {
+				actionsCacheError(w, err)
+				return
+			}
+			if objectKey == "" {
+				continue
+			}
+			name, _ := url.PathUnescape(objectKey[strings.LastIndex(objectKey, "/")+1:])
+			respondJSON(w, http.StatusOK, actionsCacheEntry(r, scope, version, name, head))
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// newestActionsCache is the most recently saved cache whose key starts with
+// prefix, or "" if there is none.
+func newestActionsCache(ctx context.Context, prefix string) (string, error) {
+	var newest string
+	var newestTime time.Time
+	input := &s3.ListObjectsV2Input{Bucket: aws.String(bucketFor(ctx)), Prefix: aws.String(prefix)}
+	for {
+		page, err := s3Client.ListObjectsV2(ctx, input)
+		if err != nil {
+			return "", err
+		}
+		for _, obj := range page.Contents {
+			// Keys are escaped, so a slash means a longer version, not a longer key
+			if modified := aws.ToTime(obj.LastModified); !strings.Contains(strings.TrimPrefix(aws.ToString(obj.Key), prefix), "/") && modified.After(newestTime) {
+				newest, newestTime = aws.ToString(obj.Key), modified
+			}
+		}
+		if !aws.ToBool(page.IsTruncated) {
+			return newest, nil
+		}
+		input.ContinuationToken = page.NextContinuationToken
+	}
+}
+
+func actionsCacheEntry(r *http.Request, scope, version, cacheKey string, head *s3.HeadObjectOutput) ActionsCacheEntry {
+	return ActionsCacheEntry{
+		CacheKey:        cacheKey,
+		Scope:           scope,
+		CacheVersion:    version,
+		CreationTime:    aws.ToTime(head.LastModified).UTC().Format(time.RFC3339),
+		ArchiveLocation: requestBaseURL(r) + "/api/actions-cache/" + url.PathEscape(mux.Vars(r)["token"]) + "/_apis/artifactcache/artifacts/" + head.Metadata["cache-id"],
+	}
+}
+
+// reserveActionsCacheHandler starts saving a cache in the job's writable
+// scope. Caches can't be replaced, and only one job may save a given one at
+// a time.
+func reserveActionsCacheHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	ns := requestNamespace(r)
+	var req ActionsCacheReserveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondInvalidBody(w, err)
+		return
+	}
+	switch {
+	case req.Key == "" || len(req.Key) > 512 || strings.Contains(req.Key, ","):
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error: "key must be 1 to 512 characters, without commas",
+		})
+		return
+	case req.Version == "" || len(req.Version) > 512:
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error: "version must be 1 to 512 characters",
+		})
+		return
+	case req.CacheSize > actionsCacheMaxSize:
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "Cache is too large",
+			Details: fmt.Sprintf("the limit is %d bytes", actionsCacheMaxSize),
+		})
+		return
+	}
+	scope, ok := "", false
+	for _, s := range actionsCacheScopes(r) {
+		if s.Permission&actionsCacheWrite != 0 {
+			scope, ok = s.Scope, true
+			break
+		}
+	}
+	if !ok {
+		respondJSON(w, http.StatusForbidden, ErrorResponse{
+			Error: "This job may not save caches",
+		})
+		return
+	}
+
+	objectKey := actionsCacheObjectKey(ns, scope, req.Version, req.Key)
+	if _, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(ns.Bucket), Key: aws.String(objectKey)}); err == nil {
+		respondJSON(w, http.StatusConflict, ErrorResponse{
+			Error: "Cache already exists",
+		})
+		return
+	} else if !isNotFound(err) {
+		actionsCacheError(w, err)
+		return
+	}
+
+	upload := actionsCacheUpload{
+		ID:        newActionsCacheID(),
+		Key:       objectKey,
+		CacheKey:  req.Key,
+		Version:   req.Version,
+		Scope:     scope,
+		Size:      req.CacheSize,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := reserveActionsCache(ctx, actionsCacheReservationKey(ns, scope, req.Version, req.Key), upload); err != nil {
+		if errors.Is(err, errActionsCacheReserved) {
+			respondJSON(w, http.StatusConflict, ErrorResponse{
+				Error: "Cache is already being saved by another job",
+			})
+			return
+		}
+		actionsCacheError(w, err)
+		return
+	}
+
+	created, err := s3Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(ns.Bucket),
+		Key:         aws.String(objectKey),
+		ContentType: aws.String("application/octet-stream"),
+		Metadata:    map[string]string{"cache-id": strconv.FormatInt(upload.ID, 10)},
+	})
+	if err != nil {
+		actionsCacheError(w, err)
+		return
+	}
+	upload.UploadID = aws.ToString(created.UploadId)
+	// The runner sends the chunks in later requests, maybe to other instances
+	openUploads.remove(upload.UploadID)
+	if err := putActionsCacheRecord(ctx, actionsCacheUploadKey(ns, upload.ID), upload, true, nil); err != nil {
+		actionsCacheError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusCreated, ActionsCacheReserveResponse{CacheID: upload.ID})
+}
+
+var errActionsCacheReserved = errors.New("cache is reserved")
+
+// reserveActionsCache claims the right to save a cache, taking it over from
+// a reservation that has gone stale.
+func reserveActionsCache(ctx context.Context, key string, upload actionsCacheUpload) error {
+	reservation := actionsCacheReservation{ID: upload.ID, CreatedAt: upload.CreatedAt}
+	err := putActionsCacheRecord(ctx, key, reservation, true, nil)
+	if !isPreconditionFailed(err) {
+		return err
+	}
+	var existing actionsCacheReservation
+	etag, err := loadActionsCacheRecord(ctx, key, &existing)
+	if err != nil {
+		return err
+	}
+	if time.Since(existing.CreatedAt) < actionsCacheReservationTTL {
+		return errActionsCacheReserved
+	}
+	err = putActionsCacheRecord(ctx, key, reservation, false, aws.String(etag))
+	if isPreconditionFailed(err) {
+		return errActionsCacheReserved
+	}
+	return err
+}
+
+func newActionsCacheID() int64 {
+	var b [8]byte
+	rand.Read(b[:])
+	// JavaScript numbers stay exact up to 2^53
+	return int64(binary.BigEndian.Uint64(b[:])>>11) + 1
+}
+
+// loadActionsCacheUpload finds the reservation a request names, answering
+// the request itself if it can't.
+func loadActionsCacheUpload(w http.ResponseWriter, r *http.Request, committed bool) (actionsCacheUpload, bool) {
+	upload, ok := findActionsCacheUpload(w, r)
+	if ok && upload.Committed != committed {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{
+			Error: "Cache not found",
+		})
+		return upload, false
+	}
+	return upload, ok
+}
+
+// findActionsCacheUpload is loadActionsCacheUpload for a cache committed or not.
+func findActionsCacheUpload(w http.ResponseWriter, r *http.Request) (actionsCacheUpload, bool) {
+	var upload actionsCacheUpload
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err == nil {
+		_, err = loadActionsCacheRecord(r.Context(), actionsCacheUploadKey(requestNamespace(r), id), &upload)
+	}
+	switch {
+	case err != nil && !isNotFound(err) && !errors.Is(err, strconv.ErrSyntax) && !errors.Is(err, strconv.ErrRange):
+		actionsCacheError(w, err)
+		return upload, false
+	case err != nil:
+		respondJSON(w, http.StatusNotFound, ErrorResponse{
+			Error: "Cache not found",
+		})
+		return upload, false
+	}
+	return upload, true
+}
+
+// uploadActionsCacheHandler stores a chunk of a reserved cache's archive.
+// Chunks may arrive in any order and at once.
+func uploadActionsCacheHandler(w http.ResponseWriter, r *http.Request) {
+	upload, ok := loadActionsCacheUpload(w, r, false)
+	if !ok {
+		return
+	}
+	start, end, err := parseChunkRange(r.Header.Get("Content-Range"))
+	switch {
+	case err != nil:
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid Content-Range",
+			Details: err.Error(),
+		})
+		return
+	case r.ContentLength >= 0 && r.ContentLength != end-start+1:
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid Content-Range",
+			Details: fmt.Sprintf("the range is %d bytes but the body is %d", end-start+1, r.ContentLength),
+		})
+		return
+	case end >= actionsCacheMaxSize:
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "Cache is too large",
+			Details: fmt.Sprintf("the limit is %d bytes", actionsCacheMaxSize),
+		})
+		return
+	}
+
+	ctx := r.Context()
+	ns := requestNamespace(r)
+	number := int32(start/actionsCachePartAlign + 1)
+	defer receivingChunks.done(upload.ID, number)
+	out, err := s3Client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:        aws.String(ns.Bucket),
+		Key:           aws.String(upload.Key),
+		UploadId:      aws.String(upload.UploadID),
+		PartNumber:    aws.Int32(number),
+		Body:          receivingChunks.reader(upload.ID, number, r.Body),
+		ContentLength: aws.Int64(end - start + 1),
+	})
+	if err != nil {
+		actionsCacheError(w, err)
+		return
+	}
+	part := actionsCachePart{Number: number, Start: start, End: end, ETag: aws.ToString(out.ETag)}
+	if err := putActionsCacheRecord(ctx, actionsCachePartsPrefix(ns, upload.ID)+strconv.Itoa(int(number)), part, false, nil); err != nil {
+		actionsCacheError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseChunkRange reads a chunk's "bytes <start>-<end>/*" Content-Range.
+func parseChunkRange(header string) (start, end int64, err error) {
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	spec, _, _ = strings.Cut(spec, "/")
+	first, last, found := strings.Cut(spec, "-")
+	if !ok || !found {
+		return 0, 0, fmt.Errorf("want bytes <start>-<end>/*")
+	}
+	start, err1 := strconv.ParseInt(first, 10, 64)
+	end, err2 := strconv.ParseInt(last, 10, 64)
+	switch {
+	case err1 != nil || err2 != nil || start < 0 || end < start:
+		return 0, 0, fmt.Errorf("want bytes <start>-<end>/*")
+	case start%actionsCachePartAlign != 0:
+		return 0, 0, fmt.Errorf("chunks must start at a multiple of %d bytes", actionsCachePartAlign)
+	}
+	return start, end, nil
+}
+
+// commitActionsCacheHandler assembles the chunks into the archive, which
+// makes the cache available to restore.
+func commitActionsCacheHandler(w http.ResponseWriter, r *http.Request) {
+	upload, ok := loadActionsCacheUpload(w, r, false)
+	if !ok {
+		return
+	}
+	var req ActionsCacheCommitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondInvalidBody(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	ns := requestNamespace(r)
+	parts, err := listActionsCacheParts(ctx, actionsCachePartsPrefix(ns, upload.ID))
+	if err != nil {
+		actionsCacheError(w, err)
+		return
+	}
+	var covered int64
+	completed := make([]types.CompletedPart, len(parts))
+	for i, p := range parts {
+		if p.Start != covered {
+			break
+		}
+		covered = p.End + 1
+		completed[i] = types.CompletedPart{PartNumber: aws.Int32(p.Number), ETag: aws.String(p.ETag)}
+	}
+	if covered != req.Size || req.Size == 0 {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "Cache is incomplete",
+			Details: fmt.Sprintf("the chunks uploaded cover %d contiguous bytes of %d", covered, req.Size),
+		})
+		return
+	}
+
+	if _, err := s3Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(ns.Bucket),
+		Key:             aws.String(upload.Key),
+		UploadId:        aws.String(upload.UploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	}); err != nil {
+		actionsCacheError(w, err)
+		return
+	}
+	upload.Committed, upload.Size = true, req.Size
+	if err := putActionsCacheRecord(ctx, actionsCacheUploadKey(ns, upload.ID), upload, false, nil); err != nil {
+		actionsCacheError(w, err)
+		return
+	}
+
+	cleanup := []types.ObjectIdentifier{{Key: aws.String(actionsCacheReservationKey(ns, upload.Scope, upload.Version, upload.CacheKey))}}
+	for _, p := range parts {
+		cleanup = append(cleanup, types.ObjectIdentifier{Key: aws.String(actionsCachePartsPrefix(ns, upload.ID) + strconv.Itoa(int(p.Number)))})
+	}
+	if _, err := s3Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(ns.Bucket),
+		Delete: &types.Delete{Objects: cleanup, Quiet: aws.Bool(true)},
+	}); err != nil {
+		slog.WarnContext(ctx, "Failed to remove actions cache upload records", "cache_id", upload.ID, "err", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listActionsCacheParts returns a reservation's uploaded chunks in order.
+func listActionsCacheParts(ctx context.Context, prefix string) ([]actionsCachePart, error) {
+	var parts []actionsCachePart
+	input := &s3.ListObjectsV2Input{Bucket: aws.String(bucketFor(ctx)), Prefix: aws.String(prefix)}
+	for {
+		page, err := s3Client.ListObjectsV2(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			var part actionsCachePart
+			if _, err := loadActionsCacheRecord(ctx, aws.ToString(obj.Key), &part); err != nil {
+				return nil, err
+			}
+			parts = append(parts, part)
+		}
+		if !aws.ToBool(page.IsTruncated) {
+			break
+		}
+		input.ContinuationToken = page.NextContinuationToken
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Start < parts[j].Start })
+	return parts, nil
+}
+
+// downloadActionsCacheHandler serves a committed cache's archive, whole or
+// by range.
+func downloadActionsCacheHandler(w http.ResponseWriter, r *http.Request) {
+	upload, ok := loadActionsCacheUpload(w, r, true)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	ns := requestNamespace(r)
+	head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(ns.Bucket), Key: aws.String(upload.Key)})
+	if isNotFound(err) {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{
+			Error: "Cache not found",
+		})
+		return
+	}
+	if err != nil {
+		actionsCacheError(w, err)
+		return
+	}
+	headers := func() { w.Header().Set("Content-Type", "application/octet-stream") }
+	if r.Header.Get("Range") != "" && serveRange(w, r, ns.Bucket, upload.Key, head, headers) {
+		return
+	}
+
+	result, err := s3Client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(ns.Bucket), Key: aws.String(upload.Key), IfMatch: head.ETag})
+	if err != nil {
+		actionsCacheError(w, err)
+		return
+	}
+	defer result.Body.Close()
+	headers()
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("ETag", aws.ToString(head.ETag))
+	w.Header().Set("Content-Length", strconv.FormatInt(aws.ToInt64(result.ContentLength), 10))
+	if _, err := io.Copy(w, result.Body); err != nil {
+		slog.WarnContext(ctx, "Actions cache download interrupted", "cache_id", upload.ID, "err", err)
+	}
+}
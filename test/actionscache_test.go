@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"test-api/internal/fakes3"
+)
+
+const actionsCacheBase = "/api/actions-cache/runner-token/_apis/artifactcache"
+
+// runtimeToken is an unsigned runtime token granting the given scopes, as
+// the runner sends them.
+func runtimeToken(t *testing.T, scopes ...actionsCacheScope) string {
+	t.Helper()
+	ac, err := json.Marshal(scopes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, _ := json.Marshal(map[string]string{"ac": string(ac)})
+	return "Bearer e30." + base64.RawURLEncoding.EncodeToString(payload) + ".sig"
+}
+
+// saveActionsCache saves content under key as the job with the given
+// runtime token, in chunks of whole MiBs, and returns the cache ID.
+func saveActionsCache(t *testing.T, srv *httptest.Server, key string, content []byte, headers ...string) int64 {
+	t.Helper()
+	reserved := call(t, srv, "POST", actionsCacheBase+"/caches", ActionsCacheReserveRequest{Key: key, Version: "v1", CacheSize: int64(len(content))}, headers...)
+	expectStatus(t, reserved, http.StatusCreated)
+	var res ActionsCacheReserveResponse
+	reserved.decode(t, &res)
+
+	path := fmt.Sprintf("%s/caches/%d", actionsCacheBase, res.CacheID)
+	// Sent last chunk first, as parallel uploads may finish
+	for start := (len(content) - 1) / actionsCachePartAlign * actionsCachePartAlign; start >= 0; start -= actionsCachePartAlign {
+		end := min(start+actionsCachePartAlign, len(content))
+		chunk := append([]string{
+			"Content-Type", "application/octet-stream",
+			"Content-Range", fmt.Sprintf("bytes %d-%d/*", start, end-1),
+		}, headers...)
+		expectStatus(t, call(t, srv, "PATCH", path, content[start:end], chunk...), http.StatusNoContent)
+	}
+	expectStatus(t, call(t, srv, "POST", path, ActionsCacheCommitRequest{Size: int64(len(content))}, headers...), http.StatusNoContent)
+	return res.CacheID
+}
+
+func newActionsCacheServer(t *testing.T) (*httptest.Server, *fakes3.Client) {
+	t.Helper()
+	srv, fake := newTestServer(t)
+	override(t, &actionsCacheTokens, map[string]string{"runner-token": defaultTenant})
+	return srv, fake
+}
+
+func TestActionsCache(t *testing.T) {
+	srv, fake := newActionsCacheServer(t)
+	content := bytes.Repeat([]byte("0123456789abcdef"), actionsCachePartAlign/16+100)
+	saveActionsCache(t, srv, "npm-linux-aaa", content)
+
+	// Exact key
+	found := call(t, srv, "GET", actionsCacheBase+"/cache?version=v1&keys=npm-linux-aaa", nil)
+	expectStatus(t, found, http.StatusOK)
+	var entry ActionsCacheEntry
+	found.decode(t, &entry)
+	if entry.CacheKey != "npm-linux-aaa" || entry.CacheVersion != "v1" || !strings.HasPrefix(entry.ArchiveLocation, "http") {
+		t.Fatalf("entry %+v", entry)
+	}
+	location, err := url.Parse(entry.ArchiveLocation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := call(t, srv, "GET", location.Path, nil)
+	expectStatus(t, archive, http.StatusOK)
+	if !bytes.Equal(archive.body, content) {
+		t.Fatalf("archive is %d bytes, want %d", len(archive.body), len(content))
+	}
+	ranged := call(t, srv, "GET", location.Path, nil, "Range", "bytes=16-31")
+	expectStatus(t, ranged, http.StatusPartialContent)
+	if string(ranged.body) != "0123456789abcdef" {
+		t.Fatalf("range %q", ranged.body)
+	}
+
+	// A restore key matches as a prefix; another version doesn't
+	restored := call(t, srv, "GET", actionsCacheBase+"/cache?version=v1&keys=npm-linux-bbb,npm-linux-", nil)
+	expectStatus(t, restored, http.StatusOK)
+	restored.decode(t, &entry)
+	if entry.CacheKey != "npm-linux-aaa" {
+		t.Fatalf("restored %+v", entry)
+	}
+	expectStatus(t, call(t, srv, "GET", actionsCacheBase+"/cache?version=v2&keys=npm-linux-", nil), http.StatusNoContent)
+	expectStatus(t, call(t, srv, "GET", actionsCacheBase+"/cache?version=v1&keys=pip-", nil), http.StatusNoContent)
+
+	// Caches are never replaced
+	expectStatus(t, call(t, srv, "POST", actionsCacheBase+"/caches", ActionsCacheReserveRequest{Key: "npm-linux-aaa", Version: "v1"}), http.StatusConflict)
+
+	// Nor saved twice at once
+	pending := ActionsCacheReserveRequest{Key: "go-linux", Version: "v1"}
+	expectStatus(t, call(t, srv, "POST", actionsCacheBase+"/caches", pending), http.StatusCreated)
+	expectStatus(t, call(t, srv, "POST", actionsCacheBase+"/caches", pending), http.StatusConflict)
+
+	for _, key := range fake.Keys(bucketName) {
+		if !isReservedKey(key) {
+			t.Errorf("cache bookkeeping %s is not reserved", key)
+		}
+	}
+}
+
+func TestActionsCacheIncompleteCommit(t *testing.T) {
+	srv, _ := newActionsCacheServer(t)
+	reserved := call(t, srv, "POST", actionsCacheBase+"/caches", ActionsCacheReserveRequest{Key: "k", Version: "v1"})
+	var res ActionsCacheReserveResponse
+	reserved.decode(t, &res)
+	path := fmt.Sprintf("%s/caches/%d", actionsCacheBase, res.CacheID)
+
+	expectStatus(t, call(t, srv, "PATCH", path, "abc", "Content-Type", "application/octet-stream", "Content-Range", "bytes 5-7/*"), http.StatusBadRequest)
+	expectStatus(t, call(t, srv, "PATCH", path, "abc", "Content-Type", "application/octet-stream", "Content-Range", "bytes 0-2/*"), http.StatusNoContent)
+	expectStatus(t, call(t, srv, "POST", path, ActionsCacheCommitRequest{Size: 10}), http.StatusBadRequest)
+	expectStatus(t, call(t, srv, "GET", actionsCacheBase+"/cache?version=v1&keys=k", nil), http.StatusNoContent)
+	expectStatus(t, call(t, srv, "GET", fmt.Sprintf("%s/artifacts/%d", actionsCacheBase, res.CacheID), nil), http.StatusNotFound)
+	expectStatus(t, call(t, srv, "POST", path, ActionsCacheCommitRequest{Size: 3}), http.StatusNoContent)
+	expectStatus(t, call(t, srv, "GET", actionsCacheBase+"/cache?version=v1&keys=k", nil), http.StatusOK)
+}
+
+func TestActionsCacheScopes(t *testing.T) {
+	srv, _ := newActionsCacheServer(t)
+	main := runtimeToken(t, actionsCacheScope{"refs/heads/main", actionsCacheRead | actionsCacheWrite})
+	feature := runtimeToken(t,
+		actionsCacheScope{"refs/heads/feature", actionsCacheRead | actionsCacheWrite},
+		actionsCacheScope{"refs/heads/main", actionsCacheRead},
+	)
+	fork := runtimeToken(t, actionsCacheScope{"refs/heads/main", actionsCacheRead})
+
+	saveActionsCache(t, srv, "deps-", []byte("from main"), "Authorization", main)
+	saveActionsCache(t, srv, "deps-feature", []byte("from feature"), "Authorization", feature)
+
+	var entry ActionsCacheEntry
+	got := call(t, srv, "GET", actionsCacheBase+"/cache?version=v1&keys=deps-", nil, "Authorization", feature)
+	expectStatus(t, got, http.StatusOK)
+	got.decode(t, &entry)
+	if entry.Scope != "refs/heads/feature" || entry.CacheKey != "deps-feature" {
+		t.Fatalf("feature restored %+v, want its own scope first", entry)
+	}
+	got = call(t, srv, "GET", actionsCacheBase+"/cache?version=v1&keys=deps-feature,deps-", nil, "Authorization", main)
+	expectStatus(t, got, http.StatusOK)
+	got.decode(t, &entry)
+	if entry.Scope != "refs/heads/main" || entry.CacheKey != "deps-" {
+		t.Fatalf("main restored %+v from another branch", entry)
+	}
+
+	expectStatus(t, call(t, srv, "POST", actionsCacheBase+"/caches", ActionsCacheReserveRequest{Key: "x", Version: "v1"}, "Authorization", fork), http.StatusForbidden)
+	// Without a runtime token, jobs share a scope of their own
+	expectStatus(t, call(t, srv, "GET", actionsCacheBase+"/cache?version=v1&keys=deps-", nil), http.StatusNoContent)
+}
+
+func TestActionsCacheTokens(t *testing.T) {
+	srv, _ := newTestServer(t)
+	expectStatus(t, call(t, srv, "GET", actionsCacheBase+"/cache?version=v1&keys=k", nil), http.StatusNotFound)
+
+	override(t, &apiKeys, map[string]string{"acme-key": "acme"})
+	override(t, &actionsCacheTokens, map[string]string{"runner-token": "acme", "other-token": "globex"})
+	saveActionsCache(t, srv, "k", []byte("acme's"))
+	expectStatus(t, call(t, srv, "GET", actionsCacheBase+"/cache?version=v1&keys=k", nil), http.StatusOK)
+	expectStatus(t, call(t, srv, "GET", strings.Replace(actionsCacheBase, "runner-token", "other-token", 1)+"/cache?version=v1&keys=k", nil), http.StatusNoContent)
+	expectStatus(t, call(t, srv, "GET", strings.Replace(actionsCacheBase, "runner-token", "wrong", 1)+"/cache?version=v1&keys=k", nil), http.StatusUnauthorized)
+
+	// The tenant's files don't show the cache
+	var files FilesResponse
+	call(t, srv, "GET", "/api/files", nil, "X-API-Key", "acme-key").decode(t, &files)
+	if len(files.Files) != 0 {
+		t.Fatalf("files %+v", files.Files)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+)
+
+// Admin endpoints are only served when ADMIN_TOKEN is set, and require it as a
+// bearer token.
+var adminToken = settings.Auth.AdminToken
+
+func requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if adminToken == "" {
+			respondJSON(w, http.StatusNotFound, ErrorResponse{
+				Error: "Admin API is disabled",
+			})
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			respondJSON(w, http.StatusUnauthorized, ErrorResponse{
+				Error: "Admin token required",
+			})
+			return
+		}
+
+		setLogPrincipal(r.Context(), "admin")
+		next.ServeHTTP(w, r)
+	})
+}
+
+type ObjectDebugResponse struct {
+	Key         string                `json:"key"`
+	Head        *s3.HeadObjectOutput  `json:"head,omitempty"`
+	HeadError   string                `json:"head_error,omitempty"`
+	Tags        map[string]string     `json:"tags,omitempty"`
+	Policy      ObjectDebugPolicy     `json:"storage_policy"`
+	Trash       *ObjectDebugTrash     `json:"trash,omitempty"`
+	Replication *ObjectDebugReplicate `json:"replication,omitempty"`
+	Events      []FileEvent           `json:"recent_events"`
+}
+
+type ObjectDebugPolicy struct {
+	Compression string `json:"compression,omitempty"`
+	Encrypt     bool   `json:"encrypt"`
+	Reserved    bool   `json:"reserved"`
+}
+
+type ObjectDebugTrash struct {
+	Key       string `json:"key"`
+	DeletedAt string `json:"deleted_at"`
+	PurgeAt   string `json:"purge_at"`
+}
+
+type ObjectDebugReplicate struct {
+	TargetBucket string `json:"target_bucket"`
+	ReplicaETag  string `json:"replica_etag,omitempty"`
+	ReplicaError string `json:"replica_error,omitempty"`
+	InSync       bool   `json:"in_sync"`
+}
+
+// debugObjectHandler gathers everything the service knows about a key into a
+// single response for support investigations. Keys are raw keys in the default
+// bucket, tenant prefix included.
+func debugObjectHandler(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	ns := namespaceOfKey(key)
+	ctx := withNamespace(r.Context(), ns)
+
+	policy := storagePolicyFor(ns.name(key))
+	response := ObjectDebugResponse{
+		Key: key,
+		Policy: ObjectDebugPolicy{
+			Compression: policy.Compression,
+			Encrypt:     policy.Encrypt,
+			Reserved:    isReservedKey(ns.name(key)),
+		},
+		Events: eventsForKey(key),
+	}
+
+	head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		response.HeadError = err.Error()
+	} else {
+		response.Head = head
+		if tags, err := objectTags(ctx, key); err == nil {
+			response.Tags = tags
+		}
+	}
+
+	if trashed, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(trashKey(ctx, key)),
+	}); err == nil {
+		deletedAt := aws.ToTime(trashed.LastModified)
+		response.Trash = &ObjectDebugTrash{
+			Key:       trashKey(ctx, key),
+			DeletedAt: deletedAt.UTC().Format(time.RFC3339),
+			PurgeAt:   deletedAt.Add(trashRetention).UTC().Format(time.RFC3339),
+		}
+	}
+
+	if objectReplicator != nil {
+		replica := &ObjectDebugReplicate{TargetBucket: objectReplicator.bucket}
+		replicaHead, err := objectReplicator.client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(objectReplicator.bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			replica.ReplicaError = err.Error()
+		} else {
+			replica.ReplicaETag = aws.ToString(replicaHead.ETag)
+			replica.InSync = head != nil && aws.ToString(head.ETag) == replica.ReplicaETag
+		}
+		response.Replication = replica
+	}
+
+	respondJSON(w, http.StatusOK, response)
+}
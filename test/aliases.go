@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+)
+
+// An alias is another name for a file, stored once. Each alias is a record
+// under aliasNamesPrefix naming its target, and each target has an empty
+// marker per alias under aliasTargetsPrefix, so the names still using a
+// file can be found with one listing.
+const (
+	aliasPrefix        = ".aliases/"
+	aliasNamesPrefix   = aliasPrefix + "names/"
+	aliasTargetsPrefix = aliasPrefix + "targets/"
+
+	// aliasHeader tells clients which file an alias served
+	aliasHeader = "X-Alias-Target"
+)
+
+type Alias struct {
+	Alias     string `json:"alias"`
+	Target    string `json:"target"`
+	CreatedAt string `json:"created_at"`
+	CreatedBy string `json:"created_by"`
+
+	// etag is the record's, for conditional updates
+	etag string
+}
+
+type AliasRequest struct {
+	Target string `json:"target"`
+}
+
+type AliasesResponse struct {
+	Aliases []Alias `json:"aliases"`
+}
+
+// aliasMarkers is the prefix of target's markers. Names may contain
+// slashes, so the target is encoded to keep "a" from listing the markers
+// of "a/b".
+func aliasMarkers(ns namespace, target string) string {
+	return ns.key(aliasTargetsPrefix + base64.RawURLEncoding.EncodeToString([]byte(target)) + "/")
+}
+
+// readAlias returns the alias named name, if there is one.
+func readAlias(ctx context.Context, ns namespace, name string) (Alias, bool, error) {
+	var alias Alias
+	result, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(ns.Bucket),
+		Key:    aws.String(ns.key(aliasNamesPrefix + name)),
+	})
+	if isNotFound(err) {
+		return alias, false, nil
+	}
+	if err != nil {
+		return alias, false, err
+	}
+	defer result.Body.Close()
+	if err := json.NewDecoder(result.Body).Decode(&alias); err != nil {
+		return alias, false, fmt.Errorf("decoding alias %s: %w", name, err)
+	}
+	alias.etag = aws.ToString(result.ETag)
+	return alias, true, nil
+}
+
+// aliasesOf lists the names of target's aliases.
+func aliasesOf(ctx context.Context, ns namespace, target string) ([]string, error) {
+	prefix := aliasMarkers(ns, target)
+	keys, err := listPrefix(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(keys))
+	for i, key := range keys {
+		names[i] = strings.TrimPrefix(key, prefix)
+	}
+	return names, nil
+}
+
+// serveAlias serves r as if it were for the target of the alias in its
+// filename, for handlers that found no file of that name. It reports
+// whether there was such an alias; if not, the handler reports the 404.
+func serveAlias(w http.ResponseWriter, r *http.Request, handler http.HandlerFunc) bool {
+	// Targets are never aliases, but a dangling one must not loop
+	if w.Header().Get(aliasHeader) != "" {
+		return false
+	}
+	vars := mux.Vars(r)
+	alias, ok, err := readAlias(r.Context(), requestNamespace(r), vars["filename"])
+	if err != nil {
+		slog.WarnContext(r.Context(), "Failed to read alias", "alias", vars["filename"], "err", err)
+	}
+	if !ok {
+		return false
+	}
+
+	targetVars := make(map[string]string, len(vars))
+	for name, value := range vars {
+		targetVars[name] = value
+	}
+	targetVars["filename"] = alias.Target
+	w.Header().Set(aliasHeader, alias.Target)
+	handler(w, mux.SetURLVars(r, targetVars))
+	return true
+}
+
+// lookupAliasName validates the alias in the route.
+func lookupAliasName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name, err := sanitizeName(mux.Vars(r)["alias"])
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid alias",
+			Details: err.Error(),
+		})
+		return "", false
+	}
+	return name, true
+}
+
+func respondAliasStorageError(w http.ResponseWriter, err error) {
+	respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+		Error:   "Failed to update aliases",
+		Details: err.Error(),
+	})
+}
+
+// putAliasHandler points an alias at a file, or repoints an existing one.
+func putAliasHandler(w http.ResponseWriter, r *http.Request) {
+	name, ok := lookupAliasName(w, r)
+	if !ok {
+		return
+	}
+	var req AliasRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondInvalidBody(w, err)
+		return
+	}
+	target, err := sanitizeName(req.Target)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid target",
+			Details: err.Error(),
+		})
+		return
+	}
+	writeAlias(w, r, name, target)
+}
+
+// writeAlias points name at target and answers with the alias. Pointing at
+// an alias points at its target, so aliases never chain. If-Match with the
+// alias's ETag repoints it only if no one else has since, and
+// If-None-Match: * only creates it.
+func writeAlias(w http.ResponseWriter, r *http.Request, name, target string) {
+	ctx := r.Context()
+	ns := requestNamespace(r)
+	if other, ok, err := readAlias(ctx, ns, target); err != nil {
+		respondAliasStorageError(w, err)
+		return
+	} else if ok {
+		target = other.Target
+	}
+	if target == name {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error: "An alias cannot point at itself",
+		})
+		return
+	}
+	if !authorizeFile(w, r, ns.key(target), permRead) || !authorizeFile(w, r, ns.key(name), permWrite) {
+		return
+	}
+
+	for _, check := range []struct {
+		key    string
+		want   bool
+		status int
+		error  string
+	}{
+		{ns.key(target), true, http.StatusNotFound, "Target not found"},
+		{ns.key(name), false, http.StatusConflict, "A file already has this name"},
+	} {
+		exists, err := objectExists(ctx, check.key)
+		if err != nil {
+			respondAliasStorageError(w, err)
+			return
+		}
+		if exists != check.want {
+			respondJSON(w, check.status, ErrorResponse{
+				Error:   check.error,
+				Details: ns.name(check.key),
+			})
+			return
+		}
+	}
+
+	previous, existed, err := readAlias(ctx, ns, name)
+	if err != nil {
+		respondAliasStorageError(w, err)
+		return
+	}
+	record := &s3.PutObjectInput{
+		Bucket:      aws.String(ns.Bucket),
+		Key:         aws.String(ns.key(aliasNamesPrefix + name)),
+		ContentType: aws.String("application/json"),
+	}
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		etag, err := checkIfMatch(ctx, ns.Bucket, aws.ToString(record.Key), ifMatch)
+		if err != nil {
+			respondPreconditionFailed(w, etag, err)
+			return
+		}
+		record.IfMatch = aws.String(etag)
+	} else if r.Header.Get("If-None-Match") == "*" {
+		if existed {
+			respondPreconditionFailed(w, previous.etag, errPreconditionFailed)
+			return
+		}
+		record.IfNoneMatch = aws.String("*")
+	}
+
+	alias := Alias{
+		Alias:     name,
+		Target:    target,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		CreatedBy: requestSubject(r),
+	}
+	body, _ := json.Marshal(alias)
+	record.Body = bytes.NewReader(body)
+
+	// The marker goes first: a marker without its record only makes a
+	// delete refuse until the alias is set again or removed
+	if _, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(ns.Bucket),
+		Key:    aws.String(aliasMarkers(ns, target) + name),
+		Body:   bytes.NewReader(nil),
+	}); err != nil {
+		respondAliasStorageError(w, err)
+		return
+	}
+	written, err := s3Client.PutObject(ctx, record)
+	if err != nil {
+		// Whoever changed the alias in between keeps their marker
+		if current, ok, _ := readAlias(ctx, ns, name); !ok || current.Target != target {
+			removeAliasMarker(ctx, ns, alias)
+		}
+		if isPreconditionFailed(err) {
+			respondPreconditionFailed(w, "", err)
+			return
+		}
+		respondAliasStorageError(w, err)
+		return
+	}
+	if existed && previous.Target != target {
+		removeAliasMarker(ctx, ns, previous)
+	}
+
+	status := http.StatusCreated
+	if existed {
+		status = http.StatusOK
+	}
+	w.Header().Set("ETag", aws.ToString(written.ETag))
+	respondJSON(w, status, alias)
+}
+
+func removeAliasMarker(ctx context.Context, ns namespace, alias Alias) {
+	if _, err := s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(ns.Bucket),
+		Key:    aws.String(aliasMarkers(ns, alias.Target) + alias.Alias),
+	}); err != nil {
+		slog.WarnContext(ctx, "Failed to remove alias marker", "alias", alias.Alias, "target", alias.Target, "err", err)
+	}
+}
+
+func getAliasHandler(w http.ResponseWriter, r *http.Request) {
+	name, ok := lookupAliasName(w, r)
+	if !ok {
+		return
+	}
+	ns := requestNamespace(r)
+	alias, ok, err := readAlias(r.Context(), ns, name)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to read alias",
+			Details: err.Error(),
+		})
+		return
+	}
+	if !ok {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{
+			Error: "Alias not found",
+		})
+		return
+	}
+	if !authorizeFile(w, r, ns.key(alias.Target), permRead) {
+		return
+	}
+	w.Header().Set("ETag", alias.etag)
+	respondJSON(w, http.StatusOK, alias)
+}
+
+// listAliasesHandler lists aliases of files the caller can read, all of
+// them or, with ?target=, one file's.
+func listAliasesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	ns := requestNamespace(r)
+
+	var names []string
+	var err error
+	if target := r.URL.Query().Get("target"); target != "" {
+		names, err = aliasesOf(ctx, ns, target)
+	} else {
+		var keys []string
+		keys, err = listPrefix(ctx, ns.key(aliasNamesPrefix))
+		for _, key := range keys {
+			names = append(names, strings.TrimPrefix(ns.name(key), aliasNamesPrefix))
+		}
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to list aliases",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	response := AliasesResponse{Aliases: []Alias{}}
+	for _, name := range names {
+		alias, ok, err := readAlias(ctx, ns, name)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+				Error:   "Failed to list aliases",
+				Details: err.Error(),
+			})
+			return
+		}
+		if !ok {
+			continue
+		}
+		if _, err := checkAccess(ctx, requestSubject(r), ns.key(alias.Target), permRead); err != nil {
+			continue
+		}
+		response.Aliases = append(response.Aliases, alias)
+	}
+	respondJSON(w, http.StatusOK, response)
+}
+
+// deleteAliasHandler removes an alias. Its target is left alone.
+func deleteAliasHandler(w http.ResponseWriter, r *http.Request) {
+	name, ok := lookupAliasName(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	ns := requestNamespace(r)
+	if !authorizeFile(w, r, ns.key(name), permWrite) {
+		return
+	}
+	alias, ok, err := readAlias(ctx, ns, name)
+	if err != nil {
+		respondAliasStorageError(w, err)
+		return
+	}
+	if !ok {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{
+			Error: "Alias not found",
+		})
+		return
+	}
+
+	if _, err := s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(ns.Bucket),
+		Key:    aws.String(ns.key(aliasNamesPrefix + name)),
+	}); err != nil {
+		respondAliasStorageError(w, err)
+		return
+	}
+	removeAliasMarker(ctx, ns, alias)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// refuseAliasedDelete answers 409 and returns false if filename still has
+// aliases, which would otherwise be left pointing at nothing.
+func refuseAliasedDelete(w http.ResponseWriter, r *http.Request, filename string) bool {
+	aliases, err := aliasesOf(r.Context(), requestNamespace(r), filename)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to check aliases",
+			Details: err.Error(),
+		})
+		return false
+	}
+	if len(aliases) > 0 {
+		respondJSON(w, http.StatusConflict, ErrorResponse{
+			Error:   "File has aliases",
+			Details: "remove its aliases first: " + strings.Join(aliases, ", "),
+		})
+		return false
+	}
+	return true
+}
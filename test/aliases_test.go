@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAliases(t *testing.T) {
+	srv, fake := newTestServer(t)
+	mustUpload(t, srv, "/api", "releases/app-1.4.0.tar.gz", "build")
+
+	var alias Alias
+	got := call(t, srv, "PUT", "/api/aliases/latest/app.tar.gz", AliasRequest{Target: "releases/app-1.4.0.tar.gz"})
+	expectStatus(t, got, http.StatusCreated)
+	got.decode(t, &alias)
+	if alias.Alias != "latest/app.tar.gz" || alias.Target != "releases/app-1.4.0.tar.gz" || alias.CreatedBy == "" {
+		t.Fatalf("alias %+v", alias)
+	}
+	if _, _, ok := fake.Object(bucketName, "latest/app.tar.gz"); ok {
+		t.Fatal("the alias copied the file")
+	}
+
+	// Downloads and metadata follow the alias
+	download := call(t, srv, "GET", "/api/files/latest/app.tar.gz", nil)
+	expectStatus(t, download, http.StatusOK)
+	if string(download.body) != "build" || download.Header.Get(aliasHeader) != "releases/app-1.4.0.tar.gz" {
+		t.Errorf("download through alias: %q, target %q", download.body, download.Header.Get(aliasHeader))
+	}
+	var metadata FileMetadata
+	got = call(t, srv, "GET", "/api/files/latest/app.tar.gz/metadata", nil)
+	expectStatus(t, got, http.StatusOK)
+	got.decode(t, &metadata)
+	if metadata.Filename != "releases/app-1.4.0.tar.gz" {
+		t.Errorf("metadata through alias: %+v", metadata)
+	}
+
+	// An alias of an alias points at the file
+	got = call(t, srv, "PUT", "/api/aliases/app.tar.gz", AliasRequest{Target: "latest/app.tar.gz"})
+	expectStatus(t, got, http.StatusCreated)
+	got.decode(t, &alias)
+	if alias.Target != "releases/app-1.4.0.tar.gz" {
+		t.Errorf("chained alias points at %q", alias.Target)
+	}
+
+	var list AliasesResponse
+	call(t, srv, "GET", "/api/aliases?target=releases/app-1.4.0.tar.gz", nil).decode(t, &list)
+	if len(list.Aliases) != 2 {
+		t.Errorf("aliases of the file: %+v", list.Aliases)
+	}
+
+	// The file can't be deleted out from under its aliases
+	expectStatus(t, call(t, srv, "DELETE", "/api/files/releases/app-1.4.0.tar.gz", nil), http.StatusConflict)
+
+	// Repointing moves the alias to the new file
+	mustUpload(t, srv, "/api", "releases/app-1.5.0.tar.gz", "newer")
+	expectStatus(t, call(t, srv, "PUT", "/api/aliases/latest/app.tar.gz", AliasRequest{Target: "releases/app-1.5.0.tar.gz"}), http.StatusOK)
+	if got := call(t, srv, "GET", "/api/files/latest/app.tar.gz", nil); string(got.body) != "newer" {
+		t.Errorf("repointed alias served %q", got.body)
+	}
+	call(t, srv, "GET", "/api/aliases?target=releases/app-1.4.0.tar.gz", nil).decode(t, &list)
+	if len(list.Aliases) != 1 || list.Aliases[0].Alias != "app.tar.gz" {
+		t.Errorf("aliases of the old file after repointing: %+v", list.Aliases)
+	}
+	call(t, srv, "GET", "/api/aliases", nil).decode(t, &list)
+	if len(list.Aliases) != 2 {
+		t.Errorf("all aliases: %+v", list.Aliases)
+	}
+
+	expectStatus(t, call(t, srv, "DELETE", "/api/aliases/app.tar.gz", nil), http.StatusNoContent)
+	expectStatus(t, call(t, srv, "GET", "/api/aliases/app.tar.gz", nil), http.StatusNotFound)
+	expectStatus(t, call(t, srv, "GET", "/api/files/app.tar.gz", nil), http.StatusNotFound)
+	expectStatus(t, call(t, srv, "DELETE", "/api/files/releases/app-1.4.0.tar.gz", nil), http.StatusOK)
+	if got := call(t, srv, "GET", "/api/files/latest/app.tar.gz", nil); string(got.body) != "newer" {
+		t.Errorf("the remaining alias served %q", got.body)
+	}
+}
+
+func TestAliasErrors(t *testing.T) {
+	srv, _ := newTestServer(t)
+	mustUpload(t, srv, "/api", "a.txt", "a")
+	mustUpload(t, srv, "/api", "b.txt", "b")
+
+	for _, tc := range []struct {
+		name   string
+		alias  string
+		target string
+		status int
+	}{
+		{"missing target", "c.txt", "nope.txt", http.StatusNotFound},
+		{"name of a file", "b.txt", "a.txt", http.StatusConflict},
+		{"itself", "a.txt", "a.txt", http.StatusBadRequest},
+		{"reserved target", "c.txt", ".aliases/names/x", http.StatusBadRequest},
+	} {
+		if got := call(t, srv, "PUT", "/api/aliases/"+tc.alias, AliasRequest{Target: tc.target}); got.StatusCode != tc.status {
+			t.Errorf("%s: status %d, want %d: %s", tc.name, got.StatusCode, tc.status, got.body)
+		}
+	}
+	expectStatus(t, call(t, srv, "DELETE", "/api/aliases/c.txt", nil), http.StatusNotFound)
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Uploads can be scanned for viruses by clamd before they are stored.
+// CLAMAV_ADDRESS is its TCP or unix socket, e.g. "tcp://clamav:3310" or
+// "unix:///run/clamav/clamd.ctl"; a bare host:port or path works too. An
+// infected upload is refused, and with CLAMAV_ACTION=quarantine also kept
+// under .quarantine/ for someone to look at.
+var (
+	clamavAddress = os.Getenv("CLAMAV_ADDRESS")
+	clamavTimeout = durationFromEnv("CLAMAV_TIMEOUT", 30*time.Second)
+	clamavAction  = envOr("CLAMAV_ACTION", scanActionReject)
+
+	uploadsScanned = newCounterVec("uploads_scanned_total", "Uploads scanned for viruses, by result: clean, infected or failed.", "result")
+)
+
+const (
+	scanActionReject     = "reject"
+	scanActionQuarantine = "quarantine"
+
+	quarantinePrefix = ".quarantine/"
+)
+
+// Object metadata with the result of the scan. Files stored before
+// scanning was turned on have none, and are reported as unscanned.
+const (
+	metaScanStatus    = "scan-status"
+	metaScanSignature = "scan-signature"
+	metaUploadedBy    = "uploaded-by"
+	metaOriginalKey   = "original-key"
+
+	scanClean     = "clean"
+	scanInfected  = "infected"
+	scanUnscanned = "unscanned"
+)
+
+// clamd reads a stream in chunks of at most this size.
+const clamdChunkSize = 64 << 10
+
+func antivirusEnabled() bool { return clamavAddress != "" }
+
+// checkAntivirusSettings reports a problem with the CLAMAV_ settings.
+func checkAntivirusSettings() error {
+	if !antivirusEnabled() {
+		return nil
+	}
+	if clamavAction != scanActionReject && clamavAction != scanActionQuarantine {
+		return fmt.Errorf("CLAMAV_ACTION must be %s or %s, not %q", scanActionReject, scanActionQuarantine, clamavAction)
+	}
+	_, _, err := clamdNetwork(clamavAddress)
+	return err
+}
+
+// clamdNetwork splits CLAMAV_ADDRESS into what net.Dial takes.
+func clamdNetwork(address string) (network, addr string, err error) {
+	switch {
+	case strings.HasPrefix(address, "tcp://"):
+		return "tcp", strings.TrimPrefix(address, "tcp://"), nil
+	case strings.HasPrefix(address, "unix://"):
+		return "unix", strings.TrimPrefix(address, "unix://"), nil
+	case strings.HasPrefix(address, "/"):
+		return "unix", address, nil
+	case strings.Contains(address, ":") && !strings.Contains(address, "://"):
+		return "tcp", address, nil
+	}
+	return "", "", fmt.Errorf("CLAMAV_ADDRESS must be tcp://host:port or unix:///path, not %q", address)
+}
+
+// clamdCommand sends one command to clamd, with the content streamed after
+// it when there is any, and returns its reply.
+func clamdCommand(ctx context.Context, command string, content []byte) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, clamavTimeout)
+	defer cancel()
+	network, addr, err := clamdNetwork(clamavAddress)
+	if err != nil {
+		return "", err
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return "", fmt.Errorf("connecting to clamd: %w", err)
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	// The z prefix has replies end in a NUL rather than a newline
+	if _, err := io.WriteString(conn, "z"+command+"\x00"); err != nil {
+		return "", err
+	}
+	if command == "INSTREAM" {
+		// Each chunk is preceded by its length; an empty one ends the stream
+		size := make([]byte, 4)
+		for {
+			n := min(len(content), clamdChunkSize)
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(append(size, content[:n]...)); err != nil {
+				return "", fmt.Errorf("sending to clamd: %w", err)
+			}
+			if n == 0 {
+				break
+			}
+			content = content[n:]
+		}
+	}
+	reply, err := io.ReadAll(io.LimitReader(conn, 4096))
+	if err != nil && len(reply) == 0 {
+		return "", fmt.Errorf("reading from clamd: %w", err)
+	}
+	return strings.TrimSpace(string(bytes.TrimRight(reply, "\x00"))), nil
+}
+
+// scanContent returns the name of the virus clamd finds in content, or ""
+// when it is clean.
+func scanContent(ctx context.Context, content []byte) (string, error) {
+	reply, err := clamdCommand(ctx, "INSTREAM", content)
+	if err != nil {
+		return "", err
+	}
+	// e.g. "stream: OK" or "stream: Eicar-Test-Signature FOUND"
+	result := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case result == "OK":
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return strings.TrimSuffix(result, " FOUND"), nil
+	}
+	return "", fmt.Errorf("clamd: %s", reply)
+}
+
+// scanUpload scans content before it is stored as input. A clean upload is
+// marked as such in input's metadata. An infected one is quarantined if
+// CLAMAV_ACTION says so, and refused with errRejected. Uploads held for
+// review are scanned then instead, if clamav is one of the checks.
+func scanUpload(ctx context.Context, subject string, input *s3.PutObjectInput, content []byte) error {
+	if !antivirusEnabled() || reviewDefers(reviewCheckClamAV) {
+		return nil
+	}
+	signature, err := scanContent(ctx, content)
+	if err != nil {
+		uploadsScanned.add(1, "failed")
+		return fmt.Errorf("scanning for viruses: %w", err)
+	}
+	if signature == "" {
+		uploadsScanned.add(1, scanClean)
+		if input.Metadata == nil {
+			input.Metadata = map[string]string{}
+		}
+		input.Metadata[metaScanStatus] = scanClean
+		return nil
+	}
+
+	uploadsScanned.add(1, scanInfected)
+	ns := namespaceFrom(ctx)
+	name := ns.name(aws.ToString(input.Key))
+	log := slog.With("key", name, "signature", signature)
+	if clamavAction == scanActionQuarantine {
+		key, err := quarantineUpload(ctx, subject, name, signature, content)
+		if err != nil {
+			// The upload is refused all the same
+			log.ErrorContext(ctx, "Failed to quarantine infected upload", "err", err)
+		} else {
+			log = log.With("quarantined_as", ns.name(key))
+		}
+	}
+	log.WarnContext(ctx, "Refused infected upload")
+	return errRejected{"clamav", []ValidationError{{Message: "infected with " + signature}}}
+}
+
+// quarantineUpload keeps an infected upload where only the bucket's owner
+// can get at it, with what it was uploaded as and by whom.
+func quarantineUpload(ctx context.Context, subject, name, signature string, content []byte) (string, error) {
+	ns := namespaceFrom(ctx)
+	// Each gets its own folder, so one upload can't replace another's copy
+	key := ns.key(quarantinePrefix + newJobID() + "/" + name)
+	_, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(ns.Bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(content),
+		Metadata: map[string]string{
+			metaScanStatus:    scanInfected,
+			metaScanSignature: signature,
+			metaOriginalKey:   name,
+			metaUploadedBy:    subject,
+		},
+	})
+	return key, err
+}
+
+// scanStatus is a stored file's scan status, from its metadata.
+func scanStatus(metadata map[string]string) string {
+	if status := metadata[metaScanStatus]; status != "" {
+		return status
+	}
+	return scanUnscanned
+}
+
+// scanStatuses looks up the scan status of each of names.
+func scanStatuses(ctx context.Context, ns namespace, names []string) (map[string]string, error) {
+	keys := make([]string, len(names))
+	for i, name := range names {
+		keys[i] = ns.key(name)
+	}
+	heads, err := headObjects(ctx, ns.Bucket, keys)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make(map[string]string, len(names))
+	for i, name := range names {
+		statuses[name] = scanStatus(heads[i].Metadata)
+	}
+	return statuses, nil
+}
+
+// checkAntivirus pings clamd.
+func checkAntivirus(ctx context.Context) error {
+	reply, err := clamdCommand(ctx, "PING", nil)
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("clamd answered %q", reply)
+	}
+	return nil
+}
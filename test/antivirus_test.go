@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// eicar stands in for the EICAR test file, which fakeClamd reports as a
+// virus.
+const eicar = "X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*"
+
+// fakeClamd answers clamd's PING and INSTREAM commands on listener, and
+// returns how many streams it has scanned.
+func fakeClamd(t *testing.T, listener net.Listener) *atomic.Int32 {
+	t.Helper()
+	t.Cleanup(func() { listener.Close() })
+	scanned := new(atomic.Int32)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			r := bufio.NewReader(conn)
+			command, _ := r.ReadString(0)
+			switch command {
+			case "zPING\x00":
+				io.WriteString(conn, "PONG\x00")
+			case "zINSTREAM\x00":
+				var stream bytes.Buffer
+				for {
+					var size uint32
+					if binary.Read(r, binary.BigEndian, &size) != nil || size == 0 {
+						break
+					}
+					io.CopyN(&stream, r, int64(size))
+				}
+				scanned.Add(1)
+				if strings.Contains(stream.String(), "EICAR-STANDARD-ANTIVIRUS-TEST-FILE") {
+					io.WriteString(conn, "stream: Eicar-Test-Signature FOUND\x00")
+				} else {
+					io.WriteString(conn, "stream: OK\x00")
+				}
+			}
+			conn.Close()
+		}
+	}()
+	return scanned
+}
+
+func TestAntivirusScanning(t *testing.T) {
+	srv, fake := newTestServer(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanned := fakeClamd(t, listener)
+	override(t, &clamavAddress, "tcp://"+listener.Addr().String())
+	override(t, &clamavAction, scanActionReject)
+
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("notes.txt", "hello")), http.StatusOK)
+	if _, meta, _ := fake.Object(bucketName, "notes.txt"); meta[metaScanStatus] != scanClean {
+		t.Errorf("clean upload stored with %v", meta)
+	}
+	resp := call(t, srv, "POST", "/api/upload", upload("invoice.pdf", eicar))
+	expectStatus(t, resp, http.StatusUnprocessableEntity)
+	if msg := resp.errorMessage(t); msg != "File is infected" {
+		t.Errorf("infected upload answered %q", msg)
+	}
+	if _, _, ok := fake.Object(bucketName, "invoice.pdf"); ok {
+		t.Error("infected upload was stored")
+	}
+	if n := scanned.Load(); n != 2 {
+		t.Errorf("%d uploads scanned", n)
+	}
+
+	// Files from before scanning was on have no status
+	fake.PutObject(context.Background(), &s3.PutObjectInput{Bucket: aws.String(bucketName), Key: aws.String("old.txt"), Body: strings.NewReader("hello")})
+	var list FilesResponse
+	call(t, srv, "GET", "/api/files?scan_status=true", nil).decode(t, &list)
+	if list.ScanStatus["notes.txt"] != scanClean || list.ScanStatus["old.txt"] != scanUnscanned {
+		t.Errorf("listed scan status %v", list.ScanStatus)
+	}
+	var metadata FileMetadata
+	call(t, srv, "GET", "/api/files/notes.txt/metadata", nil).decode(t, &metadata)
+	if metadata.ScanStatus != scanClean {
+		t.Errorf("metadata scan status %q", metadata.ScanStatus)
+	}
+	if err := checkAntivirus(context.Background()); err != nil {
+		t.Errorf("health: %v", err)
+	}
+
+	// Nothing is stored unscanned while clamd is down
+	listener.Close()
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("later.txt", "hello")), http.StatusServiceUnavailable)
+	if err := checkAntivirus(context.Background()); err == nil {
+		t.Error("health passed with clamd down")
+	}
+}
+
+func TestAntivirusQuarantine(t *testing.T) {
+	srv, fake := newTestServer(t)
+	override(t, &apiKeys, map[string]string{"acme-key": "acme"})
+	socket := filepath.Join(t.TempDir(), "clamd.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fakeClamd(t, listener)
+	override(t, &clamavAddress, socket)
+	override(t, &clamavAction, scanActionQuarantine)
+
+	// Uploads through storeFile are scanned too
+	override(t, &webdavEnabled, true)
+	if resp := call(t, srv, "PUT", "/api/dav/invoice.pdf", eicar, "X-API-Key", "acme-key"); resp.StatusCode == http.StatusCreated {
+		t.Error("infected WebDAV upload succeeded")
+	}
+	var quarantined []string
+	for _, key := range fake.Keys(bucketName) {
+		if strings.HasPrefix(key, "tenants/acme/"+quarantinePrefix) {
+			quarantined = append(quarantined, key)
+		}
+	}
+	if len(quarantined) != 1 || !strings.HasSuffix(quarantined[0], "/invoice.pdf") {
+		t.Fatalf("quarantined %v", quarantined)
+	}
+	body, meta, _ := fake.Object(bucketName, quarantined[0])
+	if string(body) != eicar || meta[metaScanStatus] != scanInfected || meta[metaScanSignature] != "Eicar-Test-Signature" || meta[metaUploadedBy] != "acme" {
+		t.Errorf("quarantined with %v", meta)
+	}
+	if _, _, ok := fake.Object(bucketName, "tenants/acme/invoice.pdf"); ok {
+		t.Error("infected upload was stored")
+	}
+	var list FilesResponse
+	call(t, srv, "GET", "/api/files", nil, "X-API-Key", "acme-key").decode(t, &list)
+	if len(list.Files) != 0 {
+		t.Errorf("quarantine listed as %v", list.Files)
+	}
+}
+
+func TestAntivirusSettings(t *testing.T) {
+	for address, ok := range map[string]bool{
+		"tcp://clamav:3310":            true,
+		"clamav:3310":                  true,
+		"unix:///run/clamav/clamd.ctl": true,
+		"/run/clamav/clamd.ctl":        true,
+		"http://clamav:3310":           false,
+		"clamav":                       false,
+	} {
+		override(t, &clamavAddress, address)
+		if err := checkAntivirusSettings(); (err == nil) != ok {
+			t.Errorf("%s: %v", address, err)
+		}
+	}
+	override(t, &clamavAddress, "clamav:3310")
+	override(t, &clamavAction, "delete")
+	if err := checkAntivirusSettings(); err == nil {
+		t.Error("accepted an unknown action")
+	}
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestUploadListGetDelete(t *testing.T) {
+	srv, fake := newTestServer(t)
+
+	key := mustUpload(t, srv, "/api", "reports/q1.txt", "hello world")
+	if key != "reports/q1.txt" {
+		t.Fatalf("stored as %q", key)
+	}
+
+	var files FilesResponse
+	call(t, srv, "GET", "/api/files", nil).decode(t, &files)
+	if !reflect.DeepEqual(files.Files, []string{"reports/q1.txt"}) {
+		t.Fatalf("listed %v", files.Files)
+	}
+
+	got := call(t, srv, "GET", "/api/files/reports/q1.txt", nil)
+	expectStatus(t, got, http.StatusOK)
+	if string(got.body) != "hello world" {
+		t.Fatalf("downloaded %q", got.body)
+	}
+	if cd := got.Header.Get("Content-Disposition"); cd != "attachment; filename=q1.txt" {
+		t.Fatalf("Content-Disposition %q", cd)
+	}
+
+	stored, metadata, _ := fake.Object(bucketName, "reports/q1.txt")
+	if string(stored) != "hello world" || metadata[metaContentHash] != contentHash([]byte("hello world")) {
+		t.Fatalf("stored %q with metadata %v", stored, metadata)
+	}
+
+	expectStatus(t, call(t, srv, "DELETE", "/api/files/reports/q1.txt", nil), http.StatusOK)
+	expectStatus(t, call(t, srv, "GET", "/api/files/reports/q1.txt", nil), http.StatusNotFound)
+
+	files = FilesResponse{}
+	call(t, srv, "GET", "/api/files", nil).decode(t, &files)
+	if len(files.Files) != 0 {
+		t.Fatalf("listed %v after delete", files.Files)
+	}
+}
+
+func TestListingFormats(t *testing.T) {
+	srv, _ := newTestServer(t)
+	mustUpload(t, srv, "/api", "docs/a <b>.txt", "hello")
+
+	resp := call(t, srv, "GET", "/api/files", nil, "Accept", "text/html;q=0.5, application/xml")
+	expectStatus(t, resp, http.StatusOK)
+	var listing ListBucketResult
+	if err := xml.Unmarshal(resp.body, &listing); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get("Content-Type") != "application/xml" || listing.KeyCount != 1 ||
+		listing.Contents[0].Key != "docs/a <b>.txt" || listing.Contents[0].Size != 5 {
+		t.Fatalf("listing %+v", listing)
+	}
+
+	resp = call(t, srv, "GET", "/api/files", nil, "Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+	expectStatus(t, resp, http.StatusOK)
+	if !bytes.Contains(resp.body, []byte(`<a href="/api/files/docs/a%20%3Cb%3E.txt">docs/a &lt;b&gt;.txt</a>`)) {
+		t.Fatalf("index %s", resp.body)
+	}
+
+	// Anything else gets JSON
+	var files FilesResponse
+	resp = call(t, srv, "GET", "/api/files", nil, "Accept", "text/csv")
+	resp.decode(t, &files)
+	if len(files.Files) != 1 || resp.Header.Get("Vary") != "Accept" {
+		t.Fatalf("listed %v", files.Files)
+	}
+}
+
+func TestErrorMapping(t *testing.T) {
+	srv, _ := newTestServer(t)
+	mustUpload(t, srv, "/api", "notes.txt", "plain text")
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+		status int
+		error  string
+	}{
+		{"invalid json", "POST", "/api/upload", "{", http.StatusBadRequest, "Invalid JSON"},
+		{"missing content", "POST", "/api/upload", UploadRequest{Filename: "a.txt"}, http.StatusBadRequest, "Missing filename or content"},
+		{"bad base64", "POST", "/api/upload", UploadRequest{Filename: "a.txt", Content: "%%%"}, http.StatusBadRequest, "Invalid base64 content"},
+		{"bad conflict strategy", "POST", "/api/upload?on_conflict=clobber", upload("a.txt", "x"), http.StatusBadRequest, "Invalid on_conflict strategy"},
+		{"bad expiry", "POST", "/api/upload", UploadRequest{Filename: "a.txt", Content: "eA==", ExpiresIn: "soon"}, http.StatusBadRequest, "Invalid expiry"},
+		{"reserved tag", "POST", "/api/upload", UploadRequest{Filename: "a.txt", Content: "eA==", Tags: map[string]string{"expires-at": "never"}}, http.StatusBadRequest, "Invalid tags"},
+		{"bad tag value", "POST", "/api/upload", UploadRequest{Filename: "a.txt", Content: "eA==", Tags: map[string]string{"branch": "feature/<script>"}}, http.StatusBadRequest, "Invalid tags"},
+		{"missing file", "GET", "/api/files/nope.txt", nil, http.StatusNotFound, "File not found"},
+		{"missing metadata", "GET", "/api/files/nope.txt/metadata", nil, http.StatusNotFound, "File not found"},
+		{"render non-markdown", "GET", "/api/files/notes.txt/render", nil, http.StatusUnsupportedMediaType, "File is not Markdown"},
+		{"tail bad lines", "GET", "/api/files/notes.txt/tail?lines=0", nil, http.StatusBadRequest, "Invalid lines parameter"},
+		{"missing trash entry", "POST", "/api/trash/nope.txt/restore", nil, http.StatusNotFound, "File not found in trash"},
+		{"missing folder", "DELETE", "/api/folders/nowhere", nil, http.StatusNotFound, "Folder not found"},
+		{"unknown bucket", "GET", "/api/buckets/nope/files", nil, http.StatusNotFound, "Bucket not found"},
+		{"unknown export", "GET", "/api/exports/nope", nil, http.StatusNotFound, "Export not found"},
+		{"admin disabled", "GET", "/api/admin/debug/object/notes.txt", nil, http.StatusNotFound, "Admin API is disabled"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := call(t, srv, tt.method, tt.path, tt.body)
+			expectStatus(t, resp, tt.status)
+			if got := resp.errorMessage(t); got != tt.error {
+				t.Fatalf("error %q, want %q", got, tt.error)
+			}
+		})
+	}
+}
+
+func TestCORS(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+	}{
+		{"preflight", "OPTIONS", "/api/upload", http.StatusOK},
+		{"json response", "GET", "/api/health", http.StatusOK},
+		{"error response", "GET", "/api/files/missing.txt", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := call(t, srv, tt.method, tt.path, nil)
+			expectStatus(t, resp, tt.status)
+			if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "*" {
+				t.Fatalf("Access-Control-Allow-Origin %q", got)
+			}
+			if got := resp.Header.Get("Access-Control-Allow-Methods"); got == "" {
+				t.Fatal("missing Access-Control-Allow-Methods")
+			}
+		})
+	}
+}
+
+func TestConflictStrategies(t *testing.T) {
+	srv, _ := newTestServer(t)
+	mustUpload(t, srv, "/api", "report.pdf", "v1")
+
+	tests := []struct {
+		strategy string
+		want     string
+	}{
+		{conflictOverwrite, "report.pdf"},
+		{conflictNumber, "report (1).pdf"},
+		{conflictHash, "report-" + contentHash([]byte("v2"))[:8] + ".pdf"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			req := upload("report.pdf", "v2")
+			req.OnConflict = tt.strategy
+			resp := call(t, srv, "POST", "/api/upload", req)
+			expectStatus(t, resp, http.StatusOK)
+
+			var msg MessageResponse
+			resp.decode(t, &msg)
+			if msg.Filename != tt.want {
+				t.Fatalf("stored as %q, want %q", msg.Filename, tt.want)
+			}
+		})
+	}
+}
+
+func TestSoftDeleteAndRestore(t *testing.T) {
+	srv, fake := newTestServer(t)
+	override(t, &softDeleteEnabled, true)
+
+	mustUpload(t, srv, "/api", "keep.txt", "precious")
+	expectStatus(t, call(t, srv, "DELETE", "/api/files/keep.txt", nil), http.StatusOK)
+
+	if _, _, ok := fake.Object(bucketName, trashPrefix+"keep.txt"); !ok {
+		t.Fatalf("not in trash, bucket holds %v", fake.Keys(bucketName))
+	}
+
+	var files FilesResponse
+	call(t, srv, "GET", "/api/files", nil).decode(t, &files)
+	if len(files.Files) != 0 {
+		t.Fatalf("trash leaked into listing: %v", files.Files)
+	}
+
+	var trash TrashResponse
+	call(t, srv, "GET", "/api/trash", nil).decode(t, &trash)
+	if len(trash.Items) != 1 || trash.Items[0].Filename != "keep.txt" {
+		t.Fatalf("trash lists %+v", trash.Items)
+	}
+
+	expectStatus(t, call(t, srv, "POST", "/api/trash/keep.txt/restore", nil), http.StatusOK)
+	if got := call(t, srv, "GET", "/api/files/keep.txt", nil); string(got.body) != "precious" {
+		t.Fatalf("restored %q", got.body)
+	}
+}
+
+func TestFolderDelete(t *testing.T) {
+	srv, fake := newTestServer(t)
+	for _, name := range []string{"logs/a.log", "logs/b.log", "other.txt"} {
+		mustUpload(t, srv, "/api", name, name)
+	}
+
+	var dry FolderDeleteResponse
+	resp := call(t, srv, "DELETE", "/api/folders/logs?dry_run=true", nil)
+	expectStatus(t, resp, http.StatusOK)
+	resp.decode(t, &dry)
+	if dry.Count != 2 || len(fake.Keys(bucketName)) != 3 {
+		t.Fatalf("dry run reported %d keys, bucket holds %v", dry.Count, fake.Keys(bucketName))
+	}
+
+	expectStatus(t, call(t, srv, "DELETE", "/api/folders/logs", nil), http.StatusOK)
+	if keys := fake.Keys(bucketName); !reflect.DeepEqual(keys, []string{"other.txt"}) {
+		t.Fatalf("bucket holds %v", keys)
+	}
+}
+
+func TestFolderDeleteToTrash(t *testing.T) {
+	srv, fake := newTestServer(t)
+	override(t, &softDeleteEnabled, true)
+	expectStatus(t, call(t, srv, "POST", "/api/folders", map[string]string{"path": "logs/old"}), http.StatusCreated)
+	for _, name := range []string{"logs/a.log", "logs/old/b.log", "other.txt"} {
+		mustUpload(t, srv, "/api", name, name)
+	}
+
+	var resp FolderDeleteResponse
+	call(t, srv, "DELETE", "/api/folders/logs", nil).decode(t, &resp)
+	if resp.Count != 3 || len(resp.Errors) != 0 {
+		t.Fatalf("deleted %+v", resp)
+	}
+	if keys := fake.Keys(bucketName); !reflect.DeepEqual(keys, []string{trashPrefix + "logs/a.log", trashPrefix + "logs/old/b.log", "other.txt"}) {
+		t.Fatalf("bucket holds %v", keys)
+	}
+	expectStatus(t, call(t, srv, "POST", "/api/trash/logs/old/b.log/restore", nil), http.StatusOK)
+	if got := call(t, srv, "GET", "/api/files/logs/old/b.log", nil); string(got.body) != "logs/old/b.log" {
+		t.Errorf("restored %q", got.body)
+	}
+}
+
+func TestStoragePolicyRoundTrip(t *testing.T) {
+	srv, fake := newTestServer(t)
+	override(t, &storagePolicies, map[string]storagePolicy{"secure/": {Compression: encodingZstd, Encrypt: true}})
+	override(t, &encryptionKey, bytes.Repeat([]byte{7}, 32))
+
+	content := string(bytes.Repeat([]byte("confidential "), 100))
+	mustUpload(t, srv, "/api", "secure/plan.txt", content)
+
+	stored, metadata, _ := fake.Object(bucketName, "secure/plan.txt")
+	if bytes.Contains(stored, []byte("confidential")) {
+		t.Fatal("stored in plaintext")
+	}
+	if metadata[metaStorageEncoding] != "zstd,aes-256-gcm-key" {
+		t.Fatalf("storage encoding %q", metadata[metaStorageEncoding])
+	}
+
+	if got := call(t, srv, "GET", "/api/files/secure/plan.txt", nil); string(got.body) != content {
+		t.Fatalf("downloaded %d bytes, want %d", len(got.body), len(content))
+	}
+
+	// The bytes only decrypt under the key they were stored under
+	ctx := context.Background()
+	mustUpload(t, srv, "/api", "secure/other.txt", "other")
+	if _, err := fake.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String(bucketName), Key: aws.String("secure/other.txt"), Body: bytes.NewReader(stored), Metadata: metadata}); err != nil {
+		t.Fatal(err)
+	}
+	if got := call(t, srv, "GET", "/api/files/secure/other.txt", nil); got.StatusCode == http.StatusOK {
+		t.Errorf("object swapped into another key served %d bytes", len(got.body))
+	}
+
+	// Objects encrypted before that are still read
+	gcm, err := newGCM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	legacy := gcm.Seal(nonce, nonce, []byte("old"), nil)
+	if _, err := fake.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String(bucketName), Key: aws.String("secure/old.txt"), Body: bytes.NewReader(legacy), Metadata: map[string]string{metaStorageEncoding: encodingAESGCM, metaOriginalSize: "3"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := call(t, srv, "GET", "/api/files/secure/old.txt", nil); string(got.body) != "old" {
+		t.Errorf("legacy object downloaded as %q", got.body)
+	}
+
+	// Trashing and restoring a file keeps it readable, and so does moving it
+	override(t, &softDeleteEnabled, true)
+	expectStatus(t, call(t, srv, "DELETE", "/api/files/secure/plan.txt", nil), http.StatusOK)
+	expectStatus(t, call(t, srv, "POST", "/api/trash/secure/plan.txt/restore", nil), http.StatusOK)
+	if got := call(t, srv, "GET", "/api/files/secure/plan.txt", nil); string(got.body) != content {
+		t.Errorf("restored file downloaded as %d bytes, want %d", len(got.body), len(content))
+	}
+	override(t, &webdavEnabled, true)
+	for _, move := range [][2]string{{"secure/plan.txt", "secure/moved.txt"}, {"secure/old.txt", "secure/moved-old.txt"}} {
+		got := call(t, srv, "MOVE", "/api/dav/"+move[0], nil, "Destination", srv.URL+"/api/dav/"+move[1])
+		if got.StatusCode != http.StatusCreated {
+			t.Fatalf("MOVE %s answered %d", move[0], got.StatusCode)
+		}
+	}
+	if got := call(t, srv, "GET", "/api/files/secure/moved.txt", nil); string(got.body) != content {
+		t.Errorf("moved file downloaded as %d bytes, want %d", len(got.body), len(content))
+	}
+	if got := call(t, srv, "GET", "/api/files/secure/moved-old.txt", nil); string(got.body) != "old" {
+		t.Errorf("moved legacy file downloaded as %q", got.body)
+	}
+	if _, metadata, _ := fake.Object(bucketName, "secure/moved-old.txt"); metadata[metaStorageEncoding] != encodingAESGCMKey {
+		t.Errorf("moved legacy file stored as %q", metadata[metaStorageEncoding])
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	srv, fake := newTestServer(t)
+	override(t, &apiKeys, map[string]string{"acme-key": "acme", "globex-key": "globex"})
+
+	resp := call(t, srv, "GET", "/api/files", nil)
+	expectStatus(t, resp, http.StatusUnauthorized)
+	expectStatus(t, call(t, srv, "GET", "/api/files", nil, "X-API-Key", "wrong"), http.StatusUnauthorized)
+	expectStatus(t, call(t, srv, "GET", "/api/health", nil), http.StatusOK)
+
+	mustUpload(t, srv, "/api", "plan.txt", "acme only", "X-API-Key", "acme-key")
+	if _, _, ok := fake.Object(bucketName, "tenants/acme/plan.txt"); !ok {
+		t.Fatalf("bucket holds %v", fake.Keys(bucketName))
+	}
+
+	var files FilesResponse
+	call(t, srv, "GET", "/api/files", nil, "Authorization", "Bearer acme-key").decode(t, &files)
+	if !reflect.DeepEqual(files.Files, []string{"plan.txt"}) {
+		t.Fatalf("acme lists %v", files.Files)
+	}
+
+	files = FilesResponse{}
+	call(t, srv, "GET", "/api/files", nil, "X-API-Key", "globex-key").decode(t, &files)
+	if len(files.Files) != 0 {
+		t.Fatalf("globex lists %v", files.Files)
+	}
+	expectStatus(t, call(t, srv, "GET", "/api/files/plan.txt", nil, "X-API-Key", "globex-key"), http.StatusNotFound)
+
+	expectStatus(t, call(t, srv, "DELETE", "/api/files/plan.txt", nil, "X-API-Key", "globex-key"), http.StatusOK)
+	if _, _, ok := fake.Object(bucketName, "tenants/acme/plan.txt"); !ok {
+		t.Fatal("globex deleted acme's file")
+	}
+}
+
+func TestNamedBuckets(t *testing.T) {
+	srv, fake := newTestServer(t)
+	fake.AddBucket("assets-bucket")
+	override(t, &namedBuckets, map[string]string{"assets": "assets-bucket"})
+
+	mustUpload(t, srv, "/api/buckets/assets", "logo.svg", "<svg/>")
+	if _, _, ok := fake.Object("assets-bucket", "logo.svg"); !ok {
+		t.Fatal("not stored in the named bucket")
+	}
+
+	var files FilesResponse
+	call(t, srv, "GET", "/api/files", nil).decode(t, &files)
+	if len(files.Files) != 0 {
+		t.Fatalf("files bucket lists %v", files.Files)
+	}
+
+	var buckets BucketsResponse
+	call(t, srv, "GET", "/api/buckets", nil).decode(t, &buckets)
+	if !reflect.DeepEqual(buckets.Buckets, []string{"assets"}) {
+		t.Fatalf("buckets %v", buckets.Buckets)
+	}
+}
+
+func TestUploadTags(t *testing.T) {
+	srv, fake := newTestServer(t)
+	req := upload("dist/app.tar.gz", "binary")
+	req.Tags = map[string]string{"branch": "feature/login", "commit": "4f2a9c1", "run": "1234"}
+	req.ExpiresIn = "720h"
+	expectStatus(t, call(t, srv, "POST", "/api/upload", req), http.StatusOK)
+
+	var metadata FileMetadata
+	call(t, srv, "GET", "/api/files/dist/app.tar.gz/metadata", nil).decode(t, &metadata)
+	if !reflect.DeepEqual(metadata.Tags, req.Tags) || metadata.ExpiresAt == "" {
+		t.Fatalf("metadata tags %v, expires %q", metadata.Tags, metadata.ExpiresAt)
+	}
+	if _, _, ok := fake.Object(bucketName, "dist/app.tar.gz"); !ok {
+		t.Fatal("not stored")
+	}
+
+	req.Tags = map[string]string{}
+	for i := 0; i <= maxUserTags; i++ {
+		req.Tags[fmt.Sprintf("tag%d", i)] = "x"
+	}
+	expectStatus(t, call(t, srv, "POST", "/api/upload", req), http.StatusBadRequest)
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+)
+
+// Audit records live at the root of the files bucket, one object each, under
+// the tenant and the UTC day they were made. Tenant IDs can't start with a
+// dot, so admin records get a directory of their own.
+const (
+	auditPrefix      = ".audit/"
+	auditAdminTenant = ".admin"
+
+	auditResultSuccess = "success"
+	auditResultDenied  = "denied"
+	auditResultFailed  = "failed"
+)
+
+var (
+	// Longest window one query may cover, in days
+	auditQueryMaxDays = intFromEnv("AUDIT_QUERY_MAX_DAYS", 31)
+
+	auditQueryDefaultLimit = 100
+	auditQueryMaxLimit     = 1000
+
+	// Records a query reads at once before checking whether it has enough
+	auditReadBatch = 50
+
+	// Responses are peeked at for the name a write actually stored, so only
+	// their start is kept
+	auditMaxResponseBody = int64(64 << 10)
+)
+
+// AuditRecord is one mutating request: who made it, what it touched and how
+// it ended.
+type AuditRecord struct {
+	ID         string `json:"id"`
+	Time       string `json:"time"`
+	Tenant     string `json:"tenant"`
+	Actor      string `json:"actor"`
+	AuthMethod string `json:"auth_method,omitempty"`
+	Action     string `json:"action"`
+	Method     string `json:"method"`
+	Path       string `json:"path"`
+	Bucket     string `json:"bucket,omitempty"`
+	Key        string `json:"key,omitempty"`
+	Status     int    `json:"status"`
+	Result     string `json:"result"`
+	SourceIP   string `json:"source_ip"`
+	UserAgent  string `json:"user_agent,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+type AuditResponse struct {
+	Records   []AuditRecord `json:"records"`
+	Truncated bool          `json:"truncated"`
+}
+
+// auditSink stores audit records somewhere a compliance review can find them.
+type auditSink interface {
+	write(ctx context.Context, record AuditRecord) error
+}
+
+// auditSinkTypes are the AUDIT_SINKS values this build supports. CloudWatch
+// Logs needs its own SDK client, so it is only built in with -tags cloudwatch.
+var auditSinkTypes = map[string]func() (auditSink, error){
+	"s3":     func() (auditSink, error) { return s3AuditSink{}, nil },
+	"file":   newFileAuditSink,
+	"stdout": func() (auditSink, error) { return &jsonLinesSink{w: os.Stdout}, nil },
+}
+
+func registerAuditSink(name string, open func() (auditSink, error)) bool {
+	auditSinkTypes[name] = open
+	return true
+}
+
+// auditSinks receive every record; none means auditing is off
+var auditSinks []auditSink
+
+func init() {
+	for _, name := range strings.Split(os.Getenv("AUDIT_SINKS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		open, ok := auditSinkTypes[name]
+		if !ok {
+			fatal("AUDIT_SINKS names a sink this build doesn't support", "sink", name)
+		}
+		sink, err := open()
+		if err != nil {
+			fatal("Failed to open audit sink", "sink", name, "err", err)
+		}
+		auditSinks = append(auditSinks, sink)
+	}
+}
+
+// auditQueryable reports whether records are kept where GET /api/audit can
+// read them back.
+func auditQueryable() bool {
+	for _, sink := range auditSinks {
+		if _, ok := sink.(s3AuditSink); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// s3AuditSink writes each record to its own object, so concurrent instances
+// never contend and records can't be rewritten by appending.
+type s3AuditSink struct{}
+
+func (s3AuditSink) write(ctx context.Context, record AuditRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucketName),
+		Key:         aws.String(auditKey(record)),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+		IfNoneMatch: aws.String("*"),
+	})
+	return err
+}
+
+func auditKey(record AuditRecord) string {
+	return auditDayPrefix(record.Tenant, record.ID[:8]) + record.ID + ".json"
+}
+
+// auditDayPrefix holds a tenant's records for a day, given as YYYYMMDD.
+func auditDayPrefix(tenant, day string) string {
+	return auditPrefix + tenant + "/" + day + "/"
+}
+
+// jsonLinesSink writes one JSON record per line, for log shippers to pick up.
+type jsonLinesSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func newFileAuditSink() (auditSink, error) {
+	path := os.Getenv("AUDIT_LOG_FILE")
+	if path == "" {
+		return nil, fmt.Errorf("AUDIT_LOG_FILE is not set")
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonLinesSink{w: f}, nil
+}
+
+func (s *jsonLinesSink) write(_ context.Context, record AuditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(line, '\n'))
+	return err
+}
+
+// auditRequests records the tenant requests that change something once they
+// have been answered. Requests rejected before authentication have no actor,
+// so aren't recorded.
+func auditRequests(next http.Handler) http.Handler {
+	return auditWith(next, func(r *http.Request) (string, string, string) {
+		p, _ := requestPrincipal(r)
+		return requestTenant(r), requestSubject(r), p.Method
+	})
+}
+
+// auditAdminRequests records admin changes, which act for no tenant.
+func auditAdminRequests(next http.Handler) http.Handler {
+	return auditWith(next, func(*http.Request) (string, string, string) {
+		return auditAdminTenant, "admin", "admin_token"
+	})
+}
+
+func auditWith(next http.Handler, who func(*http.Request) (tenant, actor, method string)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(auditSinks) == 0 || r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS" || r.Method == "PROPFIND" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		cw := &captureWriter{ResponseWriter: w, body: &cappedBuffer{max: auditMaxResponseBody}}
+		next.ServeHTTP(cw, r)
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+
+		tenant, actor, method := who(r)
+		record := AuditRecord{
+			ID:         captureID(start),
+			Time:       start.UTC().Format(time.RFC3339Nano),
+			Tenant:     tenant,
+			Actor:      actor,
+			AuthMethod: method,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Bucket:     mux.Vars(r)["bucket"],
+			Key:        auditedKey(r, cw.body.Bytes()),
+			Status:     cw.status,
+			Result:     auditResult(cw.status),
+			SourceIP:   clientAddr(r),
+			UserAgent:  r.UserAgent(),
+			DurationMS: time.Since(start).Milliseconds(),
+		}
+		if route := mux.CurrentRoute(r); route != nil {
+			record.Action = route.GetName()
+		}
+
+		// The request's own context ends with the response
+		writeAudit(context.WithoutCancel(r.Context()), record)
+	})
+}
+
+// writeAudit hands record to every sink.
+func writeAudit(ctx context.Context, record AuditRecord) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	for _, sink := range auditSinks {
+		if err := sink.write(ctx, record); err != nil {
+			slog.ErrorContext(ctx, "Failed to write audit record", "record", record.ID, "err", err)
+		}
+	}
+}
+
+// auditedKey is what the request changed: the name the response reports
+// storing, which may differ from the one asked for, or else the one in the
+// path.
+func auditedKey(r *http.Request, response []byte) string {
+	var written struct {
+		Filename string `json:"filename"`
+	}
+	if json.Unmarshal(response, &written) == nil && written.Filename != "" {
+		return written.Filename
+	}
+	vars := mux.Vars(r)
+	for _, name := range []string{"filename", "prefix", "key", "id", "channel", "alias", "path"} {
+		if v := vars[name]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func auditResult(status int) string {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return auditResultDenied
+	case status >= 400:
+		return auditResultFailed
+	}
+	return auditResultSuccess
+}
+
+// auditQuery selects records made between From and To, newest first.
+// Empty filters match everything; Key matches names by prefix.
+type auditQuery struct {
+	From, To time.Time
+	Actor    string
+	Action   string
+	Key      string
+	Result   string
+	Limit    int
+}
+
+func (q auditQuery) matches(record AuditRecord) bool {
+	return (q.Actor == "" || record.Actor == q.Actor) &&
+		(q.Action == "" || record.Action == q.Action) &&
+		(q.Result == "" || record.Result == q.Result) &&
+		strings.HasPrefix(record.Key, q.Key)
+}
+
+func parseAuditQuery(r *http.Request) (auditQuery, error) {
+	query := r.URL.Query()
+	q := auditQuery{
+		To:     time.Now().UTC(),
+		Actor:  query.Get("actor"),
+		Action: query.Get("action"),
+		Key:    query.Get("key"),
+		Result: query.Get("result"),
+		Limit:  auditQueryDefaultLimit,
+	}
+	for name, t := range map[string]*time.Time{"from": &q.From, "to": &q.To} {
+		if v := query.Get(name); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return q, fmt.Errorf("%s must be an RFC 3339 time", name)
+			}
+			*t = parsed.UTC()
+		}
+	}
+	if q.From.IsZero() {
+		q.From = q.To.Add(-24 * time.Hour)
+	}
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > auditQueryMaxLimit {
+			return q, fmt.Errorf("limit must be between 1 and %d", auditQueryMaxLimit)
+		}
+		q.Limit = n
+	}
+
+	switch q.Result {
+	case "", auditResultSuccess, auditResultDenied, auditResultFailed:
+	default:
+		return q, fmt.Errorf("result must be %s, %s or %s", auditResultSuccess, auditResultDenied, auditResultFailed)
+	}
+	switch {
+	case q.From.After(q.To):
+		return q, fmt.Errorf("from must not be after to")
+	case q.To.Sub(q.From) > time.Duration(auditQueryMaxDays)*24*time.Hour:
+		return q, fmt.Errorf("a query may cover at most %d days", auditQueryMaxDays)
+	}
+	return q, nil
+}
+
+// readAudit walks a tenant's records newest first, reading them in batches
+// until the limit is met. Record IDs start with their time, so records
+// outside the window are skipped without being read.
+func readAudit(ctx context.Context, tenant string, q auditQuery) (AuditResponse, error) {
+	response := AuditResponse{Records: []AuditRecord{}}
+	fromID, toID := captureID(q.From)[:25], captureID(q.To)[:25]
+
+	for day := q.To.Truncate(24 * time.Hour); !day.Before(q.From.Truncate(24 * time.Hour)); day = day.Add(-24 * time.Hour) {
+		var keys []string
+		paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+			Bucket: aws.String(bucketName),
+			Prefix: aws.String(auditDayPrefix(tenant, day.Format("20060102"))),
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return response, err
+			}
+			for _, obj := range page.Contents {
+				key := aws.ToString(obj.Key)
+				id := key[strings.LastIndex(key, "/")+1:]
+				if len(id) >= 25 && id[:25] >= fromID && id[:25] <= toID {
+					keys = append(keys, key)
+				}
+			}
+		}
+		sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+
+		for len(keys) > 0 {
+			batch := keys[:min(len(keys), auditReadBatch)]
+			keys = keys[len(batch):]
+			records := make([]AuditRecord, len(batch))
+			if err := fanOut(ctx, len(batch), func(ctx context.Context, i int) error {
+				return readAuditRecord(ctx, batch[i], &records[i])
+			}); err != nil {
+				return response, err
+			}
+			for _, record := range records {
+				if !q.matches(record) {
+					continue
+				}
+				if len(response.Records) == q.Limit {
+					response.Truncated = true
+					return response, nil
+				}
+				response.Records = append(response.Records, record)
+			}
+		}
+	}
+	return response, nil
+}
+
+func readAuditRecord(ctx context.Context, key string, record *AuditRecord) error {
+	result, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+	defer result.Body.Close()
+	if err := json.NewDecoder(result.Body).Decode(record); err != nil {
+		return fmt.Errorf("decoding %s: %w", key, err)
+	}
+	return nil
+}
+
+func respondAudit(w http.ResponseWriter, r *http.Request, tenant string) {
+	if !auditQueryable() {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{
+			Error:   "Audit log is not queryable",
+			Details: "add s3 to AUDIT_SINKS to keep records this API can read",
+		})
+		return
+	}
+	q, err := parseAuditQuery(r)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid audit query",
+			Details: err.Error(),
+		})
+		return
+	}
+	response, err := readAudit(r.Context(), tenant, q)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to read audit log",
+			Details: err.Error(),
+		})
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, http.StatusOK, response)
+}
+
+func auditHandler(w http.ResponseWriter, r *http.Request) {
+	respondAudit(w, r, requestTenant(r))
+}
+
+// adminAuditHandler reads any tenant's records, or the admin API's own when
+// no tenant is given.
+func adminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	tenant := r.URL.Query().Get("tenant")
+	if tenant == "" {
+		tenant = auditAdminTenant
+	} else if !validTenantID.MatchString(tenant) {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid audit query",
+			Details: "tenant is not a valid tenant id",
+		})
+		return
+	}
+	respondAudit(w, r, tenant)
+}
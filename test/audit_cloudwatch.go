@@ -0,0 +1,80 @@
+//go:build cloudwatch
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+)
+
+var _ = registerAuditSink("cloudwatch", newCloudWatchAuditSink)
+
+// cloudWatchAuditSink sends records to a CloudWatch Logs stream, one event
+// each. The stream is named after the host unless AUDIT_CLOUDWATCH_STREAM
+// says otherwise, so instances don't interleave.
+type cloudWatchAuditSink struct {
+	mu     sync.Mutex
+	client *cloudwatchlogs.Client
+	group  string
+	stream string
+}
+
+func newCloudWatchAuditSink() (auditSink, error) {
+	group := os.Getenv("AUDIT_CLOUDWATCH_GROUP")
+	if group == "" {
+		return nil, fmt.Errorf("AUDIT_CLOUDWATCH_GROUP is not set")
+	}
+	stream := os.Getenv("AUDIT_CLOUDWATCH_STREAM")
+	if stream == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		stream = host
+	}
+
+	ctx := context.Background()
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := cloudwatchlogs.NewFromConfig(cfg)
+	_, err = client.CreateLogStream(ctx, &cloudwatchlogs.CreateLogStreamInput{
+		LogGroupName:  aws.String(group),
+		LogStreamName: aws.String(stream),
+	})
+	var exists *types.ResourceAlreadyExistsException
+	if err != nil && !errors.As(err, &exists) {
+		return nil, err
+	}
+	return &cloudWatchAuditSink{client: client, group: group, stream: stream}, nil
+}
+
+func (s *cloudWatchAuditSink) write(ctx context.Context, record AuditRecord) error {
+	message, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	// Events in one stream must arrive in order
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.client.PutLogEvents(ctx, &cloudwatchlogs.PutLogEventsInput{
+		LogGroupName:  aws.String(s.group),
+		LogStreamName: aws.String(s.stream),
+		LogEvents: []types.InputLogEvent{{
+			Message:   aws.String(string(message)),
+			Timestamp: aws.Int64(time.Now().UnixMilli()),
+		}},
+	})
+	return err
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditLog(t *testing.T) {
+	srv, _ := newTestServer(t)
+	override(t, &apiKeys, map[string]string{"acme-key": "acme", "globex-key": "globex"})
+	override(t, &auditSinks, []auditSink{s3AuditSink{}})
+	acme := []string{"X-API-Key", "acme-key", "X-Forwarded-For", "203.0.113.9"}
+
+	mustUploadAs := func(name string, key ...string) {
+		t.Helper()
+		expectStatus(t, call(t, srv, "POST", "/api/upload", upload(name, "x"), key...), http.StatusOK)
+	}
+	mustUploadAs("reports/q3.txt", acme...)
+	mustUploadAs("reports/q4.txt", acme...)
+	mustUploadAs("notes.txt", "X-API-Key", "globex-key")
+	expectStatus(t, call(t, srv, "DELETE", "/api/files/reports/q3.txt", nil, acme...), http.StatusOK)
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("", "x"), acme...), http.StatusBadRequest)
+	// Reads aren't recorded
+	expectStatus(t, call(t, srv, "GET", "/api/files", nil, acme...), http.StatusOK)
+
+	var log AuditResponse
+	call(t, srv, "GET", "/api/audit", nil, acme...).decode(t, &log)
+	if len(log.Records) != 4 || log.Truncated {
+		t.Fatalf("acme audit log %+v", log)
+	}
+	newest := log.Records[0]
+	if newest.Action != "upload" || newest.Key != "" || newest.Status != http.StatusBadRequest || newest.Result != auditResultFailed {
+		t.Fatalf("newest record %+v", newest)
+	}
+	first := log.Records[3]
+	if first.Action != "upload" || first.Key != "reports/q3.txt" || first.Actor != "acme" || first.Tenant != "acme" ||
+		first.AuthMethod == "" || first.SourceIP != "203.0.113.9" || first.Result != auditResultSuccess {
+		t.Fatalf("first record %+v", first)
+	}
+
+	for query, want := range map[string]int{
+		"?action=upload": 3,
+		"?key=reports/":  3,
+		"?result=failed": 1,
+		"?actor=globex":  0,
+		"?limit=1":       1,
+		"?to=" + time.Now().Add(-time.Hour).UTC().Format(time.RFC3339): 0,
+	} {
+		var filtered AuditResponse
+		call(t, srv, "GET", "/api/audit"+query, nil, acme...).decode(t, &filtered)
+		if len(filtered.Records) != want {
+			t.Errorf("%s: %d records, want %d", query, len(filtered.Records), want)
+		}
+	}
+
+	for _, query := range []string{"?limit=0", "?from=yesterday", "?result=maybe", "?from=2020-01-01T00:00:00Z&to=2020-06-01T00:00:00Z"} {
+		expectStatus(t, call(t, srv, "GET", "/api/audit"+query, nil, acme...), http.StatusBadRequest)
+	}
+
+	override(t, &adminToken, "admin-secret")
+	admin := []string{"Authorization", "Bearer admin-secret"}
+	call(t, srv, "GET", "/api/admin/audit?tenant=globex", nil, admin...).decode(t, &log)
+	if len(log.Records) != 1 || log.Records[0].Key != "notes.txt" {
+		t.Fatalf("globex audit log %+v", log)
+	}
+	expectStatus(t, call(t, srv, "POST", "/api/admin/policies", PolicyRule{Subject: "*", Action: "*", Resource: "*"}, admin...), http.StatusCreated)
+	call(t, srv, "GET", "/api/admin/audit", nil, admin...).decode(t, &log)
+	if len(log.Records) != 1 || log.Records[0].Action != "createPolicy" || log.Records[0].Actor != "admin" {
+		t.Fatalf("admin audit log %+v", log)
+	}
+}
+
+func TestAuditDenied(t *testing.T) {
+	srv, _ := newTestServer(t)
+	override(t, &auditSinks, []auditSink{s3AuditSink{}})
+	startTestPolicy(t, func(in PolicyInput) interface{} { return in.Action != "deleteFile" })
+	mustUpload(t, srv, "/api", "kept.txt", "x")
+
+	expectStatus(t, call(t, srv, "DELETE", "/api/files/kept.txt", nil), http.StatusForbidden)
+	var log AuditResponse
+	call(t, srv, "GET", "/api/audit?result=denied", nil).decode(t, &log)
+	if len(log.Records) != 1 || log.Records[0].Key != "kept.txt" || log.Records[0].Status != http.StatusForbidden {
+		t.Fatalf("denied records %+v", log)
+	}
+}
+
+func TestAuditFileSink(t *testing.T) {
+	srv, _ := newTestServer(t)
+	var out bytes.Buffer
+	override(t, &auditSinks, []auditSink{&jsonLinesSink{w: &out}})
+	mustUpload(t, srv, "/api", "a.txt", "x")
+
+	var record AuditRecord
+	if err := json.Unmarshal(bytes.TrimSpace(out.Bytes()), &record); err != nil || record.Key != "a.txt" {
+		t.Fatalf("audit line %q: %v", out.String(), err)
+	}
+
+	// Kept only in the file, so there's nothing the API can search
+	resp := call(t, srv, "GET", "/api/audit", nil)
+	expectStatus(t, resp, http.StatusNotFound)
+	if !strings.Contains(string(resp.body), "AUDIT_SINKS") {
+		t.Fatalf("unqueryable response %s", resp.body)
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+)
+
+// PolicyInput is what a policy decides on. Policies see names as tenants do,
+// and the stored object's attributes when the request names one.
+type PolicyInput struct {
+	Action    string          `json:"action"`
+	Method    string          `json:"method"`
+	Path      string          `json:"path"`
+	Principal PolicyPrincipal `json:"principal"`
+	Resource  PolicyResource  `json:"resource"`
+}
+
+type PolicyPrincipal struct {
+	Subject string                 `json:"subject"`
+	Tenant  string                 `json:"tenant"`
+	Method  string                 `json:"method,omitempty"`
+	Claims  map[string]interface{} `json:"claims,omitempty"`
+}
+
+type PolicyResource struct {
+	Bucket      string            `json:"bucket"`
+	Name        string            `json:"name,omitempty"`
+	Prefix      string            `json:"prefix,omitempty"`
+	Exists      bool              `json:"exists"`
+	Size        int64             `json:"size,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+type policyDecision struct {
+	Allow  bool
+	Reason string
+}
+
+// policyEngine makes authorization decisions on behalf of an organization's
+// central policy, on top of the ACLs files carry.
+type policyEngine interface {
+	decide(ctx context.Context, input PolicyInput) (policyDecision, error)
+}
+
+// policyEngines are the AUTHZ_ENGINE values this build supports. The embedded
+// OPA engine pulls in the whole of OPA, so it is only built in with -tags opa.
+var policyEngines = map[string]func() (policyEngine, error){
+	"http": newHTTPPolicy,
+}
+
+func registerPolicyEngine(name string, open func() (policyEngine, error)) bool {
+	policyEngines[name] = open
+	return true
+}
+
+var authzPolicy policyEngine
+
+func init() {
+	name := os.Getenv("AUTHZ_ENGINE")
+	if name == "" {
+		return
+	}
+	open, ok := policyEngines[name]
+	if !ok {
+		fatal("AUTHZ_ENGINE is not supported by this build", "engine", name)
+	}
+	engine, err := open()
+	if err != nil {
+		fatal("Failed to load authorization policy", "engine", name, "err", err)
+	}
+	authzPolicy = engine
+}
+
+// httpPolicy asks an external decision point, speaking OPA's data API: the
+// input is POSTed as {"input": ...} and the answer is {"result": ...}.
+type httpPolicy struct {
+	url    string
+	client *http.Client
+}
+
+func newHTTPPolicy() (policyEngine, error) {
+	url := os.Getenv("AUTHZ_POLICY_URL")
+	if url == "" {
+		return nil, fmt.Errorf("AUTHZ_POLICY_URL is not set")
+	}
+	return &httpPolicy{
+		url:    url,
+		client: &http.Client{Timeout: durationFromEnv("AUTHZ_TIMEOUT", 2*time.Second)},
+	}, nil
+}
+
+func (p *httpPolicy) decide(ctx context.Context, input PolicyInput) (policyDecision, error) {
+	body, err := json.Marshal(map[string]interface{}{"input": input})
+	if err != nil {
+		return policyDecision{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", p.url, bytes.NewReader(body))
+	if err != nil {
+		return policyDecision{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return policyDecision{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return policyDecision{}, fmt.Errorf("policy service returned %s", resp.Status)
+	}
+
+	var answer struct {
+		Result interface{} `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		return policyDecision{}, err
+	}
+	return decisionFrom(answer.Result)
+}
+
+// decisionFrom reads a policy's result, either a bare boolean or an object
+// like {"allow": false, "reason": "..."}. An undefined result denies.
+func decisionFrom(result interface{}) (policyDecision, error) {
+	switch v := result.(type) {
+	case nil:
+		return policyDecision{Reason: "no policy decision"}, nil
+	case bool:
+		return policyDecision{Allow: v}, nil
+	case map[string]interface{}:
+		allow, ok := v["allow"].(bool)
+		if !ok {
+			return policyDecision{}, fmt.Errorf("policy result has no boolean allow")
+		}
+		reason, _ := v["reason"].(string)
+		return policyDecision{Allow: allow, Reason: reason}, nil
+	}
+	return policyDecision{}, fmt.Errorf("policy result is a %T, not a boolean or an object", result)
+}
+
+// policyInput describes r to the policy, looking up the object it names.
+func policyInput(r *http.Request) (PolicyInput, error) {
+	ns := requestNamespace(r)
+	input := PolicyInput{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Principal: PolicyPrincipal{
+			Subject: requestSubject(r),
+			Tenant:  ns.Tenant,
+		},
+		Resource: PolicyResource{Bucket: ns.Bucket},
+	}
+	if route := mux.CurrentRoute(r); route != nil {
+		input.Action = route.GetName()
+	}
+	if p, ok := requestPrincipal(r); ok {
+		input.Principal.Method, input.Principal.Claims = p.Method, p.Claims
+	}
+
+	name, prefix := requestResource(r, input.Action)
+	input.Resource.Prefix = prefix
+	if name == "" {
+		return input, nil
+	}
+	input.Resource.Name = name
+	return input, describeResource(r.Context(), ns, &input.Resource)
+}
+
+// describeResource fills in the attributes of the object resource names,
+// if it exists.
+func describeResource(ctx context.Context, ns namespace, resource *PolicyResource) error {
+	head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(ns.Bucket),
+		Key:    aws.String(ns.key(resource.Name)),
+	})
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resource.Exists = true
+	resource.Size = aws.ToInt64(head.ContentLength)
+	resource.ContentType = aws.ToString(head.ContentType)
+	resource.Metadata = head.Metadata
+	return nil
+}
+
+// requestResource is the file the action named by route acts on, or else
+// the prefix, as tenants name them.
+func requestResource(r *http.Request, action string) (name, prefix string) {
+	vars := mux.Vars(r)
+	name, prefix = vars["filename"], vars["prefix"]
+	switch action {
+	// These name what they write in the body rather than the path
+	case "upload":
+		name = ""
+		if filename, ok := bodyField(r, "filename"); ok {
+			// Decide on the name the handler stores. It rejects names that
+			// don't sanitize before writing anything.
+			if clean, err := sanitizeName(filename); err == nil {
+				name = clean
+			}
+		}
+	case "createFolder":
+		prefix, _ = bodyField(r, "path")
+	case "browse", "headBrowse":
+		// Folders end with a slash
+		name = vars["path"]
+		if name == "" || strings.HasSuffix(name, "/") {
+			name, prefix = "", name
+		}
+	}
+	return name, prefix
+}
+
+// bodyField reads a string field of a JSON request body, leaving the body in
+// place for the handler. Bodies are already capped by limitRequestBody.
+func bodyField(r *http.Request, field string) (string, bool) {
+	if r.Body == nil {
+		return "", false
+	}
+	body, err := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), errReader{err}))
+	var fields map[string]interface{}
+	if err != nil || json.Unmarshal(body, &fields) != nil {
+		return "", false
+	}
+	value, ok := fields[field].(string)
+	return value, ok && value != ""
+}
+
+// errReader fails reads with err, so a body that couldn't be read fully
+// still fails in the handler the way it would have without the peek.
+type errReader struct{ err error }
+
+func (e errReader) Read([]byte) (int, error) {
+	if e.err == nil {
+		return 0, io.EOF
+	}
+	return 0, e.err
+}
+
+// authorizePolicy lets a request through only if the configured policy
+// allows it. Handlers still apply file ACLs afterwards, so both must agree.
+func authorizePolicy(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authzPolicy == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		input, err := policyInput(r)
+		var decision policyDecision
+		if err == nil {
+			decision, err = authzPolicy.decide(r.Context(), input)
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Authorization policy failed", "err", err)
+			respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+				Error:   "Failed to check access",
+				Details: err.Error(),
+			})
+			return
+		}
+		if !decision.Allow {
+			details := decision.Reason
+			if details == "" {
+				details = fmt.Sprintf("%s is not allowed by policy", input.Action)
+			}
+			respondJSON(w, http.StatusForbidden, ErrorResponse{
+				Error:   "Access denied",
+				Details: details,
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,48 @@
+//go:build opa
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/open-policy-agent/opa/v1/rego"
+)
+
+var _ = registerPolicyEngine("opa", newEmbeddedPolicy)
+
+// embeddedPolicy evaluates a Rego module in process, from AUTHZ_POLICY_FILE.
+type embeddedPolicy struct {
+	query rego.PreparedEvalQuery
+}
+
+func newEmbeddedPolicy() (policyEngine, error) {
+	file := os.Getenv("AUTHZ_POLICY_FILE")
+	if file == "" {
+		return nil, fmt.Errorf("AUTHZ_POLICY_FILE is not set")
+	}
+	module, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	query, err := rego.New(
+		rego.Query(envOr("AUTHZ_POLICY_QUERY", "data.files.allow")),
+		rego.Module(file, string(module)),
+	).PrepareForEval(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return &embeddedPolicy{query: query}, nil
+}
+
+func (p *embeddedPolicy) decide(ctx context.Context, input PolicyInput) (policyDecision, error) {
+	results, err := p.query.Eval(ctx, rego.EvalInput(input))
+	if err != nil {
+		return policyDecision{}, err
+	}
+	if len(results) == 0 || len(results[0].Expressions) == 0 {
+		return decisionFrom(nil)
+	}
+	return decisionFrom(results[0].Expressions[0].Value)
+}
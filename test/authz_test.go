@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// startTestPolicy serves decide as an OPA-style decision point and records
+// the inputs it was asked about.
+func startTestPolicy(t *testing.T, decide func(PolicyInput) interface{}) *[]PolicyInput {
+	t.Helper()
+	var mu sync.Mutex
+	var inputs []PolicyInput
+	pdp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Input PolicyInput `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		inputs = append(inputs, req.Input)
+		mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{"result": decide(req.Input)})
+	}))
+	t.Cleanup(pdp.Close)
+	override(t, &authzPolicy, policyEngine(&httpPolicy{url: pdp.URL, client: pdp.Client()}))
+	return &inputs
+}
+
+func TestPolicyDecisions(t *testing.T) {
+	srv, _ := newTestServer(t)
+	mustUpload(t, srv, "/api", "reports/q3.txt", "numbers")
+	mustUpload(t, srv, "/api", "secret/plan.txt", "plans")
+
+	inputs := startTestPolicy(t, func(in PolicyInput) interface{} {
+		switch {
+		case in.Action == "deleteFile":
+			return map[string]interface{}{"allow": false, "reason": "files are deleted by retention jobs only"}
+		case strings.HasPrefix(in.Resource.Name, "secret/") || in.Resource.Prefix == "secret":
+			return false
+		case in.Action == "usage":
+			// Undefined, as when no rule matches
+			return nil
+		}
+		return true
+	})
+
+	expectStatus(t, call(t, srv, "GET", "/api/files/reports/q3.txt", nil), http.StatusOK)
+	expectStatus(t, call(t, srv, "GET", "/api/files/secret/plan.txt", nil), http.StatusForbidden)
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("secret/new.txt", "x")), http.StatusForbidden)
+	expectStatus(t, call(t, srv, "POST", "/api/folders", FolderRequest{Path: "secret"}), http.StatusForbidden)
+	expectStatus(t, call(t, srv, "GET", "/api/usage", nil), http.StatusForbidden)
+	mustUpload(t, srv, "/api", "reports/q4.txt", "more numbers")
+
+	denied := call(t, srv, "DELETE", "/api/files/reports/q3.txt", nil)
+	expectStatus(t, denied, http.StatusForbidden)
+	var problem ErrorResponse
+	denied.decode(t, &problem)
+	if problem.Details != "files are deleted by retention jobs only" {
+		t.Fatalf("denial %+v", problem)
+	}
+
+	first := (*inputs)[0]
+	if first.Action != "getFile" || first.Method != "GET" || first.Resource.Name != "reports/q3.txt" ||
+		!first.Resource.Exists || first.Resource.Size != int64(len("numbers")) || first.Principal.Subject == "" {
+		t.Fatalf("policy input %+v", first)
+	}
+	if upload := (*inputs)[2]; upload.Action != "upload" || upload.Resource.Name != "secret/new.txt" || upload.Resource.Exists {
+		t.Fatalf("upload input %+v", upload)
+	}
+}
+
+func TestPolicyUnavailable(t *testing.T) {
+	srv, _ := newTestServer(t)
+	pdp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer pdp.Close()
+	override(t, &authzPolicy, policyEngine(&httpPolicy{url: pdp.URL, client: pdp.Client()}))
+
+	// Fails closed
+	expectStatus(t, call(t, srv, "GET", "/api/files", nil), http.StatusInternalServerError)
+}
+
+func TestDecisionFrom(t *testing.T) {
+	for _, tc := range []struct {
+		result  interface{}
+		allow   bool
+		invalid bool
+	}{
+		{true, true, false},
+		{false, false, false},
+		{nil, false, false},
+		{map[string]interface{}{"allow": true}, true, false},
+		{map[string]interface{}{"reason": "x"}, false, true},
+		{"yes", false, true},
+	} {
+		decision, err := decisionFrom(tc.result)
+		if (err != nil) != tc.invalid || decision.Allow != tc.allow {
+			t.Errorf("decisionFrom(%v) = %+v, %v", tc.result, decision, err)
+		}
+	}
+}
@@ -0,0 +1,543 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+)
+
+// Billing data lives at the root of the files bucket. Samples and meter
+// readings carry their numbers in their keys, so a month's report is built
+// from listings alone, without reading thousands of tiny objects.
+const (
+	billingPrefix        = ".billing/"
+	billingSamplePrefix  = billingPrefix + "samples/"
+	billingMeterPrefix   = billingPrefix + "meters/"
+	billingReportsPrefix = billingPrefix + "reports/"
+
+	billingMonthFormat = "2006-01"
+	billingHourFormat  = "2006010215"
+
+	unitGBHours  = "GB-Hours"
+	unitBytes    = "Bytes"
+	unitRequests = "Requests"
+)
+
+var (
+	billingEnabled, _ = strconv.ParseBool(os.Getenv("BILLING"))
+
+	// How often this instance writes out its request counts and checks
+	// whether the hour's storage has been sampled
+	billingFlushInterval = durationFromEnv("BILLING_FLUSH_INTERVAL", 5*time.Minute)
+
+	// Where finished monthly reports are pushed, signed with the secret
+	billingWebhookURL    = os.Getenv("BILLING_WEBHOOK_URL")
+	billingWebhookSecret = os.Getenv("BILLING_WEBHOOK_SECRET")
+
+	meter = newBillingMeter()
+)
+
+// UsageRecord is one metric of one tenant for a billing period. Columns are
+// named after the usage columns of the FinOps FOCUS specification, so
+// chargeback tools can import reports as they are. Prices are left to them.
+type UsageRecord struct {
+	BillingPeriodStart string  `json:"BillingPeriodStart"`
+	BillingPeriodEnd   string  `json:"BillingPeriodEnd"`
+	SubAccountId       string  `json:"SubAccountId"`
+	ChargeDescription  string  `json:"ChargeDescription"`
+	ConsumedQuantity   float64 `json:"ConsumedQuantity"`
+	ConsumedUnit       string  `json:"ConsumedUnit"`
+}
+
+type BillingReport struct {
+	Month       string        `json:"month"`
+	Complete    bool          `json:"complete"`
+	GeneratedAt string        `json:"generated_at"`
+	Records     []UsageRecord `json:"records"`
+}
+
+type BillingReportRequest struct {
+	Month string `json:"month"`
+	Push  bool   `json:"push,omitempty"`
+}
+
+type meterKey struct {
+	month, tenant string
+}
+
+type meterCounts struct {
+	requests, egress int64
+}
+
+// billingMeter counts each tenant's requests and response bytes until they
+// are flushed to storage as one reading per instance and interval.
+type billingMeter struct {
+	mu       sync.Mutex
+	counts   map[meterKey]*meterCounts
+	instance string
+
+	// The hour each tenant's storage was last sampled by this instance
+	sampled map[string]string
+}
+
+func newBillingMeter() *billingMeter {
+	return &billingMeter{counts: map[meterKey]*meterCounts{}, instance: newJobID(), sampled: map[string]string{}}
+}
+
+func (m *billingMeter) record(tenant string, egress int64, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := meterKey{now.UTC().Format(billingMonthFormat), tenant}
+	c, ok := m.counts[key]
+	if !ok {
+		c = &meterCounts{}
+		m.counts[key] = c
+	}
+	c.requests++
+	c.egress += egress
+}
+
+// flush writes out the counts so far. Counts that fail to be written are kept
+// for the next flush.
+func (m *billingMeter) flush(ctx context.Context) error {
+	m.mu.Lock()
+	counts := m.counts
+	m.counts = map[meterKey]*meterCounts{}
+	m.mu.Unlock()
+
+	var errs []error
+	for key, c := range counts {
+		reading := fmt.Sprintf("%s%s/%s/%s-%d-%d-%d", billingMeterPrefix, key.month, key.tenant, m.instance, time.Now().UnixNano(), c.requests, c.egress)
+		_, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(reading),
+			Body:   bytes.NewReader(nil),
+		})
+		if err != nil {
+			errs = append(errs, err)
+			m.mu.Lock()
+			if kept, ok := m.counts[key]; ok {
+				kept.requests += c.requests
+				kept.egress += c.egress
+			} else {
+				m.counts[key] = c
+			}
+			m.mu.Unlock()
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sample records each tenant's stored bytes for the current hour, once per
+// hour per instance. Instances sampling the same hour are counted once.
+func (m *billingMeter) sample(ctx context.Context, now time.Time) error {
+	tenants := []string{defaultTenant}
+	if tenancyEnabled() {
+		var err error
+		if tenants, err = knownTenants(ctx); err != nil {
+			return err
+		}
+	}
+
+	hour := now.UTC().Format(billingHourFormat)
+	month := now.UTC().Format(billingMonthFormat)
+	var errs []error
+	for _, tenant := range tenants {
+		m.mu.Lock()
+		done := m.sampled[tenant] == hour
+		m.mu.Unlock()
+		if done {
+			continue
+		}
+
+		current, err := usage.get(ctx, tenant)
+		if err == nil {
+			_, err = s3Client.PutObject(ctx, &s3.PutObjectInput{
+				Bucket: aws.String(bucketName),
+				Key:    aws.String(fmt.Sprintf("%s%s/%s/%s-%d", billingSamplePrefix, month, tenant, hour, current.bytes)),
+				Body:   bytes.NewReader(nil),
+			})
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("sampling %s: %w", tenant, err))
+			continue
+		}
+		m.mu.Lock()
+		m.sampled[tenant] = hour
+		m.mu.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+// meterRequests counts authenticated requests and the bytes sent back to
+// them against the tenant.
+func meterRequests(next http.Handler) http.Handler {
+	if !billingEnabled {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		meter.record(requestTenant(r), rec.bytes, time.Now())
+	})
+}
+
+// startBilling flushes meter readings and samples storage in the background,
+// and produces the previous month's report once it has ended.
+func startBilling(ctx context.Context) {
+	if !billingEnabled {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(billingFlushInterval)
+		defer ticker.Stop()
+
+		for {
+			now := time.Now()
+			if err := meter.sample(ctx, now); err != nil {
+				slog.ErrorContext(ctx, "Billing storage sample failed", "err", err)
+			}
+			if err := meter.flush(ctx); err != nil {
+				slog.ErrorContext(ctx, "Billing meter flush failed", "err", err)
+			}
+			// Other instances flush the month's last counts within an interval
+			// of it ending, so give them time before closing it
+			settled := now.UTC().Add(-2 * billingFlushInterval)
+			previous := time.Date(settled.Year(), settled.Month(), 0, 0, 0, 0, 0, time.UTC).Format(billingMonthFormat)
+			if err := closeBillingMonth(ctx, previous); err != nil {
+				slog.ErrorContext(ctx, "Billing report failed", "month", previous, "err", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// closeBillingMonth writes a finished month's report unless some instance
+// already has, and pushes it if this instance wrote it.
+func closeBillingMonth(ctx context.Context, month string) error {
+	_, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(billingReportKey(month, "json")),
+	})
+	if !isNotFound(err) {
+		return err
+	}
+	report, err := buildBillingReport(ctx, month, time.Now())
+	if err != nil {
+		return err
+	}
+	written, err := storeBillingReport(ctx, report, false)
+	if err != nil || !written || billingWebhookURL == "" {
+		return err
+	}
+	return pushBillingReport(ctx, report)
+}
+
+func billingReportKey(month, format string) string {
+	return billingReportsPrefix + month + "." + format
+}
+
+// billingPeriod is the month as a half-open interval in UTC.
+func billingPeriod(month string) (time.Time, time.Time, error) {
+	start, err := time.Parse(billingMonthFormat, month)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("month must look like 2024-05")
+	}
+	return start, start.AddDate(0, 1, 0), nil
+}
+
+// buildBillingReport totals a month from its samples and meter readings. Each
+// hour is charged the storage of its sample, or of the latest earlier one
+// when no instance was running to take it.
+func buildBillingReport(ctx context.Context, month string, now time.Time) (BillingReport, error) {
+	start, end, err := billingPeriod(month)
+	if err != nil {
+		return BillingReport{}, err
+	}
+
+	samples := map[string]map[string]int64{}
+	if err := listBillingEntries(ctx, billingSamplePrefix+month+"/", func(tenant string, fields []string) {
+		if len(fields) != 2 {
+			return
+		}
+		stored, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return
+		}
+		if samples[tenant] == nil {
+			samples[tenant] = map[string]int64{}
+		}
+		samples[tenant][fields[0]] = max(samples[tenant][fields[0]], stored)
+	}); err != nil {
+		return BillingReport{}, err
+	}
+
+	meters := map[string]*meterCounts{}
+	if err := listBillingEntries(ctx, billingMeterPrefix+month+"/", func(tenant string, fields []string) {
+		if len(fields) != 4 {
+			return
+		}
+		requests, err1 := strconv.ParseInt(fields[2], 10, 64)
+		egress, err2 := strconv.ParseInt(fields[3], 10, 64)
+		if err1 != nil || err2 != nil {
+			return
+		}
+		if meters[tenant] == nil {
+			meters[tenant] = &meterCounts{}
+		}
+		meters[tenant].requests += requests
+		meters[tenant].egress += egress
+	}); err != nil {
+		return BillingReport{}, err
+	}
+
+	until := end
+	if now.Before(end) {
+		until = now.UTC().Truncate(time.Hour).Add(time.Hour)
+	}
+	tenants := map[string]bool{}
+	for tenant := range samples {
+		tenants[tenant] = true
+	}
+	for tenant := range meters {
+		tenants[tenant] = true
+	}
+
+	report := BillingReport{
+		Month:       month,
+		Complete:    !now.Before(end),
+		GeneratedAt: now.UTC().Format(time.RFC3339),
+		Records:     []UsageRecord{},
+	}
+	row := func(tenant, description string, quantity float64, unit string) {
+		report.Records = append(report.Records, UsageRecord{
+			BillingPeriodStart: start.Format(time.RFC3339),
+			BillingPeriodEnd:   end.Format(time.RFC3339),
+			SubAccountId:       tenant,
+			ChargeDescription:  description,
+			ConsumedQuantity:   quantity,
+			ConsumedUnit:       unit,
+		})
+	}
+	for _, tenant := range sortedSet(tenants) {
+		var gbHours float64
+		var stored int64
+		for hour := start; hour.Before(until); hour = hour.Add(time.Hour) {
+			if sampled, ok := samples[tenant][hour.Format(billingHourFormat)]; ok {
+				stored = sampled
+			}
+			gbHours += float64(stored) / 1e9
+		}
+		counts := meters[tenant]
+		if counts == nil {
+			counts = &meterCounts{}
+		}
+		row(tenant, "Storage", gbHours, unitGBHours)
+		row(tenant, "Data transfer out", float64(counts.egress), unitBytes)
+		row(tenant, "API requests", float64(counts.requests), unitRequests)
+	}
+	return report, nil
+}
+
+// listBillingEntries calls each with the tenant and the dash-separated fields
+// of every entry's name under prefix.
+func listBillingEntries(ctx context.Context, prefix string, each func(tenant string, fields []string)) error {
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucketName),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, obj := range page.Contents {
+			tenant, name, ok := strings.Cut(strings.TrimPrefix(aws.ToString(obj.Key), prefix), "/")
+			if ok {
+				each(tenant, strings.Split(name, "-"))
+			}
+		}
+	}
+	return nil
+}
+
+func sortedSet(set map[string]bool) []string {
+	values := make([]string, 0, len(set))
+	for v := range set {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	return values
+}
+
+// storeBillingReport writes the report as JSON and CSV. Unless replace is
+// set, it leaves a report some other instance wrote alone and says so.
+func storeBillingReport(ctx context.Context, report BillingReport, replace bool) (bool, error) {
+	body, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return false, err
+	}
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(bucketName),
+		Key:         aws.String(billingReportKey(report.Month, "json")),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	}
+	if !replace {
+		input.IfNoneMatch = aws.String("*")
+	}
+	if _, err := s3Client.PutObject(ctx, input); isPreconditionFailed(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	_, err = s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucketName),
+		Key:         aws.String(billingReportKey(report.Month, "csv")),
+		Body:        bytes.NewReader(billingCSV(report)),
+		ContentType: aws.String("text/csv"),
+	})
+	return err == nil, err
+}
+
+func billingCSV(report BillingReport) []byte {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"BillingPeriodStart", "BillingPeriodEnd", "SubAccountId", "ChargeDescription", "ConsumedQuantity", "ConsumedUnit"})
+	for _, r := range report.Records {
+		w.Write([]string{r.BillingPeriodStart, r.BillingPeriodEnd, r.SubAccountId, r.ChargeDescription,
+			strconv.FormatFloat(r.ConsumedQuantity, 'f', -1, 64), r.ConsumedUnit})
+	}
+	w.Flush()
+	return buf.Bytes()
+}
+
+// pushBillingReport posts the report to the billing webhook, signed with an
+// HMAC-SHA256 of the body so the receiver can check where it came from.
+func pushBillingReport(ctx context.Context, report BillingReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	return postSigned(ctx, billingWebhookURL, currentSetting(&billingWebhookSecret), "X-Billing-Signature", body)
+}
+
+func requireBilling(w http.ResponseWriter) bool {
+	if !billingEnabled {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{
+			Error:   "Billing is disabled",
+			Details: "set BILLING=true to meter usage",
+		})
+		return false
+	}
+	return true
+}
+
+// createBillingReportHandler (re)builds a month's report in a job, for
+// months still running or to correct a finished one.
+func createBillingReportHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireBilling(w) {
+		return
+	}
+	req := BillingReportRequest{Month: time.Now().UTC().Format(billingMonthFormat)}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondInvalidBody(w, err)
+			return
+		}
+	}
+	if _, _, err := billingPeriod(req.Month); err != nil {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid month",
+			Details: err.Error(),
+		})
+		return
+	}
+	if req.Push && billingWebhookURL == "" {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Billing webhook is not configured",
+		})
+		return
+	}
+
+	job := startJob(context.Background(), "billing", func(ctx context.Context, job *Job) (map[string]string, error) {
+		// Include what this instance has counted but not yet written out
+		if err := meter.flush(ctx); err != nil {
+			return nil, err
+		}
+		report, err := buildBillingReport(ctx, req.Month, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		if _, err := storeBillingReport(ctx, report, true); err != nil {
+			return nil, err
+		}
+		job.addProgress(int64(len(report.Records)))
+		result := map[string]string{
+			"month":    report.Month,
+			"complete": strconv.FormatBool(report.Complete),
+			"json":     billingReportKey(report.Month, "json"),
+			"csv":      billingReportKey(report.Month, "csv"),
+		}
+		if req.Push {
+			if err := pushBillingReport(ctx, report); err != nil {
+				return nil, err
+			}
+			result["pushed"] = "true"
+		}
+		return result, nil
+	})
+	respondJSON(w, http.StatusAccepted, job.snapshot())
+}
+
+func getBillingReportHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireBilling(w) {
+		return
+	}
+	format := "json"
+	if r.URL.Query().Get("format") == "csv" {
+		format = "csv"
+	}
+	result, err := s3Client.GetObject(r.Context(), &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(billingReportKey(mux.Vars(r)["month"], format)),
+	})
+	if err != nil {
+		status, message := http.StatusInternalServerError, "Failed to read billing report"
+		if isNotFound(err) {
+			status, message = http.StatusNotFound, "Billing report not found"
+		}
+		respondJSON(w, status, ErrorResponse{
+			Error:   message,
+			Details: err.Error(),
+		})
+		return
+	}
+	defer result.Body.Close()
+
+	w.Header().Set("Content-Type", aws.ToString(result.ContentType))
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, result.Body)
+}
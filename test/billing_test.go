@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func usageOf(report BillingReport, tenant, unit string) float64 {
+	for _, r := range report.Records {
+		if r.SubAccountId == tenant && r.ConsumedUnit == unit {
+			return r.ConsumedQuantity
+		}
+	}
+	return -1
+}
+
+func TestBillingMetering(t *testing.T) {
+	override(t, &billingEnabled, true)
+	override(t, &meter, newBillingMeter())
+	override(t, &apiKeys, map[string]string{"acme-key": "acme", "globex-key": "globex"})
+	override(t, &adminToken, "admin-secret")
+	srv, _ := newTestServer(t)
+	acme := []string{"X-API-Key", "acme-key"}
+
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("a.txt", "hello world"), acme...), http.StatusOK)
+	download := call(t, srv, "GET", "/api/files/a.txt", nil, acme...)
+	expectStatus(t, download, http.StatusOK)
+	expectStatus(t, call(t, srv, "GET", "/api/files", nil, "X-API-Key", "globex-key"), http.StatusOK)
+	if err := meter.sample(context.Background(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	admin := []string{"Authorization", "Bearer admin-secret"}
+	var job Job
+	resp := call(t, srv, "POST", "/api/admin/billing/reports", nil, admin...)
+	expectStatus(t, resp, http.StatusAccepted)
+	resp.decode(t, &job)
+	if finished := waitJob(t, srv, job.ID); finished.Status != jobSucceeded || finished.Result["complete"] != "false" {
+		t.Fatalf("billing job %s: %s %v", finished.Status, finished.Error, finished.Result)
+	}
+
+	month := time.Now().UTC().Format(billingMonthFormat)
+	var report BillingReport
+	call(t, srv, "GET", "/api/admin/billing/reports/"+month, nil, admin...).decode(t, &report)
+	if got := usageOf(report, "acme", unitRequests); got != 2 {
+		t.Errorf("acme made %v requests", got)
+	}
+	if got := usageOf(report, "globex", unitRequests); got != 1 {
+		t.Errorf("globex made %v requests", got)
+	}
+	if got := usageOf(report, "acme", unitBytes); got < float64(len(download.body)) {
+		t.Errorf("acme egress %v, downloaded %d", got, len(download.body))
+	}
+	// One sampled hour of 11 bytes so far
+	if got := usageOf(report, "acme", unitGBHours); got != 11/1e9 {
+		t.Errorf("acme storage %v GB-hours", got)
+	}
+
+	csvReport := call(t, srv, "GET", "/api/admin/billing/reports/"+month+"?format=csv", nil, admin...)
+	if !strings.HasPrefix(string(csvReport.body), "BillingPeriodStart,BillingPeriodEnd,SubAccountId,") || csvReport.Header.Get("Content-Type") != "text/csv" {
+		t.Fatalf("csv report %s", csvReport.body)
+	}
+	expectStatus(t, call(t, srv, "GET", "/api/admin/billing/reports/2001-01", nil, admin...), http.StatusNotFound)
+	expectStatus(t, call(t, srv, "POST", "/api/admin/billing/reports", BillingReportRequest{Month: "May"}, admin...), http.StatusBadRequest)
+}
+
+// Hours without a sample are charged the storage of the latest earlier one.
+func TestBillingStorageHours(t *testing.T) {
+	_, fake := newTestServer(t)
+	for _, key := range []string{"acme/2024050100-2000000000", "acme/2024050110-1000000000", "acme/2024050110-1000000000x"} {
+		fake.PutObject(context.Background(), &s3.PutObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(billingSamplePrefix + "2024-05/" + key),
+		})
+	}
+
+	report, err := buildBillingReport(context.Background(), "2024-05", time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Complete || usageOf(report, "acme", unitGBHours) != 10*2+(31*24-10)*1 {
+		t.Fatalf("report %+v", report)
+	}
+	if r := report.Records[0]; r.BillingPeriodStart != "2024-05-01T00:00:00Z" || r.BillingPeriodEnd != "2024-06-01T00:00:00Z" {
+		t.Fatalf("period %+v", r)
+	}
+}
+
+func TestBillingMonthClose(t *testing.T) {
+	newTestServer(t)
+	var pushes []BillingReport
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte("hook-secret"))
+		mac.Write(body)
+		if r.Header.Get("X-Billing-Signature") != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var report BillingReport
+		json.Unmarshal(body, &report)
+		pushes = append(pushes, report)
+	}))
+	defer hook.Close()
+	override(t, &billingWebhookURL, hook.URL)
+	override(t, &billingWebhookSecret, "hook-secret")
+
+	// Only the first instance to close the month pushes it
+	for i := 0; i < 2; i++ {
+		if err := closeBillingMonth(context.Background(), "2024-05"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(pushes) != 1 || pushes[0].Month != "2024-05" || !pushes[0].Complete {
+		t.Fatalf("pushed %+v", pushes)
+	}
+}
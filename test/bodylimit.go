@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Largest request body accepted, in bytes, unless its content type has its
+// own limit. Uploads are base64 in JSON, so a file can be about three
+// quarters of this. A limit of 0 accepts any size.
+var maxBodyBytes = settings.Limits.MaxBodyBytes
+
+// Limits by the request's media type, e.g.
+// MAX_BODY_BYTES_BY_TYPE="application/json=20971520,text/*=1048576"
+var bodyLimitsByType = map[string]int64{}
+
+const bodyTooLargeMessage = "Request body too large"
+
+func init() {
+	for mediaType, value := range settings.Limits.MaxBodyBytesByType {
+		// Invalid limits are reported by main
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		bodyLimitsByType[strings.ToLower(mediaType)] = limit
+	}
+}
+
+// bodyLimit is the limit for a request with the given Content-Type: its own
+// media type's, then its type's wildcard's, then the global one.
+func bodyLimit(contentType string) int64 {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return maxBodyBytes
+	}
+	if limit, ok := bodyLimitsByType[mediaType]; ok {
+		return limit
+	}
+	major, _, _ := strings.Cut(mediaType, "/")
+	if limit, ok := bodyLimitsByType[major+"/*"]; ok {
+		return limit
+	}
+	return maxBodyBytes
+}
+
+// limitRequestBody rejects bodies declared larger than their limit up front,
+// and caps the rest so handlers decoding them fail instead of buffering
+// without bound.
+func limitRequestBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body == nil || r.Body == http.NoBody {
+			next.ServeHTTP(w, r)
+			return
+		}
+		limit := bodyLimit(r.Header.Get("Content-Type"))
+		if limit <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.ContentLength > limit {
+			respondJSON(w, http.StatusRequestEntityTooLarge, bodyTooLarge(limit))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func bodyTooLarge(limit int64) ErrorResponse {
+	return ErrorResponse{
+		Error:   bodyTooLargeMessage,
+		Details: fmt.Sprintf("the limit is %d bytes", limit),
+		Limit:   &LimitHint{Kind: limitBody, Bytes: limit},
+	}
+}
+
+// invalidBody describes a request body that failed to decode, telling a body
+// cut off at its size limit apart from a malformed one.
+func invalidBody(err error) ErrorResponse {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return bodyTooLarge(tooLarge.Limit)
+	}
+	return ErrorResponse{
+		Error:   "Invalid JSON",
+		Details: err.Error(),
+	}
+}
+
+// respondInvalidBody answers a body that failed to decode with 413 when it hit
+// its size limit and 400 otherwise.
+func respondInvalidBody(w http.ResponseWriter, err error) {
+	problem := invalidBody(err)
+	respondJSON(w, problemStatus(problem), problem)
+}
+
+func problemStatus(problem ErrorResponse) int {
+	if problem.Error == bodyTooLargeMessage {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
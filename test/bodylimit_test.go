@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestBodyLimits(t *testing.T) {
+	srv, fake := newTestServer(t)
+	override(t, &maxBodyBytes, 256)
+	override(t, &bodyLimitsByType, map[string]int64{"text/*": 16})
+
+	mustUpload(t, srv, "/api", "small.txt", "fits")
+
+	big := upload("big.txt", strings.Repeat("x", 512))
+	resp := call(t, srv, "POST", "/api/upload", big)
+	expectStatus(t, resp, http.StatusRequestEntityTooLarge)
+	if msg := resp.errorMessage(t); msg != bodyTooLargeMessage {
+		t.Fatalf("error %q", msg)
+	}
+
+	// Without a Content-Length the body is cut off while it is decoded
+	body := `{"filename":"big.txt","content":"` + strings.Repeat("eHh4", 100) + `"}`
+	req, err := http.NewRequest("POST", srv.URL+"/api/upload", struct{ io.Reader }{strings.NewReader(body)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	chunked, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunked.Body.Close()
+	if chunked.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("chunked upload: got %d", chunked.StatusCode)
+	}
+	for _, key := range fake.Keys(bucketName) {
+		if key == "big.txt" {
+			t.Fatal("oversized upload was stored")
+		}
+	}
+
+	expectStatus(t, call(t, srv, "POST", "/api/folders", `{"path":"`+strings.Repeat("a", 300)+`"}`), http.StatusRequestEntityTooLarge)
+	expectStatus(t, call(t, srv, "POST", "/api/folders", FolderRequest{Path: "reports"}), http.StatusCreated)
+}
+
+func TestBodyLimit(t *testing.T) {
+	override(t, &maxBodyBytes, 100)
+	override(t, &bodyLimitsByType, map[string]int64{"application/json": 50, "text/*": 10})
+
+	tests := []struct {
+		contentType string
+		want        int64
+	}{
+		{"application/json; charset=utf-8", 50},
+		{"text/csv", 10},
+		{"application/octet-stream", 100},
+		{"", 100},
+	}
+	for _, tt := range tests {
+		if got := bodyLimit(tt.contentType); got != tt.want {
+			t.Errorf("bodyLimit(%q) = %d, want %d", tt.contentType, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+var (
+	// After STORAGE_BREAKER_THRESHOLD consecutive storage failures, calls fail
+	// fast for STORAGE_BREAKER_COOLDOWN before a single probe is let through.
+	breakerThreshold = intFromEnv("STORAGE_BREAKER_THRESHOLD", 5)
+	breakerCooldown  = durationFromEnv("STORAGE_BREAKER_COOLDOWN", 30*time.Second)
+
+	// Retry-After hints never exceed this
+	maxRetryAfter = durationFromEnv("RETRY_AFTER_MAX", time.Minute)
+)
+
+var errStorageUnavailable = errors.New("storage unavailable: circuit breaker open")
+
+// Reasons given in retry hints.
+const (
+	retryStorageUnavailable = "storage_unavailable"
+	retryStorageThrottled   = "storage_throttled"
+)
+
+// RetryHint tells clients how long to back off before retrying a 5xx.
+type RetryHint struct {
+	AfterSeconds int    `json:"after_seconds"`
+	Reason       string `json:"reason"`
+}
+
+// storageBreaker tracks the health of storage calls. It opens after a run of
+// failures and remembers recent throttling, and both feed the Retry-After
+// hints on error responses.
+type storageBreaker struct {
+	mu          sync.Mutex
+	failures    int
+	openedAt    time.Time
+	probing     bool
+	throttles   int
+	throttledAt time.Time
+}
+
+var breaker = &storageBreaker{}
+
+// allow returns errStorageUnavailable while the breaker is open. Once the
+// cooldown passes one call is let through to probe storage.
+func (b *storageBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openedAt.IsZero() {
+		return nil
+	}
+	if b.probing || time.Since(b.openedAt) < breakerCooldown {
+		return errStorageUnavailable
+	}
+	b.probing = true
+	return nil
+}
+
+type outcome int
+
+const (
+	outcomeSuccess outcome = iota
+	outcomeFailure
+	outcomeThrottled
+	outcomeIgnored
+)
+
+var throttleCodes = map[string]bool{
+	"SlowDown":                 true,
+	"Throttling":               true,
+	"ThrottlingException":      true,
+	"RequestLimitExceeded":     true,
+	"RequestThrottled":         true,
+	"TooManyRequestsException": true,
+}
+
+// classify decides what a storage call's error says about storage health.
+// Client errors such as a missing key mean storage is answering fine.
+func classify(err error) outcome {
+	if err == nil {
+		return outcomeSuccess
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, errStorageUnavailable) {
+		return outcomeIgnored
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && throttleCodes[apiErr.ErrorCode()] {
+		return outcomeThrottled
+	}
+	var withStatus interface{ HTTPStatusCode() int }
+	if errors.As(err, &withStatus) {
+		switch status := withStatus.HTTPStatusCode(); {
+		case status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable:
+			return outcomeThrottled
+		case status >= 500:
+			return outcomeFailure
+		case status > 0:
+			return outcomeSuccess
+		}
+	}
+	if apiErr != nil && apiErr.ErrorFault() != smithy.FaultServer {
+		return outcomeSuccess
+	}
+	// Network errors, timeouts and server faults
+	return outcomeFailure
+}
+
+func (b *storageBreaker) record(err error) {
+	result := classify(err)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wasProbe := b.probing
+	b.probing = false
+
+	switch result {
+	case outcomeIgnored:
+		return
+	case outcomeSuccess:
+		b.failures, b.throttles = 0, 0
+		b.openedAt = time.Time{}
+		return
+	case outcomeThrottled:
+		b.throttles++
+		b.throttledAt = time.Now()
+	}
+
+	b.failures++
+	if wasProbe || (b.openedAt.IsZero() && b.failures >= breakerThreshold) {
+		b.openedAt = time.Now()
+	}
+}
+
+// retryHint says how long clients should wait while storage is degraded, or
+// returns nil when it isn't.
+func (b *storageBreaker) retryHint() *RetryHint {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var wait time.Duration
+	var reason string
+	if !b.openedAt.IsZero() {
+		wait, reason = breakerCooldown-time.Since(b.openedAt), retryStorageUnavailable
+	} else if b.throttles > 0 {
+		// Exponential in the length of the current run of throttled calls,
+		// counted from the last one
+		backoff := time.Second * time.Duration(math.Pow(2, float64(b.throttles-1)))
+		wait, reason = backoff-time.Since(b.throttledAt), retryStorageThrottled
+	}
+	if reason == "" || (reason == retryStorageThrottled && wait <= 0) {
+		return nil
+	}
+
+	if wait > maxRetryAfter {
+		wait = maxRetryAfter
+	}
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return &RetryHint{AfterSeconds: seconds, Reason: reason}
+}
+
+// withRetryHint turns a 500 into a 503 with a Retry-After header and a hint
+// in the body while storage is degraded, since the failure is most likely
+// down to storage.
+func withRetryHint(w http.ResponseWriter, status int, data interface{}) (int, interface{}) {
+	if status < 500 {
+		return status, data
+	}
+	hint := breaker.retryHint()
+	if hint == nil {
+		return status, data
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(hint.AfterSeconds))
+	if e, ok := data.(ErrorResponse); ok {
+		e.Retry = hint
+		data = e
+	}
+	if status == http.StatusInternalServerError {
+		status = http.StatusServiceUnavailable
+	}
+	return status, data
+}
+
+// breakerS3 passes calls through to storage while the breaker allows them
+// and reports each outcome back to it.
+type breakerS3 struct {
+	s3API
+	breaker *storageBreaker
+}
+
+func withBreaker(client s3API) s3API {
+	return breakerS3{s3API: client, breaker: breaker}
+}
+
+func guarded[In, Out any](b *storageBreaker, call func(context.Context, In, ...func(*s3.Options)) (Out, error), ctx context.Context, in In, opts []func(*s3.Options)) (Out, error) {
+	if err := b.allow(); err != nil {
+		var zero Out
+		return zero, err
+	}
+	out, err := call(ctx, in, opts...)
+	b.record(err)
+	return out, err
+}
+
+func (c breakerS3) PutObject(ctx context.Context, in *s3.PutObjectInput, opts ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	return guarded(c.breaker, c.s3API.PutObject, ctx, in, opts)
+}
+
+func (c breakerS3) UploadPart(ctx context.Context, in *s3.UploadPartInput, opts ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	return guarded(c.breaker, c.s3API.UploadPart, ctx, in, opts)
+}
+
+func (c breakerS3) CreateMultipartUpload(ctx context.Context, in *s3.CreateMultipartUploadInput, opts ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	return guarded(c.breaker, c.s3API.CreateMultipartUpload, ctx, in, opts)
+}
+
+func (c breakerS3) CompleteMultipartUpload(ctx context.Context, in *s3.CompleteMultipartUploadInput, opts ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	return guarded(c.breaker, c.s3API.CompleteMultipartUpload, ctx, in, opts)
+}
+
+func (c breakerS3) AbortMultipartUpload(ctx context.Context, in *s3.AbortMultipartUploadInput, opts ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	return guarded(c.breaker, c.s3API.AbortMultipartUpload, ctx, in, opts)
+}
+
+func (c breakerS3) GetObject(ctx context.Context, in *s3.GetObjectInput, opts ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	return guarded(c.breaker, c.s3API.GetObject, ctx, in, opts)
+}
+
+func (c breakerS3) HeadObject(ctx context.Context, in *s3.HeadObjectInput, opts ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	return guarded(c.breaker, c.s3API.HeadObject, ctx, in, opts)
+}
+
+func (c breakerS3) CopyObject(ctx context.Context, in *s3.CopyObjectInput, opts ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	return guarded(c.breaker, c.s3API.CopyObject, ctx, in, opts)
+}
+
+func (c breakerS3) DeleteObject(ctx context.Context, in *s3.DeleteObjectInput, opts ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	return guarded(c.breaker, c.s3API.DeleteObject, ctx, in, opts)
+}
+
+func (c breakerS3) DeleteObjects(ctx context.Context, in *s3.DeleteObjectsInput, opts ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	return guarded(c.breaker, c.s3API.DeleteObjects, ctx, in, opts)
+}
+
+func (c breakerS3) ListObjectsV2(ctx context.Context, in *s3.ListObjectsV2Input, opts ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	return guarded(c.breaker, c.s3API.ListObjectsV2, ctx, in, opts)
+}
+
+func (c breakerS3) ListObjectVersions(ctx context.Context, in *s3.ListObjectVersionsInput, opts ...func(*s3.Options)) (*s3.ListObjectVersionsOutput, error) {
+	return guarded(c.breaker, c.s3API.ListObjectVersions, ctx, in, opts)
+}
+
+func (c breakerS3) GetObjectTagging(ctx context.Context, in *s3.GetObjectTaggingInput, opts ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error) {
+	return guarded(c.breaker, c.s3API.GetObjectTagging, ctx, in, opts)
+}
+
+func (c breakerS3) GetObjectLegalHold(ctx context.Context, in *s3.GetObjectLegalHoldInput, opts ...func(*s3.Options)) (*s3.GetObjectLegalHoldOutput, error) {
+	return guarded(c.breaker, c.s3API.GetObjectLegalHold, ctx, in, opts)
+}
+
+func (c breakerS3) PutObjectLegalHold(ctx context.Context, in *s3.PutObjectLegalHoldInput, opts ...func(*s3.Options)) (*s3.PutObjectLegalHoldOutput, error) {
+	return guarded(c.breaker, c.s3API.PutObjectLegalHold, ctx, in, opts)
+}
+
+func (c breakerS3) GetBucketVersioning(ctx context.Context, in *s3.GetBucketVersioningInput, opts ...func(*s3.Options)) (*s3.GetBucketVersioningOutput, error) {
+	return guarded(c.breaker, c.s3API.GetBucketVersioning, ctx, in, opts)
+}
+
+func (c breakerS3) HeadBucket(ctx context.Context, in *s3.HeadBucketInput, opts ...func(*s3.Options)) (*s3.HeadBucketOutput, error) {
+	return guarded(c.breaker, c.s3API.HeadBucket, ctx, in, opts)
+}
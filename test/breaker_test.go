@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+
+	"test-api/internal/fakes3"
+)
+
+// flakyS3 fails reads with err while it is set.
+type flakyS3 struct {
+	*fakes3.Client
+	err error
+}
+
+func (c *flakyS3) GetObject(ctx context.Context, in *s3.GetObjectInput, opts ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.Client.GetObject(ctx, in, opts...)
+}
+
+func newFlakyServer(t *testing.T) (*flakyS3, func(string) response) {
+	t.Helper()
+	srv, fake := newTestServer(t)
+	flaky := &flakyS3{Client: fake}
+	override(t, &breaker, &storageBreaker{})
+	override(t, &s3Client, withBreaker(flaky))
+	mustUpload(t, srv, "/api", "a.txt", "hello")
+	return flaky, func(path string) response {
+		return call(t, srv, "GET", path, nil)
+	}
+}
+
+func TestBreakerOpensWithRetryAfter(t *testing.T) {
+	override(t, &breakerThreshold, 3)
+	override(t, &breakerCooldown, 20*time.Second)
+	flaky, get := newFlakyServer(t)
+
+	flaky.err = errors.New("connection reset by peer")
+	for i := 0; i < 2; i++ {
+		got := get("/api/files/a.txt")
+		expectStatus(t, got, http.StatusInternalServerError)
+		if got.Header.Get("Retry-After") != "" {
+			t.Fatalf("Retry-After before the breaker opened, call %d", i)
+		}
+	}
+	// The failure that opens the breaker already carries the hint
+	expectStatus(t, get("/api/files/a.txt"), http.StatusServiceUnavailable)
+
+	// Fails fast without reaching storage, even though it has recovered
+	flaky.err = nil
+	got := get("/api/files/a.txt")
+	expectStatus(t, got, http.StatusServiceUnavailable)
+	if after := got.Header.Get("Retry-After"); after != "20" {
+		t.Fatalf("Retry-After %q", after)
+	}
+	var body ErrorResponse
+	got.decode(t, &body)
+	if body.Retry == nil || body.Retry.AfterSeconds != 20 || body.Retry.Reason != retryStorageUnavailable {
+		t.Fatalf("body %+v", body)
+	}
+
+	// Not-found is storage answering, so it doesn't count against it
+	breaker.openedAt = time.Now().Add(-time.Minute)
+	expectStatus(t, get("/api/files/missing.txt"), http.StatusNotFound)
+	expectStatus(t, get("/api/files/a.txt"), http.StatusOK)
+}
+
+func TestBreakerFailedProbeReopens(t *testing.T) {
+	override(t, &breakerThreshold, 1)
+	flaky, get := newFlakyServer(t)
+
+	flaky.err = errors.New("i/o timeout")
+	expectStatus(t, get("/api/files/a.txt"), http.StatusServiceUnavailable)
+	breaker.openedAt = time.Now().Add(-time.Hour)
+
+	expectStatus(t, get("/api/files/a.txt"), http.StatusServiceUnavailable)
+	if breaker.openedAt.Before(time.Now().Add(-time.Minute)) {
+		t.Fatal("failed probe didn't restart the cooldown")
+	}
+}
+
+func TestThrottlingBacksOffExponentially(t *testing.T) {
+	override(t, &breakerThreshold, 100)
+	override(t, &maxRetryAfter, 4*time.Second)
+	flaky, get := newFlakyServer(t)
+
+	flaky.err = &smithy.GenericAPIError{Code: "SlowDown", Message: "Please reduce your request rate."}
+	var hints []string
+	for i := 0; i < 4; i++ {
+		got := get("/api/files/a.txt")
+		expectStatus(t, got, http.StatusServiceUnavailable)
+		hints = append(hints, got.Header.Get("Retry-After"))
+	}
+	// 1s, 2s, 4s, then capped
+	if want := []string{"1", "2", "4", "4"}; !reflect.DeepEqual(hints, want) {
+		t.Fatalf("Retry-After %v, want %v", hints, want)
+	}
+
+	flaky.err = nil
+	expectStatus(t, get("/api/files/a.txt"), http.StatusOK)
+	if hint := breaker.retryHint(); hint != nil {
+		t.Fatalf("hint %+v after recovery", hint)
+	}
+}
+
+func TestClassifyStorageErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want outcome
+	}{
+		{"success", nil, outcomeSuccess},
+		{"missing key", &smithy.GenericAPIError{Code: "NoSuchKey"}, outcomeSuccess},
+		{"throttled", &smithy.GenericAPIError{Code: "ThrottlingException"}, outcomeThrottled},
+		{"server fault", &smithy.GenericAPIError{Code: "InternalError", Fault: smithy.FaultServer}, outcomeFailure},
+		{"network", errors.New("dial tcp: connection refused"), outcomeFailure},
+		{"canceled", context.Canceled, outcomeIgnored},
+		{"breaker open", errStorageUnavailable, outcomeIgnored},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classify(tt.err); got != tt.want {
+				t.Fatalf("classify(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
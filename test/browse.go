@@ -0,0 +1,230 @@
+package main
+
+import (
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+)
+
+// The browse routes serve the files as a plain web server would: a directory
+// per folder, linked relatively, and files with the headers a download
+// manager or rclone's http backend relies on (Content-Length, Last-Modified,
+// ETag and byte ranges).
+var browseIndex = template.Must(template.New("browse").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Index of /{{.Path}}</title>
+</head>
+<body>
+<h1>Index of /{{.Path}}</h1>
+<table>
+<tr><th>Name</th><th>Size</th><th>Last modified</th></tr>
+{{if .Path}}<tr><td><a href="../">../</a></td><td></td><td></td></tr>
+{{end}}{{range .Entries}}<tr><td><a href="{{.Href}}">{{.Name}}</a></td><td>{{if not .Dir}}{{.Size}}{{end}}</td><td>{{.Modified}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+type browseEntry struct {
+	Name     string
+	Href     string
+	Dir      bool
+	Size     int64
+	Modified string
+}
+
+// browseHandler lists a folder when the path ends with a slash, and serves
+// the file otherwise. A folder asked for without its slash is redirected, so
+// relative links in its index resolve.
+func browseHandler(w http.ResponseWriter, r *http.Request) {
+	p := mux.Vars(r)["path"]
+	if p != "" {
+		clean, err := sanitizePrefix(p)
+		if err != nil {
+			respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid path", Details: err.Error()})
+			return
+		}
+		p = clean
+	}
+	if p == "" || strings.HasSuffix(p, "/") {
+		browseFolder(w, r, p)
+		return
+	}
+	browseFile(w, r, p)
+}
+
+// headBrowseHandler answers HEAD for the browse routes, which clients use to
+// size and date a file before fetching it.
+func headBrowseHandler(w http.ResponseWriter, r *http.Request) {
+	browseHandler(w, r)
+}
+
+func browseFolder(w http.ResponseWriter, r *http.Request, prefix string) {
+	ns := requestNamespace(r)
+	var entries []browseEntry
+	var names []string
+	files := map[string]browseEntry{}
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(ns.Bucket),
+		Prefix:    aws.String(ns.key(prefix)),
+		Delimiter: aws.String("/"),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(r.Context())
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+				Error:   "Failed to list files",
+				Details: err.Error(),
+			})
+			return
+		}
+		for _, common := range page.CommonPrefixes {
+			dir := ns.name(aws.ToString(common.Prefix))
+			name := strings.TrimPrefix(dir, prefix)
+			if isReservedKey(dir) || strings.HasPrefix(name, ".") {
+				continue
+			}
+			entries = append(entries, browseEntry{Name: name, Href: (&url.URL{Path: name}).EscapedPath(), Dir: true})
+		}
+		for _, obj := range page.Contents {
+			name := ns.name(aws.ToString(obj.Key))
+			if isReservedKey(name) || strings.HasSuffix(name, "/") {
+				continue
+			}
+			names = append(names, name)
+			base := strings.TrimPrefix(name, prefix)
+			files[name] = browseEntry{
+				Name:     base,
+				Href:     (&url.URL{Path: base}).EscapedPath(),
+				Size:     aws.ToInt64(obj.Size),
+				Modified: aws.ToTime(obj.LastModified).UTC().Format(http.TimeFormat),
+			}
+		}
+	}
+
+	readable, err := readableNames(r.Context(), requestSubject(r), names)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to check access",
+			Details: err.Error(),
+		})
+		return
+	}
+	if prefix != "" && len(entries) == 0 && len(names) == 0 {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "Folder not found"})
+		return
+	}
+	for _, name := range readable {
+		entries = append(entries, files[name])
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	enableCORS(w)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+	browseIndex.Execute(w, struct {
+		Path    string
+		Entries []browseEntry
+	}{prefix, entries})
+}
+
+func browseFile(w http.ResponseWriter, r *http.Request, filename string) {
+	ns := requestNamespace(r)
+	key := ns.key(filename)
+	if !authorizeFile(w, r, key, permRead) {
+		return
+	}
+	head, err := s3Client.HeadObject(r.Context(), &s3.HeadObjectInput{
+		Bucket: aws.String(ns.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			browseMissing(w, r, filename)
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to read file",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	headers := func() {
+		w.Header().Set("Content-Type", publicContentType(filename, aws.ToString(head.ContentType)))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Security-Policy", "sandbox")
+		if head.LastModified != nil {
+			w.Header().Set("Last-Modified", head.LastModified.UTC().Format(http.TimeFormat))
+		}
+	}
+	if r.Method != http.MethodHead && r.Header.Get("Range") != "" && serveRange(w, r, ns.Bucket, key, head, headers) {
+		return
+	}
+	size, known := contentSize(head)
+
+	enableCORS(w)
+	headers()
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("ETag", aws.ToString(head.ETag))
+	if etagMatches(r.Header.Get("If-None-Match"), aws.ToString(head.ETag)) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if known {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	result, err := getObject(r.Context(), &s3.GetObjectInput{
+		Bucket:  aws.String(ns.Bucket),
+		Key:     aws.String(key),
+		IfMatch: head.ETag,
+	})
+	if err != nil {
+		w.Header().Del("Content-Length")
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to read file",
+			Details: err.Error(),
+		})
+		return
+	}
+	defer result.Body.Close()
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, result.Body); err != nil {
+		slog.WarnContext(r.Context(), "Download interrupted", "key", key, "err", err)
+	}
+}
+
+// browseMissing redirects a folder asked for without its trailing slash, and
+// answers 404 for anything else.
+func browseMissing(w http.ResponseWriter, r *http.Request, filename string) {
+	ns := requestNamespace(r)
+	out, err := s3Client.ListObjectsV2(r.Context(), &s3.ListObjectsV2Input{
+		Bucket:  aws.String(ns.Bucket),
+		Prefix:  aws.String(ns.key(filename + "/")),
+		MaxKeys: aws.Int32(1),
+	})
+	if err == nil && len(out.Contents) > 0 {
+		http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+		return
+	}
+	respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "File not found"})
+}
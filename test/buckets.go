@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"sort"
+
+	"github.com/gorilla/mux"
+)
+
+// Named buckets the API may front besides the files bucket, served under
+// /api/buckets/{name}/..., e.g. BUCKETS="uploads=acme-uploads,public-assets=acme-public"
+var namedBuckets = parseAssignments(os.Getenv("BUCKETS"))
+
+type BucketsResponse struct {
+	Buckets []string `json:"buckets"`
+}
+
+func namedBucketNames() []string {
+	names := make([]string, 0, len(namedBuckets))
+	for name := range namedBuckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// namespaceInBucket is tenant's namespace within a named bucket. Named buckets
+// are shared, so with tenancy enabled every tenant gets its prefix in them,
+// including tenants that have a dedicated bucket of their own.
+func namespaceInBucket(tenant, bucket string) namespace {
+	if !tenancyEnabled() {
+		return namespace{Tenant: defaultTenant, Bucket: bucket}
+	}
+	return sharedNamespace(tenant, bucket)
+}
+
+// bucketMiddleware resolves the {bucket} route variable against the allowlist
+// and rescopes the request to the tenant's namespace in that bucket.
+func bucketMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bucket, ok := namedBuckets[mux.Vars(r)["bucket"]]
+		if !ok {
+			respondJSON(w, http.StatusNotFound, ErrorResponse{
+				Error: "Bucket not found",
+			})
+			return
+		}
+
+		ns := namespaceInBucket(requestTenant(r), bucket)
+		next.ServeHTTP(w, r.WithContext(withNamespace(r.Context(), ns)))
+	})
+}
+
+func listBucketsHandler(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, BucketsResponse{Buckets: namedBucketNames()})
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/gorilla/mux"
+)
+
+// A remote build cache speaking Bazel's HTTP cache protocol, which Gradle's
+// HTTP build cache also fits: entries are fetched with GET and stored with
+// PUT at /api/build-cache/<kind>/<hash>, where the kind is ac (Bazel action
+// results), cas (Bazel outputs, named by their SHA-256) or gradle. Entries
+// live in the tenant's namespace under buildCachePrefix.
+const buildCachePrefix = ".build-cache/"
+
+var (
+	buildCacheEnabled, _ = strconv.ParseBool(os.Getenv("BUILD_CACHE"))
+
+	// Entries not used for this long are evicted, and then the least
+	// recently used until a tenant's cache fits BUILD_CACHE_MAX_BYTES
+	buildCacheMaxAge        = durationFromEnv("BUILD_CACHE_MAX_AGE", 30*24*time.Hour)
+	buildCacheMaxBytes      = int64(intFromEnv("BUILD_CACHE_MAX_BYTES", 0))
+	buildCacheSweepInterval = durationFromEnv("BUILD_CACHE_SWEEP_INTERVAL", time.Hour)
+
+	// S3 doesn't record reads, so a hit rewrites an entry to mark it used,
+	// at most this often
+	buildCacheTouchAfter = durationFromEnv("BUILD_CACHE_TOUCH_AFTER", 24*time.Hour)
+
+	buildCacheRequests  = newCounterVec("build_cache_requests_total", "Build cache lookups, by kind and result: hit or miss.", "kind", "result")
+	buildCacheEvictions = newCounterVec("build_cache_evictions_total", "Build cache entries evicted, by reason: age or size.", "reason")
+)
+
+// Bazel's digests and Gradle's keys are hex hashes
+var buildCacheHash = regexp.MustCompile(`^[0-9a-f]{32,128}$`)
+
+func buildCacheKey(ns namespace, kind, hash string) string {
+	return ns.key(buildCachePrefix + kind + "/" + hash)
+}
+
+// requireBuildCache answers 404 unless the build cache is on, and checks
+// the entry's hash.
+func requireBuildCache(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !buildCacheEnabled {
+			respondJSON(w, http.StatusNotFound, ErrorResponse{
+				Error: "Build cache is disabled",
+			})
+			return
+		}
+		if !buildCacheHash.MatchString(mux.Vars(r)["hash"]) {
+			respondJSON(w, http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid cache key",
+				Details: "want 32 to 128 lowercase hex digits",
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func getBuildCacheHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	ns := requestNamespace(r)
+	kind := mux.Vars(r)["kind"]
+	key := buildCacheKey(ns, kind, mux.Vars(r)["hash"])
+
+	result, err := getObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(ns.Bucket),
+		Key:    aws.String(key),
+	})
+	if isNotFound(err) {
+		buildCacheRequests.add(1, kind, "miss")
+		respondJSON(w, http.StatusNotFound, ErrorResponse{
+			Error: "Not in the cache",
+		})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to read the cache",
+			Details: err.Error(),
+		})
+		return
+	}
+	defer result.Body.Close()
+	buildCacheRequests.add(1, kind, "hit")
+	countCacheHit(ctx)
+	if time.Since(aws.ToTime(result.LastModified)) > buildCacheTouchAfter {
+		touchBuildCacheEntry(ctx, key, result.Metadata)
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if result.ContentLength != nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(aws.ToInt64(result.ContentLength), 10))
+	}
+	if _, err := io.Copy(w, result.Body); err != nil {
+		slog.WarnContext(ctx, "Build cache download interrupted", "key", key, "err", err)
+	}
+}
+
+// touchBuildCacheEntry copies an entry onto itself, which renews its last
+// modified time, so eviction sees it as recently used. The entry's metadata
+// is kept, as it records how the entry is stored.
+func touchBuildCacheEntry(ctx context.Context, key string, metadata map[string]string) {
+	bucket := bucketFor(ctx)
+	touched := map[string]string{}
+	for k, v := range metadata {
+		touched[k] = v
+	}
+	touched["used-at"] = time.Now().UTC().Format(time.RFC3339)
+	if _, err := s3Client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:            aws.String(bucket),
+		Key:               aws.String(key),
+		CopySource:        aws.String(bucket + "/" + url.PathEscape(key)),
+		ContentType:       aws.String("application/octet-stream"),
+		Metadata:          touched,
+		MetadataDirective: types.MetadataDirectiveReplace,
+	}); err != nil {
+		slog.WarnContext(ctx, "Failed to mark build cache entry used", "key", key, "err", err)
+	}
+}
+
+// putBuildCacheHandler stores an entry. Blobs in the CAS are checked
+// against their name, so a bad client can't poison other builds; action
+// results can't be checked.
+func putBuildCacheHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	ns := requestNamespace(r)
+	kind, hash := mux.Vars(r)["kind"], mux.Vars(r)["hash"]
+
+	// Bounded by limitRequestBody
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondInvalidBody(w, err)
+		return
+	}
+	if kind == "cas" && len(hash) == sha256.Size*2 {
+		if sum := sha256.Sum256(body); hex.EncodeToString(sum[:]) != hash {
+			respondJSON(w, http.StatusBadRequest, ErrorResponse{
+				Error:   "Content doesn't match its digest",
+				Details: fmt.Sprintf("SHA-256 is %x", sum),
+			})
+			return
+		}
+	}
+
+	// Entries count against the tenant's storage like its files do
+	if !checkObjectSize(w, ns.Tenant, int64(len(body)), false) {
+		return
+	}
+	if err := checkQuota(ctx, ns.Tenant, int64(len(body))); err != nil {
+		var exceeded errQuotaExceeded
+		if errors.As(err, &exceeded) {
+			respondJSON(w, http.StatusRequestEntityTooLarge, ErrorResponse{
+				Error:   "Storage quota exceeded",
+				Details: err.Error(),
+				Limit:   &LimitHint{Kind: limitQuota, Bytes: exceeded.limit, Scope: exceeded.scope, Size: exceeded.size},
+			})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to check quota",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	if _, err := putObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(ns.Bucket),
+		Key:         aws.String(buildCacheKey(ns, kind, hash)),
+		ContentType: aws.String("application/octet-stream"),
+	}, body); err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to write the cache",
+			Details: err.Error(),
+		})
+		return
+	}
+	usage.add(ns.Tenant, int64(len(body)), 1)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// evictBuildCache removes the namespace's entries that have gone unused
+// for BUILD_CACHE_MAX_AGE, then the least recently used of the rest while
+// the cache is over BUILD_CACHE_MAX_BYTES.
+func evictBuildCache(ctx context.Context) error {
+	ns := namespaceFrom(ctx)
+	var entries []types.Object
+	input := &s3.ListObjectsV2Input{Bucket: aws.String(ns.Bucket), Prefix: aws.String(ns.key(buildCachePrefix))}
+	for {
+		page, err := s3Client.ListObjectsV2(ctx, input)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, page.Contents...)
+		if !aws.ToBool(page.IsTruncated) {
+			break
+		}
+		input.ContinuationToken = page.NextContinuationToken
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return aws.ToTime(entries[i].LastModified).Before(aws.ToTime(entries[j].LastModified))
+	})
+
+	var total int64
+	for _, obj := range entries {
+		total += aws.ToInt64(obj.Size)
+	}
+	cutoff := time.Now().Add(-buildCacheMaxAge)
+	var evict []string
+	var byAge int
+	for _, obj := range entries {
+		stale := buildCacheMaxAge > 0 && aws.ToTime(obj.LastModified).Before(cutoff)
+		if !stale && !(buildCacheMaxBytes > 0 && total > buildCacheMaxBytes) {
+			break
+		}
+		if stale {
+			byAge++
+		}
+		evict = append(evict, aws.ToString(obj.Key))
+		total -= aws.ToInt64(obj.Size)
+	}
+	if len(evict) == 0 {
+		return nil
+	}
+
+	failures, err := deleteKeys(ctx, evict)
+	if err != nil {
+		return err
+	}
+	bySize := len(evict) - byAge
+	buildCacheEvictions.add(float64(byAge), "age")
+	buildCacheEvictions.add(float64(bySize), "size")
+	slog.InfoContext(ctx, "Evicted build cache entries", "tenant", ns.Tenant, "unused", byAge, "over_size", bySize, "failed", len(failures))
+	return nil
+}
+
+func startBuildCacheEvictor(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(buildCacheSweepInterval)
+		defer ticker.Stop()
+
+		for {
+			namespaces, err := knownNamespaces(ctx)
+			if err != nil {
+				slog.ErrorContext(ctx, "Build cache eviction failed to list tenants", "err", err)
+			}
+			for _, ns := range namespaces {
+				if err := evictBuildCache(withNamespace(ctx, ns)); err != nil {
+					slog.ErrorContext(ctx, "Build cache eviction failed", "tenant", ns.Tenant, "err", err)
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
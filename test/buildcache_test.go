@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func digest(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestBuildCache(t *testing.T) {
+	srv, _ := newTestServer(t)
+	blob := "/api/build-cache/cas/" + digest("object file")
+	expectStatus(t, call(t, srv, "GET", blob, nil), http.StatusNotFound)
+
+	override(t, &buildCacheEnabled, true)
+	expectStatus(t, call(t, srv, "GET", blob, nil), http.StatusNotFound)
+	expectStatus(t, call(t, srv, "PUT", blob, "object file"), http.StatusCreated)
+	got := call(t, srv, "GET", blob, nil)
+	expectStatus(t, got, http.StatusOK)
+	if string(got.body) != "object file" {
+		t.Fatalf("blob %q", got.body)
+	}
+
+	// The CAS is checked against the digest; action results and Gradle
+	// entries can't be
+	expectStatus(t, call(t, srv, "PUT", "/api/build-cache/cas/"+digest("other"), "object file"), http.StatusBadRequest)
+	expectStatus(t, call(t, srv, "PUT", "/api/build-cache/ac/"+digest("action"), "result"), http.StatusCreated)
+	expectStatus(t, call(t, srv, "PUT", "/api/build-cache/gradle/0a1b2c3d4e5f60718293a4b5c6d7e8f9", "outputs"), http.StatusCreated)
+	expectStatus(t, call(t, srv, "GET", "/api/build-cache/gradle/0a1b2c3d4e5f60718293a4b5c6d7e8f9", nil), http.StatusOK)
+	expectStatus(t, call(t, srv, "GET", "/api/build-cache/ac/not-a-hash", nil), http.StatusBadRequest)
+
+	var files FilesResponse
+	call(t, srv, "GET", "/api/files", nil).decode(t, &files)
+	if len(files.Files) != 0 {
+		t.Fatalf("cache entries listed as files: %+v", files.Files)
+	}
+
+	samples := scrape(t, call(t, srv, "GET", "/metrics", nil).body)
+	for _, name := range []string{
+		`build_cache_requests_total{kind="cas",result="hit"}`,
+		`build_cache_requests_total{kind="cas",result="miss"}`,
+		`build_cache_requests_total{kind="gradle",result="hit"}`,
+	} {
+		if v, ok := samples[name]; !ok || v == "0" {
+			t.Errorf("%s = %q", name, v)
+		}
+	}
+}
+
+func TestBuildCacheBasicAuth(t *testing.T) {
+	srv, _ := newTestServer(t)
+	override(t, &buildCacheEnabled, true)
+	override(t, &apiKeys, map[string]string{"acme-key": "acme"})
+
+	entry := "/api/build-cache/gradle/" + digest("task")
+	expectStatus(t, call(t, srv, "PUT", entry, "outputs"), http.StatusUnauthorized)
+	req, _ := http.NewRequest("PUT", srv.URL+entry, strings.NewReader("outputs"))
+	req.SetBasicAuth("gradle", "acme-key")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("PUT with Basic auth: %d", resp.StatusCode)
+	}
+	expectStatus(t, call(t, srv, "GET", entry, nil, "X-API-Key", "acme-key"), http.StatusOK)
+}
+
+func TestBuildCacheEviction(t *testing.T) {
+	srv, fake := newTestServer(t)
+	override(t, &buildCacheEnabled, true)
+	override(t, &buildCacheMaxAge, 7*24*time.Hour)
+	override(t, &buildCacheMaxBytes, 10)
+	now := time.Now()
+	override(t, &fake.Now, func() time.Time { return now })
+	at := func(age time.Duration) { now = time.Now().Add(-age) }
+
+	entry := func(name string) string { return "/api/build-cache/ac/" + digest(name) }
+	at(10 * 24 * time.Hour)
+	expectStatus(t, call(t, srv, "PUT", entry("unused"), "1234"), http.StatusCreated)
+	expectStatus(t, call(t, srv, "PUT", entry("used"), "1234"), http.StatusCreated)
+	at(3 * 24 * time.Hour)
+	expectStatus(t, call(t, srv, "PUT", entry("oldest"), "1234"), http.StatusCreated)
+	at(2 * 24 * time.Hour)
+	expectStatus(t, call(t, srv, "PUT", entry("newer"), "1234"), http.StatusCreated)
+	// A hit marks an entry used again
+	at(0)
+	expectStatus(t, call(t, srv, "GET", entry("used"), nil), http.StatusOK)
+
+	if err := evictBuildCache(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]int{"unused": http.StatusNotFound, "oldest": http.StatusNotFound, "newer": http.StatusOK, "used": http.StatusOK} {
+		if got := call(t, srv, "GET", entry(name), nil); got.StatusCode != want {
+			t.Errorf("%s: %d, want %d", name, got.StatusCode, want)
+		}
+	}
+}
+
+func TestBuildCacheStorage(t *testing.T) {
+	srv, fake := newTestServer(t)
+	override(t, &buildCacheEnabled, true)
+	entry := "/api/build-cache/gradle/" + digest("task")
+
+	// Entries are held to the same limits as files
+	override(t, &maxObjectBytes, 5)
+	expectStatus(t, call(t, srv, "PUT", entry, "too large"), http.StatusRequestEntityTooLarge)
+	override(t, &maxObjectBytes, 0)
+	override(t, &defaultQuota, 20)
+	expectStatus(t, call(t, srv, "PUT", entry, "within quota"), http.StatusCreated)
+	expectStatus(t, call(t, srv, "PUT", entry, "over the quota now"), http.StatusRequestEntityTooLarge)
+	override(t, &defaultQuota, 0)
+
+	// and stored as the policy for their prefix says
+	override(t, &storagePolicies, map[string]storagePolicy{buildCachePrefix: {Encrypt: true}})
+	override(t, &encryptionKey, bytes.Repeat([]byte{7}, 32))
+	override(t, &buildCacheTouchAfter, 0)
+	expectStatus(t, call(t, srv, "PUT", entry, "task outputs"), http.StatusCreated)
+	if stored, _, _ := fake.Object(bucketName, buildCachePrefix+"gradle/"+digest("task")); bytes.Contains(stored, []byte("task outputs")) {
+		t.Fatal("stored in plaintext")
+	}
+	// A hit rewrites the entry, which must still decrypt afterwards
+	for i := 0; i < 2; i++ {
+		if got := call(t, srv, "GET", entry, nil); string(got.body) != "task outputs" {
+			t.Fatalf("hit %d read %d %q", i, got.StatusCode, got.body)
+		}
+	}
+}
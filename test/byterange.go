@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// parseByteRange resolves a Range header against a representation of size
+// bytes, returning inclusive offsets. ok is false for headers to ignore,
+// which RFC 9110 allows: other units, several ranges, or bad syntax. A
+// well-formed range that starts past the end is errRangeNotSatisfiable.
+func parseByteRange(header string, size int64) (start, end int64, ok bool, err error) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false, nil
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false, nil
+	}
+
+	if first == "" {
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || suffix < 0 {
+			return 0, 0, false, nil
+		}
+		if suffix == 0 || size == 0 {
+			return 0, 0, true, errRangeNotSatisfiable
+		}
+		return max(size-suffix, 0), size - 1, true, nil
+	}
+
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false, nil
+	}
+	end = size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false, nil
+		}
+		end = min(end, size-1)
+	}
+	if start >= size {
+		return 0, 0, true, errRangeNotSatisfiable
+	}
+	return start, end, true, nil
+}
+
+// contentSize is the size of an object's content as uploaded, which for
+// compressed or encrypted objects is recorded in their metadata.
+func contentSize(head *s3.HeadObjectOutput) (int64, bool) {
+	if len(storageEncodings(head.Metadata)) == 0 {
+		return aws.ToInt64(head.ContentLength), true
+	}
+	size, err := strconv.ParseInt(head.Metadata[metaOriginalSize], 10, 64)
+	return size, err == nil
+}
+
+// serveRange answers a request's Range header for the object head describes
+// with 206, or 416 when the range starts past the end, and reports whether
+// it answered. Ranges that can't be served, and If-Range validators that no
+// longer match, are left to the caller to answer with the whole file.
+// Ranges are of the content as uploaded and never compressed: plain objects
+// have the range fetched from S3, while compressed or encrypted ones are
+// decoded from the start. headers sets the caller's own response headers.
+func serveRange(w http.ResponseWriter, r *http.Request, bucket, key string, head *s3.HeadObjectOutput, headers func()) bool {
+	etag := aws.ToString(head.ETag)
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" && ifRange != etag {
+		return false
+	}
+	size, known := contentSize(head)
+	if !known {
+		return false
+	}
+	start, end, ok, err := parseByteRange(r.Header.Get("Range"), size)
+	if !ok {
+		return false
+	}
+	enableCORS(w)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		respondJSON(w, http.StatusRequestedRangeNotSatisfiable, ErrorResponse{
+			Error:   "Range not satisfiable",
+			Details: fmt.Sprintf("the file is %d bytes", size),
+		})
+		return true
+	}
+
+	encoded := len(storageEncodings(head.Metadata)) > 0
+	input := &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key), IfMatch: head.ETag}
+	if !encoded {
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-%d", start, end))
+	}
+	result, err := getObject(r.Context(), input)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to read file",
+			Details: err.Error(),
+		})
+		return true
+	}
+	defer result.Body.Close()
+	body := io.Reader(result.Body)
+	if encoded {
+		if _, err := io.CopyN(io.Discard, body, start); err != nil {
+			respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+				Error:   "Failed to read file",
+				Details: err.Error(),
+			})
+			return true
+		}
+		body = io.LimitReader(body, end-start+1)
+	}
+
+	headers()
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+	w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	w.WriteHeader(http.StatusPartialContent)
+	if _, err := io.Copy(w, body); err != nil {
+		slog.WarnContext(r.Context(), "Ranged download interrupted", "key", key, "err", err)
+	}
+	return true
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Capability describes one optional subsystem. Limits and Options are only
+// present for enabled subsystems that have any.
+type Capability struct {
+	Enabled bool                   `json:"enabled"`
+	Limits  map[string]int64       `json:"limits,omitempty"`
+	Options map[string]interface{} `json:"options,omitempty"`
+}
+
+type CapabilitiesResponse struct {
+	Version      string                `json:"version"`
+	Tenant       string                `json:"tenant"`
+	Capabilities map[string]Capability `json:"capabilities"`
+}
+
+// capabilitiesHandler reports which optional subsystems this deployment has
+// enabled, so clients can adapt instead of probing endpoints. Subsystems this
+// build doesn't provide are listed as disabled rather than left out.
+func capabilitiesHandler(w http.ResponseWriter, r *http.Request) {
+	ns := requestNamespace(r)
+	objectSize, _ := objectSizeLimit(ns.Tenant, false)
+	multipartObjectSize, _ := objectSizeLimit(ns.Tenant, true)
+
+	versioning := false
+	if result, err := s3Client.GetBucketVersioning(r.Context(), &s3.GetBucketVersioningInput{
+		Bucket: aws.String(ns.Bucket),
+	}); err == nil {
+		versioning = result.Status == types.BucketVersioningStatusEnabled
+	}
+
+	var validators []string
+	for _, v := range uploadValidators {
+		validators = append(validators, v.Name())
+	}
+	var plugins []string
+	for _, p := range uploadPlugins {
+		plugins = append(plugins, p.name)
+	}
+
+	capabilities := map[string]Capability{
+		"tus":     {Enabled: false},
+		"presign": {Enabled: false},
+		"search":  {Enabled: false},
+
+		"versions": {Enabled: versioning},
+		"soft_delete": {
+			Enabled: softDeleteEnabled,
+			Limits: map[string]int64{
+				"retention_days": int64(trashRetention.Hours() / 24),
+				"max_bytes":      trashMaxBytes,
+				"max_objects":    int64(trashMaxObjects),
+			},
+		},
+		"expiry": {Enabled: true},
+		"quota": {
+			Enabled: quotaFor(ns.Tenant) > 0,
+			Limits:  map[string]int64{"bytes": quotaFor(ns.Tenant)},
+			Options: map[string]interface{}{"warning_thresholds": quotaWarningThresholds},
+		},
+		"replication": {Enabled: objectReplicator != nil},
+		"shadow":      {Enabled: trafficShadow != nil},
+		"exports": {
+			Enabled: true,
+			Options: map[string]interface{}{
+				"sources": exportSources(),
+			},
+		},
+		"compression": {
+			Enabled: autoCompression || len(storagePolicies) > 0,
+			Options: map[string]interface{}{"encodings": []string{encodingZstd, encodingGzip}},
+		},
+		"response_compression": {
+			Enabled: responseCompression,
+			Limits:  map[string]int64{"min_bytes": int64(responseCompressionMin)},
+			Options: map[string]interface{}{"encodings": []string{encodingGzip, encodingDeflate}},
+		},
+		"encryption": {Enabled: encryptionKey != nil},
+		"integrity": {
+			Enabled: true,
+			Options: map[string]interface{}{
+				"algorithm":  hashAlgorithm,
+				"algorithms": supportedHashAlgorithms(),
+			},
+		},
+		"validation": {
+			Enabled: len(validators) > 0,
+			Options: map[string]interface{}{"validators": validators},
+		},
+		"upload_plugins": {
+			Enabled: len(plugins) > 0,
+			Options: map[string]interface{}{"plugins": plugins},
+		},
+		"antivirus": {
+			Enabled: antivirusEnabled(),
+			Options: map[string]interface{}{"action": clamavAction},
+		},
+		"hooks": {
+			Enabled: len(fileHooks) > 0,
+			Options: map[string]interface{}{"hooks": hookNames()},
+		},
+		"quarantine": {
+			Enabled: quarantineEnabled(),
+			Options: map[string]interface{}{"checks": quarantineChecks},
+		},
+		"conflict_strategies": {
+			Enabled: true,
+			Options: map[string]interface{}{
+				"strategies": []string{conflictOverwrite, conflictNumber, conflictTimestamp, conflictHash},
+			},
+		},
+		"body_limits": {
+			Enabled: maxBodyBytes > 0 || len(bodyLimitsByType) > 0,
+			Limits:  map[string]int64{"max_body_bytes": maxBodyBytes},
+			Options: map[string]interface{}{"by_content_type": bodyLimitsByType},
+		},
+		"object_size": {
+			Enabled: objectSize > 0 || multipartObjectSize > 0,
+			Limits:  map[string]int64{"max_object_bytes": objectSize, "max_multipart_object_bytes": multipartObjectSize},
+		},
+		"key_policy": {
+			Enabled: true,
+			Limits:  map[string]int64{"max_length": int64(keyRules.MaxLength)},
+			Options: map[string]interface{}{
+				"strict_charset": keyRules.StrictCharset,
+				"normalization":  keyRules.Normalization,
+			},
+		},
+		"tail": {
+			Enabled: true,
+			Limits:  map[string]int64{"max_lines": maxTailLines},
+		},
+		"file_events": {
+			Enabled: true,
+			Options: map[string]interface{}{"path": "/api/events/ws"},
+		},
+		"webhooks": {
+			Enabled: webhookDeliveries != nil,
+			Options: map[string]interface{}{"events": []string{"upload", "delete", "move"}},
+		},
+		"event_publishing": {
+			Enabled: eventPublishing != nil,
+			Options: map[string]interface{}{"publisher": eventPublisherName},
+		},
+		"render":  {Enabled: true},
+		"folders": {Enabled: true, Limits: map[string]int64{"delete_batch_size": deleteBatchSize}},
+		"tenancy": {
+			Enabled: tenancyEnabled(),
+			Options: map[string]interface{}{"registry": tenantRegistryEnabled},
+		},
+		"acl":    {Enabled: aclsEnabled},
+		"policy": {Enabled: authzPolicy != nil},
+		"billing": {
+			Enabled: billingEnabled,
+			Options: map[string]interface{}{"webhook": billingWebhookURL != ""},
+		},
+		"audit": {
+			Enabled: len(auditSinks) > 0,
+			Options: map[string]interface{}{"queryable": auditQueryable()},
+		},
+		"share": {
+			Enabled: true,
+			Limits: map[string]int64{
+				"default_ttl_seconds": int64(shareDefaultTTL.Seconds()),
+				"max_ttl_seconds":     int64(shareMaxTTL.Seconds()),
+			},
+		},
+		"public_files": {
+			Enabled: true,
+			Limits:  map[string]int64{"cache_ttl_seconds": int64(publicCacheTTL.Seconds())},
+		},
+		"oidc": {
+			Enabled: oidcEnabled(),
+			Options: map[string]interface{}{"login_url": "/api/auth/login", "logout_url": "/api/auth/logout"},
+		},
+		"buckets": {
+			Enabled: len(namedBuckets) > 0,
+			Options: map[string]interface{}{"names": namedBucketNames()},
+		},
+		"actions_cache": {
+			Enabled: len(actionsCacheTokens) > 0,
+			Limits:  map[string]int64{"max_bytes": actionsCacheMaxSize},
+		},
+		"build_cache": {
+			Enabled: buildCacheEnabled,
+			Limits: map[string]int64{
+				"max_bytes":       buildCacheMaxBytes,
+				"max_age_seconds": int64(buildCacheMaxAge.Seconds()),
+			},
+		},
+		"mirrors": {
+			Enabled: true,
+			Limits: map[string]int64{
+				"max_per_file":           int64(mirrorMaxPerFile),
+				"probe_interval_seconds": int64(mirrorProbeInterval.Seconds()),
+			},
+		},
+		"channels": {
+			Enabled: true,
+			Options: map[string]interface{}{"names": releaseChannels},
+		},
+		"aliases": {Enabled: true},
+		"graphql": {
+			Enabled: true,
+			Limits:  map[string]int64{"max_depth": int64(graphqlMaxDepth), "max_page": int64(graphqlMaxPage)},
+		},
+		"webdav": {
+			Enabled: webdavEnabled,
+			Options: map[string]interface{}{"path": webdavPrefix + "/"},
+		},
+		"status_reports": {
+			Enabled: statusReportsEnabled(),
+			Options: map[string]interface{}{"email": len(statusReportEmail) > 0, "slack": statusReportSlackURL != ""},
+		},
+		"sftp": {
+			Enabled: sftpAddr != "" && serveSFTP != nil,
+			Options: map[string]interface{}{"addr": sftpAddr},
+		},
+		"s3_gateway": {
+			Enabled: len(s3GatewayKeys) > 0,
+			Options: map[string]interface{}{"path": "/api/s3", "bucket": s3GatewayBucket},
+			Limits:  map[string]int64{"max_keys": s3MaxListKeys},
+		},
+		"admin": {Enabled: adminToken != ""},
+	}
+
+	for name, c := range capabilities {
+		if !c.Enabled {
+			c.Limits, c.Options = nil, nil
+			capabilities[name] = c
+		}
+	}
+
+	// Capabilities only change on redeploy, but depend on the caller's tenant
+	setCacheControl(w, "private", time.Minute)
+	respondJSON(w, http.StatusOK, CapabilitiesResponse{
+		Version:      apiVersion(),
+		Tenant:       ns.Tenant,
+		Capabilities: capabilities,
+	})
+}
+
+func exportSources() []string {
+	sources := []string{exportSourceList}
+	if inventoryPrefix != "" {
+		sources = append(sources, exportSourceInventory)
+	}
+	return sources
+}
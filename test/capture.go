@@ -0,0 +1,474 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+)
+
+// Captured exchanges live at the root of the files bucket, named by capture
+// time so listings come back oldest first.
+const capturePrefix = ".captures/"
+
+// A client opts a request into capture by sending a token from
+// POST /api/admin/captures/token in this header. Tokens are signed with the
+// admin token, so only an admin can hand them out and nothing is stored.
+const (
+	captureHeader   = "X-Debug-Capture"
+	captureIDHeader = "X-Debug-Capture-Id"
+)
+
+var (
+	captureMaxTTL = durationFromEnv("CAPTURE_MAX_TTL", 24*time.Hour)
+
+	// Bodies are recorded up to this many bytes each, so capturing a large
+	// download doesn't hold it all in memory
+	captureMaxBody = int64(intFromEnv("CAPTURE_MAX_BODY_BYTES", 1<<20))
+)
+
+const redacted = "[REDACTED]"
+
+// Headers, path variables, query parameters and body fields that carry
+// credentials. Replays supply their own credentials instead. Query and body
+// names are matched ignoring case, as path.Match patterns.
+var (
+	sensitiveHeaders = []string{"Authorization", "X-Api-Key", "Cookie", "Set-Cookie", "X-Share-Password", "X-Amz-Security-Token", captureHeader}
+	// Share links and the actions cache take their token in the path
+	sensitiveVars   = []string{"token"}
+	sensitiveQuery  = []string{"code", "state", "x-amz-*"}
+	sensitiveFields = []string{"password", "token", "secret", "api_keys"}
+)
+
+type CapturedExchange struct {
+	ID         string           `json:"id"`
+	CapturedAt string           `json:"captured_at"`
+	DurationMS int64            `json:"duration_ms"`
+	Request    CapturedRequest  `json:"request"`
+	Response   CapturedResponse `json:"response"`
+}
+
+type CapturedRequest struct {
+	Method        string              `json:"method"`
+	Path          string              `json:"path"`
+	Query         string              `json:"query,omitempty"`
+	Header        map[string][]string `json:"header"`
+	Body          []byte              `json:"body,omitempty"`
+	BodyTruncated bool                `json:"body_truncated,omitempty"`
+}
+
+type CapturedResponse struct {
+	Status        int                 `json:"status"`
+	Header        map[string][]string `json:"header"`
+	Body          []byte              `json:"body,omitempty"`
+	BodyTruncated bool                `json:"body_truncated,omitempty"`
+}
+
+type CaptureTokenRequest struct {
+	ExpiresIn string `json:"expires_in,omitempty"`
+}
+
+type CaptureTokenResponse struct {
+	Token     string `json:"token"`
+	Header    string `json:"header"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+type CaptureSummary struct {
+	ID         string `json:"id"`
+	Size       int64  `json:"size"`
+	CapturedAt string `json:"captured_at"`
+}
+
+type CapturesResponse struct {
+	Captures []CaptureSummary `json:"captures"`
+}
+
+// captureToken is "<expiry unix seconds>.<signature>".
+func captureToken(expiresAt time.Time) string {
+	expiry := strconv.FormatInt(expiresAt.Unix(), 10)
+	return expiry + "." + captureSignature(expiry)
+}
+
+func captureSignature(expiry string) string {
+	mac := hmac.New(sha256.New, []byte(adminToken))
+	mac.Write([]byte("capture:" + expiry))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func validCaptureToken(token string, now time.Time) bool {
+	if adminToken == "" {
+		return false
+	}
+	expiry, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	unix, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || now.Unix() >= unix {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(signature), []byte(captureSignature(expiry))) == 1
+}
+
+// cappedBuffer keeps the first max bytes written to it and notes whether any
+// were dropped.
+type cappedBuffer struct {
+	bytes.Buffer
+	max       int64
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - int64(b.Len()); int64(len(p)) > room {
+		b.truncated = true
+		if room > 0 {
+			b.Buffer.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// captureWriter copies the response as it is written, still letting
+// streaming handlers flush.
+type captureWriter struct {
+	http.ResponseWriter
+	status int
+	body   *cappedBuffer
+}
+
+func (cw *captureWriter) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *captureWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	cw.body.Write(b)
+	return cw.ResponseWriter.Write(b)
+}
+
+func (cw *captureWriter) Flush() {
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (cw *captureWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// captureRequests records requests carrying a valid capture token, along with
+// their responses, for replaying against another instance. Requests with a
+// missing or invalid token are served as usual.
+func captureRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get(captureHeader)
+		if token == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !validCaptureToken(token, time.Now()) {
+			slog.WarnContext(r.Context(), "Ignoring invalid capture token")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		id := captureID(start)
+		w.Header().Set(captureIDHeader, id)
+
+		reqBody := &cappedBuffer{max: captureMaxBody}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = readCloser{Reader: io.TeeReader(r.Body, reqBody), close: r.Body.Close}
+		}
+		cw := &captureWriter{ResponseWriter: w, body: &cappedBuffer{max: captureMaxBody}}
+		next.ServeHTTP(cw, r)
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+
+		exchange := CapturedExchange{
+			ID:         id,
+			CapturedAt: start.UTC().Format(time.RFC3339Nano),
+			DurationMS: time.Since(start).Milliseconds(),
+			Request: CapturedRequest{
+				Method:        r.Method,
+				Path:          sanitizePath(r),
+				Query:         sanitizeQuery(r.URL.Query()),
+				Header:        sanitizeHeader(r.Header),
+				Body:          sanitizeBody(r.Header.Get("Content-Type"), reqBody.Bytes()),
+				BodyTruncated: reqBody.truncated,
+			},
+			Response: CapturedResponse{
+				Status:        cw.status,
+				Header:        sanitizeHeader(w.Header()),
+				Body:          sanitizeBody(w.Header().Get("Content-Type"), cw.body.Bytes()),
+				BodyTruncated: cw.body.truncated,
+			},
+		}
+
+		// The request's own context ends with the response
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 5*time.Second)
+		defer cancel()
+		if err := saveCapture(ctx, exchange); err != nil {
+			slog.ErrorContext(ctx, "Failed to save capture", "capture", id, "err", err)
+		}
+	})
+}
+
+func captureID(now time.Time) string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return now.UTC().Format("20060102T150405.000000000Z") + "-" + hex.EncodeToString(suffix)
+}
+
+func captureKey(id string) string {
+	return capturePrefix + id + ".json"
+}
+
+func sanitizeHeader(header http.Header) map[string][]string {
+	clean := map[string][]string{}
+	for name, values := range header {
+		clean[name] = values
+	}
+	for _, name := range sensitiveHeaders {
+		name = http.CanonicalHeaderKey(name)
+		if _, ok := clean[name]; ok {
+			clean[name] = []string{redacted}
+		}
+	}
+	return clean
+}
+
+// sanitizePath redacts the path segments holding the route's credentials.
+func sanitizePath(r *http.Request) string {
+	vars := mux.Vars(r)
+	segments := strings.Split(r.URL.Path, "/")
+	for _, name := range sensitiveVars {
+		value := vars[name]
+		if value == "" {
+			continue
+		}
+		for i, segment := range segments {
+			if segment == value {
+				segments[i] = redacted
+			}
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func sanitizeQuery(query url.Values) string {
+	for name := range query {
+		if sensitiveName(sensitiveQuery, name) {
+			query.Set(name, redacted)
+		}
+	}
+	return query.Encode()
+}
+
+func sensitiveName(patterns []string, name string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitizeBody redacts credential fields from form bodies and anything that
+// parses as JSON, whatever content type the client claimed. Other bodies are
+// recorded as they are.
+func sanitizeBody(contentType string, body []byte) []byte {
+	if len(body) == 0 {
+		return nil
+	}
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return body
+		}
+		for name := range form {
+			if sensitiveName(sensitiveFields, name) {
+				form.Set(name, redacted)
+			}
+		}
+		return []byte(form.Encode())
+	}
+
+	var doc interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil || decoder.More() {
+		return body
+	}
+	clean, err := json.Marshal(redactFields(doc))
+	if err != nil {
+		return body
+	}
+	return clean
+}
+
+func redactFields(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = redactFields(value)
+			if sensitiveName(sensitiveFields, key) {
+				v[key] = redacted
+			}
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = redactFields(value)
+		}
+	}
+	return v
+}
+
+func saveCapture(ctx context.Context, exchange CapturedExchange) error {
+	data, err := json.Marshal(exchange)
+	if err != nil {
+		return err
+	}
+	_, err = s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucketName),
+		Key:         aws.String(captureKey(exchange.ID)),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
+
+func createCaptureTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var req CaptureTokenRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondInvalidBody(w, err)
+			return
+		}
+	}
+
+	ttl := time.Hour
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 {
+			respondJSON(w, http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid expires_in",
+				Details: "must be a positive duration such as 30m",
+			})
+			return
+		}
+		ttl = d
+	}
+	if ttl > captureMaxTTL {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid expires_in",
+			Details: fmt.Sprintf("must be at most %s", captureMaxTTL),
+		})
+		return
+	}
+
+	expiresAt := time.Now().Add(ttl).Truncate(time.Second)
+	respondJSON(w, http.StatusCreated, CaptureTokenResponse{
+		Token:     captureToken(expiresAt),
+		Header:    captureHeader,
+		ExpiresAt: expiresAt.UTC().Format(time.RFC3339),
+	})
+}
+
+func listCapturesHandler(w http.ResponseWriter, r *http.Request) {
+	response := CapturesResponse{Captures: []CaptureSummary{}}
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucketName),
+		Prefix: aws.String(capturePrefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(r.Context())
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+				Error:   "Failed to list captures",
+				Details: err.Error(),
+			})
+			return
+		}
+		for _, obj := range page.Contents {
+			id := strings.TrimSuffix(strings.TrimPrefix(aws.ToString(obj.Key), capturePrefix), ".json")
+			response.Captures = append(response.Captures, CaptureSummary{
+				ID:         id,
+				Size:       aws.ToInt64(obj.Size),
+				CapturedAt: formatTime(obj.LastModified),
+			})
+		}
+	}
+	respondJSON(w, http.StatusOK, response)
+}
+
+func getCaptureHandler(w http.ResponseWriter, r *http.Request) {
+	result, err := s3Client.GetObject(r.Context(), &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(captureKey(mux.Vars(r)["id"])),
+	})
+	if err != nil {
+		status, message := http.StatusInternalServerError, "Failed to read capture"
+		if isNotFound(err) {
+			status, message = http.StatusNotFound, "Capture not found"
+		}
+		respondJSON(w, status, ErrorResponse{
+			Error:   message,
+			Details: err.Error(),
+		})
+		return
+	}
+	defer result.Body.Close()
+
+	var exchange CapturedExchange
+	if err := json.NewDecoder(result.Body).Decode(&exchange); err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to read capture",
+			Details: err.Error(),
+		})
+		return
+	}
+	respondJSON(w, http.StatusOK, exchange)
+}
+
+func deleteCaptureHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, err := s3Client.DeleteObject(r.Context(), &s3.DeleteObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(captureKey(id)),
+	}); err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to delete capture",
+			Details: err.Error(),
+		})
+		return
+	}
+	respondJSON(w, http.StatusOK, MessageResponse{Message: "Capture deleted"})
+}
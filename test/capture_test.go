@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCaptureAndFetch(t *testing.T) {
+	srv, _ := newTestServer(t)
+	override(t, &adminToken, "admin-secret")
+	override(t, &apiKeys, map[string]string{"acme-key": "acme"})
+	admin := []string{"Authorization", "Bearer admin-secret"}
+
+	expectStatus(t, call(t, srv, "POST", "/api/admin/captures/token", CaptureTokenRequest{}), http.StatusUnauthorized)
+	expectStatus(t, call(t, srv, "POST", "/api/admin/captures/token", CaptureTokenRequest{ExpiresIn: "48h"}, admin...), http.StatusBadRequest)
+	created := call(t, srv, "POST", "/api/admin/captures/token", CaptureTokenRequest{ExpiresIn: "10m"}, admin...)
+	expectStatus(t, created, http.StatusCreated)
+	var token CaptureTokenResponse
+	created.decode(t, &token)
+
+	mustUpload(t, srv, "/api", "notes.txt", "hello", "X-API-Key", "acme-key")
+	share := call(t, srv, "POST", "/api/files/notes.txt/share", ShareRequest{Password: "hunter2"},
+		"X-API-Key", "acme-key", captureHeader, token.Token)
+	expectStatus(t, share, http.StatusCreated)
+	id := share.Header.Get(captureIDHeader)
+	if id == "" {
+		t.Fatal("no capture id returned")
+	}
+
+	// Requests without a valid token aren't captured
+	forged := call(t, srv, "GET", "/api/files", nil, "X-API-Key", "acme-key", captureHeader, token.Token+"x")
+	if forged.Header.Get(captureIDHeader) != "" {
+		t.Fatal("captured a request with a forged token")
+	}
+
+	var list CapturesResponse
+	call(t, srv, "GET", "/api/admin/captures", nil, admin...).decode(t, &list)
+	if len(list.Captures) != 1 || list.Captures[0].ID != id {
+		t.Fatalf("captures %+v", list.Captures)
+	}
+
+	var ex CapturedExchange
+	call(t, srv, "GET", "/api/admin/captures/"+id, nil, admin...).decode(t, &ex)
+	if ex.Request.Method != "POST" || ex.Request.Path != "/api/files/notes.txt/share" || ex.Response.Status != http.StatusCreated {
+		t.Fatalf("exchange %+v", ex)
+	}
+	if got := ex.Request.Header["X-Api-Key"]; len(got) != 1 || got[0] != redacted {
+		t.Fatalf("api key recorded as %v", got)
+	}
+	if got := ex.Request.Header[captureHeader]; len(got) != 1 || got[0] != redacted {
+		t.Fatalf("capture token recorded as %v", got)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(ex.Request.Body, &body); err != nil || body["password"] != redacted {
+		t.Fatalf("request body %s: %v", ex.Request.Body, err)
+	}
+	var response ShareResponse
+	if err := json.Unmarshal(ex.Response.Body, &response); err != nil || !response.PasswordProtected || response.Token != redacted {
+		t.Fatalf("response body %s: %v", ex.Response.Body, err)
+	}
+
+	// Nor are tokens in the path or signatures in the query
+	var link ShareResponse
+	share.decode(t, &link)
+	download := call(t, srv, "GET", "/api/share/"+link.Token+"?X-Amz-Signature=abc123&X-Amz-Credential=AKID", nil, captureHeader, token.Token)
+	call(t, srv, "GET", "/api/admin/captures/"+download.Header.Get(captureIDHeader), nil, admin...).decode(t, &ex)
+	if ex.Request.Path != "/api/share/"+redacted || strings.Contains(ex.Request.Query, "abc123") || strings.Contains(ex.Request.Query, "AKID") {
+		t.Errorf("share download recorded as %s?%s", ex.Request.Path, ex.Request.Query)
+	}
+	expectStatus(t, call(t, srv, "DELETE", "/api/admin/captures/"+download.Header.Get(captureIDHeader), nil, admin...), http.StatusOK)
+
+	expectStatus(t, call(t, srv, "DELETE", "/api/admin/captures/"+id, nil, admin...), http.StatusOK)
+	expectStatus(t, call(t, srv, "GET", "/api/admin/captures/"+id, nil, admin...), http.StatusNotFound)
+}
+
+func TestCaptureToken(t *testing.T) {
+	override(t, &adminToken, "admin-secret")
+	now := time.Now()
+	token := captureToken(now.Add(time.Minute))
+
+	if !validCaptureToken(token, now) {
+		t.Fatal("fresh token rejected")
+	}
+	if validCaptureToken(token, now.Add(2*time.Minute)) {
+		t.Fatal("expired token accepted")
+	}
+	for _, forged := range []string{"", "123", "9999999999.abc", token + "=", "x" + token} {
+		if validCaptureToken(forged, now) {
+			t.Errorf("accepted %q", forged)
+		}
+	}
+
+	override(t, &adminToken, "rotated")
+	if validCaptureToken(token, now) {
+		t.Fatal("token outlived the admin token it was signed with")
+	}
+}
+
+func TestCappedBuffer(t *testing.T) {
+	b := &cappedBuffer{max: 4}
+	b.Write([]byte("ab"))
+	b.Write([]byte("cdef"))
+	if b.String() != "abcd" || !b.truncated {
+		t.Fatalf("buffer %q truncated=%v", b.String(), b.truncated)
+	}
+}
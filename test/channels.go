@@ -0,0 +1,555 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+)
+
+// Each channel's history is one object per namespace, so channels in a
+// named bucket are separate from the default bucket's.
+const (
+	channelPrefix = ".channels/"
+
+	channelActionPromote  = "promote"
+	channelActionRollback = "rollback"
+
+	channelUpdateAttempts = 5
+)
+
+// The channels releases can be promoted to, e.g. RELEASE_CHANNELS=stable,beta,nightly
+var releaseChannels = splitList(envOr("RELEASE_CHANNELS", "stable,beta"))
+
+// ChannelRelease is one entry in a channel's history: the file version it
+// serves from EffectiveAt on, and who put it there. Versions can't change
+// once written, so a release always serves the same bytes.
+type ChannelRelease struct {
+	Release      int    `json:"release"`
+	Action       string `json:"action"`
+	Filename     string `json:"filename"`
+	VersionID    string `json:"version_id"`
+	ETag         string `json:"etag,omitempty"`
+	Size         int64  `json:"size"`
+	EffectiveAt  string `json:"effective_at"`
+	Actor        string `json:"actor"`
+	CreatedAt    string `json:"created_at"`
+	Note         string `json:"note,omitempty"`
+	RolledBackTo int    `json:"rolled_back_to,omitempty"`
+}
+
+// Channel is what a channel serves now, or at the time asked for, and its
+// history oldest first.
+type Channel struct {
+	Name    string           `json:"name"`
+	Current *ChannelRelease  `json:"current,omitempty"`
+	Pending []ChannelRelease `json:"pending"`
+	History []ChannelRelease `json:"history"`
+}
+
+type ChannelsResponse struct {
+	Channels []Channel `json:"channels"`
+}
+
+// PromoteRequest names the version to release: a file's version, its
+// current one by default, or whatever another channel serves, e.g. beta to
+// stable. EffectiveAt schedules the release for later.
+type PromoteRequest struct {
+	Filename    string `json:"filename,omitempty"`
+	VersionID   string `json:"version_id,omitempty"`
+	From        string `json:"from,omitempty"`
+	EffectiveAt string `json:"effective_at,omitempty"`
+	Note        string `json:"note,omitempty"`
+}
+
+// RollbackRequest picks the release to go back to, by default the one
+// served before the current one.
+type RollbackRequest struct {
+	Release int    `json:"release,omitempty"`
+	Note    string `json:"note,omitempty"`
+}
+
+var (
+	errChannelEmpty     = errors.New("channel has no release")
+	errNoEarlierRelease = errors.New("channel has no earlier release to roll back to")
+	errReleaseNotFound  = errors.New("release not found")
+	errReleasePending   = errors.New("release hasn't taken effect yet")
+	errVersionNotFound  = errors.New("version not found")
+
+	errChannelUnversioned = errors.New("releases pin an object version, and this bucket doesn't keep them")
+)
+
+func channelKey(ns namespace, name string) string {
+	return ns.key(channelPrefix + name + ".json")
+}
+
+// readChannelHistory returns a channel's history and the ETag to update it
+// against, which is empty for a channel never released to.
+func readChannelHistory(ctx context.Context, ns namespace, name string) ([]ChannelRelease, string, error) {
+	result, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(ns.Bucket),
+		Key:    aws.String(channelKey(ns, name)),
+	})
+	if isNotFound(err) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	defer result.Body.Close()
+
+	var history []ChannelRelease
+	if err := json.NewDecoder(result.Body).Decode(&history); err != nil {
+		return nil, "", fmt.Errorf("decoding channel %s: %w", name, err)
+	}
+	return history, aws.ToString(result.ETag), nil
+}
+
+// appendChannelRelease adds the release next builds from the history it is
+// given. Entries are only ever appended, and the write is conditional on
+// the history read, so concurrent promotions retry rather than lose one.
+func appendChannelRelease(ctx context.Context, ns namespace, name string, next func([]ChannelRelease) (ChannelRelease, error)) ([]ChannelRelease, error) {
+	for attempt := 0; attempt < channelUpdateAttempts; attempt++ {
+		history, etag, err := readChannelHistory(ctx, ns, name)
+		if err != nil {
+			return nil, err
+		}
+		release, err := next(history)
+		if err != nil {
+			return nil, err
+		}
+		release.Release = len(history) + 1
+		history = append(history, release)
+
+		body, err := json.MarshalIndent(history, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		input := &s3.PutObjectInput{
+			Bucket:      aws.String(ns.Bucket),
+			Key:         aws.String(channelKey(ns, name)),
+			Body:        bytes.NewReader(body),
+			ContentType: aws.String("application/json"),
+		}
+		if etag == "" {
+			input.IfNoneMatch = aws.String("*")
+		} else {
+			input.IfMatch = aws.String(etag)
+		}
+		_, err = s3Client.PutObject(ctx, input)
+		if isPreconditionFailed(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return history, nil
+	}
+	return nil, fmt.Errorf("channel %s kept changing; gave up after %d attempts", name, channelUpdateAttempts)
+}
+
+// effectiveOrder returns the releases in effect at t, in the order they
+// took effect. A later entry with the same time wins, so a promotion and a
+// rollback in the same second end on the rollback.
+func effectiveOrder(history []ChannelRelease, t time.Time) []ChannelRelease {
+	var effective []ChannelRelease
+	for _, release := range history {
+		at, err := time.Parse(time.RFC3339Nano, release.EffectiveAt)
+		if err == nil && !at.After(t) {
+			effective = append(effective, release)
+		}
+	}
+	slices.SortStableFunc(effective, func(a, b ChannelRelease) int {
+		return compareRFC3339(a.EffectiveAt, b.EffectiveAt)
+	})
+	return effective
+}
+
+func compareRFC3339(a, b string) int {
+	ta, _ := time.Parse(time.RFC3339Nano, a)
+	tb, _ := time.Parse(time.RFC3339Nano, b)
+	return ta.Compare(tb)
+}
+
+// channelAt is the channel as it stood at t.
+func channelAt(name string, history []ChannelRelease, t time.Time) Channel {
+	channel := Channel{Name: name, Pending: []ChannelRelease{}, History: history}
+	if channel.History == nil {
+		channel.History = []ChannelRelease{}
+	}
+	if effective := effectiveOrder(history, t); len(effective) > 0 {
+		current := effective[len(effective)-1]
+		channel.Current = &current
+	}
+	for _, release := range history {
+		if at, err := time.Parse(time.RFC3339Nano, release.EffectiveAt); err == nil && at.After(t) {
+			channel.Pending = append(channel.Pending, release)
+		}
+	}
+	return channel
+}
+
+// lookupChannel resolves the {channel} in the path, answering 404 for a
+// name RELEASE_CHANNELS doesn't list.
+func lookupChannel(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name := mux.Vars(r)["channel"]
+	if !slices.Contains(releaseChannels, name) {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{
+			Error:   "Channel not found",
+			Details: fmt.Sprintf("channels are %v", releaseChannels),
+		})
+		return "", false
+	}
+	return name, true
+}
+
+// channelTime is the ?at= a read asks about, so clients can see what a
+// channel served at some point, or now.
+func channelTime(w http.ResponseWriter, r *http.Request) (time.Time, bool) {
+	at := r.URL.Query().Get("at")
+	if at == "" {
+		return time.Now(), true
+	}
+	t, err := time.Parse(time.RFC3339, at)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid at",
+			Details: "want an RFC 3339 time, e.g. 2024-05-01T12:00:00Z",
+		})
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+func respondChannelError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errChannelEmpty), errors.Is(err, errReleaseNotFound):
+		respondJSON(w, http.StatusNotFound, ErrorResponse{
+			Error:   "Release not found",
+			Details: err.Error(),
+		})
+	case errors.Is(err, errVersionNotFound):
+		respondJSON(w, http.StatusNotFound, ErrorResponse{
+			Error:   "File or version not found",
+			Details: err.Error(),
+		})
+	case errors.Is(err, errNoEarlierRelease), errors.Is(err, errReleasePending):
+		respondJSON(w, http.StatusConflict, ErrorResponse{
+			Error:   "Nothing to roll back to",
+			Details: err.Error(),
+		})
+	case errors.Is(err, errChannelUnversioned):
+		respondJSON(w, http.StatusConflict, ErrorResponse{
+			Error:   "Channels need bucket versioning",
+			Details: err.Error(),
+		})
+	default:
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to update channel",
+			Details: err.Error(),
+		})
+	}
+}
+
+func listChannelsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	ns := requestNamespace(r)
+	now := time.Now()
+
+	response := ChannelsResponse{Channels: []Channel{}}
+	for _, name := range releaseChannels {
+		history, _, err := readChannelHistory(ctx, ns, name)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+				Error:   "Failed to read channels",
+				Details: err.Error(),
+			})
+			return
+		}
+		channel := channelAt(name, history, now)
+		// Only what the caller could download anyway
+		if channel.Current != nil {
+			if _, err := checkAccess(ctx, requestSubject(r), ns.key(channel.Current.Filename), permRead); err != nil {
+				continue
+			}
+		}
+		// The listing is a summary; each channel's own route has the history
+		channel.History = nil
+		response.Channels = append(response.Channels, channel)
+	}
+	respondJSON(w, http.StatusOK, response)
+}
+
+func getChannelHandler(w http.ResponseWriter, r *http.Request) {
+	name, ok := lookupChannel(w, r)
+	if !ok {
+		return
+	}
+	at, ok := channelTime(w, r)
+	if !ok {
+		return
+	}
+	ns := requestNamespace(r)
+	history, _, err := readChannelHistory(r.Context(), ns, name)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to read channel",
+			Details: err.Error(),
+		})
+		return
+	}
+	channel := channelAt(name, history, at)
+	if channel.Current != nil && !authorizeFile(w, r, ns.key(channel.Current.Filename), permRead) {
+		return
+	}
+	respondJSON(w, http.StatusOK, channel)
+}
+
+// downloadChannelHandler serves the version the channel points at, as
+// getFileHandler would with its version_id, ranges and encodings included.
+func downloadChannelHandler(w http.ResponseWriter, r *http.Request) {
+	name, ok := lookupChannel(w, r)
+	if !ok {
+		return
+	}
+	at, ok := channelTime(w, r)
+	if !ok {
+		return
+	}
+	history, _, err := readChannelHistory(r.Context(), requestNamespace(r), name)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to read channel",
+			Details: err.Error(),
+		})
+		return
+	}
+	current := channelAt(name, history, at).Current
+	if current == nil {
+		respondChannelError(w, fmt.Errorf("%w: %s", errChannelEmpty, name))
+		return
+	}
+
+	w.Header().Set("X-Channel-Release", fmt.Sprint(current.Release))
+	r = mux.SetURLVars(r, map[string]string{"filename": current.Filename})
+	r.URL.RawQuery = url.Values{"version_id": {current.VersionID}}.Encode()
+	getFileHandler(w, r)
+}
+
+func promoteChannelHandler(w http.ResponseWriter, r *http.Request) {
+	name, ok := lookupChannel(w, r)
+	if !ok {
+		return
+	}
+	var req PromoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondInvalidBody(w, err)
+		return
+	}
+	ctx := r.Context()
+	ns := requestNamespace(r)
+	now := time.Now().UTC()
+
+	effectiveAt := now
+	if req.EffectiveAt != "" {
+		t, err := time.Parse(time.RFC3339, req.EffectiveAt)
+		// The history records what was served when, so it can't be backdated
+		if err != nil || t.Before(now.Add(-time.Minute)) {
+			respondJSON(w, http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid effective_at",
+				Details: "want an RFC 3339 time that isn't in the past",
+			})
+			return
+		}
+		effectiveAt = t.UTC()
+	}
+
+	release := ChannelRelease{
+		Action:      channelActionPromote,
+		Filename:    req.Filename,
+		VersionID:   req.VersionID,
+		EffectiveAt: effectiveAt.Format(time.RFC3339Nano),
+		Actor:       requestSubject(r),
+		CreatedAt:   now.Format(time.RFC3339Nano),
+		Note:        req.Note,
+	}
+	switch {
+	case req.From != "" && (req.Filename != "" || req.VersionID != ""):
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Give either from or a filename, not both",
+		})
+		return
+	case req.From != "":
+		if !slices.Contains(releaseChannels, req.From) || req.From == name {
+			respondJSON(w, http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid from",
+				Details: fmt.Sprintf("want another of the channels %v", releaseChannels),
+			})
+			return
+		}
+		history, _, err := readChannelHistory(ctx, ns, req.From)
+		if err != nil {
+			respondChannelError(w, err)
+			return
+		}
+		source := channelAt(req.From, history, now).Current
+		if source == nil {
+			respondChannelError(w, fmt.Errorf("%w: %s", errChannelEmpty, req.From))
+			return
+		}
+		release.Filename, release.VersionID = source.Filename, source.VersionID
+	default:
+		filename, err := sanitizeName(req.Filename)
+		if err != nil {
+			respondJSON(w, http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid filename",
+				Details: err.Error(),
+			})
+			return
+		}
+		release.Filename = filename
+	}
+
+	if !authorizeFile(w, r, ns.key(release.Filename), permWrite) {
+		return
+	}
+	if err := pinChannelVersion(ctx, ns, &release); err != nil {
+		respondChannelError(w, err)
+		return
+	}
+	history, err := appendChannelRelease(ctx, ns, name, func([]ChannelRelease) (ChannelRelease, error) {
+		return release, nil
+	})
+	if err != nil {
+		respondChannelError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, channelAt(name, history, time.Now()))
+}
+
+// pinChannelVersion checks the release's version exists, resolving the
+// file's current one when none was given. Without versioning a later upload
+// would change what the channel serves, so that is refused.
+func pinChannelVersion(ctx context.Context, ns namespace, release *ChannelRelease) error {
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(ns.Bucket),
+		Key:    aws.String(ns.key(release.Filename)),
+	}
+	if release.VersionID != "" {
+		input.VersionId = aws.String(release.VersionID)
+	}
+	head, err := s3Client.HeadObject(ctx, input)
+	if isNotFound(err) && release.VersionID != "" {
+		return fmt.Errorf("%w: %s has no version %s", errVersionNotFound, release.Filename, release.VersionID)
+	}
+	if isNotFound(err) {
+		return fmt.Errorf("%w: %s", errVersionNotFound, release.Filename)
+	}
+	if err != nil {
+		return err
+	}
+	versionID := aws.ToString(head.VersionId)
+	if versionID == "" || versionID == "null" {
+		return errChannelUnversioned
+	}
+	release.VersionID = versionID
+	release.ETag = aws.ToString(head.ETag)
+	release.Size = aws.ToInt64(head.ContentLength)
+	return nil
+}
+
+// rollbackChannelHandler puts an earlier release back, effective now. It is
+// recorded as a new release, so the history still shows what was served
+// before; releases scheduled for later still take effect.
+func rollbackChannelHandler(w http.ResponseWriter, r *http.Request) {
+	name, ok := lookupChannel(w, r)
+	if !ok {
+		return
+	}
+	var req RollbackRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondInvalidBody(w, err)
+			return
+		}
+	}
+	ns := requestNamespace(r)
+	actor := requestSubject(r)
+
+	var denied string
+	history, err := appendChannelRelease(r.Context(), ns, name, func(history []ChannelRelease) (ChannelRelease, error) {
+		now := time.Now().UTC()
+		target, err := rollbackTarget(history, req.Release, now)
+		if err != nil {
+			return ChannelRelease{}, err
+		}
+		if _, err := checkAccess(r.Context(), actor, ns.key(target.Filename), permWrite); err != nil {
+			denied = target.Filename
+			return ChannelRelease{}, err
+		}
+		// The version may have been deleted since it was released
+		if err := pinChannelVersion(r.Context(), ns, &target); err != nil {
+			return ChannelRelease{}, err
+		}
+		target.Action = channelActionRollback
+		target.RolledBackTo = target.Release
+		target.EffectiveAt = now.Format(time.RFC3339Nano)
+		target.CreatedAt = target.EffectiveAt
+		target.Actor = actor
+		target.Note = req.Note
+		return target, nil
+	})
+	if denied != "" {
+		respondAccessError(w, r, ns.key(denied), permWrite, err)
+		return
+	}
+	if err != nil {
+		respondChannelError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, channelAt(name, history, time.Now()))
+}
+
+// rollbackTarget is the release numbered want, or else the latest one in
+// effect before the current release that served a different version.
+func rollbackTarget(history []ChannelRelease, want int, now time.Time) (ChannelRelease, error) {
+	if want != 0 {
+		if want < 1 || want > len(history) {
+			return ChannelRelease{}, fmt.Errorf("%w: %d", errReleaseNotFound, want)
+		}
+		target := history[want-1]
+		if compareRFC3339(target.EffectiveAt, now.Format(time.RFC3339Nano)) > 0 {
+			return ChannelRelease{}, fmt.Errorf("%w: %d", errReleasePending, want)
+		}
+		return target, nil
+	}
+	effective := effectiveOrder(history, now)
+	if len(effective) == 0 {
+		return ChannelRelease{}, errChannelEmpty
+	}
+	current := effective[len(effective)-1]
+	for i := len(effective) - 2; i >= 0; i-- {
+		if effective[i].VersionID != current.VersionID || effective[i].Filename != current.Filename {
+			return effective[i], nil
+		}
+	}
+	return ChannelRelease{}, errNoEarlierRelease
+}
+
+func init() {
+	// Names end up in keys and paths
+	for _, name := range releaseChannels {
+		if !validTenantID.MatchString(name) {
+			fatal("RELEASE_CHANNELS names an invalid channel", "channel", name)
+		}
+	}
+}
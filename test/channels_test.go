@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestReleaseChannels(t *testing.T) {
+	srv, fake := newTestServer(t)
+	fake.EnableVersioning(bucketName)
+
+	mustUpload(t, srv, "/api", "releases/app.tar.gz", "v1")
+	var beta Channel
+	got := call(t, srv, "POST", "/api/channels/beta/promote", PromoteRequest{Filename: "releases/app.tar.gz", Note: "1.0"})
+	expectStatus(t, got, http.StatusOK)
+	got.decode(t, &beta)
+	if beta.Current == nil || beta.Current.Release != 1 || beta.Current.VersionID == "" || beta.Current.Actor == "" {
+		t.Fatalf("beta after promotion: %+v", beta)
+	}
+	v1 := beta.Current.VersionID
+
+	// Promoting from beta pins the version beta serves, not the file's newest
+	mustUpload(t, srv, "/api", "releases/app.tar.gz", "v2")
+	expectStatus(t, call(t, srv, "POST", "/api/channels/stable/promote", PromoteRequest{From: "beta"}), http.StatusOK)
+	download := call(t, srv, "GET", "/api/channels/stable/download", nil)
+	expectStatus(t, download, http.StatusOK)
+	if string(download.body) != "v1" || download.Header.Get("X-Channel-Release") != "1" {
+		t.Errorf("stable served %q, release %s", download.body, download.Header.Get("X-Channel-Release"))
+	}
+
+	expectStatus(t, call(t, srv, "POST", "/api/channels/stable/promote", PromoteRequest{Filename: "releases/app.tar.gz"}), http.StatusOK)
+	if got := call(t, srv, "GET", "/api/channels/stable/download", nil); string(got.body) != "v2" {
+		t.Errorf("stable served %q after promoting v2", got.body)
+	}
+
+	// Rolling back appends a release serving v1 again
+	var stable Channel
+	got = call(t, srv, "POST", "/api/channels/stable/rollback", nil)
+	expectStatus(t, got, http.StatusOK)
+	got.decode(t, &stable)
+	if stable.Current == nil || stable.Current.VersionID != v1 || stable.Current.RolledBackTo != 1 || len(stable.History) != 3 {
+		t.Errorf("stable after rollback: %+v", stable)
+	}
+	if got := call(t, srv, "GET", "/api/channels/stable/download", nil); string(got.body) != "v1" {
+		t.Errorf("stable served %q after rolling back", got.body)
+	}
+
+	// A scheduled release waits for its time
+	later := time.Now().Add(time.Hour).UTC()
+	got = call(t, srv, "POST", "/api/channels/beta/promote", PromoteRequest{Filename: "releases/app.tar.gz", EffectiveAt: later.Format(time.RFC3339)})
+	expectStatus(t, got, http.StatusOK)
+	got.decode(t, &beta)
+	if beta.Current.VersionID != v1 || len(beta.Pending) != 1 {
+		t.Errorf("beta with a scheduled release: %+v", beta)
+	}
+	got = call(t, srv, "GET", "/api/channels/beta?at="+later.Add(time.Minute).Format(time.RFC3339), nil)
+	got.decode(t, &beta)
+	if beta.Current == nil || beta.Current.VersionID == v1 {
+		t.Errorf("beta after its scheduled time: %+v", beta.Current)
+	}
+
+	var list ChannelsResponse
+	call(t, srv, "GET", "/api/channels", nil).decode(t, &list)
+	if len(list.Channels) != 2 || list.Channels[0].Name != "stable" || list.Channels[0].History != nil {
+		t.Errorf("channel list %+v", list)
+	}
+}
+
+func TestReleaseChannelProblems(t *testing.T) {
+	srv, fake := newTestServer(t)
+	mustUpload(t, srv, "/api", "app.tar.gz", "v1")
+
+	// Without versioning a channel couldn't keep serving the same bytes
+	expectStatus(t, call(t, srv, "POST", "/api/channels/stable/promote", PromoteRequest{Filename: "app.tar.gz"}), http.StatusConflict)
+
+	fake.EnableVersioning(bucketName)
+	mustUpload(t, srv, "/api", "app.tar.gz", "v2")
+	for _, tc := range []struct {
+		path string
+		body interface{}
+		want int
+	}{
+		{"/api/channels/nightly/promote", PromoteRequest{Filename: "app.tar.gz"}, http.StatusNotFound},
+		{"/api/channels/stable/promote", PromoteRequest{Filename: "missing.tar.gz"}, http.StatusNotFound},
+		{"/api/channels/stable/promote", PromoteRequest{Filename: "app.tar.gz", VersionID: "nope"}, http.StatusNotFound},
+		{"/api/channels/stable/promote", PromoteRequest{From: "beta"}, http.StatusNotFound},
+		{"/api/channels/stable/promote", PromoteRequest{From: "stable"}, http.StatusBadRequest},
+		{"/api/channels/stable/promote", PromoteRequest{Filename: "app.tar.gz", EffectiveAt: "2001-01-01T00:00:00Z"}, http.StatusBadRequest},
+		{"/api/channels/stable/rollback", nil, http.StatusNotFound},
+	} {
+		if got := call(t, srv, "POST", tc.path, tc.body); got.StatusCode != tc.want {
+			t.Errorf("%s %+v: %d %s, want %d", tc.path, tc.body, got.StatusCode, got.body, tc.want)
+		}
+	}
+
+	expectStatus(t, call(t, srv, "POST", "/api/channels/stable/promote", PromoteRequest{Filename: "app.tar.gz"}), http.StatusOK)
+	expectStatus(t, call(t, srv, "POST", "/api/channels/stable/rollback", nil), http.StatusConflict)
+	expectStatus(t, call(t, srv, "POST", "/api/channels/stable/rollback", RollbackRequest{Release: 7}), http.StatusNotFound)
+	expectStatus(t, call(t, srv, "GET", "/api/channels/beta/download", nil), http.StatusNotFound)
+}
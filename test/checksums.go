@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/gorilla/mux"
+)
+
+const (
+	hashMD5  = "md5"
+	hashSHA1 = "sha1"
+)
+
+// checksumAlgorithms are the digests checksums can be asked for: the ones
+// uploads are stored with, plus MD5 and SHA-1, which are what rclone and
+// md5sum-style tools compare against.
+var checksumAlgorithms = func() map[string]func() hash.Hash {
+	algorithms := map[string]func() hash.Hash{hashMD5: md5.New, hashSHA1: sha1.New}
+	for name, fn := range hashAlgorithms {
+		algorithms[name] = fn
+	}
+	return algorithms
+}()
+
+type FileChecksums struct {
+	Filename  string            `json:"filename"`
+	Checksums map[string]string `json:"checksums"`
+}
+
+// parseChecksumAlgorithms reads ?hash=md5,sha1, defaulting to def.
+func parseChecksumAlgorithms(value string, def ...string) ([]string, error) {
+	names := splitList(strings.ToLower(value))
+	if len(names) == 0 {
+		return def, nil
+	}
+	for _, name := range names {
+		if _, ok := checksumAlgorithms[name]; !ok {
+			return nil, fmt.Errorf("unknown hash %q, supported: %s", name, strings.Join(sortedKeys(checksumAlgorithms), ", "))
+		}
+	}
+	return names, nil
+}
+
+// fileChecksums returns the digests of an object's content as uploaded.
+// Digests S3 or the upload already recorded are used as they are; the
+// object is only read when one of algorithms isn't among them.
+func fileChecksums(ctx context.Context, bucket, key string, algorithms []string) (map[string]string, error) {
+	head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sums := map[string]string{}
+	if algorithm := head.Metadata[metaHashAlgorithm]; algorithm != "" && head.Metadata[metaContentHash] != "" {
+		sums[algorithm] = head.Metadata[metaContentHash]
+	}
+	// A single-part upload's ETag is its MD5, unless S3 encrypted it with KMS
+	// or we compressed or encrypted it first
+	etag := strings.Trim(aws.ToString(head.ETag), `"`)
+	if len(storageEncodings(head.Metadata)) == 0 && !strings.Contains(etag, "-") &&
+		head.ServerSideEncryption != types.ServerSideEncryptionAwsKms && len(etag) == 2*md5.Size {
+		sums[hashMD5] = etag
+	}
+
+	var missing []string
+	for _, algorithm := range algorithms {
+		if sums[algorithm] == "" {
+			missing = append(missing, algorithm)
+		}
+	}
+	if len(missing) > 0 {
+		result, err := getObject(ctx, &s3.GetObjectInput{
+			Bucket:  aws.String(bucket),
+			Key:     aws.String(key),
+			IfMatch: head.ETag,
+		})
+		if err != nil {
+			return nil, err
+		}
+		defer result.Body.Close()
+
+		hashers := make([]hash.Hash, len(missing))
+		writers := make([]io.Writer, len(missing))
+		for i, algorithm := range missing {
+			hashers[i] = checksumAlgorithms[algorithm]()
+			writers[i] = hashers[i]
+		}
+		if _, err := io.Copy(io.MultiWriter(writers...), result.Body); err != nil {
+			return nil, err
+		}
+		for i, algorithm := range missing {
+			sums[algorithm] = hex.EncodeToString(hashers[i].Sum(nil))
+		}
+	}
+
+	requested := make(map[string]string, len(algorithms))
+	for _, algorithm := range algorithms {
+		requested[algorithm] = sums[algorithm]
+	}
+	return requested, nil
+}
+
+// fileChecksumsHandler returns a file's digests, MD5 and SHA-1 unless
+// ?hash= asks for others.
+func fileChecksumsHandler(w http.ResponseWriter, r *http.Request) {
+	filename := mux.Vars(r)["filename"]
+	ns := requestNamespace(r)
+	if !authorizeFile(w, r, ns.key(filename), permRead) {
+		return
+	}
+	algorithms, err := parseChecksumAlgorithms(r.URL.Query().Get("hash"), hashMD5, hashSHA1)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid hash", Details: err.Error()})
+		return
+	}
+
+	sums, err := fileChecksums(r.Context(), ns.Bucket, ns.key(filename), algorithms)
+	if err != nil {
+		if isNotFound(err) {
+			respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "File not found"})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to compute checksums",
+			Details: err.Error(),
+		})
+		return
+	}
+	respondJSON(w, http.StatusOK, FileChecksums{Filename: filename, Checksums: sums})
+}
+
+// checksumFileHandler lists one digest of every readable file under
+// ?prefix= in md5sum's format, names relative to the prefix, which is what
+// rclone check --checkfile and sha1sum -c read.
+func checksumFileHandler(w http.ResponseWriter, r *http.Request) {
+	ns := requestNamespace(r)
+	prefix := r.URL.Query().Get("prefix")
+	algorithms, err := parseChecksumAlgorithms(r.URL.Query().Get("hash"), hashMD5)
+	if err != nil || len(algorithms) != 1 {
+		details := "ask for a single hash"
+		if err != nil {
+			details = err.Error()
+		}
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid hash", Details: details})
+		return
+	}
+
+	var names []string
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(ns.Bucket),
+		Prefix: aws.String(ns.key(prefix)),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(r.Context())
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+				Error:   "Failed to list files",
+				Details: err.Error(),
+			})
+			return
+		}
+		for _, obj := range page.Contents {
+			name := ns.name(aws.ToString(obj.Key))
+			if !isReservedKey(name) && !strings.HasSuffix(name, "/") {
+				names = append(names, name)
+			}
+		}
+	}
+	names, err = readableNames(r.Context(), requestSubject(r), names)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to check access",
+			Details: err.Error(),
+		})
+		return
+	}
+	sort.Strings(names)
+
+	sums := make([]string, len(names))
+	err = fanOut(r.Context(), len(names), func(ctx context.Context, i int) error {
+		computed, err := fileChecksums(ctx, ns.Bucket, ns.key(names[i]), algorithms)
+		if err != nil {
+			return fmt.Errorf("%s: %w", names[i], err)
+		}
+		sums[i] = computed[algorithms[0]]
+		return nil
+	})
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to compute checksums",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	enableCORS(w)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	for i, name := range names {
+		fmt.Fprintf(w, "%s  %s\n", sums[i], strings.TrimPrefix(name, prefix))
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func hexSum(sum []byte) string { return hex.EncodeToString(sum) }
+
+func TestFileChecksums(t *testing.T) {
+	srv, _ := newTestServer(t)
+	content := strings.Repeat("checksum me ", 200)
+	md5Sum, sha1Sum := md5.Sum([]byte(content)), sha1.Sum([]byte(content))
+	mustUpload(t, srv, "/api", "plain.txt", content)
+
+	var sums FileChecksums
+	resp := call(t, srv, "GET", "/api/files/plain.txt/checksums", nil)
+	expectStatus(t, resp, http.StatusOK)
+	resp.decode(t, &sums)
+	if sums.Checksums[hashMD5] != hexSum(md5Sum[:]) || sums.Checksums[hashSHA1] != hexSum(sha1Sum[:]) || len(sums.Checksums) != 2 {
+		t.Fatalf("checksums %+v", sums)
+	}
+
+	// Compressed objects' ETags aren't the content's MD5, so it is computed
+	override(t, &autoCompression, true)
+	override(t, &autoCompressionMin, 1)
+	mustUpload(t, srv, "/api", "packed.txt", content)
+	sums = FileChecksums{}
+	resp = call(t, srv, "GET", "/api/files/packed.txt/checksums?hash=md5,sha256", nil)
+	expectStatus(t, resp, http.StatusOK)
+	resp.decode(t, &sums)
+	if sums.Checksums[hashMD5] != hexSum(md5Sum[:]) || sums.Checksums[hashSHA256] != hashWith(hashSHA256, []byte(content)) {
+		t.Fatalf("checksums %+v", sums)
+	}
+
+	expectStatus(t, call(t, srv, "GET", "/api/files/plain.txt/checksums?hash=crc64", nil), http.StatusBadRequest)
+	expectStatus(t, call(t, srv, "GET", "/api/files/missing.txt/checksums", nil), http.StatusNotFound)
+}
+
+func TestChecksumFile(t *testing.T) {
+	srv, _ := newTestServer(t)
+	mustUpload(t, srv, "/api", "site/index.html", "<h1>hi</h1>")
+	mustUpload(t, srv, "/api", "site/css/app.css", "body{}")
+	mustUpload(t, srv, "/api", "other.txt", "not listed")
+
+	resp := call(t, srv, "GET", "/api/checksums?prefix=site/&hash=sha1", nil)
+	expectStatus(t, resp, http.StatusOK)
+	css, index := sha1.Sum([]byte("body{}")), sha1.Sum([]byte("<h1>hi</h1>"))
+	want := hexSum(css[:]) + "  css/app.css\n" + hexSum(index[:]) + "  index.html\n"
+	if string(resp.body) != want {
+		t.Fatalf("checksum file\n%s\nwant\n%s", resp.body, want)
+	}
+
+	expectStatus(t, call(t, srv, "GET", "/api/checksums?hash=md5,sha1", nil), http.StatusBadRequest)
+}
+
+func TestBrowse(t *testing.T) {
+	srv, _ := newTestServer(t)
+	mustUpload(t, srv, "/api", "docs/guide one.txt", "0123456789")
+	mustUpload(t, srv, "/api", "docs/img/logo.png", "png")
+	mustUpload(t, srv, "/api", "top.txt", "top")
+
+	resp := call(t, srv, "GET", "/api/browse/", nil)
+	expectStatus(t, resp, http.StatusOK)
+	if body := string(resp.body); !strings.Contains(body, `<a href="docs/">docs/</a>`) || !strings.Contains(body, `<a href="top.txt">top.txt</a>`) {
+		t.Fatalf("root index\n%s", body)
+	}
+
+	// Without its slash a folder redirects, so relative links resolve
+	resp = call(t, srv, "GET", "/api/browse/docs", nil)
+	expectStatus(t, resp, http.StatusMovedPermanently)
+	if location := resp.Header.Get("Location"); location != "/api/browse/docs/" {
+		t.Fatalf("redirected to %s", location)
+	}
+	resp = call(t, srv, "GET", "/api/browse/docs/", nil)
+	expectStatus(t, resp, http.StatusOK)
+	if body := string(resp.body); !strings.Contains(body, `<a href="guide%20one.txt">guide one.txt</a>`) || !strings.Contains(body, `<a href="img/">img/</a>`) || !strings.Contains(body, `<a href="../">`) {
+		t.Fatalf("docs index\n%s", body)
+	}
+
+	resp = call(t, srv, "HEAD", "/api/browse/docs/guide%20one.txt", nil)
+	expectStatus(t, resp, http.StatusOK)
+	if resp.ContentLength != 10 || resp.Header.Get("Last-Modified") == "" || resp.Header.Get("Accept-Ranges") != "bytes" || len(resp.body) != 0 {
+		t.Fatalf("HEAD %d %v", resp.ContentLength, resp.Header)
+	}
+
+	resp = call(t, srv, "GET", "/api/browse/docs/guide%20one.txt", nil, "Range", "bytes=4-")
+	expectStatus(t, resp, http.StatusPartialContent)
+	if string(resp.body) != "456789" || resp.Header.Get("Content-Range") != "bytes 4-9/10" {
+		t.Fatalf("range %q %q", resp.body, resp.Header.Get("Content-Range"))
+	}
+
+	expectStatus(t, call(t, srv, "GET", "/api/browse/nothing/", nil), http.StatusNotFound)
+	expectStatus(t, call(t, srv, "GET", "/api/browse/nothing.txt", nil), http.StatusNotFound)
+	expectStatus(t, call(t, srv, "GET", "/api/browse/a%5Cb", nil), http.StatusBadRequest)
+}
+
+func TestDownloadRanges(t *testing.T) {
+	srv, _ := newTestServer(t)
+	mustUpload(t, srv, "/api", "big.bin", "abcdefghij")
+
+	resp := call(t, srv, "GET", "/api/files/big.bin", nil, "Range", "bytes=-3")
+	expectStatus(t, resp, http.StatusPartialContent)
+	if string(resp.body) != "hij" || resp.Header.Get("Content-Disposition") == "" {
+		t.Fatalf("range %q %v", resp.body, resp.Header)
+	}
+
+	resp = call(t, srv, "GET", "/api/files/big.bin", nil, "Range", "bytes=0-1", "If-Range", `"stale"`)
+	expectStatus(t, resp, http.StatusOK)
+	if string(resp.body) != "abcdefghij" || resp.Header.Get("Last-Modified") == "" {
+		t.Fatalf("If-Range mismatch served %q", resp.body)
+	}
+}
@@ -0,0 +1,266 @@
+// Command artifact uploads build artifacts from CI jobs, tagged with the
+// branch, commit and run that built them, and prints the URL each can be
+// downloaded from.
+//
+//	go run ./cmd/artifact upload -url https://files.example.com -api-key $API_KEY \
+//	    -retention keep-30d dist/app.tar.gz
+//
+// The branch, commit and run are read from the environment of GitHub
+// Actions, GitLab CI, CircleCI, Buildkite or Jenkins, or given with -branch,
+// -commit and -run. Exit codes are those of package cli.
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"test-api/internal/cli"
+)
+
+// The parts of POST /api/upload this command uses.
+type uploadRequest struct {
+	Filename  string            `json:"filename"`
+	Content   string            `json:"content"`
+	ExpiresIn string            `json:"expires_in,omitempty"`
+	Tags      map[string]string `json:"tags,omitempty"`
+}
+
+type uploadResponse struct {
+	Filename string `json:"filename"`
+}
+
+// result is an uploaded artifact, as -json writes it.
+type result struct {
+	File      string            `json:"file"`
+	Filename  string            `json:"filename"`
+	URL       string            `json:"url"`
+	Tags      map[string]string `json:"tags"`
+	ExpiresIn string            `json:"expires_in,omitempty"`
+}
+
+// ciVariables are where CI systems say what a build is of. For each, the
+// first variable that is set wins, and the first system that sets any.
+var ciVariables = []struct {
+	branch, commit, run, repo []string
+}{
+	// GITHUB_HEAD_REF is the branch of a pull request, whose ref is a merge
+	{[]string{"GITHUB_HEAD_REF", "GITHUB_REF_NAME"}, []string{"GITHUB_SHA"}, []string{"GITHUB_RUN_ID"}, []string{"GITHUB_REPOSITORY"}},
+	{[]string{"CI_COMMIT_REF_NAME"}, []string{"CI_COMMIT_SHA"}, []string{"CI_PIPELINE_ID"}, []string{"CI_PROJECT_PATH"}},
+	{[]string{"CIRCLE_BRANCH"}, []string{"CIRCLE_SHA1"}, []string{"CIRCLE_BUILD_NUM"}, []string{"CIRCLE_PROJECT_REPONAME"}},
+	{[]string{"BUILDKITE_BRANCH"}, []string{"BUILDKITE_COMMIT"}, []string{"BUILDKITE_BUILD_NUMBER"}, []string{"BUILDKITE_PIPELINE_SLUG"}},
+	{[]string{"BRANCH_NAME", "GIT_BRANCH"}, []string{"GIT_COMMIT"}, []string{"BUILD_NUMBER"}, []string{"JOB_NAME"}},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(cli.ExitUsage)
+	}
+	switch os.Args[1] {
+	case "upload":
+		upload(os.Args[2:])
+	case "-h", "-help", "--help", "help":
+		usage()
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", os.Args[1])
+		usage()
+		os.Exit(cli.ExitUsage)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: artifact upload [flags] <file>...")
+	fmt.Fprintln(os.Stderr, "run artifact upload -h for the flags")
+}
+
+type uploadOptions struct {
+	base, bucket  string
+	apiKey, token string
+	prefix        string
+	branch        string
+	commit        string
+	run           string
+	repo          string
+	retention     string
+	tags          map[string]string
+}
+
+func upload(args []string) {
+	opts := uploadOptions{tags: map[string]string{}}
+	fs := flag.NewFlagSet("upload", flag.ExitOnError)
+	fs.StringVar(&opts.base, "url", os.Getenv("FILES_URL"), "base URL of the API")
+	fs.StringVar(&opts.bucket, "bucket", "", "upload to this named bucket instead of the files bucket")
+	fs.StringVar(&opts.apiKey, "api-key", os.Getenv("API_KEY"), "API key")
+	fs.StringVar(&opts.token, "token", os.Getenv("TOKEN"), "bearer token")
+	fs.StringVar(&opts.prefix, "prefix", "artifacts", "folder the artifacts go under, as <prefix>/<branch>/<commit>/<file>")
+	fs.StringVar(&opts.branch, "branch", "", "branch the artifact was built from; read from the CI environment by default")
+	fs.StringVar(&opts.commit, "commit", "", "commit the artifact was built from; read from the CI environment by default")
+	fs.StringVar(&opts.run, "run", "", "CI run or pipeline ID; read from the CI environment by default")
+	fs.StringVar(&opts.retention, "retention", "keep-30d", "retention label: keep-<n>d, keep-<n>h or keep-forever")
+	fs.Func("tag", "extra tag as key=value; may be repeated", func(value string) error {
+		key, v, ok := strings.Cut(value, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("want key=value")
+		}
+		opts.tags[key] = v
+		return nil
+	})
+	out := cli.NewOutput(fs)
+	fs.Parse(args)
+
+	if opts.base == "" || fs.NArg() == 0 {
+		out.Usage(fs, "-url and at least one file are required")
+	}
+	expiresIn, err := retentionTTL(opts.retention)
+	if err != nil {
+		out.Usage(fs, "%v", err)
+	}
+	opts.fromCI()
+	if opts.branch == "" || opts.commit == "" {
+		out.Usage(fs, "no CI environment found; set -branch and -commit")
+	}
+
+	tags := map[string]string{
+		"branch":    opts.branch,
+		"commit":    opts.commit,
+		"retention": opts.retention,
+	}
+	if opts.run != "" {
+		tags["run"] = opts.run
+	}
+	if opts.repo != "" {
+		tags["repository"] = opts.repo
+	}
+	for key, value := range opts.tags {
+		tags[key] = value
+	}
+
+	client := &http.Client{Timeout: 10 * time.Minute}
+	for _, file := range fs.Args() {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			out.Exit(err)
+		}
+		name := path.Join(opts.prefix, opts.branch, opts.commit, path.Base(file))
+		stored, err := opts.put(client, uploadRequest{
+			Filename:  name,
+			Content:   base64.StdEncoding.EncodeToString(content),
+			ExpiresIn: expiresIn,
+			Tags:      tags,
+		})
+		if err != nil {
+			out.Exit(fmt.Errorf("uploading %s: %w", file, err))
+		}
+		r := result{File: file, Filename: stored, URL: opts.apiURL() + "/files/" + escapeName(stored), Tags: tags, ExpiresIn: expiresIn}
+		out.Result(r, "%s", r.URL)
+	}
+}
+
+// fromCI fills in what wasn't given from the CI environment.
+func (o *uploadOptions) fromCI() {
+	first := func(names []string) string {
+		for _, name := range names {
+			if value := os.Getenv(name); value != "" {
+				return value
+			}
+		}
+		return ""
+	}
+	for _, ci := range ciVariables {
+		if first(ci.commit) == "" {
+			continue
+		}
+		if o.branch == "" {
+			o.branch = strings.TrimPrefix(first(ci.branch), "origin/")
+		}
+		if o.commit == "" {
+			o.commit = first(ci.commit)
+		}
+		if o.run == "" {
+			o.run = first(ci.run)
+		}
+		o.repo = first(ci.repo)
+		return
+	}
+}
+
+var retentionLabel = regexp.MustCompile(`^keep-([1-9][0-9]*)([dh])$`)
+
+// retentionTTL turns a retention label into the upload's expires_in.
+func retentionTTL(label string) (string, error) {
+	if label == "keep-forever" {
+		return "", nil
+	}
+	m := retentionLabel.FindStringSubmatch(label)
+	if m == nil {
+		return "", fmt.Errorf("invalid retention label %q; use keep-<n>d, keep-<n>h or keep-forever", label)
+	}
+	n, _ := strconv.Atoi(m[1])
+	if m[2] == "d" {
+		n *= 24
+	}
+	return strconv.Itoa(n) + "h", nil
+}
+
+// apiURL is where the file routes of the chosen bucket are.
+func (o *uploadOptions) apiURL() string {
+	base := strings.TrimSuffix(o.base, "/") + "/api"
+	if o.bucket != "" {
+		base += "/buckets/" + url.PathEscape(o.bucket)
+	}
+	return base
+}
+
+// put uploads the artifact, replacing one a rerun of the same job uploaded,
+// and returns the name it was stored under.
+func (o *uploadOptions) put(client *http.Client, req uploadRequest) (string, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	httpReq, err := http.NewRequest("POST", o.apiURL()+"/upload?overwrite=true", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if o.apiKey != "" {
+		httpReq.Header.Set("X-API-Key", o.apiKey)
+	}
+	if o.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+o.token)
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return "", cli.Unavailable(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", cli.HTTPError(resp)
+	}
+	var uploaded uploadResponse
+	if err := json.NewDecoder(resp.Body).Decode(&uploaded); err != nil {
+		return "", err
+	}
+	return uploaded.Filename, nil
+}
+
+// escapeName escapes each segment of a file name for a path, keeping the
+// slashes between them.
+func escapeName(name string) string {
+	segments := strings.Split(name, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,300 @@
+// Command compare runs the same listing and stat queries against two
+// deployments, or two versions of the API on one deployment, and reports
+// where their answers diverge.
+//
+//	go run ./cmd/compare -a https://prod.example.com -b https://staging.example.com \
+//	    -api-key $API_KEY
+//	go run ./cmd/compare -a https://api.example.com -b https://api.example.com \
+//	    -b-prefix /api/v2 -api-key $API_KEY
+//
+// It exits 1 on any divergence, and with the codes of package cli if it
+// couldn't list the files of both.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"test-api/internal/cli"
+)
+
+// side is one of the two deployments being compared.
+type side struct {
+	name          string
+	base, prefix  string
+	apiKey, token string
+}
+
+// answer is a response reduced to what is compared.
+type answer struct {
+	status int
+	body   interface{}
+}
+
+// result is a divergence or note, as -json writes it.
+type result struct {
+	// only-a, only-b, diff, fail or note
+	Result  string `json:"result"`
+	Subject string `json:"subject,omitempty"`
+	Detail  string `json:"detail"`
+}
+
+type comparison struct {
+	client *http.Client
+	out    *cli.Output
+	a, b   side
+	bucket string
+	ignore map[string]bool
+
+	divergences int
+}
+
+func main() {
+	var a, b side
+	var bucket, ignore, paths string
+	var statLimit int
+	flag.StringVar(&a.base, "a", "", "base URL of the first deployment")
+	flag.StringVar(&b.base, "b", "", "base URL of the second deployment")
+	flag.StringVar(&a.prefix, "a-prefix", "/api", "API path prefix on the first deployment")
+	flag.StringVar(&b.prefix, "b-prefix", "/api", "API path prefix on the second deployment")
+	flag.StringVar(&a.apiKey, "api-key", os.Getenv("API_KEY"), "API key for both deployments")
+	flag.StringVar(&a.token, "token", os.Getenv("TOKEN"), "bearer token for both deployments")
+	flag.StringVar(&b.apiKey, "b-api-key", "", "API key for the second deployment, if it differs")
+	flag.StringVar(&b.token, "b-token", "", "bearer token for the second deployment, if it differs")
+	flag.StringVar(&bucket, "bucket", "", "compare this named bucket instead of the files bucket")
+	flag.StringVar(&ignore, "ignore", "etag,last_modified,version_id,request_id", "comma separated JSON fields that may differ")
+	flag.StringVar(&paths, "paths", "", "comma separated extra GET paths to compare, relative to the prefix, e.g. /usage")
+	flag.IntVar(&statLimit, "stat-limit", 1000, "stat at most this many files; 0 for all")
+	out := cli.NewOutput(flag.CommandLine)
+	flag.Parse()
+
+	if a.base == "" || b.base == "" {
+		out.Usage(flag.CommandLine, "-a and -b are required")
+	}
+	a.name, b.name = "a", "b"
+	if b.apiKey == "" {
+		b.apiKey = a.apiKey
+	}
+	if b.token == "" {
+		b.token = a.token
+	}
+
+	c := &comparison{
+		client: &http.Client{
+			Timeout: time.Minute,
+			// Report redirects rather than following them
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		out:    out,
+		a:      a,
+		b:      b,
+		bucket: bucket,
+		ignore: map[string]bool{},
+	}
+	for _, field := range strings.Split(ignore, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			c.ignore[field] = true
+		}
+	}
+
+	names := c.compareListings()
+	if statLimit > 0 && len(names) > statLimit {
+		detail := fmt.Sprintf("stating %d of %d files shared by both", statLimit, len(names))
+		out.Result(result{Result: "note", Detail: detail}, "NOTE %s", detail)
+		names = names[:statLimit]
+	}
+	for _, name := range names {
+		c.compare(c.filesPath()+"/files/"+escapeName(name)+"/metadata", false)
+	}
+	for _, path := range strings.Split(paths, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			c.compare(path, false)
+		}
+	}
+
+	summary := struct {
+		Files       int `json:"files"`
+		Divergences int `json:"divergences"`
+	}{len(names), c.divergences}
+	if c.divergences > 0 {
+		out.Result(summary, "%d divergences", c.divergences)
+		os.Exit(cli.ExitFailed)
+	}
+	out.Result(summary, "No divergences in the listing and %d files", len(names))
+}
+
+// filesPath is where the file routes live relative to the prefix.
+func (c *comparison) filesPath() string {
+	if c.bucket == "" {
+		return ""
+	}
+	return "/buckets/" + url.PathEscape(c.bucket)
+}
+
+// compareListings reports files only one side lists, and returns the sorted
+// names both list.
+func (c *comparison) compareListings() []string {
+	path := c.filesPath() + "/files"
+	listA, listB := c.compare(path, true)
+	if listA.status != http.StatusOK || listB.status != http.StatusOK {
+		status := listA.status
+		if status == http.StatusOK {
+			status = listB.status
+		}
+		c.out.Exit(cli.Errorf(cli.ExitCodeFor(status), "listing failed: %s %d, %s %d", c.a.name, listA.status, c.b.name, listB.status))
+	}
+
+	inA, inB := fileNames(listA.body), fileNames(listB.body)
+	var shared []string
+	for _, name := range sortedKeys(inA) {
+		if inB[name] {
+			shared = append(shared, name)
+		} else {
+			c.diverge("ONLY-A", name, "listed by "+c.a.name+" only")
+		}
+	}
+	for _, name := range sortedKeys(inB) {
+		if !inA[name] {
+			c.diverge("ONLY-B", name, "listed by "+c.b.name+" only")
+		}
+	}
+	return shared
+}
+
+// compare fetches path from both sides and reports how they differ. Listings
+// are compared as sets elsewhere, so their bodies can be skipped here.
+func (c *comparison) compare(path string, skipBody bool) (answer, answer) {
+	gotA, errA := c.get(c.a, path)
+	gotB, errB := c.get(c.b, path)
+	switch {
+	case errA != nil || errB != nil:
+		c.diverge("FAIL", path, fmt.Sprintf("%s: %v, %s: %v", c.a.name, errA, c.b.name, errB))
+	case gotA.status != gotB.status:
+		c.diverge("DIFF", path, fmt.Sprintf("status %d vs %d", gotA.status, gotB.status))
+	case !skipBody:
+		for _, field := range c.diffFields("", gotA.body, gotB.body) {
+			c.diverge("DIFF", path, field)
+		}
+	}
+	return gotA, gotB
+}
+
+func (c *comparison) get(s side, path string) (answer, error) {
+	req, err := http.NewRequest("GET", strings.TrimSuffix(s.base, "/")+s.prefix+path, nil)
+	if err != nil {
+		return answer{}, err
+	}
+	if s.apiKey != "" {
+		req.Header.Set("X-API-Key", s.apiKey)
+	}
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return answer{}, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return answer{}, err
+	}
+
+	got := answer{status: resp.StatusCode, body: string(body)}
+	var decoded interface{}
+	if json.Unmarshal(body, &decoded) == nil {
+		got.body = decoded
+	}
+	return got, nil
+}
+
+// diffFields describes each difference between a and b below at, skipping
+// ignored fields at any depth.
+func (c *comparison) diffFields(at string, a, b interface{}) []string {
+	objA, okA := a.(map[string]interface{})
+	objB, okB := b.(map[string]interface{})
+	if !okA || !okB {
+		if reflect.DeepEqual(a, b) {
+			return nil
+		}
+		if at == "" {
+			return []string{"bodies differ"}
+		}
+		return []string{fmt.Sprintf("%s: %s vs %s", at, describe(a), describe(b))}
+	}
+
+	keys := map[string]bool{}
+	for k := range objA {
+		keys[k] = !c.ignore[k]
+	}
+	for k := range objB {
+		keys[k] = !c.ignore[k]
+	}
+
+	var diffs []string
+	for _, k := range sortedKeys(keys) {
+		field := k
+		if at != "" {
+			field = at + "." + k
+		}
+		diffs = append(diffs, c.diffFields(field, objA[k], objB[k])...)
+	}
+	return diffs
+}
+
+func (c *comparison) diverge(kind, subject, detail string) {
+	c.divergences++
+	c.out.Result(result{Result: strings.ToLower(kind), Subject: subject, Detail: detail}, "%-6s %s: %s", kind, subject, detail)
+}
+
+// fileNames is the set of names in a GET /files response.
+func fileNames(body interface{}) map[string]bool {
+	names := map[string]bool{}
+	list, _ := body.(map[string]interface{})
+	files, _ := list["files"].([]interface{})
+	for _, f := range files {
+		if name, ok := f.(string); ok {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// sortedKeys returns the keys of set that are true, in order.
+func sortedKeys(set map[string]bool) []string {
+	var keys []string
+	for k, ok := range set {
+		if ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// escapeName escapes each segment of a file name for a path, keeping the
+// slashes between them.
+func escapeName(name string) string {
+	segments := strings.Split(name, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+func describe(v interface{}) string {
+	if v == nil {
+		return "missing"
+	}
+	encoded, _ := json.Marshal(v)
+	return string(encoded)
+}
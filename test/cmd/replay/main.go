@@ -0,0 +1,247 @@
+// Command replay sends requests captured by one instance of the API to
+// another, typically staging, and reports where the responses differ.
+//
+//	go run ./cmd/replay -source https://prod.example.com -admin-token $ADMIN_TOKEN \
+//	    -target https://staging.example.com -api-key $STAGING_KEY
+//
+// It exits 1 if any replay differed, and with the codes of package cli if
+// it couldn't get the captures.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"test-api/internal/cli"
+)
+
+// The capture format served by GET /api/admin/captures/{id}.
+type exchange struct {
+	ID      string `json:"id"`
+	Request struct {
+		Method        string              `json:"method"`
+		Path          string              `json:"path"`
+		Query         string              `json:"query"`
+		Header        map[string][]string `json:"header"`
+		Body          []byte              `json:"body"`
+		BodyTruncated bool                `json:"body_truncated"`
+	} `json:"request"`
+	Response struct {
+		Status        int                 `json:"status"`
+		Header        map[string][]string `json:"header"`
+		Body          []byte              `json:"body"`
+		BodyTruncated bool                `json:"body_truncated"`
+	} `json:"response"`
+}
+
+const redacted = "[REDACTED]"
+
+// Headers the transport sets itself, or that described the original hop.
+var skipHeaders = map[string]bool{
+	"Content-Length":    true,
+	"Accept-Encoding":   true,
+	"Connection":        true,
+	"Host":              true,
+	"X-Forwarded-For":   true,
+	"X-Forwarded-Proto": true,
+	"X-Debug-Capture":   true,
+}
+
+// result is how one capture replayed, as -json writes it.
+type result struct {
+	ID     string `json:"id"`
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// ok, diff, fail or skip
+	Result string `json:"result"`
+	Status int    `json:"status,omitempty"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type options struct {
+	source, adminToken string
+	target             string
+	apiKey, token      string
+	compareBody        bool
+}
+
+func main() {
+	var opts options
+	var ids string
+	flag.StringVar(&opts.source, "source", "", "base URL of the instance that captured the requests")
+	flag.StringVar(&opts.adminToken, "admin-token", os.Getenv("ADMIN_TOKEN"), "admin token of the source instance")
+	flag.StringVar(&opts.target, "target", "", "base URL to replay against")
+	flag.StringVar(&opts.apiKey, "api-key", os.Getenv("API_KEY"), "API key to send to the target")
+	flag.StringVar(&opts.token, "token", os.Getenv("TOKEN"), "bearer token to send to the target")
+	flag.StringVar(&ids, "id", "", "comma separated capture IDs to replay; all of them by default")
+	flag.BoolVar(&opts.compareBody, "compare-body", false, "also compare JSON response bodies")
+	out := cli.NewOutput(flag.CommandLine)
+	flag.Parse()
+
+	if opts.source == "" || opts.target == "" {
+		out.Usage(flag.CommandLine, "-source and -target are required")
+	}
+
+	client := &http.Client{
+		Timeout: time.Minute,
+		// Report redirects rather than following them
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	var captureIDs []string
+	if ids != "" {
+		captureIDs = strings.Split(ids, ",")
+	} else {
+		var err error
+		if captureIDs, err = listCaptures(client, opts); err != nil {
+			out.Exit(fmt.Errorf("failed to list captures: %w", err))
+		}
+	}
+
+	differences := 0
+	for _, id := range captureIDs {
+		ex, err := fetchCapture(client, opts, strings.TrimSpace(id))
+		if err != nil {
+			out.Exit(fmt.Errorf("failed to fetch capture %s: %w", id, err))
+		}
+		r := result{ID: ex.ID, Method: ex.Request.Method, Path: ex.Request.Path}
+		if ex.Request.BodyTruncated {
+			r.Result, r.Detail = "skip", "request body was too large to capture"
+			out.Result(r, "SKIP %s %s %s: %s", r.ID, r.Method, r.Path, r.Detail)
+			continue
+		}
+
+		status, body, err := replay(client, opts, ex)
+		if err != nil {
+			differences++
+			r.Result, r.Detail = "fail", err.Error()
+			out.Result(r, "FAIL %s %s %s: %s", r.ID, r.Method, r.Path, r.Detail)
+			continue
+		}
+		r.Status = status
+		if problem := compare(ex, status, body, opts.compareBody); problem != "" {
+			differences++
+			r.Result, r.Detail = "diff", problem
+			out.Result(r, "DIFF %s %s %s: %s", r.ID, r.Method, r.Path, r.Detail)
+			continue
+		}
+		r.Result = "ok"
+		out.Result(r, "OK   %s %s %s %d", r.ID, r.Method, r.Path, r.Status)
+	}
+
+	summary := struct {
+		Replayed int `json:"replayed"`
+		Differed int `json:"differed"`
+	}{len(captureIDs), differences}
+	if differences > 0 {
+		out.Result(summary, "%d of %d replays differed", differences, len(captureIDs))
+		os.Exit(cli.ExitFailed)
+	}
+	out.Result(summary, "All %d replays matched", len(captureIDs))
+}
+
+func adminGet(client *http.Client, opts options, path string, v interface{}) error {
+	req, err := http.NewRequest("GET", strings.TrimSuffix(opts.source, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+opts.adminToken)
+	resp, err := client.Do(req)
+	if err != nil {
+		return cli.Unavailable(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return cli.HTTPError(resp)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func listCaptures(client *http.Client, opts options) ([]string, error) {
+	var list struct {
+		Captures []struct {
+			ID string `json:"id"`
+		} `json:"captures"`
+	}
+	if err := adminGet(client, opts, "/api/admin/captures", &list); err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, c := range list.Captures {
+		ids = append(ids, c.ID)
+	}
+	return ids, nil
+}
+
+func fetchCapture(client *http.Client, opts options, id string) (exchange, error) {
+	var ex exchange
+	err := adminGet(client, opts, "/api/admin/captures/"+id, &ex)
+	return ex, err
+}
+
+// replay sends the captured request to the target with the target's
+// credentials in place of the redacted ones.
+func replay(client *http.Client, opts options, ex exchange) (int, []byte, error) {
+	url := strings.TrimSuffix(opts.target, "/") + ex.Request.Path
+	if ex.Request.Query != "" {
+		url += "?" + ex.Request.Query
+	}
+	req, err := http.NewRequest(ex.Request.Method, url, bytes.NewReader(ex.Request.Body))
+	if err != nil {
+		return 0, nil, err
+	}
+	for name, values := range ex.Request.Header {
+		if skipHeaders[name] || (len(values) == 1 && values[0] == redacted) {
+			continue
+		}
+		req.Header[name] = values
+	}
+	if opts.apiKey != "" {
+		req.Header.Set("X-API-Key", opts.apiKey)
+	}
+	if opts.token != "" {
+		req.Header.Set("Authorization", "Bearer "+opts.token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, body, err
+}
+
+// compare describes how a replayed response differs from the captured one, or
+// returns "" when it matches.
+func compare(ex exchange, status int, body []byte, compareBody bool) string {
+	if status != ex.Response.Status {
+		return fmt.Sprintf("captured %d, replayed %d", ex.Response.Status, status)
+	}
+	if !compareBody || ex.Response.BodyTruncated {
+		return ""
+	}
+	contentType := strings.Join(ex.Response.Header["Content-Type"], "")
+	if !strings.HasPrefix(contentType, "application/json") {
+		if !bytes.Equal(body, ex.Response.Body) {
+			return "response bodies differ"
+		}
+		return ""
+	}
+	var captured, replayed interface{}
+	if json.Unmarshal(ex.Response.Body, &captured) != nil || json.Unmarshal(body, &replayed) != nil {
+		return "response bodies are not JSON"
+	}
+	if !reflect.DeepEqual(captured, replayed) {
+		return "JSON response bodies differ"
+	}
+	return ""
+}
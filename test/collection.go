@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const postmanSchema = "https://schema.getpostman.com/json/collection/v2.1.0/collection.json"
+
+// Example values for path variables, so requests work once a variable is
+// filled in rather than needing every segment edited.
+var pathExamples = map[string]string{
+	"filename":   "reports/q3.txt",
+	"prefix":     "reports",
+	"version_id": "{{versionId}}",
+	"token":      "{{shareToken}}",
+	"bucket":     "{{bucket}}",
+	"id":         "{{exportId}}",
+	"key":        "reports/q3.txt",
+	"asset":      "console.js",
+}
+
+type postmanCollection struct {
+	Info     postmanInfo       `json:"info"`
+	Auth     *postmanAuth      `json:"auth,omitempty"`
+	Variable []postmanVariable `json:"variable"`
+	Item     []postmanItem     `json:"item"`
+}
+
+type postmanInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Schema      string `json:"schema"`
+}
+
+type postmanAuth struct {
+	Type   string            `json:"type"`
+	APIKey []postmanVariable `json:"apikey,omitempty"`
+	Bearer []postmanVariable `json:"bearer,omitempty"`
+}
+
+type postmanVariable struct {
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+	Description string `json:"description,omitempty"`
+	Disabled    bool   `json:"disabled,omitempty"`
+}
+
+// postmanItem is a folder when it has items and a request otherwise.
+type postmanItem struct {
+	Name    string          `json:"name"`
+	Item    []postmanItem   `json:"item,omitempty"`
+	Request *postmanRequest `json:"request,omitempty"`
+}
+
+type postmanRequest struct {
+	Method      string            `json:"method"`
+	Description string            `json:"description,omitempty"`
+	Header      []postmanVariable `json:"header"`
+	URL         postmanURL        `json:"url"`
+	Body        *postmanBody      `json:"body,omitempty"`
+	Auth        *postmanAuth      `json:"auth,omitempty"`
+}
+
+type postmanURL struct {
+	Raw      string            `json:"raw"`
+	Host     []string          `json:"host"`
+	Path     []string          `json:"path"`
+	Query    []postmanVariable `json:"query,omitempty"`
+	Variable []postmanVariable `json:"variable,omitempty"`
+}
+
+type postmanBody struct {
+	Mode       string                 `json:"mode"`
+	Raw        string                 `json:"raw,omitempty"`
+	URLEncoded []postmanVariable      `json:"urlencoded,omitempty"`
+	Options    map[string]interface{} `json:"options,omitempty"`
+}
+
+// Groups whose routes take no credentials, or one in the path, or the admin token instead of the
+// tenant's. Undocumented groups speak another protocol, which their clients already know.
+var (
+	unauthenticatedGroups = map[string]bool{"public": true, "share": true, "auth": true, "actions-cache": true}
+	undocumentedGroups    = map[string]bool{"webdav": true, "s3": true}
+	adminAuth             = &postmanAuth{Type: "bearer", Bearer: []postmanVariable{{Key: "token", Value: "{{adminToken}}"}}}
+)
+
+// buildPostmanCollection turns the route table into a Postman v2.1
+// collection, one folder per route group. Insomnia imports the same format.
+func buildPostmanCollection(g routeGroup, baseURL string) postmanCollection {
+	collection := postmanCollection{
+		Info: postmanInfo{
+			Name:        "test-api " + apiVersion(),
+			Description: "Generated from the route table. Set apiKey, or switch the collection auth to Bearer Token for a JWT.",
+			Schema:      postmanSchema,
+		},
+		Auth: &postmanAuth{Type: "apikey", APIKey: []postmanVariable{
+			{Key: "key", Value: "X-API-Key"},
+			{Key: "value", Value: "{{apiKey}}"},
+			{Key: "in", Value: "header"},
+		}},
+		Variable: []postmanVariable{
+			{Key: "baseUrl", Value: baseURL},
+			{Key: "apiKey", Value: "", Description: "API key for X-API-Key"},
+			{Key: "adminToken", Value: "", Description: "ADMIN_TOKEN, for the admin routes"},
+			{Key: "bucket", Value: "", Description: "Name of a bucket listed in BUCKETS"},
+			{Key: "shareToken", Value: "", Description: "Token returned when creating a share link"},
+			{Key: "versionId", Value: ""},
+			{Key: "exportId", Value: ""},
+		},
+	}
+
+	folders := map[string]int{}
+	walkRoutes(g, "", nil, func(rt registeredRoute, _ []middleware) {
+		if undocumentedGroups[rt.Group] {
+			return
+		}
+		folder := rt.Group
+		if strings.HasPrefix(rt.Path, "/api/buckets/{bucket}/") {
+			folder = "buckets"
+		}
+		i, ok := folders[folder]
+		if !ok {
+			i = len(collection.Item)
+			folders[folder] = i
+			collection.Item = append(collection.Item, postmanItem{Name: folder})
+		}
+		collection.Item[i].Item = append(collection.Item[i].Item, postmanItem{
+			Name:    rt.Summary,
+			Request: postmanRequestFor(rt),
+		})
+	})
+	return collection
+}
+
+func postmanRequestFor(rt registeredRoute) *postmanRequest {
+	op := operations[operationID(rt.Handler)]
+	path, params := openAPIPath(rt.Path)
+
+	req := &postmanRequest{
+		Method: rt.Method,
+		Header: []postmanVariable{},
+		URL:    postmanURL{Host: []string{"{{baseUrl}}"}},
+	}
+	for _, name := range params {
+		path = strings.Replace(path, "{"+name+"}", ":"+name, 1)
+		req.URL.Variable = append(req.URL.Variable, postmanVariable{Key: name, Value: pathExamples[name]})
+	}
+	req.URL.Path = strings.Split(strings.TrimPrefix(path, "/"), "/")
+	req.URL.Raw = "{{baseUrl}}" + path
+	for _, q := range op.Query {
+		req.URL.Query = append(req.URL.Query, postmanVariable{Key: q.Name, Description: q.Description, Disabled: true})
+	}
+	for _, h := range op.Headers {
+		req.Header = append(req.Header, postmanVariable{Key: h.Name, Description: h.Description, Disabled: true})
+	}
+
+	switch {
+	case op.Request != nil:
+		example, _ := json.MarshalIndent(exampleFor(op), "", "  ")
+		req.Header = append(req.Header, postmanVariable{Key: "Content-Type", Value: "application/json"})
+		req.Body = &postmanBody{
+			Mode:    "raw",
+			Raw:     string(example),
+			Options: map[string]interface{}{"raw": map[string]string{"language": "json"}},
+		}
+	case op.Form != nil:
+		req.Body = &postmanBody{Mode: "urlencoded"}
+		for _, name := range jsonFields(reflect.TypeOf(op.Form)) {
+			req.Body.URLEncoded = append(req.Body.URLEncoded, postmanVariable{Key: name})
+		}
+	}
+
+	switch {
+	case unauthenticatedGroups[rt.Group]:
+		req.Auth = &postmanAuth{Type: "noauth"}
+	case rt.Group == "admin":
+		req.Auth = adminAuth
+	}
+	return req
+}
+
+// exampleFor is the operation's example request, or its request type's zero
+// value so every field is at least listed.
+func exampleFor(op operation) interface{} {
+	if op.Example != nil {
+		return op.Example
+	}
+	return op.Request
+}
+
+func jsonFields(t reflect.Type) []string {
+	var fields []string
+	for i := 0; i < t.NumField(); i++ {
+		if name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ","); name != "" && name != "-" {
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
+
+// requestBaseURL is where the caller reached the API, so the collection works
+// without editing baseUrl.
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme, _, _ = strings.Cut(proto, ",")
+	}
+	return scheme + "://" + r.Host
+}
+
+func collectionHandler(w http.ResponseWriter, r *http.Request) {
+	collection := buildPostmanCollection(apiRoutes(), requestBaseURL(r))
+	w.Header().Set("Content-Disposition", `attachment; filename="test-api.postman_collection.json"`)
+	setCacheControl(w, "public", time.Hour)
+	respondJSON(w, http.StatusOK, collection)
+}
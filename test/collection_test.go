@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestPostmanCollection(t *testing.T) {
+	srv, _ := newTestServer(t)
+	resp := call(t, srv, "GET", "/api/docs/collection", nil, "X-Forwarded-Proto", "https")
+	expectStatus(t, resp, http.StatusOK)
+
+	var collection postmanCollection
+	resp.decode(t, &collection)
+	if collection.Info.Schema != postmanSchema || collection.Auth.Type != "apikey" {
+		t.Fatalf("info %+v auth %+v", collection.Info, collection.Auth)
+	}
+	if base := collection.Variable[0]; base.Key != "baseUrl" || base.Value != "https://"+resp.Request.URL.Host {
+		t.Fatalf("baseUrl %+v", base)
+	}
+
+	requests := map[string]*postmanRequest{}
+	for _, folder := range collection.Item {
+		for _, item := range folder.Item {
+			requests[item.Request.Method+" "+item.Request.URL.Raw] = item.Request
+		}
+	}
+	routes := 0
+	walkRoutes(apiRoutes(), "", nil, func(rt registeredRoute, _ []middleware) {
+		if !undocumentedGroups[rt.Group] {
+			routes++
+		}
+	})
+	if len(requests) != routes {
+		t.Fatalf("%d requests for %d routes", len(requests), routes)
+	}
+
+	upload := requests["POST {{baseUrl}}/api/upload"]
+	var body UploadRequest
+	if err := json.Unmarshal([]byte(upload.Body.Raw), &body); err != nil || body.Filename == "" || body.Content == "" {
+		t.Fatalf("upload example %q: %v", upload.Body.Raw, err)
+	}
+	if upload.Auth != nil {
+		t.Fatalf("upload overrides the collection auth: %+v", upload.Auth)
+	}
+
+	file := requests["GET {{baseUrl}}/api/buckets/:bucket/files/:filename"]
+	if file == nil || len(file.URL.Variable) != 2 || file.URL.Query[0].Key != "version_id" {
+		t.Fatalf("bucket file request %+v", file)
+	}
+	if share := requests["GET {{baseUrl}}/api/share/:token"]; share.Auth.Type != "noauth" {
+		t.Fatalf("share auth %+v", share.Auth)
+	}
+	if debug := requests["GET {{baseUrl}}/api/admin/debug/object/:key"]; debug.Auth.Type != "bearer" {
+		t.Fatalf("admin auth %+v", debug.Auth)
+	}
+	if unlock := requests["POST {{baseUrl}}/api/share/:token"]; unlock.Body.Mode != "urlencoded" || unlock.Body.URLEncoded[0].Key != "password" {
+		t.Fatalf("share form %+v", unlock.Body)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+)
+
+var (
+	// Store compressible uploads as zstd even outside of a STORAGE_POLICIES prefix
+	autoCompression, _ = strconv.ParseBool(os.Getenv("ZSTD_COMPRESSION"))
+	autoCompressionMin = intFromEnv("ZSTD_MIN_SIZE", 1024)
+)
+
+var (
+	// Compress responses of compressible types for clients that accept gzip
+	// or deflate, once they are at least RESPONSE_COMPRESSION_MIN_SIZE bytes
+	responseCompression, _   = strconv.ParseBool(envOr("RESPONSE_COMPRESSION", "true"))
+	responseCompressionMin   = intFromEnv("RESPONSE_COMPRESSION_MIN_SIZE", 1024)
+	responseCompressionLevel = compressionLevelFromEnv("RESPONSE_COMPRESSION_LEVEL")
+)
+
+// compressionLevelFromEnv reads a gzip level, 1 (fastest) to 9 (smallest),
+// falling back to the default for anything else.
+func compressionLevelFromEnv(name string) int {
+	if level := intFromEnv(name, gzip.DefaultCompression); level >= gzip.BestSpeed && level <= gzip.BestCompression {
+		return level
+	}
+	return gzip.DefaultCompression
+}
+
+// The deflate content coding is zlib-wrapped, as RFC 9110 defines it.
+const encodingDeflate = "deflate"
+
+var compressibleTypes = []string{"json", "xml", "javascript", "csv", "yaml", "svg", "markdown", "x-ndjson"}
+
+// isCompressible guesses from the key's extension, or failing that the content,
+// whether the object is text-like enough to be worth compressing.
+func isCompressible(key string, content []byte) bool {
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = http.DetectContentType(content)
+	}
+	return isCompressibleType(contentType)
+}
+
+func isCompressibleType(contentType string) bool {
+	contentType, _, _ = mime.ParseMediaType(contentType)
+	if strings.HasPrefix(contentType, "text/") {
+		return true
+	}
+	for _, t := range compressibleTypes {
+		if strings.Contains(contentType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// autoCompressionFor decides whether an upload without an explicit policy
+// should be stored as zstd.
+func autoCompressionFor(key string, content []byte) bool {
+	return autoCompression && len(content) >= autoCompressionMin && isCompressible(key, content)
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header allows coding.
+func acceptsEncoding(header, coding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), coding) && strings.TrimSpace(name) != "*" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// getObjectNegotiated behaves like getObject, except that an object stored
+// with a single compression the client accepts is returned as stored. The
+// returned coding is then set as the response Content-Encoding.
+func getObjectNegotiated(ctx context.Context, input *s3.GetObjectInput, acceptEncoding string) (*s3.GetObjectOutput, string, error) {
+	result, err := s3Client.GetObject(ctx, input)
+	if err != nil {
+		return nil, "", err
+	}
+
+	encodings := storageEncodings(result.Metadata)
+	if len(encodings) == 1 && (encodings[0] == encodingZstd || encodings[0] == encodingGzip) && acceptsEncoding(acceptEncoding, encodings[0]) {
+		return result, encodings[0], nil
+	}
+
+	if len(encodings) == 0 {
+		return result, "", nil
+	}
+	if err := decodeResult(result, aws.ToString(input.Key)); err != nil {
+		return nil, "", err
+	}
+	return result, "", nil
+}
+
+// compressResponses compresses responses on the way out, for clients whose
+// Accept-Encoding allows it. Responses already encoded, such as downloads
+// served as stored, partial responses and those smaller than
+// responseCompressionMin are sent as they are.
+func compressResponses(next http.Handler) http.Handler {
+	if !responseCompression {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accept := r.Header.Get("Accept-Encoding")
+		coding := encodingGzip
+		if !acceptsEncoding(accept, coding) {
+			coding = encodingDeflate
+		}
+		if r.Method == http.MethodHead || r.Header.Get("Range") != "" || !acceptsEncoding(accept, coding) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, coding: coding, r: r}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// compressWriter holds back the start of a response until it knows whether
+// to compress it, which takes the headers and, when they don't give the
+// length, responseCompressionMin bytes of body.
+type compressWriter struct {
+	http.ResponseWriter
+	coding string
+	r      *http.Request
+
+	status  int
+	pending []byte
+	decided bool
+	encoder interface {
+		io.Writer
+		Flush() error
+		Close() error
+	}
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.decided || cw.status != 0 {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if status < http.StatusOK {
+		// Informational responses go out as they come
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	cw.status = status
+	if !cw.eligible() {
+		cw.start(false)
+		return
+	}
+	if length, err := strconv.Atoi(cw.Header().Get("Content-Length")); err == nil {
+		cw.start(length >= responseCompressionMin)
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		cw.pending = append(cw.pending, b...)
+		if len(cw.pending) >= responseCompressionMin {
+			cw.start(true)
+		}
+		return len(b), nil
+	}
+	if cw.encoder != nil {
+		return cw.encoder.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Flush sends what has been written so far. A response flushed before it
+// reached the threshold, like a followed tail, is sent uncompressed.
+func (cw *compressWriter) Flush() {
+	if cw.status != 0 && !cw.decided {
+		cw.start(false)
+	}
+	if cw.encoder != nil {
+		cw.encoder.Flush()
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// eligible reports whether the response could be compressed, going by its
+// status and headers. Downloads are sent as octet streams, so for those the
+// filename's type decides.
+func (cw *compressWriter) eligible() bool {
+	h := cw.Header()
+	switch cw.status {
+	case http.StatusNoContent, http.StatusPartialContent, http.StatusNotModified:
+		return false
+	}
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	contentType := h.Get("Content-Type")
+	if strings.HasPrefix(contentType, "application/octet-stream") {
+		vars := mux.Vars(cw.r)
+		name := vars["filename"]
+		if name == "" {
+			name = vars["path"]
+		}
+		contentType = mime.TypeByExtension(path.Ext(name))
+	}
+	if !isCompressibleType(contentType) {
+		return false
+	}
+	if !strings.Contains(strings.Join(h.Values("Vary"), ","), "Accept-Encoding") {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	return true
+}
+
+// start sends the headers, compressed or not, and whatever was held back.
+func (cw *compressWriter) start(compress bool) {
+	cw.decided = true
+	if compress {
+		h := cw.Header()
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", cw.coding)
+		if etag := h.Get("ETag"); etag != "" {
+			h.Set("ETag", publicETag(etag, cw.coding))
+		}
+		if cw.coding == encodingGzip {
+			cw.encoder, _ = gzip.NewWriterLevel(cw.ResponseWriter, responseCompressionLevel)
+		} else {
+			cw.encoder, _ = zlib.NewWriterLevel(cw.ResponseWriter, responseCompressionLevel)
+		}
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if len(cw.pending) > 0 {
+		if cw.encoder != nil {
+			cw.encoder.Write(cw.pending)
+		} else {
+			cw.ResponseWriter.Write(cw.pending)
+		}
+		cw.pending = nil
+	}
+}
+
+func (cw *compressWriter) close() {
+	if cw.status != 0 && !cw.decided {
+		cw.start(false)
+	}
+	if cw.encoder != nil {
+		cw.encoder.Close()
+	}
+}
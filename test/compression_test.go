@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestResponseCompression(t *testing.T) {
+	srv, _ := newTestServer(t)
+	override(t, &responseCompressionMin, 256)
+	for i := 0; i < 20; i++ {
+		mustUpload(t, srv, "/api", fmt.Sprintf("reports/2024/quarterly-%02d.txt", i), "x")
+	}
+	report := strings.Repeat(`{"region":"eu-west-1","total":1}`+"\n", 50)
+	mustUpload(t, srv, "/api", "report.json", report)
+	mustUpload(t, srv, "/api", "photo.png", report)
+
+	decode := func(resp response) []byte {
+		t.Helper()
+		var reader io.ReadCloser
+		var err error
+		switch resp.Header.Get("Content-Encoding") {
+		case "gzip":
+			reader, err = gzip.NewReader(bytes.NewReader(resp.body))
+		case "deflate":
+			reader, err = zlib.NewReader(bytes.NewReader(resp.body))
+		default:
+			return resp.body
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return body
+	}
+
+	for _, coding := range []string{"gzip", "deflate"} {
+		resp := call(t, srv, "GET", "/api/files", nil, "Accept-Encoding", coding)
+		expectStatus(t, resp, http.StatusOK)
+		if resp.Header.Get("Content-Encoding") != coding || !strings.Contains(strings.Join(resp.Header.Values("Vary"), ","), "Accept-Encoding") {
+			t.Fatalf("%s listing headers %v", coding, resp.Header)
+		}
+		var files FilesResponse
+		if err := json.Unmarshal(decode(resp), &files); err != nil || len(files.Files) != 22 {
+			t.Fatalf("%s listing %v: %v", coding, files.Files, err)
+		}
+	}
+	if resp := call(t, srv, "GET", "/api/files", nil, "Accept-Encoding", "identity"); resp.Header.Get("Content-Encoding") != "" {
+		t.Fatal("compressed for a client that doesn't accept it")
+	}
+	if resp := call(t, srv, "GET", "/api/files", nil, "Accept-Encoding", "gzip;q=0, deflate"); resp.Header.Get("Content-Encoding") != "deflate" {
+		t.Fatalf("ignored q=0: %q", resp.Header.Get("Content-Encoding"))
+	}
+
+	// Downloads are compressed by the file's type, and keep an ETag that
+	// preconditions accept
+	resp := call(t, srv, "GET", "/api/files/report.json", nil, "Accept-Encoding", "gzip")
+	if resp.Header.Get("Content-Encoding") != "gzip" || string(decode(resp)) != report || len(resp.body) >= len(report) {
+		t.Fatalf("download served %q, %d bytes", resp.Header.Get("Content-Encoding"), len(resp.body))
+	}
+	etag := resp.Header.Get("ETag")
+	if !strings.HasSuffix(etag, `-gzip"`) {
+		t.Fatalf("ETag %s", etag)
+	}
+	for _, tc := range []struct {
+		name, path string
+		headers    []string
+	}{
+		{"binary file", "/api/files/photo.png", nil},
+		{"small file", "/api/files/reports/2024/quarterly-00.txt", nil},
+		{"range", "/api/files/report.json", []string{"Range", "bytes=0-511"}},
+	} {
+		if resp := call(t, srv, "GET", tc.path, nil, append(tc.headers, "Accept-Encoding", "gzip")...); resp.Header.Get("Content-Encoding") != "" {
+			t.Fatalf("compressed the %s", tc.name)
+		}
+	}
+	expectStatus(t, call(t, srv, "DELETE", "/api/files/report.json", nil, "If-Match", etag), http.StatusOK)
+}
+
+func TestResponseCompressionDisabled(t *testing.T) {
+	override(t, &responseCompression, false)
+	override(t, &responseCompressionMin, 1)
+	srv, _ := newTestServer(t)
+	if resp := call(t, srv, "GET", "/api/files", nil, "Accept-Encoding", "gzip"); resp.Header.Get("Content-Encoding") != "" {
+		t.Fatal("compressed with RESPONSE_COMPRESSION=false")
+	}
+}
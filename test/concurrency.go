@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Upper bound on concurrent storage calls a single fan-out makes, so one
+// request can't exhaust the S3 client's connection pool. 0 means unbounded.
+var fanOutLimit = intFromEnv("FANOUT_CONCURRENCY", 8)
+
+// fanOut calls fn for i in [0, n) with at most fanOutLimit calls running at
+// once. The first failure cancels the context the remaining calls see and
+// stops new ones from starting. Every real failure is returned, joined;
+// cancellations caused by an earlier failure are left out.
+func fanOut(ctx context.Context, n int, fn func(ctx context.Context, i int) error) error {
+	g, gctx := errgroup.WithContext(ctx)
+	if fanOutLimit > 0 {
+		g.SetLimit(fanOutLimit)
+	}
+
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		if gctx.Err() != nil {
+			break
+		}
+		g.Go(func() error {
+			if err := gctx.Err(); err != nil {
+				return err
+			}
+			errs[i] = fn(gctx, i)
+			return errs[i]
+		})
+	}
+	g.Wait()
+
+	var failures []error
+	for _, err := range errs {
+		if err != nil && (ctx.Err() != nil || !errors.Is(err, context.Canceled)) {
+			failures = append(failures, err)
+		}
+	}
+	if len(failures) == 0 {
+		return ctx.Err()
+	}
+	return errors.Join(failures...)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFanOutBoundsConcurrency(t *testing.T) {
+	override(t, &fanOutLimit, 3)
+
+	var running, peak atomic.Int32
+	err := fanOut(context.Background(), 20, func(ctx context.Context, i int) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := peak.Load(); got > 3 {
+		t.Fatalf("%d calls ran at once", got)
+	}
+}
+
+func TestFanOutJoinsFailuresAndCancels(t *testing.T) {
+	override(t, &fanOutLimit, 2)
+	errFirst, errSecond := errors.New("first"), errors.New("second")
+
+	var started atomic.Int32
+	err := fanOut(context.Background(), 50, func(ctx context.Context, i int) error {
+		started.Add(1)
+		switch i {
+		case 0:
+			return errFirst
+		case 1:
+			// Still running when 0 fails, so it sees the cancellation
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return errSecond
+	})
+
+	if !errors.Is(err, errFirst) {
+		t.Fatalf("err %v", err)
+	}
+	if errors.Is(err, context.Canceled) {
+		t.Fatalf("cancellation reported as a failure: %v", err)
+	}
+	if n := started.Load(); n > 4 {
+		t.Fatalf("%d calls started after the first failure", n)
+	}
+}
+
+func TestFanOutReportsCallerCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	called := false
+	err := fanOut(ctx, 5, func(ctx context.Context, i int) error {
+		called = true
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) || called {
+		t.Fatalf("err %v, called %v", err, called)
+	}
+}
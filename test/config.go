@@ -0,0 +1,315 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the settings that can come from a configuration file as well as
+// the environment. Each is named by its section and key in the file and by
+// its environment variable, which overrides the file; flags.go has the flags
+// that override both. Everything else is set by environment variables only.
+type Config struct {
+	Server struct {
+		Port string `config:"port" env:"PORT"`
+		// See server.go
+		AdminAddr         string        `config:"admin_addr" env:"ADMIN_ADDR"`
+		ReadHeaderTimeout time.Duration `config:"read_header_timeout" env:"SERVER_READ_HEADER_TIMEOUT"`
+		ReadTimeout       time.Duration `config:"read_timeout" env:"SERVER_READ_TIMEOUT"`
+		WriteTimeout      time.Duration `config:"write_timeout" env:"SERVER_WRITE_TIMEOUT"`
+		IdleTimeout       time.Duration `config:"idle_timeout" env:"SERVER_IDLE_TIMEOUT"`
+		MaxHeaderBytes    int           `config:"max_header_bytes" env:"SERVER_MAX_HEADER_BYTES"`
+		RequestTimeout    time.Duration `config:"request_timeout" env:"REQUEST_TIMEOUT"`
+	} `config:"server"`
+
+	Storage struct {
+		// Only s3 for now
+		Driver string `config:"driver" env:"STORAGE_DRIVER"`
+		Bucket string `config:"bucket" env:"FILES_BUCKET_NAME"`
+		// Empty leaves it to the AWS SDK's own configuration
+		Region string `config:"region" env:"AWS_REGION"`
+	} `config:"storage"`
+
+	CORS struct {
+		AllowOrigin string `config:"allow_origin" env:"CORS_ALLOW_ORIGIN"`
+	} `config:"cors"`
+
+	Limits struct {
+		MaxBodyBytes       int64             `config:"max_body_bytes" env:"MAX_BODY_BYTES"`
+		MaxBodyBytesByType map[string]string `config:"max_body_bytes_by_type" env:"MAX_BODY_BYTES_BY_TYPE"`
+		RateLimitRPS       int               `config:"rate_limit_rps" env:"RATE_LIMIT_RPS"`
+		RateLimitBurst     int               `config:"rate_limit_burst" env:"RATE_LIMIT_BURST"`
+		// Route to latency budget; see slo.go
+		LatencySLOs map[string]string `config:"latency_slos" env:"LATENCY_SLOS"`
+	} `config:"limits"`
+
+	Auth struct {
+		// API key to tenant
+		APIKeys        map[string]string `config:"api_keys" env:"API_KEYS"`
+		AdminToken     string            `config:"admin_token" env:"ADMIN_TOKEN"`
+		JWTJWKSURL     string            `config:"jwt_jwks_url" env:"JWT_JWKS_URL"`
+		JWTIssuer      string            `config:"jwt_issuer" env:"JWT_ISSUER"`
+		JWTAudience    string            `config:"jwt_audience" env:"JWT_AUDIENCE"`
+		JWTTenantClaim string            `config:"jwt_tenant_claim" env:"JWT_TENANT_CLAIM"`
+	} `config:"auth"`
+
+	Log struct {
+		Level string `config:"level" env:"LOG_LEVEL"`
+	} `config:"log"`
+}
+
+// The configuration is loaded before anything else is initialised, since
+// the settings above are read into package variables when the package
+// loads. main reports any errors and exits.
+var (
+	configPath          = cmdline.configFile()
+	settings, configErr = loadConfig(configPath, cmdline.getenv)
+)
+
+func defaultConfig(getenv func(string) string) Config {
+	var c Config
+	c.Server.Port = "8080"
+	c.Server.ReadHeaderTimeout = 10 * time.Second
+	c.Server.ReadTimeout = time.Minute
+	c.Server.WriteTimeout = time.Minute
+	c.Server.IdleTimeout = 2 * time.Minute
+	c.Server.MaxHeaderBytes = 1 << 20
+	c.Server.RequestTimeout = 30 * time.Second
+
+	// The local development stack's bucket; the Nitric platform sets
+	// FILES_BUCKET_NAME
+	stack := getenv("NITRIC_STACK_ID")
+	if stack == "" {
+		stack = "test-api-dev-local"
+	}
+	c.Storage.Driver = "s3"
+	c.Storage.Bucket = stack + "-files"
+
+	c.CORS.AllowOrigin = "*"
+	c.Limits.MaxBodyBytes = 10 << 20
+	c.Limits.RateLimitBurst = 20
+	c.Auth.JWTTenantClaim = "sub"
+	c.Log.Level = "info"
+	return c
+}
+
+// loadConfig layers the file at path, if any, and then the environment over
+// the defaults, and validates the result. Every problem is reported, not
+// just the first.
+func loadConfig(path string, getenv func(string) string) (Config, error) {
+	c := defaultConfig(getenv)
+	var problems []error
+
+	if path != "" {
+		if file, err := readConfigFile(path); err != nil {
+			problems = append(problems, err)
+		} else {
+			problems = append(problems, applyConfigFile(reflect.ValueOf(&c).Elem(), file, "")...)
+		}
+	}
+	forEachSetting(reflect.ValueOf(&c).Elem(), "", func(key, env string, field reflect.Value) {
+		if value := getenv(env); value != "" {
+			if err := setSetting(field, value); err != nil {
+				problems = append(problems, fmt.Errorf("%s (%s): %w", key, env, err))
+			}
+		}
+	})
+	problems = append(problems, c.validate()...)
+
+	// The logger is made before main can report the problem
+	if new(slog.LevelVar).UnmarshalText([]byte(c.Log.Level)) != nil {
+		c.Log.Level = "info"
+	}
+	return c, errors.Join(problems...)
+}
+
+func (c *Config) validate() []error {
+	var problems []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			problems = append(problems, fmt.Errorf(format, args...))
+		}
+	}
+
+	port, err := strconv.Atoi(c.Server.Port)
+	check(err == nil && port > 0 && port < 1<<16, "server.port (PORT): %q is not a port number", c.Server.Port)
+	for key, d := range map[string]time.Duration{
+		"server.read_header_timeout (SERVER_READ_HEADER_TIMEOUT)": c.Server.ReadHeaderTimeout,
+		"server.read_timeout (SERVER_READ_TIMEOUT)":               c.Server.ReadTimeout,
+		"server.write_timeout (SERVER_WRITE_TIMEOUT)":             c.Server.WriteTimeout,
+		"server.idle_timeout (SERVER_IDLE_TIMEOUT)":               c.Server.IdleTimeout,
+		"server.request_timeout (REQUEST_TIMEOUT)":                c.Server.RequestTimeout,
+	} {
+		check(d > 0, "%s: must be positive", key)
+	}
+	check(c.Server.MaxHeaderBytes > 0, "server.max_header_bytes (SERVER_MAX_HEADER_BYTES): must be positive")
+
+	check(c.Storage.Driver == "s3", "storage.driver (STORAGE_DRIVER): %q is not supported; use s3", c.Storage.Driver)
+	check(c.Storage.Bucket != "", "storage.bucket (FILES_BUCKET_NAME): must be set")
+
+	check(c.CORS.AllowOrigin != "", "cors.allow_origin (CORS_ALLOW_ORIGIN): must be set; use * to allow any origin")
+
+	check(c.Limits.MaxBodyBytes >= 0, "limits.max_body_bytes (MAX_BODY_BYTES): must be 0 or more")
+	for mediaType, value := range c.Limits.MaxBodyBytesByType {
+		_, err := strconv.ParseInt(value, 10, 64)
+		check(err == nil, "limits.max_body_bytes_by_type (MAX_BODY_BYTES_BY_TYPE): %s: %q is not a number of bytes", mediaType, value)
+	}
+	check(c.Limits.RateLimitRPS >= 0, "limits.rate_limit_rps (RATE_LIMIT_RPS): must be 0 or more")
+	check(c.Limits.RateLimitBurst > 0, "limits.rate_limit_burst (RATE_LIMIT_BURST): must be positive")
+	for route, value := range c.Limits.LatencySLOs {
+		_, err := parseLatencySLO(value)
+		check(err == nil, "limits.latency_slos (LATENCY_SLOS): %s: %v", route, err)
+	}
+
+	if c.Auth.JWTJWKSURL != "" {
+		u, err := url.Parse(c.Auth.JWTJWKSURL)
+		check(err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != "", "auth.jwt_jwks_url (JWT_JWKS_URL): %q is not an http(s) URL", c.Auth.JWTJWKSURL)
+	}
+	check(c.Auth.JWTTenantClaim != "", "auth.jwt_tenant_claim (JWT_TENANT_CLAIM): must be set")
+
+	check(new(slog.LevelVar).UnmarshalText([]byte(c.Log.Level)) == nil, "log.level (LOG_LEVEL): %q must be debug, info, warn or error", c.Log.Level)
+
+	sort.Slice(problems, func(i, j int) bool { return problems[i].Error() < problems[j].Error() })
+	return problems
+}
+
+// readConfigFile parses a YAML, TOML or JSON file, by its extension.
+func readConfigFile(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+	var parse func(string) (map[string]any, error)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		parse = parseYAML
+	case ".toml":
+		parse = parseTOML
+	case ".json":
+		parse = parseJSONConfig
+	default:
+		return nil, fmt.Errorf("config %s: use a .yaml, .yml, .toml or .json file", path)
+	}
+	file, err := parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	return file, nil
+}
+
+// applyConfigFile sets the settings in file, and reports keys it doesn't
+// know so a misspelt one isn't silently ignored.
+func applyConfigFile(v reflect.Value, file map[string]any, at string) []error {
+	var problems []error
+	for _, key := range sortedAnyKeys(file) {
+		path := strings.TrimPrefix(at+"."+key, ".")
+		field, ok := fieldByKey(v, key)
+		if !ok {
+			problems = append(problems, fmt.Errorf("%s: unknown setting", path))
+			continue
+		}
+		value := file[key]
+		if field.Kind() == reflect.Struct {
+			section, ok := value.(map[string]any)
+			if !ok {
+				problems = append(problems, fmt.Errorf("%s: must be a section", path))
+				continue
+			}
+			problems = append(problems, applyConfigFile(field, section, path)...)
+			continue
+		}
+		if err := setSetting(field, value); err != nil {
+			problems = append(problems, fmt.Errorf("%s: %w", path, err))
+		}
+	}
+	return problems
+}
+
+func fieldByKey(v reflect.Value, key string) (reflect.Value, bool) {
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).Tag.Get("config") == key {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// forEachSetting calls fn with each setting's file key, environment
+// variable and field.
+func forEachSetting(v reflect.Value, at string, fn func(key, env string, field reflect.Value)) {
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		key := strings.TrimPrefix(at+"."+f.Tag.Get("config"), ".")
+		if f.Type.Kind() == reflect.Struct {
+			forEachSetting(v.Field(i), key, fn)
+			continue
+		}
+		fn(key, f.Tag.Get("env"), v.Field(i))
+	}
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setSetting sets field from an environment variable's string or a file's
+// value: a string, a list of strings, or for assignments a section.
+func setSetting(field reflect.Value, value any) error {
+	if field.Kind() == reflect.Map {
+		assignments := map[string]string{}
+		switch value := value.(type) {
+		case string:
+			assignments = parseAssignments(value)
+		case map[string]any:
+			for k, v := range value {
+				s, ok := v.(string)
+				if !ok {
+					return fmt.Errorf("%s: must be a single value", k)
+				}
+				assignments[k] = s
+			}
+		default:
+			return fmt.Errorf("must be key=value pairs or a section")
+		}
+		field.Set(reflect.ValueOf(assignments))
+		return nil
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("must be a single value")
+	}
+	switch {
+	case field.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration such as 30s", s)
+		}
+		field.SetInt(int64(d))
+	case field.Kind() == reflect.Int || field.Kind() == reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a whole number", s)
+		}
+		field.SetInt(n)
+	default:
+		field.SetString(s)
+	}
+	return nil
+}
+
+func sortedAnyKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
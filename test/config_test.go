@@ -0,0 +1,166 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func noEnv(string) string { return "" }
+
+func TestConfigFileFormats(t *testing.T) {
+	files := map[string]string{
+		"config.yaml": `
+# Production settings
+server:
+  port: 9000
+  read_timeout: 2m
+storage:
+  bucket: "prod-files"   # the # in a comment
+  region: eu-west-1
+cors:
+  allow_origin: 'https://app.example.com'
+limits:
+  max_body_bytes: 20971520
+  max_body_bytes_by_type: {application/json: 1048576}
+auth:
+  api_keys:
+    k3y-one: acme
+    "k3y#two": globex
+log:
+  level: debug
+`,
+		"config.toml": `
+# Production settings
+[server]
+port = 9000
+read_timeout = "2m"
+
+[storage]
+bucket = "prod-files"   # the # in a comment
+region = 'eu-west-1'
+
+[cors]
+allow_origin = "https://app.example.com"
+
+[limits]
+max_body_bytes = 20_971_520
+max_body_bytes_by_type = { "application/json" = 1048576 }
+
+[auth.api_keys]
+k3y-one = "acme"
+"k3y#two" = "globex"
+
+[log]
+level = "debug"
+`,
+		"config.json": `{
+  "server": {"port": 9000, "read_timeout": "2m"},
+  "storage": {"bucket": "prod-files", "region": "eu-west-1"},
+  "cors": {"allow_origin": "https://app.example.com"},
+  "limits": {"max_body_bytes": 20971520, "max_body_bytes_by_type": {"application/json": "1048576"}},
+  "auth": {"api_keys": {"k3y-one": "acme", "k3y#two": "globex"}},
+  "log": {"level": "debug"}
+}`,
+	}
+
+	want := defaultConfig(noEnv)
+	want.Server.Port = "9000"
+	want.Server.ReadTimeout = 2 * time.Minute
+	want.Storage.Bucket = "prod-files"
+	want.Storage.Region = "eu-west-1"
+	want.CORS.AllowOrigin = "https://app.example.com"
+	want.Limits.MaxBodyBytes = 20 << 20
+	want.Limits.MaxBodyBytesByType = map[string]string{"application/json": "1048576"}
+	want.Auth.APIKeys = map[string]string{"k3y-one": "acme", "k3y#two": "globex"}
+	want.Log.Level = "debug"
+
+	for name, content := range files {
+		got, err := loadConfig(writeConfig(t, name, content), noEnv)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s loaded\n%+v\nwant\n%+v", name, got, want)
+		}
+	}
+}
+
+func TestConfigEnvironmentOverridesFile(t *testing.T) {
+	path := writeConfig(t, "config.yaml", "server:\n  port: 9000\nauth:\n  admin_token: from-file\n")
+	env := map[string]string{"PORT": "9100", "API_KEYS": "k=acme", "NITRIC_STACK_ID": "stack"}
+	got, err := loadConfig(path, func(name string) string { return env[name] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Server.Port != "9100" || got.Auth.AdminToken != "from-file" || got.Auth.APIKeys["k"] != "acme" {
+		t.Errorf("loaded %+v", got)
+	}
+	if got.Storage.Bucket != "stack-files" {
+		t.Errorf("bucket %q, want the stack's", got.Storage.Bucket)
+	}
+}
+
+func TestConfigProblems(t *testing.T) {
+	path := writeConfig(t, "config.yaml", `
+server:
+  prot: 9000
+  read_timeout: soon
+storage:
+  driver: gcs
+log:
+  level: loud
+`)
+	env := map[string]string{"RATE_LIMIT_BURST": "0", "LATENCY_SLOS": "upload=500ms@100"}
+	got, err := loadConfig(path, func(name string) string { return env[name] })
+	if err == nil {
+		t.Fatal("loaded an invalid configuration")
+	}
+	for _, want := range []string{
+		"server.prot: unknown setting",
+		`server.read_timeout: "soon" is not a duration`,
+		`storage.driver (STORAGE_DRIVER): "gcs" is not supported`,
+		"limits.rate_limit_burst (RATE_LIMIT_BURST): must be positive",
+		`limits.latency_slos (LATENCY_SLOS): upload: "100" is not a percentage`,
+		`log.level (LOG_LEVEL): "loud"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("problems %q\nmissing %q", err, want)
+		}
+	}
+	if got.Log.Level != "info" {
+		t.Errorf("invalid log level kept as %q", got.Log.Level)
+	}
+
+	for name, content := range map[string]string{
+		"dup.yaml":     "server:\n  port: 1\n  port: 2\n",
+		"indent.yaml":  "server:\n  port: 1\n    extra: 2\n",
+		"anchor.yaml":  "server: &base\n  port: 1\n",
+		"dup.toml":     "[server]\nport = 1\nport = 2\n",
+		"string.toml":  "[server]\nport = \"9000\n",
+		"tables.toml":  "[[server]]\nport = 1\n",
+		"config.ini":   "port=1\n",
+		"missing.yaml": "",
+	} {
+		path := writeConfig(t, name, content)
+		if name == "missing.yaml" {
+			os.Remove(path)
+		}
+		if _, err := loadConfig(path, noEnv); err == nil {
+			t.Errorf("%s loaded", name)
+		}
+	}
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The configuration file formats are parsed here rather than with a YAML or
+// TOML library, which would be the service's largest dependency for a file
+// read once. Each parser returns sections as map[string]any, and values as
+// strings, or []any of them, for the settings to parse. What a configuration
+// file needs of either format is supported: nested sections, plain, quoted
+// and literal strings, numbers, booleans, and inline lists and tables. The
+// rest, such as YAML anchors and multi-line strings, is rejected rather than
+// misread.
+
+// parseYAML parses block mappings nested by indentation, with scalar,
+// sequence and flow values.
+func parseYAML(src string) (map[string]any, error) {
+	p := &yamlParser{}
+	for i, raw := range strings.Split(src, "\n") {
+		text := strings.TrimRight(stripComment(raw, false), " \t\r")
+		trimmed := strings.TrimLeft(text, " ")
+		switch {
+		case trimmed == "" || trimmed == "---" && len(p.lines) == 0:
+			continue
+		case strings.HasPrefix(trimmed, "\t"):
+			return nil, fmt.Errorf("line %d: indent with spaces, not tabs", i+1)
+		}
+		p.lines = append(p.lines, yamlLine{number: i + 1, indent: len(text) - len(trimmed), text: trimmed})
+	}
+	if len(p.lines) == 0 {
+		return map[string]any{}, nil
+	}
+	m, err := p.mapping(p.lines[0].indent)
+	if err == nil && p.pos < len(p.lines) {
+		err = fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].number)
+	}
+	return m, err
+}
+
+type yamlLine struct {
+	number, indent int
+	text           string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+func (p *yamlParser) mapping(indent int) (map[string]any, error) {
+	m := map[string]any{}
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent {
+		line := p.lines[p.pos]
+		key, rest, ok := cutYAMLKey(line.text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", line.number)
+		}
+		if _, dup := m[key]; dup {
+			return nil, fmt.Errorf("line %d: %s is set twice", line.number, key)
+		}
+		p.pos++
+
+		if rest != "" {
+			value, err := flowValue(rest)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line.number, err)
+			}
+			m[key] = value
+			continue
+		}
+		// An empty value is a nested block, or a sequence at the key's own
+		// indentation, or an empty string
+		switch next, ok := p.peek(); {
+		case ok && next.indent > indent:
+			value, err := p.block(next.indent)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = value
+		case ok && next.indent == indent && isYAMLItem(next.text):
+			value, err := p.sequence(indent)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = value
+		default:
+			m[key] = ""
+		}
+	}
+	return m, nil
+}
+
+func (p *yamlParser) block(indent int) (any, error) {
+	if isYAMLItem(p.lines[p.pos].text) {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+// sequence parses a list of scalars; lists of mappings or lists aren't
+// settings anything takes.
+func (p *yamlParser) sequence(indent int) ([]any, error) {
+	items := []any{}
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isYAMLItem(p.lines[p.pos].text) {
+		line := p.lines[p.pos]
+		item := strings.TrimSpace(strings.TrimPrefix(line.text, "-"))
+		if _, _, isMap := cutYAMLKey(item); isMap || item == "" || isYAMLItem(item) {
+			return nil, fmt.Errorf("line %d: list items must be single values", line.number)
+		}
+		value, err := flowValue(item)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line.number, err)
+		}
+		items = append(items, value)
+		p.pos++
+	}
+	return items, nil
+}
+
+func (p *yamlParser) peek() (yamlLine, bool) {
+	if p.pos < len(p.lines) {
+		return p.lines[p.pos], true
+	}
+	return yamlLine{}, false
+}
+
+func isYAMLItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// cutYAMLKey splits "key: value" at the first colon outside quotes that is
+// followed by a space or ends the line.
+func cutYAMLKey(text string) (key, rest string, ok bool) {
+	for i := indexOutsideQuotes(text, ':', 0); i >= 0; i = indexOutsideQuotes(text, ':', i+1) {
+		if i+1 == len(text) || text[i+1] == ' ' {
+			key, err := unquoteScalar(strings.TrimSpace(text[:i]))
+			if err != nil || key == "" {
+				return "", "", false
+			}
+			return key, strings.TrimSpace(text[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+// flowValue parses a YAML value written on one line: a scalar, [a, b] or
+// {key: value}.
+func flowValue(s string) (any, error) {
+	switch {
+	case strings.HasPrefix(s, "["):
+		if !strings.HasSuffix(s, "]") {
+			return nil, fmt.Errorf("unterminated list")
+		}
+		items := []any{}
+		for _, item := range splitOutsideQuotes(s[1:len(s)-1], ',') {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			value, err := unquoteScalar(item)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, value)
+		}
+		return items, nil
+	case strings.HasPrefix(s, "{"):
+		if !strings.HasSuffix(s, "}") {
+			return nil, fmt.Errorf("unterminated mapping")
+		}
+		m := map[string]any{}
+		for _, pair := range splitOutsideQuotes(s[1:len(s)-1], ',') {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			key, rest, ok := cutYAMLKey(pair)
+			if !ok {
+				return nil, fmt.Errorf("expected key: value in %q", pair)
+			}
+			value, err := unquoteScalar(rest)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = value
+		}
+		return m, nil
+	case strings.ContainsAny(s[:1], "|>&*!"):
+		return nil, fmt.Errorf("%q: multi-line strings, anchors and tags aren't supported", s)
+	}
+	return unquoteScalar(s)
+}
+
+// parseTOML parses key = value pairs under [table] headers, with dotted
+// keys, and arrays that may span lines.
+func parseTOML(src string) (map[string]any, error) {
+	root := map[string]any{}
+	table := root
+	lines := strings.Split(src, "\n")
+	for i := 0; i < len(lines); i++ {
+		number := i + 1
+		line := strings.TrimSpace(stripComment(lines[i], true))
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "[["):
+			return nil, fmt.Errorf("line %d: arrays of tables aren't supported", number)
+		case strings.HasPrefix(line, "["):
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: unterminated table header", number)
+			}
+			path, err := splitTOMLKey(line[1 : len(line)-1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", number, err)
+			}
+			if table, err = tomlTable(root, path); err != nil {
+				return nil, fmt.Errorf("line %d: %w", number, err)
+			}
+			continue
+		}
+
+		eq := indexOutsideQuotes(line, '=', 0)
+		if eq < 0 {
+			return nil, fmt.Errorf("line %d: expected key = value", number)
+		}
+		path, err := splitTOMLKey(line[:eq])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", number, err)
+		}
+		raw := strings.TrimSpace(line[eq+1:])
+		for strings.HasPrefix(raw, "[") && !bracketsClosed(raw) && i+1 < len(lines) {
+			i++
+			raw += " " + strings.TrimSpace(stripComment(lines[i], true))
+		}
+		value, err := tomlValue(raw)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", number, err)
+		}
+		parent, err := tomlTable(table, path[:len(path)-1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", number, err)
+		}
+		key := path[len(path)-1]
+		if _, dup := parent[key]; dup {
+			return nil, fmt.Errorf("line %d: %s is set twice", number, strings.Join(path, "."))
+		}
+		parent[key] = value
+	}
+	return root, nil
+}
+
+// tomlTable finds the table at path below t, making any that are missing.
+func tomlTable(t map[string]any, path []string) (map[string]any, error) {
+	for _, key := range path {
+		switch next := t[key].(type) {
+		case nil:
+			created := map[string]any{}
+			t[key] = created
+			t = created
+		case map[string]any:
+			t = next
+		default:
+			return nil, fmt.Errorf("%s is a value, not a table", key)
+		}
+	}
+	return t, nil
+}
+
+func splitTOMLKey(s string) ([]string, error) {
+	var path []string
+	for _, part := range splitOutsideQuotes(s, '.') {
+		key, err := unquoteScalar(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		if key == "" {
+			return nil, fmt.Errorf("empty key in %q", strings.TrimSpace(s))
+		}
+		path = append(path, key)
+	}
+	return path, nil
+}
+
+var tomlNumber = regexp.MustCompile(`^[+-]?[0-9][0-9_]*$`)
+
+func tomlValue(s string) (any, error) {
+	switch {
+	case s == "":
+		return nil, fmt.Errorf("missing value")
+	case strings.HasPrefix(s, `"""`) || strings.HasPrefix(s, "'''"):
+		return nil, fmt.Errorf("multi-line strings aren't supported")
+	case strings.HasPrefix(s, "["):
+		if !strings.HasSuffix(s, "]") {
+			return nil, fmt.Errorf("unterminated array")
+		}
+		items := []any{}
+		for _, item := range splitOutsideQuotes(s[1:len(s)-1], ',') {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			value, err := tomlValue(item)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, value)
+		}
+		return items, nil
+	case strings.HasPrefix(s, "{"):
+		if !strings.HasSuffix(s, "}") {
+			return nil, fmt.Errorf("unterminated inline table")
+		}
+		t := map[string]any{}
+		for _, pair := range splitOutsideQuotes(s[1:len(s)-1], ',') {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			eq := indexOutsideQuotes(pair, '=', 0)
+			if eq < 0 {
+				return nil, fmt.Errorf("expected key = value in %q", pair)
+			}
+			key, err := unquoteScalar(strings.TrimSpace(pair[:eq]))
+			if err != nil {
+				return nil, err
+			}
+			value, err := tomlValue(strings.TrimSpace(pair[eq+1:]))
+			if err != nil {
+				return nil, err
+			}
+			t[key] = value
+		}
+		return t, nil
+	case tomlNumber.MatchString(s):
+		return strings.ReplaceAll(s, "_", ""), nil
+	}
+	return unquoteScalar(s)
+}
+
+// parseJSONConfig reads a JSON object into the same shape as the other
+// formats, with numbers and booleans as the strings they were written as.
+func parseJSONConfig(src string) (map[string]any, error) {
+	dec := json.NewDecoder(bytes.NewReader([]byte(src)))
+	dec.UseNumber()
+	var file map[string]any
+	if err := dec.Decode(&file); err != nil {
+		return nil, err
+	}
+	value, err := jsonSettings(file)
+	if err != nil {
+		return nil, err
+	}
+	return value.(map[string]any), nil
+}
+
+func jsonSettings(v any) (any, error) {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			converted, err := jsonSettings(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			v[key] = converted
+		}
+		return v, nil
+	case []any:
+		for i, value := range v {
+			converted, err := jsonSettings(value)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = converted
+		}
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case string:
+		return v, nil
+	}
+	return nil, fmt.Errorf("null isn't a setting; leave it out")
+}
+
+// unquoteScalar returns a plain scalar as it is, and a quoted one without
+// its quotes: "..." with Go's escapes, which cover YAML's and TOML's common
+// ones, and '...', where YAML writes a quote as ”.
+func unquoteScalar(s string) (string, error) {
+	switch {
+	case len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"':
+		unquoted, err := strconv.Unquote(s)
+		if err != nil {
+			return "", fmt.Errorf("invalid string %s", s)
+		}
+		return unquoted, nil
+	case len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'':
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	case strings.HasPrefix(s, `"`) || strings.HasPrefix(s, "'"):
+		return "", fmt.Errorf("unterminated string %s", s)
+	}
+	return s, nil
+}
+
+// stripComment cuts a # comment from a line. YAML only starts one at the
+// start of the line or after a space; TOML anywhere outside a string.
+func stripComment(line string, anywhere bool) string {
+	for i := indexOutsideQuotes(line, '#', 0); i >= 0; i = indexOutsideQuotes(line, '#', i+1) {
+		if anywhere || i == 0 || line[i-1] == ' ' || line[i-1] == '\t' {
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// indexOutsideQuotes is the index of the first c at or after from that is
+// outside a quoted string, or -1.
+func indexOutsideQuotes(s string, c byte, from int) int {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote == '"' && s[i] == '\\':
+			i++
+		case quote != 0:
+			if s[i] == quote {
+				quote = 0
+			}
+		case s[i] == '"' || s[i] == '\'':
+			quote = s[i]
+		case s[i] == c && i >= from:
+			return i
+		}
+	}
+	return -1
+}
+
+// splitOutsideQuotes splits s at each sep outside strings and nested
+// brackets or braces.
+func splitOutsideQuotes(s string, sep byte) []string {
+	var parts []string
+	var quote byte
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		case c == sep && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// bracketsClosed reports whether every [ in s outside strings is closed.
+func bracketsClosed(s string) bool {
+	depth := 0
+	for i := indexOutsideQuotes(s, '[', 0); i >= 0; i = indexOutsideQuotes(s, '[', i+1) {
+		depth++
+	}
+	for i := indexOutsideQuotes(s, ']', 0); i >= 0; i = indexOutsideQuotes(s, ']', i+1) {
+		depth--
+	}
+	return depth <= 0
+}
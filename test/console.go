@@ -0,0 +1,50 @@
+package main
+
+import (
+	"embed"
+	"mime"
+	"net/http"
+	"path"
+
+	"github.com/gorilla/mux"
+)
+
+// The API console is a static page that builds "try it" forms from the
+// OpenAPI description. It holds no secrets itself, so it is served without
+// credentials, but only when the admin API is enabled.
+//
+//go:embed console
+var consoleFiles embed.FS
+
+const consoleCSP = "default-src 'self'; img-src 'self' blob: data:; frame-ancestors 'none'"
+
+func consoleHandler(w http.ResponseWriter, r *http.Request) {
+	serveConsoleFile(w, "index.html")
+}
+
+func consoleAssetHandler(w http.ResponseWriter, r *http.Request) {
+	serveConsoleFile(w, mux.Vars(r)["asset"])
+}
+
+func serveConsoleFile(w http.ResponseWriter, name string) {
+	if adminToken == "" {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{
+			Error: "Admin API is disabled",
+		})
+		return
+	}
+
+	content, err := consoleFiles.ReadFile(path.Join("console", path.Clean("/"+name)))
+	if err != nil {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{
+			Error: "Console file not found",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", mime.TypeByExtension(path.Ext(name)))
+	w.Header().Set("Content-Security-Policy", consoleCSP)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(content)
+}
@@ -0,0 +1,392 @@
+// API console: "try it" forms built from the OpenAPI description, sent with
+// the credentials entered once at the top of the page.
+const SPEC_URL = '/api/openapi.json';
+const CREDENTIALS = ['apiKey', 'token', 'adminToken'];
+const METHODS = ['get', 'post', 'put', 'patch', 'delete'];
+
+let spec = null;
+let current = null;
+
+const $ = (id) => document.getElementById(id);
+
+function el(tag, attrs = {}, ...children) {
+    const node = document.createElement(tag);
+    for (const [name, value] of Object.entries(attrs)) {
+        if (name === 'class') {
+            node.className = value;
+        } else {
+            node.setAttribute(name, value);
+        }
+    }
+    node.append(...children);
+    return node;
+}
+
+// Resolves a local $ref, e.g. #/components/schemas/UploadRequest
+function resolve(schema) {
+    if (schema && schema.$ref) {
+        return schema.$ref.replace(/^#\//, '').split('/').reduce((node, part) => node[part], spec);
+    }
+    return schema || {};
+}
+
+// skeleton is a placeholder value for a schema, so every field of a request
+// body is at least listed.
+function skeleton(schema, depth = 0) {
+    schema = resolve(schema);
+    if (schema.example !== undefined) {
+        return schema.example;
+    }
+    switch (schema.type) {
+        case 'object': {
+            const value = {};
+            for (const [name, property] of Object.entries(schema.properties || {})) {
+                value[name] = depth < 3 ? skeleton(property, depth + 1) : null;
+            }
+            return value;
+        }
+        case 'array':
+            return [];
+        case 'integer':
+        case 'number':
+            return 0;
+        case 'boolean':
+            return false;
+        default:
+            return '';
+    }
+}
+
+function loadCredentials() {
+    const remember = sessionStorage.getItem('console.remember') === 'true';
+    $('remember').checked = remember;
+    for (const name of CREDENTIALS) {
+        $(name).value = remember ? sessionStorage.getItem(`console.${name}`) || '' : '';
+        $(name).addEventListener('input', saveCredentials);
+    }
+    $('remember').addEventListener('change', saveCredentials);
+}
+
+function saveCredentials() {
+    const remember = $('remember').checked;
+    sessionStorage.setItem('console.remember', String(remember));
+    for (const name of CREDENTIALS) {
+        if (remember) {
+            sessionStorage.setItem(`console.${name}`, $(name).value);
+        } else {
+            sessionStorage.removeItem(`console.${name}`);
+        }
+    }
+}
+
+// authHeaders picks the credentials an operation accepts: none for public
+// routes, the admin token for admin ones, and otherwise the API key or bearer
+// token, leaving the session cookie to the browser.
+function authHeaders(op) {
+    const security = op.security || spec.security || [];
+    if (security.length === 0) {
+        return {};
+    }
+    if (security.some((s) => 'adminToken' in s)) {
+        return $('adminToken').value ? { Authorization: `Bearer ${$('adminToken').value}` } : {};
+    }
+    const headers = {};
+    if ($('apiKey').value) {
+        headers['X-API-Key'] = $('apiKey').value;
+    }
+    if ($('token').value) {
+        headers.Authorization = `Bearer ${$('token').value}`;
+    }
+    return headers;
+}
+
+function listOperations() {
+    const groups = {};
+    for (const [path, item] of Object.entries(spec.paths)) {
+        for (const method of METHODS) {
+            if (item[method]) {
+                const op = { ...item[method], method: method.toUpperCase(), path };
+                const tag = (op.tags && op.tags[0]) || 'other';
+                (groups[tag] = groups[tag] || []).push(op);
+            }
+        }
+    }
+
+    const container = $('operations');
+    container.replaceChildren();
+    for (const tag of Object.keys(groups).sort()) {
+        const list = el('ul');
+        for (const op of groups[tag].sort((a, b) => a.path.localeCompare(b.path))) {
+            const link = el('a', { href: `#${op.operationId}` },
+                el('span', { class: `method ${op.method.toLowerCase()}` }, op.method), ' ', op.path);
+            link.dataset.search = `${op.method} ${op.path} ${op.summary || ''} ${op.operationId}`.toLowerCase();
+            link.title = op.summary || '';
+            link.addEventListener('click', () => select(op));
+            list.append(el('li', {}, link));
+        }
+        container.append(el('h3', {}, tag), list);
+    }
+
+    const wanted = location.hash.slice(1);
+    for (const ops of Object.values(groups)) {
+        const op = ops.find((o) => o.operationId === wanted);
+        if (op) {
+            select(op);
+        }
+    }
+}
+
+function filterOperations() {
+    const query = $('filter').value.toLowerCase();
+    for (const link of $('operations').querySelectorAll('a')) {
+        link.parentElement.hidden = !link.dataset.search.includes(query);
+    }
+}
+
+function paramField(param) {
+    const input = el('input', { name: param.name, 'data-in': param.in });
+    if (param.in === 'path') {
+        input.required = true;
+    }
+    if (param.schema && param.schema.type === 'boolean') {
+        input.placeholder = 'true or false';
+    }
+    return el('label', { title: param.description || '' },
+        `${param.name} (${param.in})`, input,
+        param.description ? el('small', {}, param.description) : '');
+}
+
+function select(op) {
+    current = op;
+    $('request').hidden = false;
+    $('response').hidden = true;
+    $('method').textContent = op.method;
+    $('method').className = `method ${op.method.toLowerCase()}`;
+    $('path').textContent = op.path;
+    $('summary').textContent = op.summary || '';
+
+    $('params').replaceChildren(...(op.parameters || []).map(paramField));
+
+    const content = (op.requestBody && op.requestBody.content) || {};
+    const json = content['application/json'];
+    const form = content['application/x-www-form-urlencoded'];
+    $('body').hidden = !json && !form;
+    $('bodyText').hidden = !json;
+    $('formFields').replaceChildren();
+    $('fileHelper').hidden = true;
+    if (json) {
+        const body = json.example !== undefined ? json.example : skeleton(json.schema);
+        $('bodyText').value = JSON.stringify(body, null, 2);
+        const properties = resolve(json.schema).properties || {};
+        $('fileHelper').hidden = !('filename' in properties && 'content' in properties);
+    }
+    if (form) {
+        const properties = resolve(form.schema).properties || {};
+        $('formFields').append(...Object.keys(properties).map((name) =>
+            el('label', {}, name, el('input', { name, 'data-in': 'form' }))));
+    }
+}
+
+// buildRequest turns the form into a URL and fetch options. Path parameters
+// may hold slashes, e.g. folder/file.txt, so each segment is encoded alone.
+function buildRequest() {
+    let path = current.path;
+    const query = new URLSearchParams();
+    const headers = authHeaders(current);
+    for (const input of $('params').querySelectorAll('input')) {
+        if (input.value === '') {
+            continue;
+        }
+        switch (input.dataset.in) {
+            case 'path':
+                path = path.replace(`{${input.name}}`, input.value.split('/').map(encodeURIComponent).join('/'));
+                break;
+            case 'query':
+                query.append(input.name, input.value);
+                break;
+            case 'header':
+                headers[input.name] = input.value;
+                break;
+        }
+    }
+
+    const init = { method: current.method, headers, redirect: 'manual' };
+    if (!$('body').hidden) {
+        if (!$('bodyText').hidden) {
+            headers['Content-Type'] = 'application/json';
+            init.body = $('bodyText').value;
+        } else {
+            const form = new URLSearchParams();
+            for (const input of $('formFields').querySelectorAll('input')) {
+                form.append(input.name, input.value);
+            }
+            headers['Content-Type'] = 'application/x-www-form-urlencoded';
+            init.body = form.toString();
+        }
+    }
+
+    const search = query.toString();
+    return { url: path + (search ? `?${search}` : ''), init };
+}
+
+// redirects answer with a 302 the browser has to follow itself, e.g. the OIDC
+// login, so they are opened rather than fetched.
+function redirects(op) {
+    return Object.keys(op.responses || {}).includes('302');
+}
+
+async function send(event) {
+    event.preventDefault();
+    if (!$('request').reportValidity()) {
+        return;
+    }
+    if (!$('bodyText').hidden && !$('body').hidden) {
+        try {
+            JSON.parse($('bodyText').value);
+        } catch (error) {
+            showError(`Body is not valid JSON: ${error.message}`);
+            return;
+        }
+    }
+
+    const { url, init } = buildRequest();
+    if (redirects(current)) {
+        window.open(url, '_blank', 'noopener');
+        return;
+    }
+
+    const started = performance.now();
+    let response;
+    try {
+        response = await fetch(url, init);
+    } catch (error) {
+        showError(`Request failed: ${error.message}`);
+        return;
+    }
+    await showResponse(response, performance.now() - started);
+}
+
+function showError(message) {
+    $('response').hidden = false;
+    $('status').textContent = '';
+    $('status').className = 'status error';
+    $('elapsed').textContent = '';
+    $('headers').replaceChildren();
+    $('responseBody').textContent = message;
+    $('download').hidden = true;
+}
+
+async function showResponse(response, elapsed) {
+    $('response').hidden = false;
+    $('status').textContent = `${response.status} ${response.statusText}`;
+    $('status').className = `status ${response.ok ? 'success' : 'error'}`;
+    $('elapsed').textContent = `${Math.round(elapsed)} ms`;
+    $('headers').replaceChildren(...[...response.headers].map(([name, value]) =>
+        el('tr', {}, el('th', {}, name), el('td', {}, value))));
+
+    const previous = $('download').href;
+    if (previous) {
+        URL.revokeObjectURL(previous);
+        $('download').removeAttribute('href');
+    }
+    $('download').hidden = true;
+
+    const contentType = response.headers.get('Content-Type') || '';
+    if (contentType.includes('json')) {
+        const text = await response.text();
+        try {
+            $('responseBody').textContent = JSON.stringify(JSON.parse(text), null, 2);
+        } catch {
+            $('responseBody').textContent = text;
+        }
+    } else if (contentType.startsWith('text/')) {
+        $('responseBody').textContent = await response.text();
+    } else {
+        const blob = await response.blob();
+        $('responseBody').textContent = `${blob.size} bytes of ${contentType || 'binary data'}`;
+        const disposition = response.headers.get('Content-Disposition') || '';
+        const match = disposition.match(/filename="?([^";]+)"?/);
+        $('download').href = URL.createObjectURL(blob);
+        $('download').download = match ? match[1] : 'download';
+        $('download').hidden = false;
+    }
+}
+
+function shellQuote(value) {
+    return `'${value.replace(/'/g, `'\\''`)}'`;
+}
+
+// curlCommand reproduces the request with credentials left as shell
+// variables, so it can be pasted into a ticket as is.
+function curlCommand() {
+    const { url, init } = buildRequest();
+    const placeholders = {
+        [$('apiKey').value]: '$API_KEY',
+        [$('token').value]: '$TOKEN',
+        [$('adminToken').value]: '$ADMIN_TOKEN',
+    };
+    const parts = ['curl', '-i', '-X', init.method, shellQuote(location.origin + url)];
+    for (let [name, value] of Object.entries(init.headers)) {
+        const secret = name === 'Authorization' ? value.replace(/^Bearer /, '') : value;
+        if ((name === 'Authorization' || name === 'X-API-Key') && placeholders[secret]) {
+            value = value.replace(secret, placeholders[secret]);
+            parts.push('-H', `"${name}: ${value}"`);
+            continue;
+        }
+        parts.push('-H', shellQuote(`${name}: ${value}`));
+    }
+    if (init.body !== undefined) {
+        parts.push('--data-raw', shellQuote(init.body));
+    }
+    return parts.join(' ');
+}
+
+async function copyCurl() {
+    const command = curlCommand();
+    try {
+        await navigator.clipboard.writeText(command);
+        $('curl').textContent = 'Copied';
+    } catch {
+        showError(command);
+    }
+    setTimeout(() => { $('curl').textContent = 'Copy as curl'; }, 1500);
+}
+
+// fillFromFile puts a picked file into an upload body as base64.
+function fillFromFile() {
+    const file = $('file').files[0];
+    if (!file) {
+        return;
+    }
+    const reader = new FileReader();
+    reader.onload = () => {
+        let body = {};
+        try {
+            body = JSON.parse($('bodyText').value);
+        } catch {
+            // Start over from an empty body
+        }
+        body.filename = body.filename || file.name;
+        body.content = reader.result.split(',')[1];
+        $('bodyText').value = JSON.stringify(body, null, 2);
+    };
+    reader.readAsDataURL(file);
+}
+
+document.addEventListener('DOMContentLoaded', async () => {
+    loadCredentials();
+    $('filter').addEventListener('input', filterOperations);
+    $('request').addEventListener('submit', send);
+    $('curl').addEventListener('click', copyCurl);
+    $('file').addEventListener('change', fillFromFile);
+
+    try {
+        const response = await fetch(SPEC_URL);
+        spec = await response.json();
+    } catch (error) {
+        showError(`Failed to load ${SPEC_URL}: ${error.message}`);
+        return;
+    }
+    $('version').textContent = spec.info.version;
+    listOperations();
+});
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestConsole(t *testing.T) {
+	srv, _ := newTestServer(t)
+	expectStatus(t, call(t, srv, "GET", "/api/console", nil), http.StatusNotFound)
+
+	override(t, &adminToken, "admin-secret")
+	page := call(t, srv, "GET", "/api/console", nil)
+	expectStatus(t, page, http.StatusOK)
+	if !strings.HasPrefix(page.Header.Get("Content-Type"), "text/html") || !strings.Contains(string(page.body), `src="console/console.js"`) {
+		t.Fatalf("console page %v %q", page.Header, page.body)
+	}
+	if strings.Contains(string(page.body), "admin-secret") {
+		t.Fatal("console page leaks the admin token")
+	}
+
+	script := call(t, srv, "GET", "/api/console/console.js", nil)
+	expectStatus(t, script, http.StatusOK)
+	if !strings.Contains(script.Header.Get("Content-Type"), "javascript") || script.Header.Get("Content-Security-Policy") != consoleCSP {
+		t.Fatalf("console script headers %v", script.Header)
+	}
+	expectStatus(t, call(t, srv, "GET", "/api/console/nope.js", nil), http.StatusNotFound)
+}
+
+// The console picks credentials from each operation's security requirement.
+func TestOpenAPISecurity(t *testing.T) {
+	spec, err := buildOpenAPISpec(apiRoutes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := spec["paths"].(map[string]map[string]interface{})
+	security := func(path, method string) interface{} {
+		return paths[path][method].(map[string]interface{})["security"]
+	}
+	if s, ok := security("/api/share/{token}", "get").([]interface{}); !ok || len(s) != 0 {
+		t.Fatalf("share security %v", s)
+	}
+	if s := security("/api/admin/debug/object/{key}", "get").([]interface{}); len(s) != 1 {
+		t.Fatalf("admin security %v", s)
+	}
+	if s := security("/api/files", "get"); s != nil {
+		t.Fatalf("tenant routes override the default security: %v", s)
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const requestCostHeader = "X-Request-Cost"
+
+// requestCost is the work one request made the service do.
+type requestCost struct {
+	s3Ops        atomic.Int64
+	bytesRead    atomic.Int64
+	bytesWritten atomic.Int64
+	cacheHits    atomic.Int64
+}
+
+type requestCostKey struct{}
+
+func costFrom(ctx context.Context) *requestCost {
+	cost, _ := ctx.Value(requestCostKey{}).(*requestCost)
+	return cost
+}
+
+// countS3Call adds a storage call to the cost of the request making it.
+func countS3Call(ctx context.Context) {
+	if cost := costFrom(ctx); cost != nil {
+		cost.s3Ops.Add(1)
+	}
+}
+
+// countS3Bytes adds what a storage call read from or wrote to the bucket.
+func countS3Bytes(ctx context.Context, read, written int64) {
+	if cost := costFrom(ctx); cost != nil {
+		cost.bytesRead.Add(read)
+		cost.bytesWritten.Add(written)
+	}
+}
+
+// bodyLength is the length of a request body, which the SDK works out from
+// readers like bytes.Reader when it isn't declared.
+func bodyLength(body io.Reader, declared *int64) int64 {
+	if declared != nil {
+		return *declared
+	}
+	if sized, ok := body.(interface{ Len() int }); ok {
+		return int64(sized.Len())
+	}
+	return 0
+}
+
+// countCacheHit records that a cache answered instead of storage.
+func countCacheHit(ctx context.Context) {
+	if cost := costFrom(ctx); cost != nil {
+		cost.cacheHits.Add(1)
+	}
+}
+
+func (c *requestCost) header() string {
+	return fmt.Sprintf("s3_ops=%d, bytes_read=%d, bytes_written=%d, cache_hits=%d",
+		c.s3Ops.Load(), c.bytesRead.Load(), c.bytesWritten.Load(), c.cacheHits.Load())
+}
+
+// costWriter sets the cost header when the response starts, which for
+// streamed downloads is before the body has been read from storage.
+type costWriter struct {
+	http.ResponseWriter
+	cost    *requestCost
+	started bool
+}
+
+func (cw *costWriter) start(status int) {
+	if cw.started {
+		return
+	}
+	cw.started = true
+	if status == http.StatusNotModified {
+		// The client's copy was still good
+		cw.cost.cacheHits.Add(1)
+	}
+	cw.Header().Set(requestCostHeader, cw.cost.header())
+}
+
+func (cw *costWriter) WriteHeader(status int) {
+	cw.start(status)
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *costWriter) Write(p []byte) (int, error) {
+	cw.start(http.StatusOK)
+	return cw.ResponseWriter.Write(p)
+}
+
+func (cw *costWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// costClient names the credential a request was made with: an API key by the
+// ID the tenant registry gives it, anything else by its subject.
+func costClient(r *http.Request) string {
+	p, ok := requestPrincipal(r)
+	if !ok {
+		return "anonymous"
+	}
+	if p.Method == "api_key" {
+		sum := sha256.Sum256([]byte(requestCredential(r)))
+		return "api_key:" + hex.EncodeToString(sum[:])[:12]
+	}
+	return p.Method + ":" + p.Subject
+}
+
+// accountCosts counts the storage calls, bytes and cache hits of each
+// request, reports them in X-Request-Cost and adds them to the tenant's and
+// the credential's totals.
+func accountCosts(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cost := &requestCost{}
+		cw := &costWriter{ResponseWriter: w, cost: cost}
+		next.ServeHTTP(cw, r.WithContext(context.WithValue(r.Context(), requestCostKey{}, cost)))
+		// Handlers that write nothing leave the server to send the headers
+		cw.start(http.StatusOK)
+		costs.record(requestTenant(r), costClient(r), cost)
+	})
+}
+
+// CostTotals adds up the costs of a client's requests.
+type CostTotals struct {
+	Requests     int64 `json:"requests"`
+	S3Operations int64 `json:"s3_operations"`
+	BytesRead    int64 `json:"bytes_read"`
+	BytesWritten int64 `json:"bytes_written"`
+	CacheHits    int64 `json:"cache_hits"`
+}
+
+func (t *CostTotals) add(c CostTotals) {
+	t.Requests += c.Requests
+	t.S3Operations += c.S3Operations
+	t.BytesRead += c.BytesRead
+	t.BytesWritten += c.BytesWritten
+	t.CacheHits += c.CacheHits
+}
+
+type ClientCost struct {
+	Client string `json:"client"`
+	CostTotals
+}
+
+type TenantCost struct {
+	Tenant string `json:"tenant"`
+	CostTotals
+	Clients []ClientCost `json:"clients"`
+}
+
+type CostsResponse struct {
+	Since   string       `json:"since"`
+	Tenants []TenantCost `json:"tenants"`
+}
+
+// costTracker keeps each tenant's and credential's totals since this
+// instance started.
+type costTracker struct {
+	since time.Time
+
+	mu      sync.Mutex
+	tenants map[string]map[string]*CostTotals
+}
+
+var costs = newCostTracker()
+
+func newCostTracker() *costTracker {
+	return &costTracker{since: time.Now(), tenants: map[string]map[string]*CostTotals{}}
+}
+
+func (t *costTracker) record(tenant, client string, cost *requestCost) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	clients := t.tenants[tenant]
+	if clients == nil {
+		clients = map[string]*CostTotals{}
+		t.tenants[tenant] = clients
+	}
+	totals := clients[client]
+	if totals == nil {
+		totals = &CostTotals{}
+		clients[client] = totals
+	}
+	totals.add(CostTotals{
+		Requests:     1,
+		S3Operations: cost.s3Ops.Load(),
+		BytesRead:    cost.bytesRead.Load(),
+		BytesWritten: cost.bytesWritten.Load(),
+		CacheHits:    cost.cacheHits.Load(),
+	})
+}
+
+// tenant returns a tenant's totals, with its heaviest clients first.
+func (t *costTracker) tenant(tenant string) TenantCost {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	result := TenantCost{Tenant: tenant, Clients: []ClientCost{}}
+	for client, totals := range t.tenants[tenant] {
+		result.add(*totals)
+		result.Clients = append(result.Clients, ClientCost{Client: client, CostTotals: *totals})
+	}
+	sort.Slice(result.Clients, func(i, j int) bool {
+		a, b := result.Clients[i], result.Clients[j]
+		if a.S3Operations != b.S3Operations {
+			return a.S3Operations > b.S3Operations
+		}
+		return a.Client < b.Client
+	})
+	return result
+}
+
+func (t *costTracker) report(tenants []string) CostsResponse {
+	resp := CostsResponse{Since: t.since.UTC().Format(time.RFC3339), Tenants: []TenantCost{}}
+	for _, tenant := range tenants {
+		resp.Tenants = append(resp.Tenants, t.tenant(tenant))
+	}
+	sort.SliceStable(resp.Tenants, func(i, j int) bool {
+		return resp.Tenants[i].S3Operations > resp.Tenants[j].S3Operations
+	})
+	return resp
+}
+
+func (t *costTracker) tenantIDs() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return sortedKeys(t.tenants)
+}
+
+func usageCostsHandler(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, costs.report([]string{requestTenant(r)}))
+}
+
+func adminCostsHandler(w http.ResponseWriter, r *http.Request) {
+	tenants := costs.tenantIDs()
+	if tenant := r.URL.Query().Get("tenant"); tenant != "" {
+		tenants = []string{tenant}
+	}
+	respondJSON(w, http.StatusOK, costs.report(tenants))
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRequestCosts(t *testing.T) {
+	srv, fake := newTestServer(t)
+	override(t, &s3Client, withStorageMetrics(fake))
+	override(t, &costs, newCostTracker())
+	override(t, &apiKeys, map[string]string{"acme-key": "acme", "acme-ci-key": "acme", "globex-key": "globex"})
+	override(t, &adminToken, "admin-secret")
+	acme := []string{"X-API-Key", "acme-key"}
+
+	resp := call(t, srv, "POST", "/api/upload", upload("report.txt", "quarterly"), acme...)
+	expectStatus(t, resp, http.StatusOK)
+	if cost := resp.Header.Get(requestCostHeader); !strings.Contains(cost, "bytes_written=9") || strings.Contains(cost, "s3_ops=0") {
+		t.Errorf("upload cost %q", cost)
+	}
+	resp = call(t, srv, "GET", "/api/files/report.txt", nil, "X-API-Key", "acme-ci-key")
+	expectStatus(t, resp, http.StatusOK)
+	if cost := resp.Header.Get(requestCostHeader); !strings.Contains(cost, "bytes_read=9, bytes_written=0, cache_hits=0") {
+		t.Errorf("download cost %q", cost)
+	}
+	etag := call(t, srv, "HEAD", "/api/browse/report.txt", nil, acme...).Header.Get("ETag")
+	resp = call(t, srv, "GET", "/api/browse/report.txt", nil, "X-API-Key", "acme-key", "If-None-Match", etag)
+	expectStatus(t, resp, http.StatusNotModified)
+	if cost := resp.Header.Get(requestCostHeader); !strings.HasSuffix(cost, "cache_hits=1") {
+		t.Errorf("not modified cost %q", cost)
+	}
+	expectStatus(t, call(t, srv, "GET", "/api/files", nil, "X-API-Key", "globex-key"), http.StatusOK)
+
+	var own CostsResponse
+	call(t, srv, "GET", "/api/usage/costs", nil, acme...).decode(t, &own)
+	if len(own.Tenants) != 1 || own.Tenants[0].Tenant != "acme" || len(own.Tenants[0].Clients) != 2 {
+		t.Fatalf("own costs %+v", own)
+	}
+	// The request for the report itself is counted once it has been answered
+	acmeCost := own.Tenants[0]
+	if acmeCost.Requests != 4 || acmeCost.BytesRead != 9 || acmeCost.BytesWritten != 9 || acmeCost.CacheHits != 1 {
+		t.Errorf("acme totals %+v", acmeCost.CostTotals)
+	}
+	for _, client := range acmeCost.Clients {
+		if !strings.HasPrefix(client.Client, "api_key:") || strings.Contains(client.Client, "acme") {
+			t.Errorf("client %q should be named by its key ID", client.Client)
+		}
+	}
+
+	var all CostsResponse
+	call(t, srv, "GET", "/api/admin/costs", nil, "Authorization", "Bearer admin-secret").decode(t, &all)
+	if len(all.Tenants) != 2 {
+		t.Fatalf("all costs %+v", all)
+	}
+	var globex CostsResponse
+	call(t, srv, "GET", "/api/admin/costs?tenant=globex", nil, "Authorization", "Bearer admin-secret").decode(t, &globex)
+	if len(globex.Tenants) != 1 || globex.Tenants[0].Requests != 1 {
+		t.Errorf("globex costs %+v", globex)
+	}
+}
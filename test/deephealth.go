@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+// Deep checks list each bucket as well as heading it, so they are cached
+// longer than the regular report.
+var deepHealthCacheTTL = durationFromEnv("HEALTH_DEEP_CACHE_TTL", 30*time.Second)
+
+// BucketDiagnosis is what a deep health check learned about one bucket. A
+// null field couldn't be determined, because an earlier step failed.
+type BucketDiagnosis struct {
+	Bucket           string `json:"bucket"`
+	Reachable        *bool  `json:"reachable"`
+	CredentialsValid *bool  `json:"credentials_valid"`
+	BucketExists     *bool  `json:"bucket_exists"`
+	Listable         *bool  `json:"listable"`
+	LatencyMillis    int64  `json:"latency_ms"`
+	Error            string `json:"error,omitempty"`
+}
+
+func (d BucketDiagnosis) healthy() bool {
+	return d.Error == ""
+}
+
+// Error codes S3 answers with when it can't accept the request's signature
+// or credentials.
+var credentialErrorCodes = map[string]bool{
+	"InvalidAccessKeyId":    true,
+	"SignatureDoesNotMatch": true,
+	"ExpiredToken":          true,
+	"InvalidToken":          true,
+	"TokenRefreshRequired":  true,
+	"InvalidClientTokenId":  true,
+}
+
+// diagnoseBucket lists one key, whose error codes tell bad credentials from
+// a missing bucket, then heads the bucket if listing was refused, since a
+// role may be allowed to use a bucket without listing it.
+func diagnoseBucket(ctx context.Context, bucket string) BucketDiagnosis {
+	diagnosis := BucketDiagnosis{Bucket: bucket}
+	yes, no := aws.Bool(true), aws.Bool(false)
+	start := time.Now()
+	defer func() { diagnosis.LatencyMillis = time.Since(start).Milliseconds() }()
+
+	_, err := s3Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{Bucket: aws.String(bucket), MaxKeys: aws.Int32(1)})
+	if err == nil {
+		diagnosis.Reachable, diagnosis.CredentialsValid, diagnosis.BucketExists, diagnosis.Listable = yes, yes, yes, yes
+		return diagnosis
+	}
+	diagnosis.Error = err.Error()
+
+	var apiErr smithy.APIError
+	switch {
+	case errors.Is(err, errStorageNotReady):
+		// There is no client to reach S3 with, usually for want of credentials
+		diagnosis.CredentialsValid = no
+		return diagnosis
+	case !errors.As(err, &apiErr):
+		diagnosis.Reachable = no
+		return diagnosis
+	case credentialErrorCodes[apiErr.ErrorCode()]:
+		diagnosis.Reachable, diagnosis.CredentialsValid = yes, no
+		return diagnosis
+	case apiErr.ErrorCode() == "NoSuchBucket":
+		diagnosis.Reachable, diagnosis.CredentialsValid, diagnosis.BucketExists = yes, yes, no
+		return diagnosis
+	}
+	diagnosis.Reachable, diagnosis.CredentialsValid, diagnosis.Listable = yes, yes, no
+
+	if _, err := s3Client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)}); err == nil {
+		diagnosis.BucketExists = yes
+	} else if isNotFound(err) {
+		diagnosis.BucketExists = no
+	}
+	return diagnosis
+}
+
+var deepHealthState struct {
+	mu         sync.Mutex
+	report     []BucketDiagnosis
+	reportedAt time.Time
+}
+
+// deepStorageReport diagnoses every known bucket, or returns the cached
+// diagnoses if they are still fresh.
+func deepStorageReport(ctx context.Context) []BucketDiagnosis {
+	deepHealthState.mu.Lock()
+	defer deepHealthState.mu.Unlock()
+
+	if deepHealthState.report != nil && time.Since(deepHealthState.reportedAt) < deepHealthCacheTTL {
+		return deepHealthState.report
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	buckets := knownBuckets()
+	report := make([]BucketDiagnosis, len(buckets))
+	fanOut(ctx, len(buckets), func(ctx context.Context, i int) error {
+		report[i] = diagnoseBucket(ctx, buckets[i])
+		return nil
+	})
+
+	deepHealthState.report, deepHealthState.reportedAt = report, time.Now()
+	return report
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// streamEncoder is what encoding/json's NewEncoder returns, and what drop-in
+// replacements such as sonic return from theirs.
+type streamEncoder interface {
+	Encode(v interface{}) error
+}
+
+// newStreamEncoder builds the encoder bound to each pooled response buffer.
+// Swap it to change the JSON library for every response.
+var newStreamEncoder = func(w io.Writer) streamEncoder {
+	return json.NewEncoder(w)
+}
+
+// responseBuffer is a pooled body buffer with an encoder writing into it, so
+// a response allocates neither.
+type responseBuffer struct {
+	bytes.Buffer
+	enc streamEncoder
+}
+
+var responseBuffers = sync.Pool{
+	New: func() interface{} {
+		b := &responseBuffer{}
+		b.enc = newStreamEncoder(&b.Buffer)
+		return b
+	},
+}
+
+// Buffers that grew past this for one huge listing aren't kept, so a single
+// large response doesn't pin its memory in the pool.
+const maxPooledBuffer = 1 << 20
+
+// net/http sets Content-Length itself for bodies smaller than its chunking
+// buffer; larger ones need it set explicitly to avoid chunked encoding.
+const chunkingThreshold = 2048
+
+// Header values shared by every JSON response. Full slice expressions keep
+// cap == len, so an Add elsewhere copies instead of writing into them.
+var (
+	contentTypeJSON = []string{"application/json"}[:1:1]
+	corsOrigin      = []string{settings.CORS.AllowOrigin}[:1:1]
+	corsMethods     = []string{"GET, POST, PUT, DELETE, OPTIONS"}[:1:1]
+	corsHeaders     = []string{"Content-Type, Authorization, X-API-Key, X-Share-Password, X-Debug-Capture, X-Request-ID, If-Match, If-None-Match"}[:1:1]
+	corsExpose      = []string{"ETag, X-Request-ID, X-Request-Cost"}[:1:1]
+)
+
+// writeJSON encodes v into a pooled buffer before writing anything, so an
+// encoding failure becomes a clean 500 rather than a truncated body.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	buf := responseBuffers.Get().(*responseBuffer)
+	defer func() {
+		if buf.Cap() <= maxPooledBuffer {
+			buf.Reset()
+			responseBuffers.Put(buf)
+		}
+	}()
+
+	if err := buf.enc.Encode(v); err != nil {
+		slog.Error("Failed to encode response", "type", fmt.Sprintf("%T", v), "err", err)
+		buf.Reset()
+		buf.WriteString(`{"error":"Failed to encode response"}` + "\n")
+		status = http.StatusInternalServerError
+	}
+
+	if buf.Len() >= chunkingThreshold {
+		w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	}
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mutations can also be published to a message bus, so other services can
+// subscribe without polling. EVENT_PUBLISHER picks the bus and
+// EVENT_PUBLISH_TARGET where on it events go, e.g. an SNS topic ARN or an
+// EventBridge bus name. Each bus needs its own SDK client, so SNS is only
+// built in with -tags sns, EventBridge with -tags eventbridge, Kafka with
+// -tags kafka and NATS JetStream with -tags nats.
+var (
+	eventPublisherName = strings.TrimSpace(os.Getenv("EVENT_PUBLISHER"))
+	eventPublishTarget = os.Getenv("EVENT_PUBLISH_TARGET")
+	// EVENT_PUBLISH_TYPES limits publishing to some event types, and
+	// EVENT_PUBLISH_TARGETS sends some to other targets, as "<type>=<target>"
+	// pairs
+	eventPublishTypes   = splitList(os.Getenv("EVENT_PUBLISH_TYPES"))
+	eventPublishTargets = parseAssignments(os.Getenv("EVENT_PUBLISH_TARGETS"))
+
+	// A batch the bus refuses is sent again after EVENT_PUBLISH_RETRY_DELAY,
+	// doubling each time, until EVENT_PUBLISH_MAX_ATTEMPTS
+	eventPublishMaxAttempts = intFromEnv("EVENT_PUBLISH_MAX_ATTEMPTS", 5)
+	eventPublishRetryDelay  = durationFromEnv("EVENT_PUBLISH_RETRY_DELAY", 500*time.Millisecond)
+
+	publishedEvents = newCounterVec("events_published_total", "Events sent to the message bus, by publisher and result: published, failed or dropped.", "publisher", "result")
+)
+
+const (
+	eventPublishQueueSize = 1000
+	// The most events SNS and EventBridge take in one call
+	eventPublishBatchSize = 10
+)
+
+// publishedEventTypes are the event types that change something, and so
+// are published unless EVENT_PUBLISH_TYPES says otherwise.
+var publishedEventTypes = []string{
+	eventUploaded, eventDeleted, eventMoved, eventTrashed, eventRestored,
+	eventExpired, eventVersionRestored, eventLegalHold, eventFolderCreated,
+	eventACLChanged, eventShared, eventReplicated, eventReplicationFailed,
+}
+
+// BusEvent is the body of each published message. Type is the FileEvent
+// type, which buses also carry as an attribute subscribers can filter on.
+type BusEvent struct {
+	ID      string            `json:"id"`
+	Type    string            `json:"type"`
+	Tenant  string            `json:"tenant,omitempty"`
+	Bucket  string            `json:"bucket"`
+	Name    string            `json:"name"`
+	To      string            `json:"to,omitempty"`
+	Time    string            `json:"time"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// eventPublisher sends events to a target on its bus. It returns the events
+// the bus didn't accept, so only they are sent again.
+type eventPublisher interface {
+	publish(ctx context.Context, target string, events []BusEvent) (failed []BusEvent, err error)
+}
+
+// eventPublisherTypes are the EVENT_PUBLISHER values this build supports.
+var eventPublisherTypes = map[string]func(ctx context.Context) (eventPublisher, error){}
+
+func registerEventPublisher(name string, open func(ctx context.Context) (eventPublisher, error)) bool {
+	eventPublisherTypes[name] = open
+	return true
+}
+
+// eventBus queues events and publishes them in batches from one goroutine,
+// so they leave in the order they were recorded. The queue is in memory:
+// events still in it when an instance stops are lost.
+type eventBus struct {
+	name      string
+	publisher eventPublisher
+	targets   map[string]string
+	queue     chan BusEvent
+
+	mu        sync.Mutex
+	lastError string
+}
+
+// eventPublishing is nil unless EVENT_PUBLISHER is set.
+var eventPublishing *eventBus
+
+// startEventBus opens the publisher EVENT_PUBLISHER names and starts
+// publishing.
+func startEventBus(ctx context.Context) error {
+	if eventPublisherName == "" {
+		return nil
+	}
+	open, ok := eventPublisherTypes[eventPublisherName]
+	if !ok {
+		return fmt.Errorf("EVENT_PUBLISHER %q is not supported by this build", eventPublisherName)
+	}
+	targets, err := eventTargets()
+	if err != nil {
+		return err
+	}
+	publisher, err := open(ctx)
+	if err != nil {
+		return err
+	}
+	bus := &eventBus{
+		name:      eventPublisherName,
+		publisher: publisher,
+		targets:   targets,
+		queue:     make(chan BusEvent, eventPublishQueueSize),
+	}
+	go bus.run(ctx)
+	eventPublishing = bus
+	return nil
+}
+
+// eventTargets maps each published event type to where it is sent.
+func eventTargets() (map[string]string, error) {
+	types := eventPublishTypes
+	if len(types) == 0 {
+		types = publishedEventTypes
+	}
+	targets := map[string]string{}
+	for _, t := range types {
+		if !contains(publishedEventTypes, t) {
+			return nil, fmt.Errorf("EVENT_PUBLISH_TYPES: unknown event type %q", t)
+		}
+		targets[t] = eventPublishTarget
+	}
+	for t, target := range eventPublishTargets {
+		if _, ok := targets[t]; !ok {
+			return nil, fmt.Errorf("EVENT_PUBLISH_TARGETS: %q is not a published event type", t)
+		}
+		targets[t] = target
+	}
+	for t, target := range targets {
+		if target == "" {
+			return nil, fmt.Errorf("no EVENT_PUBLISH_TARGET for %s events", t)
+		}
+	}
+	return targets, nil
+}
+
+// publishEvent queues event for the bus if its type is published.
+func publishEvent(ns namespace, event FileEvent) {
+	bus := eventPublishing
+	if bus == nil || bus.targets[event.Type] == "" || isReservedKey(ns.name(event.Key)) {
+		return
+	}
+	e := BusEvent{
+		ID:     newJobID(),
+		Type:   event.Type,
+		Tenant: ns.Tenant,
+		Bucket: event.bucket,
+		Name:   ns.name(event.Key),
+		Time:   event.Time,
+	}
+	details := map[string]string{}
+	for k, v := range event.Details {
+		details[k] = v
+	}
+	if to, ok := details["to"]; ok {
+		e.To = ns.name(to)
+		delete(details, "to")
+	}
+	if len(details) > 0 {
+		e.Details = details
+	}
+
+	select {
+	case bus.queue <- e:
+	default:
+		// Recording an event never waits on the bus
+		publishedEvents.add(1, bus.name, "dropped")
+		slog.Warn("Event queue is full; dropping event", "publisher", bus.name, "type", e.Type, "name", e.Name)
+	}
+}
+
+func (b *eventBus) run(ctx context.Context) {
+	for {
+		var batch []BusEvent
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-b.queue:
+			batch = append(batch, e)
+		}
+		// Whatever else is already waiting goes in the same calls
+		for len(batch) < eventPublishQueueSize && len(b.queue) > 0 {
+			batch = append(batch, <-b.queue)
+		}
+		byTarget := map[string][]BusEvent{}
+		var order []string
+		for _, e := range batch {
+			target := b.targets[e.Type]
+			if byTarget[target] == nil {
+				order = append(order, target)
+			}
+			byTarget[target] = append(byTarget[target], e)
+		}
+		for _, target := range order {
+			pending := byTarget[target]
+			for len(pending) > 0 {
+				n := min(len(pending), eventPublishBatchSize)
+				b.send(ctx, target, pending[:n])
+				pending = pending[n:]
+			}
+		}
+	}
+}
+
+// send publishes a batch, trying the events the bus refused again until
+// they are accepted or out of attempts.
+func (b *eventBus) send(ctx context.Context, target string, batch []BusEvent) {
+	delay := eventPublishRetryDelay
+	for attempt := 1; ; attempt++ {
+		failed, err := b.publisher.publish(ctx, target, batch)
+		if err != nil && len(failed) == 0 {
+			// The call itself failed, so nothing was accepted
+			failed = batch
+		}
+		if len(failed) > 0 && err == nil {
+			err = errors.New("events refused")
+		}
+		b.mu.Lock()
+		b.lastError = ""
+		if err != nil {
+			b.lastError = err.Error()
+		}
+		b.mu.Unlock()
+		publishedEvents.add(float64(len(batch)-len(failed)), b.name, "published")
+		if len(failed) == 0 {
+			return
+		}
+
+		if attempt >= eventPublishMaxAttempts {
+			publishedEvents.add(float64(len(failed)), b.name, "failed")
+			slog.Error("Failed to publish events", "publisher", b.name, "target", target, "events", len(failed), "attempts", attempt, "err", err)
+			return
+		}
+		slog.Warn("Failed to publish events; retrying", "publisher", b.name, "target", target, "events", len(failed), "attempt", attempt, "err", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		batch = failed
+		delay *= 2
+	}
+}
+
+// checkEventBus fails while the bus is refusing events or the queue is full.
+func checkEventBus(ctx context.Context) error {
+	b := eventPublishing
+	if len(b.queue) == cap(b.queue) {
+		return errors.New("event queue is full")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.lastError != "" {
+		return fmt.Errorf("publishing to %s failing: %s", b.name, b.lastError)
+	}
+	return nil
+}
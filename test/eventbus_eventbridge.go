@@ -0,0 +1,77 @@
+//go:build eventbridge
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+)
+
+var _ = registerEventPublisher("eventbridge", newEventBridgePublisher)
+
+// eventBridgePublisher puts each event on the bus it is sent to, with the
+// event type as its detail type, so rules can match on either.
+type eventBridgePublisher struct {
+	client *eventbridge.Client
+	source string
+}
+
+func newEventBridgePublisher(ctx context.Context) (eventPublisher, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &eventBridgePublisher{
+		client: eventbridge.NewFromConfig(cfg),
+		source: envOr("EVENT_BRIDGE_SOURCE", "test-api"),
+	}, nil
+}
+
+func (p *eventBridgePublisher) publish(ctx context.Context, target string, events []BusEvent) ([]BusEvent, error) {
+	entries := make([]types.PutEventsRequestEntry, len(events))
+	for i, e := range events {
+		detail, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+		at, err := time.Parse(time.RFC3339Nano, e.Time)
+		if err != nil {
+			at = time.Now()
+		}
+		entries[i] = types.PutEventsRequestEntry{
+			EventBusName: aws.String(target),
+			Source:       aws.String(p.source),
+			DetailType:   aws.String(e.Type),
+			Detail:       aws.String(string(detail)),
+			Time:         aws.Time(at),
+		}
+	}
+	out, err := p.client.PutEvents(ctx, &eventbridge.PutEventsInput{Entries: entries})
+	if err != nil {
+		return nil, err
+	}
+	if out.FailedEntryCount == 0 {
+		return nil, nil
+	}
+	// Results are in the order of the entries, with an error code on those
+	// that weren't put
+	var failed []BusEvent
+	var reason string
+	for i, result := range out.Entries {
+		if result.ErrorCode == nil || i >= len(events) {
+			continue
+		}
+		failed = append(failed, events[i])
+		if reason == "" {
+			reason = aws.ToString(result.ErrorCode) + ": " + aws.ToString(result.ErrorMessage)
+		}
+	}
+	return failed, fmt.Errorf("%d events refused: %s", out.FailedEntryCount, reason)
+}
@@ -0,0 +1,101 @@
+//go:build kafka
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+	"github.com/twmb/franz-go/pkg/sasl/scram"
+)
+
+var _ = registerEventPublisher("kafka", newKafkaPublisher)
+
+// kafkaDeliveryTimeout bounds how long the client retries a record itself
+// before the bus gets it back to retry on its own schedule.
+const kafkaDeliveryTimeout = 30 * time.Second
+
+// kafkaPublisher produces each event as a record on the topic it is sent
+// to, keyed by file and with its type and tenant as headers.
+type kafkaPublisher struct {
+	client *kgo.Client
+}
+
+func newKafkaPublisher(ctx context.Context) (eventPublisher, error) {
+	if err := checkKafkaSettings(); err != nil {
+		return nil, err
+	}
+	tlsConfig, err := kafkaTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(kafkaBrokers...),
+		kgo.ClientID(kafkaClientID),
+		kgo.RecordDeliveryTimeout(kafkaDeliveryTimeout),
+	}
+	if tlsConfig != nil {
+		opts = append(opts, kgo.DialTLSConfig(tlsConfig))
+	}
+	switch kafkaSASLMechanism {
+	case "plain":
+		opts = append(opts, kgo.SASL(plain.Plain(func(context.Context) (plain.Auth, error) {
+			return plain.Auth{User: kafkaSASLUsername, Pass: currentSetting(&kafkaSASLPassword)}, nil
+		})))
+	case "scram-sha-256", "scram-sha-512":
+		auth := func(context.Context) (scram.Auth, error) {
+			return scram.Auth{User: kafkaSASLUsername, Pass: currentSetting(&kafkaSASLPassword)}, nil
+		}
+		if kafkaSASLMechanism == "scram-sha-256" {
+			opts = append(opts, kgo.SASL(scram.Sha256(auth)))
+		} else {
+			opts = append(opts, kgo.SASL(scram.Sha512(auth)))
+		}
+	}
+	// Connections are made as records are produced, so brokers being down
+	// shows in the health report rather than stopping startup
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &kafkaPublisher{client: client}, nil
+}
+
+func (p *kafkaPublisher) publish(ctx context.Context, target string, events []BusEvent) ([]BusEvent, error) {
+	records := make([]*kgo.Record, len(events))
+	index := make(map[*kgo.Record]int, len(events))
+	for i, e := range events {
+		value, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+		headers := []kgo.RecordHeader{
+			{Key: "event_id", Value: []byte(e.ID)},
+			{Key: "event_type", Value: []byte(e.Type)},
+		}
+		if e.Tenant != "" {
+			headers = append(headers, kgo.RecordHeader{Key: "tenant", Value: []byte(e.Tenant)})
+		}
+		records[i] = &kgo.Record{Topic: target, Key: []byte(kafkaRecordKey(e)), Value: value, Headers: headers}
+		index[records[i]] = i
+	}
+	var failed []BusEvent
+	var first error
+	for _, result := range p.client.ProduceSync(ctx, records...) {
+		if result.Err == nil {
+			continue
+		}
+		failed = append(failed, events[index[result.Record]])
+		if first == nil {
+			first = result.Err
+		}
+	}
+	if len(failed) == 0 {
+		return nil, nil
+	}
+	return failed, fmt.Errorf("%d events refused: %w", len(failed), first)
+}
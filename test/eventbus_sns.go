@@ -0,0 +1,78 @@
+//go:build sns
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+)
+
+var _ = registerEventPublisher("sns", newSNSPublisher)
+
+// snsPublisher publishes each event as a message on the topic ARN it is
+// sent to, with its type and tenant as message attributes for subscription
+// filter policies.
+type snsPublisher struct {
+	client *sns.Client
+}
+
+func newSNSPublisher(ctx context.Context) (eventPublisher, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &snsPublisher{client: sns.NewFromConfig(cfg)}, nil
+}
+
+func (p *snsPublisher) publish(ctx context.Context, target string, events []BusEvent) ([]BusEvent, error) {
+	fifo := strings.HasSuffix(target, ".fifo")
+	entries := make([]types.PublishBatchRequestEntry, len(events))
+	for i, e := range events {
+		message, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+		attributes := map[string]types.MessageAttributeValue{
+			"event_type": {DataType: aws.String("String"), StringValue: aws.String(e.Type)},
+		}
+		if e.Tenant != "" {
+			attributes["tenant"] = types.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(e.Tenant)}
+		}
+		entries[i] = types.PublishBatchRequestEntry{
+			// Only needs to be unique within the batch
+			Id:                aws.String(strconv.Itoa(i)),
+			Message:           aws.String(string(message)),
+			MessageAttributes: attributes,
+		}
+		if fifo {
+			// Events for one file stay in order; a retry isn't delivered twice
+			entries[i].MessageGroupId = aws.String(e.Bucket + "/" + e.Name)
+			entries[i].MessageDeduplicationId = aws.String(e.ID)
+		}
+	}
+	out, err := p.client.PublishBatch(ctx, &sns.PublishBatchInput{
+		TopicArn:                   aws.String(target),
+		PublishBatchRequestEntries: entries,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(out.Failed) == 0 {
+		return nil, nil
+	}
+	var failed []BusEvent
+	for _, f := range out.Failed {
+		i, _ := strconv.Atoi(aws.ToString(f.Id))
+		failed = append(failed, events[i])
+	}
+	first := out.Failed[0]
+	return failed, fmt.Errorf("%d events refused: %s: %s", len(failed), aws.ToString(first.Code), aws.ToString(first.Message))
+}
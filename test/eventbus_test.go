@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePublisher records what it is sent, refusing the first refuse events
+// it sees.
+type fakePublisher struct {
+	mu     sync.Mutex
+	refuse int
+	sent   map[string][]BusEvent
+	calls  int
+}
+
+func (p *fakePublisher) publish(ctx context.Context, target string, events []BusEvent) ([]BusEvent, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	var failed []BusEvent
+	for _, e := range events {
+		if p.refuse > 0 {
+			p.refuse--
+			failed = append(failed, e)
+			continue
+		}
+		p.sent[target] = append(p.sent[target], e)
+	}
+	if len(failed) > 0 {
+		return failed, errors.New("throttled")
+	}
+	return nil, nil
+}
+
+func (p *fakePublisher) waitFor(t *testing.T, target string, n int) []BusEvent {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		p.mu.Lock()
+		sent := append([]BusEvent(nil), p.sent[target]...)
+		p.mu.Unlock()
+		if len(sent) >= n || time.Now().After(deadline) {
+			if len(sent) != n {
+				t.Fatalf("%d events published to %s, want %d: %+v", len(sent), target, n, sent)
+			}
+			return sent
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// waitPublished waits for the bus to have counted n events with result.
+func waitPublished(t *testing.T, result string, n float64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		publishedEvents.mu.Lock()
+		got := publishedEvents.values[labelKey([]string{"fake", result})]
+		publishedEvents.mu.Unlock()
+		if got == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%v events %s, want %v", got, result, n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func startTestEventBus(t *testing.T, p *fakePublisher) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	p.sent = map[string][]BusEvent{}
+	override(t, &eventPublisherTypes, map[string]func(context.Context) (eventPublisher, error){
+		"fake": func(context.Context) (eventPublisher, error) { return p, nil },
+	})
+	override(t, &eventPublisherName, "fake")
+	override(t, &eventPublishing, nil)
+	override(t, &publishedEvents.values, map[string]float64{})
+	override(t, &eventPublishRetryDelay, time.Millisecond)
+	if err := startEventBus(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEventPublishing(t *testing.T) {
+	srv, _ := newTestServer(t)
+	override(t, &apiKeys, map[string]string{"acme-key": "acme"})
+	override(t, &eventPublishTarget, "files-topic")
+	override(t, &eventPublishTypes, []string{eventUploaded, eventDeleted, eventMoved})
+	override(t, &eventPublishTargets, map[string]string{eventDeleted: "deletions-topic"})
+	p := &fakePublisher{}
+	startTestEventBus(t, p)
+	acme := []string{"X-API-Key", "acme-key"}
+
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("reports/q1.txt", "abc"), acme...), http.StatusOK)
+	expectStatus(t, call(t, srv, "DELETE", "/api/files/reports/q1.txt", nil, acme...), http.StatusOK)
+	// Sharing isn't one of the types asked for
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("notes.txt", "abc"), acme...), http.StatusOK)
+	expectStatus(t, call(t, srv, "POST", "/api/files/notes.txt/share", nil, acme...), http.StatusCreated)
+
+	uploads := p.waitFor(t, "files-topic", 2)
+	if e := uploads[0]; e.Type != eventUploaded || e.Tenant != "acme" || e.Name != "reports/q1.txt" || e.Details["size"] != "3" || e.ID == "" {
+		t.Errorf("upload event %+v", e)
+	}
+	if e := p.waitFor(t, "deletions-topic", 1)[0]; e.Type != eventDeleted || e.Name != "reports/q1.txt" {
+		t.Errorf("delete event %+v", e)
+	}
+	waitPublished(t, "published", 3)
+}
+
+func TestEventPublishingRetries(t *testing.T) {
+	srv, _ := newTestServer(t)
+	override(t, &eventPublishTarget, "files-topic")
+	override(t, &eventPublishTypes, nil)
+	override(t, &eventPublishTargets, map[string]string{})
+	p := &fakePublisher{refuse: 1}
+	startTestEventBus(t, p)
+
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("a.txt", "abc")), http.StatusOK)
+	if e := p.waitFor(t, "files-topic", 1)[0]; e.Name != "a.txt" {
+		t.Errorf("retried event %+v", e)
+	}
+	waitPublished(t, "published", 1)
+	if p.calls != 2 {
+		t.Errorf("%d calls, want the refused event sent again", p.calls)
+	}
+	if err := checkEventBus(context.Background()); err != nil {
+		t.Errorf("health after recovering: %v", err)
+	}
+
+	override(t, &eventPublishMaxAttempts, 2)
+	p.mu.Lock()
+	p.refuse = 2
+	p.mu.Unlock()
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("b.txt", "abc")), http.StatusOK)
+	waitPublished(t, "failed", 1)
+	if err := checkEventBus(context.Background()); err == nil || !strings.Contains(err.Error(), "throttled") {
+		t.Errorf("health while failing: %v", err)
+	}
+}
+
+func TestEventPublishingSettings(t *testing.T) {
+	override(t, &eventPublishTarget, "")
+	override(t, &eventPublishTypes, []string{eventUploaded})
+	override(t, &eventPublishTargets, map[string]string{})
+	if _, err := eventTargets(); err == nil {
+		t.Error("accepted events with nowhere to go")
+	}
+	override(t, &eventPublishTargets, map[string]string{eventUploaded: "uploads-topic"})
+	if targets, err := eventTargets(); err != nil || targets[eventUploaded] != "uploads-topic" {
+		t.Errorf("targets %v, %v", targets, err)
+	}
+	override(t, &eventPublishTypes, []string{"share_downloaded"})
+	if _, err := eventTargets(); err == nil {
+		t.Error("accepted an event type that isn't a mutation")
+	}
+
+	override(t, &eventPublisherName, "kinesis")
+	if err := startEventBus(context.Background()); err == nil || !strings.Contains(err.Error(), "not supported by this build") {
+		t.Errorf("unknown publisher: %v", err)
+	}
+}
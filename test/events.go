@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	maxRecentEvents = 1000
+	// Events a subscriber may have waiting before it is dropped for falling
+	// behind
+	subscriberBuffer = 256
+)
+
+// Event types recorded for object mutations.
+const (
+	eventUploaded          = "uploaded"
+	eventDeleted           = "deleted"
+	eventMoved             = "moved"
+	eventTrashed           = "trashed"
+	eventRestored          = "restored"
+	eventExpired           = "expired"
+	eventVersionRestored   = "version_restored"
+	eventLegalHold         = "legal_hold"
+	eventFolderCreated     = "folder_created"
+	eventACLChanged        = "acl_changed"
+	eventShared            = "shared"
+	eventShareDownloaded   = "share_downloaded"
+	eventReplicated        = "replicated"
+	eventReplicationFailed = "replication_failed"
+)
+
+type FileEvent struct {
+	Type    string            `json:"type"`
+	Key     string            `json:"key"`
+	Time    string            `json:"time"`
+	Details map[string]string `json:"details,omitempty"`
+
+	// Keys are only unique within their bucket
+	bucket string
+}
+
+// The most recent events are kept in memory, oldest first, for debugging,
+// and passed on to subscribers as they are recorded.
+var (
+	eventsMu         sync.Mutex
+	recentEvents     []FileEvent
+	eventSubscribers = map[chan FileEvent]bool{}
+)
+
+// recordEvent records an event for key in the bucket of ctx's namespace.
+func recordEvent(ctx context.Context, eventType, key string, details map[string]string) {
+	event := FileEvent{
+		Type:    eventType,
+		Key:     key,
+		Time:    time.Now().UTC().Format(time.RFC3339Nano),
+		Details: details,
+		bucket:  bucketFor(ctx),
+	}
+	notifyWebhooks(namespaceFrom(ctx), event)
+	publishEvent(namespaceFrom(ctx), event)
+
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+
+	recentEvents = append(recentEvents, event)
+	if len(recentEvents) > maxRecentEvents {
+		recentEvents = recentEvents[len(recentEvents)-maxRecentEvents:]
+	}
+	for events := range eventSubscribers {
+		select {
+		case events <- event:
+		default:
+			// Recording never waits; a subscriber that missed an event has
+			// to start over
+			delete(eventSubscribers, events)
+			close(events)
+		}
+	}
+}
+
+// subscribeEvents returns a channel of the events recorded from now on. It
+// is closed if the subscriber falls behind or the server shuts down.
+func subscribeEvents() chan FileEvent {
+	events := make(chan FileEvent, subscriberBuffer)
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	eventSubscribers[events] = true
+	return events
+}
+
+func unsubscribeEvents(events chan FileEvent) {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	if eventSubscribers[events] {
+		delete(eventSubscribers, events)
+		close(events)
+	}
+}
+
+// closeEventSubscribers ends every subscription, as hijacked connections
+// aren't drained by the servers' shutdown.
+func closeEventSubscribers() {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	for events := range eventSubscribers {
+		delete(eventSubscribers, events)
+		close(events)
+	}
+}
+
+// eventsForKey returns the recent events recorded for key, newest first.
+func eventsForKey(key string) []FileEvent {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+
+	matches := []FileEvent{}
+	for i := len(recentEvents) - 1; i >= 0; i-- {
+		if recentEvents[i].Key == key {
+			matches = append(matches, recentEvents[i])
+		}
+	}
+	return matches
+}
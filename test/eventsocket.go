@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+const eventSocketWriteTimeout = 10 * time.Second
+
+// Idle sockets are pinged this often, so proxies don't close them
+var eventSocketPingInterval = durationFromEnv("EVENTS_PING_INTERVAL", 30*time.Second)
+
+// socketEvents are the events that change what a listing shows.
+var socketEvents = map[string]bool{
+	eventUploaded:        true,
+	eventDeleted:         true,
+	eventMoved:           true,
+	eventTrashed:         true,
+	eventRestored:        true,
+	eventExpired:         true,
+	eventVersionRestored: true,
+	eventFolderCreated:   true,
+}
+
+// FileEventMessage is sent to a socket for each change to a file, with names
+// relative to the client's namespace, as /api/files lists them.
+type FileEventMessage struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+	// Where a moved file went
+	To      string            `json:"to,omitempty"`
+	Time    string            `json:"time"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// socketMessage is event as ns sees it, if it is in ns under prefix.
+func socketMessage(ns namespace, prefix string, event FileEvent) (FileEventMessage, bool) {
+	if !socketEvents[event.Type] || event.bucket != ns.Bucket {
+		return FileEventMessage{}, false
+	}
+	under := func(key string) bool {
+		return strings.HasPrefix(key, ns.key(prefix))
+	}
+	msg := FileEventMessage{Type: event.Type, Name: ns.name(event.Key), Time: event.Time, Details: event.Details}
+	if event.Type == eventMoved {
+		to := event.Details["to"]
+		if !under(event.Key) && !under(to) {
+			return FileEventMessage{}, false
+		}
+		msg.To, msg.Details = ns.name(to), nil
+		return msg, true
+	}
+	return msg, under(event.Key)
+}
+
+// sameOrigin is whether a browser's socket comes from the API's own pages or
+// the allowed CORS origin. Browsers send cookies with sockets whatever the
+// origin, so any other page could read a signed-in user's events.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if u.Host == r.Host {
+		return true
+	}
+	allowed := currentSetting(&corsOrigin)[0]
+	return allowed != "*" && origin == allowed
+}
+
+// hijacker lets the websocket package take the connection through the
+// middleware's response writers, which only unwrap for a ResponseController.
+type hijacker struct {
+	http.ResponseWriter
+}
+
+func (h hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(h.ResponseWriter).Hijack()
+}
+
+// fileEventsSocketHandler pushes changes to files under ?prefix= to a
+// WebSocket as they happen on this instance. The socket is closed when the
+// client falls behind, so it knows to list the files again.
+func fileEventsSocketHandler(w http.ResponseWriter, r *http.Request) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "WebSocket upgrade required",
+			Details: "connect with a WebSocket client",
+		})
+		return
+	}
+	if !sameOrigin(r) {
+		respondJSON(w, http.StatusForbidden, ErrorResponse{
+			Error:   "Origin not allowed",
+			Details: "sockets are accepted from the API's own origin and CORS_ALLOW_ORIGIN",
+		})
+		return
+	}
+
+	ns := requestNamespace(r)
+	prefix := r.URL.Query().Get("prefix")
+	// Subscribed before the handshake, so nothing done once the client is
+	// connected is missed
+	events := subscribeEvents()
+	defer unsubscribeEvents(events)
+	server := websocket.Server{Handler: func(ws *websocket.Conn) {
+		streamFileEvents(ws, events, ns, prefix)
+	}}
+	server.ServeHTTP(hijacker{w}, r)
+}
+
+func streamFileEvents(ws *websocket.Conn, events chan FileEvent, ns namespace, prefix string) {
+	defer ws.Close()
+
+	// Clients only send pongs and close frames, which reading handles
+	gone := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, ws)
+		close(gone)
+	}()
+
+	ping := time.NewTicker(eventSocketPingInterval)
+	defer ping.Stop()
+	for {
+		var err error
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			msg, ok := socketMessage(ns, prefix, event)
+			if !ok {
+				continue
+			}
+			ws.SetWriteDeadline(time.Now().Add(eventSocketWriteTimeout))
+			err = websocket.JSON.Send(ws, msg)
+		case <-ping.C:
+			ws.SetWriteDeadline(time.Now().Add(eventSocketWriteTimeout))
+			ws.PayloadType = websocket.PingFrame
+			_, err = ws.Write(nil)
+			ws.PayloadType = websocket.TextFrame
+		case <-gone:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func dialEvents(t *testing.T, srv *httptest.Server, path, origin string, headers ...string) *websocket.Conn {
+	t.Helper()
+	config, err := websocket.NewConfig("ws"+strings.TrimPrefix(srv.URL, "http")+path, origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		config.Header.Set(headers[i], headers[i+1])
+	}
+	ws, err := websocket.DialConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+func receiveEvent(t *testing.T, ws *websocket.Conn) FileEventMessage {
+	t.Helper()
+	var msg FileEventMessage
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := websocket.JSON.Receive(ws, &msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestFileEventsSocket(t *testing.T) {
+	srv, _ := newTestServer(t)
+	override(t, &apiKeys, map[string]string{"acme-key": "acme", "globex-key": "globex"})
+	acme := []string{"X-API-Key", "acme-key"}
+	ws := dialEvents(t, srv, "/api/events/ws?prefix=reports/", srv.URL, acme...)
+
+	// Neither the other tenant's files nor those outside the prefix are sent
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("reports/q1.txt", "abc"), "X-API-Key", "globex-key"), http.StatusOK)
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("notes.txt", "abc"), acme...), http.StatusOK)
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("reports/q1.txt", "abc"), acme...), http.StatusOK)
+	if msg := receiveEvent(t, ws); msg.Type != eventUploaded || msg.Name != "reports/q1.txt" || msg.Details["size"] != "3" {
+		t.Errorf("upload event %+v", msg)
+	}
+
+	s, err := sftpLogin(context.Background(), "acme", "acme-key", "203.0.113.9:50022", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.rename("/reports/q1.txt", "/archive/q1.txt", false); err != nil {
+		t.Fatal(err)
+	}
+	if msg := receiveEvent(t, ws); msg.Type != eventMoved || msg.Name != "reports/q1.txt" || msg.To != "archive/q1.txt" {
+		t.Errorf("move event %+v", msg)
+	}
+
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("reports/q2.txt", "abc"), acme...), http.StatusOK)
+	expectStatus(t, call(t, srv, "DELETE", "/api/files/reports/q2.txt", nil, acme...), http.StatusOK)
+	receiveEvent(t, ws)
+	if msg := receiveEvent(t, ws); msg.Type != eventDeleted || msg.Name != "reports/q2.txt" {
+		t.Errorf("delete event %+v", msg)
+	}
+}
+
+func TestFileEventsSocketRefusals(t *testing.T) {
+	srv, _ := newTestServer(t)
+	override(t, &apiKeys, map[string]string{"acme-key": "acme"})
+
+	expectStatus(t, call(t, srv, "GET", "/api/events/ws", nil, "X-API-Key", "acme-key"), http.StatusBadRequest)
+	expectStatus(t, call(t, srv, "GET", "/api/events/ws", nil, "Upgrade", "websocket"), http.StatusUnauthorized)
+	// Another site's page could otherwise read a signed-in user's events
+	expectStatus(t, call(t, srv, "GET", "/api/events/ws", nil, "X-API-Key", "acme-key", "Upgrade", "websocket", "Origin", "https://evil.example"), http.StatusForbidden)
+	override(t, &corsOrigin, []string{"https://app.example.com"})
+	dialEvents(t, srv, "/api/events/ws", "https://app.example.com", "X-API-Key", "acme-key")
+}
+
+func TestFileEventsSlowSubscriber(t *testing.T) {
+	events := subscribeEvents()
+	defer unsubscribeEvents(events)
+	for range subscriberBuffer + 1 {
+		recordEvent(context.Background(), eventUploaded, "flood.txt", nil)
+	}
+	n := 0
+	for range events {
+		n++
+	}
+	if n != subscriberBuffer {
+		t.Errorf("%d events before the subscription ended, want %d", n, subscriberBuffer)
+	}
+}
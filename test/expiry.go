@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+)
+
+// The expires-at tag on an object is the source of truth for its TTL. Markers
+// under expiryPrefix, named by zero-padded unix time, index those tags so the
+// sweeper can find due objects with a single sorted listing. Markers sit at the
+// root of each bucket and name the full key, whichever tenant it belongs to.
+const (
+	tagExpiresAt = "expires-at"
+	expiryPrefix = ".expiry/"
+)
+
+var expirySweepInterval = durationFromEnv("EXPIRY_SWEEP_INTERVAL", 15*time.Minute)
+
+type FileMetadata struct {
+	Filename      string `json:"filename"`
+	Size          int64  `json:"size"`
+	ContentType   string `json:"content_type,omitempty"`
+	ETag          string `json:"etag,omitempty"`
+	LastModified  string `json:"last_modified,omitempty"`
+	VersionID     string `json:"version_id,omitempty"`
+	Hash          string `json:"hash,omitempty"`
+	HashAlgorithm string `json:"hash_algorithm,omitempty"`
+	Visibility    string `json:"visibility"`
+	// clean or unscanned; only reported while uploads are being scanned
+	ScanStatus string `json:"scan_status,omitempty"`
+	// approved for a file that was held for review; only reported while
+	// uploads are held for review
+	ReviewStatus string `json:"review_status,omitempty"`
+	ExpiresAt    string `json:"expires_at,omitempty"`
+	TTLSeconds   *int64 `json:"ttl_seconds,omitempty"`
+	// The tags the file was uploaded with
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// expiryTime resolves the expires_in / expires_at fields of an upload.
+func expiryTime(req UploadRequest, now time.Time) (time.Time, error) {
+	switch {
+	case req.ExpiresIn != "" && req.ExpiresAt != "":
+		return time.Time{}, fmt.Errorf("set either expires_in or expires_at, not both")
+	case req.ExpiresIn != "":
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 {
+			return time.Time{}, fmt.Errorf("expires_in must be a positive duration such as 24h")
+		}
+		return now.Add(d), nil
+	case req.ExpiresAt != "":
+		t, err := time.Parse(time.RFC3339, req.ExpiresAt)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("expires_at must be an RFC 3339 timestamp")
+		}
+		if !t.After(now) {
+			return time.Time{}, fmt.Errorf("expires_at must be in the future")
+		}
+		return t, nil
+	}
+	return time.Time{}, nil
+}
+
+func expiryMarkerKey(key string, expiresAt time.Time) string {
+	return fmt.Sprintf("%s%012d/%s", expiryPrefix, expiresAt.Unix(), key)
+}
+
+// applyExpiry tags the upload with its expiry time.
+func applyExpiry(input *s3.PutObjectInput, expiresAt time.Time) {
+	tag := tagExpiresAt + "=" + url.QueryEscape(expiresAt.UTC().Format(time.RFC3339))
+	if input.Tagging != nil && *input.Tagging != "" {
+		tag = *input.Tagging + "&" + tag
+	}
+	input.Tagging = aws.String(tag)
+}
+
+func writeExpiryMarker(ctx context.Context, key string, expiresAt time.Time) error {
+	_, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(bucketFor(ctx)),
+		Key:    aws.String(expiryMarkerKey(key, expiresAt)),
+		Body:   strings.NewReader(""),
+	})
+	return err
+}
+
+func objectTags(ctx context.Context, key string) (map[string]string, error) {
+	result, err := s3Client.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+		Bucket: aws.String(bucketFor(ctx)),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tags := map[string]string{}
+	for _, tag := range result.TagSet {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return tags, nil
+}
+
+func fileMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	filename := mux.Vars(r)["filename"]
+	ctx := r.Context()
+	key := requestNamespace(r).key(filename)
+	if !authorizeFile(w, r, key, permRead) {
+		return
+	}
+
+	head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucketFor(ctx)),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			if serveAlias(w, r, fileMetadataHandler) {
+				return
+			}
+			respondJSON(w, http.StatusNotFound, ErrorResponse{
+				Error: "File not found",
+			})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to read metadata",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	metadata := FileMetadata{
+		Filename:      filename,
+		Size:          aws.ToInt64(head.ContentLength),
+		ContentType:   aws.ToString(head.ContentType),
+		ETag:          aws.ToString(head.ETag),
+		LastModified:  formatTime(head.LastModified),
+		VersionID:     aws.ToString(head.VersionId),
+		Hash:          head.Metadata[metaContentHash],
+		HashAlgorithm: head.Metadata[metaHashAlgorithm],
+		Visibility:    fileVisibility(head.Metadata),
+	}
+	if antivirusEnabled() {
+		metadata.ScanStatus = scanStatus(head.Metadata)
+	}
+	if quarantineEnabled() {
+		metadata.ReviewStatus = head.Metadata[metaReviewStatus]
+	}
+	if size, err := strconv.ParseInt(head.Metadata[metaOriginalSize], 10, 64); err == nil {
+		metadata.Size = size
+	}
+
+	tags, err := objectTags(ctx, key)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to read tags",
+			Details: err.Error(),
+		})
+		return
+	}
+	if value := tags[tagExpiresAt]; value != "" {
+		if expiresAt, err := time.Parse(time.RFC3339, value); err == nil {
+			ttl := int64(time.Until(expiresAt).Seconds())
+			if ttl < 0 {
+				ttl = 0
+			}
+			metadata.ExpiresAt = value
+			metadata.TTLSeconds = &ttl
+		}
+	}
+	metadata.Tags = userTags(tags)
+
+	respondJSON(w, http.StatusOK, metadata)
+}
+
+// sweepExpired deletes objects in the bucket of ctx whose expiry has passed.
+// Markers are listed in time order, so the sweep stops at the first one that
+// isn't due yet.
+func sweepExpired(ctx context.Context) error {
+	now := time.Now()
+
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucketFor(ctx)),
+		Prefix: aws.String(expiryPrefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, obj := range page.Contents {
+			marker := aws.ToString(obj.Key)
+			stamp, key, ok := strings.Cut(strings.TrimPrefix(marker, expiryPrefix), "/")
+			if !ok {
+				continue
+			}
+			unix, err := strconv.ParseInt(stamp, 10, 64)
+			if err != nil {
+				continue
+			}
+			expiresAt := time.Unix(unix, 0)
+			if expiresAt.After(now) {
+				return nil
+			}
+
+			if err := expireObject(ctx, key, expiresAt); err != nil {
+				slog.ErrorContext(ctx, "Failed to expire object", "key", key, "err", err)
+				continue
+			}
+
+			if _, err := s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+				Bucket: aws.String(bucketFor(ctx)),
+				Key:    aws.String(marker),
+			}); err != nil {
+				slog.WarnContext(ctx, "Failed to remove expiry marker", "marker", marker, "err", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// expireObject deletes key if it still carries the expiry recorded in its
+// marker; an object re-uploaded since then keeps its new (or no) TTL.
+func expireObject(ctx context.Context, key string, expiresAt time.Time) error {
+	tags, err := objectTags(ctx, key)
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	current, err := time.Parse(time.RFC3339, tags[tagExpiresAt])
+	if err != nil || current.Unix() != expiresAt.Unix() {
+		return nil
+	}
+
+	_, err = s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucketFor(ctx)),
+		Key:    aws.String(key),
+	})
+	if err == nil {
+		slog.InfoContext(ctx, "Expired object", "key", key)
+		recordEvent(ctx, eventExpired, key, nil)
+		replicateDeletion(ctx, key)
+	}
+	return err
+}
+
+func startExpirySweeper(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(expirySweepInterval)
+		defer ticker.Stop()
+
+		for {
+			for _, bucket := range knownBuckets() {
+				if err := sweepExpired(withNamespace(ctx, namespace{Bucket: bucket})); err != nil {
+					slog.ErrorContext(ctx, "Expiry sweep failed", "bucket", bucket, "err", err)
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
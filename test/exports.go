@@ -0,0 +1,481 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/gorilla/mux"
+)
+
+const (
+	exportSourceList      = "list"
+	exportSourceInventory = "inventory"
+)
+
+var (
+	exportPrefix = envOr("EXPORT_PREFIX", "exports/")
+
+	// Where S3 Inventory delivers reports for this bucket, e.g.
+	// INVENTORY_PREFIX="inventory/my-bucket/daily/"
+	inventoryBucket = os.Getenv("INVENTORY_BUCKET")
+	inventoryPrefix = os.Getenv("INVENTORY_PREFIX")
+)
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+type ExportRequest struct {
+	Source string `json:"source"`
+}
+
+// inventoryManifest is the manifest.json S3 Inventory writes with each report.
+type inventoryManifest struct {
+	SourceBucket string `json:"sourceBucket"`
+	FileFormat   string `json:"fileFormat"`
+	FileSchema   string `json:"fileSchema"`
+	Files        []struct {
+		Key string `json:"key"`
+	} `json:"files"`
+}
+
+// exportRow is called once per object in the export, with the position in
+// the source to resume after it from.
+type exportRow func(key string, size int64, lastModified, position string) error
+
+func createExportHandler(w http.ResponseWriter, r *http.Request) {
+	req := ExportRequest{Source: exportSourceList}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondInvalidBody(w, err)
+			return
+		}
+	}
+
+	switch req.Source {
+	case exportSourceList:
+	case exportSourceInventory:
+		if inventoryPrefix == "" {
+			respondJSON(w, http.StatusBadRequest, ErrorResponse{
+				Error: "S3 Inventory exports are not configured",
+			})
+			return
+		}
+	default:
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid export source",
+			Details: "must be list or inventory",
+		})
+		return
+	}
+
+	// Exports outlive the request, so they only carry over its namespace
+	ctx := withNamespace(context.Background(), requestNamespace(r))
+	job, existing, err := startDurableJob(ctx, "export", exportParams{Source: req.Source}, r.Header.Get(idempotencyHeader))
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to start export",
+			Details: err.Error(),
+		})
+		return
+	}
+	status := http.StatusAccepted
+	if existing {
+		status = http.StatusOK
+	}
+	respondJSON(w, status, job.snapshot())
+}
+
+func getExportHandler(w http.ResponseWriter, r *http.Request) {
+	job, err := findJob(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to read export",
+			Details: err.Error(),
+		})
+		return
+	}
+	if job == nil || job.Type != "export" || job.Tenant != requestTenant(r) {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{
+			Error: "Export not found",
+		})
+		return
+	}
+	respondJSON(w, http.StatusOK, job.snapshot())
+}
+
+// Reports are uploaded in parts of at least this size, and an interrupted
+// export resumes after the last part it uploaded. S3 needs 5 MiB.
+var exportPartSize = 5 << 20
+
+var _ = registerDurableJob("export", runExport)
+
+type exportParams struct {
+	Source string `json:"source"`
+}
+
+// exportCheckpoint is how far an export got: the report parts uploaded,
+// and the position in the source that the last of them ends at.
+type exportCheckpoint struct {
+	UploadID string       `json:"upload_id"`
+	Parts    []exportPart `json:"parts,omitempty"`
+	Cursor   string       `json:"cursor,omitempty"`
+	Objects  int64        `json:"objects"`
+	Manifest string       `json:"manifest,omitempty"`
+}
+
+type exportPart struct {
+	Number int32  `json:"number"`
+	ETag   string `json:"etag"`
+}
+
+// runExport writes a key,size,last_modified CSV report of ctx's namespace
+// into that namespace, as a multipart upload it checkpoints after each
+// part.
+func runExport(ctx context.Context, job *Job) (map[string]string, error) {
+	var params exportParams
+	if err := job.decodeParams(&params); err != nil {
+		return nil, err
+	}
+	ns := namespaceFrom(ctx)
+	reportName := exportPrefix + job.ID + ".csv"
+	reportKey := ns.key(reportName)
+
+	var cp exportCheckpoint
+	resumed, err := job.resumeFrom(&cp)
+	if err != nil {
+		return nil, err
+	}
+	if !resumed {
+		if params.Source == exportSourceInventory {
+			if cp.Manifest, err = latestInventoryManifest(ctx, inventoryBucketFor(ctx)); err != nil {
+				return nil, err
+			}
+		}
+		created, err := s3Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+			Bucket:      aws.String(ns.Bucket),
+			Key:         aws.String(reportKey),
+			ContentType: aws.String("text/csv"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		cp.UploadID = aws.ToString(created.UploadId)
+		// The upload is the job's to resume, so shutting down leaves it open
+		openUploads.remove(cp.UploadID)
+		if err := job.saveCheckpoint(ctx, cp, true); err != nil {
+			return nil, err
+		}
+	}
+	job.setProgress(cp.Objects)
+
+	err = writeExport(ctx, job, params.Source, reportKey, &cp)
+	if err == nil {
+		err = completeExport(ctx, reportKey, cp)
+	}
+	if err != nil {
+		// Stopped rather than failed: whoever resumes the job needs the upload
+		if ctx.Err() == nil && !errors.Is(err, errJobLeaseLost) {
+			s3Client.AbortMultipartUpload(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(ns.Bucket),
+				Key:      aws.String(reportKey),
+				UploadId: aws.String(cp.UploadID),
+			})
+		}
+		return nil, err
+	}
+
+	replicateObject(ctx, reportKey)
+	return map[string]string{
+		"source":   params.Source,
+		"objects":  strconv.FormatInt(cp.Objects, 10),
+		"report":   reportName,
+		"download": "/api/files/" + reportName,
+	}, nil
+}
+
+// writeExport reads the source from the checkpoint's cursor on, uploading
+// a part whenever enough rows have built up.
+func writeExport(ctx context.Context, job *Job, source, reportKey string, cp *exportCheckpoint) error {
+	ns := namespaceFrom(ctx)
+	var part bytes.Buffer
+	report := csv.NewWriter(&part)
+	if len(cp.Parts) == 0 {
+		report.Write([]string{"key", "size", "last_modified"})
+	}
+
+	count, cursor := cp.Objects, cp.Cursor
+	upload := func(final bool) error {
+		report.Flush()
+		if err := report.Error(); err != nil {
+			return err
+		}
+		if part.Len() == 0 || !final && part.Len() < exportPartSize {
+			return nil
+		}
+		number := int32(len(cp.Parts) + 1)
+		out, err := s3Client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:     aws.String(ns.Bucket),
+			Key:        aws.String(reportKey),
+			UploadId:   aws.String(cp.UploadID),
+			PartNumber: aws.Int32(number),
+			Body:       bytes.NewReader(part.Bytes()),
+		})
+		if err != nil {
+			return err
+		}
+		part.Reset()
+		cp.Parts = append(cp.Parts, exportPart{Number: number, ETag: aws.ToString(out.ETag)})
+		cp.Cursor, cp.Objects = cursor, count
+		return job.saveCheckpoint(ctx, cp, true)
+	}
+
+	row := func(key string, size int64, lastModified, position string) error {
+		cursor = position
+		// Inventory reports cover the whole bucket, so other tenants' keys show up too
+		name, ok := strings.CutPrefix(key, ns.Prefix)
+		if !ok || isReservedKey(name) {
+			return nil
+		}
+		count++
+		if count%1000 == 0 {
+			job.setProgress(count)
+		}
+		if err := report.Write([]string{name, strconv.FormatInt(size, 10), lastModified}); err != nil {
+			return err
+		}
+		return upload(false)
+	}
+
+	var err error
+	if source == exportSourceInventory {
+		err = exportFromInventory(ctx, cp.Manifest, cp.Cursor, row)
+	} else {
+		err = exportFromListing(ctx, cp.Cursor, row)
+	}
+	if err != nil {
+		return err
+	}
+	job.setProgress(count)
+	return upload(true)
+}
+
+// completeExport assembles the report. A job resumed after completing it,
+// but before recording that, finds the upload gone and the report there.
+func completeExport(ctx context.Context, reportKey string, cp exportCheckpoint) error {
+	ns := namespaceFrom(ctx)
+	parts := make([]types.CompletedPart, len(cp.Parts))
+	for i, p := range cp.Parts {
+		parts[i] = types.CompletedPart{PartNumber: aws.Int32(p.Number), ETag: aws.String(p.ETag)}
+	}
+	_, err := s3Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(ns.Bucket),
+		Key:             aws.String(reportKey),
+		UploadId:        aws.String(cp.UploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchUpload" {
+		if _, headErr := s3Client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(ns.Bucket), Key: aws.String(reportKey)}); headErr == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// exportFromListing lists the namespace from after the key after, which
+// is each row's position.
+func exportFromListing(ctx context.Context, after string, row exportRow) error {
+	ns := namespaceFrom(ctx)
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(ns.Bucket),
+		Prefix: aws.String(ns.Prefix),
+	}
+	if after != "" {
+		input.StartAfter = aws.String(after)
+	}
+	paginator := s3.NewListObjectsV2Paginator(s3Client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+			if err := row(key, aws.ToInt64(obj.Size), formatTime(obj.LastModified), key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// latestInventoryManifest finds the newest delivery under the inventory
+// prefix. Deliveries are folders named by timestamp, so they sort by name.
+func latestInventoryManifest(ctx context.Context, bucket string) (string, error) {
+	prefix := strings.TrimSuffix(inventoryPrefix, "/") + "/"
+
+	var deliveries []string
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return "", err
+		}
+		for _, p := range page.CommonPrefixes {
+			name := strings.TrimPrefix(aws.ToString(p.Prefix), prefix)
+			// Skip the hive/ and data/ folders that sit next to the deliveries
+			if name != "" && name[0] >= '0' && name[0] <= '9' {
+				deliveries = append(deliveries, aws.ToString(p.Prefix))
+			}
+		}
+	}
+
+	if len(deliveries) == 0 {
+		return "", fmt.Errorf("no inventory deliveries found under s3://%s/%s", bucket, prefix)
+	}
+	sort.Strings(deliveries)
+	return deliveries[len(deliveries)-1] + "manifest.json", nil
+}
+
+func inventoryBucketFor(ctx context.Context) string {
+	if inventoryBucket != "" {
+		return inventoryBucket
+	}
+	return bucketFor(ctx)
+}
+
+// exportFromInventory reads the report files of an inventory delivery.
+// Positions are a file's index and a record's number within it, so a
+// resumed export skips what it already has.
+func exportFromInventory(ctx context.Context, manifestKey, after string, row exportRow) error {
+	bucket := inventoryBucketFor(ctx)
+	var skipFile, skipRecord int
+	if after != "" {
+		if _, err := fmt.Sscanf(after, "%d:%d", &skipFile, &skipRecord); err != nil {
+			return fmt.Errorf("invalid inventory position %q", after)
+		}
+	}
+
+	result, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(manifestKey),
+	})
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", manifestKey, err)
+	}
+	var manifest inventoryManifest
+	err = json.NewDecoder(result.Body).Decode(&manifest)
+	result.Body.Close()
+	if err != nil {
+		return fmt.Errorf("parsing %s: %w", manifestKey, err)
+	}
+
+	if manifest.FileFormat != "CSV" {
+		return fmt.Errorf("inventory format %s is not supported, configure the inventory as CSV", manifest.FileFormat)
+	}
+
+	columns := map[string]int{}
+	for i, name := range strings.Split(manifest.FileSchema, ",") {
+		columns[strings.TrimSpace(name)] = i
+	}
+	keyCol, ok := columns["Key"]
+	if !ok {
+		return fmt.Errorf("inventory schema has no Key column")
+	}
+	sizeCol, hasSize := columns["Size"]
+	modifiedCol, hasModified := columns["LastModifiedDate"]
+
+	for i, file := range manifest.Files {
+		if after != "" && i < skipFile {
+			continue
+		}
+		n := 0
+		err := readInventoryFile(ctx, bucket, file.Key, func(record []string) error {
+			if n++; after != "" && i == skipFile && n <= skipRecord {
+				return nil
+			}
+			if keyCol >= len(record) {
+				return fmt.Errorf("record has %d fields, expected a key in field %d", len(record), keyCol+1)
+			}
+			// Inventory reports URL-encode keys
+			key, err := url.QueryUnescape(record[keyCol])
+			if err != nil {
+				key = record[keyCol]
+			}
+
+			var size int64
+			if hasSize && sizeCol < len(record) {
+				size, _ = strconv.ParseInt(record[sizeCol], 10, 64)
+			}
+			var modified string
+			if hasModified && modifiedCol < len(record) {
+				if t, err := time.Parse(time.RFC3339, record[modifiedCol]); err == nil {
+					modified = t.UTC().Format(time.RFC3339)
+				}
+			}
+			return row(key, size, modified, fmt.Sprintf("%d:%d", i, n))
+		})
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", file.Key, err)
+		}
+	}
+
+	return nil
+}
+
+func readInventoryFile(ctx context.Context, bucket, key string, each func([]string) error) error {
+	result, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+	defer result.Body.Close()
+
+	gz, err := gzip.NewReader(result.Body)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	reader := csv.NewReader(gz)
+	reader.FieldsPerRecord = -1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := each(record); err != nil {
+			return err
+		}
+	}
+}
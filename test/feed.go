@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// A feed lists the most recently modified files under its prefix, newest
+// first.
+var feedMaxEntries = intFromEnv("FEED_MAX_ENTRIES", 50)
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel    string `xml:"rel,attr,omitempty"`
+	Href   string `xml:"href,attr"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID      string     `xml:"id"`
+	Title   string     `xml:"title"`
+	Updated string     `xml:"updated"`
+	Links   []atomLink `xml:"link"`
+	Summary string     `xml:"summary"`
+}
+
+// filesFeedHandler serves an Atom feed of the newest files under ?prefix=,
+// so people can follow e.g. releases/ in a feed reader. A file re-uploaded
+// under the same name is a new entry.
+func filesFeedHandler(w http.ResponseWriter, r *http.Request) {
+	ns := requestNamespace(r)
+	prefix := r.URL.Query().Get("prefix")
+
+	var objects []types.Object
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(ns.Bucket),
+		Prefix: aws.String(ns.key(prefix)),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(r.Context())
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+				Error:   "Failed to list files",
+				Details: err.Error(),
+			})
+			return
+		}
+		for _, obj := range page.Contents {
+			name := ns.name(aws.ToString(obj.Key))
+			if !isReservedKey(name) && !strings.HasSuffix(name, "/") {
+				objects = append(objects, obj)
+			}
+		}
+	}
+
+	names := make([]string, len(objects))
+	for i, obj := range objects {
+		names[i] = ns.name(aws.ToString(obj.Key))
+	}
+	readable, err := readableNames(r.Context(), requestSubject(r), names)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to check access",
+			Details: err.Error(),
+		})
+		return
+	}
+	allowed := map[string]bool{}
+	for _, name := range readable {
+		allowed[name] = true
+	}
+	visible := objects[:0]
+	for _, obj := range objects {
+		if allowed[ns.name(aws.ToString(obj.Key))] {
+			visible = append(visible, obj)
+		}
+	}
+	sort.SliceStable(visible, func(i, j int) bool {
+		return aws.ToTime(visible[i].LastModified).After(aws.ToTime(visible[j].LastModified))
+	})
+	if len(visible) > feedMaxEntries {
+		visible = visible[:feedMaxEntries]
+	}
+
+	base := requestBaseURL(r)
+	self := base + r.URL.RequestURI()
+	files := base + strings.TrimSuffix(r.URL.Path, "/feed") + "/"
+	title := "New files"
+	if prefix != "" {
+		title += " in " + prefix
+	}
+	feed := atomFeed{
+		ID:      self,
+		Title:   title,
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Author:  atomAuthor{Name: "test-api"},
+		Links:   []atomLink{{Rel: "self", Href: self, Type: "application/atom+xml"}},
+	}
+	for i, obj := range visible {
+		name := ns.name(aws.ToString(obj.Key))
+		modified := aws.ToTime(obj.LastModified).UTC().Format(time.RFC3339)
+		if i == 0 {
+			feed.Updated = modified
+		}
+		link := files + (&url.URL{Path: name}).EscapedPath()
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:      link + "#" + strings.Trim(aws.ToString(obj.ETag), `"`),
+			Title:   name,
+			Updated: modified,
+			Links:   []atomLink{{Rel: "enclosure", Href: link, Length: aws.ToInt64(obj.Size)}},
+			Summary: fmt.Sprintf("%d bytes", aws.ToInt64(obj.Size)),
+		})
+	}
+
+	body, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to render feed", Details: err.Error()})
+		return
+	}
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	setCacheControl(w, "private", time.Minute)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFilesFeed(t *testing.T) {
+	override(t, &feedMaxEntries, 2)
+	srv, fake := newTestServer(t)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	fake.Now = func() time.Time { return now }
+	for _, name := range []string{"releases/v1.0.tar.gz", "releases/v1.1 rc.tar.gz", "releases/v1.2.tar.gz", "notes.txt"} {
+		mustUpload(t, srv, "/api", name, "build of "+name)
+		now = now.Add(time.Hour)
+	}
+
+	resp := call(t, srv, "GET", "/api/files/feed?prefix=releases/", nil)
+	expectStatus(t, resp, http.StatusOK)
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/atom+xml") {
+		t.Fatalf("Content-Type %q", resp.Header.Get("Content-Type"))
+	}
+	var feed atomFeed
+	if err := xml.Unmarshal(resp.body, &feed); err != nil {
+		t.Fatal(err)
+	}
+	if feed.Title != "New files in releases/" || len(feed.Entries) != 2 || feed.Updated != "2026-03-01T14:00:00Z" {
+		t.Fatalf("feed %+v", feed)
+	}
+	newest, next := feed.Entries[0], feed.Entries[1]
+	if newest.Title != "releases/v1.2.tar.gz" || next.Title != "releases/v1.1 rc.tar.gz" {
+		t.Fatalf("entries %q, %q", newest.Title, next.Title)
+	}
+	if link := next.Links[0].Href; link != srv.URL+"/api/files/releases/v1.1%20rc.tar.gz" {
+		t.Fatalf("link %q", link)
+	}
+	expectStatus(t, call(t, srv, "GET", strings.TrimPrefix(next.Links[0].Href, srv.URL), nil), http.StatusOK)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// commandLine is the server's flags. Those for settings override the
+// environment and the configuration file.
+type commandLine struct {
+	flags       *flag.FlagSet
+	config      string
+	version     bool
+	checkConfig bool
+	worker      bool
+
+	// Flag values by the environment variable they stand in for
+	overrides map[string]string
+}
+
+// Like the configuration file, the command line is parsed when the package
+// loads, so the settings can be read from it. main reports a bad one; test
+// binaries have flags of their own, which it doesn't know.
+var cmdline, cmdlineErr = parseCommandLine(os.Args[1:])
+
+// Set at build time with -ldflags "-X main.buildVersion=1.4.0"
+var buildVersion string
+
+func parseCommandLine(args []string) (*commandLine, error) {
+	c := &commandLine{flags: flag.NewFlagSet("test-api", flag.ContinueOnError), overrides: map[string]string{}}
+	fs := c.flags
+	fs.SetOutput(io.Discard)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s [flags]\n\nFlags override the environment and the configuration file.\n\n", fs.Name())
+		fs.PrintDefaults()
+	}
+
+	fs.StringVar(&c.config, "config", "", "configuration `file`, .yaml, .yml, .toml or .json (CONFIG_FILE)")
+	for _, setting := range []struct{ name, env, usage string }{
+		{"port", "PORT", "`port` to listen on"},
+		{"bucket", "FILES_BUCKET_NAME", "files `bucket`"},
+		{"region", "AWS_REGION", "AWS `region` of the bucket"},
+		{"storage-driver", "STORAGE_DRIVER", "storage `driver`; only s3"},
+		{"log-level", "LOG_LEVEL", "log `level`: debug, info, warn or error"},
+	} {
+		env := setting.env
+		fs.Func(setting.name, setting.usage+" ("+env+")", func(value string) error {
+			c.overrides[env] = value
+			return nil
+		})
+	}
+	fs.BoolVar(&c.version, "version", false, "print the version and exit")
+	fs.BoolVar(&c.checkConfig, "check-config", false, "check the configuration, print it and exit")
+	fs.BoolVar(&c.worker, "worker", false, "consume the INGEST_QUEUE_URL queue instead of serving the API")
+
+	if err := fs.Parse(args); err != nil {
+		return c, err
+	}
+	if fs.NArg() > 0 {
+		return c, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	return c, nil
+}
+
+// configFile is the file given with -config, or else CONFIG_FILE.
+func (c *commandLine) configFile() string {
+	if c.config != "" {
+		return c.config
+	}
+	return os.Getenv("CONFIG_FILE")
+}
+
+// getenv is os.Getenv, except for the settings given as flags.
+func (c *commandLine) getenv(name string) string {
+	if value, ok := c.overrides[name]; ok {
+		return value
+	}
+	return os.Getenv(name)
+}
+
+// runCommand handles the command line's problems and the flags that don't
+// start the server, and reports whether to start it.
+func runCommand() bool {
+	switch {
+	case errors.Is(cmdlineErr, flag.ErrHelp):
+		cmdline.flags.SetOutput(os.Stdout)
+		cmdline.flags.Usage()
+		return false
+	case cmdlineErr != nil:
+		fmt.Fprintln(os.Stderr, cmdlineErr)
+		cmdline.flags.SetOutput(os.Stderr)
+		cmdline.flags.Usage()
+		os.Exit(2)
+	case cmdline.version:
+		fmt.Println(versionString())
+		return false
+	case cmdline.checkConfig:
+		if err := checkConfig(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return false
+	}
+	return true
+}
+
+func versionString() string {
+	version, revision := buildVersion, ""
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if version == "" && info.Main.Version != "(devel)" {
+			version = info.Main.Version
+		}
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision":
+				revision = s.Value
+			case s.Key == "vcs.modified" && s.Value == "true":
+				revision += "-dirty"
+			}
+		}
+	}
+	if version == "" {
+		version = "dev"
+	}
+	if revision != "" {
+		version += " (" + revision + ")"
+	}
+	return fmt.Sprintf("test-api %s, API %s, %s", version, apiVersion(), runtime.Version())
+}
+
+// checkConfig reports every problem with the configuration, or writes the
+// settings it comes to, with secrets left out.
+func checkConfig(w io.Writer) error {
+	problems := []error{configErr, checkLatencySLORoutes(apiRoutes())}
+	if _, err := serverTLSConfig(); err != nil {
+		problems = append(problems, fmt.Errorf("tls: %w", err))
+	}
+	if err := errors.Join(problems...); err != nil {
+		return fmt.Errorf("%s is invalid:\n%w", describeConfig(), err)
+	}
+
+	fmt.Fprintf(w, "%s is valid\n", describeConfig())
+	forEachSetting(reflect.ValueOf(&settings).Elem(), "", func(key, env string, field reflect.Value) {
+		value := fmt.Sprint(field.Interface())
+		if field.Kind() == reflect.Map {
+			value = describeAssignments(field.Interface().(map[string]string))
+		}
+		if secretSettings[key] && !field.IsZero() {
+			value = "(set)"
+		}
+		fmt.Fprintf(w, "%s = %s\n", key, value)
+	})
+	return nil
+}
+
+// secretSettings are shown only as set or not.
+var secretSettings = map[string]bool{"auth.api_keys": true, "auth.admin_token": true}
+
+func describeConfig() string {
+	if configPath == "" {
+		return "configuration"
+	}
+	return "configuration " + configPath
+}
+
+func describeAssignments(m map[string]string) string {
+	pairs := make([]string, 0, len(m))
+	for _, key := range sortedKeys(m) {
+		pairs = append(pairs, key+"="+m[key])
+	}
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCommandLine(t *testing.T) {
+	t.Setenv("PORT", "9000")
+	t.Setenv("LOG_LEVEL", "warn")
+	c, err := parseCommandLine([]string{"-port", "9100", "--bucket=flag-files", "-config", "prod.yaml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.configFile() != "prod.yaml" {
+		t.Errorf("config file %q", c.configFile())
+	}
+	got, err := loadConfig("", c.getenv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Server.Port != "9100" || got.Storage.Bucket != "flag-files" || got.Log.Level != "warn" {
+		t.Errorf("loaded %+v", got)
+	}
+
+	for _, args := range [][]string{{"-prot", "1"}, {"serve"}} {
+		if _, err := parseCommandLine(args); err == nil {
+			t.Errorf("%q parsed", args)
+		}
+	}
+}
+
+func TestCommandLineConfigFile(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "env.yaml")
+	for _, tc := range []struct {
+		args []string
+		want string
+		ok   bool
+	}{
+		{[]string{"-config", "a.yaml"}, "a.yaml", true},
+		{[]string{"--config=b.toml", "-port", "9000"}, "b.toml", true},
+		{[]string{"-port", "9000"}, "env.yaml", true},
+		// After --, -config is an argument rather than the flag
+		{[]string{"--", "-config", "c.yaml"}, "env.yaml", false},
+	} {
+		c, err := parseCommandLine(tc.args)
+		if (err == nil) != tc.ok {
+			t.Errorf("%q: parsing returned %v", tc.args, err)
+		}
+		if got := c.configFile(); got != tc.want {
+			t.Errorf("%q: config %q, want %q", tc.args, got, tc.want)
+		}
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	override(t, &configErr, nil)
+	override(t, &settings, defaultConfig(noEnv))
+	settings.Auth.AdminToken = "s3cret"
+	settings.Auth.APIKeys = map[string]string{"k3y": "acme"}
+
+	var out bytes.Buffer
+	if err := checkConfig(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"is valid\n", "server.port = 8080\n", "auth.admin_token = (set)\n", "auth.api_keys = (set)\n", "auth.jwt_issuer = \n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output\n%s\nmissing %q", out.String(), want)
+		}
+	}
+	if strings.Contains(out.String(), "s3cret") || strings.Contains(out.String(), "k3y") {
+		t.Errorf("secrets printed:\n%s", out.String())
+	}
+
+	_, configErr = loadConfig("", func(name string) string { return map[string]string{"PORT": "http"}[name] })
+	if err := checkConfig(&out); err == nil || !strings.Contains(err.Error(), "server.port (PORT)") {
+		t.Errorf("invalid configuration checked: %v", err)
+	}
+}
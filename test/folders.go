@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/gorilla/mux"
+)
+
+// S3 accepts at most 1000 keys per DeleteObjects call
+const deleteBatchSize = 1000
+
+type FolderRequest struct {
+	Path string `json:"path"`
+}
+
+type FolderDeleteResponse struct {
+	Prefix  string        `json:"prefix"`
+	DryRun  bool          `json:"dry_run"`
+	Count   int           `json:"count"`
+	Keys    []string      `json:"keys"`
+	Errors  []FolderError `json:"errors,omitempty"`
+	Message string        `json:"message"`
+}
+
+type FolderError struct {
+	Key   string `json:"key"`
+	Error string `json:"error"`
+}
+
+// folderPrefix normalizes a folder path to the "a/b/" form used for marker keys
+// and prefix listings.
+func folderPrefix(p string) string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return ""
+	}
+	return p + "/"
+}
+
+func createFolderHandler(w http.ResponseWriter, r *http.Request) {
+	var req FolderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondInvalidBody(w, err)
+		return
+	}
+
+	if folderPrefix(req.Path) == "" {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Missing folder path",
+		})
+		return
+	}
+	path, err := sanitizePrefix(req.Path)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid folder path",
+			Details: err.Error(),
+		})
+		return
+	}
+	prefix := folderPrefix(path)
+
+	ctx := r.Context()
+	key := requestNamespace(r).key(prefix)
+	_, err = s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(bucketFor(ctx)),
+		Key:    aws.String(key),
+		Body:   strings.NewReader(""),
+	})
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to create folder",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	replicateObject(ctx, key)
+	recordEvent(ctx, eventFolderCreated, key, nil)
+
+	respondJSON(w, http.StatusCreated, MessageResponse{
+		Message:  "Folder created successfully",
+		Filename: prefix,
+	})
+}
+
+// listPrefix returns every key under prefix, following continuation tokens.
+func listPrefix(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucketFor(ctx)),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			if obj.Key != nil {
+				keys = append(keys, *obj.Key)
+			}
+		}
+	}
+
+	return keys, nil
+}
+
+// deleteInBatches sends keys to DeleteObjects in batches of deleteBatchSize,
+// several at once, and hands each batch's result to handle.
+func deleteInBatches(ctx context.Context, keys []string, handle func(batch int, result *s3.DeleteObjectsOutput)) error {
+	batches := (len(keys) + deleteBatchSize - 1) / deleteBatchSize
+	return fanOut(ctx, batches, func(ctx context.Context, batch int) error {
+		start := batch * deleteBatchSize
+		end := start + deleteBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		objects := make([]types.ObjectIdentifier, 0, end-start)
+		for _, key := range keys[start:end] {
+			objects = append(objects, types.ObjectIdentifier{Key: aws.String(key)})
+		}
+
+		result, err := s3Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(bucketFor(ctx)),
+			Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return err
+		}
+		handle(batch, result)
+		return nil
+	})
+}
+
+// deleteKeys removes keys in DeleteObjects batches, returning per-key failures.
+func deleteKeys(ctx context.Context, keys []string) ([]FolderError, error) {
+	perBatch := make([][]FolderError, (len(keys)+deleteBatchSize-1)/deleteBatchSize)
+	err := deleteInBatches(ctx, keys, func(batch int, result *s3.DeleteObjectsOutput) {
+		for _, e := range result.Errors {
+			perBatch[batch] = append(perBatch[batch], FolderError{
+				Key:   aws.ToString(e.Key),
+				Error: aws.ToString(e.Message),
+			})
+		}
+		for _, d := range result.Deleted {
+			replicateDeletion(ctx, aws.ToString(d.Key))
+			recordEvent(ctx, eventDeleted, aws.ToString(d.Key), nil)
+		}
+	})
+
+	var failures []FolderError
+	for _, batch := range perBatch {
+		failures = append(failures, batch...)
+	}
+	return failures, err
+}
+
+// removeKeys removes each of keys as a DELETE of it would, so files go to
+// the trash when soft delete is on and their ACLs go with them when it
+// isn't. Folder markers are just deleted. A key that can't be removed is
+// reported by name and the rest are still removed.
+func removeKeys(ctx context.Context, ns namespace, keys []string, acls map[string]*fileACL) ([]FolderError, error) {
+	failed := make([]*FolderError, len(keys))
+	err := fanOut(ctx, len(keys), func(ctx context.Context, i int) error {
+		key := keys[i]
+		var err error
+		if strings.HasSuffix(key, "/") {
+			_, err = s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+				Bucket: aws.String(ns.Bucket),
+				Key:    aws.String(key),
+			})
+		} else {
+			err = removeFile(ctx, key, "", acls[ns.name(key)])
+		}
+		if err != nil {
+			failed[i] = &FolderError{Key: ns.name(key), Error: err.Error()}
+		}
+		return nil
+	})
+
+	var failures []FolderError
+	for _, f := range failed {
+		if f != nil {
+			failures = append(failures, *f)
+		}
+	}
+	return failures, err
+}
+
+func deleteFolderHandler(w http.ResponseWriter, r *http.Request) {
+	prefix := folderPrefix(mux.Vars(r)["prefix"])
+	if prefix == "" {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Missing folder prefix",
+		})
+		return
+	}
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+	ns := requestNamespace(r)
+
+	keys, err := listPrefix(r.Context(), ns.key(prefix))
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to list folder",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	if len(keys) == 0 {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{
+			Error: "Folder not found",
+		})
+		return
+	}
+
+	// Files the caller can't write are left in place and reported as failures
+	var denied []FolderError
+	var acls map[string]*fileACL
+	if aclsEnabled {
+		acls, err = loadACLs(r.Context(), ns.key(prefix))
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+				Error:   "Failed to check access",
+				Details: err.Error(),
+			})
+			return
+		}
+		subject := requestSubject(r)
+		allowed := keys[:0]
+		for _, key := range keys {
+			if acls[ns.name(key)].allows(subject, permWrite) {
+				allowed = append(allowed, key)
+			} else {
+				denied = append(denied, FolderError{Key: ns.name(key), Error: errAccessDenied.Error()})
+			}
+		}
+		keys = allowed
+	}
+
+	response := FolderDeleteResponse{
+		Prefix: prefix,
+		DryRun: dryRun,
+		Count:  len(keys),
+	}
+	for _, key := range keys {
+		response.Keys = append(response.Keys, ns.name(key))
+	}
+
+	if dryRun {
+		response.Message = "Dry run: no objects were deleted"
+		response.Errors = denied
+		respondJSON(w, http.StatusOK, response)
+		return
+	}
+
+	failures, err := removeKeys(r.Context(), ns, keys, acls)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Delete failed",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	response.Count = len(keys) - len(failures)
+	response.Errors = append(failures, denied...)
+	response.Message = "Folder deleted successfully"
+	if len(response.Errors) > 0 {
+		response.Message = "Folder partially deleted"
+	}
+
+	respondJSON(w, http.StatusOK, response)
+}
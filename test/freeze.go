@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+)
+
+const (
+	freezePrefix = ".freezes/"
+	freezesKey   = freezePrefix + "freezes.json"
+)
+
+// Other instances pick up freezes within this long
+var freezeReloadInterval = durationFromEnv("FREEZE_RELOAD_INTERVAL", 10*time.Second)
+
+// frozenActions are the operations that change what is stored under a name,
+// and so are refused under a freeze. Metadata such as ACLs, legal holds and
+// shares can still change.
+var frozenActions = map[string]bool{
+	"upload": true, "restoreVersion": true, "deleteFile": true, "createFolder": true, "deleteFolder": true,
+	"restoreTrash": true, "putAlias": true, "deleteAlias": true, "setLatest": true, "webdav": true,
+	"s3PutObject": true, "s3DeleteObject": true,
+}
+
+// Freeze rejects writes under a prefix, for a change-freeze window, until
+// it is lifted or its Until passes. The empty prefix freezes everything.
+type Freeze struct {
+	ID        string `json:"id"`
+	Prefix    string `json:"prefix"`
+	Reason    string `json:"reason"`
+	Tenant    string `json:"tenant,omitempty"`
+	Until     string `json:"until,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+// FreezeRequest starts a freeze. Until and Duration both end it on their
+// own; without either it lasts until lifted.
+type FreezeRequest struct {
+	Prefix   string `json:"prefix"`
+	Reason   string `json:"reason"`
+	Tenant   string `json:"tenant,omitempty"`
+	Until    string `json:"until,omitempty"`
+	Duration string `json:"duration,omitempty"`
+}
+
+type FreezesResponse struct {
+	Freezes []Freeze `json:"freezes"`
+}
+
+// active reports whether f is still in force at now.
+func (f Freeze) active(now time.Time) bool {
+	if f.Until == "" {
+		return true
+	}
+	until, err := time.Parse(time.RFC3339, f.Until)
+	return err != nil || now.Before(until)
+}
+
+// covers reports whether f applies to writing name, or to the whole of
+// prefix when name is empty.
+func (f Freeze) covers(tenant, name, prefix string) bool {
+	if f.Tenant != "" && f.Tenant != tenant {
+		return false
+	}
+	if name != "" {
+		return strings.HasPrefix(name, f.Prefix)
+	}
+	return strings.HasPrefix(prefix, f.Prefix) || strings.HasPrefix(f.Prefix, prefix)
+}
+
+// freezeStore keeps the freezes in one object in the files bucket, cached
+// like the policy rules.
+type freezeStore struct {
+	mu        sync.Mutex
+	freezes   []Freeze
+	etag      string
+	checkedAt time.Time
+}
+
+var freezes = &freezeStore{}
+
+// current returns the freezes in force, reloading them if they may be stale.
+func (s *freezeStore) current(ctx context.Context) ([]Freeze, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.checkedAt.IsZero() || time.Since(s.checkedAt) >= freezeReloadInterval {
+		head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(freezesKey),
+		})
+		switch {
+		case isNotFound(err):
+			s.freezes, s.etag = nil, ""
+		case err != nil:
+			return nil, err
+		case aws.ToString(head.ETag) != s.etag:
+			stored, etag, err := readFreezes(ctx)
+			if err != nil {
+				return nil, err
+			}
+			s.freezes, s.etag = stored, etag
+		}
+		s.checkedAt = time.Now()
+	}
+	return activeFreezes(s.freezes), nil
+}
+
+// activeFreezes drops the freezes whose time is up, which is all it takes
+// to lift them on schedule.
+func activeFreezes(all []Freeze) []Freeze {
+	now := time.Now()
+	active := []Freeze{}
+	for _, f := range all {
+		if f.active(now) {
+			active = append(active, f)
+		}
+	}
+	return active
+}
+
+func readFreezes(ctx context.Context) ([]Freeze, string, error) {
+	result, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(freezesKey),
+	})
+	if isNotFound(err) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	defer result.Body.Close()
+
+	var stored []Freeze
+	if err := json.NewDecoder(result.Body).Decode(&stored); err != nil {
+		return nil, "", fmt.Errorf("decoding %s: %w", freezesKey, err)
+	}
+	return stored, aws.ToString(result.ETag), nil
+}
+
+// update applies change to the freezes in force, conditionally on what it
+// read as the policy store does. Lapsed freezes are dropped as it goes.
+func (s *freezeStore) update(ctx context.Context, change func([]Freeze) ([]Freeze, error)) error {
+	for attempt := 0; attempt < policyUpdateAttempts; attempt++ {
+		stored, etag, err := readFreezes(ctx)
+		if err != nil {
+			return err
+		}
+		updated, err := change(activeFreezes(stored))
+		if err != nil {
+			return err
+		}
+
+		body, err := json.MarshalIndent(updated, "", "  ")
+		if err != nil {
+			return err
+		}
+		input := &s3.PutObjectInput{
+			Bucket:      aws.String(bucketName),
+			Key:         aws.String(freezesKey),
+			Body:        bytes.NewReader(body),
+			ContentType: aws.String("application/json"),
+		}
+		if etag == "" {
+			input.IfNoneMatch = aws.String("*")
+		} else {
+			input.IfMatch = aws.String(etag)
+		}
+		result, err := s3Client.PutObject(ctx, input)
+		if isPreconditionFailed(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		s.freezes, s.etag, s.checkedAt = updated, aws.ToString(result.ETag), time.Now()
+		s.mu.Unlock()
+		return nil
+	}
+	return fmt.Errorf("freezes kept changing; gave up after %d attempts", policyUpdateAttempts)
+}
+
+// frozenTargets are the names, or else prefixes, that r writes to.
+func frozenTargets(r *http.Request, action string) (names, prefixes []string) {
+	vars := mux.Vars(r)
+	switch action {
+	case "putAlias", "deleteAlias":
+		return []string{vars["alias"]}, nil
+	case "setLatest":
+		return []string{strings.TrimSuffix(vars["prefix"], "/") + "/" + latestName}, nil
+	case "webdav":
+		// A move or copy writes its destination, and a move removes its source
+		paths := []string{vars["path"]}
+		if destination, err := url.Parse(r.Header.Get("Destination")); err == nil {
+			if path, ok := strings.CutPrefix(destination.Path, webdavPrefix+"/"); ok {
+				paths = append(paths, path)
+			}
+		}
+		for _, path := range paths {
+			// A collection's path doesn't end with / on every request
+			if name := strings.Trim(path, "/"); name != "" {
+				names, prefixes = append(names, name), append(prefixes, name+"/")
+			}
+		}
+		return names, prefixes
+	}
+	name, prefix := requestResource(r, action)
+	if name != "" {
+		return []string{name}, nil
+	}
+	return nil, []string{prefix}
+}
+
+// rejectFrozen answers 423 Locked to writes under a frozen prefix.
+func rejectFrozen(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		if route == nil || !frozenActions[route.GetName()] || r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS" || r.Method == "PROPFIND" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		names, prefixes := frozenTargets(r, route.GetName())
+		f, frozen, err := frozenBy(r.Context(), requestNamespace(r).Tenant, names, prefixes)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+				Error:   "Failed to check freezes",
+				Details: err.Error(),
+			})
+			return
+		}
+		if frozen {
+			respondFrozen(w, f)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// frozenBy returns the freeze in force, if any, that covers writing one of
+// names or prefixes in tenant's namespace.
+func frozenBy(ctx context.Context, tenant string, names, prefixes []string) (Freeze, bool, error) {
+	active, err := freezes.current(ctx)
+	if err != nil {
+		return Freeze{}, false, err
+	}
+	for _, f := range active {
+		for _, name := range names {
+			if f.covers(tenant, name, "") {
+				return f, true, nil
+			}
+		}
+		for _, prefix := range prefixes {
+			if f.covers(tenant, "", prefix) {
+				return f, true, nil
+			}
+		}
+	}
+	return Freeze{}, false, nil
+}
+
+func respondFrozen(w http.ResponseWriter, f Freeze) {
+	details := f.Reason
+	if f.Until != "" {
+		details += "; frozen until " + f.Until
+		if until, err := time.Parse(time.RFC3339, f.Until); err == nil {
+			w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(until).Seconds())+1))
+		}
+	}
+	respondJSON(w, http.StatusLocked, ErrorResponse{
+		Error:   fmt.Sprintf("Prefix %q is frozen", f.Prefix),
+		Details: details,
+	})
+}
+
+var errFreezeNotFound = errors.New("freeze not found")
+
+func listFreezesHandler(w http.ResponseWriter, r *http.Request) {
+	stored, _, err := readFreezes(r.Context())
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to read freezes",
+			Details: err.Error(),
+		})
+		return
+	}
+	respondJSON(w, http.StatusOK, FreezesResponse{Freezes: activeFreezes(stored)})
+}
+
+func createFreezeHandler(w http.ResponseWriter, r *http.Request) {
+	var req FreezeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondInvalidBody(w, err)
+		return
+	}
+	freeze, err := newFreeze(req)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid freeze",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	if err := freezes.update(r.Context(), func(active []Freeze) ([]Freeze, error) {
+		return append(active, freeze), nil
+	}); err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to update freezes",
+			Details: err.Error(),
+		})
+		return
+	}
+	respondJSON(w, http.StatusCreated, freeze)
+}
+
+// newFreeze checks req and turns it into a freeze starting now.
+func newFreeze(req FreezeRequest) (Freeze, error) {
+	now := time.Now().UTC()
+	freeze := Freeze{
+		ID:        newJobID(),
+		Reason:    strings.TrimSpace(req.Reason),
+		Tenant:    req.Tenant,
+		CreatedAt: now.Format(time.RFC3339),
+	}
+	if freeze.Reason == "" {
+		return freeze, errors.New("reason is required; it is shown to everyone whose writes are refused")
+	}
+	if req.Prefix != "" {
+		prefix, err := sanitizePrefix(req.Prefix)
+		if err != nil {
+			return freeze, err
+		}
+		freeze.Prefix = prefix
+	}
+
+	var until time.Time
+	switch {
+	case req.Until != "" && req.Duration != "":
+		return freeze, errors.New("give until or duration, not both")
+	case req.Until != "":
+		t, err := time.Parse(time.RFC3339, req.Until)
+		if err != nil {
+			return freeze, fmt.Errorf("until must be an RFC 3339 time: %w", err)
+		}
+		until = t
+	case req.Duration != "":
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil {
+			return freeze, fmt.Errorf("duration: %w", err)
+		}
+		until = now.Add(d)
+	}
+	if !until.IsZero() {
+		if !until.After(now) {
+			return freeze, errors.New("the freeze would already be over")
+		}
+		freeze.Until = until.UTC().Format(time.RFC3339)
+	}
+	return freeze, nil
+}
+
+// deleteFreezeHandler lifts a freeze before its time.
+func deleteFreezeHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	err := freezes.update(r.Context(), func(active []Freeze) ([]Freeze, error) {
+		for i := range active {
+			if active[i].ID == id {
+				return append(active[:i], active[i+1:]...), nil
+			}
+		}
+		return nil, errFreezeNotFound
+	})
+	switch {
+	case errors.Is(err, errFreezeNotFound):
+		respondJSON(w, http.StatusNotFound, ErrorResponse{
+			Error: "Freeze not found",
+		})
+	case err != nil:
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to update freezes",
+			Details: err.Error(),
+		})
+	default:
+		respondJSON(w, http.StatusOK, MessageResponse{Message: "Freeze lifted"})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestContentFreeze(t *testing.T) {
+	srv, fake := newTestServer(t)
+	override(t, &adminToken, "admin-secret")
+	admin := []string{"Authorization", "Bearer admin-secret"}
+	mustUpload(t, srv, "/api", "releases/app-1.0.tar.gz", "build")
+
+	var freeze Freeze
+	created := call(t, srv, "POST", "/api/admin/freezes", FreezeRequest{Prefix: "releases/", Reason: "Release 4.2 deploy window", Duration: "2h"}, admin...)
+	expectStatus(t, created, http.StatusCreated)
+	created.decode(t, &freeze)
+	if freeze.ID == "" || freeze.Until == "" {
+		t.Fatalf("created %+v", freeze)
+	}
+	if _, _, ok := fake.Object(bucketName, freezesKey); !ok {
+		t.Fatal("the freeze was not stored")
+	}
+
+	got := call(t, srv, "POST", "/api/upload", upload("releases/app-1.1.tar.gz", "newer"))
+	expectStatus(t, got, http.StatusLocked)
+	var e ErrorResponse
+	got.decode(t, &e)
+	if e.Details == "" || got.Header.Get("Retry-After") == "" {
+		t.Errorf("locked response %+v, Retry-After %q", e, got.Header.Get("Retry-After"))
+	}
+	expectStatus(t, call(t, srv, "DELETE", "/api/files/releases/app-1.0.tar.gz", nil), http.StatusLocked)
+	expectStatus(t, call(t, srv, "DELETE", "/api/folders/releases/", nil), http.StatusLocked)
+	expectStatus(t, call(t, srv, "PUT", "/api/latest/releases", nil), http.StatusLocked)
+
+	// Reads and writes elsewhere go on
+	expectStatus(t, call(t, srv, "GET", "/api/files/releases/app-1.0.tar.gz", nil), http.StatusOK)
+	mustUpload(t, srv, "/api", "docs/notes.txt", "notes")
+
+	var list FreezesResponse
+	call(t, srv, "GET", "/api/admin/freezes", nil, admin...).decode(t, &list)
+	if len(list.Freezes) != 1 || list.Freezes[0].ID != freeze.ID {
+		t.Fatalf("freezes %+v", list.Freezes)
+	}
+
+	expectStatus(t, call(t, srv, "DELETE", "/api/admin/freezes/"+freeze.ID, nil, admin...), http.StatusOK)
+	expectStatus(t, call(t, srv, "DELETE", "/api/admin/freezes/"+freeze.ID, nil, admin...), http.StatusNotFound)
+	mustUpload(t, srv, "/api", "releases/app-1.1.tar.gz", "newer")
+}
+
+func TestContentFreezeLiftsOnSchedule(t *testing.T) {
+	srv, _ := newTestServer(t)
+	override(t, &adminToken, "admin-secret")
+	admin := []string{"Authorization", "Bearer admin-secret"}
+
+	expectStatus(t, call(t, srv, "POST", "/api/admin/freezes", FreezeRequest{Reason: "Everything", Duration: "1h"}, admin...), http.StatusCreated)
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("a.txt", "a")), http.StatusLocked)
+
+	// Move the freeze's end into the past, as if the hour had gone by
+	if err := freezes.update(context.Background(), func(active []Freeze) ([]Freeze, error) {
+		active[0].Until = time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+		return active, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	mustUpload(t, srv, "/api", "a.txt", "a")
+	var list FreezesResponse
+	call(t, srv, "GET", "/api/admin/freezes", nil, admin...).decode(t, &list)
+	if len(list.Freezes) != 0 {
+		t.Errorf("lapsed freezes are listed: %+v", list.Freezes)
+	}
+
+	for _, req := range []FreezeRequest{
+		{Prefix: "a/"},
+		{Reason: "r", Duration: "-1h"},
+		{Reason: "r", Until: "tomorrow"},
+		{Reason: "r", Until: time.Now().Add(time.Hour).Format(time.RFC3339), Duration: "1h"},
+	} {
+		if got := call(t, srv, "POST", "/api/admin/freezes", req, admin...); got.StatusCode != http.StatusBadRequest {
+			t.Errorf("%+v: status %d, want 400", req, got.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func FuzzDecodeUploadRequest(f *testing.F) {
+	f.Add(`{"filename":"a.txt","content":"aGVsbG8="}`)
+	f.Add(`{"filename":"a.txt","content":"aGVsbG8","on_conflict":"number"}`)
+	f.Add(`{"filename":"","content":""}`)
+	f.Add(`{"filename":"\u0000/../x","content":"===="}`)
+	f.Add(`{"filename":1}`)
+	f.Add(`[`)
+
+	f.Fuzz(func(t *testing.T, body string) {
+		req, content, problem := decodeUploadRequest(strings.NewReader(body))
+		if problem != nil {
+			if problem.Error == "" {
+				t.Fatal("rejected without a reason")
+			}
+			return
+		}
+		if req.Filename == "" {
+			t.Fatal("accepted an empty filename")
+		}
+		if got := base64.StdEncoding.EncodeToString(content); got != req.Content {
+			t.Fatalf("content %q round-trips to %q", req.Content, got)
+		}
+	})
+}
+
+func FuzzNamespaceKeys(f *testing.F) {
+	override(f, &apiKeys, map[string]string{"k": "acme"})
+
+	f.Add("acme", "report.pdf")
+	f.Add("acme", "nested/dir/file")
+	f.Add("a-b_c", "")
+	f.Add("../acme", "x")
+	f.Add("acme", "tenants/other/file")
+
+	f.Fuzz(func(t *testing.T, tenant, name string) {
+		ns := sharedNamespace(tenant, bucketName)
+		key := ns.key(name)
+		if got := ns.name(key); got != name {
+			t.Fatalf("name %q round-trips to %q", name, got)
+		}
+
+		// A key only resolves to a tenant whose prefix it actually carries
+		owner := namespaceOfKey(key)
+		if owner.Tenant != defaultTenant && !strings.HasPrefix(key, owner.Prefix) {
+			t.Fatalf("key %q attributed to tenant %q", key, owner.Tenant)
+		}
+		if owner.Tenant == tenant && !validTenantID.MatchString(tenant) {
+			t.Fatalf("invalid tenant id %q resolved from key %q", tenant, key)
+		}
+	})
+}
+
+func FuzzVerifyJWT(f *testing.F) {
+	signer, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		f.Fatal(err)
+	}
+	ks := &keySet{
+		keys:        map[string]crypto.PublicKey{"k1": &signer.PublicKey},
+		fetchedAt:   time.Now(),
+		lastAttempt: time.Now(),
+	}
+
+	sign := func(method jwt.SigningMethod, kid string, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(signer)
+		if err != nil {
+			f.Fatal(err)
+		}
+		return signed
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+	f.Add(sign(jwt.SigningMethodES256, "k1", jwt.MapClaims{"sub": "acme", "exp": exp}))
+	f.Add(sign(jwt.SigningMethodES256, "k1", jwt.MapClaims{"sub": "acme"}))
+	f.Add(sign(jwt.SigningMethodES256, "k2", jwt.MapClaims{"sub": "acme", "exp": exp}))
+	f.Add(sign(jwt.SigningMethodES256, "k1", jwt.MapClaims{"sub": []string{"x"}, "exp": "soon"}))
+	f.Add("eyJhbGciOiJub25lIn0.eyJzdWIiOiJhY21lIn0.")
+	f.Add("..")
+	f.Add("not-a-token")
+
+	f.Fuzz(func(t *testing.T, token string) {
+		claims, err := verifyJWT(context.Background(), ks, token, "", "")
+		if err != nil {
+			return
+		}
+		if _, err := claims.GetExpirationTime(); err != nil {
+			t.Fatalf("accepted a token with a malformed exp: %v", err)
+		}
+		if p, err := principalFromClaims(claims, "jwt", "sub"); err == nil && !validTenantID.MatchString(p.Tenant) {
+			t.Fatalf("accepted tenant %q", p.Tenant)
+		}
+	})
+}
+
+func FuzzJWKPublicKey(f *testing.F) {
+	f.Add([]byte(`{"kty":"EC","crv":"P-256","x":"f83OJ3D2xF1Bg8vub9tLe1gHMzV76e8Tus9uPHvRVEU","y":"x_FEzRu9m36HLN_tue659LNpXW6pCyStikYjKIWI5a0"}`))
+	f.Add([]byte(`{"kty":"RSA","n":"sXchDaQebHnPiGvyDOAT4saGEUetSyo9MKLOoWFsueri23bOdgWp4Dy1WlUzewbgBHod5pcM9H95GQRV3JDXboIRROSBigeC5yjU1hGzHHyXss8UDprecbAYxknTcQkhslANGRUZmdTOQ5qTRsLAt6BTYuyvVRdhS8exSZEy_c4gs_7svlJJQ4H9_NxsiIoLwAEk7-Q3UXERGYw_75IDrGA84-lA_-Ct4eTlXHBIY2EaV7t7LjJaynVJCpkv4LKjTTAumiGUIuQhrNhZLuF_RJLqHpM2kgWFLU7-VTdL1VbC2tejvcI2BlMkEpk1BzBZI0KQB0GaDWFLN-aEAw3vRw","e":"AQAB"}`))
+	f.Add([]byte(`{"kty":"RSA","n":"","e":"AAAAAAAAAAAA"}`))
+	f.Add([]byte(`{"kty":"EC","crv":"P-521","x":"AA","y":"AA"}`))
+	f.Add([]byte(`{"kty":"oct"}`))
+
+	f.Fuzz(func(t *testing.T, doc []byte) {
+		var k jwk
+		if json.Unmarshal(doc, &k) != nil {
+			return
+		}
+		key, err := k.publicKey()
+		if err == nil && key == nil {
+			t.Fatal("no key and no error")
+		}
+	})
+}
+
+func FuzzSplitExt(f *testing.F) {
+	f.Add("dir/report.pdf")
+	f.Add("archive.tar.gz")
+	f.Add(".hidden")
+	f.Add("dir.d/noext")
+	f.Add("trailing/")
+
+	f.Fuzz(func(t *testing.T, key string) {
+		base, ext := splitExt(key)
+		if base+ext != key {
+			t.Fatalf("%q split into %q + %q", key, base, ext)
+		}
+		if strings.Contains(ext, "/") {
+			t.Fatalf("extension %q crosses a directory", ext)
+		}
+		if utf8.ValidString(key) && !utf8.ValidString(ext) {
+			t.Fatalf("extension %q splits a rune", ext)
+		}
+	})
+}
+
+func FuzzShareToken(f *testing.F) {
+	f.Add(randomToken())
+	f.Add(randomToken()[:42] + "B")
+	f.Add("")
+	f.Add("../../.acl/x")
+	f.Add(strings.Repeat("A", 43) + "=")
+
+	f.Fuzz(func(t *testing.T, token string) {
+		if !validShareToken(token) {
+			return
+		}
+		// Only the canonical encoding of a token is accepted, so each record
+		// has exactly one token that opens it
+		decoded, _ := base64.RawURLEncoding.DecodeString(token)
+		if got := base64.RawURLEncoding.EncodeToString(decoded); got != token {
+			t.Fatalf("accepted %q, canonically %q", token, got)
+		}
+		key := shareRecordKey(token)
+		if rest, ok := strings.CutPrefix(key, sharePrefix); !ok || strings.ContainsAny(rest, "/.") {
+			t.Fatalf("record key %q", key)
+		}
+	})
+}
+
+func FuzzSanitizeName(f *testing.F) {
+	f.Add("reports/q3.txt")
+	f.Add("../etc/passwd")
+	f.Add("a/./b")
+	f.Add("/abs")
+	f.Add(`dir\file`)
+	f.Add(".trash/x")
+	f.Add("exe‮txt.")
+	f.Add("café")
+
+	f.Fuzz(func(t *testing.T, name string) {
+		clean, err := sanitizeName(name)
+		if err != nil {
+			return
+		}
+		if again, err := sanitizeName(clean); err != nil || again != clean {
+			t.Fatalf("%q sanitizes to %q, then to %q (%v)", name, clean, again, err)
+		}
+		if len(clean) > keyRules.MaxLength || strings.HasPrefix(clean, "/") || strings.HasSuffix(clean, "/") {
+			t.Fatalf("accepted %q", clean)
+		}
+		for _, segment := range strings.Split(clean, "/") {
+			if segment == "" || segment == "." || segment == ".." {
+				t.Fatalf("accepted %q with segment %q", clean, segment)
+			}
+		}
+		if isReservedKey(clean) || strings.ContainsFunc(clean, unicode.IsControl) {
+			t.Fatalf("accepted %q", clean)
+		}
+	})
+}
+
+func FuzzParseByteRange(f *testing.F) {
+	f.Add("bytes=0-99", int64(100))
+	f.Add("bytes=-5", int64(3))
+	f.Add("bytes=50-", int64(10))
+	f.Add("bytes=5-2", int64(10))
+	f.Add("bytes=0-0,5-9", int64(10))
+	f.Add("items=0-1", int64(10))
+
+	f.Fuzz(func(t *testing.T, header string, size int64) {
+		if size < 0 {
+			return
+		}
+		start, end, ok, err := parseByteRange(header, size)
+		if !ok || err != nil {
+			return
+		}
+		if start < 0 || start > end || end >= size {
+			t.Fatalf("%q of %d bytes resolved to %d-%d", header, size, start, end)
+		}
+	})
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"test-api/internal/graphql"
+)
+
+var (
+	// Queries nesting fields deeper than this are refused, so folders of
+	// folders can't fan out a query without bound
+	graphqlMaxDepth = intFromEnv("GRAPHQL_MAX_DEPTH", 8)
+
+	graphqlDefaultPage = 50
+	graphqlMaxPage     = 1000
+)
+
+// GraphQLRequest is a query, as GraphQL clients post it.
+type GraphQLRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+}
+
+// graphqlFile is a file as GraphQL resolves it. Listings give the stored
+// size and date; the rest is fetched once, only if a query asks for it.
+type graphqlFile struct {
+	ns      namespace
+	name    string
+	object  types.Object
+	head    *s3.HeadObjectOutput
+	headErr error
+	tags    map[string]string
+	tagsErr error
+	fetched struct{ head, tags bool }
+}
+
+func (f *graphqlFile) metadata(ctx context.Context) (*s3.HeadObjectOutput, error) {
+	if !f.fetched.head {
+		f.fetched.head = true
+		f.head, f.headErr = s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(f.ns.Bucket),
+			Key:    aws.String(f.ns.key(f.name)),
+		})
+	}
+	return f.head, f.headErr
+}
+
+func (f *graphqlFile) userTags(ctx context.Context) (map[string]string, error) {
+	if !f.fetched.tags {
+		f.fetched.tags = true
+		tags, err := objectTags(ctx, f.ns.key(f.name))
+		f.tags, f.tagsErr = userTags(tags), err
+	}
+	return f.tags, f.tagsErr
+}
+
+// size is the file as uploaded, which compression and encryption make
+// differ from the stored size.
+func (f *graphqlFile) size(ctx context.Context) (int64, error) {
+	head, err := f.metadata(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if original, err := strconv.ParseInt(head.Metadata[metaOriginalSize], 10, 64); err == nil {
+		return original, nil
+	}
+	return aws.ToInt64(head.ContentLength), nil
+}
+
+type graphqlFolder struct {
+	ns   namespace
+	path string
+}
+
+// graphqlFiles is a page of files, with the cursor to continue after.
+type graphqlFiles struct {
+	nodes     []*graphqlFile
+	endCursor string
+	more      bool
+}
+
+// fileFilter is the files arguments that narrow a listing.
+type fileFilter struct {
+	prefix         string
+	recursive      bool
+	tag            string
+	minSize        int64
+	maxSize        int64
+	modifiedAfter  time.Time
+	modifiedBefore time.Time
+	first          int
+	after          string
+}
+
+func parseFileFilter(p graphql.Params, prefix string) (fileFilter, error) {
+	f := fileFilter{
+		prefix:    prefix + p.String("prefix"),
+		recursive: p.Bool("recursive"),
+		tag:       p.String("tag"),
+		minSize:   p.Int("minSize"),
+		maxSize:   p.Int("maxSize"),
+		first:     int(p.Int("first")),
+	}
+	if f.first < 1 || f.first > graphqlMaxPage {
+		return f, fmt.Errorf("first must be from 1 to %d", graphqlMaxPage)
+	}
+	for name, target := range map[string]*time.Time{"modifiedAfter": &f.modifiedAfter, "modifiedBefore": &f.modifiedBefore} {
+		if raw := p.String(name); raw != "" {
+			t, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				return f, fmt.Errorf("%s must be an RFC 3339 time", name)
+			}
+			*target = t
+		}
+	}
+	if after := p.String("after"); after != "" {
+		name, err := base64.RawURLEncoding.DecodeString(after)
+		if err != nil {
+			return f, fmt.Errorf("after is not a cursor this API returned")
+		}
+		f.after = string(name)
+	}
+	return f, nil
+}
+
+func (f fileFilter) matches(ctx context.Context, file *graphqlFile) (bool, error) {
+	modified := aws.ToTime(file.object.LastModified)
+	if !f.modifiedAfter.IsZero() && !modified.After(f.modifiedAfter) ||
+		!f.modifiedBefore.IsZero() && !modified.Before(f.modifiedBefore) {
+		return false, nil
+	}
+	if f.minSize > 0 || f.maxSize > 0 {
+		size, err := file.size(ctx)
+		if err != nil {
+			return false, err
+		}
+		if size < f.minSize || f.maxSize > 0 && size > f.maxSize {
+			return false, nil
+		}
+	}
+	if f.tag != "" {
+		tags, err := file.userTags(ctx)
+		if err != nil {
+			return false, err
+		}
+		key, value, hasValue := strings.Cut(f.tag, "=")
+		if got, ok := tags[key]; !ok || hasValue && got != value {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// listGraphQLFiles pages through the files the caller can read, in name
+// order. Filters apply after listing, so a page may take several listings
+// to fill.
+func listGraphQLFiles(ctx context.Context, subject string, f fileFilter) (graphqlFiles, error) {
+	ns := namespaceFrom(ctx)
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(ns.Bucket),
+		Prefix: aws.String(ns.key(f.prefix)),
+	}
+	if !f.recursive {
+		input.Delimiter = aws.String("/")
+	}
+	if f.after != "" {
+		input.StartAfter = aws.String(ns.key(f.after))
+	}
+
+	var page graphqlFiles
+	paginator := s3.NewListObjectsV2Paginator(s3Client, input)
+	for paginator.HasMorePages() {
+		listing, err := paginator.NextPage(ctx)
+		if err != nil {
+			return page, err
+		}
+		var names []string
+		objects := map[string]types.Object{}
+		for _, obj := range listing.Contents {
+			name := ns.name(aws.ToString(obj.Key))
+			if isReservedKey(name) || strings.HasSuffix(name, "/") {
+				continue
+			}
+			names = append(names, name)
+			objects[name] = obj
+		}
+		if names, err = readableNames(ctx, subject, names); err != nil {
+			return page, err
+		}
+		for _, name := range names {
+			file := &graphqlFile{ns: ns, name: name, object: objects[name]}
+			ok, err := f.matches(ctx, file)
+			if err != nil {
+				return page, err
+			}
+			if !ok {
+				continue
+			}
+			if len(page.nodes) == f.first {
+				page.more = true
+				return page, nil
+			}
+			page.nodes = append(page.nodes, file)
+			page.endCursor = base64.RawURLEncoding.EncodeToString([]byte(name))
+		}
+	}
+	return page, nil
+}
+
+// listGraphQLFolders lists the folders directly under prefix.
+func listGraphQLFolders(ctx context.Context, prefix string) ([]graphqlFolder, error) {
+	ns := namespaceFrom(ctx)
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	var folders []graphqlFolder
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(ns.Bucket),
+		Prefix:    aws.String(ns.key(prefix)),
+		Delimiter: aws.String("/"),
+	})
+	for paginator.HasMorePages() {
+		listing, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, common := range listing.CommonPrefixes {
+			path := ns.name(aws.ToString(common.Prefix))
+			if !isReservedKey(path) {
+				folders = append(folders, graphqlFolder{ns: ns, path: path})
+			}
+		}
+	}
+	return folders, nil
+}
+
+// fileArgs are the arguments files takes wherever it appears.
+func fileArgs(recursive bool) []graphql.Arg {
+	return []graphql.Arg{
+		{Name: "prefix", Type: "String", Description: "Only names starting with this"},
+		{Name: "recursive", Type: "Boolean", Default: recursive, Description: "Include files in subfolders"},
+		{Name: "tag", Type: "String", Description: "Only files with this tag key, or key=value"},
+		{Name: "minSize", Type: "Long"},
+		{Name: "maxSize", Type: "Long"},
+		{Name: "modifiedAfter", Type: "String", Description: "RFC 3339 time"},
+		{Name: "modifiedBefore", Type: "String", Description: "RFC 3339 time"},
+		{Name: "first", Type: "Int", Default: graphqlDefaultPage},
+		{Name: "after", Type: "String", Description: "endCursor of the previous page"},
+	}
+}
+
+// fileResolver reads a field that needs the file's metadata.
+func fileResolver(get func(*graphqlFile, *s3.HeadObjectOutput) any) func(graphql.Params) (any, error) {
+	return func(p graphql.Params) (any, error) {
+		file := p.Source.(*graphqlFile)
+		head, err := file.metadata(p.Context)
+		if err != nil {
+			return nil, err
+		}
+		return get(file, head), nil
+	}
+}
+
+// nullIfZero resolves a zero value, which means absent here, to null.
+func nullIfZero[T comparable](v T) any {
+	var zero T
+	if v == zero {
+		return nil
+	}
+	return v
+}
+
+func source[T any](get func(T) any) func(graphql.Params) (any, error) {
+	return func(p graphql.Params) (any, error) {
+		return get(p.Source.(T)), nil
+	}
+}
+
+var graphqlSchema = newGraphQLSchema()
+
+func newGraphQLSchema() *graphql.Schema {
+	listFiles := func(p graphql.Params, prefix string) (any, error) {
+		filter, err := parseFileFilter(p, prefix)
+		if err != nil {
+			return nil, err
+		}
+		return listGraphQLFiles(p.Context, p.Context.Value(graphqlSubjectKey{}).(string), filter)
+	}
+
+	file := &graphql.Object{Name: "File", Fields: map[string]*graphql.Field{
+		"name": {Type: "String!", Resolve: source(func(f *graphqlFile) any { return f.name })},
+		"size": {Type: "Long!", Description: "Bytes as uploaded", Resolve: func(p graphql.Params) (any, error) {
+			return p.Source.(*graphqlFile).size(p.Context)
+		}},
+		"storedSize": {Type: "Long!", Description: "Bytes as stored, after compression", Resolve: source(func(f *graphqlFile) any {
+			return aws.ToInt64(f.object.Size)
+		})},
+		"lastModified": {Type: "String!", Resolve: source(func(f *graphqlFile) any { return formatTime(f.object.LastModified) })},
+		"etag":         {Type: "String!", Resolve: source(func(f *graphqlFile) any { return aws.ToString(f.object.ETag) })},
+		"contentType": {Type: "String", Resolve: fileResolver(func(_ *graphqlFile, head *s3.HeadObjectOutput) any {
+			return aws.ToString(head.ContentType)
+		})},
+		"visibility": {Type: "String!", Resolve: fileResolver(func(_ *graphqlFile, head *s3.HeadObjectOutput) any {
+			return fileVisibility(head.Metadata)
+		})},
+		"versionId": {Type: "String", Resolve: fileResolver(func(_ *graphqlFile, head *s3.HeadObjectOutput) any {
+			return aws.ToString(head.VersionId)
+		})},
+		"hash": {Type: "String", Description: "Content digest recorded at upload, as algorithm:digest", Resolve: fileResolver(func(_ *graphqlFile, head *s3.HeadObjectOutput) any {
+			if head.Metadata[metaContentHash] == "" {
+				return nil
+			}
+			return head.Metadata[metaHashAlgorithm] + ":" + head.Metadata[metaContentHash]
+		})},
+		"tags": {Type: "[Tag!]!", Resolve: func(p graphql.Params) (any, error) {
+			tags, err := p.Source.(*graphqlFile).userTags(p.Context)
+			var list []map[string]any
+			for _, key := range sortedKeys(tags) {
+				list = append(list, map[string]any{"key": key, "value": tags[key]})
+			}
+			return list, err
+		}},
+		"tag": {Type: "String", Args: []graphql.Arg{{Name: "key", Type: "String!"}}, Resolve: func(p graphql.Params) (any, error) {
+			tags, err := p.Source.(*graphqlFile).userTags(p.Context)
+			if value, ok := tags[p.String("key")]; ok {
+				return value, err
+			}
+			return nil, err
+		}},
+	}}
+
+	tag := &graphql.Object{Name: "Tag", Fields: map[string]*graphql.Field{
+		"key":   {Type: "String!"},
+		"value": {Type: "String!"},
+	}}
+
+	connection := &graphql.Object{Name: "FileConnection", Description: "A page of files in name order", Fields: map[string]*graphql.Field{
+		"nodes": {Type: "[File!]!", Resolve: source(func(f graphqlFiles) any { return f.nodes })},
+		"endCursor": {Type: "String", Description: "Pass as after for the next page", Resolve: source(func(f graphqlFiles) any {
+			return nullIfZero(f.endCursor)
+		})},
+		"hasNextPage": {Type: "Boolean!", Resolve: source(func(f graphqlFiles) any { return f.more })},
+	}}
+
+	folder := &graphql.Object{Name: "Folder", Fields: map[string]*graphql.Field{
+		"path": {Type: "String!", Description: "Full path, ending in /", Resolve: source(func(f graphqlFolder) any { return f.path })},
+		"name": {Type: "String!", Resolve: source(func(f graphqlFolder) any {
+			trimmed := strings.TrimSuffix(f.path, "/")
+			return trimmed[strings.LastIndex(trimmed, "/")+1:]
+		})},
+		"files": {Type: "FileConnection!", Description: "Files in the folder, relative prefixes included", Args: fileArgs(false), Resolve: func(p graphql.Params) (any, error) {
+			return listFiles(p, p.Source.(graphqlFolder).path)
+		}},
+		"folders": {Type: "[Folder!]!", Resolve: func(p graphql.Params) (any, error) {
+			return listGraphQLFolders(p.Context, p.Source.(graphqlFolder).path)
+		}},
+	}}
+
+	usageType := &graphql.Object{Name: "Usage", Description: "Storage used against the quota", Fields: map[string]*graphql.Field{
+		"tenant":     {Type: "String!", Resolve: source(func(u UsageResponse) any { return u.Tenant })},
+		"usedBytes":  {Type: "Long!", Resolve: source(func(u UsageResponse) any { return u.UsedBytes })},
+		"objects":    {Type: "Long!", Resolve: source(func(u UsageResponse) any { return u.Objects })},
+		"quotaBytes": {Type: "Long", Description: "Null without a quota", Resolve: source(func(u UsageResponse) any { return nullIfZero(u.QuotaBytes) })},
+		"remainingBytes": {Type: "Long", Resolve: source(func(u UsageResponse) any {
+			if u.RemainingBytes == nil {
+				return nil
+			}
+			return *u.RemainingBytes
+		})},
+		"percentUsed": {Type: "Float", Resolve: source(func(u UsageResponse) any {
+			if u.PercentUsed == nil {
+				return nil
+			}
+			return *u.PercentUsed
+		})},
+		"measuredAt": {Type: "String!", Resolve: source(func(u UsageResponse) any { return u.MeasuredAt })},
+	}}
+
+	query := &graphql.Object{Name: "Query", Fields: map[string]*graphql.Field{
+		"files": {Type: "FileConnection!", Args: fileArgs(true), Resolve: func(p graphql.Params) (any, error) {
+			return listFiles(p, "")
+		}},
+		"file": {Type: "File", Description: "A file by name, or null if it doesn't exist", Args: []graphql.Arg{{Name: "name", Type: "String!"}}, Resolve: func(p graphql.Params) (any, error) {
+			ns := namespaceFrom(p.Context)
+			name := p.String("name")
+			if isReservedKey(name) {
+				return nil, nil
+			}
+			if _, err := checkAccess(p.Context, p.Context.Value(graphqlSubjectKey{}).(string), ns.key(name), permRead); err != nil {
+				return nil, err
+			}
+			file := &graphqlFile{ns: ns, name: name}
+			head, err := file.metadata(p.Context)
+			if isNotFound(err) {
+				return nil, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			file.object = types.Object{Key: aws.String(ns.key(name)), Size: head.ContentLength, LastModified: head.LastModified, ETag: head.ETag}
+			return file, nil
+		}},
+		"folders": {Type: "[Folder!]!", Description: "Folders directly under prefix", Args: []graphql.Arg{{Name: "prefix", Type: "String"}}, Resolve: func(p graphql.Params) (any, error) {
+			return listGraphQLFolders(p.Context, p.String("prefix"))
+		}},
+		"usage": {Type: "Usage!", Resolve: func(p graphql.Params) (any, error) {
+			return usageReport(p.Context, namespaceFrom(p.Context).Tenant)
+		}},
+	}}
+
+	schema, err := graphql.NewSchema(query, []*graphql.Object{file, tag, connection, folder, usageType}, graphql.Options{
+		Scalars:  map[string]string{"Long": "A 64-bit integer, such as a size in bytes"},
+		MaxDepth: graphqlMaxDepth,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+// graphqlSubjectKey carries the caller to resolvers, which check its access
+// to each file as the REST routes do.
+type graphqlSubjectKey struct{}
+
+// graphqlHandler runs a query. Problems with the query itself, like errors
+// resolving fields, are reported in the body with 200, as GraphQL clients
+// expect.
+func graphqlHandler(w http.ResponseWriter, r *http.Request) {
+	var req GraphQLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondInvalidBody(w, err)
+		return
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Missing query",
+		})
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), graphqlSubjectKey{}, requestSubject(r))
+	respondJSON(w, http.StatusOK, graphqlSchema.Execute(ctx, graphql.Request{
+		Query:         req.Query,
+		OperationName: req.OperationName,
+		Variables:     req.Variables,
+	}))
+}
+
+func graphqlSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, graphqlSchema.SDL())
+}
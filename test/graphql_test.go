@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func graphqlQuery(t *testing.T, srv *httptest.Server, query string, variables map[string]any) (data map[string]any, errors []string) {
+	t.Helper()
+	got := call(t, srv, "POST", "/api/graphql", GraphQLRequest{Query: query, Variables: variables})
+	expectStatus(t, got, http.StatusOK)
+	var response struct {
+		Data   map[string]any `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	got.decode(t, &response)
+	for _, e := range response.Errors {
+		errors = append(errors, e.Message)
+	}
+	return response.Data, errors
+}
+
+func names(t *testing.T, connection any) []string {
+	t.Helper()
+	var list []string
+	for _, node := range connection.(map[string]any)["nodes"].([]any) {
+		list = append(list, node.(map[string]any)["name"].(string))
+	}
+	return list
+}
+
+func TestGraphQLFiles(t *testing.T) {
+	srv, _ := newTestServer(t)
+	for _, name := range []string{"a.txt", "docs/b.txt", "docs/c.txt", "docs/old/d.txt", "e.txt"} {
+		mustUpload(t, srv, "/api", name, strings.Repeat("x", len(name)))
+	}
+	tagged := upload("docs/c.txt", "tagged content")
+	tagged.Tags = map[string]string{"team": "web"}
+	expectStatus(t, call(t, srv, "POST", "/api/upload", tagged), http.StatusOK)
+
+	// Pages follow each other by cursor
+	const page = `query($after: String) { files(first: 2, after: $after) { nodes { name } endCursor hasNextPage } }`
+	var all []string
+	var after any
+	for pages := 0; pages < 5; pages++ {
+		data, errs := graphqlQuery(t, srv, page, map[string]any{"after": after})
+		if len(errs) > 0 {
+			t.Fatal(errs)
+		}
+		files := data["files"].(map[string]any)
+		all = append(all, names(t, files)...)
+		if files["hasNextPage"] != true {
+			break
+		}
+		after = files["endCursor"]
+	}
+	if strings.Join(all, " ") != "a.txt docs/b.txt docs/c.txt docs/old/d.txt e.txt" {
+		t.Errorf("paged through %v", all)
+	}
+
+	for query, want := range map[string]string{
+		`{ files(prefix: "docs/", recursive: false) { nodes { name } } }`:      "docs/b.txt docs/c.txt",
+		`{ files(tag: "team") { nodes { name } } }`:                            "docs/c.txt",
+		`{ files(tag: "team=ops") { nodes { name } } }`:                        "",
+		`{ files(minSize: 10) { nodes { name } } }`:                            "docs/b.txt docs/c.txt docs/old/d.txt",
+		`{ files(maxSize: 5) { nodes { name } } }`:                             "a.txt e.txt",
+		`{ files(modifiedBefore: "2000-01-01T00:00:00Z") { nodes { name } } }`: "",
+	} {
+		data, errs := graphqlQuery(t, srv, query, nil)
+		if len(errs) > 0 {
+			t.Errorf("%s: %v", query, errs)
+			continue
+		}
+		if got := strings.Join(names(t, data["files"]), " "); got != want {
+			t.Errorf("%s: got %q, want %q", query, got, want)
+		}
+	}
+
+	data, errs := graphqlQuery(t, srv, `{ file(name: "docs/c.txt") { size visibility tags { key value } team: tag(key: "team") } missing: file(name: "nope") { name } }`, nil)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	file, _ := json.Marshal(data["file"])
+	if string(file) != `{"size":14,"tags":[{"key":"team","value":"web"}],"team":"web","visibility":"private"}` || data["missing"] != nil {
+		t.Errorf("file: %s, missing: %v", file, data["missing"])
+	}
+
+	data, errs = graphqlQuery(t, srv, `{ files(first: 0) { nodes { name } } }`, nil)
+	if len(errs) != 1 || !strings.Contains(errs[0], "first must be") || data != nil {
+		t.Errorf("first: 0 gave %v, %v", data, errs)
+	}
+}
+
+func TestGraphQLFoldersAndUsage(t *testing.T) {
+	srv, _ := newTestServer(t)
+	for _, name := range []string{"top.txt", "docs/b.txt", "docs/old/d.txt", "img/logo.png"} {
+		mustUpload(t, srv, "/api", name, "content")
+	}
+
+	data, errs := graphqlQuery(t, srv, `{ folders { name files { nodes { name } } folders { path } } usage { objects usedBytes quotaBytes } }`, nil)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	got, _ := json.Marshal(data)
+	want := `{"folders":[{"files":{"nodes":[{"name":"docs/b.txt"}]},"folders":[{"path":"docs/old/"}],"name":"docs"},` +
+		`{"files":{"nodes":[{"name":"img/logo.png"}]},"folders":[],"name":"img"}],"usage":{"objects":4,"quotaBytes":null,"usedBytes":28}}`
+	if string(got) != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+
+	if _, errs := graphqlQuery(t, srv, `{ files { nodes { size } } }`, nil); len(errs) != 0 {
+		t.Errorf("sizes: %v", errs)
+	}
+	if _, errs := graphqlQuery(t, srv, `{ folders { folders { folders { folders { folders { folders { folders { folders { path } } } } } } } } }`, nil); len(errs) == 0 {
+		t.Error("a query nested past GRAPHQL_MAX_DEPTH was run")
+	}
+
+	expectStatus(t, call(t, srv, "POST", "/api/graphql", GraphQLRequest{}), http.StatusBadRequest)
+	schema := call(t, srv, "GET", "/api/graphql/schema", nil)
+	expectStatus(t, schema, http.StatusOK)
+	if !strings.Contains(string(schema.body), "type FileConnection {") {
+		t.Errorf("schema:\n%s", schema.body)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"test-api/internal/fakes3"
+)
+
+var _ s3API = (*fakes3.Client)(nil)
+
+func TestMain(m *testing.M) {
+	// Request logging drowns out test failures
+	slog.SetDefault(slog.New(slog.NewJSONHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// newTestServer serves the full router against a fresh in-memory S3 holding
+// the files bucket. Process-wide caches are reset so tests don't see each
+// other's state.
+func newTestServer(t *testing.T) (*httptest.Server, *fakes3.Client) {
+	t.Helper()
+
+	fake := fakes3.New(bucketName)
+	override(t, &s3Client, s3API(fake))
+	override(t, &usage, &usageTracker{entries: map[string]*tenantUsage{}})
+	override(t, &freezes, &freezeStore{})
+	healthState.mu.Lock()
+	healthState.report, healthState.failures = nil, nil
+	healthState.mu.Unlock()
+	deepHealthState.mu.Lock()
+	deepHealthState.report = nil
+	deepHealthState.mu.Unlock()
+
+	srv := httptest.NewServer(buildRouter(apiRoutes()))
+	t.Cleanup(srv.Close)
+	// Tests assert on redirects rather than follow them
+	srv.Client().CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return srv, fake
+}
+
+// override sets a package-level setting for the duration of the test.
+func override[T any](t testing.TB, target *T, value T) {
+	t.Helper()
+	previous := *target
+	*target = value
+	t.Cleanup(func() { *target = previous })
+}
+
+type response struct {
+	*http.Response
+	body []byte
+}
+
+// decode unmarshals the JSON response body into v.
+func (r response) decode(t *testing.T, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(r.body, v); err != nil {
+		t.Fatalf("decoding %s: %v", r.body, err)
+	}
+}
+
+func (r response) errorMessage(t *testing.T) string {
+	t.Helper()
+	var e ErrorResponse
+	r.decode(t, &e)
+	return e.Error
+}
+
+// call makes a request against srv. A body that isn't a string or []byte is
+// sent as JSON. headers are name/value pairs.
+func call(t *testing.T, srv *httptest.Server, method, path string, body interface{}, headers ...string) response {
+	t.Helper()
+
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		reader = bytes.NewBufferString(b)
+	case []byte:
+		reader = bytes.NewReader(b)
+	default:
+		encoded, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequest(method, srv.URL+path, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response{Response: resp, body: data}
+}
+
+func upload(filename, content string) UploadRequest {
+	return UploadRequest{
+		Filename: filename,
+		Content:  base64.StdEncoding.EncodeToString([]byte(content)),
+	}
+}
+
+// mustUpload uploads a file and returns the key it was stored under.
+func mustUpload(t *testing.T, srv *httptest.Server, prefix, filename, content string, headers ...string) string {
+	t.Helper()
+	resp := call(t, srv, "POST", prefix+"/upload", upload(filename, content), headers...)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("upload of %s: %d %s", filename, resp.StatusCode, resp.body)
+	}
+	var msg MessageResponse
+	resp.decode(t, &msg)
+	return msg.Filename
+}
+
+func expectStatus(t *testing.T, resp response, want int) {
+	t.Helper()
+	if resp.StatusCode != want {
+		t.Fatalf("%s %s: got %d, want %d: %s", resp.Request.Method, resp.Request.URL.Path, resp.StatusCode, want, resp.body)
+	}
+}
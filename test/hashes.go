@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"hash/crc32"
+	"os"
+	"sort"
+	"strings"
+
+	"lukechampine.com/blake3"
+)
+
+// Every stored object records the digest of its original content and the
+// algorithm that produced it, so digests stay verifiable after the deployment
+// default changes.
+const (
+	metaHashAlgorithm = "hash-algorithm"
+	metaContentHash   = "content-hash"
+)
+
+const (
+	hashSHA256 = "sha256"
+	hashBLAKE3 = "blake3"
+	hashCRC32C = "crc32c"
+)
+
+var hashAlgorithms = map[string]func() hash.Hash{
+	hashSHA256: sha256.New,
+	hashBLAKE3: func() hash.Hash { return blake3.New(32, nil) },
+	hashCRC32C: func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) },
+}
+
+var hashAlgorithm = hashSHA256
+
+func init() {
+	if value := strings.ToLower(os.Getenv("HASH_ALGORITHM")); value != "" {
+		if _, ok := hashAlgorithms[value]; !ok {
+			fatal("Unknown HASH_ALGORITHM", "value", value, "supported", supportedHashAlgorithms())
+		}
+		hashAlgorithm = value
+	}
+}
+
+func supportedHashAlgorithms() []string {
+	names := make([]string, 0, len(hashAlgorithms))
+	for name := range hashAlgorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// hashWith returns the hex digest of content using the named algorithm.
+func hashWith(algorithm string, content []byte) string {
+	h := hashAlgorithms[algorithm]()
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// contentHash returns the digest of content using the deployment's algorithm.
+func contentHash(content []byte) string {
+	return hashWith(hashAlgorithm, content)
+}
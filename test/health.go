@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	healthOK       = "ok"
+	healthError    = "error"
+	healthDisabled = "disabled"
+
+	statusHealthy   = "healthy"
+	statusDegraded  = "degraded"
+	statusUnhealthy = "unhealthy"
+)
+
+var (
+	// Health reports are reused for this long so status pages polling the
+	// endpoint don't turn into a stream of S3 calls; clients may cache as long.
+	healthCacheTTL     = durationFromEnv("HEALTH_CACHE_TTL", 5*time.Second)
+	healthCheckTimeout = durationFromEnv("HEALTH_CHECK_TIMEOUT", 2*time.Second)
+)
+
+type ComponentStatus struct {
+	Status        string `json:"status"`
+	Critical      bool   `json:"critical"`
+	LatencyMillis int64  `json:"latency_ms"`
+	CheckedAt     string `json:"checked_at,omitempty"`
+	Error         string `json:"error,omitempty"`
+	LastError     string `json:"last_error,omitempty"`
+	LastErrorAt   string `json:"last_error_at,omitempty"`
+}
+
+// healthCheck probes one component. Components that aren't configured are
+// still reported, as disabled, so the schema doesn't change between
+// deployments.
+type healthCheck struct {
+	name     string
+	critical bool
+	enabled  func() bool
+	check    func(ctx context.Context) error
+}
+
+var healthChecks []healthCheck
+
+func registerHealthCheck(c healthCheck) {
+	healthChecks = append(healthChecks, c)
+}
+
+func always() bool { return true }
+func never() bool  { return false }
+
+func init() {
+	registerHealthCheck(healthCheck{name: "storage", critical: true, enabled: always, check: checkStorage})
+	registerHealthCheck(healthCheck{name: "queue", enabled: func() bool { return objectReplicator != nil }, check: checkReplicationQueue})
+	// This build has no index database or cache
+	registerHealthCheck(healthCheck{name: "index", enabled: never})
+	registerHealthCheck(healthCheck{name: "cache", enabled: never})
+	registerHealthCheck(healthCheck{name: "webhooks", enabled: func() bool { return webhookDeliveries != nil }, check: checkWebhooks})
+	registerHealthCheck(healthCheck{name: "event_bus", enabled: func() bool { return eventPublishing != nil }, check: checkEventBus})
+	registerHealthCheck(healthCheck{name: "antivirus", enabled: antivirusEnabled, check: checkAntivirus})
+}
+
+func checkStorage(ctx context.Context) error {
+	buckets := knownBuckets()
+	return fanOut(ctx, len(buckets), func(ctx context.Context, i int) error {
+		if _, err := s3Client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(buckets[i])}); err != nil {
+			return fmt.Errorf("bucket %s: %w", buckets[i], err)
+		}
+		return nil
+	})
+}
+
+func checkReplicationQueue(ctx context.Context) error {
+	if _, err := objectReplicator.client.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(objectReplicator.bucket),
+	}); err != nil {
+		return fmt.Errorf("replica bucket %s: %w", objectReplicator.bucket, err)
+	}
+	if len(objectReplicator.queue) == cap(objectReplicator.queue) {
+		return fmt.Errorf("replication queue is full")
+	}
+	return nil
+}
+
+type lastFailure struct {
+	message string
+	at      time.Time
+}
+
+// healthState caches the latest report and remembers each component's most
+// recent failure, which stays visible after the component recovers.
+var healthState struct {
+	mu         sync.Mutex
+	report     map[string]ComponentStatus
+	overall    string
+	reportedAt time.Time
+	failures   map[string]lastFailure
+}
+
+// componentReport runs every check concurrently, or returns the cached report
+// if it is still fresh.
+func componentReport(ctx context.Context) (string, map[string]ComponentStatus) {
+	healthState.mu.Lock()
+	defer healthState.mu.Unlock()
+
+	if healthState.report != nil && time.Since(healthState.reportedAt) < healthCacheTTL {
+		return healthState.overall, healthState.report
+	}
+	if healthState.failures == nil {
+		healthState.failures = map[string]lastFailure{}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	// A failing check is a result, not a reason to cut the others short, so
+	// every check reports to results and returns nil
+	results := make([]ComponentStatus, len(healthChecks))
+	fanOut(ctx, len(healthChecks), func(ctx context.Context, i int) error {
+		c := healthChecks[i]
+		results[i] = ComponentStatus{Status: healthDisabled, Critical: c.critical}
+		if !c.enabled() {
+			return nil
+		}
+
+		start := time.Now()
+		err := c.check(ctx)
+		results[i].LatencyMillis = time.Since(start).Milliseconds()
+		results[i].CheckedAt = start.UTC().Format(time.RFC3339)
+		results[i].Status = healthOK
+		if err != nil {
+			results[i].Status = healthError
+			results[i].Error = err.Error()
+		}
+		return nil
+	})
+
+	overall := statusHealthy
+	report := make(map[string]ComponentStatus, len(healthChecks))
+	for i, c := range healthChecks {
+		status := results[i]
+		if status.Status == healthError {
+			healthState.failures[c.name] = lastFailure{message: status.Error, at: time.Now()}
+			if c.critical {
+				overall = statusUnhealthy
+			} else if overall == statusHealthy {
+				overall = statusDegraded
+			}
+		}
+		if failure, ok := healthState.failures[c.name]; ok {
+			status.LastError = failure.message
+			status.LastErrorAt = failure.at.UTC().Format(time.RFC3339)
+		}
+		report[c.name] = status
+	}
+
+	healthState.report, healthState.overall, healthState.reportedAt = report, overall, time.Now()
+	return overall, report
+}
+
+func setCacheControl(w http.ResponseWriter, scope string, ttl time.Duration) {
+	w.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", scope, int(ttl.Seconds())))
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+
+	"test-api/internal/fakes3"
+)
+
+// listFailingS3 fails ListObjectsV2 with err.
+type listFailingS3 struct {
+	*fakes3.Client
+	err error
+}
+
+func (c *listFailingS3) ListObjectsV2(ctx context.Context, in *s3.ListObjectsV2Input, opts ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.Client.ListObjectsV2(ctx, in, opts...)
+}
+
+func TestDeepHealth(t *testing.T) {
+	override(t, &namedBuckets, map[string]string{"archive": "archive-files"})
+	srv, fake := newTestServer(t)
+	deep := func() HealthResponse {
+		t.Helper()
+		override(t, &deepHealthCacheTTL, 0)
+		var health HealthResponse
+		call(t, srv, "GET", "/api/health?deep=true", nil).decode(t, &health)
+		return health
+	}
+	is := func(b *bool, want bool) bool { return b != nil && *b == want }
+
+	health := deep()
+	if len(health.Storage) != 2 || health.Status != statusUnhealthy {
+		t.Fatalf("health %+v", health)
+	}
+	files, archive := health.Storage[0], health.Storage[1]
+	if !is(files.Reachable, true) || !is(files.CredentialsValid, true) || !is(files.BucketExists, true) || !is(files.Listable, true) {
+		t.Fatalf("files bucket %+v", files)
+	}
+	if !is(archive.BucketExists, false) || archive.Listable != nil || archive.Error == "" {
+		t.Fatalf("archive bucket %+v", archive)
+	}
+
+	fake.AddBucket("archive-files")
+	failing := &listFailingS3{Client: fake, err: &smithy.GenericAPIError{Code: "InvalidAccessKeyId"}}
+	override(t, &s3Client, s3API(failing))
+	if health = deep(); !is(health.Storage[0].Reachable, true) || !is(health.Storage[0].CredentialsValid, false) || health.Storage[0].BucketExists != nil {
+		t.Fatalf("bad credentials %+v", health.Storage[0])
+	}
+
+	// Listing refused, but the bucket can still be used
+	failing.err = &smithy.GenericAPIError{Code: "AccessDenied"}
+	if health = deep(); !is(health.Storage[0].BucketExists, true) || !is(health.Storage[0].Listable, false) {
+		t.Fatalf("listing denied %+v", health.Storage[0])
+	}
+
+	failing.err = errors.New("dial tcp: connection refused")
+	if health = deep(); !is(health.Storage[0].Reachable, false) || health.Storage[0].CredentialsValid != nil {
+		t.Fatalf("unreachable %+v", health.Storage[0])
+	}
+
+	// Without deep the report is unchanged
+	failing.err = nil
+	healthState.mu.Lock()
+	healthState.report = nil
+	healthState.mu.Unlock()
+	resp := call(t, srv, "GET", "/api/health", nil)
+	expectStatus(t, resp, http.StatusOK)
+	var shallow HealthResponse
+	resp.decode(t, &shallow)
+	if shallow.Storage != nil {
+		t.Fatalf("storage %+v", shallow.Storage)
+	}
+}
+
+func TestProbes(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	resp := call(t, srv, "GET", "/readyz", nil)
+	expectStatus(t, resp, http.StatusOK)
+	if string(resp.body) != "[+]shutdown ok\n[+]config ok\n[+]storage ok\nreadyz check passed\n" {
+		t.Fatalf("readyz %q", resp.body)
+	}
+
+	override(t, &bucketName, "missing-bucket")
+	resp = call(t, srv, "GET", "/readyz", nil)
+	expectStatus(t, resp, http.StatusServiceUnavailable)
+	if !strings.Contains(string(resp.body), "[+]config ok\n[-]storage failed: ") {
+		t.Fatalf("readyz %q", resp.body)
+	}
+
+	override(t, &s3Client, s3API(&lazyS3{connect: func(context.Context) (s3API, error) {
+		return nil, errors.New("no credentials")
+	}}))
+	resp = call(t, srv, "GET", "/readyz", nil)
+	expectStatus(t, resp, http.StatusServiceUnavailable)
+	if !strings.Contains(string(resp.body), "[-]config failed: ") || !strings.Contains(string(resp.body), "[-]storage skipped") {
+		t.Fatalf("readyz %q", resp.body)
+	}
+	// Liveness never calls storage, so even no client at all is fine
+	override(t, &s3Client, s3API(nil))
+	expectStatus(t, call(t, srv, "GET", "/livez", nil), http.StatusOK)
+}
+
+func TestShutdownDrains(t *testing.T) {
+	srv, fake := newTestServer(t)
+	override(t, &openUploads, &uploadTracker{open: map[string]openUpload{}})
+	override(t, &s3Client, trackUploads(fake))
+
+	ctx := context.Background()
+	started, err := s3Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{Bucket: aws.String(bucketName), Key: aws.String("big.bin")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done, err := s3Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{Bucket: aws.String(bucketName), Key: aws.String("done.bin")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s3Client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{Bucket: aws.String(bucketName), Key: aws.String("done.bin"), UploadId: done.UploadId}); err != nil {
+		t.Fatal(err)
+	}
+
+	draining.Store(true)
+	t.Cleanup(func() { draining.Store(false) })
+	resp := call(t, srv, "GET", "/readyz", nil)
+	expectStatus(t, resp, http.StatusServiceUnavailable)
+	if !strings.HasPrefix(string(resp.body), "[-]shutdown failed: draining connections\n") {
+		t.Fatalf("readyz %q", resp.body)
+	}
+
+	if aborted := openUploads.abortAll(ctx); aborted != 1 || len(openUploads.snapshot()) != 0 {
+		t.Fatalf("aborted %d, still open %v", aborted, openUploads.snapshot())
+	}
+	if _, err := s3Client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{Bucket: aws.String(bucketName), Key: aws.String("big.bin"), UploadId: started.UploadId}); err == nil {
+		t.Fatal("upload was still open")
+	}
+}
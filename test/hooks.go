@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Hooks let code built into the API take part in file operations without the
+// handlers knowing about it, the way storage and event bus backends register
+// themselves. A plugin registers its hooks from a var initialiser, in a file
+// behind a build tag if it needs a dependency the default build doesn't have:
+//
+//	var _ = registerHook(fileHook{name: "legal-hold", point: hookBeforeDelete, run: refuseHeld})
+//
+// Hooks at each point run one at a time, by order and then name, whichever
+// API the operation came through. Those before an operation can veto it by
+// returning veto(reason), and fail it by returning any other error: a hook
+// that couldn't check what it is there to check doesn't let the operation
+// through. Those after an operation can't undo it, so their errors are
+// logged and the rest still run.
+var (
+	// Each hook gets this long; one that takes longer has failed
+	hookTimeout = durationFromEnv("HOOK_TIMEOUT", 5*time.Second)
+	// HOOKS_DISABLED turns registered hooks off by name, e.g. after one
+	// starts failing operations
+	hooksDisabled = splitList(os.Getenv("HOOKS_DISABLED"))
+
+	hookRuns = newCounterVec("hook_runs_total", "File hook runs, by hook and result: ok, vetoed or failed.", "hook", "result")
+)
+
+// The points a hook can run at. A download hook runs before the file is
+// sent, so it can veto it like the before hooks can.
+const (
+	hookBeforeUpload = "before_upload"
+	hookAfterUpload  = "after_upload"
+	hookBeforeDelete = "before_delete"
+	hookOnDownload   = "on_download"
+)
+
+var hookPoints = []string{hookBeforeUpload, hookAfterUpload, hookBeforeDelete, hookOnDownload}
+
+// fileHook is one hook. Hooks with a lower order run first; those with the
+// same order run by name, so the order doesn't depend on how files are
+// compiled.
+type fileHook struct {
+	name  string
+	point string
+	order int
+	run   func(ctx context.Context, e *hookEvent) error
+}
+
+// hookEvent is the operation a hook runs for.
+type hookEvent struct {
+	Point  string
+	Tenant string
+	// Empty for downloads by public or share link
+	Subject  string
+	Filename string
+	// Not known to delete and download hooks
+	Size        int64
+	ContentType string
+	// The file's metadata. Before an upload, hooks may change it, and the
+	// file is stored with what the last of them leaves; after one, it is
+	// what the file was stored with. Nil for delete and download hooks.
+	Metadata map[string]string
+}
+
+// managedMetadata is metadata the API reads back to serve the file, which
+// before-upload hooks can't change.
+var managedMetadata = []string{metaStorageEncoding, metaOriginalSize, metaVisibility, metaScanStatus, metaScanSignature, metaReviewStatus, metaContentHash, metaHashAlgorithm}
+
+// errVetoed is an operation a hook refused.
+type errVetoed struct {
+	hook   string
+	reason string
+}
+
+func (e errVetoed) Error() string {
+	return fmt.Sprintf("vetoed by %s hook: %s", e.hook, e.reason)
+}
+
+// errHookFailed is a before hook that failed, and so failed the operation.
+type errHookFailed struct {
+	hook string
+	err  error
+}
+
+func (e errHookFailed) Error() string { return fmt.Sprintf("%s hook: %v", e.hook, e.err) }
+func (e errHookFailed) Unwrap() error { return e.err }
+
+// veto is what a hook returns to refuse the operation it runs before.
+func veto(reason string) error {
+	return errVetoed{reason: reason}
+}
+
+var fileHooks = map[string][]fileHook{}
+
+func registerHook(h fileHook) bool {
+	if !contains(hookPoints, h.point) {
+		panic(fmt.Sprintf("hook %s is for unknown point %q", h.name, h.point))
+	}
+	hooks := append(fileHooks[h.point], h)
+	sort.SliceStable(hooks, func(i, j int) bool {
+		if hooks[i].order != hooks[j].order {
+			return hooks[i].order < hooks[j].order
+		}
+		return hooks[i].name < hooks[j].name
+	})
+	fileHooks[h.point] = hooks
+	return true
+}
+
+// hookNames lists the hooks that run at each point, in order.
+func hookNames() map[string][]string {
+	names := map[string][]string{}
+	for _, point := range hookPoints {
+		names[point] = []string{}
+		for _, h := range fileHooks[point] {
+			if !contains(hooksDisabled, h.name) {
+				names[point] = append(names[point], h.name)
+			}
+		}
+	}
+	return names
+}
+
+// runHooks runs the hooks at e.Point for the operation in ctx's namespace.
+// Before an operation it stops at the first veto or failure and returns it,
+// as errVetoed or errHookFailed; after one it always returns nil.
+func runHooks(ctx context.Context, e *hookEvent) error {
+	hooks := fileHooks[e.Point]
+	if len(hooks) == 0 {
+		return nil
+	}
+	e.Tenant = namespaceFrom(ctx).Tenant
+	after := e.Point == hookAfterUpload
+	for _, h := range hooks {
+		if contains(hooksDisabled, h.name) {
+			continue
+		}
+		err := runHook(ctx, h, e)
+		var vetoed errVetoed
+		switch {
+		case err == nil:
+			hookRuns.add(1, h.name, "ok")
+			continue
+		case errors.As(err, &vetoed) && !after:
+			hookRuns.add(1, h.name, "vetoed")
+			vetoed.hook = h.name
+			slog.InfoContext(ctx, "Hook vetoed operation", "hook", h.name, "point", e.Point, "key", e.Filename, "reason", vetoed.reason)
+			return vetoed
+		}
+		hookRuns.add(1, h.name, "failed")
+		if after {
+			slog.WarnContext(ctx, "Hook failed", "hook", h.name, "point", e.Point, "key", e.Filename, "err", err)
+			continue
+		}
+		return errHookFailed{h.name, err}
+	}
+	return nil
+}
+
+// runHook runs one hook within its timeout, turning a panic into an error
+// so a broken hook fails the operation rather than the instance.
+func runHook(ctx context.Context, h fileHook, e *hookEvent) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, hookTimeout)
+	defer cancel()
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panicked: %v", p)
+		}
+	}()
+	err = h.run(ctx, e)
+	if err == nil && ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("took longer than %s", hookTimeout)
+	}
+	return err
+}
+
+// beforeUploadHooks runs the before-upload hooks for storing input, and
+// leaves the metadata they settle on on it.
+func beforeUploadHooks(ctx context.Context, subject string, input *s3.PutObjectInput, size int64) error {
+	if len(fileHooks[hookBeforeUpload]) == 0 {
+		return nil
+	}
+	e := uploadHookEvent(ctx, hookBeforeUpload, subject, input, size)
+	e.Metadata = maps.Clone(input.Metadata)
+	if e.Metadata == nil {
+		e.Metadata = map[string]string{}
+	}
+	if err := runHooks(ctx, e); err != nil {
+		return err
+	}
+	for _, name := range managedMetadata {
+		if value, ok := input.Metadata[name]; ok {
+			e.Metadata[name] = value
+		} else {
+			delete(e.Metadata, name)
+		}
+	}
+	input.Metadata = e.Metadata
+	return nil
+}
+
+// afterUploadHooks runs the after-upload hooks for input once it is stored.
+func afterUploadHooks(ctx context.Context, subject string, input *s3.PutObjectInput, size int64) {
+	if len(fileHooks[hookAfterUpload]) == 0 {
+		return
+	}
+	e := uploadHookEvent(ctx, hookAfterUpload, subject, input, size)
+	// What it was stored with, which the hooks can't change
+	e.Metadata = maps.Clone(e.Metadata)
+	runHooks(ctx, e)
+}
+
+// linkDownloadHooks runs the download hooks for a file fetched by public or
+// share link, which has no caller to take the namespace from.
+func linkDownloadHooks(ctx context.Context, bucket, key string) error {
+	ns := namespaceOfKey(key)
+	if bucket != bucketName {
+		ns = namespace{Tenant: defaultTenant, Bucket: bucket}
+	}
+	return runHooks(withNamespace(ctx, ns), &hookEvent{Point: hookOnDownload, Filename: ns.name(key)})
+}
+
+func uploadHookEvent(ctx context.Context, point, subject string, input *s3.PutObjectInput, size int64) *hookEvent {
+	return &hookEvent{
+		Point:       point,
+		Subject:     subject,
+		Filename:    namespaceFrom(ctx).name(aws.ToString(input.Key)),
+		Size:        size,
+		ContentType: aws.ToString(input.ContentType),
+		Metadata:    input.Metadata,
+	}
+}
+
+// respondHookError answers a request for an operation a hook vetoed, with
+// 403, or failed, and reports whether err was either.
+func respondHookError(w http.ResponseWriter, operation string, err error) bool {
+	var vetoed errVetoed
+	var failed errHookFailed
+	switch {
+	case errors.As(err, &vetoed):
+		respondJSON(w, http.StatusForbidden, ErrorResponse{
+			Error:   operation + " refused",
+			Details: vetoed.Error(),
+		})
+	case errors.As(err, &failed):
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   operation + " hook failed",
+			Details: failed.Error(),
+		})
+	default:
+		return false
+	}
+	return true
+}
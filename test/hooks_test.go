@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// hookLog records the hooks that ran, in the order they did.
+type hookLog struct {
+	mu  sync.Mutex
+	ran []string
+}
+
+func (l *hookLog) hook(name, point string, order int, run func(e *hookEvent) error) fileHook {
+	return fileHook{name: name, point: point, order: order, run: func(ctx context.Context, e *hookEvent) error {
+		l.mu.Lock()
+		l.ran = append(l.ran, name+":"+e.Filename)
+		l.mu.Unlock()
+		if run == nil {
+			return nil
+		}
+		return run(e)
+	}}
+}
+
+func (l *hookLog) take() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ran := l.ran
+	l.ran = nil
+	return ran
+}
+
+func TestFileHooks(t *testing.T) {
+	srv, fake := newTestServer(t)
+	override(t, &fileHooks, map[string][]fileHook{})
+	var log hookLog
+	registerHook(log.hook("stamp", hookBeforeUpload, 0, func(e *hookEvent) error {
+		switch e.Filename {
+		case "secret.txt":
+			return veto("secret files stay off the server")
+		case "broken.txt":
+			return errors.New("rules service unreachable")
+		}
+		e.Metadata["classification"] = "internal"
+		// Managed by the API, so left as the upload had it
+		e.Metadata[metaScanStatus] = scanClean
+		return nil
+	}))
+	// With the same order they run by name, and a lower order runs first
+	registerHook(log.hook("index", hookAfterUpload, 0, func(e *hookEvent) error {
+		if e.Metadata["classification"] != "internal" || e.Filename == "notes.txt" && e.Size != 5 {
+			t.Errorf("after-upload hook saw %+v", e)
+		}
+		return errors.New("search index down")
+	}))
+	registerHook(log.hook("audit", hookAfterUpload, 0, nil))
+	registerHook(log.hook("first", hookAfterUpload, -1, nil))
+	registerHook(log.hook("legal-hold", hookBeforeDelete, 0, func(e *hookEvent) error {
+		if strings.HasPrefix(e.Filename, "contracts/") {
+			return veto("under legal hold")
+		}
+		return nil
+	}))
+	registerHook(log.hook("embargo", hookOnDownload, 0, func(e *hookEvent) error {
+		if e.Filename == "results.txt" {
+			return veto("embargoed until Friday")
+		}
+		return nil
+	}))
+
+	// An after-upload hook failing doesn't fail the upload
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("notes.txt", "hello")), http.StatusOK)
+	if ran := log.take(); strings.Join(ran, ",") != "stamp:notes.txt,first:notes.txt,audit:notes.txt,index:notes.txt" {
+		t.Errorf("ran %v", ran)
+	}
+	_, meta, _ := fake.Object(bucketName, "notes.txt")
+	if meta["classification"] != "internal" {
+		t.Errorf("stored with metadata %v", meta)
+	}
+	if _, ok := meta[metaScanStatus]; ok {
+		t.Errorf("hook set managed metadata: %v", meta)
+	}
+
+	resp := call(t, srv, "POST", "/api/upload", upload("secret.txt", "hello"))
+	expectStatus(t, resp, http.StatusForbidden)
+	if msg := resp.errorMessage(t); msg != "Upload refused" {
+		t.Errorf("vetoed upload answered %q", msg)
+	}
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("broken.txt", "hello")), http.StatusInternalServerError)
+	for _, key := range []string{"secret.txt", "broken.txt"} {
+		if _, _, ok := fake.Object(bucketName, key); ok {
+			t.Errorf("%s was stored", key)
+		}
+	}
+	if ran := log.take(); len(ran) != 2 {
+		t.Errorf("after-upload hooks ran for refused uploads: %v", ran)
+	}
+
+	mustUpload(t, srv, "/api", "contracts/acme.pdf", "signed")
+	mustUpload(t, srv, "/api", "results.txt", "42")
+	log.take()
+	expectStatus(t, call(t, srv, "DELETE", "/api/files/contracts/acme.pdf", nil), http.StatusForbidden)
+	expectStatus(t, call(t, srv, "GET", "/api/files/results.txt", nil), http.StatusForbidden)
+	expectStatus(t, call(t, srv, "GET", "/api/files/notes.txt", nil), http.StatusOK)
+
+	// The S3 gateway goes through the same hooks
+	override(t, &s3GatewayKeys, map[string]s3GatewayKey{"AKIDEXAMPLE": {secret: "gateway-secret"}})
+	gw := gatewayClient(t, srv.URL, "AKIDEXAMPLE", "gateway-secret", srv.Client())
+	ctx := context.Background()
+	if _, err := gw.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String("files"), Key: aws.String("contracts/acme.pdf")}); s3ErrorCode(err) != "AccessDenied" {
+		t.Errorf("DeleteObject under legal hold: %v", err)
+	}
+	if _, err := gw.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("files"), Key: aws.String("results.txt")}); s3ErrorCode(err) != "AccessDenied" {
+		t.Errorf("GetObject under embargo: %v", err)
+	}
+	if _, _, ok := fake.Object(bucketName, "contracts/acme.pdf"); !ok {
+		t.Error("file under legal hold was deleted")
+	}
+	expectStatus(t, call(t, srv, "DELETE", "/api/files/notes.txt", nil), http.StatusOK)
+
+	// A folder delete runs them for each file, and reports the ones refused
+	mustUpload(t, srv, "/api", "contracts/draft.txt", "unsigned")
+	var deleted FolderDeleteResponse
+	call(t, srv, "DELETE", "/api/folders/contracts", nil).decode(t, &deleted)
+	if deleted.Count != 0 || len(deleted.Errors) != 2 || !strings.Contains(deleted.Errors[0].Error, "under legal hold") {
+		t.Errorf("folder delete under legal hold: %+v", deleted)
+	}
+	if _, _, ok := fake.Object(bucketName, "contracts/draft.txt"); !ok {
+		t.Error("file under legal hold was deleted with its folder")
+	}
+
+	// Turned off by name
+	override(t, &hooksDisabled, []string{"legal-hold"})
+	expectStatus(t, call(t, srv, "DELETE", "/api/files/contracts/acme.pdf", nil), http.StatusOK)
+	if names := hookNames(); len(names[hookBeforeDelete]) != 0 || strings.Join(names[hookAfterUpload], ",") != "first,audit,index" {
+		t.Errorf("hook names %v", names)
+	}
+}
+
+func TestFileHookFailures(t *testing.T) {
+	srv, fake := newTestServer(t)
+	override(t, &fileHooks, map[string][]fileHook{})
+	override(t, &hookTimeout, 20*time.Millisecond)
+	registerHook(fileHook{name: "crash", point: hookBeforeUpload, run: func(ctx context.Context, e *hookEvent) error {
+		if e.Filename == "crash.txt" {
+			panic("nil map")
+		}
+		return nil
+	}})
+	registerHook(fileHook{name: "slow", point: hookBeforeUpload, run: func(ctx context.Context, e *hookEvent) error {
+		if e.Filename == "slow.txt" {
+			<-ctx.Done()
+		}
+		return nil
+	}})
+
+	for _, name := range []string{"crash.txt", "slow.txt"} {
+		resp := call(t, srv, "POST", "/api/upload", upload(name, "hello"))
+		expectStatus(t, resp, http.StatusInternalServerError)
+		if msg := resp.errorMessage(t); msg != "Upload hook failed" {
+			t.Errorf("%s answered %q", name, msg)
+		}
+		if _, _, ok := fake.Object(bucketName, name); ok {
+			t.Errorf("%s was stored", name)
+		}
+	}
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("fine.txt", "hello")), http.StatusOK)
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a hook for an unknown point didn't panic")
+		}
+	}()
+	registerHook(fileHook{name: "typo", point: "before_uplaod"})
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// The ingestion worker stores files other systems ask for over a queue,
+// e.g. "fetch this URL and store it as reports/q1.csv", through the same
+// checks and storage policies as an upload. It runs instead of the API with
+// -worker. INGEST_QUEUE picks the queue: sqs, which is only built in with
+// -tags sqs, or a NATS JetStream consumer with -tags nats.
+var (
+	ingestQueueKind = envOr("INGEST_QUEUE", "sqs")
+
+	ingestQueueURL = os.Getenv("INGEST_QUEUE_URL")
+	// Messages that can't be processed are moved here, with the reason
+	ingestDLQURL = os.Getenv("INGEST_DLQ_URL")
+
+	ingestWorkers = intFromEnv("INGEST_WORKERS", 4)
+	// A message that fails is received again after INGEST_RETRY_DELAY,
+	// doubling each time, until INGEST_MAX_ATTEMPTS
+	ingestMaxAttempts  = intFromEnv("INGEST_MAX_ATTEMPTS", 5)
+	ingestRetryDelay   = durationFromEnv("INGEST_RETRY_DELAY", 30*time.Second)
+	ingestFetchTimeout = durationFromEnv("INGEST_FETCH_TIMEOUT", 5*time.Minute)
+	// Hosts messages may fetch from, as path.Match patterns; none means any
+	ingestAllowedHosts = splitList(os.Getenv("INGEST_ALLOWED_HOSTS"))
+
+	ingestClient = &http.Client{CheckRedirect: checkIngestRedirect}
+)
+
+const (
+	ingestActionFetch = "fetch"
+	// SQS can hide a message for at most 12 hours
+	ingestMaxRetryDelay = 12 * time.Hour
+)
+
+// IngestMessage is the body of a queued request. Key is the name to store
+// under, as the tenant sees it; Tenant is left out when tenancy is off.
+type IngestMessage struct {
+	Action      string `json:"action"`
+	URL         string `json:"url"`
+	Key         string `json:"key"`
+	Tenant      string `json:"tenant,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+}
+
+// queueMessage is a message as received. Attempt counts its deliveries,
+// this one included, and receipt is what the queue settles it with.
+type queueMessage struct {
+	ID      string
+	Body    string
+	Attempt int
+	receipt any
+}
+
+// ingestQueue is where the worker gets its messages from.
+type ingestQueue interface {
+	// receive waits a while for up to max messages, returning none if there
+	// are none
+	receive(ctx context.Context, max int) ([]queueMessage, error)
+	// done removes a message that has been handled
+	done(ctx context.Context, m queueMessage) error
+	// retry makes a message that failed visible again after delay
+	retry(ctx context.Context, m queueMessage, delay time.Duration) error
+	// deadLetter moves a message that won't succeed to the dead-letter queue
+	deadLetter(ctx context.Context, m queueMessage, reason string) error
+}
+
+// ingestQueueTypes are the INGEST_QUEUE values this build supports.
+var ingestQueueTypes = map[string]func(ctx context.Context) (ingestQueue, error){}
+
+func registerIngestQueue(kind string, open func(ctx context.Context) (ingestQueue, error)) bool {
+	ingestQueueTypes[kind] = open
+	return true
+}
+
+// permanentError is a message that would fail however often it was tried,
+// so it goes straight to the dead-letter queue.
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+func permanent(format string, args ...interface{}) error {
+	return permanentError{fmt.Errorf(format, args...)}
+}
+
+// runIngestWorker consumes the queue until SIGTERM or SIGINT. Messages
+// being processed then get shutdownTimeout to finish; any left are received
+// again once the queue stops hiding them.
+func runIngestWorker() error {
+	switch ingestQueueKind {
+	case "sqs":
+		if ingestQueueURL == "" || ingestDLQURL == "" {
+			return errors.New("-worker needs INGEST_QUEUE_URL and INGEST_DLQ_URL")
+		}
+	case "nats":
+		if err := checkNATSIngestSettings(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("INGEST_QUEUE must be sqs or nats, not %q", ingestQueueKind)
+	}
+	open, ok := ingestQueueTypes[ingestQueueKind]
+	if !ok {
+		return fmt.Errorf("-worker with INGEST_QUEUE=%s is not supported by this build", ingestQueueKind)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	queue, err := open(ctx)
+	if err != nil {
+		return err
+	}
+	slog.Info("Ingestion worker starting", "queue", ingestQueueKind, "workers", ingestWorkers)
+
+	// Work in hand isn't abandoned at the signal; only receiving stops
+	work, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+	var wg sync.WaitGroup
+	for range ingestWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			consumeIngestQueue(ctx, work, queue)
+		}()
+	}
+	<-ctx.Done()
+	slog.Info("Ingestion worker stopping")
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(shutdownTimeout):
+		cancel()
+		<-done
+	}
+	return nil
+}
+
+// consumeIngestQueue receives until ctx ends, and handles what it receives
+// with work.
+func consumeIngestQueue(ctx, work context.Context, queue ingestQueue) {
+	failures := 0
+	for ctx.Err() == nil {
+		messages, err := queue.receive(ctx, 10)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			failures++
+			slog.Error("Failed to receive from the ingest queue", "err", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(min(time.Duration(failures)*time.Second, time.Minute)):
+			}
+			continue
+		}
+		failures = 0
+		for _, m := range messages {
+			handleIngestMessage(work, queue, m)
+		}
+	}
+}
+
+// handleIngestMessage processes m, then removes it, hides it for a retry,
+// or dead-letters it.
+func handleIngestMessage(ctx context.Context, queue ingestQueue, m queueMessage) {
+	log := slog.With("message_id", m.ID, "attempt", m.Attempt)
+	var err error
+	if m.Attempt > ingestMaxAttempts {
+		// It was received again after a retry that couldn't be recorded
+		err = permanent("out of attempts")
+	} else {
+		err = processIngestMessage(ctx, m.Body)
+	}
+
+	var settle error
+	var perm permanentError
+	switch {
+	case err == nil:
+		log.Info("Ingested message")
+		settle = queue.done(ctx, m)
+	case errors.As(err, &perm) || m.Attempt >= ingestMaxAttempts:
+		log.Error("Dead-lettering message", "err", err)
+		settle = queue.deadLetter(ctx, m, err.Error())
+	default:
+		delay := ingestBackoff(m.Attempt)
+		log.Warn("Failed to ingest message; retrying", "err", err, "retry_in", delay)
+		settle = queue.retry(ctx, m, delay)
+	}
+	if settle != nil {
+		// The queue delivers it again once it stops hiding it
+		log.Error("Failed to settle message", "err", settle)
+	}
+}
+
+func ingestBackoff(attempt int) time.Duration {
+	delay := ingestRetryDelay << (attempt - 1)
+	if delay <= 0 || delay > ingestMaxRetryDelay {
+		delay = ingestMaxRetryDelay
+	}
+	return delay
+}
+
+// processIngestMessage carries out one message.
+func processIngestMessage(ctx context.Context, body string) error {
+	var msg IngestMessage
+	if err := json.Unmarshal([]byte(body), &msg); err != nil {
+		return permanent("malformed message: %v", err)
+	}
+	if msg.Action != ingestActionFetch {
+		return permanent("unknown action %q", msg.Action)
+	}
+	tenant := msg.Tenant
+	switch {
+	case !tenancyEnabled():
+		tenant = defaultTenant
+	case tenant == "":
+		return permanent("tenant is required")
+	case !validTenantID.MatchString(tenant):
+		return permanent("invalid tenant %q", tenant)
+	}
+	name, err := sanitizeName(msg.Key)
+	if err != nil {
+		return permanentError{err}
+	}
+	if isReservedKey(name) {
+		return permanent("%s is a reserved name", name)
+	}
+	source, err := url.Parse(msg.URL)
+	if err != nil {
+		return permanent("url must be an absolute http or https URL")
+	}
+	if err := checkIngestSource(source); err != nil {
+		return err
+	}
+
+	ns := namespaceFor(tenant)
+	ctx = withNamespace(ctx, ns)
+	key := ns.key(name)
+	f, frozen, err := frozenBy(ctx, tenant, []string{name}, nil)
+	if err != nil {
+		return err
+	}
+	if frozen {
+		// Freezes are lifted, so this can wait
+		return fmt.Errorf("%s is frozen: %s", f.Prefix, f.Reason)
+	}
+	// The worker stores as the tenant, as an API key without a subject would
+	acl, err := checkAccess(ctx, tenant, key, permWrite)
+	if errors.Is(err, errAccessDenied) {
+		return permanent("%s's ACL doesn't let the tenant write it", name)
+	}
+	if err != nil {
+		return err
+	}
+
+	content, contentType, err := fetchIngestSource(ctx, source.String(), tenant)
+	if err != nil {
+		return err
+	}
+	input := &s3.PutObjectInput{Bucket: aws.String(ns.Bucket), Key: aws.String(key)}
+	if msg.ContentType != "" {
+		contentType = msg.ContentType
+	}
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+	_, err = storeFile(ctx, tenant, input, content, acl)
+	var rejected errRejected
+	var exceeded errQuotaExceeded
+	var vetoed errVetoed
+	if errors.As(err, &rejected) || errors.As(err, &exceeded) || errors.As(err, &vetoed) {
+		return permanentError{err}
+	}
+	return err
+}
+
+func matchesAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matchPattern(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIngestSource reports whether the worker may fetch from source.
+func checkIngestSource(source *url.URL) error {
+	if (source.Scheme != "https" && source.Scheme != "http") || source.Host == "" {
+		return permanent("url must be an absolute http or https URL")
+	}
+	if len(ingestAllowedHosts) > 0 && !matchesAny(ingestAllowedHosts, source.Hostname()) {
+		return permanent("fetching from %s is not allowed", source.Hostname())
+	}
+	return nil
+}
+
+// checkIngestRedirect checks each redirect like the URL in the message, so
+// an allowed host can't send the worker somewhere that isn't.
+func checkIngestRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return permanent("stopped after 10 redirects")
+	}
+	return checkIngestSource(req.URL)
+}
+
+// fetchIngestSource downloads url, up to the tenant's object size limit.
+func fetchIngestSource(ctx context.Context, source, tenant string) ([]byte, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, ingestFetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, "", permanentError{err}
+	}
+	resp, err := ingestClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode/100 == 2:
+	case resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests:
+		return nil, "", permanent("fetching %s: %s", source, resp.Status)
+	default:
+		return nil, "", fmt.Errorf("fetching %s: %s", source, resp.Status)
+	}
+
+	limit, _ := objectSizeLimit(tenant, false)
+	if limit > 0 && resp.ContentLength > limit {
+		return nil, "", permanent("the file is %d bytes and the limit is %d", resp.ContentLength, limit)
+	}
+	body := io.Reader(resp.Body)
+	if limit > 0 {
+		body = io.LimitReader(resp.Body, limit+1)
+	}
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return nil, "", fmt.Errorf("fetching %s: %w", source, err)
+	}
+	if limit > 0 && int64(len(content)) > limit {
+		return nil, "", permanent("the file is over the limit of %d bytes", limit)
+	}
+	return content, resp.Header.Get("Content-Type"), nil
+}
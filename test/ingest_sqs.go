@@ -0,0 +1,96 @@
+//go:build sqs
+
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+var _ = registerIngestQueue("sqs", openSQSQueue)
+
+// sqsQueue long-polls an SQS queue, and dead-letters by sending to another
+// queue and deleting from this one.
+type sqsQueue struct {
+	client   *sqs.Client
+	queueURL string
+	dlqURL   string
+}
+
+func openSQSQueue(ctx context.Context) (ingestQueue, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &sqsQueue{client: sqs.NewFromConfig(cfg), queueURL: ingestQueueURL, dlqURL: ingestDLQURL}, nil
+}
+
+func (q *sqsQueue) receive(ctx context.Context, max int) ([]queueMessage, error) {
+	out, err := q.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:                    aws.String(q.queueURL),
+		MaxNumberOfMessages:         int32(max),
+		WaitTimeSeconds:             20,
+		MessageSystemAttributeNames: []types.MessageSystemAttributeName{types.MessageSystemAttributeNameApproximateReceiveCount},
+	})
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]queueMessage, 0, len(out.Messages))
+	for _, m := range out.Messages {
+		attempt, err := strconv.Atoi(m.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)])
+		if err != nil {
+			attempt = 1
+		}
+		messages = append(messages, queueMessage{
+			ID:      aws.ToString(m.MessageId),
+			Body:    aws.ToString(m.Body),
+			Attempt: attempt,
+			receipt: aws.ToString(m.ReceiptHandle),
+		})
+	}
+	return messages, nil
+}
+
+func (q *sqsQueue) done(ctx context.Context, m queueMessage) error {
+	_, err := q.client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(q.queueURL),
+		ReceiptHandle: aws.String(m.receipt.(string)),
+	})
+	return err
+}
+
+func (q *sqsQueue) retry(ctx context.Context, m queueMessage, delay time.Duration) error {
+	_, err := q.client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(q.queueURL),
+		ReceiptHandle:     aws.String(m.receipt.(string)),
+		VisibilityTimeout: int32(delay / time.Second),
+	})
+	return err
+}
+
+func (q *sqsQueue) deadLetter(ctx context.Context, m queueMessage, reason string) error {
+	// Attributes count towards SQS's message size limit along with the body
+	if len(reason) > 1024 {
+		reason = strings.ToValidUTF8(reason[:1024], "")
+	}
+	_, err := q.client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(q.dlqURL),
+		MessageBody: aws.String(m.Body),
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			"error":         {DataType: aws.String("String"), StringValue: aws.String(reason)},
+			"message_id":    {DataType: aws.String("String"), StringValue: aws.String(m.ID)},
+			"receive_count": {DataType: aws.String("Number"), StringValue: aws.String(strconv.Itoa(m.Attempt))},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return q.done(ctx, m)
+}
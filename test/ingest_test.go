@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// fakeQueue records how each message was settled.
+type fakeQueue struct {
+	settled map[string]string
+	delays  map[string]time.Duration
+	reasons map[string]string
+}
+
+func newFakeQueue() *fakeQueue {
+	return &fakeQueue{settled: map[string]string{}, delays: map[string]time.Duration{}, reasons: map[string]string{}}
+}
+
+func (q *fakeQueue) receive(ctx context.Context, max int) ([]queueMessage, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (q *fakeQueue) done(ctx context.Context, m queueMessage) error {
+	q.settled[m.ID] = "done"
+	return nil
+}
+
+func (q *fakeQueue) retry(ctx context.Context, m queueMessage, delay time.Duration) error {
+	q.settled[m.ID], q.delays[m.ID] = "retry", delay
+	return nil
+}
+
+func (q *fakeQueue) deadLetter(ctx context.Context, m queueMessage, reason string) error {
+	q.settled[m.ID], q.reasons[m.ID] = "dead", reason
+	return nil
+}
+
+func ingestMessage(t *testing.T, id string, attempt int, msg IngestMessage) queueMessage {
+	t.Helper()
+	body, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return queueMessage{ID: id, Body: string(body), Attempt: attempt}
+}
+
+func TestIngestWorker(t *testing.T) {
+	_, fake := newTestServer(t)
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/report.csv":
+			w.Header().Set("Content-Type", "text/csv")
+			w.Write([]byte("id,amount\n1,2\n"))
+		case "/flaky":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer source.Close()
+	override(t, &ingestRetryDelay, time.Minute)
+	override(t, &ingestMaxAttempts, 3)
+	q := newFakeQueue()
+
+	handleIngestMessage(context.Background(), q, ingestMessage(t, "ok", 1, IngestMessage{Action: ingestActionFetch, URL: source.URL + "/report.csv", Key: "reports/q1.csv"}))
+	if q.settled["ok"] != "done" {
+		t.Fatalf("ingested message settled as %q: %s", q.settled["ok"], q.reasons["ok"])
+	}
+	if body, _, _ := fake.Object(bucketName, "reports/q1.csv"); string(body) != "id,amount\n1,2\n" {
+		t.Errorf("stored %q", body)
+	}
+	head, err := fake.HeadObject(context.Background(), &s3.HeadObjectInput{Bucket: aws.String(bucketName), Key: aws.String("reports/q1.csv")})
+	if err != nil || aws.ToString(head.ContentType) != "text/csv" {
+		t.Errorf("stored with content type %v, %v", head, err)
+	}
+
+	// Worth trying again, with a longer wait each time, until out of attempts
+	flaky := IngestMessage{Action: ingestActionFetch, URL: source.URL + "/flaky", Key: "flaky.txt"}
+	handleIngestMessage(context.Background(), q, ingestMessage(t, "flaky-1", 2, flaky))
+	if q.settled["flaky-1"] != "retry" || q.delays["flaky-1"] != 2*time.Minute {
+		t.Errorf("failed message settled as %q after %s", q.settled["flaky-1"], q.delays["flaky-1"])
+	}
+	handleIngestMessage(context.Background(), q, ingestMessage(t, "flaky-3", 3, flaky))
+	if q.settled["flaky-3"] != "dead" || !strings.Contains(q.reasons["flaky-3"], "503") {
+		t.Errorf("last attempt settled as %q: %s", q.settled["flaky-3"], q.reasons["flaky-3"])
+	}
+
+	// Not worth trying again
+	for id, msg := range map[string]IngestMessage{
+		"missing":  {Action: ingestActionFetch, URL: source.URL + "/missing", Key: "missing.txt"},
+		"action":   {Action: "delete", Key: "a.txt"},
+		"name":     {Action: ingestActionFetch, URL: source.URL + "/report.csv", Key: "../escape.csv"},
+		"reserved": {Action: ingestActionFetch, URL: source.URL + "/report.csv", Key: ".trash/x"},
+		"scheme":   {Action: ingestActionFetch, URL: "file:///etc/passwd", Key: "passwd"},
+	} {
+		handleIngestMessage(context.Background(), q, ingestMessage(t, id, 1, msg))
+		if q.settled[id] != "dead" {
+			t.Errorf("%s: settled as %q", id, q.settled[id])
+		}
+	}
+	handleIngestMessage(context.Background(), q, queueMessage{ID: "json", Body: "{", Attempt: 1})
+	if q.settled["json"] != "dead" || !strings.Contains(q.reasons["json"], "malformed") {
+		t.Errorf("malformed message settled as %q: %s", q.settled["json"], q.reasons["json"])
+	}
+}
+
+func TestIngestLimits(t *testing.T) {
+	_, fake := newTestServer(t)
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 100)))
+	}))
+	defer source.Close()
+	q := newFakeQueue()
+
+	override(t, &maxObjectBytes, 10)
+	handleIngestMessage(context.Background(), q, ingestMessage(t, "big", 1, IngestMessage{Action: ingestActionFetch, URL: source.URL, Key: "big.txt"}))
+	if q.settled["big"] != "dead" || !strings.Contains(q.reasons["big"], "limit") {
+		t.Errorf("oversized file settled as %q: %s", q.settled["big"], q.reasons["big"])
+	}
+	override(t, &maxObjectBytes, 0)
+
+	override(t, &ingestAllowedHosts, []string{"*.example.com"})
+	handleIngestMessage(context.Background(), q, ingestMessage(t, "host", 1, IngestMessage{Action: ingestActionFetch, URL: source.URL, Key: "host.txt"}))
+	if q.settled["host"] != "dead" || !strings.Contains(q.reasons["host"], "not allowed") {
+		t.Errorf("disallowed host settled as %q: %s", q.settled["host"], q.reasons["host"])
+	}
+
+	// Nor can an allowed host redirect somewhere that isn't
+	var fetched bool
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched = true
+		w.Write([]byte("secret"))
+	}))
+	defer internal.Close()
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, strings.Replace(internal.URL, "127.0.0.1", "localhost", 1), http.StatusFound)
+	}))
+	defer redirect.Close()
+	override(t, &ingestAllowedHosts, []string{"127.0.0.1"})
+	handleIngestMessage(context.Background(), q, ingestMessage(t, "redirect", 1, IngestMessage{Action: ingestActionFetch, URL: redirect.URL, Key: "redirect.txt"}))
+	if q.settled["redirect"] != "dead" || !strings.Contains(q.reasons["redirect"], "localhost is not allowed") || fetched {
+		t.Errorf("redirect to a disallowed host settled as %q: %s", q.settled["redirect"], q.reasons["redirect"])
+	}
+	for _, key := range []string{"big.txt", "host.txt", "redirect.txt"} {
+		if _, _, ok := fake.Object(bucketName, key); ok {
+			t.Errorf("%s was stored", key)
+		}
+	}
+}
+
+func TestIngestWorkerNeedsQueue(t *testing.T) {
+	override(t, &ingestQueueKind, "sqs")
+	override(t, &ingestQueueURL, "https://sqs.eu-west-1.amazonaws.com/123456789012/ingest")
+	override(t, &ingestDLQURL, "https://sqs.eu-west-1.amazonaws.com/123456789012/ingest-dlq")
+	override(t, &ingestQueueTypes, map[string]func(context.Context) (ingestQueue, error){})
+	if err := runIngestWorker(); err == nil || !strings.Contains(err.Error(), "not supported by this build") {
+		t.Errorf("starting without an SQS client: %v", err)
+	}
+	override(t, &ingestDLQURL, "")
+	if err := runIngestWorker(); err == nil || !strings.Contains(err.Error(), "INGEST_DLQ_URL") {
+		t.Errorf("starting without a dead-letter queue: %v", err)
+	}
+
+	override(t, &ingestQueueKind, "nats")
+	override(t, &natsURL, "nats://nats:4222")
+	override(t, &natsIngestStream, "UPLOADS")
+	override(t, &natsIngestConsumer, "")
+	if err := runIngestWorker(); err == nil || !strings.Contains(err.Error(), "NATS_INGEST_CONSUMER") {
+		t.Errorf("starting without a consumer: %v", err)
+	}
+	override(t, &ingestQueueKind, "kafka")
+	if err := runIngestWorker(); err == nil || !strings.Contains(err.Error(), "INGEST_QUEUE") {
+		t.Errorf("starting with an unknown queue: %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+// Package cli is what the commands under cmd/ share so they behave the same
+// in scripts and CI pipelines: -json and -quiet output modes, and exit codes
+// that tell the class of failure apart.
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Exit codes. Anything that isn't one of the classes below exits with
+// ExitFailed, as does a command that ran but found problems, such as
+// differences between two deployments.
+const (
+	ExitOK          = 0
+	ExitFailed      = 1
+	ExitUsage       = 2
+	ExitUnavailable = 3 // the server couldn't be reached or answered 5xx
+	ExitAuth        = 4 // the credentials were missing or refused
+	ExitNotFound    = 5
+	ExitRejected    = 6 // the server refused the request as invalid
+)
+
+// Error is an error that ends the command with Code.
+type Error struct {
+	Code int
+	Err  error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+func (e *Error) Unwrap() error { return e.Err }
+
+// Errorf returns an Error with the given exit code.
+func Errorf(code int, format string, args ...any) error {
+	return &Error{Code: code, Err: fmt.Errorf(format, args...)}
+}
+
+// Unavailable classifies err from an HTTP client, which couldn't get an
+// answer at all.
+func Unavailable(err error) error {
+	return &Error{Code: ExitUnavailable, Err: err}
+}
+
+// HTTPError describes an unexpected response, classified by its status. It
+// reads the start of the body for the message.
+func HTTPError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return &Error{
+		Code: ExitCodeFor(resp.StatusCode),
+		Err:  fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body))),
+	}
+}
+
+// ExitCodeFor is the exit code for a request answered with an unexpected
+// HTTP status; 0 stands for no answer at all.
+func ExitCodeFor(status int) int {
+	switch {
+	case status == 0 || status >= 500:
+		return ExitUnavailable
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ExitAuth
+	case status == http.StatusNotFound:
+		return ExitNotFound
+	case status >= 400:
+		return ExitRejected
+	}
+	return ExitFailed
+}
+
+// ExitCode is the code a command ending with err exits with.
+func ExitCode(err error) int {
+	var e *Error
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.As(err, &e):
+		return e.Code
+	}
+	return ExitFailed
+}
+
+// Output writes a command's results as text lines, or with -json as one
+// JSON object per line. With -quiet, it writes only errors, and the exit
+// code tells the outcome.
+type Output struct {
+	JSON  bool
+	Quiet bool
+
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// NewOutput registers -json and -quiet on fs.
+func NewOutput(fs *flag.FlagSet) *Output {
+	o := &Output{Stdout: os.Stdout, Stderr: os.Stderr}
+	fs.BoolVar(&o.JSON, "json", false, "write results as JSON lines")
+	fs.BoolVar(&o.Quiet, "quiet", false, "write nothing but errors; the exit code tells the result")
+	return o
+}
+
+// Result reports v, or in text mode the line format describes.
+func (o *Output) Result(v any, format string, args ...any) {
+	switch {
+	case o.Quiet:
+	case o.JSON:
+		json.NewEncoder(o.Stdout).Encode(v)
+	default:
+		fmt.Fprintf(o.Stdout, format+"\n", args...)
+	}
+}
+
+// Exit ends the command with err's exit code, reporting err first. In JSON
+// mode the error is also written to stdout, so a pipeline reading it sees
+// why there are no more results.
+func (o *Output) Exit(err error) {
+	if err != nil {
+		fmt.Fprintf(o.Stderr, "%v\n", err)
+		if o.JSON && !o.Quiet {
+			json.NewEncoder(o.Stdout).Encode(struct {
+				Error    string `json:"error"`
+				ExitCode int    `json:"exit_code"`
+			}{err.Error(), ExitCode(err)})
+		}
+	}
+	os.Exit(ExitCode(err))
+}
+
+// Usage reports a usage error, with the flags, and exits with ExitUsage.
+func (o *Output) Usage(fs *flag.FlagSet, format string, args ...any) {
+	fmt.Fprintf(o.Stderr, format+"\n", args...)
+	fs.Usage()
+	os.Exit(ExitUsage)
+}
@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestExitCodes(t *testing.T) {
+	for status, want := range map[int]int{
+		http.StatusUnauthorized:       ExitAuth,
+		http.StatusForbidden:          ExitAuth,
+		http.StatusNotFound:           ExitNotFound,
+		http.StatusBadRequest:         ExitRejected,
+		http.StatusTooManyRequests:    ExitRejected,
+		http.StatusServiceUnavailable: ExitUnavailable,
+	} {
+		resp := &http.Response{StatusCode: status, Status: http.StatusText(status), Body: io.NopCloser(strings.NewReader(`{"error":"nope"}` + "\n"))}
+		err := fmt.Errorf("listing: %w", HTTPError(resp))
+		if got := ExitCode(err); got != want {
+			t.Errorf("%d exits %d, want %d", status, got, want)
+		}
+		if !strings.HasSuffix(err.Error(), `: {"error":"nope"}`) {
+			t.Errorf("message %q", err)
+		}
+	}
+
+	if got := ExitCode(Unavailable(errors.New("connection refused"))); got != ExitUnavailable {
+		t.Errorf("transport error exits %d", got)
+	}
+	if got := ExitCode(errors.New("something else")); got != ExitFailed {
+		t.Errorf("unclassified error exits %d", got)
+	}
+	if got := ExitCode(nil); got != ExitOK {
+		t.Errorf("success exits %d", got)
+	}
+}
+
+func TestOutputModes(t *testing.T) {
+	type row struct {
+		ID string `json:"id"`
+	}
+	for _, tc := range []struct {
+		name        string
+		json, quiet bool
+		want        string
+	}{
+		{"text", false, false, "OK abc\n"},
+		{"json", true, false, `{"id":"abc"}` + "\n"},
+		{"quiet", false, true, ""},
+		{"quiet json", true, true, ""},
+	} {
+		var stdout bytes.Buffer
+		out := &Output{JSON: tc.json, Quiet: tc.quiet, Stdout: &stdout}
+		out.Result(row{"abc"}, "OK %s", "abc")
+		if stdout.String() != tc.want {
+			t.Errorf("%s wrote %q, want %q", tc.name, stdout.String(), tc.want)
+		}
+	}
+}
@@ -0,0 +1,803 @@
+// Package fakes3 is an in-memory stand-in for the parts of the S3 API the
+// service uses. It mimics S3's observable behaviour closely enough for
+// end-to-end tests: not-found errors carry S3's error codes, listings are
+// sorted and paginated, versioning keeps history and delete markers, and
+// range reads, tags, legal holds and multipart uploads work.
+package fakes3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+const defaultMaxKeys = 1000
+
+// Client holds buckets in memory. The zero value is not usable; call New.
+type Client struct {
+	// Now stamps LastModified on writes; tests may replace it to control time.
+	Now func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	uploads map[string]*upload
+	nextID  int
+}
+
+type bucket struct {
+	versioned bool
+	// Versions of each key, oldest first. Unversioned buckets keep one.
+	history map[string][]*object
+}
+
+type object struct {
+	body         []byte
+	contentType  string
+	metadata     map[string]string
+	tags         map[string]string
+	etag         string
+	versionID    string
+	deleteMarker bool
+	legalHold    types.ObjectLockLegalHoldStatus
+	lastModified time.Time
+}
+
+type upload struct {
+	bucket string
+	input  s3.PutObjectInput
+	parts  map[int32][]byte
+}
+
+// New returns a client with the given buckets already created.
+func New(buckets ...string) *Client {
+	c := &Client{
+		Now:     time.Now,
+		buckets: map[string]*bucket{},
+		uploads: map[string]*upload{},
+	}
+	for _, name := range buckets {
+		c.AddBucket(name)
+	}
+	return c
+}
+
+// AddBucket creates an empty, unversioned bucket.
+func (c *Client) AddBucket(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.buckets[name] = &bucket{history: map[string][]*object{}}
+}
+
+// EnableVersioning turns on versioning for an existing bucket.
+func (c *Client) EnableVersioning(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.buckets[name].versioned = true
+}
+
+// Object returns the stored bytes and metadata of the current version of key.
+func (c *Client) Object(bucketName, key string) ([]byte, map[string]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, ok := c.buckets[bucketName]
+	if !ok {
+		return nil, nil, false
+	}
+	obj := b.current(key)
+	if obj == nil {
+		return nil, nil, false
+	}
+	return append([]byte(nil), obj.body...), copyMap(obj.metadata), true
+}
+
+// Keys lists the keys with a current version in the bucket, sorted.
+func (c *Client) Keys(bucketName string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, ok := c.buckets[bucketName]
+	if !ok {
+		return nil
+	}
+	return b.keys("")
+}
+
+func (b *bucket) current(key string) *object {
+	versions := b.history[key]
+	if len(versions) == 0 {
+		return nil
+	}
+	latest := versions[len(versions)-1]
+	if latest.deleteMarker {
+		return nil
+	}
+	return latest
+}
+
+func (b *bucket) version(key, versionID string) *object {
+	for _, obj := range b.history[key] {
+		if obj.versionID == versionID {
+			return obj
+		}
+	}
+	return nil
+}
+
+func (b *bucket) keys(prefix string) []string {
+	var keys []string
+	for key := range b.history {
+		if strings.HasPrefix(key, prefix) && b.current(key) != nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (c *Client) put(b *bucket, key string, obj *object) {
+	obj.lastModified = c.Now().UTC()
+	if !b.versioned {
+		obj.versionID = "null"
+		b.history[key] = []*object{obj}
+		return
+	}
+	c.nextID++
+	obj.versionID = fmt.Sprintf("v%06d", c.nextID)
+	b.history[key] = append(b.history[key], obj)
+}
+
+// bucket must be called with c.mu held.
+func (c *Client) bucket(name *string) (*bucket, error) {
+	b, ok := c.buckets[aws.ToString(name)]
+	if !ok {
+		return nil, &types.NoSuchBucket{Message: aws.String("The specified bucket does not exist")}
+	}
+	return b, nil
+}
+
+func noSuchKey() error {
+	return &types.NoSuchKey{Message: aws.String("The specified key does not exist.")}
+}
+
+func apiError(code, message string) error {
+	return &smithy.GenericAPIError{Code: code, Message: message}
+}
+
+// lookup finds the requested version of key, or the current one.
+func (b *bucket) lookup(key string, versionID *string) (*object, error) {
+	if versionID != nil {
+		obj := b.version(key, *versionID)
+		if obj == nil {
+			return nil, apiError("NoSuchVersion", "The specified version does not exist.")
+		}
+		if obj.deleteMarker {
+			return nil, apiError("MethodNotAllowed", "The specified method is not allowed against this resource.")
+		}
+		return obj, nil
+	}
+	obj := b.current(key)
+	if obj == nil {
+		return nil, noSuchKey()
+	}
+	return obj, nil
+}
+
+func etagOf(body []byte) string {
+	sum := md5.Sum(body)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func copyMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[strings.ToLower(k)] = v
+	}
+	return out
+}
+
+func parseTags(tagging *string) map[string]string {
+	tags := map[string]string{}
+	values, _ := url.ParseQuery(aws.ToString(tagging))
+	for k := range values {
+		tags[k] = values.Get(k)
+	}
+	return tags
+}
+
+func (c *Client) PutObject(ctx context.Context, in *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	var body []byte
+	if in.Body != nil {
+		var err error
+		if body, err = io.ReadAll(in.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, err := c.bucket(in.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	key := aws.ToString(in.Key)
+	current := b.current(key)
+	if aws.ToString(in.IfNoneMatch) == "*" && current != nil {
+		return nil, apiError("PreconditionFailed", "At least one of the pre-conditions you specified did not hold")
+	}
+	if in.IfMatch != nil && (current == nil || current.etag != aws.ToString(in.IfMatch)) {
+		return nil, apiError("PreconditionFailed", "At least one of the pre-conditions you specified did not hold")
+	}
+
+	obj := &object{
+		body:        body,
+		contentType: aws.ToString(in.ContentType),
+		metadata:    copyMap(in.Metadata),
+		tags:        parseTags(in.Tagging),
+		etag:        etagOf(body),
+		legalHold:   in.ObjectLockLegalHoldStatus,
+	}
+	c.put(b, key, obj)
+
+	return &s3.PutObjectOutput{ETag: aws.String(obj.etag), VersionId: versionOutput(b, obj)}, nil
+}
+
+func versionOutput(b *bucket, obj *object) *string {
+	if !b.versioned {
+		return nil
+	}
+	return aws.String(obj.versionID)
+}
+
+// parseRange resolves an HTTP Range header against size, returning the
+// inclusive start and end offsets.
+func parseRange(header string, size int64) (int64, int64, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return 0, 0, fmt.Errorf("unsupported range %q", header)
+	}
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("unsupported range %q", header)
+	}
+
+	var start, end int64
+	var err error
+	switch {
+	case first == "":
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		start, end = size-n, size-1
+		if start < 0 {
+			start = 0
+		}
+	case last == "":
+		if start, err = strconv.ParseInt(first, 10, 64); err != nil {
+			return 0, 0, err
+		}
+		end = size - 1
+	default:
+		if start, err = strconv.ParseInt(first, 10, 64); err != nil {
+			return 0, 0, err
+		}
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil {
+			return 0, 0, err
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	if start >= size || start > end {
+		return 0, 0, apiError("InvalidRange", "The requested range is not satisfiable")
+	}
+	return start, end, nil
+}
+
+func (c *Client) GetObject(ctx context.Context, in *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, err := c.bucket(in.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := b.lookup(aws.ToString(in.Key), in.VersionId)
+	if err != nil {
+		return nil, err
+	}
+	if in.IfNoneMatch != nil && aws.ToString(in.IfNoneMatch) == obj.etag {
+		return nil, apiError("NotModified", "Not Modified")
+	}
+
+	body := obj.body
+	out := &s3.GetObjectOutput{
+		ContentType:   aws.String(obj.contentType),
+		ETag:          aws.String(obj.etag),
+		LastModified:  aws.Time(obj.lastModified),
+		Metadata:      copyMap(obj.metadata),
+		VersionId:     versionOutput(b, obj),
+		AcceptRanges:  aws.String("bytes"),
+		TagCount:      aws.Int32(int32(len(obj.tags))),
+		ContentLength: aws.Int64(int64(len(body))),
+	}
+	if in.Range != nil {
+		start, end, err := parseRange(*in.Range, int64(len(body)))
+		if err != nil {
+			return nil, err
+		}
+		out.ContentRange = aws.String(fmt.Sprintf("bytes %d-%d/%d", start, end, len(body)))
+		body = body[start : end+1]
+		out.ContentLength = aws.Int64(int64(len(body)))
+	}
+	out.Body = io.NopCloser(bytes.NewReader(append([]byte(nil), body...)))
+	return out, nil
+}
+
+func (c *Client) HeadObject(ctx context.Context, in *s3.HeadObjectInput, _ ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, err := c.bucket(in.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := b.lookup(aws.ToString(in.Key), in.VersionId)
+	if err != nil {
+		// HEAD responses have no body, so S3 can only report a bare NotFound
+		return nil, &types.NotFound{Message: aws.String("Not Found")}
+	}
+	return &s3.HeadObjectOutput{
+		ContentLength: aws.Int64(int64(len(obj.body))),
+		ContentType:   aws.String(obj.contentType),
+		ETag:          aws.String(obj.etag),
+		LastModified:  aws.Time(obj.lastModified),
+		Metadata:      copyMap(obj.metadata),
+		VersionId:     versionOutput(b, obj),
+	}, nil
+}
+
+// parseCopySource splits "bucket/key?versionId=..." with a URL-escaped key.
+func parseCopySource(source string) (string, string, *string, error) {
+	path, query, _ := strings.Cut(source, "?")
+	path, err := url.PathUnescape(strings.TrimPrefix(path, "/"))
+	if err != nil {
+		return "", "", nil, err
+	}
+	bucketName, key, ok := strings.Cut(path, "/")
+	if !ok {
+		return "", "", nil, apiError("InvalidArgument", "Invalid copy source")
+	}
+
+	var versionID *string
+	if query != "" {
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			return "", "", nil, err
+		}
+		if v := values.Get("versionId"); v != "" {
+			versionID = aws.String(v)
+		}
+	}
+	return bucketName, key, versionID, nil
+}
+
+func (c *Client) CopyObject(ctx context.Context, in *s3.CopyObjectInput, _ ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	sourceBucket, sourceKey, sourceVersion, err := parseCopySource(aws.ToString(in.CopySource))
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	src, err := c.bucket(aws.String(sourceBucket))
+	if err != nil {
+		return nil, err
+	}
+	dst, err := c.bucket(in.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	from, err := src.lookup(sourceKey, sourceVersion)
+	if err != nil {
+		return nil, err
+	}
+	if in.CopySourceIfMatch != nil && from.etag != aws.ToString(in.CopySourceIfMatch) {
+		return nil, apiError("PreconditionFailed", "At least one of the pre-conditions you specified did not hold")
+	}
+
+	obj := &object{
+		body:        append([]byte(nil), from.body...),
+		contentType: from.contentType,
+		metadata:    copyMap(from.metadata),
+		tags:        from.tags,
+		etag:        from.etag,
+	}
+	if in.MetadataDirective == types.MetadataDirectiveReplace {
+		obj.contentType = aws.ToString(in.ContentType)
+		obj.metadata = copyMap(in.Metadata)
+	}
+	if in.TaggingDirective == types.TaggingDirectiveReplace {
+		obj.tags = parseTags(in.Tagging)
+	}
+	c.put(dst, aws.ToString(in.Key), obj)
+
+	return &s3.CopyObjectOutput{
+		CopyObjectResult: &types.CopyObjectResult{ETag: aws.String(obj.etag), LastModified: aws.Time(obj.lastModified)},
+		VersionId:        versionOutput(dst, obj),
+	}, nil
+}
+
+// deleteObject must be called with c.mu held. Like S3, deleting a missing key
+// succeeds.
+func (c *Client) deleteObject(b *bucket, key string, versionID *string) *types.DeletedObject {
+	deleted := &types.DeletedObject{Key: aws.String(key)}
+
+	switch {
+	case versionID != nil:
+		versions := b.history[key]
+		for i, obj := range versions {
+			if obj.versionID == *versionID {
+				b.history[key] = append(versions[:i:i], versions[i+1:]...)
+				deleted.VersionId = versionID
+				deleted.DeleteMarker = aws.Bool(obj.deleteMarker)
+				break
+			}
+		}
+		if len(b.history[key]) == 0 {
+			delete(b.history, key)
+		}
+	case b.versioned:
+		marker := &object{deleteMarker: true}
+		c.put(b, key, marker)
+		deleted.DeleteMarker = aws.Bool(true)
+		deleted.DeleteMarkerVersionId = aws.String(marker.versionID)
+	default:
+		delete(b.history, key)
+	}
+	return deleted
+}
+
+func (c *Client) DeleteObject(ctx context.Context, in *s3.DeleteObjectInput, _ ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, err := c.bucket(in.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if in.IfMatch != nil {
+		if current := b.current(aws.ToString(in.Key)); current == nil || current.etag != aws.ToString(in.IfMatch) {
+			return nil, apiError("PreconditionFailed", "At least one of the pre-conditions you specified did not hold")
+		}
+	}
+	deleted := c.deleteObject(b, aws.ToString(in.Key), in.VersionId)
+	return &s3.DeleteObjectOutput{DeleteMarker: deleted.DeleteMarker, VersionId: deleted.VersionId}, nil
+}
+
+func (c *Client) DeleteObjects(ctx context.Context, in *s3.DeleteObjectsInput, _ ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, err := c.bucket(in.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if in.Delete == nil || len(in.Delete.Objects) > 1000 {
+		return nil, apiError("MalformedXML", "The XML you provided was not well-formed")
+	}
+
+	out := &s3.DeleteObjectsOutput{}
+	for _, id := range in.Delete.Objects {
+		out.Deleted = append(out.Deleted, *c.deleteObject(b, aws.ToString(id.Key), id.VersionId))
+	}
+	return out, nil
+}
+
+func (c *Client) ListObjectsV2(ctx context.Context, in *s3.ListObjectsV2Input, _ ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, err := c.bucket(in.Bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix, delimiter := aws.ToString(in.Prefix), aws.ToString(in.Delimiter)
+	maxKeys := int(aws.ToInt32(in.MaxKeys))
+	if maxKeys <= 0 {
+		maxKeys = defaultMaxKeys
+	}
+	after := aws.ToString(in.StartAfter)
+	if in.ContinuationToken != nil {
+		after = *in.ContinuationToken
+	}
+
+	out := &s3.ListObjectsV2Output{
+		Name:      in.Bucket,
+		Prefix:    in.Prefix,
+		Delimiter: in.Delimiter,
+		MaxKeys:   aws.Int32(int32(maxKeys)),
+	}
+	seenPrefixes := map[string]bool{}
+	count := 0
+	for _, key := range b.keys(prefix) {
+		if key <= after {
+			continue
+		}
+
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				common := key[:len(prefix)+i+len(delimiter)]
+				if common <= after || seenPrefixes[common] {
+					continue
+				}
+				if count == maxKeys {
+					out.IsTruncated = aws.Bool(true)
+					break
+				}
+				seenPrefixes[common] = true
+				out.CommonPrefixes = append(out.CommonPrefixes, types.CommonPrefix{Prefix: aws.String(common)})
+				out.NextContinuationToken = aws.String(common + "\xff")
+				count++
+				continue
+			}
+		}
+
+		if count == maxKeys {
+			out.IsTruncated = aws.Bool(true)
+			break
+		}
+		obj := b.current(key)
+		out.Contents = append(out.Contents, types.Object{
+			Key:          aws.String(key),
+			Size:         aws.Int64(int64(len(obj.body))),
+			ETag:         aws.String(obj.etag),
+			LastModified: aws.Time(obj.lastModified),
+			StorageClass: types.ObjectStorageClassStandard,
+		})
+		out.NextContinuationToken = aws.String(key)
+		count++
+	}
+
+	out.KeyCount = aws.Int32(int32(count))
+	if !aws.ToBool(out.IsTruncated) {
+		out.IsTruncated = aws.Bool(false)
+		out.NextContinuationToken = nil
+	}
+	return out, nil
+}
+
+func (c *Client) ListObjectVersions(ctx context.Context, in *s3.ListObjectVersionsInput, _ ...func(*s3.Options)) (*s3.ListObjectVersionsOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, err := c.bucket(in.Bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for key := range b.history {
+		if strings.HasPrefix(key, aws.ToString(in.Prefix)) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	out := &s3.ListObjectVersionsOutput{Name: in.Bucket, Prefix: in.Prefix, IsTruncated: aws.Bool(false)}
+	for _, key := range keys {
+		versions := b.history[key]
+		// Newest first, as S3 lists them
+		for i := len(versions) - 1; i >= 0; i-- {
+			obj, latest := versions[i], i == len(versions)-1
+			if obj.deleteMarker {
+				out.DeleteMarkers = append(out.DeleteMarkers, types.DeleteMarkerEntry{
+					Key:          aws.String(key),
+					VersionId:    aws.String(obj.versionID),
+					IsLatest:     aws.Bool(latest),
+					LastModified: aws.Time(obj.lastModified),
+				})
+				continue
+			}
+			out.Versions = append(out.Versions, types.ObjectVersion{
+				Key:          aws.String(key),
+				VersionId:    aws.String(obj.versionID),
+				IsLatest:     aws.Bool(latest),
+				Size:         aws.Int64(int64(len(obj.body))),
+				ETag:         aws.String(obj.etag),
+				LastModified: aws.Time(obj.lastModified),
+			})
+		}
+	}
+	return out, nil
+}
+
+func (c *Client) GetObjectTagging(ctx context.Context, in *s3.GetObjectTaggingInput, _ ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, err := c.bucket(in.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := b.lookup(aws.ToString(in.Key), in.VersionId)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(obj.tags))
+	for name := range obj.tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := &s3.GetObjectTaggingOutput{TagSet: []types.Tag{}}
+	for _, name := range names {
+		out.TagSet = append(out.TagSet, types.Tag{Key: aws.String(name), Value: aws.String(obj.tags[name])})
+	}
+	return out, nil
+}
+
+func (c *Client) GetObjectLegalHold(ctx context.Context, in *s3.GetObjectLegalHoldInput, _ ...func(*s3.Options)) (*s3.GetObjectLegalHoldOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, err := c.bucket(in.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := b.lookup(aws.ToString(in.Key), in.VersionId)
+	if err != nil {
+		return nil, err
+	}
+	if obj.legalHold == "" {
+		return nil, apiError("NoSuchObjectLockConfiguration", "The specified object does not have a ObjectLock configuration")
+	}
+	return &s3.GetObjectLegalHoldOutput{LegalHold: &types.ObjectLockLegalHold{Status: obj.legalHold}}, nil
+}
+
+func (c *Client) PutObjectLegalHold(ctx context.Context, in *s3.PutObjectLegalHoldInput, _ ...func(*s3.Options)) (*s3.PutObjectLegalHoldOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, err := c.bucket(in.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := b.lookup(aws.ToString(in.Key), in.VersionId)
+	if err != nil {
+		return nil, err
+	}
+	if in.LegalHold != nil {
+		obj.legalHold = in.LegalHold.Status
+	}
+	return &s3.PutObjectLegalHoldOutput{}, nil
+}
+
+func (c *Client) GetBucketVersioning(ctx context.Context, in *s3.GetBucketVersioningInput, _ ...func(*s3.Options)) (*s3.GetBucketVersioningOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, err := c.bucket(in.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	out := &s3.GetBucketVersioningOutput{}
+	if b.versioned {
+		out.Status = types.BucketVersioningStatusEnabled
+	}
+	return out, nil
+}
+
+func (c *Client) HeadBucket(ctx context.Context, in *s3.HeadBucketInput, _ ...func(*s3.Options)) (*s3.HeadBucketOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err := c.bucket(in.Bucket); err != nil {
+		return nil, &types.NotFound{Message: aws.String("Not Found")}
+	}
+	return &s3.HeadBucketOutput{}, nil
+}
+
+func (c *Client) CreateMultipartUpload(ctx context.Context, in *s3.CreateMultipartUploadInput, _ ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err := c.bucket(in.Bucket); err != nil {
+		return nil, err
+	}
+	c.nextID++
+	id := fmt.Sprintf("upload-%06d", c.nextID)
+	c.uploads[id] = &upload{
+		bucket: aws.ToString(in.Bucket),
+		input: s3.PutObjectInput{
+			Bucket:      in.Bucket,
+			Key:         in.Key,
+			ContentType: in.ContentType,
+			Metadata:    copyMap(in.Metadata),
+			Tagging:     in.Tagging,
+		},
+		parts: map[int32][]byte{},
+	}
+	return &s3.CreateMultipartUploadOutput{Bucket: in.Bucket, Key: in.Key, UploadId: aws.String(id)}, nil
+}
+
+func (c *Client) UploadPart(ctx context.Context, in *s3.UploadPartInput, _ ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	body, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	up, ok := c.uploads[aws.ToString(in.UploadId)]
+	if !ok {
+		return nil, apiError("NoSuchUpload", "The specified upload does not exist.")
+	}
+	up.parts[aws.ToInt32(in.PartNumber)] = body
+	return &s3.UploadPartOutput{ETag: aws.String(etagOf(body))}, nil
+}
+
+func (c *Client) CompleteMultipartUpload(ctx context.Context, in *s3.CompleteMultipartUploadInput, _ ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	c.mu.Lock()
+	up, ok := c.uploads[aws.ToString(in.UploadId)]
+	if !ok {
+		c.mu.Unlock()
+		return nil, apiError("NoSuchUpload", "The specified upload does not exist.")
+	}
+
+	var body []byte
+	if in.MultipartUpload != nil {
+		for _, part := range in.MultipartUpload.Parts {
+			data, ok := up.parts[aws.ToInt32(part.PartNumber)]
+			if !ok {
+				c.mu.Unlock()
+				return nil, apiError("InvalidPart", "One or more of the specified parts could not be found.")
+			}
+			body = append(body, data...)
+		}
+	}
+	delete(c.uploads, aws.ToString(in.UploadId))
+	c.mu.Unlock()
+
+	input := up.input
+	input.Body = bytes.NewReader(body)
+	out, err := c.PutObject(ctx, &input)
+	if err != nil {
+		return nil, err
+	}
+	return &s3.CompleteMultipartUploadOutput{Bucket: in.Bucket, Key: in.Key, ETag: out.ETag, VersionId: out.VersionId}, nil
+}
+
+func (c *Client) AbortMultipartUpload(ctx context.Context, in *s3.AbortMultipartUploadInput, _ ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.uploads[aws.ToString(in.UploadId)]; !ok {
+		return nil, apiError("NoSuchUpload", "The specified upload does not exist.")
+	}
+	delete(c.uploads, aws.ToString(in.UploadId))
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
@@ -0,0 +1,120 @@
+package fakes3
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+func put(t *testing.T, c *Client, key, body string) {
+	t.Helper()
+	_, err := c.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String("b"),
+		Key:    aws.String(key),
+		Body:   strings.NewReader(body),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func errorCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return ""
+}
+
+func TestListPaginationAndDelimiter(t *testing.T) {
+	c := New("b")
+	for _, key := range []string{"a/1", "a/2", "b/1", "c", "d"} {
+		put(t, c, key, key)
+	}
+
+	var keys, prefixes []string
+	paginator := s3.NewListObjectsV2Paginator(c, &s3.ListObjectsV2Input{
+		Bucket:    aws.String("b"),
+		Delimiter: aws.String("/"),
+		MaxKeys:   aws.Int32(1),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, obj := range page.Contents {
+			keys = append(keys, aws.ToString(obj.Key))
+		}
+		for _, p := range page.CommonPrefixes {
+			prefixes = append(prefixes, aws.ToString(p.Prefix))
+		}
+	}
+
+	if !reflect.DeepEqual(keys, []string{"c", "d"}) || !reflect.DeepEqual(prefixes, []string{"a/", "b/"}) {
+		t.Fatalf("keys %v, prefixes %v", keys, prefixes)
+	}
+}
+
+func TestNotFoundCodes(t *testing.T) {
+	c := New("b")
+	ctx := context.Background()
+
+	_, err := c.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("b"), Key: aws.String("missing")})
+	if code := errorCode(err); code != "NoSuchKey" {
+		t.Fatalf("GetObject: %v", err)
+	}
+	_, err = c.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("b"), Key: aws.String("missing")})
+	if code := errorCode(err); code != "NotFound" {
+		t.Fatalf("HeadObject: %v", err)
+	}
+	_, err = c.ListObjectsV2(ctx, &s3.ListObjectsV2Input{Bucket: aws.String("nope")})
+	if code := errorCode(err); code != "NoSuchBucket" {
+		t.Fatalf("ListObjectsV2: %v", err)
+	}
+}
+
+func TestVersioningAndRanges(t *testing.T) {
+	c := New("b")
+	c.EnableVersioning("b")
+	ctx := context.Background()
+
+	put(t, c, "k", "first")
+	put(t, c, "k", "second version")
+	if _, err := c.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String("b"), Key: aws.String("k")}); err != nil {
+		t.Fatal(err)
+	}
+
+	versions, err := c.ListObjectVersions(ctx, &s3.ListObjectVersionsInput{Bucket: aws.String("b")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions.Versions) != 2 || len(versions.DeleteMarkers) != 1 || !aws.ToBool(versions.DeleteMarkers[0].IsLatest) {
+		t.Fatalf("versions %+v, markers %+v", versions.Versions, versions.DeleteMarkers)
+	}
+
+	_, err = c.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String("b"),
+		Key:        aws.String("k"),
+		CopySource: aws.String("b/k?versionId=" + aws.ToString(versions.Versions[0].VersionId)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("b"), Key: aws.String("k"), Range: aws.String("bytes=-7")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(got.Body)
+	if string(body) != "version" || aws.ToString(got.ContentRange) != "bytes 7-13/14" {
+		t.Fatalf("range read %q (%s)", body, aws.ToString(got.ContentRange))
+	}
+}
@@ -0,0 +1,632 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// Request is a query as clients post it.
+type Request struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+}
+
+// Response is the result of a query. Data is absent when the query couldn't
+// run at all, and null when a non-null field failed at the root.
+type Response struct {
+	Data   any      `json:"data,omitempty"`
+	Errors []*Error `json:"errors,omitempty"`
+}
+
+// Error is a GraphQL error: where in the query it was found and, once the
+// query runs, the path of the field it nulled.
+type Error struct {
+	Message   string     `json:"message"`
+	Locations []Location `json:"locations,omitempty"`
+	Path      []any      `json:"path,omitempty"`
+}
+
+func (e *Error) Error() string { return e.Message }
+
+func errorAt(loc Location, format string, args ...any) *Error {
+	return &Error{Message: fmt.Sprintf(format, args...), Locations: []Location{loc}}
+}
+
+// Execute parses, validates and runs req. Problems with the query itself
+// come back as errors without data; resolver errors null their field and
+// are reported alongside the rest of the data.
+func (s *Schema) Execute(ctx context.Context, req Request) *Response {
+	doc, err := parse(req.Query)
+	if err != nil {
+		return &Response{Errors: []*Error{asError(err)}}
+	}
+	op, errs := s.validate(doc, req.OperationName)
+	if len(errs) > 0 {
+		return &Response{Errors: errs}
+	}
+	vars, errs := s.coerceVariables(op, req.Variables)
+	if len(errs) > 0 {
+		return &Response{Errors: errs}
+	}
+
+	e := &executor{ctx: ctx, schema: s, doc: doc, vars: vars}
+	data, failed := e.executeFields(s.query, nil, [][]selection{op.selection}, nil)
+	response := &Response{Data: data, Errors: e.errors}
+	if failed {
+		response.Data = json.RawMessage("null")
+	}
+	return response
+}
+
+func asError(err error) *Error {
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+	return &Error{Message: err.Error()}
+}
+
+// validate picks the operation to run and checks every field, argument and
+// fragment in it against the schema before anything resolves.
+func (s *Schema) validate(doc *document, operationName string) (*operation, []*Error) {
+	var op *operation
+	switch {
+	case operationName != "":
+		for _, candidate := range doc.operations {
+			if candidate.name == operationName {
+				op = candidate
+			}
+		}
+		if op == nil {
+			return nil, []*Error{{Message: fmt.Sprintf("Unknown operation named %q.", operationName)}}
+		}
+	case len(doc.operations) > 1:
+		return nil, []*Error{{Message: "Must provide operation name if query contains multiple operations."}}
+	default:
+		op = doc.operations[0]
+	}
+	if op.kind != "query" {
+		return nil, []*Error{errorAt(op.loc, "Only queries are supported, not %ss.", op.kind)}
+	}
+
+	v := &validator{schema: s, doc: doc, defined: map[string]typeRef{}, visiting: map[string]bool{}}
+	for _, def := range op.variables {
+		t, err := parseTypeRef(def.typ)
+		if err != nil || !s.isScalar(t.named()) {
+			v.errorf(def.loc, "Variable \"$%s\" must be of a scalar type, not %s.", def.name, def.typ)
+			continue
+		}
+		if _, dup := v.defined[def.name]; dup {
+			v.errorf(def.loc, "There can be only one variable named \"$%s\".", def.name)
+		}
+		v.defined[def.name] = t
+	}
+	v.selectionSet(s.query, op.selection, 1)
+	return op, v.errors
+}
+
+type validator struct {
+	schema   *Schema
+	doc      *document
+	defined  map[string]typeRef
+	visiting map[string]bool
+	errors   []*Error
+}
+
+func (v *validator) errorf(loc Location, format string, args ...any) {
+	v.errors = append(v.errors, errorAt(loc, format, args...))
+}
+
+func (v *validator) selectionSet(obj *Object, set []selection, depth int) {
+	if max := v.schema.maxDepth; max > 0 && depth > max {
+		v.errorf(set[0].loc, "Query is nested deeper than %d levels.", max)
+		return
+	}
+	for _, sel := range set {
+		v.directives(sel.directives)
+		switch {
+		case sel.spread != "":
+			f := v.doc.fragments[sel.spread]
+			switch {
+			case f == nil:
+				v.errorf(sel.loc, "Unknown fragment %q.", sel.spread)
+			case v.visiting[f.name]:
+				v.errorf(sel.loc, "Cannot spread fragment %q within itself.", f.name)
+			case f.on != obj.Name:
+				v.errorf(sel.loc, "Fragment %q cannot be spread here as objects of type %q can never be of type %q.", f.name, obj.Name, f.on)
+			default:
+				v.visiting[f.name] = true
+				v.selectionSet(obj, f.selection, depth)
+				delete(v.visiting, f.name)
+			}
+		case sel.inline:
+			if sel.on != "" && sel.on != obj.Name {
+				v.errorf(sel.loc, "Fragment cannot be spread here as objects of type %q can never be of type %q.", obj.Name, sel.on)
+				continue
+			}
+			v.selectionSet(obj, sel.selection, depth)
+		case sel.name == "__typename":
+			if len(sel.args) > 0 || sel.selection != nil {
+				v.errorf(sel.loc, "Field \"__typename\" takes no arguments or subfields.")
+			}
+		default:
+			v.field(obj, sel, depth)
+		}
+	}
+}
+
+func (v *validator) field(obj *Object, sel selection, depth int) {
+	def := obj.Fields[sel.name]
+	if def == nil {
+		v.errorf(sel.loc, "Cannot query field %q on type %q.", sel.name, obj.Name)
+		return
+	}
+	declared := map[string]Arg{}
+	for _, arg := range def.Args {
+		declared[arg.Name] = arg
+	}
+	given := map[string]bool{}
+	for _, arg := range sel.args {
+		decl, ok := declared[arg.name]
+		if !ok {
+			v.errorf(arg.loc, "Unknown argument %q on field \"%s.%s\".", arg.name, obj.Name, sel.name)
+			continue
+		}
+		if given[arg.name] {
+			v.errorf(arg.loc, "There can be only one argument named %q.", arg.name)
+		}
+		given[arg.name] = true
+		t, _ := parseTypeRef(decl.Type)
+		v.value(t, arg.val)
+	}
+	for _, arg := range def.Args {
+		if t, _ := parseTypeRef(arg.Type); t.nonNull && arg.Default == nil && !given[arg.Name] {
+			v.errorf(sel.loc, "Field \"%s.%s\" argument %q of type %q is required, but it was not provided.", obj.Name, sel.name, arg.Name, arg.Type)
+		}
+	}
+
+	t, _ := parseTypeRef(def.Type)
+	child := v.schema.objects[t.named()]
+	switch {
+	case child != nil && sel.selection == nil:
+		v.errorf(sel.loc, "Field %q of type %q must have a selection of subfields.", sel.name, def.Type)
+	case child == nil && sel.selection != nil:
+		v.errorf(sel.loc, "Field %q must not have a selection since type %q has no subfields.", sel.name, def.Type)
+	case child != nil:
+		v.selectionSet(child, sel.selection, depth+1)
+	}
+}
+
+func (v *validator) directives(directives []directive) {
+	for _, d := range directives {
+		if d.name != "skip" && d.name != "include" {
+			v.errorf(d.loc, "Unknown directive \"@%s\".", d.name)
+			continue
+		}
+		if len(d.args) != 1 || d.args[0].name != "if" {
+			v.errorf(d.loc, "Directive \"@%s\" takes exactly one argument, \"if\".", d.name)
+			continue
+		}
+		v.value(typeRef{name: "Boolean", nonNull: true}, d.args[0].val)
+	}
+}
+
+// value checks a literal fits t; variables are only checked to be defined
+// with a type that can be used there.
+func (v *validator) value(t typeRef, val value) {
+	if val.kind == valueVariable {
+		defined, ok := v.defined[val.raw]
+		if !ok {
+			v.errorf(val.loc, "Variable \"$%s\" is not defined.", val.raw)
+		} else if defined.named() != t.named() && !(t.named() == "Float" && defined.named() == "Int") {
+			v.errorf(val.loc, "Variable \"$%s\" of type %q used in position expecting type %q.", val.raw, defined, t)
+		}
+		return
+	}
+	if _, err := coerceLiteral(v.schema, t, val, nil); err != nil {
+		v.errors = append(v.errors, asError(err))
+	}
+}
+
+// coerceVariables checks the variables posted against the operation's
+// definitions, filling in defaults.
+func (s *Schema) coerceVariables(op *operation, posted map[string]any) (map[string]any, []*Error) {
+	vars := map[string]any{}
+	var errs []*Error
+	for _, def := range op.variables {
+		t, _ := parseTypeRef(def.typ)
+		raw, given := posted[def.name]
+		switch {
+		case !given && def.hasDefault:
+			value, err := coerceLiteral(s, t, def.fallback, nil)
+			if err != nil {
+				errs = append(errs, asError(err))
+			}
+			vars[def.name] = value
+		case !given && t.nonNull:
+			errs = append(errs, errorAt(def.loc, "Variable \"$%s\" of required type %q was not provided.", def.name, def.typ))
+		case given:
+			value, err := coerceJSON(s, t, raw)
+			if err != nil {
+				errs = append(errs, errorAt(def.loc, "Variable \"$%s\" got invalid value: %v", def.name, err))
+			}
+			vars[def.name] = value
+		}
+	}
+	return vars, errs
+}
+
+// coerceLiteral turns a value written in the query into the Go value
+// resolvers see, looking variables up in vars.
+func coerceLiteral(s *Schema, t typeRef, val value, vars map[string]any) (any, error) {
+	if val.kind == valueVariable {
+		return vars[val.raw], nil
+	}
+	if val.kind == valueNull {
+		if t.nonNull {
+			return nil, errorAt(val.loc, "Expected a non-null %s, found null.", t)
+		}
+		return nil, nil
+	}
+	if t.elem != nil {
+		items := val.list
+		if val.kind != valueList {
+			// A single value is a list of one
+			items = []value{val}
+		}
+		list := make([]any, 0, len(items))
+		for _, item := range items {
+			coerced, err := coerceLiteral(s, *t.elem, item, vars)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, coerced)
+		}
+		return list, nil
+	}
+
+	mismatch := func() (any, error) {
+		return nil, errorAt(val.loc, "%s cannot represent the value %s.", t.name, val.raw)
+	}
+	switch t.name {
+	case "Int":
+		if val.kind != valueInt {
+			return mismatch()
+		}
+		n, err := strconv.ParseInt(val.raw, 10, 32)
+		if err != nil {
+			return mismatch()
+		}
+		return int(n), nil
+	case "Float":
+		if val.kind != valueInt && val.kind != valueFloat {
+			return mismatch()
+		}
+		return strconv.ParseFloat(val.raw, 64)
+	case "String":
+		if val.kind != valueString {
+			return mismatch()
+		}
+		return val.raw, nil
+	case "ID":
+		if val.kind != valueString && val.kind != valueInt {
+			return mismatch()
+		}
+		return val.raw, nil
+	case "Boolean":
+		if val.kind != valueBool {
+			return mismatch()
+		}
+		return val.raw == "true", nil
+	}
+	// Custom scalars take what was written
+	switch val.kind {
+	case valueInt:
+		return strconv.ParseInt(val.raw, 10, 64)
+	case valueFloat:
+		return strconv.ParseFloat(val.raw, 64)
+	case valueBool:
+		return val.raw == "true", nil
+	case valueString, valueEnum:
+		return val.raw, nil
+	}
+	return mismatch()
+}
+
+// coerceJSON is coerceLiteral for posted variables, decoded from JSON.
+func coerceJSON(s *Schema, t typeRef, raw any) (any, error) {
+	if raw == nil {
+		if t.nonNull {
+			return nil, fmt.Errorf("expected a non-null %s", t)
+		}
+		return nil, nil
+	}
+	if t.elem != nil {
+		items, ok := raw.([]any)
+		if !ok {
+			items = []any{raw}
+		}
+		list := make([]any, 0, len(items))
+		for _, item := range items {
+			coerced, err := coerceJSON(s, *t.elem, item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, coerced)
+		}
+		return list, nil
+	}
+
+	switch t.name {
+	case "Int":
+		n, ok := raw.(float64)
+		if !ok || n != math.Trunc(n) || n < math.MinInt32 || n > math.MaxInt32 {
+			return nil, fmt.Errorf("Int cannot represent %v", raw)
+		}
+		return int(n), nil
+	case "Float":
+		if n, ok := raw.(float64); ok {
+			return n, nil
+		}
+	case "String":
+		if s, ok := raw.(string); ok {
+			return s, nil
+		}
+	case "ID":
+		switch v := raw.(type) {
+		case string:
+			return v, nil
+		case float64:
+			if v == math.Trunc(v) {
+				return strconv.FormatInt(int64(v), 10), nil
+			}
+		}
+	case "Boolean":
+		if b, ok := raw.(bool); ok {
+			return b, nil
+		}
+	default:
+		if n, ok := raw.(float64); ok && n == math.Trunc(n) && math.Abs(n) < 1<<53 {
+			return int64(n), nil
+		}
+		return raw, nil
+	}
+	return nil, fmt.Errorf("%s cannot represent %v", t.name, raw)
+}
+
+type executor struct {
+	ctx    context.Context
+	schema *Schema
+	doc    *document
+	vars   map[string]any
+	errors []*Error
+}
+
+// collectedField is one response key and every selection that asked for
+// it, as a key selected twice, e.g. through two fragments, merges.
+type collectedField struct {
+	key  string
+	sels []selection
+}
+
+func (e *executor) collect(obj *Object, set []selection, fields []*collectedField, seen map[string]*collectedField) []*collectedField {
+	for _, sel := range set {
+		if !e.included(sel.directives) {
+			continue
+		}
+		switch {
+		case sel.spread != "":
+			fields = e.collect(obj, e.doc.fragments[sel.spread].selection, fields, seen)
+		case sel.inline:
+			fields = e.collect(obj, sel.selection, fields, seen)
+		default:
+			key := sel.alias
+			if key == "" {
+				key = sel.name
+			}
+			if f := seen[key]; f != nil {
+				f.sels = append(f.sels, sel)
+				continue
+			}
+			f := &collectedField{key: key, sels: []selection{sel}}
+			seen[key] = f
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+func (e *executor) included(directives []directive) bool {
+	for _, d := range directives {
+		value, _ := coerceLiteral(e.schema, typeRef{name: "Boolean"}, d.args[0].val, e.vars)
+		on, _ := value.(bool)
+		if d.name == "skip" && on || d.name == "include" && !on {
+			return false
+		}
+	}
+	return true
+}
+
+// executeFields resolves the selected fields of obj in order. It reports
+// failed when a non-null field came out null, which nulls obj too.
+func (e *executor) executeFields(obj *Object, source any, sets [][]selection, path []any) (orderedObject, bool) {
+	var fields []*collectedField
+	seen := map[string]*collectedField{}
+	for _, set := range sets {
+		fields = e.collect(obj, set, fields, seen)
+	}
+
+	result := make(orderedObject, 0, len(fields))
+	for _, f := range fields {
+		sel := f.sels[0]
+		fieldPath := append(append([]any{}, path...), f.key)
+		if sel.name == "__typename" {
+			result = append(result, orderedField{f.key, obj.Name})
+			continue
+		}
+		def := obj.Fields[sel.name]
+		t, _ := parseTypeRef(def.Type)
+
+		value, err := e.resolve(def, source, sel)
+		if err != nil {
+			e.errors = append(e.errors, &Error{Message: err.Error(), Locations: []Location{sel.loc}, Path: fieldPath})
+			if t.nonNull {
+				return nil, true
+			}
+			result = append(result, orderedField{f.key, nil})
+			continue
+		}
+
+		var subsets [][]selection
+		for _, s := range f.sels {
+			subsets = append(subsets, s.selection)
+		}
+		completed, failed := e.complete(t, value, subsets, fieldPath, sel.loc)
+		if failed {
+			return nil, true
+		}
+		result = append(result, orderedField{f.key, completed})
+	}
+	return result, false
+}
+
+func (e *executor) resolve(def *Field, source any, sel selection) (value any, err error) {
+	args := map[string]any{}
+	for _, arg := range def.Args {
+		if arg.Default != nil {
+			args[arg.Name] = arg.Default
+		}
+	}
+	for _, given := range sel.args {
+		for _, arg := range def.Args {
+			if arg.Name != given.name {
+				continue
+			}
+			t, _ := parseTypeRef(arg.Type)
+			if given.val.kind == valueVariable {
+				if _, ok := e.vars[given.val.raw]; !ok {
+					// An unset variable leaves the argument out
+					break
+				}
+			}
+			coerced, err := coerceLiteral(e.schema, t, given.val, e.vars)
+			if err != nil {
+				return nil, err
+			}
+			if coerced == nil && t.nonNull {
+				return nil, fmt.Errorf("argument %q of type %q can't be null", arg.Name, arg.Type)
+			}
+			args[arg.Name] = coerced
+		}
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			value, err = nil, fmt.Errorf("internal error resolving %s: %v", sel.name, r)
+		}
+	}()
+	if def.Resolve == nil {
+		m, _ := source.(map[string]any)
+		return m[sel.name], nil
+	}
+	return def.Resolve(Params{Context: e.ctx, Source: source, Args: args})
+}
+
+// complete shapes a resolved value to its type. failed means a non-null
+// position came out null, which the error was recorded for and which the
+// nearest nullable parent absorbs.
+func (e *executor) complete(t typeRef, value any, sets [][]selection, path []any, loc Location) (any, bool) {
+	if !t.nonNull {
+		completed, failed := e.completeValue(t, value, sets, path, loc)
+		if failed {
+			return nil, false
+		}
+		return completed, false
+	}
+	completed, failed := e.completeValue(t, value, sets, path, loc)
+	if failed {
+		return nil, true
+	}
+	if completed == nil {
+		e.errors = append(e.errors, &Error{Message: fmt.Sprintf("Cannot return null for non-nullable field of type %s.", t), Locations: []Location{loc}, Path: path})
+		return nil, true
+	}
+	return completed, false
+}
+
+func (e *executor) completeValue(t typeRef, value any, sets [][]selection, path []any, loc Location) (any, bool) {
+	// A nil slice is an empty list, so resolvers can return what they
+	// appended to; only a nil interface is a null list
+	if t.elem != nil && value != nil {
+		v := reflect.ValueOf(value)
+		if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+			e.errors = append(e.errors, &Error{Message: fmt.Sprintf("Expected a list for %s, got %T.", t, value), Locations: []Location{loc}, Path: path})
+			return nil, true
+		}
+		list := make([]any, v.Len())
+		for i := range list {
+			item, failed := e.complete(*t.elem, v.Index(i).Interface(), sets, append(append([]any{}, path...), i), loc)
+			if failed {
+				return nil, true
+			}
+			list[i] = item
+		}
+		return list, false
+	}
+	if isNil(value) {
+		return nil, false
+	}
+	if obj := e.schema.objects[t.name]; obj != nil {
+		fields, failed := e.executeFields(obj, value, sets, path)
+		if failed {
+			return nil, true
+		}
+		return fields, false
+	}
+	return value, false
+}
+
+func isNil(value any) bool {
+	if value == nil {
+		return true
+	}
+	switch v := reflect.ValueOf(value); v.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+// orderedObject is a result object, which keeps its fields in the order the
+// query selected them.
+type orderedObject []orderedField
+
+type orderedField struct {
+	key   string
+	value any
+}
+
+func (o orderedObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(f.key)
+		buf.Write(key)
+		buf.WriteByte(':')
+		value, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,211 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type book struct {
+	Title  string
+	Pages  int
+	Author *author
+}
+
+type author struct {
+	Name string
+}
+
+func testSchema(t *testing.T) *Schema {
+	t.Helper()
+	books := []book{
+		{"Dune", 412, &author{"Herbert"}},
+		{"Emma", 474, &author{"Austen"}},
+		{"Anonymous", 90, nil},
+	}
+	authorType := &Object{Name: "Author", Fields: map[string]*Field{
+		"name": {Type: "String!", Resolve: func(p Params) (any, error) { return p.Source.(*author).Name, nil }},
+		"books": {Type: "[Book!]!", Resolve: func(p Params) (any, error) {
+			var written []book
+			for _, b := range books {
+				if b.Author == p.Source.(*author) {
+					written = append(written, b)
+				}
+			}
+			return written, nil
+		}},
+	}}
+	bookType := &Object{Name: "Book", Description: "A book", Fields: map[string]*Field{
+		"title": {Type: "String!", Resolve: func(p Params) (any, error) { return p.Source.(book).Title, nil }},
+		"pages": {Type: "Long", Resolve: func(p Params) (any, error) { return p.Source.(book).Pages, nil }},
+		"author": {Type: "Author", Resolve: func(p Params) (any, error) {
+			return p.Source.(book).Author, nil
+		}},
+		"authorName": {Type: "String!", Resolve: func(p Params) (any, error) {
+			if a := p.Source.(book).Author; a != nil {
+				return a.Name, nil
+			}
+			return nil, nil
+		}},
+		"excerpt": {Type: "String", Resolve: func(p Params) (any, error) { return nil, errors.New("excerpts are unavailable") }},
+	}}
+	query := &Object{Name: "Query", Fields: map[string]*Field{
+		"books": {
+			Type: "[Book!]!",
+			Args: []Arg{{Name: "first", Type: "Int", Default: 10}, {Name: "titles", Type: "[String!]"}},
+			Resolve: func(p Params) (any, error) {
+				var matched []book
+				for _, b := range books {
+					titles, _ := p.Args["titles"].([]any)
+					keep := len(titles) == 0
+					for _, title := range titles {
+						keep = keep || title == b.Title
+					}
+					if keep && len(matched) < int(p.Int("first")) {
+						matched = append(matched, b)
+					}
+				}
+				return matched, nil
+			},
+		},
+		"book": {
+			Type: "Book",
+			Args: []Arg{{Name: "title", Type: "String!"}},
+			Resolve: func(p Params) (any, error) {
+				for _, b := range books {
+					if b.Title == p.String("title") {
+						return b, nil
+					}
+				}
+				return nil, nil
+			},
+		},
+		"settings": {Type: "Settings!", Resolve: func(Params) (any, error) {
+			return map[string]any{"theme": "dark"}, nil
+		}},
+	}}
+	settings := &Object{Name: "Settings", Fields: map[string]*Field{"theme": {Type: "String"}}}
+
+	schema, err := NewSchema(query, []*Object{bookType, authorType, settings}, Options{
+		Scalars:  map[string]string{"Long": "A 64-bit integer"},
+		MaxDepth: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+func run(t *testing.T, schema *Schema, req Request) string {
+	t.Helper()
+	out, err := json.Marshal(schema.Execute(context.Background(), req))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestExecute(t *testing.T) {
+	schema := testSchema(t)
+	for _, tc := range []struct {
+		name string
+		req  Request
+		want string
+	}{
+		{"fields in query order", Request{Query: `{ books(first: 2) { pages title } }`},
+			`{"data":{"books":[{"pages":412,"title":"Dune"},{"pages":474,"title":"Emma"}]}}`},
+		{"aliases and typename", Request{Query: `query { dune: book(title: "Dune") { __typename name: title } none: book(title: "x") { title } }`},
+			`{"data":{"dune":{"__typename":"Book","name":"Dune"},"none":null}}`},
+		{"variables and defaults", Request{
+			Query:     `query Pick($titles: [String!], $first: Int = 1) { books(titles: $titles, first: $first) { title } }`,
+			Variables: map[string]any{"titles": []any{"Emma", "Dune"}},
+		}, `{"data":{"books":[{"title":"Dune"}]}}`},
+		{"a single value is a list of one", Request{Query: `{ books(titles: "Emma") { title } }`},
+			`{"data":{"books":[{"title":"Emma"}]}}`},
+		{"fragments merge", Request{Query: `
+			{ book(title: "Emma") { ...Names ... on Book { author { name } } author { __typename } } }
+			fragment Names on Book { title author { name } }`},
+			`{"data":{"book":{"title":"Emma","author":{"name":"Austen","__typename":"Author"}}}}`},
+		{"directives", Request{
+			Query:     `query($full: Boolean!) { book(title: "Dune") { title pages @include(if: $full) author @skip(if: true) { name } } }`,
+			Variables: map[string]any{"full": false},
+		}, `{"data":{"book":{"title":"Dune"}}}`},
+		{"a nil slice is an empty list", Request{Query: `{ books(titles: "Ulysses") { title } }`}, `{"data":{"books":[]}}`},
+		{"default resolver", Request{Query: `{ settings { theme } }`}, `{"data":{"settings":{"theme":"dark"}}}`},
+		{"resolver errors null their field", Request{Query: `{ book(title: "Dune") { excerpt } }`},
+			`{"data":{"book":{"excerpt":null}},"errors":[{"message":"excerpts are unavailable","locations":[{"line":1,"column":25}],"path":["book","excerpt"]}]}`},
+		{"null in a non-null field nulls the nearest nullable parent", Request{Query: `{ book(title: "Anonymous") { title authorName } }`},
+			`{"data":{"book":null},"errors":[{"message":"Cannot return null for non-nullable field of type String!.","locations":[{"line":1,"column":36}],"path":["book","authorName"]}]}`},
+	} {
+		if got := run(t, schema, tc.req); got != tc.want {
+			t.Errorf("%s:\n got %s\nwant %s", tc.name, got, tc.want)
+		}
+	}
+
+	// A failure under a non-null list nulls everything up to the root
+	got := run(t, schema, Request{Query: `{ books { authorName } }`})
+	if !strings.HasPrefix(got, `{"data":null,"errors":[`) || !strings.Contains(got, `"path":["books",2,"authorName"]`) {
+		t.Errorf("non-null failure at the root: %s", got)
+	}
+}
+
+func TestInvalidQueries(t *testing.T) {
+	schema := testSchema(t)
+	for query, want := range map[string]string{
+		`{ books { title `:                                           "Syntax error: expected a name, found the end of the query",
+		`{ books { isbn } }`:                                         `Cannot query field "isbn" on type "Book".`,
+		`{ books }`:                                                  `Field "books" of type "[Book!]!" must have a selection of subfields.`,
+		`{ books { title { x } } }`:                                  `Field "title" must not have a selection since type "String!" has no subfields.`,
+		`{ book { title } }`:                                         `argument "title" of type "String!" is required`,
+		`{ books(last: 1) { title } }`:                               `Unknown argument "last" on field "Query.books".`,
+		`{ books(first: "2") { title } }`:                            `Int cannot represent the value 2.`,
+		`{ books(first: 9999999999) { title } }`:                     `Int cannot represent the value 9999999999.`,
+		`query($n: Int) { books(titles: $n) { title } }`:             `Variable "$n" of type "Int" used in position expecting type "[String!]".`,
+		`{ books(first: $n) { title } }`:                             `Variable "$n" is not defined.`,
+		`{ books { ...Missing } }`:                                   `Unknown fragment "Missing".`,
+		`{ books { ...A } } fragment A on Book { ...A }`:             `Cannot spread fragment "A" within itself.`,
+		`{ books { ...A } } fragment A on Author { name }`:           `can never be of type "Author"`,
+		`{ books { title @cached } }`:                                `Unknown directive "@cached".`,
+		`mutation { books { title } }`:                               "Only queries are supported, not mutations.",
+		`query A { books { title } } query B { settings { theme } }`: "Must provide operation name",
+		`{ books { author { name } } book(title: "x") { author { __typename } } }`: "",
+		`{ book(title: "Dune") { author { name } } } # deep enough`:                "",
+		`{ book(title: "Dune") { author { books { title } } } }`:                   "Query is nested deeper than 3 levels.",
+	} {
+		got := schema.Execute(context.Background(), Request{Query: query})
+		if want == "" {
+			if len(got.Errors) > 0 {
+				t.Errorf("%s: %v", query, got.Errors[0])
+			}
+			continue
+		}
+		if len(got.Errors) == 0 || !strings.Contains(got.Errors[0].Message, want) || got.Data != nil {
+			t.Errorf("%s: got %+v, want an error containing %q", query, got, want)
+		}
+	}
+
+	missing := schema.Execute(context.Background(), Request{Query: `query($t: String!) { book(title: $t) { title } }`})
+	if len(missing.Errors) == 0 || !strings.Contains(missing.Errors[0].Message, "was not provided") {
+		t.Errorf("missing required variable: %+v", missing)
+	}
+}
+
+func TestSchemaSDL(t *testing.T) {
+	sdl := testSchema(t).SDL()
+	for _, want := range []string{
+		"\"A 64-bit integer\"\nscalar Long\n",
+		"type Query {\n  book(title: String!): Book\n  books(first: Int = 10, titles: [String!]): [Book!]!\n",
+		"\"A book\"\ntype Book {\n  author: Author\n",
+	} {
+		if !strings.Contains(sdl, want) {
+			t.Errorf("SDL lacks %q:\n%s", want, sdl)
+		}
+	}
+
+	_, err := NewSchema(&Object{Name: "Query", Fields: map[string]*Field{"x": {Type: "Missing"}}}, nil, Options{})
+	if err == nil || !strings.Contains(err.Error(), "undeclared type Missing") {
+		t.Errorf("undeclared type: %v", err)
+	}
+}
@@ -0,0 +1,665 @@
+// Package graphql executes GraphQL queries against a schema of objects whose
+// fields are resolved by Go functions. It covers what read-only APIs need:
+// queries with arguments, variables, aliases, fragments and the @skip and
+// @include directives. Mutations, subscriptions and introspection beyond
+// __typename are not supported; Schema.SDL describes the schema instead.
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Location is where in the query an error was found, counting from 1.
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenPunct
+	tokenName
+	tokenInt
+	tokenFloat
+	tokenString
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	loc   Location
+}
+
+// lexer splits a query into tokens, skipping whitespace, commas and
+// comments, which GraphQL treats alike.
+type lexer struct {
+	src  string
+	pos  int
+	line int
+	col  int
+}
+
+func (l *lexer) errorf(loc Location, format string, args ...any) *Error {
+	return &Error{Message: "Syntax error: " + fmt.Sprintf(format, args...), Locations: []Location{loc}}
+}
+
+func (l *lexer) advance(n int) {
+	for _, r := range l.src[l.pos : l.pos+n] {
+		if r == '\n' {
+			l.line, l.col = l.line+1, 1
+		} else {
+			l.col++
+		}
+	}
+	l.pos += n
+}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		if c == '#' {
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.advance(1)
+			}
+			continue
+		}
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',' || strings.HasPrefix(l.src[l.pos:], "\uFEFF") {
+			_, size := utf8.DecodeRuneInString(l.src[l.pos:])
+			l.advance(size)
+			continue
+		}
+		break
+	}
+	loc := Location{Line: l.line, Column: l.col}
+	if l.pos >= len(l.src) {
+		return token{kind: tokenEOF, loc: loc}, nil
+	}
+
+	rest := l.src[l.pos:]
+	c := rest[0]
+	switch {
+	case strings.HasPrefix(rest, "..."):
+		l.advance(3)
+		return token{kind: tokenPunct, value: "...", loc: loc}, nil
+	case strings.ContainsRune("!$():=@[]{}|&", rune(c)):
+		l.advance(1)
+		return token{kind: tokenPunct, value: string(c), loc: loc}, nil
+	case c == '_' || isLetter(c):
+		n := 1
+		for n < len(rest) && (rest[n] == '_' || isLetter(rest[n]) || isDigit(rest[n])) {
+			n++
+		}
+		l.advance(n)
+		return token{kind: tokenName, value: rest[:n], loc: loc}, nil
+	case c == '-' || isDigit(c):
+		return l.number(rest, loc)
+	case c == '"':
+		return l.string(rest, loc)
+	}
+	r, _ := utf8.DecodeRuneInString(rest)
+	return token{}, l.errorf(loc, "unexpected character %q", r)
+}
+
+func (l *lexer) number(rest string, loc Location) (token, error) {
+	n := 0
+	if rest[n] == '-' {
+		n++
+	}
+	digits := func() int {
+		start := n
+		for n < len(rest) && isDigit(rest[n]) {
+			n++
+		}
+		return n - start
+	}
+	if digits() == 0 {
+		return token{}, l.errorf(loc, "invalid number")
+	}
+	kind := tokenInt
+	if n < len(rest) && rest[n] == '.' {
+		n++
+		kind = tokenFloat
+		if digits() == 0 {
+			return token{}, l.errorf(loc, "invalid number")
+		}
+	}
+	if n < len(rest) && (rest[n] == 'e' || rest[n] == 'E') {
+		n++
+		kind = tokenFloat
+		if n < len(rest) && (rest[n] == '+' || rest[n] == '-') {
+			n++
+		}
+		if digits() == 0 {
+			return token{}, l.errorf(loc, "invalid number")
+		}
+	}
+	if n < len(rest) && (rest[n] == '_' || isLetter(rest[n]) || rest[n] == '.') {
+		return token{}, l.errorf(loc, "invalid number")
+	}
+	l.advance(n)
+	return token{kind: kind, value: rest[:n], loc: loc}, nil
+}
+
+func (l *lexer) string(rest string, loc Location) (token, error) {
+	if strings.HasPrefix(rest, `"""`) {
+		end := strings.Index(rest[3:], `"""`)
+		if end < 0 {
+			return token{}, l.errorf(loc, "unterminated string")
+		}
+		l.advance(end + 6)
+		return token{kind: tokenString, value: blockString(rest[3 : 3+end]), loc: loc}, nil
+	}
+	var b strings.Builder
+	for n := 1; n < len(rest); n++ {
+		switch c := rest[n]; c {
+		case '"':
+			l.advance(n + 1)
+			return token{kind: tokenString, value: b.String(), loc: loc}, nil
+		case '\n', '\r':
+			return token{}, l.errorf(loc, "unterminated string")
+		case '\\':
+			if n+1 >= len(rest) {
+				return token{}, l.errorf(loc, "unterminated string")
+			}
+			n++
+			switch rest[n] {
+			case '"', '\\', '/':
+				b.WriteByte(rest[n])
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if n+4 >= len(rest) {
+					return token{}, l.errorf(loc, "invalid unicode escape")
+				}
+				code, err := strconv.ParseUint(rest[n+1:n+5], 16, 32)
+				if err != nil {
+					return token{}, l.errorf(loc, "invalid unicode escape")
+				}
+				b.WriteRune(rune(code))
+				n += 4
+			default:
+				return token{}, l.errorf(loc, "invalid escape \\%c", rest[n])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return token{}, l.errorf(loc, "unterminated string")
+}
+
+// blockString removes the indentation common to a block string's lines,
+// and its leading and trailing blank lines.
+func blockString(raw string) string {
+	lines := strings.Split(strings.ReplaceAll(raw, `\"""`, `"""`), "\n")
+	indent := -1
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && (indent < 0 || len(line)-len(trimmed) < indent) {
+			indent = len(line) - len(trimmed)
+		}
+	}
+	for i := 1; i < len(lines) && indent > 0; i++ {
+		if len(lines[i]) >= indent {
+			lines[i] = lines[i][indent:]
+		}
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func isLetter(c byte) bool { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' }
+func isDigit(c byte) bool  { return c >= '0' && c <= '9' }
+
+// The parsed query. Values are kept as written and resolved against the
+// variables when a field runs.
+
+type document struct {
+	operations []*operation
+	fragments  map[string]*fragment
+}
+
+type operation struct {
+	kind      string
+	name      string
+	variables []variableDef
+	selection []selection
+	loc       Location
+}
+
+type variableDef struct {
+	name       string
+	typ        string
+	fallback   value
+	hasDefault bool
+	loc        Location
+}
+
+type fragment struct {
+	name      string
+	on        string
+	selection []selection
+	loc       Location
+}
+
+// selection is a field, a fragment spread or an inline fragment.
+type selection struct {
+	alias      string
+	name       string
+	args       []argument
+	directives []directive
+	selection  []selection
+	spread     string
+	inline     bool
+	on         string
+	loc        Location
+}
+
+type argument struct {
+	name string
+	val  value
+	loc  Location
+}
+
+type directive struct {
+	name string
+	args []argument
+	loc  Location
+}
+
+type valueKind int
+
+const (
+	valueNull valueKind = iota
+	valueVariable
+	valueInt
+	valueFloat
+	valueString
+	valueBool
+	valueEnum
+	valueList
+	valueObject
+)
+
+type value struct {
+	kind   valueKind
+	raw    string
+	list   []value
+	fields []argument
+	loc    Location
+}
+
+type parser struct {
+	lex *lexer
+	tok token
+}
+
+func parse(src string) (*document, error) {
+	p := &parser{lex: &lexer{src: src, line: 1, col: 1}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	doc := &document{fragments: map[string]*fragment{}}
+	for p.tok.kind != tokenEOF {
+		switch {
+		case p.peek("{"):
+			op := &operation{kind: "query", loc: p.tok.loc}
+			var err error
+			if op.selection, err = p.selectionSet(); err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+		case p.tok.kind == tokenName && p.tok.value == "fragment":
+			f, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			if doc.fragments[f.name] != nil {
+				return nil, &Error{Message: fmt.Sprintf("There can be only one fragment named %q.", f.name), Locations: []Location{f.loc}}
+			}
+			doc.fragments[f.name] = f
+		case p.tok.kind == tokenName:
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	if len(doc.operations) == 0 {
+		return nil, &Error{Message: "The document has no operation."}
+	}
+	return doc, nil
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) peek(punct string) bool {
+	return p.tok.kind == tokenPunct && p.tok.value == punct
+}
+
+func (p *parser) unexpected() error {
+	if p.tok.kind == tokenEOF {
+		return p.lex.errorf(p.tok.loc, "unexpected end of query")
+	}
+	return p.lex.errorf(p.tok.loc, "unexpected %q", p.tok.value)
+}
+
+func (p *parser) expect(punct string) error {
+	if !p.peek(punct) {
+		if p.tok.kind == tokenEOF {
+			return p.lex.errorf(p.tok.loc, "expected %q, found the end of the query", punct)
+		}
+		return p.lex.errorf(p.tok.loc, "expected %q, found %q", punct, p.tok.value)
+	}
+	return p.advance()
+}
+
+func (p *parser) name() (string, error) {
+	if p.tok.kind != tokenName {
+		if p.tok.kind == tokenEOF {
+			return "", p.lex.errorf(p.tok.loc, "expected a name, found the end of the query")
+		}
+		return "", p.lex.errorf(p.tok.loc, "expected a name, found %q", p.tok.value)
+	}
+	name := p.tok.value
+	return name, p.advance()
+}
+
+func (p *parser) operation() (*operation, error) {
+	op := &operation{loc: p.tok.loc}
+	kind, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case "query", "mutation", "subscription":
+		op.kind = kind
+	default:
+		return nil, p.lex.errorf(op.loc, "unexpected %q", kind)
+	}
+	if p.tok.kind == tokenName {
+		op.name, _ = p.name()
+	}
+	if p.peek("(") {
+		p.advance()
+		for !p.peek(")") {
+			def := variableDef{loc: p.tok.loc}
+			if err := p.expect("$"); err != nil {
+				return nil, err
+			}
+			if def.name, err = p.name(); err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			if def.typ, err = p.typeRef(); err != nil {
+				return nil, err
+			}
+			if p.peek("=") {
+				p.advance()
+				if def.fallback, err = p.value(true); err != nil {
+					return nil, err
+				}
+				def.hasDefault = true
+			}
+			op.variables = append(op.variables, def)
+		}
+		p.advance()
+	}
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+	op.selection, err = p.selectionSet()
+	return op, err
+}
+
+func (p *parser) fragment() (*fragment, error) {
+	f := &fragment{loc: p.tok.loc}
+	p.advance()
+	var err error
+	if f.name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if f.name == "on" {
+		return nil, p.lex.errorf(f.loc, "a fragment can't be named \"on\"")
+	}
+	if on, err := p.name(); err != nil || on != "on" {
+		return nil, p.lex.errorf(f.loc, "expected \"on\" and a type condition")
+	}
+	if f.on, err = p.name(); err != nil {
+		return nil, err
+	}
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+	f.selection, err = p.selectionSet()
+	return f, err
+}
+
+// typeRef reads a type like [String!]! back into its written form.
+func (p *parser) typeRef() (string, error) {
+	var typ string
+	if p.peek("[") {
+		p.advance()
+		inner, err := p.typeRef()
+		if err != nil {
+			return "", err
+		}
+		if err := p.expect("]"); err != nil {
+			return "", err
+		}
+		typ = "[" + inner + "]"
+	} else {
+		name, err := p.name()
+		if err != nil {
+			return "", err
+		}
+		typ = name
+	}
+	if p.peek("!") {
+		p.advance()
+		typ += "!"
+	}
+	return typ, nil
+}
+
+func (p *parser) selectionSet() ([]selection, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var selections []selection
+	for !p.peek("}") {
+		sel, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, sel)
+	}
+	if len(selections) == 0 {
+		return nil, p.lex.errorf(p.tok.loc, "a selection set can't be empty")
+	}
+	return selections, p.advance()
+}
+
+func (p *parser) selection() (selection, error) {
+	sel := selection{loc: p.tok.loc}
+	var err error
+	if p.peek("...") {
+		p.advance()
+		if p.tok.kind == tokenName && p.tok.value != "on" {
+			sel.spread, _ = p.name()
+			sel.directives, err = p.directives()
+			return sel, err
+		}
+		sel.inline = true
+		if p.tok.kind == tokenName {
+			p.advance()
+			if sel.on, err = p.name(); err != nil {
+				return sel, err
+			}
+		}
+		if sel.directives, err = p.directives(); err != nil {
+			return sel, err
+		}
+		sel.selection, err = p.selectionSet()
+		return sel, err
+	}
+
+	if sel.name, err = p.name(); err != nil {
+		return sel, err
+	}
+	if p.peek(":") {
+		p.advance()
+		sel.alias = sel.name
+		if sel.name, err = p.name(); err != nil {
+			return sel, err
+		}
+	}
+	if sel.args, err = p.arguments(false); err != nil {
+		return sel, err
+	}
+	if sel.directives, err = p.directives(); err != nil {
+		return sel, err
+	}
+	if p.peek("{") {
+		sel.selection, err = p.selectionSet()
+	}
+	return sel, err
+}
+
+func (p *parser) arguments(constant bool) ([]argument, error) {
+	if !p.peek("(") {
+		return nil, nil
+	}
+	p.advance()
+	var args []argument
+	for !p.peek(")") {
+		arg := argument{loc: p.tok.loc}
+		var err error
+		if arg.name, err = p.name(); err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		if arg.val, err = p.value(constant); err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	if len(args) == 0 {
+		return nil, p.lex.errorf(p.tok.loc, "an argument list can't be empty")
+	}
+	return args, p.advance()
+}
+
+func (p *parser) directives() ([]directive, error) {
+	var directives []directive
+	for p.peek("@") {
+		d := directive{loc: p.tok.loc}
+		p.advance()
+		var err error
+		if d.name, err = p.name(); err != nil {
+			return nil, err
+		}
+		if d.args, err = p.arguments(false); err != nil {
+			return nil, err
+		}
+		directives = append(directives, d)
+	}
+	return directives, nil
+}
+
+// value reads a literal; constant ones, such as variable defaults, can't
+// refer to variables.
+func (p *parser) value(constant bool) (value, error) {
+	v := value{loc: p.tok.loc, raw: p.tok.value}
+	switch p.tok.kind {
+	case tokenInt:
+		v.kind = valueInt
+	case tokenFloat:
+		v.kind = valueFloat
+	case tokenString:
+		v.kind = valueString
+	case tokenName:
+		switch p.tok.value {
+		case "true", "false":
+			v.kind = valueBool
+		case "null":
+			v.kind = valueNull
+		default:
+			v.kind = valueEnum
+		}
+	case tokenPunct:
+		switch p.tok.value {
+		case "$":
+			if constant {
+				return v, p.lex.errorf(v.loc, "variables aren't allowed here")
+			}
+			p.advance()
+			name, err := p.name()
+			return value{kind: valueVariable, raw: name, loc: v.loc}, err
+		case "[":
+			p.advance()
+			v.kind = valueList
+			for !p.peek("]") {
+				item, err := p.value(constant)
+				if err != nil {
+					return v, err
+				}
+				v.list = append(v.list, item)
+			}
+			return v, p.advance()
+		case "{":
+			p.advance()
+			v.kind = valueObject
+			for !p.peek("}") {
+				field := argument{loc: p.tok.loc}
+				var err error
+				if field.name, err = p.name(); err != nil {
+					return v, err
+				}
+				if err := p.expect(":"); err != nil {
+					return v, err
+				}
+				if field.val, err = p.value(constant); err != nil {
+					return v, err
+				}
+				v.fields = append(v.fields, field)
+			}
+			return v, p.advance()
+		}
+		return v, p.unexpected()
+	default:
+		return v, p.unexpected()
+	}
+	return v, p.advance()
+}
@@ -0,0 +1,248 @@
+package graphql
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Object is an output type. Each field's Type is written as in a schema,
+// e.g. "[File!]!", and names a scalar or another object in the schema.
+type Object struct {
+	Name        string
+	Description string
+	Fields      map[string]*Field
+}
+
+// Field resolves one field of an object. Without a Resolve, the source must
+// be a map[string]any and the field is its entry of the same name. Lists
+// resolve to slices, where a nil slice is an empty list, not null.
+type Field struct {
+	Type        string
+	Description string
+	Args        []Arg
+	Resolve     func(p Params) (any, error)
+}
+
+// Arg is a field argument. Its Type may only name scalars, and Default is
+// used when the query leaves it out.
+type Arg struct {
+	Name        string
+	Type        string
+	Default     any
+	Description string
+}
+
+// Params is what a resolver gets: the object it resolves a field of, and
+// the field's arguments coerced to Go values. Int arguments are ints,
+// Float ones float64, and those of other scalars are as written: int64,
+// float64, string or bool.
+type Params struct {
+	Context context.Context
+	Source  any
+	Args    map[string]any
+}
+
+// String returns a string argument, or "" when it is null.
+func (p Params) String(name string) string {
+	s, _ := p.Args[name].(string)
+	return s
+}
+
+// Int returns an integer argument of any integer scalar, or 0 when it is
+// null.
+func (p Params) Int(name string) int64 {
+	switch v := p.Args[name].(type) {
+	case int:
+		return int64(v)
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	}
+	return 0
+}
+
+// Bool returns a Boolean argument, or false when it is null.
+func (p Params) Bool(name string) bool {
+	b, _ := p.Args[name].(bool)
+	return b
+}
+
+// Has reports whether an argument was given a value other than null,
+// explicitly or by default.
+func (p Params) Has(name string) bool {
+	return p.Args[name] != nil
+}
+
+var builtinScalars = map[string]bool{"String": true, "Int": true, "Float": true, "Boolean": true, "ID": true}
+
+// Schema is a query root and the types reachable from it.
+type Schema struct {
+	query    *Object
+	objects  map[string]*Object
+	scalars  map[string]string
+	maxDepth int
+}
+
+// Options adjust a schema. Scalars declares custom scalars, by name, with
+// their descriptions; their values pass through as resolved. MaxDepth
+// refuses queries whose fields nest deeper, so one query can't fan out
+// without bound; 0 allows any depth.
+type Options struct {
+	Scalars  map[string]string
+	MaxDepth int
+}
+
+// NewSchema checks that every type the query root and objects refer to is
+// declared.
+func NewSchema(query *Object, objects []*Object, options Options) (*Schema, error) {
+	s := &Schema{query: query, objects: map[string]*Object{}, scalars: options.Scalars, maxDepth: options.MaxDepth}
+	for _, obj := range append([]*Object{query}, objects...) {
+		if _, dup := s.objects[obj.Name]; dup || builtinScalars[obj.Name] || s.scalars[obj.Name] != "" {
+			return nil, fmt.Errorf("graphql: type %s is declared twice", obj.Name)
+		}
+		s.objects[obj.Name] = obj
+	}
+	for _, obj := range s.objects {
+		for name, field := range obj.Fields {
+			t, err := parseTypeRef(field.Type)
+			if err != nil {
+				return nil, fmt.Errorf("graphql: %s.%s: %w", obj.Name, name, err)
+			}
+			if !s.isScalar(t.named()) && s.objects[t.named()] == nil {
+				return nil, fmt.Errorf("graphql: %s.%s has undeclared type %s", obj.Name, name, t.named())
+			}
+			for _, arg := range field.Args {
+				t, err := parseTypeRef(arg.Type)
+				if err != nil {
+					return nil, fmt.Errorf("graphql: %s.%s(%s): %w", obj.Name, name, arg.Name, err)
+				}
+				if !s.isScalar(t.named()) {
+					return nil, fmt.Errorf("graphql: %s.%s(%s) must be a scalar or a list of them", obj.Name, name, arg.Name)
+				}
+			}
+		}
+	}
+	return s, nil
+}
+
+func (s *Schema) isScalar(name string) bool {
+	return builtinScalars[name] || s.scalars[name] != ""
+}
+
+// SDL describes the schema in the GraphQL schema language, for tools and
+// people writing queries.
+func (s *Schema) SDL() string {
+	var b strings.Builder
+	for _, name := range sortedNames(s.scalars) {
+		writeDescription(&b, "", s.scalars[name])
+		fmt.Fprintf(&b, "scalar %s\n\n", name)
+	}
+
+	names := []string{s.query.Name}
+	for name := range s.objects {
+		if name != s.query.Name {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names[1:])
+	for _, name := range names {
+		obj := s.objects[name]
+		writeDescription(&b, "", obj.Description)
+		fmt.Fprintf(&b, "type %s {\n", name)
+		for _, fieldName := range sortedNames(obj.Fields) {
+			field := obj.Fields[fieldName]
+			writeDescription(&b, "  ", field.Description)
+			b.WriteString("  " + fieldName)
+			if len(field.Args) > 0 {
+				var args []string
+				for _, arg := range field.Args {
+					text := arg.Name + ": " + arg.Type
+					if arg.Default != nil {
+						text += " = " + literal(arg.Default)
+					}
+					args = append(args, text)
+				}
+				b.WriteString("(" + strings.Join(args, ", ") + ")")
+			}
+			b.WriteString(": " + field.Type + "\n")
+		}
+		b.WriteString("}\n\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func writeDescription(b *strings.Builder, indent, description string) {
+	if description != "" {
+		b.WriteString(indent + strconv.Quote(description) + "\n")
+	}
+}
+
+func literal(v any) string {
+	if s, ok := v.(string); ok {
+		return strconv.Quote(s)
+	}
+	return fmt.Sprint(v)
+}
+
+func sortedNames[V any](m map[string]V) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// typeRef is a parsed type such as [String!]!.
+type typeRef struct {
+	name    string
+	elem    *typeRef
+	nonNull bool
+}
+
+func parseTypeRef(s string) (typeRef, error) {
+	var t typeRef
+	if rest, ok := strings.CutSuffix(s, "!"); ok {
+		t.nonNull, s = true, rest
+	}
+	if inner, ok := strings.CutPrefix(s, "["); ok {
+		inner, ok = strings.CutSuffix(inner, "]")
+		if !ok {
+			return t, fmt.Errorf("invalid type %q", s)
+		}
+		elem, err := parseTypeRef(inner)
+		if err != nil {
+			return t, err
+		}
+		t.elem = &elem
+		return t, nil
+	}
+	if s == "" || strings.ContainsAny(s, "[]! ") {
+		return t, fmt.Errorf("invalid type %q", s)
+	}
+	t.name = s
+	return t, nil
+}
+
+// named is the type with its list and non-null wrappers removed.
+func (t typeRef) named() string {
+	for t.elem != nil {
+		t = *t.elem
+	}
+	return t.name
+}
+
+func (t typeRef) String() string {
+	s := t.name
+	if t.elem != nil {
+		s = "[" + t.elem.String() + "]"
+	}
+	if t.nonNull {
+		s += "!"
+	}
+	return s
+}
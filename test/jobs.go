@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	jobPending   = "pending"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+)
+
+// Job tracks a long-running background operation started by an API call.
+type Job struct {
+	ID          string            `json:"id"`
+	Type        string            `json:"type"`
+	Tenant      string            `json:"tenant"`
+	Status      string            `json:"status"`
+	Progress    int64             `json:"progress"`
+	Error       string            `json:"error,omitempty"`
+	Result      map[string]string `json:"result,omitempty"`
+	CreatedAt   string            `json:"created_at"`
+	StartedAt   string            `json:"started_at,omitempty"`
+	CompletedAt string            `json:"completed_at,omitempty"`
+	// How many times the job has been picked up again after the instance
+	// running it stopped
+	Resumed int `json:"resumed,omitempty"`
+
+	mu sync.Mutex
+	// Durable jobs only; see jobstore.go
+	durable    bool
+	ns         namespace
+	params     json.RawMessage
+	checkpoint json.RawMessage
+	etag       string
+	savedAt    time.Time
+	cancel     context.CancelFunc
+	writing    sync.Mutex
+}
+
+// jobFunc does the work of a job, reporting progress through the job it is given.
+type jobFunc func(ctx context.Context, job *Job) (map[string]string, error)
+
+var (
+	jobsMu sync.Mutex
+	jobs   = map[string]*Job{}
+)
+
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// startJob registers a job and runs it in the background. The job belongs to
+// the tenant of ctx's namespace.
+func startJob(ctx context.Context, kind string, run jobFunc) *Job {
+	job := &Job{
+		ID:        newJobID(),
+		Type:      kind,
+		Tenant:    namespaceFrom(ctx).Tenant,
+		Status:    jobPending,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	jobsMu.Lock()
+	jobs[job.ID] = job
+	jobsMu.Unlock()
+
+	go func() {
+		job.mu.Lock()
+		job.Status = jobRunning
+		job.StartedAt = time.Now().UTC().Format(time.RFC3339)
+		job.mu.Unlock()
+
+		result, err := run(ctx, job)
+		job.finish(ctx, result, err)
+	}()
+
+	return job
+}
+
+func (j *Job) finish(ctx context.Context, result map[string]string, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.CompletedAt = time.Now().UTC().Format(time.RFC3339)
+	if err != nil {
+		j.Status = jobFailed
+		j.Error = err.Error()
+		slog.ErrorContext(ctx, "Job failed", "kind", j.Type, "job", j.ID, "err", err)
+		return
+	}
+	j.Status = jobSucceeded
+	j.Result = result
+}
+
+// findJob looks for a job this instance is running, then for a durable
+// job's record. It returns nil if there is neither.
+func findJob(ctx context.Context, id string) (*Job, error) {
+	jobsMu.Lock()
+	job, ok := jobs[id]
+	jobsMu.Unlock()
+	if ok {
+		return job, nil
+	}
+	return loadJob(ctx, id)
+}
+
+func (j *Job) setProgress(n int64) {
+	j.mu.Lock()
+	j.Progress = n
+	j.mu.Unlock()
+}
+
+func (j *Job) addProgress(n int64) {
+	j.mu.Lock()
+	j.Progress += n
+	j.mu.Unlock()
+}
+
+// snapshot returns a copy of the job that is safe to serialize.
+func (j *Job) snapshot() *Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.snapshotLocked()
+}
+
+func (j *Job) snapshotLocked() *Job {
+	return &Job{
+		ID:          j.ID,
+		Type:        j.Type,
+		Tenant:      j.Tenant,
+		Status:      j.Status,
+		Progress:    j.Progress,
+		Error:       j.Error,
+		Result:      j.Result,
+		CreatedAt:   j.CreatedAt,
+		StartedAt:   j.StartedAt,
+		CompletedAt: j.CompletedAt,
+		Resumed:     j.Resumed,
+	}
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Durable jobs are recorded under .jobs/ in the files bucket, with a
+// checkpoint of the work they have done. The instance running one renews
+// its lease on the record; once a lease lapses, because the instance
+// stopped or crashed, any instance picks the job up from its checkpoint.
+const jobPrefix = ".jobs/"
+
+var (
+	jobLeaseTTL           = durationFromEnv("JOB_LEASE_TTL", 2*time.Minute)
+	jobCheckpointInterval = durationFromEnv("JOB_CHECKPOINT_INTERVAL", 10*time.Second)
+	// Finished jobs can still be looked up for this long
+	jobRetention = durationFromEnv("JOB_RETENTION", 7*24*time.Hour)
+
+	// instanceID names this process in the leases it holds
+	instanceID = newJobID()
+
+	// durableJobs run the job kinds that can resume from a checkpoint, by
+	// kind. A job reads what it was started with from job.decodeParams.
+	durableJobs = map[string]jobFunc{}
+)
+
+// Requests that start a durable job may carry this header, so a client
+// retrying after a timeout doesn't start the job twice.
+const idempotencyHeader = "Idempotency-Key"
+
+var errJobLeaseLost = errors.New("job was taken over by another instance")
+
+func registerDurableJob(kind string, run jobFunc) bool {
+	durableJobs[kind] = run
+	return true
+}
+
+// jobRecord is what is stored for a durable job.
+type jobRecord struct {
+	Job        *Job            `json:"job"`
+	Namespace  namespace       `json:"namespace"`
+	Params     json.RawMessage `json:"params,omitempty"`
+	Checkpoint json.RawMessage `json:"checkpoint,omitempty"`
+	Owner      string          `json:"owner,omitempty"`
+	Heartbeat  string          `json:"heartbeat,omitempty"`
+}
+
+func jobRecordKey(id string) string {
+	return jobPrefix + id + ".json"
+}
+
+// idempotentJobID derives a job's id from the Idempotency-Key it was asked
+// for with, so retrying the request finds the job it started.
+func idempotentJobID(tenant, kind, key string) string {
+	sum := sha256.Sum256([]byte(tenant + "\x00" + kind + "\x00" + key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// startDurableJob records a job of a registered kind and runs it. With an
+// idempotency key, a job already started for the same tenant, kind and key
+// is returned instead, and existing reports true.
+func startDurableJob(ctx context.Context, kind string, params any, idempotencyKey string) (job *Job, existing bool, err error) {
+	run, ok := durableJobs[kind]
+	if !ok {
+		return nil, false, fmt.Errorf("%s jobs are not durable", kind)
+	}
+	encoded, err := json.Marshal(params)
+	if err != nil {
+		return nil, false, err
+	}
+	ns := namespaceFrom(ctx)
+	job = &Job{
+		ID:        newJobID(),
+		Type:      kind,
+		Tenant:    ns.Tenant,
+		Status:    jobPending,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		durable:   true,
+		ns:        ns,
+		params:    encoded,
+	}
+	if idempotencyKey != "" {
+		job.ID = idempotentJobID(ns.Tenant, kind, idempotencyKey)
+		if found, err := findJob(ctx, job.ID); err != nil || found != nil {
+			return found, found != nil, err
+		}
+	}
+
+	err = job.save(ctx, true)
+	if isPreconditionFailed(err) && idempotencyKey != "" {
+		found, err := loadJob(ctx, job.ID)
+		return found, found != nil, err
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	jobsMu.Lock()
+	jobs[job.ID] = job
+	jobsMu.Unlock()
+	go runDurableJob(ctx, job, run)
+	return job, false, nil
+}
+
+// runDurableJob runs job while holding its lease, renewing it until the job
+// finishes. A lost lease stops the job here; whoever holds it carries on.
+func runDurableJob(ctx context.Context, job *Job, run jobFunc) {
+	ctx, cancel := context.WithCancelCause(context.WithoutCancel(ctx))
+	defer cancel(nil)
+
+	job.mu.Lock()
+	job.Status = jobRunning
+	if job.StartedAt == "" {
+		job.StartedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	job.cancel = func() { cancel(context.Canceled) }
+	job.mu.Unlock()
+	if err := job.save(ctx, false); err != nil {
+		job.abandon(ctx, err)
+		return
+	}
+
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		ticker := time.NewTicker(jobLeaseTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := job.save(ctx, false); errors.Is(err, errJobLeaseLost) {
+					cancel(err)
+					return
+				} else if err != nil {
+					slog.WarnContext(ctx, "Failed to renew job lease", "job", job.ID, "err", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	result, err := run(ctx, job)
+	cancel(nil)
+	<-renewed
+	if errors.Is(context.Cause(ctx), errJobLeaseLost) || errors.Is(err, errJobLeaseLost) {
+		job.abandon(ctx, errJobLeaseLost)
+		return
+	}
+	job.mu.Lock()
+	suspended := job.cancel == nil
+	job.mu.Unlock()
+	if suspended {
+		// Shutting down; the record was handed back for another instance
+		return
+	}
+
+	job.finish(ctx, result, err)
+	if err := job.save(context.WithoutCancel(ctx), false); err != nil {
+		slog.ErrorContext(ctx, "Failed to record finished job", "job", job.ID, "err", err)
+	}
+}
+
+// abandon forgets a job this instance no longer holds the lease of, so
+// lookups read its record instead.
+func (j *Job) abandon(ctx context.Context, err error) {
+	slog.WarnContext(ctx, "Stopped running job", "job", j.ID, "kind", j.Type, "err", err)
+	jobsMu.Lock()
+	delete(jobs, j.ID)
+	jobsMu.Unlock()
+}
+
+// save writes the job's record, creating it or replacing the version this
+// instance last wrote. Someone else having written it since means the
+// lease was lost.
+func (j *Job) save(ctx context.Context, create bool) error {
+	j.mu.Lock()
+	record := j.recordLocked()
+	if j.Status == jobPending || j.Status == jobRunning {
+		record.Owner = instanceID
+		record.Heartbeat = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	j.mu.Unlock()
+	return j.write(ctx, record, create)
+}
+
+// write stores record as the job's. Writes are serialized, as each is
+// conditional on the one before.
+func (j *Job) write(ctx context.Context, record jobRecord, create bool) error {
+	j.writing.Lock()
+	defer j.writing.Unlock()
+	j.mu.Lock()
+	etag := j.etag
+	j.mu.Unlock()
+
+	body, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(bucketName),
+		Key:         aws.String(jobRecordKey(j.ID)),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	}
+	if create {
+		input.IfNoneMatch = aws.String("*")
+	} else {
+		input.IfMatch = aws.String(etag)
+	}
+	out, err := s3Client.PutObject(ctx, input)
+	if isPreconditionFailed(err) && !create {
+		return errJobLeaseLost
+	}
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	j.etag, j.savedAt = aws.ToString(out.ETag), time.Now()
+	j.mu.Unlock()
+	return nil
+}
+
+// recordLocked must be called with j.mu held.
+func (j *Job) recordLocked() jobRecord {
+	return jobRecord{
+		Job:        j.snapshotLocked(),
+		Namespace:  j.ns,
+		Params:     j.params,
+		Checkpoint: j.checkpoint,
+	}
+}
+
+// decodeParams reads what a durable job was started with into v.
+func (j *Job) decodeParams(v any) error {
+	return json.Unmarshal(j.params, v)
+}
+
+// resumeFrom reads the job's last checkpoint into v, and reports whether
+// there was one.
+func (j *Job) resumeFrom(v any) (bool, error) {
+	j.mu.Lock()
+	checkpoint := j.checkpoint
+	j.mu.Unlock()
+	if len(checkpoint) == 0 {
+		return false, nil
+	}
+	return true, json.Unmarshal(checkpoint, v)
+}
+
+// saveCheckpoint records v as the work done so far. Checkpoints are taken
+// and written at most every jobCheckpointInterval unless force is set, so
+// a resumed job may redo work done since the last one and must tolerate
+// that.
+func (j *Job) saveCheckpoint(ctx context.Context, v any, force bool) error {
+	j.mu.Lock()
+	due := j.durable && (force || time.Since(j.savedAt) >= jobCheckpointInterval)
+	j.mu.Unlock()
+	if !due {
+		return nil
+	}
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	j.checkpoint = encoded
+	j.mu.Unlock()
+	return j.save(ctx, false)
+}
+
+func loadJob(ctx context.Context, id string) (*Job, error) {
+	record, etag, err := readJobRecord(ctx, jobRecordKey(id))
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	job := record.Job
+	job.durable, job.ns, job.params, job.checkpoint, job.etag = true, record.Namespace, record.Params, record.Checkpoint, etag
+	return job, nil
+}
+
+func readJobRecord(ctx context.Context, key string) (*jobRecord, string, error) {
+	result, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	defer result.Body.Close()
+	var record jobRecord
+	if err := json.NewDecoder(result.Body).Decode(&record); err != nil {
+		return nil, "", fmt.Errorf("decoding %s: %w", key, err)
+	}
+	if record.Job == nil {
+		return nil, "", fmt.Errorf("%s has no job", key)
+	}
+	return &record, aws.ToString(result.ETag), nil
+}
+
+// resumeJobs picks up every durable job whose lease has lapsed, and
+// removes records of jobs that finished more than jobRetention ago. It
+// reports how many jobs it resumed.
+func resumeJobs(ctx context.Context) (int, error) {
+	var keys []string
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucketName),
+		Prefix: aws.String(jobPrefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return 0, err
+		}
+		for _, obj := range page.Contents {
+			keys = append(keys, aws.ToString(obj.Key))
+		}
+	}
+
+	resumed := 0
+	for _, key := range keys {
+		id := strings.TrimSuffix(strings.TrimPrefix(key, jobPrefix), ".json")
+		jobsMu.Lock()
+		_, local := jobs[id]
+		jobsMu.Unlock()
+		if local {
+			continue
+		}
+		claimed, err := claimJob(ctx, key)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to resume job", "job", id, "err", err)
+			continue
+		}
+		if claimed {
+			resumed++
+		}
+	}
+	return resumed, nil
+}
+
+// claimJob takes over the job at key if it is unfinished and nobody holds
+// its lease, and reports whether it did.
+func claimJob(ctx context.Context, key string) (bool, error) {
+	record, etag, err := readJobRecord(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	job := record.Job
+	if job.Status == jobSucceeded || job.Status == jobFailed {
+		if completed, err := time.Parse(time.RFC3339, job.CompletedAt); err == nil && time.Since(completed) > jobRetention {
+			_, err := s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(bucketName), Key: aws.String(key)})
+			return false, err
+		}
+		return false, nil
+	}
+	if heartbeat, err := time.Parse(time.RFC3339Nano, record.Heartbeat); err == nil && record.Owner != "" && time.Since(heartbeat) < jobLeaseTTL {
+		return false, nil
+	}
+
+	job.durable, job.ns, job.params, job.checkpoint, job.etag = true, record.Namespace, record.Params, record.Checkpoint, etag
+	run, ok := durableJobs[job.Type]
+	if !ok {
+		job.finish(ctx, nil, fmt.Errorf("interrupted, and %s jobs can't be resumed by this version", job.Type))
+		return false, job.save(ctx, false)
+	}
+	job.Resumed++
+	// Taking the lease is the conditional write of the record as this
+	// instance's; only one instance's write can win
+	if err := job.save(ctx, false); err != nil {
+		if errors.Is(err, errJobLeaseLost) {
+			return false, nil
+		}
+		return false, err
+	}
+	slog.InfoContext(ctx, "Resuming job", "job", job.ID, "kind", job.Type, "resumed", job.Resumed)
+
+	jobsMu.Lock()
+	jobs[job.ID] = job
+	jobsMu.Unlock()
+	go runDurableJob(withNamespace(context.Background(), job.ns), job, run)
+	return true, nil
+}
+
+// startJobResumer resumes lapsed jobs at startup and then every lease
+// period, so a job outlives the instance that started it.
+func startJobResumer(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(jobLeaseTTL)
+		defer ticker.Stop()
+		for {
+			if _, err := resumeJobs(ctx); err != nil {
+				slog.WarnContext(ctx, "Failed to look for jobs to resume", "err", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// suspendJobs stops the durable jobs this instance is running and hands
+// their records back with the last checkpoint, so another instance can
+// resume them straight away rather than after the lease lapses.
+func suspendJobs(ctx context.Context) {
+	jobsMu.Lock()
+	var running []*Job
+	for _, job := range jobs {
+		running = append(running, job)
+	}
+	jobsMu.Unlock()
+
+	for _, job := range running {
+		job.mu.Lock()
+		cancel := job.cancel
+		if !job.durable || cancel == nil || job.Status != jobRunning {
+			job.mu.Unlock()
+			continue
+		}
+		job.cancel = nil
+		record := job.recordLocked()
+		job.mu.Unlock()
+
+		cancel()
+		if err := job.write(ctx, record, false); err != nil {
+			slog.WarnContext(ctx, "Failed to hand back job", "job", job.ID, "err", err)
+			continue
+		}
+		slog.InfoContext(ctx, "Suspended job for another instance to resume", "job", job.ID, "kind", job.Type)
+	}
+}
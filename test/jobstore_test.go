@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// waitExport polls the export until it has finished.
+func waitExport(t *testing.T, srv *httptest.Server, id string) *Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job := &Job{}
+		call(t, srv, "GET", "/api/exports/"+id, nil).decode(t, job)
+		if job.Status == jobSucceeded || job.Status == jobFailed {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("export %s still %s", id, job.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// putJobRecord stores a record as an instance that has since stopped would
+// have left it.
+func putJobRecord(t *testing.T, record jobRecord) {
+	t.Helper()
+	body, _ := json.Marshal(record)
+	if _, err := s3Client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(jobRecordKey(record.Job.ID)),
+		Body:   strings.NewReader(string(body)),
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExportIdempotency(t *testing.T) {
+	srv, fake := newTestServer(t)
+	override(t, &jobs, map[string]*Job{})
+	// A part per row or two
+	override(t, &exportPartSize, 16)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		mustUpload(t, srv, "/api", name, "x")
+	}
+
+	resp := call(t, srv, "POST", "/api/exports", nil, idempotencyHeader, "nightly-2024-05-01")
+	expectStatus(t, resp, http.StatusAccepted)
+	var started Job
+	resp.decode(t, &started)
+
+	job := waitExport(t, srv, started.ID)
+	if job.Status != jobSucceeded || job.Result["objects"] != "3" {
+		t.Fatalf("export %s: %s %v", job.Status, job.Error, job.Result)
+	}
+	report, _, ok := fake.Object(bucketName, job.Result["report"])
+	if !ok {
+		t.Fatal("report missing")
+	}
+	lines := strings.Split(strings.TrimSpace(string(report)), "\n")
+	if len(lines) != 4 || lines[0] != "key,size,last_modified" || !strings.HasPrefix(lines[3], "c.txt,1,") {
+		t.Fatalf("report:\n%s", report)
+	}
+
+	// A retry gets the same job, even once this instance has forgotten it
+	jobsMu.Lock()
+	delete(jobs, started.ID)
+	jobsMu.Unlock()
+	resp = call(t, srv, "POST", "/api/exports", nil, idempotencyHeader, "nightly-2024-05-01")
+	expectStatus(t, resp, http.StatusOK)
+	var retried Job
+	resp.decode(t, &retried)
+	if retried.ID != started.ID || retried.Status != jobSucceeded {
+		t.Fatalf("retry got %s %s", retried.ID, retried.Status)
+	}
+
+	resp = call(t, srv, "POST", "/api/exports", nil, idempotencyHeader, "nightly-2024-05-02")
+	expectStatus(t, resp, http.StatusAccepted)
+	var other Job
+	resp.decode(t, &other)
+	if other.ID == started.ID {
+		t.Fatal("a different key got the same job")
+	}
+	waitExport(t, srv, other.ID)
+}
+
+func TestResumeJobs(t *testing.T) {
+	srv, fake := newTestServer(t)
+	override(t, &jobs, map[string]*Job{})
+	for _, name := range []string{"a.txt", "b.txt"} {
+		mustUpload(t, srv, "/api", name, "x")
+	}
+	ctx := context.Background()
+	ns := rootNamespace()
+	stale := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339Nano)
+	started := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+
+	// An export that got as far as its first part before its instance died
+	reportKey := ns.key(exportPrefix + "interrupted.csv")
+	created, err := s3Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{Bucket: aws.String(ns.Bucket), Key: aws.String(reportKey)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	part, err := s3Client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:     aws.String(ns.Bucket),
+		Key:        aws.String(reportKey),
+		UploadId:   created.UploadId,
+		PartNumber: aws.Int32(1),
+		Body:       strings.NewReader("key,size,last_modified\na.txt,1,before the restart\n"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	params, _ := json.Marshal(exportParams{Source: exportSourceList})
+	checkpoint, _ := json.Marshal(exportCheckpoint{
+		UploadID: aws.ToString(created.UploadId),
+		Parts:    []exportPart{{Number: 1, ETag: aws.ToString(part.ETag)}},
+		Cursor:   ns.key("a.txt"),
+		Objects:  1,
+	})
+	putJobRecord(t, jobRecord{
+		Job:        &Job{ID: "interrupted", Tenant: ns.Tenant, Type: "export", Status: jobRunning, CreatedAt: started, StartedAt: started},
+		Namespace:  ns,
+		Params:     params,
+		Checkpoint: checkpoint,
+		Owner:      "stopped-instance",
+		Heartbeat:  stale,
+	})
+	// One still running elsewhere, one this version doesn't know, and one
+	// that finished long ago
+	putJobRecord(t, jobRecord{
+		Job:       &Job{ID: "elsewhere", Tenant: ns.Tenant, Type: "export", Status: jobRunning, CreatedAt: started},
+		Namespace: ns,
+		Params:    params,
+		Owner:     "other-instance",
+		Heartbeat: time.Now().UTC().Format(time.RFC3339Nano),
+	})
+	putJobRecord(t, jobRecord{
+		Job:       &Job{ID: "unknown", Tenant: ns.Tenant, Type: "reindex", Status: jobRunning, CreatedAt: started},
+		Namespace: ns,
+		Owner:     "stopped-instance",
+		Heartbeat: stale,
+	})
+	longAgo := time.Now().Add(-2 * jobRetention).UTC().Format(time.RFC3339)
+	putJobRecord(t, jobRecord{
+		Job:       &Job{ID: "expired", Tenant: ns.Tenant, Type: "export", Status: jobSucceeded, CreatedAt: longAgo, CompletedAt: longAgo},
+		Namespace: ns,
+	})
+
+	resumed, err := resumeJobs(ctx)
+	if err != nil || resumed != 1 {
+		t.Fatalf("resumed %d: %v", resumed, err)
+	}
+
+	job := waitExport(t, srv, "interrupted")
+	if job.Status != jobSucceeded || job.Resumed != 1 || job.Result["objects"] != "2" {
+		t.Fatalf("resumed export %s: %s %+v", job.Status, job.Error, job)
+	}
+	report, _, _ := fake.Object(ns.Bucket, reportKey)
+	if want := "key,size,last_modified\na.txt,1,before the restart\nb.txt,1,"; !strings.HasPrefix(string(report), want) {
+		t.Fatalf("report:\n%s", report)
+	}
+
+	if job, _ := loadJob(ctx, "elsewhere"); job.Status != jobRunning || job.Resumed != 0 {
+		t.Fatalf("took over a job with a live lease: %+v", job)
+	}
+	if job, _ := loadJob(ctx, "unknown"); job.Status != jobFailed {
+		t.Fatalf("job of an unknown kind %+v", job)
+	}
+	if _, _, ok := fake.Object(bucketName, jobRecordKey("expired")); ok {
+		t.Fatal("expired job record was kept")
+	}
+	// Job records are not files
+	var files FilesResponse
+	call(t, srv, "GET", "/api/files", nil).decode(t, &files)
+	for _, name := range files.Files {
+		if strings.HasPrefix(name, jobPrefix) {
+			t.Fatalf("listed %s", name)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// Bearer JWTs are accepted when JWT_JWKS_URL points at the identity
+	// provider's signing keys. JWT_ISSUER and JWT_AUDIENCE are checked when set.
+	jwtJWKSURL  = settings.Auth.JWTJWKSURL
+	jwtIssuer   = settings.Auth.JWTIssuer
+	jwtAudience = settings.Auth.JWTAudience
+
+	// The claim naming the caller's tenant; defaults to the subject
+	jwtTenantClaim = settings.Auth.JWTTenantClaim
+
+	jwksRefreshInterval = durationFromEnv("JWKS_REFRESH_INTERVAL", time.Hour)
+
+	jwtSigningMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+)
+
+func jwtEnabled() bool {
+	return jwtJWKSURL != ""
+}
+
+// jwk is the subset of RFC 7517 fields needed for RSA and EC signing keys.
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+// keySet caches a JWKS document. Unknown key ids trigger a refetch, at most
+// once a minute, so key rotation at the provider is picked up promptly.
+type keySet struct {
+	url string
+
+	mu          sync.Mutex
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time
+	lastAttempt time.Time
+}
+
+var (
+	jwksMu   sync.Mutex
+	keySets  = map[string]*keySet{}
+	jwksHTTP = &http.Client{Timeout: 10 * time.Second}
+)
+
+// keySetFor returns the shared cache for the JWKS at url.
+func keySetFor(url string) *keySet {
+	jwksMu.Lock()
+	defer jwksMu.Unlock()
+
+	ks, ok := keySets[url]
+	if !ok {
+		ks = &keySet{url: url}
+		keySets[url] = ks
+	}
+	return ks
+}
+
+func (ks *keySet) fetch(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ks.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := jwksHTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %s: %s", ks.url, resp.Status)
+	}
+
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return fmt.Errorf("parsing %s: %w", ks.url, err)
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key, err := k.publicKey(); err == nil {
+			keys[k.Kid] = key
+		}
+	}
+
+	ks.keys, ks.fetchedAt = keys, time.Now()
+	return nil
+}
+
+func (ks *keySet) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	key, ok := ks.keys[kid]
+	stale := time.Since(ks.fetchedAt) > jwksRefreshInterval
+	if (!ok || stale) && time.Since(ks.lastAttempt) > time.Minute {
+		ks.lastAttempt = time.Now()
+		if err := ks.fetch(ctx); err != nil && ks.keys == nil {
+			return nil, err
+		}
+		key, ok = ks.keys[kid]
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+var errMissingClaim = errors.New("token has no tenant claim")
+
+// verifyJWT checks the token's signature against the key set and its
+// registered claims against the configured issuer and audience.
+func verifyJWT(ctx context.Context, ks *keySet, token, issuer, audience string) (jwt.MapClaims, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(jwtSigningMethods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30 * time.Second),
+	}
+	if issuer != "" {
+		opts = append(opts, jwt.WithIssuer(issuer))
+	}
+	if audience != "" {
+		opts = append(opts, jwt.WithAudience(audience))
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return ks.key(ctx, kid)
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// jwtPrincipal verifies a bearer JWT and maps it to the tenant named by the
+// tenant claim.
+func jwtPrincipal(ctx context.Context, token string) (principal, error) {
+	claims, err := verifyJWT(ctx, keySetFor(jwtJWKSURL), token, jwtIssuer, jwtAudience)
+	if err != nil {
+		return principal{}, err
+	}
+	return principalFromClaims(claims, "jwt", jwtTenantClaim)
+}
+
+func principalFromClaims(claims jwt.MapClaims, method, tenantClaim string) (principal, error) {
+	subject, _ := claims.GetSubject()
+	tenant, _ := claims[tenantClaim].(string)
+	if tenant == "" {
+		return principal{}, errMissingClaim
+	}
+	if !validTenantID.MatchString(tenant) {
+		return principal{}, fmt.Errorf("tenant claim %q is not a valid tenant id", tenant)
+	}
+	return principal{Subject: subject, Tenant: tenant, Method: method, Claims: claims}, nil
+}
+
+// looksLikeJWT tells bearer JWTs apart from API keys.
+func looksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// EVENT_PUBLISHER=kafka publishes each event as a record on the topic
+// EVENT_PUBLISH_TARGET names, through the brokers in KAFKA_BROKERS. The
+// client needs github.com/twmb/franz-go, so it is only built in with
+// -tags kafka.
+var (
+	kafkaBrokers  = splitList(os.Getenv("KAFKA_BROKERS"))
+	kafkaClientID = envOr("KAFKA_CLIENT_ID", "test-api")
+
+	// plain, scram-sha-256 or scram-sha-512; none when empty. The password
+	// can come from a secret, and is read again on each new connection.
+	kafkaSASLMechanism = strings.ToLower(strings.TrimSpace(os.Getenv("KAFKA_SASL_MECHANISM")))
+	kafkaSASLUsername  = os.Getenv("KAFKA_SASL_USERNAME")
+	kafkaSASLPassword  = os.Getenv("KAFKA_SASL_PASSWORD")
+
+	// Brokers are dialled over TLS when KAFKA_TLS=true or any of the files
+	// are set. The CA bundle replaces the system roots; the certificate and
+	// key authenticate this client.
+	kafkaTLS, _      = strconv.ParseBool(os.Getenv("KAFKA_TLS"))
+	kafkaTLSCAFile   = os.Getenv("KAFKA_TLS_CA_FILE")
+	kafkaTLSCertFile = os.Getenv("KAFKA_TLS_CERT_FILE")
+	kafkaTLSKeyFile  = os.Getenv("KAFKA_TLS_KEY_FILE")
+)
+
+var kafkaSASLMechanisms = []string{"plain", "scram-sha-256", "scram-sha-512"}
+
+// checkKafkaSettings reports the first problem with the KAFKA_ settings.
+func checkKafkaSettings() error {
+	switch {
+	case len(kafkaBrokers) == 0:
+		return errors.New("EVENT_PUBLISHER=kafka needs KAFKA_BROKERS")
+	case kafkaSASLMechanism != "" && !contains(kafkaSASLMechanisms, kafkaSASLMechanism):
+		return fmt.Errorf("KAFKA_SASL_MECHANISM must be one of %s, not %q", strings.Join(kafkaSASLMechanisms, ", "), kafkaSASLMechanism)
+	case kafkaSASLMechanism != "" && kafkaSASLUsername == "":
+		return fmt.Errorf("KAFKA_SASL_MECHANISM=%s needs KAFKA_SASL_USERNAME", kafkaSASLMechanism)
+	case (kafkaTLSCertFile == "") != (kafkaTLSKeyFile == ""):
+		return errors.New("KAFKA_TLS_CERT_FILE and KAFKA_TLS_KEY_FILE must be set together")
+	}
+	return nil
+}
+
+// kafkaTLSConfig is what brokers are dialled with, or nil for plaintext.
+func kafkaTLSConfig() (*tls.Config, error) {
+	if !kafkaTLS && kafkaTLSCAFile == "" && kafkaTLSCertFile == "" {
+		return nil, nil
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if kafkaTLSCAFile != "" {
+		pool, err := loadCertPool(kafkaTLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("KAFKA_TLS_CA_FILE: %w", err)
+		}
+		config.RootCAs = pool
+	}
+	if kafkaTLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(kafkaTLSCertFile, kafkaTLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("KAFKA_TLS_CERT_FILE: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// kafkaRecordKey keeps a file's events on one partition, and so in order.
+func kafkaRecordKey(e BusEvent) string {
+	return e.Bucket + "/" + e.Name
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/x509"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKafkaSettings(t *testing.T) {
+	override(t, &kafkaBrokers, nil)
+	if err := checkKafkaSettings(); err == nil || !strings.Contains(err.Error(), "KAFKA_BROKERS") {
+		t.Errorf("no brokers: %v", err)
+	}
+	override(t, &kafkaBrokers, []string{"kafka-1:9093", "kafka-2:9093"})
+	override(t, &kafkaSASLMechanism, "gssapi")
+	if err := checkKafkaSettings(); err == nil {
+		t.Error("accepted an unsupported SASL mechanism")
+	}
+	override(t, &kafkaSASLMechanism, "scram-sha-512")
+	override(t, &kafkaSASLUsername, "")
+	if err := checkKafkaSettings(); err == nil || !strings.Contains(err.Error(), "KAFKA_SASL_USERNAME") {
+		t.Errorf("SASL without a username: %v", err)
+	}
+	override(t, &kafkaSASLUsername, "files")
+	override(t, &kafkaTLSCertFile, "client.crt")
+	override(t, &kafkaTLSKeyFile, "")
+	if err := checkKafkaSettings(); err == nil {
+		t.Error("accepted a client certificate without its key")
+	}
+	override(t, &kafkaTLSCertFile, "")
+	if err := checkKafkaSettings(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestKafkaTLSConfig(t *testing.T) {
+	override(t, &kafkaTLS, false)
+	override(t, &kafkaTLSCAFile, "")
+	override(t, &kafkaTLSCertFile, "")
+	override(t, &kafkaTLSKeyFile, "")
+	if config, err := kafkaTLSConfig(); config != nil || err != nil {
+		t.Errorf("plaintext: %v, %v", config, err)
+	}
+
+	dir := t.TempDir()
+	ca := issueCert(t, "kafka CA", nil, x509.ExtKeyUsageAny)
+	client := issueCert(t, "test-api", ca, x509.ExtKeyUsageClientAuth)
+	caFile, certFile, keyFile := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	ca.writePEM(t, caFile, "")
+	client.writePEM(t, certFile, keyFile)
+	// Setting the files is enough to turn TLS on
+	override(t, &kafkaTLSCAFile, caFile)
+	override(t, &kafkaTLSCertFile, certFile)
+	override(t, &kafkaTLSKeyFile, keyFile)
+	config, err := kafkaTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.RootCAs == nil || len(config.Certificates) != 1 {
+		t.Errorf("config %+v", config)
+	}
+
+	override(t, &kafkaTLSCAFile, keyFile)
+	if _, err := kafkaTLSConfig(); err == nil || !strings.Contains(err.Error(), "KAFKA_TLS_CA_FILE") {
+		t.Errorf("CA file without certificates: %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// keyPolicy is what a file or folder name must look like before it is used in
+// a key. Names that break it are rejected rather than repaired, except for
+// Unicode normalization, so a client never ends up with a key it didn't ask
+// for.
+type keyPolicy struct {
+	MaxLength     int
+	Normalization string
+	// Only the characters AWS lists as safe in object keys
+	StrictCharset bool
+}
+
+var (
+	keyStrictCharset, _ = strconv.ParseBool(os.Getenv("KEY_STRICT_CHARSET"))
+
+	keyRules = keyPolicy{
+		MaxLength:     intFromEnv("KEY_MAX_LENGTH", 512),
+		Normalization: strings.ToLower(os.Getenv("KEY_UNICODE_NORMALIZATION")),
+		StrictCharset: keyStrictCharset,
+	}
+)
+
+// keyNormalizers are the Unicode normalization forms this build can apply.
+// NFC needs golang.org/x/text, so it is only built in with -tags nfc.
+var keyNormalizers = map[string]func(string) string{}
+
+func registerKeyNormalizer(form string, normalize func(string) string) bool {
+	keyNormalizers[form] = normalize
+	return true
+}
+
+func init() {
+	if form := keyRules.Normalization; form != "" && form != "none" && keyNormalizers[form] == nil {
+		fatal("KEY_UNICODE_NORMALIZATION is not supported by this build", "form", form)
+	}
+}
+
+var errInvalidName = errors.New("invalid name")
+
+// sanitizeName checks a name as tenants see it, e.g. "reports/q3.txt", and
+// returns it normalized.
+func sanitizeName(name string) (string, error) {
+	invalid := func(format string, args ...interface{}) (string, error) {
+		return "", fmt.Errorf("%w: %s", errInvalidName, fmt.Sprintf(format, args...))
+	}
+
+	if !utf8.ValidString(name) {
+		return invalid("must be valid UTF-8")
+	}
+	if normalize := keyNormalizers[keyRules.Normalization]; normalize != nil {
+		name = normalize(name)
+	}
+
+	switch {
+	case name == "":
+		return invalid("must not be empty")
+	case len(name) > keyRules.MaxLength:
+		return invalid("must be at most %d bytes", keyRules.MaxLength)
+	case strings.HasPrefix(name, "/"):
+		return invalid("must not start with /")
+	case strings.HasSuffix(name, "/"):
+		return invalid("must not end with /")
+	case strings.Contains(name, `\`):
+		return invalid(`must not contain \`)
+	case isReservedKey(name), isReservedKey(name + "/"):
+		return invalid("is in a reserved prefix")
+	}
+	for _, segment := range strings.Split(name, "/") {
+		switch segment {
+		case "":
+			return invalid("must not contain empty path segments")
+		case ".", "..":
+			return invalid("must not contain %q path segments", segment)
+		}
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return invalid("must not contain control characters")
+		}
+		// These can make "exe.txt" display as "txt.exe"
+		if unicode.Is(unicode.Bidi_Control, r) {
+			return invalid("must not contain bidirectional control characters")
+		}
+		if keyRules.StrictCharset && !safeKeyRune(r) {
+			return invalid("must only contain letters, digits, / and !-_.*'()")
+		}
+	}
+	return name, nil
+}
+
+// safeKeyRune reports whether r is one of the characters AWS recommends for
+// object keys.
+func safeKeyRune(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	}
+	return strings.ContainsRune("/!-_.*'()", r)
+}
+
+// sanitizePrefix checks a folder path, which may end with a slash.
+func sanitizePrefix(prefix string) (string, error) {
+	trimmed, isFolder := strings.CutSuffix(prefix, "/")
+	name, err := sanitizeName(trimmed)
+	if err != nil || !isFolder {
+		return name, err
+	}
+	return name + "/", nil
+}
+
+// sanitizeKeys checks the filename and prefix path variables of a file route
+// before its handler sees them, replacing them with their normalized forms.
+func sanitizeKeys(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		if len(vars) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		sanitized := map[string]string{}
+		for name, value := range vars {
+			sanitized[name] = value
+		}
+		for name, sanitize := range map[string]func(string) (string, error){
+			"filename": sanitizeName,
+			"prefix":   sanitizePrefix,
+		} {
+			value, ok := vars[name]
+			if !ok {
+				continue
+			}
+			clean, err := sanitize(value)
+			if err != nil {
+				respondJSON(w, http.StatusBadRequest, ErrorResponse{
+					Error:   "Invalid " + name,
+					Details: err.Error(),
+				})
+				return
+			}
+			sanitized[name] = clean
+		}
+		next.ServeHTTP(w, mux.SetURLVars(r, sanitized))
+	})
+}
+
+// attachmentDisposition is a Content-Disposition header offering name's last
+// segment as the download's filename, quoted or RFC 2231 encoded as needed.
+func attachmentDisposition(name string) string {
+	if disposition := mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(name)}); disposition != "" {
+		return disposition
+	}
+	return "attachment"
+}
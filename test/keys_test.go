@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeName(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"report.pdf", true},
+		{"reports/2024/q3 final (v2).txt", true},
+		{"données/été.csv", true},
+		{"", false},
+		{"/etc/passwd", false},
+		{"reports/", false},
+		{"../secrets", false},
+		{"a/../../b", false},
+		{"a/./b", false},
+		{"a//b", false},
+		{`a\..\b`, false},
+		{"line\nbreak", false},
+		{"tab\there", false},
+		{"nul\x00", false},
+		{"del\x7f", false},
+		{"invoice‮txt.exe", false},
+		{"bad\xffutf8", false},
+		{".acl/report.pdf", false},
+		{strings.Repeat("a", 513), false},
+	}
+	for _, tt := range tests {
+		got, err := sanitizeName(tt.name)
+		if (err == nil) != tt.ok {
+			t.Errorf("sanitizeName(%q) = %q, %v", tt.name, got, err)
+		}
+		if err == nil && got != tt.name {
+			t.Errorf("sanitizeName(%q) changed the name to %q", tt.name, got)
+		}
+	}
+}
+
+func TestStrictKeyCharset(t *testing.T) {
+	override(t, &keyRules, keyPolicy{MaxLength: 64, StrictCharset: true})
+	for name, ok := range map[string]bool{
+		"reports/q3-final_v2.(draft).txt": true,
+		"with space.txt":                  false,
+		"données.csv":                     false,
+		"100%.txt":                        false,
+	} {
+		if _, err := sanitizeName(name); (err == nil) != ok {
+			t.Errorf("sanitizeName(%q): %v", name, err)
+		}
+	}
+}
+
+func TestKeyNormalization(t *testing.T) {
+	override(t, &keyNormalizers, map[string]func(string) string{"upper": strings.ToUpper})
+	override(t, &keyRules, keyPolicy{MaxLength: 64, Normalization: "upper"})
+	if got, err := sanitizeName("a/b.txt"); err != nil || got != "A/B.TXT" {
+		t.Fatalf("normalized to %q, %v", got, err)
+	}
+}
+
+func TestInvalidNamesRejected(t *testing.T) {
+	srv, fake := newTestServer(t)
+	mustUpload(t, srv, "/api", "notes.txt", "hello")
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+	}{
+		{"traversal upload", "POST", "/api/upload", upload("../../etc/passwd", "x")},
+		{"reserved upload", "POST", "/api/upload", upload(".acl/notes.txt", "x")},
+		{"control characters", "POST", "/api/upload", upload("a\r\nb.txt", "x")},
+		{"control character in path", "GET", "/api/files/notes%01.txt", nil},
+		{"backslash in path", "DELETE", "/api/files/..%5Cnotes.txt", nil},
+		{"bidi override in path", "GET", "/api/files/notes%E2%80%AE.txt", nil},
+		{"leading slash folder", "POST", "/api/folders", FolderRequest{Path: "/reports"}},
+		{"reserved folder", "DELETE", "/api/folders/.trash", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expectStatus(t, call(t, srv, tt.method, tt.path, tt.body), http.StatusBadRequest)
+		})
+	}
+
+	if keys := fake.Keys(bucketName); len(keys) != 1 {
+		t.Fatalf("stored %v", keys)
+	}
+}
+
+func TestAttachmentDisposition(t *testing.T) {
+	tests := map[string]string{
+		"reports/q3.txt":   "attachment; filename=q3.txt",
+		`say "hi".txt`:     `attachment; filename="say \"hi\".txt"`,
+		"résumé.pdf":       "attachment; filename*=utf-8''r%C3%A9sum%C3%A9.pdf",
+		"semi;colon.txt":   `attachment; filename="semi;colon.txt"`,
+		"with space.txt":   `attachment; filename="with space.txt"`,
+		"dir/sub/deep.tar": "attachment; filename=deep.tar",
+	}
+	for name, want := range tests {
+		if got := attachmentDisposition(name); got != want {
+			t.Errorf("attachmentDisposition(%q) = %s, want %s", name, got, want)
+		}
+	}
+}
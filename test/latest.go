@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+)
+
+// latestName is the pointer a prefix's newest release is downloaded from,
+// e.g. releases/latest. Pointers are aliases, so they resolve on download
+// and keep their file from being deleted.
+const latestName = "latest"
+
+// LatestRequest moves a prefix's latest pointer. Without a target it points
+// at the file directly under the prefix that was modified last.
+type LatestRequest struct {
+	Target string `json:"target,omitempty"`
+}
+
+// newestFile is the file directly under prefix, which ends in /, modified
+// most recently of those subject can read.
+func newestFile(ctx context.Context, subject, prefix string) (string, error) {
+	ns := namespaceFrom(ctx)
+	modified := map[string]int64{}
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(ns.Bucket),
+		Prefix:    aws.String(ns.key(prefix)),
+		Delimiter: aws.String("/"),
+	})
+	var names []string
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return "", err
+		}
+		for _, obj := range page.Contents {
+			name := ns.name(aws.ToString(obj.Key))
+			if name == prefix+latestName || isReservedKey(name) {
+				continue
+			}
+			names = append(names, name)
+			modified[name] = aws.ToTime(obj.LastModified).UnixNano()
+		}
+	}
+	names, err := readableNames(ctx, subject, names)
+	if err != nil {
+		return "", err
+	}
+
+	var newest string
+	for _, name := range names {
+		// Ties go to the later name, so v1.10 beats v1.9 uploaded together
+		if newest == "" || modified[name] > modified[newest] || modified[name] == modified[newest] && name > newest {
+			newest = name
+		}
+	}
+	return newest, nil
+}
+
+// setLatestHandler points prefix/latest at a file under the prefix. The
+// pointer is written like any alias, so If-Match makes the move atomic.
+func setLatestHandler(w http.ResponseWriter, r *http.Request) {
+	// sanitizeKeys has checked it, and kept any trailing slash
+	prefix := folderPrefix(mux.Vars(r)["prefix"])
+
+	var req LatestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		respondInvalidBody(w, err)
+		return
+	}
+
+	target := req.Target
+	var err error
+	if target == "" {
+		if target, err = newestFile(r.Context(), requestSubject(r), prefix); err != nil {
+			respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+				Error:   "Failed to list files",
+				Details: err.Error(),
+			})
+			return
+		}
+		if target == "" {
+			respondJSON(w, http.StatusNotFound, ErrorResponse{
+				Error:   "No files under prefix",
+				Details: prefix,
+			})
+			return
+		}
+	}
+	if target, err = sanitizeName(target); err != nil || !strings.HasPrefix(target, prefix) {
+		details := "must be under " + prefix
+		if err != nil {
+			details = err.Error()
+		}
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid target",
+			Details: details,
+		})
+		return
+	}
+	writeAlias(w, r, prefix+latestName, target)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLatestPointer(t *testing.T) {
+	srv, fake := newTestServer(t)
+	now := time.Now()
+	override(t, &fake.Now, func() time.Time { return now })
+	mustUpload(t, srv, "/api", "releases/app-1.4.0.tar.gz", "1.4.0")
+	now = now.Add(time.Hour)
+	mustUpload(t, srv, "/api", "releases/app-1.5.0.tar.gz", "1.5.0")
+	now = now.Add(time.Hour)
+	mustUpload(t, srv, "/api", "releases/old/app-0.9.0.tar.gz", "0.9.0")
+	mustUpload(t, srv, "/api", "releases/app-1.3.0.tar.gz", "1.3.0")
+
+	// Without a target the pointer moves to the file modified last directly
+	// under the prefix
+	expectStatus(t, call(t, srv, "PUT", "/api/latest/releases", nil), http.StatusCreated)
+	var pointer Alias
+	got := call(t, srv, "GET", "/api/aliases/releases/latest", nil)
+	got.decode(t, &pointer)
+	if pointer.Alias != "releases/latest" || pointer.Target != "releases/app-1.3.0.tar.gz" {
+		t.Fatalf("pointer %+v", pointer)
+	}
+	if got := call(t, srv, "GET", "/api/files/releases/latest", nil); string(got.body) != "1.3.0" {
+		t.Errorf("latest served %q", got.body)
+	}
+	etag := got.Header.Get("ETag")
+
+	// If-Match moves it only if no one else has
+	got = call(t, srv, "PUT", "/api/latest/releases", LatestRequest{Target: "releases/app-1.4.0.tar.gz"}, "If-Match", etag)
+	expectStatus(t, got, http.StatusOK)
+	expectStatus(t, call(t, srv, "PUT", "/api/latest/releases", LatestRequest{Target: "releases/app-1.5.0.tar.gz"}, "If-Match", etag), http.StatusPreconditionFailed)
+	if got := call(t, srv, "GET", "/api/files/releases/latest", nil); string(got.body) != "1.4.0" {
+		t.Errorf("latest served %q after a rollback", got.body)
+	}
+	expectStatus(t, call(t, srv, "PUT", "/api/aliases/releases/latest", AliasRequest{Target: "releases/app-1.5.0.tar.gz"}, "If-None-Match", "*"), http.StatusPreconditionFailed)
+
+	// The prefix can be given as a folder
+	expectStatus(t, call(t, srv, "PUT", "/api/latest/releases/", LatestRequest{Target: "releases/app-1.5.0.tar.gz"}), http.StatusOK)
+	if got := call(t, srv, "GET", "/api/files/releases/latest", nil); string(got.body) != "1.5.0" {
+		t.Errorf("latest served %q after moving it by folder", got.body)
+	}
+
+	expectStatus(t, call(t, srv, "PUT", "/api/latest/releases", LatestRequest{Target: "docs/readme.md"}), http.StatusBadRequest)
+	expectStatus(t, call(t, srv, "PUT", "/api/latest/empty", nil), http.StatusNotFound)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/xml"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/gorilla/mux"
+)
+
+// Formats the files listing can be rendered in, picked from the Accept header.
+const (
+	listJSON = "json"
+	listXML  = "xml"
+	listHTML = "html"
+)
+
+var listMediaTypes = map[string]string{
+	"application/json": listJSON,
+	"application/xml":  listXML,
+	"text/xml":         listXML,
+	"text/html":        listHTML,
+}
+
+// negotiateListFormat picks the listing format the Accept header prefers,
+// falling back to JSON for */*, no header, or nothing it can serve.
+func negotiateListFormat(accept string) string {
+	best, bestQ := listJSON, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		format, ok := listMediaTypes[strings.ToLower(strings.TrimSpace(mediaType))]
+		if !ok {
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if v, err := strconv.ParseFloat(value, 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	return best
+}
+
+// ListBucketResult mirrors S3's ListObjectsV2 response, for tools that
+// already parse it.
+type ListBucketResult struct {
+	XMLName     xml.Name        `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name        string          `xml:"Name"`
+	Prefix      string          `xml:"Prefix"`
+	KeyCount    int             `xml:"KeyCount"`
+	MaxKeys     int             `xml:"MaxKeys"`
+	IsTruncated bool            `xml:"IsTruncated"`
+	Contents    []ListedXMLFile `xml:"Contents"`
+}
+
+type ListedXMLFile struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+// listingName is what an XML listing calls the bucket: the named bucket
+// being listed, or "files", since the files bucket's own name is deployment
+// detail.
+func listingName(r *http.Request) string {
+	if name := mux.Vars(r)["bucket"]; name != "" {
+		return name
+	}
+	return "files"
+}
+
+func respondListXML(w http.ResponseWriter, r *http.Request, names []string, objects map[string]types.Object, truncated bool) {
+	result := ListBucketResult{
+		Name:        listingName(r),
+		KeyCount:    len(names),
+		MaxKeys:     1000,
+		IsTruncated: truncated,
+	}
+	for _, name := range names {
+		obj := objects[name]
+		result.Contents = append(result.Contents, ListedXMLFile{
+			Key:          name,
+			LastModified: aws.ToTime(obj.LastModified).UTC().Format("2006-01-02T15:04:05.000Z"),
+			ETag:         aws.ToString(obj.ETag),
+			Size:         aws.ToInt64(obj.Size),
+			StorageClass: string(obj.StorageClass),
+		})
+	}
+	body, err := xml.Marshal(result)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to render listing", Details: err.Error()})
+		return
+	}
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
+
+var directoryIndex = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Index of {{.Path}}</title>
+</head>
+<body>
+<h1>Index of {{.Path}}</h1>
+<table>
+<tr><th>Name</th><th>Size</th><th>Last modified</th></tr>
+{{range .Files}}<tr><td><a href="{{.Href}}">{{.Name}}</a></td><td>{{.Size}}</td><td>{{.Modified}}</td></tr>
+{{end}}</table>
+{{if .Truncated}}<p>Only the first {{len .Files}} files are shown.</p>{{end}}
+</body>
+</html>
+`))
+
+type indexEntry struct {
+	Name     string
+	Href     string
+	Size     int64
+	Modified string
+}
+
+func respondListHTML(w http.ResponseWriter, r *http.Request, names []string, objects map[string]types.Object, truncated bool) {
+	page := struct {
+		Path      string
+		Files     []indexEntry
+		Truncated bool
+	}{Path: r.URL.Path, Truncated: truncated}
+	for _, name := range names {
+		obj := objects[name]
+		page.Files = append(page.Files, indexEntry{
+			Name:     name,
+			Href:     strings.TrimSuffix(r.URL.Path, "/") + "/" + (&url.URL{Path: name}).EscapedPath(),
+			Size:     aws.ToInt64(obj.Size),
+			Modified: aws.ToTime(obj.LastModified).UTC().Format(time.RFC3339),
+		})
+	}
+	enableCORS(w)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	directoryIndex.Execute(w, page)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"sync"
+)
+
+var (
+	// LOG_LEVEL is debug, info, warn or error; admins can change it at
+	// runtime through the admin API
+	logLevel = new(slog.LevelVar)
+
+	logger = newLogger(os.Stdout, settings.Log.Level)
+
+	// Request IDs callers send are kept if they look like IDs, so they can't
+	// inject anything into logs or headers
+	validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+)
+
+// newLogger writes JSON lines to w and makes itself the default, so the log
+// package ends up there too.
+func newLogger(w io.Writer, level string) *slog.Logger {
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid LOG_LEVEL %q: must be debug, info, warn or error\n", level)
+		os.Exit(1)
+	}
+	l := slog.New(requestLogHandler{slog.NewJSONHandler(w, &slog.HandlerOptions{Level: logLevel})})
+	slog.SetDefault(l)
+	return l
+}
+
+// fatal logs a configuration error the service can't start with, and exits.
+func fatal(msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
+
+// requestInfo identifies the request an entry was logged for.
+type requestInfo struct {
+	ID     string
+	Method string
+	Path   string
+
+	mu        sync.Mutex
+	key       string
+	principal string
+}
+
+type requestInfoKey struct{}
+
+func requestInfoFrom(ctx context.Context) (*requestInfo, bool) {
+	info, ok := ctx.Value(requestInfoKey{}).(*requestInfo)
+	return info, ok
+}
+
+// setLogKey names the key a request works on, for handlers that only learn
+// it from the body.
+func setLogKey(ctx context.Context, key string) {
+	if info, ok := requestInfoFrom(ctx); ok {
+		info.mu.Lock()
+		info.key = key
+		info.mu.Unlock()
+	}
+}
+
+// setLogPrincipal records who the request was authenticated as, for the
+// access log.
+func setLogPrincipal(ctx context.Context, name string) {
+	if info, ok := requestInfoFrom(ctx); ok {
+		info.mu.Lock()
+		info.principal = name
+		info.mu.Unlock()
+	}
+}
+
+const requestIDHeader = "X-Request-ID"
+
+// requestID is the caller's X-Request-ID when it sent a usable one, or a new
+// random ID.
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); validRequestID.MatchString(id) {
+		return id
+	}
+	return newJobID()
+}
+
+// requestLogHandler adds the request's fields to entries logged with its
+// context.
+type requestLogHandler struct {
+	slog.Handler
+}
+
+func (h requestLogHandler) Handle(ctx context.Context, record slog.Record) error {
+	if info, ok := requestInfoFrom(ctx); ok {
+		record.AddAttrs(
+			slog.String("request_id", info.ID),
+			slog.String("method", info.Method),
+			slog.String("path", info.Path),
+		)
+		info.mu.Lock()
+		key := info.key
+		info.mu.Unlock()
+		if key != "" && !hasAttr(record, "key") {
+			record.AddAttrs(slog.String("key", key))
+		}
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+// hasAttr reports whether the entry already names key itself, in which case
+// that wins over the request's.
+func hasAttr(record slog.Record, key string) bool {
+	found := false
+	record.Attrs(func(a slog.Attr) bool {
+		found = a.Key == key
+		return !found
+	})
+	return found
+}
+
+func (h requestLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestLogHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestLogHandler) WithGroup(name string) slog.Handler {
+	return requestLogHandler{h.Handler.WithGroup(name)}
+}
+
+type LogLevelRequest struct {
+	Level string `json:"level"`
+}
+
+type LogLevelResponse struct {
+	Level string `json:"level"`
+}
+
+func getLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, LogLevelResponse{Level: logLevel.Level().String()})
+}
+
+// setLogLevelHandler changes this instance's level until it restarts.
+func setLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	var req LogLevelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondInvalidBody(w, err)
+		return
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(req.Level)); err != nil {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid log level",
+			Details: "must be debug, info, warn or error",
+		})
+		return
+	}
+	previous := logLevel.Level()
+	logLevel.Set(level)
+	slog.InfoContext(r.Context(), "Log level changed", "from", previous.String(), "to", level.String())
+	respondJSON(w, http.StatusOK, LogLevelResponse{Level: level.String()})
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// entries decodes what has been logged so far.
+func (b *logBuffer) entries(t *testing.T) []map[string]any {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var entries []map[string]any
+	for _, line := range bytes.Split(b.buf.Bytes(), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		entry := map[string]any{}
+		if err := json.Unmarshal(line, &entry); err != nil {
+			t.Fatalf("log line %q: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// captureLogs sends the default logger's entries to a buffer for the test.
+func captureLogs(t *testing.T) *logBuffer {
+	t.Helper()
+	logs := &logBuffer{}
+	previous := slog.Default()
+	level := logLevel.Level()
+	slog.SetDefault(slog.New(requestLogHandler{slog.NewJSONHandler(logs, &slog.HandlerOptions{Level: logLevel})}))
+	t.Cleanup(func() {
+		slog.SetDefault(previous)
+		logLevel.Set(level)
+	})
+	return logs
+}
+
+func requestEntry(t *testing.T, logs *logBuffer, method, path string) map[string]any {
+	t.Helper()
+	for _, entry := range logs.entries(t) {
+		if entry["msg"] == "request" && entry["method"] == method && entry["path"] == path {
+			return entry
+		}
+	}
+	t.Fatalf("no request entry for %s %s", method, path)
+	return nil
+}
+
+func TestRequestLogging(t *testing.T) {
+	logs := captureLogs(t)
+	srv, _ := newTestServer(t)
+
+	expectStatus(t, call(t, srv, "GET", "/api/files/missing.txt", nil, "X-Request-ID", "req-42"), http.StatusNotFound)
+	entry := requestEntry(t, logs, "GET", "/api/files/missing.txt")
+	if entry["request_id"] != "req-42" || entry["key"] != "missing.txt" || entry["status"] != float64(404) || entry["level"] != "INFO" {
+		t.Fatalf("entry %v", entry)
+	}
+	if _, ok := entry["latency_ms"]; !ok {
+		t.Fatalf("entry has no latency: %v", entry)
+	}
+
+	// Uploads name the file in the body, and unusable IDs are replaced
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("notes.txt", "hi"), "X-Request-ID", "<script>"), http.StatusOK)
+	entry = requestEntry(t, logs, "POST", "/api/upload")
+	if entry["key"] != "notes.txt" || entry["request_id"] == "<script>" || entry["request_id"] == "" {
+		t.Fatalf("entry %v", entry)
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	logs := captureLogs(t)
+	override(t, &adminToken, "admin-secret")
+	srv, _ := newTestServer(t)
+	admin := []string{"Authorization", "Bearer admin-secret"}
+
+	var level LogLevelResponse
+	resp := call(t, srv, "PUT", "/api/admin/log-level", LogLevelRequest{Level: "error"}, admin...)
+	expectStatus(t, resp, http.StatusOK)
+	resp.decode(t, &level)
+	if level.Level != "ERROR" {
+		t.Fatalf("level %q", level.Level)
+	}
+
+	// Successful requests are no longer logged
+	before := len(logs.entries(t))
+	expectStatus(t, call(t, srv, "GET", "/api/files", nil), http.StatusOK)
+	if after := len(logs.entries(t)); after != before {
+		t.Fatalf("logged %d entries at error level", after-before)
+	}
+
+	call(t, srv, "GET", "/api/admin/log-level", nil, admin...).decode(t, &level)
+	if level.Level != "ERROR" {
+		t.Fatalf("level %q", level.Level)
+	}
+	expectStatus(t, call(t, srv, "PUT", "/api/admin/log-level", LogLevelRequest{Level: "loud"}, admin...), http.StatusBadRequest)
+	expectStatus(t, call(t, srv, "PUT", "/api/admin/log-level", LogLevelRequest{Level: "debug"}, "Authorization", "Bearer wrong"), http.StatusUnauthorized)
+}
+
+func TestRequestIDs(t *testing.T) {
+	logs := captureLogs(t)
+	srv, _ := newTestServer(t)
+
+	resp := call(t, srv, "GET", "/api/files/missing.txt", nil, "X-Request-ID", "support-7")
+	var problem ErrorResponse
+	resp.decode(t, &problem)
+	if resp.Header.Get("X-Request-ID") != "support-7" || problem.RequestID != "support-7" {
+		t.Fatalf("header %q, body %+v", resp.Header.Get("X-Request-ID"), problem)
+	}
+
+	// Without one, the ID assigned is the one logged
+	resp = call(t, srv, "POST", "/api/upload", "not json")
+	resp.decode(t, &problem)
+	id := resp.Header.Get("X-Request-ID")
+	if id == "" || problem.RequestID != id {
+		t.Fatalf("header %q, body %+v", id, problem)
+	}
+	if entry := requestEntry(t, logs, "POST", "/api/upload"); entry["request_id"] != id {
+		t.Fatalf("logged %v", entry["request_id"])
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	access := &logBuffer{}
+	override(t, &accessLog, &accessLogger{format: "json", w: access})
+	override(t, &apiKeys, map[string]string{"acme-key": "acme"})
+	logs := captureLogs(t)
+	srv, _ := newTestServer(t)
+
+	expectStatus(t, call(t, srv, "GET", "/api/files/missing.txt?code=secret", nil,
+		"X-API-Key", "acme-key", "User-Agent", "curl/8.0", "Referer", "https://example.com/", "X-Request-ID", "req-7"), http.StatusNotFound)
+	entries := access.entries(t)
+	if len(entries) != 1 {
+		t.Fatalf("access log %v", entries)
+	}
+	entry := entries[0]
+	for field, want := range map[string]any{
+		"principal":  "acme",
+		"uri":        "/api/files/missing.txt?code=%5BREDACTED%5D",
+		"status":     float64(404),
+		"user_agent": "curl/8.0",
+		"referer":    "https://example.com/",
+		"request_id": "req-7",
+		"route":      "getFile",
+	} {
+		if entry[field] != want {
+			t.Errorf("%s = %v, want %v", field, entry[field], want)
+		}
+	}
+	// The application log is unchanged
+	if len(logs.entries(t)) != 1 {
+		t.Fatalf("application log %v", logs.entries(t))
+	}
+
+	line := combinedLine(AccessLogEntry{
+		Time: "2026-10-14T09:30:00Z", RemoteAddr: "10.0.0.1", Principal: "acme", Method: "GET",
+		URI: "/api/files/a.txt", Protocol: "HTTP/1.1", Status: 200, Bytes: 5, UserAgent: `evil" agent`,
+	})
+	if want := `10.0.0.1 - acme [14/Oct/2026:09:30:00 +0000] "GET /api/files/a.txt HTTP/1.1" 200 5 "-" "evil\" agent"`; line != want {
+		t.Fatalf("combined line\n%s\nwant\n%s", line, want)
+	}
+}
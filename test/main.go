@@ -1,140 +1,369 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/gorilla/mux"
 )
 
 type UploadRequest struct {
-	Filename string `json:"filename"`
-	Content  string `json:"content"`
+	Filename   string `json:"filename"`
+	Content    string `json:"content"`
+	OnConflict string `json:"on_conflict,omitempty"`
+
+	// Object Lock settings; the bucket must have Object Lock enabled
+	RetentionMode string `json:"retention_mode,omitempty"`
+	RetentionDays int    `json:"retention_days,omitempty"`
+	RetainUntil   string `json:"retain_until,omitempty"`
+	LegalHold     bool   `json:"legal_hold,omitempty"`
+
+	// Optional TTL, as a duration ("72h") or an RFC 3339 timestamp
+	ExpiresIn string `json:"expires_in,omitempty"`
+	ExpiresAt string `json:"expires_at,omitempty"`
+
+	// "public" serves the file from /api/public without credentials
+	Visibility string `json:"visibility,omitempty"`
+
+	// Stored as S3 object tags, e.g. the branch and commit of a build
+	Tags map[string]string `json:"tags,omitempty"`
 }
 
 type HealthResponse struct {
-	Status    string `json:"status"`
-	Timestamp string `json:"timestamp"`
-	Version   string `json:"version"`
-	Bucket    string `json:"bucket"`
+	Status     string                     `json:"status"`
+	Timestamp  string                     `json:"timestamp"`
+	Version    string                     `json:"version"`
+	Bucket     string                     `json:"bucket"`
+	Components map[string]ComponentStatus `json:"components"`
+	// Only with ?deep=true
+	Storage []BucketDiagnosis `json:"storage,omitempty"`
 }
 
 type MessageResponse struct {
-	Message  string `json:"message"`
-	Filename string `json:"filename,omitempty"`
+	Message   string `json:"message"`
+	Filename  string `json:"filename,omitempty"`
+	PublicURL string `json:"public_url,omitempty"`
+	// pending when the upload is held for review before it is stored
+	ReviewStatus string `json:"review_status,omitempty"`
 }
 
 type FilesResponse struct {
 	Files []string `json:"files"`
+	// Each file's scan status, when asked for with ?scan_status=true
+	ScanStatus map[string]string `json:"scan_status,omitempty"`
 }
 
 type ErrorResponse struct {
-	Error   string `json:"error"`
-	Details string `json:"details,omitempty"`
-}
-
-var (
-	s3Client   *s3.Client
-	bucketName string
-)
-
-func init() {
-	// Initialize AWS SDK
-	cfg, err := config.LoadDefaultConfig(context.TODO())
-	if err != nil {
-		log.Fatalf("Failed to load AWS config: %v", err)
-	}
-
-	s3Client = s3.NewFromConfig(cfg)
-
-	// Get bucket name from environment (set by your Nitric platform)
-	bucketName = os.Getenv("FILES_BUCKET_NAME")
-	if bucketName == "" {
-		// Fallback for local development
-		stackId := os.Getenv("NITRIC_STACK_ID")
-		if stackId == "" {
-			stackId = "test-api-dev-local"
-		}
-		bucketName = fmt.Sprintf("%s-files", stackId)
-	}
+	Error            string            `json:"error"`
+	Details          string            `json:"details,omitempty"`
+	ValidationErrors []ValidationError `json:"validation_errors,omitempty"`
+	Retry            *RetryHint        `json:"retry,omitempty"`
+	Limit            *LimitHint        `json:"limit,omitempty"`
+	RequestID        string            `json:"request_id,omitempty"`
 }
 
 func enableCORS(w http.ResponseWriter) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	h := w.Header()
+	h["Access-Control-Allow-Origin"] = currentSetting(&corsOrigin)
+	h["Access-Control-Allow-Methods"] = corsMethods
+	h["Access-Control-Allow-Headers"] = corsHeaders
+	h["Access-Control-Expose-Headers"] = corsExpose
 }
 
 func respondJSON(w http.ResponseWriter, status int, data interface{}) {
 	enableCORS(w)
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(data)
+	w.Header()["Content-Type"] = contentTypeJSON
+	status, data = withRetryHint(w, status, data)
+	if e, ok := data.(ErrorResponse); ok {
+		e.RequestID = w.Header().Get(requestIDHeader)
+		data = e
+	}
+	writeJSON(w, status, data)
 }
 
-func healthHandler(w http.ResponseWriter, r *http.Request) {
+func apiVersion() string {
 	version := os.Getenv("API_VERSION")
 	if version == "" {
 		version = "v1"
 	}
+	return version
+}
+
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	status, components := componentReport(r.Context())
 
 	response := HealthResponse{
-		Status:    "healthy",
-		Timestamp: time.Now().Format(time.RFC3339),
-		Version:   version,
-		Bucket:    bucketName,
+		Status:     status,
+		Timestamp:  time.Now().Format(time.RFC3339),
+		Version:    apiVersion(),
+		Bucket:     bucketName,
+		Components: components,
 	}
 
-	respondJSON(w, http.StatusOK, response)
+	ttl := healthCacheTTL
+	if r.URL.Query().Get("deep") == "true" {
+		response.Storage = deepStorageReport(r.Context())
+		for _, diagnosis := range response.Storage {
+			if !diagnosis.healthy() {
+				response.Status = statusUnhealthy
+			}
+		}
+		ttl = min(ttl, deepHealthCacheTTL)
+	}
+
+	code := http.StatusOK
+	if response.Status == statusUnhealthy {
+		code = http.StatusServiceUnavailable
+	}
+	setCacheControl(w, "public", ttl)
+	respondJSON(w, code, response)
 }
 
-func uploadHandler(w http.ResponseWriter, r *http.Request) {
+// decodeUploadRequest parses an upload body and its base64 content. A non-nil
+// ErrorResponse describes why the body was rejected.
+func decodeUploadRequest(body io.Reader) (UploadRequest, []byte, *ErrorResponse) {
 	var req UploadRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := json.NewDecoder(body).Decode(&req); err != nil {
+		problem := invalidBody(err)
+		return req, nil, &problem
+	}
+
+	if req.Filename == "" || req.Content == "" {
+		return req, nil, &ErrorResponse{
+			Error: "Missing filename or content",
+		}
+	}
+	filename, err := sanitizeName(req.Filename)
+	if err != nil {
+		return req, nil, &ErrorResponse{
+			Error:   "Invalid filename",
+			Details: err.Error(),
+		}
+	}
+	req.Filename = filename
+
+	// Decode base64 content
+	content, err := base64.StdEncoding.DecodeString(req.Content)
+	if err != nil {
+		return req, nil, &ErrorResponse{
+			Error:   "Invalid base64 content",
+			Details: err.Error(),
+		}
+	}
+	return req, content, nil
+}
+
+func uploadHandler(w http.ResponseWriter, r *http.Request) {
+	req, content, problem := decodeUploadRequest(r.Body)
+	if problem != nil {
+		respondJSON(w, problemStatus(*problem), *problem)
+		return
+	}
+
+	// Left for the review when it runs them, for no plugin to see a file twice
+	var err error
+	if !reviewDefers(reviewCheckPlugins) {
+		content, err = runUploadPlugins(r.Context(), req.Filename, content)
+	}
+	if err != nil {
+		var rejected errRejected
+		if errors.As(err, &rejected) {
+			respondJSON(w, http.StatusUnprocessableEntity, ErrorResponse{
+				Error:            "File failed validation",
+				Details:          fmt.Sprintf("rejected by %s plugin", rejected.validator),
+				ValidationErrors: rejected.problems,
+			})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Upload plugin failed",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	if validator, problems := validateUpload(req.Filename, content); len(problems) > 0 {
+		respondJSON(w, http.StatusUnprocessableEntity, ErrorResponse{
+			Error:            "File failed validation",
+			Details:          fmt.Sprintf("rejected by %s validator", validator),
+			ValidationErrors: problems,
+		})
+		return
+	}
+
+	ctx := r.Context()
+	ns := requestNamespace(r)
+	if !checkObjectSize(w, ns.Tenant, int64(len(content)), false) {
+		return
+	}
+	if err := checkQuota(ctx, ns.Tenant, int64(len(content))); err != nil {
+		var exceeded errQuotaExceeded
+		if errors.As(err, &exceeded) {
+			respondJSON(w, http.StatusRequestEntityTooLarge, ErrorResponse{
+				Error:   "Storage quota exceeded",
+				Details: err.Error(),
+				Limit:   &LimitHint{Kind: limitQuota, Bytes: exceeded.limit, Scope: exceeded.scope, Size: exceeded.size},
+			})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to check quota",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	if req.OnConflict == "" {
+		req.OnConflict = r.URL.Query().Get("on_conflict")
+	}
+	if req.OnConflict != "" && !validConflictStrategy(req.OnConflict) {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid on_conflict strategy",
+			Details: "must be one of overwrite, number, timestamp, hash",
+		})
+		return
+	}
+
+	overwrite, err := overwriteAllowed(r)
+	if err != nil {
 		respondJSON(w, http.StatusBadRequest, ErrorResponse{
-			Error:   "Invalid JSON",
+			Error:   "Invalid overwrite",
 			Details: err.Error(),
 		})
 		return
 	}
 
-	if req.Filename == "" || req.Content == "" {
+	// If-Match names the version being replaced, so it always overwrites
+	ifMatch := r.Header.Get("If-Match")
+	strategy := conflictStrategyFor(req.Filename, req.OnConflict)
+	if ifMatch != "" {
+		strategy = conflictOverwrite
+	}
+
+	key, err := resolveUploadKey(ctx, ns.key(req.Filename), strategy, content)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to resolve filename",
+			Details: err.Error(),
+		})
+		return
+	}
+	// The name isn't in the path, and may have been changed to avoid a clash
+	setLogKey(ctx, ns.name(key))
+
+	acl, err := checkAccess(ctx, requestSubject(r), key, permWrite)
+	if err != nil {
+		respondAccessError(w, r, key, permWrite, err)
+		return
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(ns.Bucket),
+		Key:    aws.String(key),
+	}
+	if err := applyObjectLock(req, input); err != nil {
 		respondJSON(w, http.StatusBadRequest, ErrorResponse{
-			Error: "Missing filename or content",
+			Error:   "Invalid object lock settings",
+			Details: err.Error(),
 		})
 		return
 	}
 
-	// Decode base64 content
-	content, err := base64.StdEncoding.DecodeString(req.Content)
+	if err := applyTags(req, input); err != nil {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid tags",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	if err := applyVisibility(req, ns, input); err != nil {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid visibility",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	expiresAt, err := expiryTime(req, time.Now())
 	if err != nil {
 		respondJSON(w, http.StatusBadRequest, ErrorResponse{
-			Error:   "Invalid base64 content",
+			Error:   "Invalid expiry",
+			Details: err.Error(),
+		})
+		return
+	}
+	if !expiresAt.IsZero() {
+		applyExpiry(input, expiresAt)
+	}
+
+	if err := scanUpload(ctx, requestSubject(r), input, content); err != nil {
+		var rejected errRejected
+		if errors.As(err, &rejected) {
+			respondJSON(w, http.StatusUnprocessableEntity, ErrorResponse{
+				Error:            "File is infected",
+				Details:          "rejected by virus scan",
+				ValidationErrors: rejected.problems,
+			})
+			return
+		}
+		respondJSON(w, http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "Virus scan failed",
 			Details: err.Error(),
 		})
 		return
 	}
 
+	if err := beforeUploadHooks(ctx, requestSubject(r), input, int64(len(content))); err != nil {
+		respondHookError(w, "Upload", err)
+		return
+	}
+
+	if ifMatch != "" {
+		current, err := checkIfMatch(ctx, ns.Bucket, key, ifMatch)
+		if err != nil {
+			respondPreconditionFailed(w, current, err)
+			return
+		}
+		input.IfMatch = aws.String(current)
+	}
+	if !overwrite {
+		input.IfNoneMatch = aws.String("*")
+	}
+
+	if quarantineEnabled() {
+		holdForReview(w, r, input, content, expiresAt, acl == nil)
+		return
+	}
+
 	// Upload to S3
-	_, err = s3Client.PutObject(context.TODO(), &s3.PutObjectInput{
-		Bucket: aws.String(bucketName),
-		Key:    aws.String(req.Filename),
-		Body:   bytes.NewReader(content),
-	})
+	result, err := putObject(ctx, input, content)
 
 	if err != nil {
+		if isPreconditionFailed(err) && !overwrite {
+			respondJSON(w, http.StatusConflict, ErrorResponse{
+				Error:   "File already exists",
+				Details: "upload with overwrite=true or If-Match to replace it",
+			})
+			return
+		}
+		if isPreconditionFailed(err) {
+			respondPreconditionFailed(w, "", err)
+			return
+		}
 		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
 			Error:   "Upload failed",
 			Details: err.Error(),
@@ -142,15 +371,41 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	respondJSON(w, http.StatusOK, MessageResponse{
+	recordEvent(ctx, eventUploaded, key, map[string]string{"size": strconv.Itoa(len(content))})
+	usage.add(ns.Tenant, int64(len(content)), 1)
+	warnQuota(ctx, w, ns.Tenant)
+	afterUploadHooks(ctx, requestSubject(r), input, int64(len(content)))
+
+	if aclsEnabled && acl == nil {
+		if err := putACLEntry(ctx, key, roleOwner, requestSubject(r)); err != nil {
+			slog.WarnContext(ctx, "Failed to record owner", "key", key, "err", err)
+		}
+	}
+
+	if !expiresAt.IsZero() {
+		if err := writeExpiryMarker(ctx, key, expiresAt); err != nil {
+			slog.WarnContext(ctx, "Failed to schedule expiry", "key", key, "err", err)
+		}
+	}
+
+	if etag := aws.ToString(result.ETag); etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	response := MessageResponse{
 		Message:  "File uploaded successfully",
-		Filename: req.Filename,
-	})
+		Filename: ns.name(key),
+	}
+	if req.Visibility == visibilityPublic {
+		response.PublicURL = publicURL(key)
+	}
+	respondJSON(w, http.StatusOK, response)
 }
 
 func listFilesHandler(w http.ResponseWriter, r *http.Request) {
-	result, err := s3Client.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{
-		Bucket: aws.String(bucketName),
+	ns := requestNamespace(r)
+	result, err := s3Client.ListObjectsV2(r.Context(), &s3.ListObjectsV2Input{
+		Bucket: aws.String(ns.Bucket),
+		Prefix: aws.String(ns.Prefix),
 	})
 
 	if err != nil {
@@ -162,15 +417,43 @@ func listFilesHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var fileList []string
+	objects := map[string]types.Object{}
 	for _, obj := range result.Contents {
-		if obj.Key != nil {
-			fileList = append(fileList, *obj.Key)
+		if obj.Key != nil && !isReservedKey(ns.name(*obj.Key)) {
+			fileList = append(fileList, ns.name(*obj.Key))
+			objects[ns.name(*obj.Key)] = obj
 		}
 	}
 
-	respondJSON(w, http.StatusOK, FilesResponse{
-		Files: fileList,
-	})
+	fileList, err = readableNames(r.Context(), requestSubject(r), fileList)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to check access",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	w.Header().Set("Vary", "Accept")
+	truncated := aws.ToBool(result.IsTruncated)
+	switch negotiateListFormat(r.Header.Get("Accept")) {
+	case listXML:
+		respondListXML(w, r, fileList, objects, truncated)
+	case listHTML:
+		respondListHTML(w, r, fileList, objects, truncated)
+	default:
+		response := FilesResponse{Files: fileList}
+		if r.URL.Query().Get("scan_status") == "true" {
+			if response.ScanStatus, err = scanStatuses(r.Context(), ns, fileList); err != nil {
+				respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+					Error:   "Failed to read scan status",
+					Details: err.Error(),
+				})
+				return
+			}
+		}
+		respondJSON(w, http.StatusOK, response)
+	}
 }
 
 func getFileHandler(w http.ResponseWriter, r *http.Request) {
@@ -184,14 +467,42 @@ func getFileHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result, err := s3Client.GetObject(context.TODO(), &s3.GetObjectInput{
-		Bucket: aws.String(bucketName),
-		Key:    aws.String(filename),
-	})
+	ns := requestNamespace(r)
+	if !authorizeFile(w, r, ns.key(filename), permRead) {
+		return
+	}
+	if err := runHooks(r.Context(), &hookEvent{Point: hookOnDownload, Subject: requestSubject(r), Filename: filename}); err != nil {
+		respondHookError(w, "Download", err)
+		return
+	}
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(ns.Bucket),
+		Key:    aws.String(ns.key(filename)),
+	}
+	if versionID := r.URL.Query().Get("version_id"); versionID != "" {
+		input.VersionId = aws.String(versionID)
+	} else if r.Header.Get("Range") != "" {
+		head, err := s3Client.HeadObject(r.Context(), &s3.HeadObjectInput{Bucket: input.Bucket, Key: input.Key})
+		if err == nil && serveRange(w, r, ns.Bucket, ns.key(filename), head, func() {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Disposition", attachmentDisposition(filename))
+		}) {
+			return
+		}
+	}
+
+	result, contentEncoding, err := getObjectNegotiated(r.Context(), input, r.Header.Get("Accept-Encoding"))
 
 	if err != nil {
-		respondJSON(w, http.StatusNotFound, ErrorResponse{
-			Error:   "File not found",
+		status, message := http.StatusInternalServerError, "Failed to read file"
+		if isNotFound(err) {
+			if serveAlias(w, r, getFileHandler) {
+				return
+			}
+			status, message = http.StatusNotFound, "File not found"
+		}
+		respondJSON(w, status, ErrorResponse{
+			Error:   message,
 			Details: err.Error(),
 		})
 		return
@@ -210,7 +521,18 @@ func getFileHandler(w http.ResponseWriter, r *http.Request) {
 
 	enableCORS(w)
 	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	w.Header().Set("Content-Disposition", attachmentDisposition(filename))
+	w.Header().Set("Vary", "Accept-Encoding")
+	w.Header().Set("Accept-Ranges", "bytes")
+	if etag := publicETag(aws.ToString(result.ETag), contentEncoding); etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if result.LastModified != nil {
+		w.Header().Set("Last-Modified", result.LastModified.UTC().Format(http.TimeFormat))
+	}
+	if contentEncoding != "" {
+		w.Header().Set("Content-Encoding", contentEncoding)
+	}
 	w.Write(content)
 }
 
@@ -225,12 +547,34 @@ func deleteFileHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err := s3Client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
-		Bucket: aws.String(bucketName),
-		Key:    aws.String(filename),
-	})
+	ctx := r.Context()
+	key := requestNamespace(r).key(filename)
 
+	acl, err := checkAccess(ctx, requestSubject(r), key, permWrite)
 	if err != nil {
+		respondAccessError(w, r, key, permWrite, err)
+		return
+	}
+	if !refuseAliasedDelete(w, r, filename) {
+		return
+	}
+
+	var etag string
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if etag, err = checkIfMatch(ctx, bucketFor(ctx), key, ifMatch); err != nil {
+			respondPreconditionFailed(w, etag, err)
+			return
+		}
+	}
+
+	if err := removeFile(ctx, key, etag, acl); err != nil {
+		if respondHookError(w, "Delete", err) {
+			return
+		}
+		if isPreconditionFailed(err) {
+			respondPreconditionFailed(w, "", err)
+			return
+		}
 		if strings.Contains(err.Error(), "NoSuchKey") {
 			respondJSON(w, http.StatusNotFound, ErrorResponse{
 				Error: "File not found",
@@ -250,36 +594,213 @@ func deleteFileHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// errRejected is a file that failed validation.
+type errRejected struct {
+	validator string
+	problems  []ValidationError
+}
+
+func (e errRejected) Error() string {
+	return fmt.Sprintf("rejected by %s validator: %s", e.validator, e.problems[0].Message)
+}
+
+// storeFile validates content and stores it under input.Key for subject,
+// as a plain upload with no options would, within the tenant's quota.
+// Callers check the object size limit, and pass the ACL from checking
+// write access so new files get subject as their owner.
+func storeFile(ctx context.Context, subject string, input *s3.PutObjectInput, content []byte, acl *fileACL) (*s3.PutObjectOutput, error) {
+	ns := namespaceFrom(ctx)
+	key := aws.ToString(input.Key)
+	var err error
+	if !reviewDefers(reviewCheckPlugins) {
+		if content, err = runUploadPlugins(ctx, ns.name(key), content); err != nil {
+			return nil, err
+		}
+	}
+	if validator, problems := validateUpload(ns.name(key), content); len(problems) > 0 {
+		return nil, errRejected{validator, problems}
+	}
+	if err := checkQuota(ctx, ns.Tenant, int64(len(content))); err != nil {
+		return nil, err
+	}
+	if err := scanUpload(ctx, subject, input, content); err != nil {
+		return nil, err
+	}
+	if err := beforeUploadHooks(ctx, subject, input, int64(len(content))); err != nil {
+		return nil, err
+	}
+	if quarantineEnabled() {
+		// Nothing is stored under the name yet, so there's no ETag to give
+		_, err := holdUpload(ctx, subject, input, content, time.Time{}, acl == nil)
+		if err != nil {
+			return nil, err
+		}
+		return &s3.PutObjectOutput{}, nil
+	}
+	result, err := putObject(ctx, input, content)
+	if err != nil {
+		return nil, err
+	}
+
+	recordEvent(ctx, eventUploaded, key, map[string]string{"size": strconv.Itoa(len(content))})
+	usage.add(ns.Tenant, int64(len(content)), 1)
+	if aclsEnabled && acl == nil {
+		if err := putACLEntry(ctx, key, roleOwner, subject); err != nil {
+			slog.WarnContext(ctx, "Failed to record owner", "key", key, "err", err)
+		}
+	}
+	afterUploadHooks(ctx, subject, input, int64(len(content)))
+	return result, nil
+}
+
+// removeFile deletes key, or moves it to the trash when soft delete is on,
+// and records the event. A non-empty etag makes it conditional on the file
+// still having it. The before-delete hooks can refuse it with errVetoed.
+func removeFile(ctx context.Context, key, etag string, acl *fileACL) error {
+	if err := runHooks(ctx, &hookEvent{Point: hookBeforeDelete, Subject: contextSubject(ctx), Filename: namespaceFrom(ctx).name(key)}); err != nil {
+		return err
+	}
+	if softDeleteEnabled {
+		// The ACL stays so a restored file comes back with it
+		if err := moveToTrash(ctx, key, etag); err != nil {
+			return err
+		}
+		recordEvent(ctx, eventTrashed, key, nil)
+		return nil
+	}
+
+	input := &s3.DeleteObjectInput{
+		Bucket: aws.String(bucketFor(ctx)),
+		Key:    aws.String(key),
+	}
+	if etag != "" {
+		input.IfMatch = aws.String(etag)
+	}
+	if _, err := s3Client.DeleteObject(ctx, input); err != nil {
+		return err
+	}
+	replicateDeletion(ctx, key)
+	if acl != nil {
+		if err := deleteACLEntries(ctx, acl.entries); err != nil {
+			slog.WarnContext(ctx, "Failed to remove ACL", "key", key, "err", err)
+		}
+	}
+	recordEvent(ctx, eventDeleted, key, nil)
+	return nil
+}
+
 func optionsHandler(w http.ResponseWriter, r *http.Request) {
 	enableCORS(w)
 	w.WriteHeader(http.StatusOK)
 }
 
 func main() {
-	// Create router
-	r := mux.NewRouter()
+	if !runCommand() {
+		return
+	}
+	if configErr != nil {
+		fatal("Invalid configuration", "config", configPath, "err", configErr)
+	}
+	if err := loadSecrets(context.Background(), true); err != nil {
+		fatal("Failed to load secrets", "err", err)
+	}
+	if err := loadUploadPlugins(context.Background()); err != nil {
+		fatal("Failed to load upload plugins", "err", err)
+	}
+	if err := loadPluginCatalog(context.Background()); err != nil {
+		fatal("Failed to load plugin catalog", "dir", pluginDir, "err", err)
+	}
+	if err := checkAntivirusSettings(); err != nil {
+		fatal("Invalid configuration", "err", err)
+	}
+	if err := checkQuarantineSettings(); err != nil {
+		fatal("Invalid configuration", "err", err)
+	}
 
-	// API routes
-	api := r.PathPrefix("/api").Subrouter()
-	api.HandleFunc("/health", healthHandler).Methods("GET")
-	api.HandleFunc("/upload", uploadHandler).Methods("POST")
-	api.HandleFunc("/files", listFilesHandler).Methods("GET")
-	api.HandleFunc("/files/{filename}", getFileHandler).Methods("GET")
-	api.HandleFunc("/files/{filename}", deleteFileHandler).Methods("DELETE")
+	// Without storage the API still serves health reports and fails storage
+	// calls fast until the client can be set up
+	if err := storage.start(context.Background()); err != nil {
+		slog.Error("Storage is unavailable; starting degraded", "err", err)
+	}
 
-	// Handle preflight CORS requests
-	r.Methods("OPTIONS").HandlerFunc(optionsHandler)
+	// Create router; routes and their middleware are declared in routes.go
+	routes := apiRoutes()
+	if err := checkLatencySLORoutes(routes); err != nil {
+		fatal("Invalid configuration", "config", configPath, "err", err)
+	}
+	if adminAddr != "" {
+		routes = withoutGroup(routes, "admin")
+	}
+	r := buildRouter(routes)
 
-	// Get port from environment
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	if softDeleteEnabled {
+		startTrashPurger(context.Background())
+	}
+	startExpirySweeper(context.Background())
+	if buildCacheEnabled {
+		startBuildCacheEvictor(context.Background())
+	}
+	startMirrorProber(context.Background())
+	startRateLimitSweeper(context.Background())
+	startReloader(context.Background())
+	startSecretRefresher(context.Background())
+	startBilling(context.Background())
+	startStatusReports(context.Background())
+	startWebhooks(context.Background())
+	if err := startEventBus(context.Background()); err != nil {
+		fatal("Failed to start event publishing", "err", err)
+	}
+	if err := startReplicator(context.Background()); err != nil {
+		fatal("Failed to start replication", "err", err)
+	}
+	if err := startShadow(context.Background()); err != nil {
+		fatal("Failed to start traffic shadowing", "err", err)
+	}
+	startJobResumer(context.Background())
+	if cmdline.worker {
+		if err := runIngestWorker(); err != nil {
+			fatal("Ingestion worker stopped", "err", err)
+		}
+		return
+	}
+	if err := startSFTP(context.Background()); err != nil {
+		fatal("Failed to start SFTP", "err", err)
 	}
 
-	fmt.Printf("API server starting on port %s...\n", port)
-	fmt.Printf("Environment: %s\n", os.Getenv("NODE_ENV"))
-	fmt.Printf("API Version: %s\n", os.Getenv("API_VERSION"))
-	fmt.Printf("S3 Bucket: %s\n", bucketName)
+	attrs := []any{
+		"port", settings.Server.Port,
+		"environment", os.Getenv("NODE_ENV"),
+		"api_version", os.Getenv("API_VERSION"),
+		"bucket", bucketName,
+		"log_level", logLevel.Level().String(),
+	}
+	if len(apiKeys) > 0 {
+		attrs = append(attrs, "api_keys", len(apiKeys))
+	}
+	if jwtEnabled() {
+		attrs = append(attrs, "jwks_url", jwtJWKSURL)
+	}
+	if len(namedBuckets) > 0 {
+		attrs = append(attrs, "named_buckets", namedBucketNames())
+	}
+	tlsConfig, err := serverTLSConfig()
+	if err != nil {
+		fatal("Invalid TLS configuration", "err", err)
+	}
+	if tlsConfig != nil {
+		attrs = append(attrs, "tls", true, "client_auth", tlsConfig.ClientAuth.String())
+	}
+	servers := []*http.Server{newServer(":"+settings.Server.Port, r)}
+	if adminAddr != "" {
+		attrs = append(attrs, "admin_addr", adminAddr)
+		servers = append(servers, newServer(adminAddr, buildRouter(opsRoutes())))
+	}
+	for _, srv := range servers {
+		srv.TLSConfig = tlsConfig
+	}
+	slog.Info("API server starting", attrs...)
 
-	log.Fatal(http.ListenAndServe(":"+port, r))
+	if err := serve(servers...); err != nil {
+		fatal("API server stopped", "err", err)
+	}
 }
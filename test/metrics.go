@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+)
+
+var (
+	// Bearer token Prometheus must send to scrape /metrics; unset leaves it
+	// open, for deployments that only expose it internally
+	metricsToken = os.Getenv("METRICS_TOKEN")
+
+	latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+	requestsTotal    = newCounterVec("http_requests_total", "Requests answered, by route, method and status code.", "route", "method", "code")
+	requestDuration  = newHistogramVec("http_request_duration_seconds", "Time to answer requests, by route and method.", latencyBuckets, "route", "method")
+	requestsInFlight = newCounterVec("http_requests_in_flight", "Requests being answered, by route.", "route").gauge()
+	bytesReceived    = newCounterVec("http_received_bytes_total", "Request body bytes read, i.e. uploaded, by route.", "route")
+	bytesSent        = newCounterVec("http_sent_bytes_total", "Response body bytes written, i.e. downloaded, by route.", "route")
+	storageDuration  = newHistogramVec("storage_operation_duration_seconds", "Latency of S3 calls, by operation.", latencyBuckets, "operation")
+	storageErrors    = newCounterVec("storage_operation_errors_total", "S3 calls that failed, by operation. Missing objects and failed preconditions aren't counted.", "operation")
+
+	// Written in this order
+	allMetrics = []metric{requestsTotal, requestDuration, requestsInFlight, bytesReceived, bytesSent, storageDuration, storageErrors, buildCacheRequests, buildCacheEvictions, mirrorProbes, latencyBudgets, publishedEvents, uploadsScanned, uploadReviews, hookRuns}
+)
+
+// metric is written in the Prometheus text exposition format.
+type metric interface {
+	writeTo(w io.Writer)
+}
+
+// labelKey joins label values into a map key; the separator can't appear in
+// UTF-8 text.
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names []string, key string, extra ...string) string {
+	values := strings.Split(key, "\xff")
+	var parts []string
+	for i, name := range names {
+		parts = append(parts, name+`="`+labelEscaper.Replace(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		parts = append(parts, extra[i]+`="`+labelEscaper.Replace(extra[i+1])+`"`)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// counterVec is a counter, or a gauge, for each combination of label values.
+type counterVec struct {
+	name, help, kind string
+	labels           []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, kind: "counter", labels: labels, values: map[string]float64{}}
+}
+
+// gauge makes the metric one that can go down.
+func (c *counterVec) gauge() *counterVec {
+	c.kind = "gauge"
+	return c
+}
+
+func (c *counterVec) add(delta float64, values ...string) {
+	key := labelKey(values)
+	c.mu.Lock()
+	c.values[key] += delta
+	c.mu.Unlock()
+}
+
+func (c *counterVec) writeTo(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", c.name, c.help, c.name, c.kind)
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, key), formatValue(c.values[key]))
+	}
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// histogramVec counts observations into cumulative buckets for each
+// combination of label values.
+type histogramVec struct {
+	name, help string
+	buckets    []float64
+	labels     []string
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, buckets: buckets, labels: labels, series: map[string]*histogram{}}
+}
+
+func (h *histogramVec) observe(v float64, values ...string) {
+	key := labelKey(values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.series[key]
+	if s == nil {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *histogramVec) writeTo(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, "le", formatValue(bound)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, key), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, key), s.count)
+	}
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if metricsToken != "" {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(metricsToken)) != 1 {
+			respondJSON(w, http.StatusUnauthorized, ErrorResponse{
+				Error: "Metrics token required",
+			})
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, m := range allMetrics {
+		m.writeTo(w)
+	}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// instrumentRequests records each request's outcome, latency and body sizes
+// under the name of its route.
+func instrumentRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := ""
+		if current := mux.CurrentRoute(r); current != nil {
+			route = current.GetName()
+		}
+		requestsInFlight.add(1, route)
+		defer requestsInFlight.add(-1, route)
+
+		start := time.Now()
+		body := &countingReader{ReadCloser: r.Body}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = body
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		requestsTotal.add(1, route, r.Method, strconv.Itoa(rec.status))
+		statusCounts.request(rec.status)
+		elapsed := time.Since(start)
+		requestDuration.observe(elapsed.Seconds(), route, r.Method)
+		latencyBudgets.observe(route, r.Method, elapsed, time.Now())
+		bytesReceived.add(float64(body.n), route)
+		bytesSent.add(float64(rec.bytes), route)
+	})
+}
+
+// metricsS3 times every storage call and counts the ones that fail, and adds
+// them to the cost of the request making them.
+type metricsS3 struct {
+	s3API
+}
+
+func withStorageMetrics(client s3API) s3API {
+	return metricsS3{s3API: client}
+}
+
+func timed[In, Out any](operation string, call func(context.Context, In, ...func(*s3.Options)) (Out, error), ctx context.Context, in In, opts []func(*s3.Options)) (Out, error) {
+	start := time.Now()
+	out, err := call(ctx, in, opts...)
+	storageDuration.observe(time.Since(start).Seconds(), operation)
+	countS3Call(ctx)
+	if err != nil && !isNotFound(err) && !isPreconditionFailed(err) {
+		storageErrors.add(1, operation)
+	}
+	return out, err
+}
+
+func (c metricsS3) PutObject(ctx context.Context, in *s3.PutObjectInput, opts ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	// Counted before the SDK reads the body
+	countS3Bytes(ctx, 0, bodyLength(in.Body, in.ContentLength))
+	return timed("PutObject", c.s3API.PutObject, ctx, in, opts)
+}
+
+func (c metricsS3) UploadPart(ctx context.Context, in *s3.UploadPartInput, opts ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	countS3Bytes(ctx, 0, bodyLength(in.Body, in.ContentLength))
+	return timed("UploadPart", c.s3API.UploadPart, ctx, in, opts)
+}
+
+func (c metricsS3) CreateMultipartUpload(ctx context.Context, in *s3.CreateMultipartUploadInput, opts ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	return timed("CreateMultipartUpload", c.s3API.CreateMultipartUpload, ctx, in, opts)
+}
+
+func (c metricsS3) CompleteMultipartUpload(ctx context.Context, in *s3.CompleteMultipartUploadInput, opts ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	return timed("CompleteMultipartUpload", c.s3API.CompleteMultipartUpload, ctx, in, opts)
+}
+
+func (c metricsS3) AbortMultipartUpload(ctx context.Context, in *s3.AbortMultipartUploadInput, opts ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	return timed("AbortMultipartUpload", c.s3API.AbortMultipartUpload, ctx, in, opts)
+}
+
+func (c metricsS3) GetObject(ctx context.Context, in *s3.GetObjectInput, opts ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	out, err := timed("GetObject", c.s3API.GetObject, ctx, in, opts)
+	if err == nil {
+		countS3Bytes(ctx, aws.ToInt64(out.ContentLength), 0)
+	}
+	return out, err
+}
+
+func (c metricsS3) HeadObject(ctx context.Context, in *s3.HeadObjectInput, opts ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	return timed("HeadObject", c.s3API.HeadObject, ctx, in, opts)
+}
+
+func (c metricsS3) CopyObject(ctx context.Context, in *s3.CopyObjectInput, opts ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	return timed("CopyObject", c.s3API.CopyObject, ctx, in, opts)
+}
+
+func (c metricsS3) DeleteObject(ctx context.Context, in *s3.DeleteObjectInput, opts ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	return timed("DeleteObject", c.s3API.DeleteObject, ctx, in, opts)
+}
+
+func (c metricsS3) DeleteObjects(ctx context.Context, in *s3.DeleteObjectsInput, opts ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	return timed("DeleteObjects", c.s3API.DeleteObjects, ctx, in, opts)
+}
+
+func (c metricsS3) ListObjectsV2(ctx context.Context, in *s3.ListObjectsV2Input, opts ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	return timed("ListObjectsV2", c.s3API.ListObjectsV2, ctx, in, opts)
+}
+
+func (c metricsS3) ListObjectVersions(ctx context.Context, in *s3.ListObjectVersionsInput, opts ...func(*s3.Options)) (*s3.ListObjectVersionsOutput, error) {
+	return timed("ListObjectVersions", c.s3API.ListObjectVersions, ctx, in, opts)
+}
+
+func (c metricsS3) GetObjectTagging(ctx context.Context, in *s3.GetObjectTaggingInput, opts ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error) {
+	return timed("GetObjectTagging", c.s3API.GetObjectTagging, ctx, in, opts)
+}
+
+func (c metricsS3) GetObjectLegalHold(ctx context.Context, in *s3.GetObjectLegalHoldInput, opts ...func(*s3.Options)) (*s3.GetObjectLegalHoldOutput, error) {
+	return timed("GetObjectLegalHold", c.s3API.GetObjectLegalHold, ctx, in, opts)
+}
+
+func (c metricsS3) PutObjectLegalHold(ctx context.Context, in *s3.PutObjectLegalHoldInput, opts ...func(*s3.Options)) (*s3.PutObjectLegalHoldOutput, error) {
+	return timed("PutObjectLegalHold", c.s3API.PutObjectLegalHold, ctx, in, opts)
+}
+
+func (c metricsS3) GetBucketVersioning(ctx context.Context, in *s3.GetBucketVersioningInput, opts ...func(*s3.Options)) (*s3.GetBucketVersioningOutput, error) {
+	return timed("GetBucketVersioning", c.s3API.GetBucketVersioning, ctx, in, opts)
+}
+
+func (c metricsS3) HeadBucket(ctx context.Context, in *s3.HeadBucketInput, opts ...func(*s3.Options)) (*s3.HeadBucketOutput, error) {
+	return timed("HeadBucket", c.s3API.HeadBucket, ctx, in, opts)
+}
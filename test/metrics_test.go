@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// scrape returns the metrics exposition as name{labels} -> value.
+func scrape(t *testing.T, body []byte) map[string]string {
+	t.Helper()
+	samples := map[string]string{}
+	for _, line := range strings.Split(string(body), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		if i < 0 {
+			t.Fatalf("malformed sample %q", line)
+		}
+		samples[line[:i]] = line[i+1:]
+	}
+	return samples
+}
+
+func TestMetrics(t *testing.T) {
+	srv, fake := newTestServer(t)
+	override(t, &s3Client, withStorageMetrics(fake))
+
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("m.txt", "metered"), "X-Request-ID", "req-1"), http.StatusOK)
+	expectStatus(t, call(t, srv, "GET", "/api/files/m.txt", nil), http.StatusOK)
+	expectStatus(t, call(t, srv, "GET", "/api/files/absent.txt", nil), http.StatusNotFound)
+
+	resp := call(t, srv, "GET", "/metrics", nil)
+	expectStatus(t, resp, http.StatusOK)
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("content type %q", resp.Header.Get("Content-Type"))
+	}
+	samples := scrape(t, resp.body)
+	for _, name := range []string{
+		`http_requests_total{route="upload",method="POST",code="200"}`,
+		`http_requests_total{route="getFile",method="GET",code="404"}`,
+		`http_request_duration_seconds_bucket{route="getFile",method="GET",le="+Inf"}`,
+		`http_received_bytes_total{route="upload"}`,
+		`http_sent_bytes_total{route="getFile"}`,
+		`storage_operation_duration_seconds_count{operation="PutObject"}`,
+	} {
+		if v, ok := samples[name]; !ok || v == "0" {
+			t.Errorf("%s = %q", name, v)
+		}
+	}
+	if v := samples[`http_requests_in_flight{route="upload"}`]; v != "0" {
+		t.Errorf("uploads in flight = %q", v)
+	}
+	// A missing object is an answer, not a storage failure
+	if v, ok := samples[`storage_operation_errors_total{operation="GetObject"}`]; ok {
+		t.Errorf("GetObject errors = %s", v)
+	}
+}
+
+func TestMetricsToken(t *testing.T) {
+	override(t, &metricsToken, "scrape-secret")
+	srv, _ := newTestServer(t)
+
+	expectStatus(t, call(t, srv, "GET", "/metrics", nil), http.StatusUnauthorized)
+	expectStatus(t, call(t, srv, "GET", "/metrics", nil, "Authorization", "Bearer scrape-secret"), http.StatusOK)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// statusRecorder captures the status code for logging while still letting
+// streaming handlers flush.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+func (rec *statusRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the connection.
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// identifyRequests gives each request an ID, the caller's X-Request-ID when
+// it sent a usable one, and returns it in the response's X-Request-ID and
+// error bodies. Everything logged with the request's context carries the ID,
+// method, path and key.
+func identifyRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := &requestInfo{ID: requestID(r), Method: r.Method, Path: r.URL.Path}
+		vars := mux.Vars(r)
+		info.key = vars["filename"]
+		if info.key == "" {
+			info.key = vars["prefix"]
+		}
+		w.Header().Set(requestIDHeader, info.ID)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)))
+	})
+}
+
+// logRequests logs one entry per request.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		level := slog.LevelInfo
+		if rec.status >= 500 {
+			level = slog.LevelError
+		}
+		slog.Log(r.Context(), level, "request",
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"latency_ms", time.Since(start).Milliseconds(),
+		)
+	})
+}
+
+// withTimeout bounds the request's context, so storage calls made with it are
+// abandoned once the deadline passes.
+func withTimeout(d time.Duration) middleware {
+	return func(next http.Handler) http.Handler {
+		if d <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+var (
+	// Requests per second each client may make, with bursts up to
+	// RATE_LIMIT_BURST; 0 disables rate limiting. Reloads change them
+	// through limiters, under its lock.
+	rateLimitRPS   = float64(settings.Limits.RateLimitRPS)
+	rateLimitBurst = float64(settings.Limits.RateLimitBurst)
+
+	limiters = &rateLimiters{buckets: map[string]*tokenBucket{}}
+)
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type rateLimiters struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// take spends a token from client's bucket, or returns how long until one is
+// available.
+func (l *rateLimiters) take(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if rateLimitRPS <= 0 {
+		return true, 0
+	}
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &tokenBucket{tokens: rateLimitBurst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(rateLimitBurst, b.tokens+now.Sub(b.last).Seconds()*rateLimitRPS)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rateLimitRPS * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// setRates changes the rate limits. Clients keep the tokens they have, up
+// to the new burst.
+func (l *rateLimiters) setRates(rps, burst float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rateLimitRPS, rateLimitBurst = rps, burst
+}
+
+// sweep forgets clients whose buckets have refilled, bounding memory use.
+func (l *rateLimiters) sweep(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for client, b := range l.buckets {
+		if rateLimitRPS <= 0 || b.tokens+now.Sub(b.last).Seconds()*rateLimitRPS >= rateLimitBurst {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// clientID identifies who a request is rate limited as: its tenant when it
+// was authenticated with tenancy enabled, otherwise the client address.
+func clientID(r *http.Request) string {
+	if _, ok := requestPrincipal(r); ok && tenancyEnabled() {
+		return "tenant:" + requestTenant(r)
+	}
+	return clientAddr(r)
+}
+
+// clientAddr is the address a request came from, as reported by the proxy in
+// front of the API when there is one.
+func clientAddr(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		first, _, _ := strings.Cut(forwarded, ",")
+		return strings.TrimSpace(first)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimit applies even with rate limiting off, as a reload can turn it on.
+func rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		ok, wait := limiters.take(clientID(r), now)
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			respondJSON(w, http.StatusTooManyRequests, ErrorResponse{
+				Error: "Rate limit exceeded",
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func startRateLimitSweeper(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				limiters.sweep(now)
+			}
+		}
+	}()
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+)
+
+// Each public file's mirrors are one object under mirrorPrefix, named by the
+// file's raw key as public URLs are.
+const mirrorPrefix = ".mirrors/"
+
+const (
+	mirrorUp      = "up"
+	mirrorDown    = "down"
+	mirrorUnknown = "unknown"
+)
+
+var (
+	mirrorMaxPerFile = intFromEnv("MIRROR_MAX_PER_FILE", 10)
+
+	// Every instance probes every mirror this often, with a HEAD request; 0
+	// stops probing, leaving mirrors listed in registration order
+	mirrorProbeInterval = durationFromEnv("MIRROR_PROBE_INTERVAL", time.Minute)
+	// Tenants choose the URLs the API probes, so by default it won't connect
+	// to loopback, private or link-local addresses for them, which would let
+	// a tenant map the network the API runs in
+	mirrorAllowPrivate, _ = strconv.ParseBool(os.Getenv("MIRROR_ALLOW_PRIVATE"))
+	mirrorClient          = &http.Client{
+		Timeout:   durationFromEnv("MIRROR_PROBE_TIMEOUT", 5*time.Second),
+		Transport: mirrorTransport(),
+		// A mirror that redirects is probed where it points, if it may be
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return http.ErrUseLastResponse
+			}
+			return checkMirrorURL(req.URL)
+		},
+	}
+
+	mirrorProbes = newCounterVec("mirror_probes_total", "Mirror health probes, by result: up or down.", "result")
+)
+
+// Mirror is one place a public file can also be downloaded from, with the
+// result of its last probe from this instance.
+type Mirror struct {
+	URL       string `json:"url"`
+	Status    string `json:"status"`
+	LatencyMS int64  `json:"latency_ms,omitempty"`
+	CheckedAt string `json:"checked_at,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// MirrorsResponse lists a file's mirrors healthiest first: those up by
+// latency, then those not probed yet, then those down. Origin is the file's
+// own public URL, the fallback when no mirror is up.
+type MirrorsResponse struct {
+	Filename string   `json:"filename"`
+	Origin   string   `json:"origin"`
+	Mirrors  []Mirror `json:"mirrors"`
+}
+
+type MirrorsRequest struct {
+	URLs []string `json:"urls"`
+}
+
+// mirrorRecord is what is stored. Size is the file's when the mirrors were
+// set; a mirror serving another size is stale and counted as down.
+type mirrorRecord struct {
+	URLs      []string `json:"urls"`
+	Size      int64    `json:"size"`
+	UpdatedAt string   `json:"updated_at"`
+	UpdatedBy string   `json:"updated_by"`
+}
+
+// mirrorHealth is this instance's latest probe of each mirror URL.
+var mirrorHealth = struct {
+	sync.Mutex
+	probes map[string]Mirror
+}{probes: map[string]Mirror{}}
+
+func readMirrorRecord(ctx context.Context, key string) (mirrorRecord, error) {
+	var record mirrorRecord
+	result, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(mirrorPrefix + key),
+	})
+	if isNotFound(err) {
+		return record, nil
+	}
+	if err != nil {
+		return record, err
+	}
+	defer result.Body.Close()
+	if err := json.NewDecoder(result.Body).Decode(&record); err != nil {
+		return record, fmt.Errorf("decoding mirrors of %s: %w", key, err)
+	}
+	return record, nil
+}
+
+// probeMirror asks a mirror for the file with HEAD and records whether it
+// has it at the expected size.
+func probeMirror(ctx context.Context, rawURL string, size int64) Mirror {
+	mirror := Mirror{URL: rawURL, Status: mirrorDown}
+	start := time.Now()
+	defer func() {
+		mirror.CheckedAt = start.UTC().Format(time.RFC3339)
+		mirrorProbes.add(1, mirror.Status)
+		mirrorHealth.Lock()
+		mirrorHealth.probes[rawURL] = mirror
+		mirrorHealth.Unlock()
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, "HEAD", rawURL, nil)
+	if err != nil {
+		mirror.Error = err.Error()
+		return mirror
+	}
+	resp, err := mirrorClient.Do(req)
+	if err != nil {
+		mirror.Error = err.Error()
+		return mirror
+	}
+	resp.Body.Close()
+	mirror.LatencyMS = time.Since(start).Milliseconds()
+
+	switch length, _ := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64); {
+	case resp.StatusCode >= 300:
+		mirror.Error = resp.Status
+	case size > 0 && resp.Header.Get("Content-Length") != "" && length != size:
+		mirror.Error = fmt.Sprintf("serves %d bytes, want %d", length, size)
+	default:
+		mirror.Status = mirrorUp
+	}
+	return mirror
+}
+
+// rankMirrors orders urls healthiest first, keeping registration order
+// among equals.
+func rankMirrors(urls []string) []Mirror {
+	mirrorHealth.Lock()
+	mirrors := make([]Mirror, len(urls))
+	for i, u := range urls {
+		mirror, ok := mirrorHealth.probes[u]
+		if !ok {
+			mirror = Mirror{URL: u, Status: mirrorUnknown}
+		}
+		mirrors[i] = mirror
+	}
+	mirrorHealth.Unlock()
+
+	rank := map[string]int{mirrorUp: 0, mirrorUnknown: 1, mirrorDown: 2}
+	slices.SortStableFunc(mirrors, func(a, b Mirror) int {
+		if rank[a.Status] != rank[b.Status] {
+			return rank[a.Status] - rank[b.Status]
+		}
+		if a.Status == mirrorUp {
+			return int(a.LatencyMS - b.LatencyMS)
+		}
+		return 0
+	})
+	return mirrors
+}
+
+func validateMirrorURLs(urls []string) error {
+	if len(urls) > mirrorMaxPerFile {
+		return fmt.Errorf("at most %d mirrors per file", mirrorMaxPerFile)
+	}
+	seen := map[string]bool{}
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not an absolute http or https URL", raw)
+		}
+		if err := checkMirrorURL(u); err != nil {
+			return fmt.Errorf("%q %w", raw, err)
+		}
+		if seen[raw] {
+			return fmt.Errorf("%q is listed twice", raw)
+		}
+		seen[raw] = true
+	}
+	return nil
+}
+
+// checkMirrorURL reports whether u may be probed. A host name is only
+// checked when the probe connects, since it could resolve anywhere.
+func checkMirrorURL(u *url.URL) error {
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("is not an absolute http or https URL")
+	}
+	host := strings.ToLower(u.Hostname())
+	ip := net.ParseIP(host)
+	if !mirrorAllowPrivate && (host == "localhost" || strings.HasSuffix(host, ".localhost") || ip != nil && privateAddress(ip)) {
+		return errors.New("points at a private address")
+	}
+	return nil
+}
+
+// privateAddress reports whether ip is one only the network the API runs
+// in can reach, including the cloud metadata endpoint at 169.254.169.254.
+func privateAddress(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified()
+}
+
+// mirrorTransport connects only to addresses checkMirrorURL would allow,
+// checking each after its host name is resolved. It doesn't use a proxy,
+// which would make the address it connects to the proxy's.
+func mirrorTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); !mirrorAllowPrivate && (ip == nil || privateAddress(ip)) {
+				return fmt.Errorf("%s is a private address", host)
+			}
+			return nil
+		},
+	}
+	transport.DialContext = dialer.DialContext
+	return transport
+}
+
+// publicHead returns a public file's metadata, or nil when it is missing or
+// private, which public routes don't tell apart.
+func publicHead(ctx context.Context, key string) (*s3.HeadObjectOutput, error) {
+	if key == "" || isReservedKey(key) {
+		return nil, nil
+	}
+	head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucketName), Key: aws.String(key)})
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if fileVisibility(head.Metadata) != visibilityPublic {
+		return nil, nil
+	}
+	return head, nil
+}
+
+func mirrorsResponse(r *http.Request, filename, key string, record mirrorRecord) MirrorsResponse {
+	return MirrorsResponse{
+		Filename: filename,
+		Origin:   requestBaseURL(r) + publicURL(key),
+		Mirrors:  rankMirrors(record.URLs),
+	}
+}
+
+// publicMirrorsHandler lists a public file's mirrors for download managers,
+// which can fall back along the list.
+func publicMirrorsHandler(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["filename"]
+	head, err := publicHead(r.Context(), key)
+	if err == nil && head == nil {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "File not found"})
+		return
+	}
+	var record mirrorRecord
+	if err == nil {
+		record, err = readMirrorRecord(r.Context(), key)
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to read mirrors", Details: err.Error()})
+		return
+	}
+	enableCORS(w)
+	respondJSON(w, http.StatusOK, mirrorsResponse(r, key, key, record))
+}
+
+// redirectToMirror answers ?mirror=best on a public download by sending the
+// client to the fastest mirror that is up, and reports whether it did. With
+// none up the file is served from here.
+func redirectToMirror(w http.ResponseWriter, r *http.Request, key string) bool {
+	head, err := publicHead(r.Context(), key)
+	if err != nil || head == nil {
+		return false
+	}
+	record, err := readMirrorRecord(r.Context(), key)
+	if err != nil {
+		slog.WarnContext(r.Context(), "Failed to read mirrors; serving the file", "key", key, "err", err)
+		return false
+	}
+	mirrors := rankMirrors(record.URLs)
+	if len(mirrors) == 0 || mirrors[0].Status != mirrorUp {
+		return false
+	}
+	enableCORS(w)
+	// Health changes, so the choice mustn't be cached for long
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, mirrors[0].URL, http.StatusFound)
+	return true
+}
+
+func getMirrorsHandler(w http.ResponseWriter, r *http.Request) {
+	filename := mux.Vars(r)["filename"]
+	ns := requestNamespace(r)
+	key := ns.key(filename)
+	if !authorizeFile(w, r, key, permRead) {
+		return
+	}
+	record, err := readMirrorRecord(r.Context(), key)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to read mirrors", Details: err.Error()})
+		return
+	}
+	respondJSON(w, http.StatusOK, mirrorsResponse(r, filename, key, record))
+}
+
+// setMirrorsHandler replaces a public file's mirrors and probes them, so the
+// response shows which are reachable. An empty list removes them.
+func setMirrorsHandler(w http.ResponseWriter, r *http.Request) {
+	filename := mux.Vars(r)["filename"]
+	ctx := r.Context()
+	ns := requestNamespace(r)
+	key := ns.key(filename)
+	if !authorizeFile(w, r, key, permWrite) {
+		return
+	}
+	var req MirrorsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondInvalidBody(w, err)
+		return
+	}
+	if err := validateMirrorURLs(req.URLs); err != nil {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid mirrors", Details: err.Error()})
+		return
+	}
+
+	var head *s3.HeadObjectOutput
+	var err error
+	if ns.Bucket == bucketName {
+		head, err = publicHead(ctx, key)
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to read file", Details: err.Error()})
+		return
+	}
+	if head == nil {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{
+			Error:   "Public file not found",
+			Details: "only public files can have mirrors",
+		})
+		return
+	}
+
+	if len(req.URLs) == 0 {
+		_, err = s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(bucketName), Key: aws.String(mirrorPrefix + key)})
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to remove mirrors", Details: err.Error()})
+			return
+		}
+		respondJSON(w, http.StatusOK, mirrorsResponse(r, filename, key, mirrorRecord{}))
+		return
+	}
+
+	record := mirrorRecord{
+		URLs:      req.URLs,
+		Size:      aws.ToInt64(head.ContentLength),
+		UpdatedAt: time.Now().UTC().Format(time.RFC3339),
+		UpdatedBy: requestSubject(r),
+	}
+	if original, err := strconv.ParseInt(head.Metadata[metaOriginalSize], 10, 64); err == nil {
+		record.Size = original
+	}
+	body, err := json.MarshalIndent(record, "", "  ")
+	if err == nil {
+		_, err = s3Client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:      aws.String(bucketName),
+			Key:         aws.String(mirrorPrefix + key),
+			Body:        bytes.NewReader(body),
+			ContentType: aws.String("application/json"),
+		})
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to save mirrors", Details: err.Error()})
+		return
+	}
+
+	probeMirrors(ctx, record)
+	respondJSON(w, http.StatusOK, mirrorsResponse(r, filename, key, record))
+}
+
+// probeMirrors probes a file's mirrors at once, so a slow one only costs its
+// own timeout.
+func probeMirrors(ctx context.Context, record mirrorRecord) {
+	var wg sync.WaitGroup
+	for _, u := range record.URLs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			probeMirror(ctx, u, record.Size)
+		}()
+	}
+	wg.Wait()
+}
+
+// probeAllMirrors probes every registered mirror and forgets the health of
+// those no longer registered.
+func probeAllMirrors(ctx context.Context) error {
+	keys, err := listPrefix(withNamespace(ctx, rootNamespace()), mirrorPrefix)
+	if err != nil {
+		return err
+	}
+	registered := map[string]bool{}
+	for _, key := range keys {
+		record, err := readMirrorRecord(ctx, key[len(mirrorPrefix):])
+		if err != nil {
+			slog.WarnContext(ctx, "Skipping unreadable mirrors", "key", key, "err", err)
+			continue
+		}
+		for _, u := range record.URLs {
+			registered[u] = true
+		}
+		probeMirrors(ctx, record)
+	}
+
+	mirrorHealth.Lock()
+	for u := range mirrorHealth.probes {
+		if !registered[u] {
+			delete(mirrorHealth.probes, u)
+		}
+	}
+	mirrorHealth.Unlock()
+	return nil
+}
+
+func startMirrorProber(ctx context.Context) {
+	if mirrorProbeInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(mirrorProbeInterval)
+		defer ticker.Stop()
+
+		for {
+			if err := probeAllMirrors(ctx); err != nil {
+				slog.ErrorContext(ctx, "Mirror probes failed to list mirrors", "err", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// mirrorServer answers probes with status and the length of body.
+func mirrorServer(t *testing.T, status int, body string) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL + "/app.tar.gz"
+}
+
+func TestMirrors(t *testing.T) {
+	override(t, &mirrorHealth.probes, map[string]Mirror{})
+	// The test mirrors are on loopback
+	override(t, &mirrorAllowPrivate, true)
+	srv, _ := newTestServer(t)
+	req := upload("app.tar.gz", "release")
+	req.Visibility = visibilityPublic
+	expectStatus(t, call(t, srv, "POST", "/api/upload", req), http.StatusOK)
+
+	missing := mirrorServer(t, http.StatusNotFound, "")
+	stale := mirrorServer(t, http.StatusOK, "old")
+	good := mirrorServer(t, http.StatusOK, "release")
+	unreachable := "http://127.0.0.1:1/app.tar.gz"
+
+	var mirrors MirrorsResponse
+	got := call(t, srv, "PUT", "/api/files/app.tar.gz/mirrors", MirrorsRequest{URLs: []string{missing, stale, unreachable, good}})
+	expectStatus(t, got, http.StatusOK)
+	got.decode(t, &mirrors)
+	if len(mirrors.Mirrors) != 4 || mirrors.Mirrors[0].URL != good || mirrors.Mirrors[0].Status != mirrorUp {
+		t.Fatalf("mirrors %+v", mirrors.Mirrors)
+	}
+	for _, m := range mirrors.Mirrors[1:] {
+		if m.Status != mirrorDown || m.Error == "" {
+			t.Errorf("mirror %s is %s: %q", m.URL, m.Status, m.Error)
+		}
+	}
+	if !strings.HasSuffix(mirrors.Origin, "/api/public/app.tar.gz") {
+		t.Errorf("origin %q", mirrors.Origin)
+	}
+
+	// Anyone can list them, and ask to be sent to the best one
+	var public MirrorsResponse
+	call(t, srv, "GET", "/api/public/app.tar.gz/mirrors", nil).decode(t, &public)
+	if len(public.Mirrors) != 4 || public.Mirrors[0].URL != good {
+		t.Errorf("public mirrors %+v", public.Mirrors)
+	}
+	redirect := call(t, srv, "GET", "/api/public/app.tar.gz?mirror=best", nil)
+	expectStatus(t, redirect, http.StatusFound)
+	if location := redirect.Header.Get("Location"); location != good {
+		t.Errorf("redirected to %q, want %q", location, good)
+	}
+
+	// With no mirror up, the file is served from here
+	expectStatus(t, call(t, srv, "PUT", "/api/files/app.tar.gz/mirrors", MirrorsRequest{URLs: []string{missing}}), http.StatusOK)
+	served := call(t, srv, "GET", "/api/public/app.tar.gz?mirror=best", nil)
+	expectStatus(t, served, http.StatusOK)
+	if string(served.body) != "release" {
+		t.Errorf("served %q", served.body)
+	}
+
+	expectStatus(t, call(t, srv, "PUT", "/api/files/app.tar.gz/mirrors", MirrorsRequest{URLs: []string{}}), http.StatusOK)
+	call(t, srv, "GET", "/api/files/app.tar.gz/mirrors", nil).decode(t, &mirrors)
+	if len(mirrors.Mirrors) != 0 {
+		t.Errorf("mirrors after removing them: %+v", mirrors.Mirrors)
+	}
+}
+
+func TestMirrorProblems(t *testing.T) {
+	srv, _ := newTestServer(t)
+	mustUpload(t, srv, "/api", "private.tar.gz", "x")
+
+	for body, want := range map[string]int{
+		`{"urls": ["https://mirror.example.com/private.tar.gz"]}`:        http.StatusNotFound,
+		`{"urls": ["ftp://mirror.example.com/private.tar.gz"]}`:          http.StatusBadRequest,
+		`{"urls": ["/relative"]}`:                                        http.StatusBadRequest,
+		`{"urls": ["https://a.example.com/", "https://a.example.com/"]}`: http.StatusBadRequest,
+		`{"urls": ["http://127.0.0.1:8080/private.tar.gz"]}`:             http.StatusBadRequest,
+		`{"urls": ["http://169.254.169.254/latest/meta-data/"]}`:         http.StatusBadRequest,
+		`{"urls": ["http://[::1]/private.tar.gz"]}`:                      http.StatusBadRequest,
+		`{"urls": ["http://10.0.0.7/private.tar.gz"]}`:                   http.StatusBadRequest,
+		`{"urls": ["http://LOCALHOST/private.tar.gz"]}`:                  http.StatusBadRequest,
+	} {
+		if got := call(t, srv, "PUT", "/api/files/private.tar.gz/mirrors", body); got.StatusCode != want {
+			t.Errorf("%s: %d %s, want %d", body, got.StatusCode, got.body, want)
+		}
+	}
+	expectStatus(t, call(t, srv, "GET", "/api/public/private.tar.gz/mirrors", nil), http.StatusNotFound)
+
+	// A host name is checked once it resolves, and a redirect like the URL
+	override(t, &mirrorHealth.probes, map[string]Mirror{})
+	if mirror := probeMirror(context.Background(), mirrorServer(t, http.StatusOK, "x"), 1); mirror.Status != mirrorDown || !strings.Contains(mirror.Error, "private address") {
+		t.Errorf("probe of a loopback mirror %+v", mirror)
+	}
+	req := httptest.NewRequest("HEAD", "http://169.254.169.254/latest/meta-data/", nil)
+	if err := mirrorClient.CheckRedirect(req, []*http.Request{req}); err == nil {
+		t.Error("redirect to the metadata endpoint allowed")
+	}
+}
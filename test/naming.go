@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+// Collision strategies applied when an upload targets a key that already exists.
+const (
+	conflictOverwrite = "overwrite"
+	conflictNumber    = "number"
+	conflictTimestamp = "timestamp"
+	conflictHash      = "hash"
+)
+
+const maxConflictAttempts = 1000
+
+// Per-prefix defaults, e.g. UPLOAD_CONFLICT_STRATEGIES="reports/=timestamp,avatars/=hash"
+var prefixConflictStrategies = parseAssignments(os.Getenv("UPLOAD_CONFLICT_STRATEGIES"))
+
+// With UPLOAD_PREVENT_OVERWRITE=true uploads only replace an existing file
+// when the client asks for it
+var preventOverwrite, _ = strconv.ParseBool(os.Getenv("UPLOAD_PREVENT_OVERWRITE"))
+
+var errInvalidOverwrite = errors.New("overwrite must be true or false, and can't be combined with If-Match")
+
+// overwriteAllowed reports whether an upload may replace an existing file:
+// If-Match always may, If-None-Match: * never may, then ?overwrite= decides,
+// then the configured default.
+func overwriteAllowed(r *http.Request) (bool, error) {
+	noneMatch := r.Header.Get("If-None-Match") == "*"
+	query := r.URL.Query().Get("overwrite")
+	if r.Header.Get("If-Match") != "" {
+		if noneMatch || query == "false" {
+			return false, errInvalidOverwrite
+		}
+		return true, nil
+	}
+	if noneMatch {
+		return false, nil
+	}
+	if query != "" {
+		allowed, err := strconv.ParseBool(query)
+		if err != nil {
+			return false, errInvalidOverwrite
+		}
+		return allowed, nil
+	}
+	return !preventOverwrite, nil
+}
+
+func validConflictStrategy(strategy string) bool {
+	switch strategy {
+	case conflictOverwrite, conflictNumber, conflictTimestamp, conflictHash:
+		return true
+	}
+	return false
+}
+
+// conflictStrategyFor picks the strategy requested by the client, falling back
+// to the longest configured prefix that matches the key.
+func conflictStrategyFor(key, requested string) string {
+	if requested != "" {
+		return requested
+	}
+
+	strategy, longest := conflictOverwrite, -1
+	for prefix, s := range prefixConflictStrategies {
+		if strings.HasPrefix(key, prefix) && len(prefix) > longest {
+			strategy, longest = s, len(prefix)
+		}
+	}
+	return strategy
+}
+
+func isNotFound(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "NotFound", "NoSuchKey", "NoSuchVersion":
+			return true
+		}
+	}
+	return false
+}
+
+func objectExists(ctx context.Context, key string) (bool, error) {
+	_, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucketFor(ctx)),
+		Key:    aws.String(key),
+	})
+	if err == nil {
+		return true, nil
+	}
+	if isNotFound(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// splitExt splits "dir/name.ext" into "dir/name" and ".ext".
+func splitExt(key string) (string, string) {
+	ext := path.Ext(key)
+	return strings.TrimSuffix(key, ext), ext
+}
+
+// resolveUploadKey returns the key an upload should be written to under the
+// given collision strategy.
+func resolveUploadKey(ctx context.Context, key, strategy string, content []byte) (string, error) {
+	if strategy == conflictOverwrite {
+		return key, nil
+	}
+
+	exists, err := objectExists(ctx, key)
+	if err != nil || !exists {
+		return key, err
+	}
+
+	base, ext := splitExt(key)
+
+	switch strategy {
+	case conflictHash:
+		// Identical content maps to the same key, so rewriting it is harmless
+		return fmt.Sprintf("%s-%s%s", base, contentHash(content)[:8], ext), nil
+	case conflictTimestamp:
+		candidate := fmt.Sprintf("%s-%s%s", base, time.Now().UTC().Format("20060102T150405Z"), ext)
+		exists, err := objectExists(ctx, candidate)
+		if err != nil || !exists {
+			return candidate, err
+		}
+		// Two uploads in the same second; number the timestamped name instead
+		base, ext = splitExt(candidate)
+	}
+
+	for i := 1; i <= maxConflictAttempts; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, i, ext)
+		exists, err := objectExists(ctx, candidate)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return candidate, nil
+		}
+	}
+
+	return "", fmt.Errorf("no free name found for %s after %d attempts", key, maxConflictAttempts)
+}
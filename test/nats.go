@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+)
+
+// NATS connects the service to a NATS mesh: EVENT_PUBLISHER=nats publishes
+// events to JetStream, and INGEST_QUEUE=nats takes -worker's messages from
+// a JetStream consumer. Both share one connection to the servers in
+// NATS_URL. The client needs github.com/nats-io/nats.go, so it is only
+// built in with -tags nats.
+var (
+	natsURL  = os.Getenv("NATS_URL")
+	natsName = envOr("NATS_CLIENT_NAME", "test-api")
+	// A .creds file with the user's JWT and NKey seed, or a token, which can
+	// come from a secret and is read again on each reconnect
+	natsCredsFile = os.Getenv("NATS_CREDS_FILE")
+	natsToken     = os.Getenv("NATS_TOKEN")
+	// A CA bundle to verify the servers with, instead of the system roots
+	natsTLSCAFile = os.Getenv("NATS_TLS_CA_FILE")
+
+	// The durable consumer -worker pulls from, which operators create on the
+	// stream, and the subject messages that won't succeed are published to
+	natsIngestStream     = os.Getenv("NATS_INGEST_STREAM")
+	natsIngestConsumer   = os.Getenv("NATS_INGEST_CONSUMER")
+	natsIngestDLQSubject = os.Getenv("NATS_INGEST_DLQ_SUBJECT")
+)
+
+func checkNATSSettings() error {
+	switch {
+	case natsURL == "":
+		return errors.New("NATS needs NATS_URL")
+	case natsCredsFile != "" && natsToken != "":
+		return errors.New("set either NATS_CREDS_FILE or NATS_TOKEN, not both")
+	}
+	return nil
+}
+
+func checkNATSIngestSettings() error {
+	if natsIngestStream == "" || natsIngestConsumer == "" || natsIngestDLQSubject == "" {
+		return errors.New("-worker with INGEST_QUEUE=nats needs NATS_INGEST_STREAM, NATS_INGEST_CONSUMER and NATS_INGEST_DLQ_SUBJECT")
+	}
+	return checkNATSSettings()
+}
+
+// natsSubject is where an event sent to target is published: the target
+// with the event type appended, so subscribers can pick types with
+// wildcards, e.g. "files.events.*" or "files.events.deleted".
+func natsSubject(target string, e BusEvent) string {
+	return strings.TrimSuffix(target, ".") + "." + e.Type
+}
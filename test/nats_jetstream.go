@@ -0,0 +1,167 @@
+//go:build nats
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+var (
+	_ = registerEventPublisher("nats", newNATSPublisher)
+	_ = registerIngestQueue("nats", openNATSQueue)
+)
+
+// natsFetchWait is how long receive waits for a message. Fetching can't be
+// interrupted, so this is also how long a stopping worker may wait on it.
+const natsFetchWait = 5 * time.Second
+
+var natsConnection struct {
+	mu sync.Mutex
+	js jetstream.JetStream
+}
+
+// connectJetStream connects to NATS_URL the first time it is called, and
+// returns the same connection after that.
+func connectJetStream() (jetstream.JetStream, error) {
+	natsConnection.mu.Lock()
+	defer natsConnection.mu.Unlock()
+	if natsConnection.js != nil {
+		return natsConnection.js, nil
+	}
+	if err := checkNATSSettings(); err != nil {
+		return nil, err
+	}
+	opts := []nats.Option{nats.Name(natsName), nats.MaxReconnects(-1)}
+	if natsCredsFile != "" {
+		opts = append(opts, nats.UserCredentials(natsCredsFile))
+	}
+	if natsToken != "" {
+		opts = append(opts, nats.TokenHandler(func() string { return currentSetting(&natsToken) }))
+	}
+	if natsTLSCAFile != "" {
+		opts = append(opts, nats.RootCAs(natsTLSCAFile))
+	}
+	conn, err := nats.Connect(natsURL, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to NATS: %w", err)
+	}
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	natsConnection.js = js
+	return js, nil
+}
+
+// natsPublisher publishes each event to a stream, on a subject ending in
+// its type. JetStream drops a retried event it has already stored, by ID.
+type natsPublisher struct {
+	js jetstream.JetStream
+}
+
+func newNATSPublisher(ctx context.Context) (eventPublisher, error) {
+	js, err := connectJetStream()
+	if err != nil {
+		return nil, err
+	}
+	return &natsPublisher{js: js}, nil
+}
+
+func (p *natsPublisher) publish(ctx context.Context, target string, events []BusEvent) ([]BusEvent, error) {
+	for i, e := range events {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+		msg := nats.NewMsg(natsSubject(target, e))
+		msg.Data = data
+		msg.Header.Set("Event-Type", e.Type)
+		if e.Tenant != "" {
+			msg.Header.Set("Tenant", e.Tenant)
+		}
+		// One at a time, so a file's events are stored in order; those after
+		// a failure are sent again with it
+		if _, err := p.js.PublishMsg(ctx, msg, jetstream.WithMsgID(e.ID)); err != nil {
+			return events[i:], fmt.Errorf("%d events refused: %w", len(events)-i, err)
+		}
+	}
+	return nil, nil
+}
+
+// natsQueue pulls from a durable consumer, and dead-letters by publishing
+// to a subject and terminating the message so it isn't delivered again.
+type natsQueue struct {
+	js       jetstream.JetStream
+	consumer jetstream.Consumer
+}
+
+func openNATSQueue(ctx context.Context) (ingestQueue, error) {
+	js, err := connectJetStream()
+	if err != nil {
+		return nil, err
+	}
+	consumer, err := js.Consumer(ctx, natsIngestStream, natsIngestConsumer)
+	if err != nil {
+		return nil, fmt.Errorf("consumer %s on stream %s: %w", natsIngestConsumer, natsIngestStream, err)
+	}
+	return &natsQueue{js: js, consumer: consumer}, nil
+}
+
+func (q *natsQueue) receive(ctx context.Context, max int) ([]queueMessage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	batch, err := q.consumer.Fetch(max, jetstream.FetchMaxWait(natsFetchWait))
+	if err != nil {
+		return nil, err
+	}
+	var messages []queueMessage
+	for m := range batch.Messages() {
+		meta, err := m.Metadata()
+		if err != nil {
+			// Not a JetStream message, so there's nothing to settle
+			continue
+		}
+		messages = append(messages, queueMessage{
+			ID:      strconv.FormatUint(meta.Sequence.Stream, 10),
+			Body:    string(m.Data()),
+			Attempt: int(meta.NumDelivered),
+			receipt: m,
+		})
+	}
+	if len(messages) > 0 {
+		return messages, nil
+	}
+	return nil, batch.Error()
+}
+
+func (q *natsQueue) done(ctx context.Context, m queueMessage) error {
+	return m.receipt.(jetstream.Msg).DoubleAck(ctx)
+}
+
+func (q *natsQueue) retry(ctx context.Context, m queueMessage, delay time.Duration) error {
+	return m.receipt.(jetstream.Msg).NakWithDelay(delay)
+}
+
+func (q *natsQueue) deadLetter(ctx context.Context, m queueMessage, reason string) error {
+	msg := nats.NewMsg(natsIngestDLQSubject)
+	msg.Data = []byte(m.Body)
+	// A header is one line
+	msg.Header.Set("Error", strings.ReplaceAll(reason, "\n", " "))
+	msg.Header.Set("Stream-Sequence", m.ID)
+	msg.Header.Set("Deliveries", strconv.Itoa(m.Attempt))
+	if _, err := q.js.PublishMsg(ctx, msg); err != nil {
+		return err
+	}
+	return m.receipt.(jetstream.Msg).Term()
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNATSSettings(t *testing.T) {
+	override(t, &natsURL, "")
+	if err := checkNATSSettings(); err == nil || !strings.Contains(err.Error(), "NATS_URL") {
+		t.Errorf("no servers: %v", err)
+	}
+	override(t, &natsURL, "tls://nats-1:4222,tls://nats-2:4222")
+	override(t, &natsCredsFile, "/etc/nats/files.creds")
+	override(t, &natsToken, "s3cret")
+	if err := checkNATSSettings(); err == nil {
+		t.Error("accepted both credentials and a token")
+	}
+	override(t, &natsToken, "")
+	if err := checkNATSSettings(); err != nil {
+		t.Error(err)
+	}
+
+	override(t, &natsIngestStream, "UPLOADS")
+	override(t, &natsIngestConsumer, "test-api")
+	override(t, &natsIngestDLQSubject, "")
+	if err := checkNATSIngestSettings(); err == nil || !strings.Contains(err.Error(), "NATS_INGEST_DLQ_SUBJECT") {
+		t.Errorf("no dead-letter subject: %v", err)
+	}
+	override(t, &natsIngestDLQSubject, "uploads.dead")
+	if err := checkNATSIngestSettings(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNATSSubject(t *testing.T) {
+	for target, want := range map[string]string{
+		"files.events":  "files.events.deleted",
+		"files.events.": "files.events.deleted",
+	} {
+		if got := natsSubject(target, BusEvent{Type: eventDeleted}); got != want {
+			t.Errorf("natsSubject(%q) = %q, want %q", target, got, want)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+//go:build nfc
+
+package main
+
+import "golang.org/x/text/unicode/norm"
+
+var _ = registerKeyNormalizer("nfc", norm.NFC.String)
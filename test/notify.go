@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+	// Outgoing email goes through SMTP_ADDR (host:port), with PLAIN auth when
+	// SMTP_USERNAME is set
+	smtpAddr     = os.Getenv("SMTP_ADDR")
+	smtpUsername = os.Getenv("SMTP_USERNAME")
+	smtpPassword = os.Getenv("SMTP_PASSWORD")
+	smtpFrom     = envOr("SMTP_FROM", "files-api@localhost")
+)
+
+// postSigned POSTs a JSON body to url. With a secret, the header named by
+// signatureHeader carries "sha256=" and the body's hex HMAC, so the receiver
+// can tell the request came from us.
+func postSigned(ctx context.Context, url, secret, signatureHeader string, body []byte) error {
+	return exchangeSigned(ctx, url, secret, signatureHeader, body, nil)
+}
+
+// exchangeSigned is postSigned for a receiver that answers, decoding its
+// JSON reply into reply unless that is nil.
+func exchangeSigned(ctx context.Context, url, secret, signatureHeader string, body []byte, reply any) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		req.Header.Set(signatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		statusCounts.webhookFailed()
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		statusCounts.webhookFailed()
+		return fmt.Errorf("webhook %s returned %s", url, resp.Status)
+	}
+	if reply != nil {
+		if err := json.NewDecoder(resp.Body).Decode(reply); err != nil {
+			return fmt.Errorf("webhook %s answered: %w", url, err)
+		}
+	}
+	return nil
+}
+
+// sendEmail sends a plain text message. net/smtp can't be cancelled, so ctx
+// only stops it from starting.
+func sendEmail(ctx context.Context, to []string, subject, body string) error {
+	if smtpAddr == "" {
+		return fmt.Errorf("SMTP_ADDR is not set")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if smtpUsername != "" {
+		host, _, _ := strings.Cut(smtpAddr, ":")
+		auth = smtp.PlainAuth("", smtpUsername, smtpPassword, host)
+	}
+	msg := "From: " + smtpFrom + "\r\n" +
+		"To: " + strings.Join(to, ", ") + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" + strings.ReplaceAll(body, "\n", "\r\n")
+	return smtp.SendMail(smtpAddr, auth, smtpFrom, to, []byte(msg))
+}
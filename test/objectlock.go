@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/gorilla/mux"
+)
+
+type LegalHoldRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+type LegalHoldResponse struct {
+	Filename string `json:"filename"`
+	Enabled  bool   `json:"enabled"`
+}
+
+// applyObjectLock copies the retention and legal hold settings of an upload
+// onto the PutObject input. The bucket must have Object Lock enabled.
+func applyObjectLock(req UploadRequest, input *s3.PutObjectInput) error {
+	if req.LegalHold {
+		input.ObjectLockLegalHoldStatus = types.ObjectLockLegalHoldStatusOn
+		// Object Lock uploads must carry an integrity checksum
+		input.ChecksumAlgorithm = types.ChecksumAlgorithmCrc32
+	}
+
+	if req.RetentionMode == "" {
+		if req.RetentionDays != 0 || req.RetainUntil != "" {
+			return fmt.Errorf("retention_mode is required when setting a retention period")
+		}
+		return nil
+	}
+
+	mode := types.ObjectLockMode(strings.ToUpper(req.RetentionMode))
+	switch mode {
+	case types.ObjectLockModeGovernance, types.ObjectLockModeCompliance:
+	default:
+		return fmt.Errorf("retention_mode must be GOVERNANCE or COMPLIANCE")
+	}
+
+	var until time.Time
+	switch {
+	case req.RetainUntil != "" && req.RetentionDays != 0:
+		return fmt.Errorf("set either retention_days or retain_until, not both")
+	case req.RetainUntil != "":
+		t, err := time.Parse(time.RFC3339, req.RetainUntil)
+		if err != nil {
+			return fmt.Errorf("retain_until must be an RFC 3339 timestamp")
+		}
+		until = t
+	case req.RetentionDays > 0:
+		until = time.Now().AddDate(0, 0, req.RetentionDays)
+	default:
+		return fmt.Errorf("retention_days or retain_until is required with retention_mode")
+	}
+	if !until.After(time.Now()) {
+		return fmt.Errorf("retention period must end in the future")
+	}
+
+	input.ObjectLockMode = mode
+	input.ObjectLockRetainUntilDate = aws.Time(until)
+	input.ChecksumAlgorithm = types.ChecksumAlgorithmCrc32
+
+	return nil
+}
+
+func getLegalHoldHandler(w http.ResponseWriter, r *http.Request) {
+	filename := mux.Vars(r)["filename"]
+	ns := requestNamespace(r)
+	if !authorizeFile(w, r, ns.key(filename), permRead) {
+		return
+	}
+
+	result, err := s3Client.GetObjectLegalHold(r.Context(), &s3.GetObjectLegalHoldInput{
+		Bucket: aws.String(ns.Bucket),
+		Key:    aws.String(ns.key(filename)),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			respondJSON(w, http.StatusNotFound, ErrorResponse{
+				Error: "File not found",
+			})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to read legal hold",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	enabled := result.LegalHold != nil && result.LegalHold.Status == types.ObjectLockLegalHoldStatusOn
+	respondJSON(w, http.StatusOK, LegalHoldResponse{
+		Filename: filename,
+		Enabled:  enabled,
+	})
+}
+
+func setLegalHoldHandler(w http.ResponseWriter, r *http.Request) {
+	filename := mux.Vars(r)["filename"]
+	ns := requestNamespace(r)
+	if !authorizeFile(w, r, ns.key(filename), permWrite) {
+		return
+	}
+
+	var req LegalHoldRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondInvalidBody(w, err)
+		return
+	}
+
+	status := types.ObjectLockLegalHoldStatusOff
+	if req.Enabled {
+		status = types.ObjectLockLegalHoldStatusOn
+	}
+
+	_, err := s3Client.PutObjectLegalHold(r.Context(), &s3.PutObjectLegalHoldInput{
+		Bucket:            aws.String(ns.Bucket),
+		Key:               aws.String(ns.key(filename)),
+		LegalHold:         &types.ObjectLockLegalHold{Status: status},
+		ChecksumAlgorithm: types.ChecksumAlgorithmCrc32,
+	})
+	if err != nil {
+		if isNotFound(err) {
+			respondJSON(w, http.StatusNotFound, ErrorResponse{
+				Error: "File not found",
+			})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to update legal hold",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	recordEvent(r.Context(), eventLegalHold, ns.key(filename), map[string]string{"enabled": strconv.FormatBool(req.Enabled)})
+
+	respondJSON(w, http.StatusOK, LegalHoldResponse{
+		Filename: filename,
+		Enabled:  req.Enabled,
+	})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+var (
+	// Largest object a tenant may store, in bytes; 0 means unlimited.
+	// TENANT_MAX_OBJECT_BYTES overrides it per tenant, e.g. "acme=104857600"
+	maxObjectBytes       = int64(intFromEnv("MAX_OBJECT_BYTES", 0))
+	tenantMaxObjectBytes = parseByteLimits("TENANT_MAX_OBJECT_BYTES")
+
+	// Multipart uploads have limits of their own, typically larger, since
+	// only they can carry objects bigger than one request body
+	maxMultipartObjectBytes       = int64(intFromEnv("MAX_MULTIPART_OBJECT_BYTES", 0))
+	tenantMaxMultipartObjectBytes = parseByteLimits("TENANT_MAX_MULTIPART_OBJECT_BYTES")
+)
+
+// Kinds of limit a LimitHint can describe.
+const (
+	limitBody                = "body"
+	limitObjectSize          = "object_size"
+	limitMultipartObjectSize = "multipart_object_size"
+	limitQuota               = "quota"
+)
+
+// LimitHint tells clients which limit a request ran into, so they can split
+// or shrink the next one.
+type LimitHint struct {
+	Kind  string `json:"kind"`
+	Bytes int64  `json:"bytes"`
+	// default, tenant or media_type: where the limit was configured
+	Scope string `json:"scope,omitempty"`
+	// Size of what was refused, when known
+	Size int64 `json:"size,omitempty"`
+}
+
+// objectSizeLimit is the largest object tenant may store in one upload, or
+// in a multipart one, and whether the limit is the tenant's own.
+func objectSizeLimit(tenant string, multipart bool) (int64, string) {
+	perTenant, fallback := tenantMaxObjectBytes, maxObjectBytes
+	if multipart {
+		perTenant, fallback = tenantMaxMultipartObjectBytes, maxMultipartObjectBytes
+	}
+	if record, ok := registeredTenants.cached(tenant); ok {
+		own := record.MaxObjectBytes
+		if multipart {
+			own = record.MaxMultipartObjectBytes
+		}
+		if own != nil {
+			return *own, "tenant"
+		}
+	}
+	if limit, ok := perTenant[tenant]; ok {
+		return limit, "tenant"
+	}
+	return fallback, "default"
+}
+
+// checkObjectSize answers 413 and returns false when an object of size bytes
+// is over the tenant's limit.
+func checkObjectSize(w http.ResponseWriter, tenant string, size int64, multipart bool) bool {
+	limit, scope := objectSizeLimit(tenant, multipart)
+	if limit <= 0 || size <= limit {
+		return true
+	}
+	kind := limitObjectSize
+	if multipart {
+		kind = limitMultipartObjectSize
+	}
+	respondJSON(w, http.StatusRequestEntityTooLarge, ErrorResponse{
+		Error:   "Object too large",
+		Details: fmt.Sprintf("the object is %d bytes and the limit is %d", size, limit),
+		Limit:   &LimitHint{Kind: kind, Bytes: limit, Scope: scope, Size: size},
+	})
+	return false
+}
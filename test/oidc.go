@@ -0,0 +1,467 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// Browser users sign in through the OIDC authorization-code flow when
+	// OIDC_ISSUER, OIDC_CLIENT_ID and OIDC_REDIRECT_URL are set. The redirect
+	// URL must point at /api/auth/callback as the browser sees it.
+	oidcIssuer       = strings.TrimSuffix(os.Getenv("OIDC_ISSUER"), "/")
+	oidcClientID     = os.Getenv("OIDC_CLIENT_ID")
+	oidcClientSecret = os.Getenv("OIDC_CLIENT_SECRET")
+	oidcRedirectURL  = os.Getenv("OIDC_REDIRECT_URL")
+	oidcScopes       = envOr("OIDC_SCOPES", "openid profile email")
+	oidcTenantClaim  = envOr("OIDC_TENANT_CLAIM", "sub")
+
+	// Where the provider sends the browser after logout, if it supports
+	// RP-initiated logout
+	oidcPostLogoutURL = os.Getenv("OIDC_POST_LOGOUT_URL")
+
+	sessionCookieName = envOr("SESSION_COOKIE", "session")
+	sessionTTL        = durationFromEnv("SESSION_TTL", 8*time.Hour)
+	sessionSecret     = sessionSecretFromEnv()
+)
+
+const (
+	oidcFlowCookie = "oidc_flow"
+	oidcFlowTTL    = 10 * time.Minute
+)
+
+func oidcEnabled() bool {
+	return oidcIssuer != "" && oidcClientID != "" && oidcRedirectURL != ""
+}
+
+// sessionSecretFromEnv reads the key session cookies are signed with. Without
+// SESSION_SECRET a random key is used, so sessions end on restart and aren't
+// shared between instances.
+func sessionSecretFromEnv() []byte {
+	if secret := os.Getenv("SESSION_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	if oidcEnabled() && secretSources["SESSION_SECRET"] == "" {
+		slog.Warn("SESSION_SECRET is not set; sessions won't survive a restart")
+	}
+	return secret
+}
+
+// signValue encodes v as JSON with an HMAC so it can be handed to the browser
+// and trusted when it comes back.
+func signValue(v interface{}) (string, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, currentSetting(&sessionSecret))
+	mac.Write(payload)
+	encode := base64.RawURLEncoding.EncodeToString
+	return encode(payload) + "." + encode(mac.Sum(nil)), nil
+}
+
+var errBadSignature = errors.New("invalid signature")
+
+// openSignedValue checks a value produced by signValue and decodes it into v.
+func openSignedValue(signed string, v interface{}) error {
+	encodedPayload, encodedMAC, ok := strings.Cut(signed, ".")
+	if !ok {
+		return errBadSignature
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return errBadSignature
+	}
+	sum, err := base64.RawURLEncoding.DecodeString(encodedMAC)
+	if err != nil {
+		return errBadSignature
+	}
+	mac := hmac.New(sha256.New, currentSetting(&sessionSecret))
+	mac.Write(payload)
+	if !hmac.Equal(sum, mac.Sum(nil)) {
+		return errBadSignature
+	}
+	return json.Unmarshal(payload, v)
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// oidcProvider is the part of the provider's discovery document the login
+// flow needs.
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	EndSessionEndpoint    string `json:"end_session_endpoint"`
+}
+
+var (
+	oidcMu         sync.Mutex
+	oidcDiscovered *oidcProvider
+)
+
+// discoverOIDC fetches the provider's discovery document once and caches it.
+// Failures aren't cached so a provider outage doesn't outlive itself.
+func discoverOIDC(ctx context.Context) (*oidcProvider, error) {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	if oidcDiscovered != nil {
+		return oidcDiscovered, nil
+	}
+
+	wellKnown := oidcIssuer + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, wellKnown, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := jwksHTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", wellKnown, resp.Status)
+	}
+
+	var p oidcProvider
+	if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", wellKnown, err)
+	}
+	if strings.TrimSuffix(p.Issuer, "/") != oidcIssuer {
+		return nil, fmt.Errorf("discovery document is for issuer %q", p.Issuer)
+	}
+	if p.AuthorizationEndpoint == "" || p.TokenEndpoint == "" || p.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery document at %s is missing endpoints", wellKnown)
+	}
+
+	oidcDiscovered = &p
+	return oidcDiscovered, nil
+}
+
+// oidcFlow is what the browser carries, signed, between login and callback.
+type oidcFlow struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	ReturnTo string `json:"return_to"`
+	Expires  int64  `json:"exp"`
+}
+
+// session is the signed content of the session cookie.
+type session struct {
+	Subject string `json:"sub"`
+	Tenant  string `json:"tenant"`
+	Expires int64  `json:"exp"`
+}
+
+type SessionResponse struct {
+	Subject   string     `json:"subject"`
+	Tenant    string     `json:"tenant"`
+	Method    string     `json:"method"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+func setCookie(w http.ResponseWriter, name, value, path string, maxAge time.Duration) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		MaxAge:   int(maxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(oidcRedirectURL, "https://"),
+		// Lax lets the provider's redirect back carry the flow cookie while
+		// keeping the session off cross-site POSTs
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearCookie(w http.ResponseWriter, name, path string) {
+	setCookie(w, name, "", path, -time.Second)
+}
+
+// sessionPrincipal resolves the session cookie, if any, to a principal.
+func sessionPrincipal(r *http.Request) (principal, bool) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return principal{}, false
+	}
+	var s session
+	if openSignedValue(cookie.Value, &s) != nil || time.Now().Unix() >= s.Expires {
+		return principal{}, false
+	}
+	return principal{
+		Subject: s.Subject,
+		Tenant:  s.Tenant,
+		Method:  "session",
+		Claims:  map[string]interface{}{"sub": s.Subject, "exp": float64(s.Expires)},
+	}, true
+}
+
+// safeReturnTo only allows redirects back to paths on this site.
+func safeReturnTo(target string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		return "/"
+	}
+	return target
+}
+
+func oidcDisabled(w http.ResponseWriter) {
+	respondJSON(w, http.StatusNotFound, ErrorResponse{
+		Error: "OIDC login is disabled",
+	})
+}
+
+// loginHandler starts the authorization-code flow with PKCE and sends the
+// browser to the provider.
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	if !oidcEnabled() {
+		oidcDisabled(w)
+		return
+	}
+
+	provider, err := discoverOIDC(r.Context())
+	if err != nil {
+		respondJSON(w, http.StatusBadGateway, ErrorResponse{
+			Error:   "Identity provider unavailable",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	flow := oidcFlow{
+		State:    randomToken(),
+		Nonce:    randomToken(),
+		Verifier: randomToken(),
+		ReturnTo: safeReturnTo(r.URL.Query().Get("return_to")),
+		Expires:  time.Now().Add(oidcFlowTTL).Unix(),
+	}
+	signed, err := signValue(flow)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to start login",
+			Details: err.Error(),
+		})
+		return
+	}
+	setCookie(w, oidcFlowCookie, signed, "/api/auth", oidcFlowTTL)
+
+	challenge := sha256.Sum256([]byte(flow.Verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {oidcClientID},
+		"redirect_uri":          {oidcRedirectURL},
+		"scope":                 {oidcScopes},
+		"state":                 {flow.State},
+		"nonce":                 {flow.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(provider.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	http.Redirect(w, r, provider.AuthorizationEndpoint+separator+query.Encode(), http.StatusFound)
+}
+
+// exchangeCode redeems an authorization code for the provider's ID token.
+func exchangeCode(ctx context.Context, provider *oidcProvider, code, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {oidcRedirectURL},
+		"code_verifier": {verifier},
+	}
+	clientSecret := currentSetting(&oidcClientSecret)
+	if clientSecret == "" {
+		form.Set("client_id", oidcClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(oidcClientID), url.QueryEscape(clientSecret))
+	}
+
+	resp, err := jwksHTTP.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("parsing token response: %w", err)
+	}
+	if body.Error != "" {
+		return "", fmt.Errorf("%s: %s", body.Error, body.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint returned %s", resp.Status)
+	}
+	if body.IDToken == "" {
+		return "", errors.New("token response has no id_token")
+	}
+	return body.IDToken, nil
+}
+
+// callbackHandler finishes the flow: it checks the state, redeems the code,
+// verifies the ID token and sets the session cookie.
+func callbackHandler(w http.ResponseWriter, r *http.Request) {
+	if !oidcEnabled() {
+		oidcDisabled(w)
+		return
+	}
+
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		respondJSON(w, http.StatusUnauthorized, ErrorResponse{
+			Error:   "Login failed",
+			Details: strings.TrimSpace(errCode + ": " + query.Get("error_description")),
+		})
+		return
+	}
+
+	var flow oidcFlow
+	cookie, err := r.Cookie(oidcFlowCookie)
+	if err != nil || openSignedValue(cookie.Value, &flow) != nil || time.Now().Unix() >= flow.Expires {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "Login session expired",
+			Details: "start again from /api/auth/login",
+		})
+		return
+	}
+	clearCookie(w, oidcFlowCookie, "/api/auth")
+
+	if !hmac.Equal([]byte(query.Get("state")), []byte(flow.State)) {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid login state",
+		})
+		return
+	}
+
+	ctx := r.Context()
+	provider, err := discoverOIDC(ctx)
+	if err != nil {
+		respondJSON(w, http.StatusBadGateway, ErrorResponse{
+			Error:   "Identity provider unavailable",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	idToken, err := exchangeCode(ctx, provider, query.Get("code"), flow.Verifier)
+	if err != nil {
+		respondJSON(w, http.StatusBadGateway, ErrorResponse{
+			Error:   "Token exchange failed",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	claims, err := verifyJWT(ctx, keySetFor(provider.JWKSURI), idToken, provider.Issuer, oidcClientID)
+	if err == nil {
+		if nonce, _ := claims["nonce"].(string); !hmac.Equal([]byte(nonce), []byte(flow.Nonce)) {
+			err = errors.New("nonce does not match")
+		}
+	}
+	if err != nil {
+		respondJSON(w, http.StatusUnauthorized, ErrorResponse{
+			Error:   "Invalid ID token",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	p, err := principalFromClaims(claims, "oidc", oidcTenantClaim)
+	if err != nil {
+		respondJSON(w, http.StatusForbidden, ErrorResponse{
+			Error:   "Account has no tenant",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	signed, err := signValue(session{
+		Subject: p.Subject,
+		Tenant:  p.Tenant,
+		Expires: time.Now().Add(sessionTTL).Unix(),
+	})
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to create session",
+			Details: err.Error(),
+		})
+		return
+	}
+	setCookie(w, sessionCookieName, signed, "/", sessionTTL)
+	http.Redirect(w, r, flow.ReturnTo, http.StatusFound)
+}
+
+// logoutHandler drops the session cookie and, when the provider supports
+// it, ends the provider session too.
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if !oidcEnabled() {
+		oidcDisabled(w)
+		return
+	}
+	clearCookie(w, sessionCookieName, "/")
+
+	target := "/"
+	if provider, err := discoverOIDC(r.Context()); err == nil && provider.EndSessionEndpoint != "" {
+		query := url.Values{"client_id": {oidcClientID}}
+		if oidcPostLogoutURL != "" {
+			query.Set("post_logout_redirect_uri", oidcPostLogoutURL)
+		}
+		target = provider.EndSessionEndpoint + "?" + query.Encode()
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// sessionHandler tells the browser UI who it is signed in as.
+func sessionHandler(w http.ResponseWriter, r *http.Request) {
+	p, ok := requestPrincipal(r)
+	if !ok {
+		respondJSON(w, http.StatusOK, SessionResponse{Subject: defaultTenant, Tenant: defaultTenant, Method: "none"})
+		return
+	}
+
+	resp := SessionResponse{Subject: p.Subject, Tenant: p.Tenant, Method: p.Method}
+	if exp, err := jwt.MapClaims(p.Claims).GetExpirationTime(); err == nil && exp != nil {
+		resp.ExpiresAt = &exp.Time
+	}
+	respondJSON(w, http.StatusOK, resp)
+}
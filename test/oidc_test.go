@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// fakeIdP is an OIDC provider that issues an ID token for one code.
+type fakeIdP struct {
+	*httptest.Server
+	key       *ecdsa.PrivateKey
+	claims    jwt.MapClaims
+	challenge string
+	nonce     string
+}
+
+func newFakeIdP(t *testing.T, claims jwt.MapClaims) *fakeIdP {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &fakeIdP{key: key, claims: claims}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcProvider{
+			Issuer:                idp.URL,
+			AuthorizationEndpoint: idp.URL + "/authorize",
+			TokenEndpoint:         idp.URL + "/token",
+			JWKSURI:               idp.URL + "/jwks",
+			EndSessionEndpoint:    idp.URL + "/logout",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		encode := base64.RawURLEncoding.EncodeToString
+		json.NewEncoder(w).Encode(map[string][]jwk{"keys": {{
+			Kid: "k1", Kty: "EC", Crv: "P-256",
+			X: encode(key.X.FillBytes(make([]byte, 32))),
+			Y: encode(key.Y.FillBytes(make([]byte, 32))),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if r.PostFormValue("code") != "good-code" || base64.RawURLEncoding.EncodeToString(sum[:]) != idp.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		claims := jwt.MapClaims{
+			"iss":   idp.URL,
+			"aud":   oidcClientID,
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"nonce": idp.nonce,
+		}
+		for k, v := range idp.claims {
+			claims[k] = v
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+		token.Header["kid"] = "k1"
+		signed, _ := token.SignedString(key)
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed})
+	})
+
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+
+	override(t, &oidcIssuer, idp.URL)
+	override(t, &oidcClientID, "files-ui")
+	override(t, &oidcRedirectURL, "http://app.example/api/auth/callback")
+	override(t, &oidcDiscovered, nil)
+	return idp
+}
+
+// login runs the flow up to the callback and returns the callback response.
+func (idp *fakeIdP) login(t *testing.T, srv *httptest.Server, code string) response {
+	t.Helper()
+	start := call(t, srv, "GET", "/api/auth/login?return_to=/dashboard", nil)
+	expectStatus(t, start, http.StatusFound)
+
+	authorize, err := url.Parse(start.Header.Get("Location"))
+	if err != nil || !strings.HasPrefix(authorize.String(), idp.URL+"/authorize?") {
+		t.Fatalf("redirected to %q", start.Header.Get("Location"))
+	}
+	q := authorize.Query()
+	idp.challenge, idp.nonce = q.Get("code_challenge"), q.Get("nonce")
+
+	callback := "/api/auth/callback?" + url.Values{"code": {code}, "state": {q.Get("state")}}.Encode()
+	return call(t, srv, "GET", callback, nil, "Cookie", cookieHeader(start, oidcFlowCookie))
+}
+
+func cookieHeader(resp response, name string) string {
+	for _, c := range resp.Cookies() {
+		if c.Name == name {
+			return name + "=" + c.Value
+		}
+	}
+	return ""
+}
+
+func TestOIDCLogin(t *testing.T) {
+	srv, _ := newTestServer(t)
+	idp := newFakeIdP(t, jwt.MapClaims{"sub": "acme"})
+
+	expectStatus(t, call(t, srv, "GET", "/api/files", nil), http.StatusUnauthorized)
+
+	done := idp.login(t, srv, "good-code")
+	expectStatus(t, done, http.StatusFound)
+	if got := done.Header.Get("Location"); got != "/dashboard" {
+		t.Fatalf("returned to %q", got)
+	}
+	cookie := cookieHeader(done, sessionCookieName)
+	if cookie == "" {
+		t.Fatal("no session cookie set")
+	}
+
+	mustUpload(t, srv, "/api", "notes.txt", "from the browser", "Cookie", cookie)
+
+	var who SessionResponse
+	call(t, srv, "GET", "/api/auth/session", nil, "Cookie", cookie).decode(t, &who)
+	if who.Tenant != "acme" || who.Method != "session" || who.ExpiresAt == nil {
+		t.Fatalf("session %+v", who)
+	}
+
+	out := call(t, srv, "GET", "/api/auth/logout", nil, "Cookie", cookie)
+	expectStatus(t, out, http.StatusFound)
+	if !strings.HasPrefix(out.Header.Get("Location"), idp.URL+"/logout?") {
+		t.Fatalf("logout redirected to %q", out.Header.Get("Location"))
+	}
+	if cookieHeader(out, sessionCookieName) != sessionCookieName+"=" {
+		t.Fatal("session cookie not cleared")
+	}
+}
+
+func TestOIDCRejections(t *testing.T) {
+	srv, _ := newTestServer(t)
+	idp := newFakeIdP(t, jwt.MapClaims{"sub": "acme"})
+
+	resp := idp.login(t, srv, "bad-code")
+	expectStatus(t, resp, http.StatusBadGateway)
+
+	resp = call(t, srv, "GET", "/api/auth/callback?code=good-code&state=forged", nil)
+	expectStatus(t, resp, http.StatusBadRequest)
+	if got := resp.errorMessage(t); got != "Login session expired" {
+		t.Fatalf("error %q", got)
+	}
+
+	idp.claims = jwt.MapClaims{"sub": "not a tenant!"}
+	expectStatus(t, idp.login(t, srv, "good-code"), http.StatusForbidden)
+
+	expectStatus(t, call(t, srv, "GET", "/api/files", nil, "Cookie", sessionCookieName+"=e30.forged"), http.StatusUnauthorized)
+
+	start := call(t, srv, "GET", "/api/auth/login?return_to=//evil.example", nil)
+	var flow oidcFlow
+	if err := openSignedValue(strings.TrimPrefix(cookieHeader(start, oidcFlowCookie), oidcFlowCookie+"="), &flow); err != nil || flow.ReturnTo != "/" {
+		t.Fatalf("return_to %q (%v)", flow.ReturnTo, err)
+	}
+}
@@ -0,0 +1,538 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// How an operation answers on success. Most answer JSON; the rest are
+// described by content type so clients know not to decode them.
+const (
+	bodyJSON     = ""
+	bodyBinary   = "binary"
+	bodyText     = "text"
+	bodyHTML     = "html"
+	bodyAtom     = "atom"
+	bodyEvents   = "events"
+	bodyRedirect = "redirect"
+	bodyNone     = "none"
+)
+
+// operation documents one handler for the OpenAPI spec the SDKs are
+// generated from. Every routed handler needs an entry in operations.
+type operation struct {
+	Request  interface{}
+	Example  interface{}
+	Form     interface{}
+	Response interface{}
+	Body     string
+	Status   int
+	Query    []queryParam
+	Headers  []queryParam
+}
+
+type queryParam struct {
+	Name        string
+	Type        string
+	Description string
+}
+
+var operations = map[string]operation{
+	"health": {Response: HealthResponse{}, Query: []queryParam{
+		{"deep", "boolean", "Also list each bucket and report reachability, credentials and existence separately"},
+	}},
+	"openAPI":      {Response: map[string]interface{}{}},
+	"collection":   {Response: map[string]interface{}{}},
+	"console":      {Body: bodyHTML},
+	"consoleAsset": {Body: bodyText},
+	"shareDownload": {Body: bodyBinary, Headers: []queryParam{
+		{sharePasswordHeader, "string", "Password of a protected link"},
+	}},
+	"shareUnlock": {Form: SharePasswordForm{}, Body: bodyBinary},
+	"publicFile": {Body: bodyBinary, Headers: []queryParam{
+		{"If-None-Match", "string", "ETag of a cached copy, answered with 304 when unchanged"},
+		{"Range", "string", "A single byte range of the content as uploaded, answered with 206"},
+	}, Query: []queryParam{
+		{"mirror", "string", "best redirects to the fastest mirror that is up, if any"},
+	}},
+	"publicTorrent": {Body: bodyBinary},
+	"publicMirrors": {Response: MirrorsResponse{}},
+	"getActionsCache": {Response: ActionsCacheEntry{}, Query: []queryParam{
+		{"keys", "string", "Comma-separated cache key, then restore keys, matched as prefixes"},
+		{"version", "string", "Hash of the cached paths and compression, which must match exactly"},
+	}},
+	"reserveActionsCache": {Request: ActionsCacheReserveRequest{}, Response: ActionsCacheReserveResponse{}, Status: http.StatusCreated, Example: ActionsCacheReserveRequest{Key: "npm-linux-5f2e", Version: "b7c0e1"}},
+	"uploadActionsCache": {Body: bodyNone, Status: http.StatusNoContent, Headers: []queryParam{
+		{"Content-Range", "string", "bytes <start>-<end>/* of the chunk in the archive; start is a multiple of 1 MiB"},
+	}},
+	"commitActionsCache":   {Request: ActionsCacheCommitRequest{}, Body: bodyNone, Status: http.StatusNoContent, Example: ActionsCacheCommitRequest{Size: 1048576}},
+	"actionsCacheProgress": {Body: bodyEvents},
+	"downloadActionsCache": {Body: bodyBinary, Headers: []queryParam{
+		{"Range", "string", "A single byte range of the archive, answered with 206"},
+	}},
+	"login": {Body: bodyRedirect, Query: []queryParam{
+		{"return_to", "string", "Same-site path to return to after signing in"},
+	}},
+	"callback": {Body: bodyRedirect, Query: []queryParam{
+		{"code", "string", "Authorization code from the provider"},
+		{"state", "string", "State the login started with"},
+	}},
+	"logout": {Body: bodyRedirect},
+
+	"tailFile": {Body: bodyText, Query: []queryParam{
+		{"lines", "integer", "Number of lines to return"},
+		{"follow", "boolean", "Keep the response open and stream appended data"},
+	}},
+	"fileEventsSocket": {Body: bodyNone, Status: http.StatusSwitchingProtocols, Query: []queryParam{
+		{"prefix", "string", "Only changes to files under this prefix, e.g. reports/"},
+	}},
+	"upload": {Request: UploadRequest{}, Response: MessageResponse{}, Example: UploadRequest{
+		Filename: "reports/q3.txt", Content: "aGVsbG8gd29ybGQ=", OnConflict: conflictNumber,
+	}, Query: []queryParam{
+		{"on_conflict", "string", "What to do when the filename is taken: overwrite, number, timestamp or hash"},
+		{"overwrite", "boolean", "Whether an existing file may be replaced; false answers 409 instead"},
+	}, Headers: []queryParam{
+		{"If-Match", "string", "Only overwrite the file if its ETag is still this one, or * for any existing version"},
+		{"If-None-Match", "string", "* to answer 409 rather than replace an existing file"},
+	}},
+	"listFiles": {Response: FilesResponse{}, Query: []queryParam{
+		{"scan_status", "boolean", "Whether to include each file's virus scan status"},
+	}},
+	"renderFile": {Body: bodyHTML},
+	"filesFeed": {Body: bodyAtom, Query: []queryParam{
+		{"prefix", "string", "Only files under this prefix, e.g. releases/"},
+	}},
+	"fileChecksums": {Response: FileChecksums{}, Query: []queryParam{
+		{"hash", "string", "Comma-separated digests to return, e.g. md5,sha1 (the default)"},
+	}},
+	"checksumFile": {Body: bodyText, Query: []queryParam{
+		{"prefix", "string", "Only files under this prefix; names are listed relative to it"},
+		{"hash", "string", "Digest to list, md5 by default"},
+	}},
+	"browse":         {Body: bodyHTML},
+	"headBrowse":     {Body: bodyBinary},
+	"listVersions":   {Response: VersionsResponse{}},
+	"restoreVersion": {Response: RestoreResponse{}},
+	"fileMetadata":   {Response: FileMetadata{}},
+	"getLegalHold":   {Response: LegalHoldResponse{}},
+	"createShare":    {Request: ShareRequest{}, Response: ShareResponse{}, Status: http.StatusCreated, Example: ShareRequest{ExpiresIn: "24h", MaxDownloads: 3}},
+	"getACL":         {Response: ACLResponse{}},
+	"grantAccess":    {Request: ACLGrant{}, Response: ACLResponse{}, Example: ACLGrant{Principal: "bob", Permission: permRead}},
+	"revokeAccess": {Response: ACLResponse{}, Query: []queryParam{
+		{"principal", "string", "Principal whose grant to remove"},
+	}},
+	"setLegalHold": {Request: LegalHoldRequest{}, Response: LegalHoldResponse{}, Example: LegalHoldRequest{Enabled: true}},
+	"getFile": {Body: bodyBinary, Query: []queryParam{
+		{"version_id", "string", "Fetch this version instead of the current one"},
+	}},
+	"deleteFile": {Response: MessageResponse{}, Headers: []queryParam{
+		{"If-Match", "string", "Only delete the file if its ETag is still this one"},
+	}},
+	"createFolder": {Request: FolderRequest{}, Response: MessageResponse{}, Status: http.StatusCreated, Example: FolderRequest{Path: "reports/2024"}},
+	"deleteFolder": {Response: FolderDeleteResponse{}, Query: []queryParam{
+		{"dry_run", "boolean", "List what would be deleted without deleting it"},
+	}},
+	"listTrash": {Response: TrashResponse{}},
+	"restoreTrash": {Response: MessageResponse{}, Query: []queryParam{
+		{"overwrite", "boolean", "Replace a file that has since taken the name"},
+	}},
+	"getMirrors":   {Response: MirrorsResponse{}},
+	"setMirrors":   {Request: MirrorsRequest{}, Response: MirrorsResponse{}, Example: MirrorsRequest{URLs: []string{"https://mirror.example.com/releases/app-1.4.0.tar.gz"}}},
+	"listChannels": {Response: ChannelsResponse{}},
+	"getChannel": {Response: Channel{}, Query: []queryParam{
+		{"at", "string", "Show the channel as it stood at this RFC 3339 time"},
+	}},
+	"downloadChannel": {Body: bodyBinary, Query: []queryParam{
+		{"at", "string", "Download what the channel served at this RFC 3339 time"},
+	}},
+	"promoteChannel":  {Request: PromoteRequest{}, Response: Channel{}, Example: PromoteRequest{Filename: "releases/app-1.4.0.tar.gz", Note: "1.4.0"}},
+	"rollbackChannel": {Request: RollbackRequest{}, Response: Channel{}, Example: RollbackRequest{Note: "1.4.0 crashes on start"}},
+	"listAliases": {Response: AliasesResponse{}, Query: []queryParam{
+		{"target", "string", "Only aliases of this file"},
+	}},
+	"getAlias": {Response: Alias{}},
+	"putAlias": {Request: AliasRequest{}, Response: Alias{}, Status: http.StatusCreated, Example: AliasRequest{Target: "releases/app-1.4.0.tar.gz"}, Headers: []queryParam{
+		{"If-Match", "string", "Repoint the alias only if its ETag is still this one"},
+		{"If-None-Match", "string", "* to only create the alias"},
+	}},
+	"deleteAlias": {Status: http.StatusNoContent, Body: bodyNone},
+	"setLatest": {Request: LatestRequest{}, Response: Alias{}, Example: LatestRequest{Target: "releases/app-1.5.0.tar.gz"}, Headers: []queryParam{
+		{"If-Match", "string", "Move the pointer only if its ETag still matches"},
+	}},
+	"graphql": {Request: GraphQLRequest{}, Response: map[string]interface{}{}, Example: GraphQLRequest{
+		Query: `{ files(prefix: "reports/", first: 10) { nodes { name size tags { key value } } endCursor hasNextPage } }`,
+	}},
+	"graphqlSchema": {Body: bodyText},
+
+	"getBuildCache": {Body: bodyBinary},
+	"putBuildCache": {Body: bodyNone, Status: http.StatusCreated},
+
+	"capabilities":      {Response: CapabilitiesResponse{}},
+	"session":           {Response: SessionResponse{}},
+	"usage":             {Response: UsageResponse{}},
+	"usageCosts":        {Response: CostsResponse{}},
+	"replicationStatus": {Response: ReplicationStatus{}},
+	"createExport": {Request: ExportRequest{}, Response: Job{}, Status: http.StatusAccepted, Example: ExportRequest{Source: exportSourceList}, Headers: []queryParam{
+		{idempotencyHeader, "string", "Retrying with the same key returns the job it started"},
+	}},
+	"getExport":          {Response: Job{}},
+	"listBuckets":        {Response: BucketsResponse{}},
+	"debugObject":        {Response: ObjectDebugResponse{}},
+	"createCaptureToken": {Request: CaptureTokenRequest{}, Response: CaptureTokenResponse{}, Status: http.StatusCreated, Example: CaptureTokenRequest{ExpiresIn: "1h"}},
+	"listCaptures":       {Response: CapturesResponse{}},
+	"getCapture":         {Response: CapturedExchange{}},
+	"deleteCapture":      {Response: MessageResponse{}},
+	"shadowStatus":       {Response: ShadowStatus{}},
+	"latencySLOs":        {Response: SLOStatusResponse{}},
+	"listPolicies":       {Response: PoliciesResponse{}},
+	"createPolicy":       {Request: PolicyRule{}, Response: PolicyRule{}, Status: http.StatusCreated, Example: PolicyRule{Subject: "alice", Action: "get*", Resource: "reports/*", Effect: effectAllow}},
+	"getPolicy":          {Response: PolicyRule{}},
+	"updatePolicy":       {Request: PolicyRule{}, Response: PolicyRule{}, Example: PolicyRule{Subject: "*", Action: "deleteFile", Resource: "*", Effect: effectDeny}},
+	"deletePolicy":       {Response: MessageResponse{}},
+	"listFreezes":        {Response: FreezesResponse{}},
+	"createFreeze":       {Request: FreezeRequest{}, Response: Freeze{}, Status: http.StatusCreated, Example: FreezeRequest{Prefix: "releases/", Reason: "Release 4.2 deploy window", Duration: "2h"}},
+	"deleteFreeze":       {Response: MessageResponse{}},
+	"listWebhooks":       {Response: WebhooksResponse{}},
+	"listPlugins":        {Response: PluginsResponse{}},
+	"enablePlugin":       {Request: PluginEnableRequest{}, Response: PluginEnablement{}, Example: PluginEnableRequest{Tenants: []string{"acme"}, Pattern: "*.pdf", Config: json.RawMessage(`{"max_pages": 200}`)}},
+	"disablePlugin":      {Response: MessageResponse{}},
+	"createWebhook":      {Request: WebhookRequest{}, Response: Webhook{}, Status: http.StatusCreated, Example: WebhookRequest{URL: "https://hooks.example.com/files", Events: []string{"upload"}, Prefix: "incoming/"}},
+	"deleteWebhook":      {Response: MessageResponse{}},
+	"listTenants":        {Response: TenantsResponse{}},
+	"createTenant": {Request: CreateTenantRequest{}, Response: TenantJobResponse{}, Status: http.StatusAccepted, Example: CreateTenantRequest{
+		ID: "acme", QuotaBytes: aws.Int64(10 << 30), Webhook: &TenantWebhook{URL: "https://hooks.example.com/files", Events: []string{"upload", "delete"}},
+	}},
+	"getTenant": {Response: TenantRecord{}},
+	"offboardTenant": {Request: OffboardTenantRequest{}, Response: TenantJobResponse{}, Status: http.StatusAccepted, Example: OffboardTenantRequest{Purge: aws.Bool(true)}, Headers: []queryParam{
+		{idempotencyHeader, "string", "Retrying with the same key returns the job it started"},
+	}},
+	"getJob":              {Response: Job{}},
+	"createBillingReport": {Request: BillingReportRequest{}, Response: Job{}, Status: http.StatusAccepted, Example: BillingReportRequest{Month: "2024-05", Push: true}},
+	"getBillingReport": {Response: BillingReport{}, Query: []queryParam{
+		{"format", "string", "json (the default) or csv"},
+	}},
+	"createStatusReport": {Request: StatusReportRequest{}, Response: Job{}, Status: http.StatusAccepted, Example: StatusReportRequest{Week: "2024-W19", Send: true}},
+	"getStatusReport":    {Response: StatusReport{}},
+	"audit":              {Response: AuditResponse{}, Query: auditQueryParams},
+	"adminAudit": {Response: AuditResponse{}, Query: append([]queryParam{
+		{"tenant", "string", "Tenant whose records to read; omit for the admin API's own"},
+	}, auditQueryParams...)},
+	"adminCosts": {Response: CostsResponse{}, Query: []queryParam{
+		{"tenant", "string", "Tenant to report on; omit for all of them"},
+	}},
+	"getLogLevel": {Response: LogLevelResponse{}},
+	"setLogLevel": {Request: LogLevelRequest{}, Response: LogLevelResponse{}, Example: LogLevelRequest{Level: "debug"}},
+	"regions":     {Response: RegionsResponse{}},
+	"listReviews": {Response: ReviewsResponse{}, Query: []queryParam{
+		{"status", "string", "Only uploads in this state: pending, approved or rejected"},
+	}},
+	"getReview": {Response: ReviewRecord{}},
+	"adminListReviews": {Response: ReviewsResponse{}, Query: []queryParam{
+		{"tenant", "string", "Tenant whose uploads to list; omit for the default one"},
+		{"status", "string", "Only uploads in this state: pending, approved or rejected"},
+	}},
+	"decideReview": {Request: ReviewDecisionRequest{}, Response: ReviewRecord{}, Example: ReviewDecisionRequest{Decision: decisionReject, Reason: "contains personal data"}, Query: []queryParam{
+		{"tenant", "string", "Tenant the upload belongs to; omit for the default one"},
+	}},
+}
+
+var auditQueryParams = []queryParam{
+	{"from", "string", "Earliest record time, RFC 3339; defaults to a day before to"},
+	{"to", "string", "Latest record time, RFC 3339; defaults to now"},
+	{"actor", "string", "Only records made by this subject"},
+	{"action", "string", "Only records of this operation, e.g. upload"},
+	{"key", "string", "Only records whose file name starts with this"},
+	{"result", "string", "Only records that ended this way: success, denied or failed"},
+	{"limit", "integer", "Most records to return, newest first"},
+}
+
+// operationID names an operation after its handler, e.g. getFileHandler is
+// getFile.
+func operationID(h http.HandlerFunc) string {
+	name := runtime.FuncForPC(reflect.ValueOf(h).Pointer()).Name()
+	name = name[strings.LastIndex(name, ".")+1:]
+	return strings.TrimSuffix(name, "Handler")
+}
+
+var pathParamPattern = regexp.MustCompile(`\{(\w+)(:[^}]*)?\}`)
+
+// openAPIPath drops the patterns from mux path variables.
+func openAPIPath(path string) (string, []string) {
+	var params []string
+	for _, m := range pathParamPattern.FindAllStringSubmatch(path, -1) {
+		params = append(params, m[1])
+	}
+	return pathParamPattern.ReplaceAllString(path, "{$1}"), params
+}
+
+// buildOpenAPISpec describes every route in g. Routes served again under
+// /api/buckets/{bucket} get their own operations, suffixed InBucket.
+func buildOpenAPISpec(g routeGroup) (map[string]interface{}, error) {
+	schemas := schemaSet{}
+	paths := map[string]map[string]interface{}{}
+	var missing []string
+
+	walkRoutes(g, "", nil, func(rt registeredRoute, _ []middleware) {
+		if undocumentedGroups[rt.Group] {
+			return
+		}
+		id := operationID(rt.Handler)
+		op, ok := operations[id]
+		if !ok {
+			missing = append(missing, id)
+			return
+		}
+		path, params := openAPIPath(rt.Path)
+		if strings.HasPrefix(path, "/api/buckets/{bucket}/") {
+			id += "InBucket"
+		}
+
+		var parameters []interface{}
+		for _, name := range params {
+			parameters = append(parameters, map[string]interface{}{
+				"name": name, "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"},
+			})
+		}
+		for _, q := range op.Query {
+			parameters = append(parameters, map[string]interface{}{
+				"name": q.Name, "in": "query", "description": q.Description, "schema": map[string]interface{}{"type": q.Type},
+			})
+		}
+		for _, h := range op.Headers {
+			parameters = append(parameters, map[string]interface{}{
+				"name": h.Name, "in": "header", "description": h.Description, "schema": map[string]interface{}{"type": h.Type},
+			})
+		}
+
+		status := op.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := map[string]interface{}{"description": http.StatusText(status)}
+		switch op.Body {
+		case bodyJSON:
+			success["content"] = jsonContent(schemas.of(reflect.TypeOf(op.Response)))
+		case bodyBinary:
+			success["content"] = map[string]interface{}{
+				"application/octet-stream": map[string]interface{}{"schema": map[string]interface{}{"type": "string", "format": "binary"}},
+			}
+		case bodyText:
+			success["content"] = map[string]interface{}{
+				"text/plain": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+			}
+		case bodyHTML:
+			success["content"] = map[string]interface{}{
+				"text/html": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+			}
+		case bodyAtom:
+			success["content"] = map[string]interface{}{
+				"application/atom+xml": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+			}
+		case bodyEvents:
+			success["content"] = map[string]interface{}{
+				"text/event-stream": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+			}
+		case bodyRedirect:
+			status = http.StatusFound
+			success["description"] = "Redirect"
+		case bodyNone:
+		}
+
+		spec := map[string]interface{}{
+			"operationId": id,
+			"summary":     rt.Summary,
+			"tags":        []string{rt.Group},
+			"responses": map[string]interface{}{
+				fmt.Sprint(status): success,
+				"default": map[string]interface{}{
+					"description": "Error",
+					"content":     jsonContent(schemas.of(reflect.TypeOf(ErrorResponse{}))),
+				},
+			},
+		}
+		if parameters != nil {
+			spec["parameters"] = parameters
+		}
+		switch {
+		case unauthenticatedGroups[rt.Group]:
+			spec["security"] = []interface{}{}
+		case rt.Group == "admin":
+			spec["security"] = []interface{}{map[string]interface{}{"adminToken": []string{}}}
+		}
+		switch {
+		case op.Request != nil:
+			media := map[string]interface{}{"schema": schemas.of(reflect.TypeOf(op.Request))}
+			if op.Example != nil {
+				media["example"] = op.Example
+			}
+			spec["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  map[string]interface{}{"application/json": media},
+			}
+		case op.Form != nil:
+			spec["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/x-www-form-urlencoded": map[string]interface{}{"schema": schemas.of(reflect.TypeOf(op.Form))},
+				},
+			}
+		}
+
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+		paths[path][strings.ToLower(rt.Method)] = spec
+	})
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("no OpenAPI operation for handlers %s", strings.Join(missing, ", "))
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "test-api",
+			"version": apiVersion(),
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"apiKey": map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+				"bearer": map[string]interface{}{"type": "http", "scheme": "bearer"},
+				"adminToken": map[string]interface{}{
+					"type": "http", "scheme": "bearer", "description": "ADMIN_TOKEN, for the admin routes",
+				},
+			},
+		},
+		"security": []interface{}{
+			map[string]interface{}{},
+			map[string]interface{}{"apiKey": []string{}},
+			map[string]interface{}{"bearer": []string{}},
+		},
+	}, nil
+}
+
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+// schemaSet collects the named types a spec refers to, as JSON schemas.
+type schemaSet map[string]interface{}
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	rawJSONType = reflect.TypeOf(json.RawMessage(nil))
+	ownPkgPath  = reflect.TypeOf(operation{}).PkgPath()
+)
+
+// of returns the schema for values of t as the API encodes them. Structs of
+// this package become named components; types from dependencies are left
+// as free-form objects.
+func (s schemaSet) of(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == rawJSONType:
+		// Passed through as given, so any JSON value
+		return map[string]interface{}{}
+	case t.Kind() == reflect.Struct && t.Name() != "" && t.PkgPath() != ownPkgPath:
+		return map[string]interface{}{"type": "object"}
+	case t.Kind() == reflect.Struct && t.Name() != "":
+		if _, ok := s[t.Name()]; !ok {
+			// Placeholder first, in case the type refers to itself
+			s[t.Name()] = nil
+			s[t.Name()] = s.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		return s.object(t)
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": s.of(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.of(t.Elem())}
+	}
+	// interface{} holds anything
+	return map[string]interface{}{}
+}
+
+// object describes a struct the way encoding/json encodes it. Fields without
+// omitempty are always present, so they are required.
+func (s schemaSet) object(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = s.of(f.Type)
+		if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if required != nil {
+		schema["required"] = required
+	}
+	return schema
+}
+
+var openAPISpec struct {
+	once sync.Once
+	spec map[string]interface{}
+	err  error
+}
+
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	openAPISpec.once.Do(func() {
+		openAPISpec.spec, openAPISpec.err = buildOpenAPISpec(apiRoutes())
+	})
+	if openAPISpec.err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to build the OpenAPI spec",
+			Details: openAPISpec.err.Error(),
+		})
+		return
+	}
+
+	setCacheControl(w, "public", time.Hour)
+	respondJSON(w, http.StatusOK, openAPISpec.spec)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"reflect"
+	"testing"
+)
+
+var updateSpec = flag.Bool("update", false, "rewrite the OpenAPI spec the SDKs are generated from")
+
+// The TypeScript SDK is generated from this copy of the spec, so it must
+// match the routes. Run go test -run TestOpenAPISpecUpToDate -update after
+// changing them, then regenerate the SDK.
+const specPath = "sdk/typescript/openapi.json"
+
+func TestOpenAPISpecUpToDate(t *testing.T) {
+	t.Setenv("API_VERSION", "")
+	spec, err := buildOpenAPISpec(apiRoutes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = append(got, '\n')
+
+	if *updateSpec {
+		if err := os.WriteFile(specPath, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(specPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("%s is out of date; run go test -run TestOpenAPISpecUpToDate -update", specPath)
+	}
+}
+
+func TestOpenAPISpecServed(t *testing.T) {
+	srv, _ := newTestServer(t)
+	resp := call(t, srv, "GET", "/api/openapi.json", nil)
+	expectStatus(t, resp, http.StatusOK)
+
+	var spec struct {
+		Paths map[string]map[string]struct {
+			OperationID string `json:"operationId"`
+		} `json:"paths"`
+	}
+	resp.decode(t, &spec)
+	tests := []struct{ path, method, id string }{
+		{"/api/files/{filename}", "get", "getFile"},
+		{"/api/buckets/{bucket}/files/{filename}", "get", "getFileInBucket"},
+		{"/api/files/{filename}/share", "post", "createShare"},
+		{"/api/share/{token}", "get", "shareDownload"},
+	}
+	for _, tt := range tests {
+		if got := spec.Paths[tt.path][tt.method].OperationID; got != tt.id {
+			t.Errorf("%s %s is %q, want %q", tt.method, tt.path, got, tt.id)
+		}
+	}
+}
+
+func TestOpenAPISchemas(t *testing.T) {
+	schemas := schemaSet{}
+	schemas.of(reflect.TypeOf(SessionResponse{}))
+	session, _ := json.Marshal(schemas["SessionResponse"])
+	want := `{"properties":{"expires_at":{"format":"date-time","type":"string"},"method":{"type":"string"},"subject":{"type":"string"},"tenant":{"type":"string"}},"required":["subject","tenant","method"],"type":"object"}`
+	if string(session) != want {
+		t.Fatalf("schema %s", session)
+	}
+}
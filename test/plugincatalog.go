@@ -0,0 +1,513 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+const (
+	pluginPrefix      = ".plugins/"
+	pluginsEnabledKey = pluginPrefix + "enabled.json"
+
+	pluginManifestFile = "plugin.json"
+	pluginHookUpload   = "upload"
+)
+
+var (
+	// UPLOAD_PLUGIN_DIR holds the plugins operators can enable, a manifest
+	// and module per version, e.g. plugins/scan/1.2.0/plugin.json
+	pluginDir = os.Getenv("UPLOAD_PLUGIN_DIR")
+	// Other instances pick up enabled plugins within this long
+	pluginReloadInterval = durationFromEnv("PLUGIN_RELOAD_INTERVAL", 10*time.Second)
+
+	pluginNamePattern    = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+	pluginVersionPattern = regexp.MustCompile(`^[0-9A-Za-z][0-9A-Za-z.+-]*$`)
+)
+
+// pluginHooks are the points a manifest can ask to run its plugin at.
+var pluginHooks = []string{pluginHookUpload}
+
+// PluginManifest describes one version of a plugin. Module is relative to
+// the manifest, and ConfigSchema, if given, is the JSON Schema its config
+// must pass when it is enabled.
+type PluginManifest struct {
+	Name         string          `json:"name"`
+	Version      string          `json:"version"`
+	Description  string          `json:"description,omitempty"`
+	Module       string          `json:"module"`
+	Hooks        []string        `json:"hooks"`
+	ConfigSchema json.RawMessage `json:"config_schema,omitempty"`
+}
+
+type catalogPlugin struct {
+	manifest PluginManifest
+	module   wasmModule
+	schema   *jsonschema.Schema
+}
+
+// pluginCatalog is every plugin version in UPLOAD_PLUGIN_DIR, by
+// "name@version". It is read once at startup.
+var pluginCatalog = map[string]*catalogPlugin{}
+
+func pluginID(name, version string) string {
+	return name + "@" + version
+}
+
+// loadPluginCatalog compiles every plugin version UPLOAD_PLUGIN_DIR has a
+// manifest for.
+func loadPluginCatalog(ctx context.Context) error {
+	if pluginDir == "" {
+		return nil
+	}
+	if compileWASM == nil {
+		return errors.New("UPLOAD_PLUGIN_DIR is not supported by this build")
+	}
+	catalog := map[string]*catalogPlugin{}
+	err := filepath.WalkDir(pluginDir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || d.Name() != pluginManifestFile {
+			return err
+		}
+		p, err := loadCatalogPlugin(ctx, file)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		id := pluginID(p.manifest.Name, p.manifest.Version)
+		if catalog[id] != nil {
+			return fmt.Errorf("%s: %s is already in the catalog", file, id)
+		}
+		catalog[id] = p
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	pluginCatalog = catalog
+	return nil
+}
+
+func loadCatalogPlugin(ctx context.Context, file string) (*catalogPlugin, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var m PluginManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	switch {
+	case !pluginNamePattern.MatchString(m.Name):
+		return nil, fmt.Errorf("name %q must be lower case letters, digits and -", m.Name)
+	case !pluginVersionPattern.MatchString(m.Version):
+		return nil, fmt.Errorf("version %q must be letters, digits and .+-", m.Version)
+	case m.Module == "":
+		return nil, errors.New("module is required")
+	case len(m.Hooks) == 0:
+		return nil, errors.New("hooks is required")
+	}
+	for _, hook := range m.Hooks {
+		if !contains(pluginHooks, hook) {
+			return nil, fmt.Errorf("unknown hook %q", hook)
+		}
+	}
+
+	p := &catalogPlugin{manifest: m}
+	if len(m.ConfigSchema) > 0 {
+		url := "plugin://" + pluginID(m.Name, m.Version) + "/config.json"
+		compiler := jsonschema.NewCompiler()
+		if err := compiler.AddResource(url, bytes.NewReader(m.ConfigSchema)); err != nil {
+			return nil, fmt.Errorf("config_schema: %w", err)
+		}
+		if p.schema, err = compiler.Compile(url); err != nil {
+			return nil, fmt.Errorf("config_schema: %w", err)
+		}
+	}
+	wasm, err := os.ReadFile(filepath.Join(filepath.Dir(file), m.Module))
+	if err != nil {
+		return nil, err
+	}
+	if p.module, err = compileWASM(ctx, wasm, uploadPluginLimits); err != nil {
+		return nil, fmt.Errorf("%s: %w", m.Module, err)
+	}
+	return p, nil
+}
+
+// checkConfig validates config against the plugin's schema. A plugin
+// without one takes any JSON object.
+func (p *catalogPlugin) checkConfig(config json.RawMessage) error {
+	decoder := json.NewDecoder(bytes.NewReader(config))
+	decoder.UseNumber()
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return fmt.Errorf("config is not JSON: %w", err)
+	}
+	if p.schema == nil {
+		if _, ok := document.(map[string]interface{}); !ok {
+			return errors.New("config must be an object")
+		}
+		return nil
+	}
+	return p.schema.Validate(document)
+}
+
+// PluginEnablement turns a plugin version on for some tenants, or for every
+// tenant without one of its own when Tenants is empty, for uploads whose
+// names match Pattern. Enablements run in the order they were made.
+type PluginEnablement struct {
+	Name      string          `json:"name"`
+	Version   string          `json:"version"`
+	Tenants   []string        `json:"tenants,omitempty"`
+	Pattern   string          `json:"pattern"`
+	Config    json.RawMessage `json:"config,omitempty"`
+	UpdatedAt string          `json:"updated_at"`
+}
+
+// PluginEnableRequest enables a plugin version, or changes how it is
+// enabled. Pattern defaults to every name.
+type PluginEnableRequest struct {
+	Tenants []string        `json:"tenants,omitempty"`
+	Pattern string          `json:"pattern,omitempty"`
+	Config  json.RawMessage `json:"config,omitempty"`
+}
+
+// PluginVersion is a plugin version in the catalog, and how it is enabled
+// if it is.
+type PluginVersion struct {
+	Name         string            `json:"name"`
+	Version      string            `json:"version"`
+	Description  string            `json:"description,omitempty"`
+	Hooks        []string          `json:"hooks"`
+	ConfigSchema json.RawMessage   `json:"config_schema,omitempty"`
+	Enablement   *PluginEnablement `json:"enablement,omitempty"`
+}
+
+type PluginsResponse struct {
+	Plugins []PluginVersion `json:"plugins"`
+}
+
+func (e PluginEnablement) covers(tenant string) bool {
+	return len(e.Tenants) == 0 || contains(e.Tenants, tenant)
+}
+
+// pluginStore keeps the enablements in one object in the files bucket,
+// cached like the freezes.
+type pluginStore struct {
+	mu          sync.Mutex
+	enablements []PluginEnablement
+	etag        string
+	checkedAt   time.Time
+}
+
+var enabledPluginStore = &pluginStore{}
+
+func (s *pluginStore) current(ctx context.Context) ([]PluginEnablement, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.checkedAt.IsZero() || time.Since(s.checkedAt) >= pluginReloadInterval {
+		head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(pluginsEnabledKey),
+		})
+		switch {
+		case isNotFound(err):
+			s.enablements, s.etag = nil, ""
+		case err != nil:
+			return nil, err
+		case aws.ToString(head.ETag) != s.etag:
+			stored, etag, err := readPluginEnablements(ctx)
+			if err != nil {
+				return nil, err
+			}
+			s.enablements, s.etag = stored, etag
+		}
+		s.checkedAt = time.Now()
+	}
+	return s.enablements, nil
+}
+
+func readPluginEnablements(ctx context.Context) ([]PluginEnablement, string, error) {
+	result, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(pluginsEnabledKey),
+	})
+	if isNotFound(err) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	defer result.Body.Close()
+
+	var stored []PluginEnablement
+	if err := json.NewDecoder(result.Body).Decode(&stored); err != nil {
+		return nil, "", fmt.Errorf("decoding %s: %w", pluginsEnabledKey, err)
+	}
+	return stored, aws.ToString(result.ETag), nil
+}
+
+// update applies change to the enablements, conditionally on what it read
+// as the policy store does.
+func (s *pluginStore) update(ctx context.Context, change func([]PluginEnablement) ([]PluginEnablement, error)) error {
+	for attempt := 0; attempt < policyUpdateAttempts; attempt++ {
+		stored, etag, err := readPluginEnablements(ctx)
+		if err != nil {
+			return err
+		}
+		updated, err := change(stored)
+		if err != nil {
+			return err
+		}
+
+		body, err := json.MarshalIndent(updated, "", "  ")
+		if err != nil {
+			return err
+		}
+		input := &s3.PutObjectInput{
+			Bucket:      aws.String(bucketName),
+			Key:         aws.String(pluginsEnabledKey),
+			Body:        bytes.NewReader(body),
+			ContentType: aws.String("application/json"),
+		}
+		if etag == "" {
+			input.IfNoneMatch = aws.String("*")
+		} else {
+			input.IfMatch = aws.String(etag)
+		}
+		result, err := s3Client.PutObject(ctx, input)
+		if isPreconditionFailed(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		s.enablements, s.etag, s.checkedAt = updated, aws.ToString(result.ETag), time.Now()
+		s.mu.Unlock()
+		return nil
+	}
+	return fmt.Errorf("enabled plugins kept changing; gave up after %d attempts", policyUpdateAttempts)
+}
+
+// enabledPlugins returns the catalog plugins enabled for tenant that run at
+// hook, in order. An enablement naming the tenant wins over one for every
+// tenant, so a tenant can be kept on, or moved ahead to, its own version.
+func enabledPlugins(ctx context.Context, tenant, hook string) ([]uploadPlugin, error) {
+	if len(pluginCatalog) == 0 {
+		// Nothing could be enabled, so storage isn't asked
+		return nil, nil
+	}
+	enablements, err := enabledPluginStore.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pinned := map[string]bool{}
+	for _, e := range enablements {
+		if len(e.Tenants) > 0 && e.covers(tenant) {
+			pinned[e.Name] = true
+		}
+	}
+	var plugins []uploadPlugin
+	for _, e := range enablements {
+		p := pluginCatalog[pluginID(e.Name, e.Version)]
+		if p == nil || !e.covers(tenant) || (len(e.Tenants) == 0 && pinned[e.Name]) || !contains(p.manifest.Hooks, hook) {
+			// A version missing from this instance's catalog is skipped
+			// until it is deployed here too
+			continue
+		}
+		// The stored copy is indented
+		var config bytes.Buffer
+		if err := json.Compact(&config, e.Config); err != nil || config.Len() == 0 {
+			config.Reset()
+			config.WriteString("{}")
+		}
+		plugins = append(plugins, uploadPlugin{
+			name:    pluginID(e.Name, e.Version),
+			pattern: e.Pattern,
+			module:  p.module,
+			config:  config.String(),
+		})
+	}
+	return plugins, nil
+}
+
+var errPluginNotEnabled = errors.New("plugin version not enabled")
+
+func listPluginsHandler(w http.ResponseWriter, r *http.Request) {
+	stored, _, err := readPluginEnablements(r.Context())
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to read enabled plugins",
+			Details: err.Error(),
+		})
+		return
+	}
+	enabled := map[string]PluginEnablement{}
+	for _, e := range stored {
+		enabled[pluginID(e.Name, e.Version)] = e
+	}
+	resp := PluginsResponse{Plugins: []PluginVersion{}}
+	for _, id := range sortedKeys(pluginCatalog) {
+		m := pluginCatalog[id].manifest
+		v := PluginVersion{Name: m.Name, Version: m.Version, Description: m.Description, Hooks: m.Hooks, ConfigSchema: m.ConfigSchema}
+		if e, ok := enabled[id]; ok {
+			v.Enablement = &e
+		}
+		resp.Plugins = append(resp.Plugins, v)
+	}
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// enablePluginHandler enables a plugin version, or changes the tenants,
+// pattern or config it is enabled with.
+func enablePluginHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	p := pluginCatalog[pluginID(vars["name"], vars["version"])]
+	if p == nil {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{
+			Error:   "Plugin not found",
+			Details: fmt.Sprintf("%s is not in the catalog", pluginID(vars["name"], vars["version"])),
+		})
+		return
+	}
+	var req PluginEnableRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondInvalidBody(w, err)
+		return
+	}
+	enablement, err := newPluginEnablement(p, req)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid plugin settings",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	var clash error
+	err = enabledPluginStore.update(r.Context(), func(stored []PluginEnablement) ([]PluginEnablement, error) {
+		updated := []PluginEnablement{}
+		replaced := false
+		for _, e := range stored {
+			if e.Name == enablement.Name && e.Version == enablement.Version {
+				updated, replaced = append(updated, enablement), true
+				continue
+			}
+			if e.Name == enablement.Name {
+				if clash = versionsClash(e, enablement); clash != nil {
+					return nil, clash
+				}
+			}
+			updated = append(updated, e)
+		}
+		if !replaced {
+			updated = append(updated, enablement)
+		}
+		return updated, nil
+	})
+	switch {
+	case clash != nil:
+		respondJSON(w, http.StatusConflict, ErrorResponse{
+			Error:   "Another version is enabled",
+			Details: clash.Error(),
+		})
+	case err != nil:
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to update enabled plugins",
+			Details: err.Error(),
+		})
+	default:
+		respondJSON(w, http.StatusOK, enablement)
+	}
+}
+
+// newPluginEnablement checks req against the plugin's manifest.
+func newPluginEnablement(p *catalogPlugin, req PluginEnableRequest) (PluginEnablement, error) {
+	e := PluginEnablement{
+		Name:      p.manifest.Name,
+		Version:   p.manifest.Version,
+		Pattern:   req.Pattern,
+		Config:    req.Config,
+		UpdatedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	if e.Pattern == "" {
+		e.Pattern = "*"
+	}
+	if _, err := path.Match(e.Pattern, ""); err != nil {
+		return e, fmt.Errorf("pattern: %w", err)
+	}
+	for _, tenant := range req.Tenants {
+		if tenant == "" {
+			return e, errors.New("tenants must not be empty strings")
+		}
+		if !contains(e.Tenants, tenant) {
+			e.Tenants = append(e.Tenants, tenant)
+		}
+	}
+	sort.Strings(e.Tenants)
+	if len(e.Config) == 0 {
+		e.Config = json.RawMessage("{}")
+	}
+	if err := p.checkConfig(e.Config); err != nil {
+		return e, err
+	}
+	return e, nil
+}
+
+// versionsClash reports two versions of a plugin that would both run for a
+// tenant. One version for every tenant and others for named tenants is
+// fine; the named tenants get theirs.
+func versionsClash(a, b PluginEnablement) error {
+	if len(a.Tenants) == 0 && len(b.Tenants) == 0 {
+		return fmt.Errorf("%s is enabled for every tenant", pluginID(a.Name, a.Version))
+	}
+	for _, tenant := range b.Tenants {
+		if contains(a.Tenants, tenant) {
+			return fmt.Errorf("%s is enabled for tenant %s", pluginID(a.Name, a.Version), tenant)
+		}
+	}
+	return nil
+}
+
+// disablePluginHandler turns a plugin version off for every tenant it was
+// enabled for.
+func disablePluginHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	err := enabledPluginStore.update(r.Context(), func(stored []PluginEnablement) ([]PluginEnablement, error) {
+		for i, e := range stored {
+			if e.Name == vars["name"] && e.Version == vars["version"] {
+				return append(stored[:i], stored[i+1:]...), nil
+			}
+		}
+		return nil, errPluginNotEnabled
+	})
+	switch {
+	case errors.Is(err, errPluginNotEnabled):
+		respondJSON(w, http.StatusNotFound, ErrorResponse{
+			Error: "Plugin version not enabled",
+		})
+	case err != nil:
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to update enabled plugins",
+			Details: err.Error(),
+		})
+	default:
+		respondJSON(w, http.StatusOK, MessageResponse{Message: "Plugin disabled"})
+	}
+}
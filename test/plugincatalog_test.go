@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// installCatalog writes a manifest and module for each plugin version into
+// a catalog directory, and loads it with modules keyed by "name@version".
+func installCatalog(t *testing.T, manifests []PluginManifest, modules map[string]fakeWASM) {
+	t.Helper()
+	dir := t.TempDir()
+	for _, m := range manifests {
+		versionDir := filepath.Join(dir, m.Name, m.Version)
+		if err := os.MkdirAll(versionDir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		m.Module = "plugin.wasm"
+		manifest, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(versionDir, pluginManifestFile), manifest, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(versionDir, m.Module), []byte(pluginID(m.Name, m.Version)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	override(t, &compileWASM, func(ctx context.Context, wasm []byte, limits wasmLimits) (wasmModule, error) {
+		return modules[string(wasm)], nil
+	})
+	override(t, &pluginDir, dir)
+	override(t, &pluginCatalog, nil)
+	override(t, &enabledPluginStore, &pluginStore{})
+	if err := loadPluginCatalog(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// tagging is a plugin that prefixes uploads with its version and config.
+func tagging(version string) fakeWASM {
+	return func(ctx context.Context, stdin []byte, args []string) (wasmResult, error) {
+		return wasmResult{ExitCode: pluginReplace, Stdout: []byte(version + " " + args[2] + " " + string(stdin))}, nil
+	}
+}
+
+func TestPluginCatalog(t *testing.T) {
+	srv, fake := newTestServer(t)
+	override(t, &adminToken, "admin-secret")
+	override(t, &apiKeys, map[string]string{"acme-key": "acme", "globex-key": "globex"})
+	admin := []string{"Authorization", "Bearer admin-secret"}
+	schema := json.RawMessage(`{"type": "object", "properties": {"label": {"type": "string"}}, "additionalProperties": false}`)
+	installCatalog(t, []PluginManifest{
+		{Name: "tag", Version: "1.0.0", Hooks: []string{pluginHookUpload}, ConfigSchema: schema},
+		{Name: "tag", Version: "2.0.0", Hooks: []string{pluginHookUpload}, ConfigSchema: schema},
+	}, map[string]fakeWASM{"tag@1.0.0": tagging("v1"), "tag@2.0.0": tagging("v2")})
+
+	var list PluginsResponse
+	call(t, srv, "GET", "/api/admin/plugins", nil, admin...).decode(t, &list)
+	if len(list.Plugins) != 2 || list.Plugins[0].Version != "1.0.0" || list.Plugins[0].Enablement != nil {
+		t.Fatalf("catalog %+v", list)
+	}
+
+	// Nothing runs until a version is enabled
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("a.txt", "x"), "X-API-Key", "acme-key"), http.StatusOK)
+	if body, _, _ := fake.Object(bucketName, "tenants/acme/a.txt"); string(body) != "x" {
+		t.Errorf("upload before enabling stored as %q", body)
+	}
+
+	expectStatus(t, call(t, srv, "PUT", "/api/admin/plugins/tag/1.0.0", PluginEnableRequest{Config: json.RawMessage(`{"label": 7}`)}, admin...), http.StatusBadRequest)
+	expectStatus(t, call(t, srv, "PUT", "/api/admin/plugins/tag/3.0.0", PluginEnableRequest{}, admin...), http.StatusNotFound)
+	expectStatus(t, call(t, srv, "PUT", "/api/admin/plugins/tag/1.0.0", PluginEnableRequest{Config: json.RawMessage(`{"label": "all"}`)}, admin...), http.StatusOK)
+	// A second version for everyone would run twice
+	expectStatus(t, call(t, srv, "PUT", "/api/admin/plugins/tag/2.0.0", PluginEnableRequest{}, admin...), http.StatusConflict)
+	// but one tenant can move ahead on its own
+	expectStatus(t, call(t, srv, "PUT", "/api/admin/plugins/tag/2.0.0", PluginEnableRequest{Tenants: []string{"acme"}, Pattern: "*.txt"}, admin...), http.StatusOK)
+
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("b.txt", "x"), "X-API-Key", "acme-key"), http.StatusOK)
+	if body, _, _ := fake.Object(bucketName, "tenants/acme/b.txt"); string(body) != "v2 {} x" {
+		t.Errorf("acme's upload stored as %q", body)
+	}
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("b.txt", "x"), "X-API-Key", "globex-key"), http.StatusOK)
+	if body, _, _ := fake.Object(bucketName, "tenants/globex/b.txt"); string(body) != `v1 {"label":"all"} x` {
+		t.Errorf("globex's upload stored as %q", body)
+	}
+	// acme's version only matches text files, and acme doesn't fall back
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("c.csv", "x"), "X-API-Key", "acme-key"), http.StatusOK)
+	if body, _, _ := fake.Object(bucketName, "tenants/acme/c.csv"); string(body) != "x" {
+		t.Errorf("acme's csv stored as %q", body)
+	}
+
+	call(t, srv, "GET", "/api/admin/plugins", nil, admin...).decode(t, &list)
+	if e := list.Plugins[1].Enablement; e == nil || len(e.Tenants) != 1 || e.Pattern != "*.txt" {
+		t.Errorf("listed enablement %+v", e)
+	}
+
+	expectStatus(t, call(t, srv, "DELETE", "/api/admin/plugins/tag/2.0.0", nil, admin...), http.StatusOK)
+	expectStatus(t, call(t, srv, "DELETE", "/api/admin/plugins/tag/2.0.0", nil, admin...), http.StatusNotFound)
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("d.txt", "x"), "X-API-Key", "acme-key"), http.StatusOK)
+	if body, _, _ := fake.Object(bucketName, "tenants/acme/d.txt"); !strings.HasPrefix(string(body), "v1 ") {
+		t.Errorf("acme's upload after disabling its version stored as %q", body)
+	}
+}
+
+func TestPluginManifestChecks(t *testing.T) {
+	for _, m := range []PluginManifest{
+		{Name: "Bad Name", Version: "1.0.0", Hooks: []string{pluginHookUpload}},
+		{Name: "scan", Version: "1.0.0"},
+		{Name: "scan", Version: "1.0.0", Hooks: []string{"download"}},
+		{Name: "scan", Version: "1.0.0", Hooks: []string{pluginHookUpload}, ConfigSchema: json.RawMessage(`{"type": 5}`)},
+	} {
+		dir := t.TempDir()
+		m.Module = "plugin.wasm"
+		manifest, _ := json.Marshal(m)
+		os.WriteFile(filepath.Join(dir, pluginManifestFile), manifest, 0o644)
+		os.WriteFile(filepath.Join(dir, m.Module), nil, 0o644)
+		override(t, &compileWASM, func(context.Context, []byte, wasmLimits) (wasmModule, error) { return fakeWASM(nil), nil })
+		override(t, &pluginDir, dir)
+		if err := loadPluginCatalog(context.Background()); err == nil {
+			t.Errorf("loaded manifest %+v", m)
+		}
+	}
+}
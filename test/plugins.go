@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Upload plugins are third-party WebAssembly modules that inspect or
+// rewrite uploads before the validators see them. They run sandboxed: no
+// host files, network or environment, a fresh instance per upload, and
+// limits on memory, time and output. The runtime needs
+// github.com/tetratelabs/wazero, so it is only built in with -tags wazero.
+var (
+	uploadPluginsSetting = os.Getenv("UPLOAD_PLUGINS")
+
+	uploadPluginLimits = wasmLimits{
+		MemoryBytes: int64(intFromEnv("UPLOAD_PLUGIN_MEMORY_BYTES", 64<<20)),
+		Timeout:     durationFromEnv("UPLOAD_PLUGIN_TIMEOUT", 2*time.Second),
+		MaxOutput:   int64(intFromEnv("UPLOAD_PLUGIN_MAX_OUTPUT_BYTES", 64<<20)),
+	}
+)
+
+// Plugins are WASI commands. They get the upload on stdin, its name and
+// their config as arguments, and answer with their exit code.
+const (
+	pluginAccept  = 0 // store the upload as it is
+	pluginReject  = 1 // refuse it, with a problem per line of stderr
+	pluginReplace = 2 // store stdout instead
+)
+
+type wasmLimits struct {
+	MemoryBytes int64
+	Timeout     time.Duration
+	// The most a plugin may write to stdout, or to stderr
+	MaxOutput int64
+}
+
+type wasmResult struct {
+	ExitCode int
+	Stdout   []byte
+	Stderr   []byte
+}
+
+// wasmModule is a compiled plugin. Each run starts a new instance, so
+// nothing one upload leaves in memory is seen by the next.
+type wasmModule interface {
+	run(ctx context.Context, stdin []byte, args []string) (wasmResult, error)
+}
+
+// compileWASM is set by builds with a WebAssembly runtime.
+var compileWASM func(ctx context.Context, wasm []byte, limits wasmLimits) (wasmModule, error)
+
+func registerWASMRuntime(compile func(ctx context.Context, wasm []byte, limits wasmLimits) (wasmModule, error)) bool {
+	compileWASM = compile
+	return true
+}
+
+type uploadPlugin struct {
+	name    string
+	pattern string
+	module  wasmModule
+	// JSON, passed as the second argument; "{}" when there is none
+	config string
+}
+
+var uploadPlugins []uploadPlugin
+
+// loadUploadPlugins compiles the modules UPLOAD_PLUGINS names, as
+// "<key pattern>=<module file>" pairs that run in the order given, e.g.
+// UPLOAD_PLUGINS="*.pdf=plugins/strip-metadata.wasm,*=plugins/scan.wasm".
+func loadUploadPlugins(ctx context.Context) error {
+	entries := splitList(uploadPluginsSetting)
+	if len(entries) == 0 {
+		return nil
+	}
+	if compileWASM == nil {
+		return errors.New("UPLOAD_PLUGINS is not supported by this build")
+	}
+	if uploadPluginLimits.MemoryBytes < wasmPageSize {
+		return fmt.Errorf("UPLOAD_PLUGIN_MEMORY_BYTES must be at least %d", wasmPageSize)
+	}
+	var plugins []uploadPlugin
+	for _, entry := range entries {
+		pattern, file, ok := strings.Cut(entry, "=")
+		pattern, file = strings.TrimSpace(pattern), strings.TrimSpace(file)
+		if !ok || pattern == "" || file == "" {
+			return fmt.Errorf("UPLOAD_PLUGINS entry %q is not <pattern>=<module file>", entry)
+		}
+		wasm, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		module, err := compileWASM(ctx, wasm, uploadPluginLimits)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		name := "wasm:" + strings.TrimSuffix(path.Base(file), ".wasm")
+		plugins = append(plugins, uploadPlugin{name: name, pattern: pattern, module: module, config: "{}"})
+	}
+	uploadPlugins = plugins
+	return nil
+}
+
+// wasmPageSize is the unit WebAssembly memory grows in.
+const wasmPageSize = 64 << 10
+
+// runUploadPlugins passes content through each plugin matching filename in
+// turn: those UPLOAD_PLUGINS names, then those enabled for the tenant. It
+// returns what should be stored. A plugin refusing the upload returns
+// errRejected, like a validator would.
+func runUploadPlugins(ctx context.Context, filename string, content []byte) ([]byte, error) {
+	enabled, err := enabledPlugins(ctx, namespaceFrom(ctx).Tenant, pluginHookUpload)
+	if err != nil {
+		return nil, fmt.Errorf("loading enabled plugins: %w", err)
+	}
+	plugins := append(append([]uploadPlugin(nil), uploadPlugins...), enabled...)
+	for _, p := range plugins {
+		if !matchPattern(p.pattern, filename) {
+			continue
+		}
+		result, err := runUploadPlugin(ctx, p, filename, content)
+		if err != nil {
+			return nil, fmt.Errorf("%s plugin: %w", p.name, err)
+		}
+		switch result.ExitCode {
+		case pluginAccept:
+		case pluginReject:
+			return nil, errRejected{p.name, pluginProblems(result.Stderr)}
+		case pluginReplace:
+			content = result.Stdout
+		default:
+			return nil, fmt.Errorf("%s plugin exited with %d: %s", p.name, result.ExitCode, strings.TrimSpace(string(result.Stderr)))
+		}
+	}
+	return content, nil
+}
+
+func runUploadPlugin(ctx context.Context, p uploadPlugin, filename string, content []byte) (wasmResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, uploadPluginLimits.Timeout)
+	defer cancel()
+	result, err := p.module.run(ctx, content, []string{p.name, filename, p.config})
+	if ctx.Err() == context.DeadlineExceeded {
+		// The runtime stops the instance however far it got
+		return result, fmt.Errorf("took longer than %s", uploadPluginLimits.Timeout)
+	}
+	return result, err
+}
+
+// pluginProblems turns a rejecting plugin's stderr into validation errors.
+func pluginProblems(stderr []byte) []ValidationError {
+	var problems []ValidationError
+	for _, line := range strings.Split(string(stderr), "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		problems = append(problems, ValidationError{Message: line})
+		if len(problems) == maxValidationErrors {
+			break
+		}
+	}
+	if len(problems) == 0 {
+		problems = []ValidationError{{Message: "rejected without a reason"}}
+	}
+	return problems
+}
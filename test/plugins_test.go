@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"go/build"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeWASM stands in for a compiled plugin, so the tests don't need the
+// runtime built in.
+type fakeWASM func(ctx context.Context, stdin []byte, args []string) (wasmResult, error)
+
+func (f fakeWASM) run(ctx context.Context, stdin []byte, args []string) (wasmResult, error) {
+	return f(ctx, stdin, args)
+}
+
+// installPlugins loads plugins as UPLOAD_PLUGINS would, with modules keyed
+// by file name.
+func installPlugins(t *testing.T, setting string, modules map[string]fakeWASM) {
+	t.Helper()
+	dir := t.TempDir()
+	for name := range modules {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	override(t, &compileWASM, func(ctx context.Context, wasm []byte, limits wasmLimits) (wasmModule, error) {
+		return modules[string(wasm)], nil
+	})
+	override(t, &uploadPlugins, nil)
+	override(t, &uploadPluginsSetting, strings.ReplaceAll(setting, "=", "="+dir+"/"))
+	if err := loadUploadPlugins(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUploadPlugins(t *testing.T) {
+	srv, fake := newTestServer(t)
+	installPlugins(t, "*.txt=upper.wasm,secret*=deny.wasm", map[string]fakeWASM{
+		"upper.wasm": func(ctx context.Context, stdin []byte, args []string) (wasmResult, error) {
+			if args[1] == "keep.txt" {
+				return wasmResult{ExitCode: pluginAccept}, nil
+			}
+			return wasmResult{ExitCode: pluginReplace, Stdout: bytes.ToUpper(stdin)}, nil
+		},
+		"deny.wasm": func(ctx context.Context, stdin []byte, args []string) (wasmResult, error) {
+			return wasmResult{ExitCode: pluginReject, Stderr: []byte("contains a key\n\nline 3\n")}, nil
+		},
+	})
+
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("notes.txt", "hello")), http.StatusOK)
+	if body, _, _ := fake.Object(bucketName, "notes.txt"); string(body) != "HELLO" {
+		t.Errorf("rewritten upload stored as %q", body)
+	}
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("keep.txt", "hello")), http.StatusOK)
+	if body, _, _ := fake.Object(bucketName, "keep.txt"); string(body) != "hello" {
+		t.Errorf("accepted upload stored as %q", body)
+	}
+	// Plugins run in order, so the second sees what the first wrote
+	resp := call(t, srv, "POST", "/api/upload", upload("secret.txt", "hello"))
+	expectStatus(t, resp, http.StatusUnprocessableEntity)
+	var e ErrorResponse
+	resp.decode(t, &e)
+	if e.Details != "rejected by wasm:deny plugin" || len(e.ValidationErrors) != 2 || e.ValidationErrors[1].Message != "line 3" {
+		t.Errorf("rejection %+v", e)
+	}
+	if _, _, ok := fake.Object(bucketName, "secret.txt"); ok {
+		t.Error("rejected upload was stored")
+	}
+
+	var caps CapabilitiesResponse
+	call(t, srv, "GET", "/api/capabilities", nil).decode(t, &caps)
+	if plugins := caps.Capabilities["upload_plugins"]; !plugins.Enabled {
+		t.Errorf("capabilities %+v", plugins)
+	}
+}
+
+func TestUploadPluginFailures(t *testing.T) {
+	srv, fake := newTestServer(t)
+	override(t, &uploadPluginLimits.Timeout, 10*time.Millisecond)
+	installPlugins(t, "crash*=crash.wasm,slow*=slow.wasm,odd*=odd.wasm", map[string]fakeWASM{
+		"crash.wasm": func(ctx context.Context, stdin []byte, args []string) (wasmResult, error) {
+			return wasmResult{}, errors.New("unreachable executed")
+		},
+		"slow.wasm": func(ctx context.Context, stdin []byte, args []string) (wasmResult, error) {
+			<-ctx.Done()
+			return wasmResult{}, ctx.Err()
+		},
+		"odd.wasm": func(ctx context.Context, stdin []byte, args []string) (wasmResult, error) {
+			return wasmResult{ExitCode: 7}, nil
+		},
+	})
+
+	for _, name := range []string{"crash.txt", "slow.txt", "odd.txt"} {
+		resp := call(t, srv, "POST", "/api/upload", upload(name, "x"))
+		expectStatus(t, resp, http.StatusInternalServerError)
+		if msg := resp.errorMessage(t); msg != "Upload plugin failed" {
+			t.Errorf("%s: %s", name, msg)
+		}
+		if _, _, ok := fake.Object(bucketName, name); ok {
+			t.Errorf("%s was stored", name)
+		}
+	}
+}
+
+func TestUploadPluginsNeedRuntime(t *testing.T) {
+	override(t, &compileWASM, nil)
+	override(t, &uploadPluginsSetting, "*=scan.wasm")
+	if err := loadUploadPlugins(context.Background()); err == nil || !strings.Contains(err.Error(), "not supported by this build") {
+		t.Errorf("loading without a runtime: %v", err)
+	}
+}
+
+// TestOptionalFilesBuild checks that each file behind a build tag is built
+// when its tag is set. A file name ending in an architecture or OS, such as
+// _wasm, would be left out on every other platform whatever the tags.
+func TestOptionalFilesBuild(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tags []string
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tag, ok := strings.CutPrefix(strings.SplitN(string(content), "\n", 2)[0], "//go:build "); ok {
+			tags = append(tags, tag)
+		}
+	}
+	for _, platform := range [][2]string{{"linux", "amd64"}, {"linux", "arm64"}, {"darwin", "arm64"}} {
+		ctx := build.Default
+		ctx.GOOS, ctx.GOARCH, ctx.BuildTags = platform[0], platform[1], tags
+		pkg, err := ctx.ImportDir(".", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pkg.IgnoredGoFiles) > 0 {
+			t.Errorf("%s/%s with -tags %s leaves out %v", platform[0], platform[1], strings.Join(tags, ","), pkg.IgnoredGoFiles)
+		}
+	}
+}
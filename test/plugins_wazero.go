@@ -0,0 +1,85 @@
+//go:build wazero
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+var _ = registerWASMRuntime(compileWazero)
+
+// wazeroModule runs a plugin with wazero, which interprets or compiles it
+// without cgo.
+type wazeroModule struct {
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	limits   wasmLimits
+}
+
+func compileWazero(ctx context.Context, wasm []byte, limits wasmLimits) (wasmModule, error) {
+	config := wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(uint32(limits.MemoryBytes / wasmPageSize)).
+		// Stops a plugin that loops, rather than leaving it running
+		WithCloseOnContextDone(true)
+	runtime := wazero.NewRuntimeWithConfig(ctx, config)
+	wasi_snapshot_preview1.MustInstantiate(ctx, runtime)
+	compiled, err := runtime.CompileModule(ctx, wasm)
+	if err != nil {
+		runtime.Close(ctx)
+		return nil, err
+	}
+	return &wazeroModule{runtime: runtime, compiled: compiled, limits: limits}, nil
+}
+
+func (m *wazeroModule) run(ctx context.Context, stdin []byte, args []string) (wasmResult, error) {
+	stdout := &cappedBuffer{limit: m.limits.MaxOutput}
+	stderr := &cappedBuffer{limit: m.limits.MaxOutput}
+	// Nothing is mounted and no environment is passed, and the clocks and
+	// random source are wazero's fakes, so the plugin sees only the upload
+	config := wazero.NewModuleConfig().
+		WithStdin(bytes.NewReader(stdin)).
+		WithStdout(stdout).
+		WithStderr(stderr).
+		WithArgs(args...).
+		// Unnamed, so uploads can run the plugin at the same time
+		WithName("")
+	mod, err := m.runtime.InstantiateModule(ctx, m.compiled, config)
+	if mod != nil {
+		mod.Close(ctx)
+	}
+	result := wasmResult{Stdout: stdout.Bytes(), Stderr: stderr.Bytes()}
+	var exit *sys.ExitError
+	switch {
+	case errors.As(err, &exit):
+		result.ExitCode = int(exit.ExitCode())
+	case err != nil:
+		return result, err
+	}
+	if stdout.over || stderr.over {
+		return result, fmt.Errorf("wrote more than %d bytes", m.limits.MaxOutput)
+	}
+	return result, nil
+}
+
+// cappedBuffer fails writes past limit, so a plugin can't fill the host's
+// memory through its output.
+type cappedBuffer struct {
+	bytes.Buffer
+	limit int64
+	over  bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if int64(b.Len()+len(p)) > b.limit {
+		b.over = true
+		return 0, errors.New("output limit reached")
+	}
+	return b.Buffer.Write(p)
+}
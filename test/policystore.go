@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+)
+
+const (
+	policyPrefix   = ".policies/"
+	policyRulesKey = policyPrefix + "rules.json"
+
+	effectAllow = "allow"
+	effectDeny  = "deny"
+
+	policyUpdateAttempts = 5
+)
+
+// Other instances pick up rule changes within this long
+var policyReloadInterval = durationFromEnv("POLICY_RELOAD_INTERVAL", 30*time.Second)
+
+// PolicyRule allows or denies a subject an action on resources. Subject,
+// action and resource are patterns where * matches anything, slashes
+// included, e.g. "reports/*".
+type PolicyRule struct {
+	ID        string `json:"id"`
+	Subject   string `json:"subject"`
+	Action    string `json:"action"`
+	Resource  string `json:"resource"`
+	Effect    string `json:"effect"`
+	Tenant    string `json:"tenant,omitempty"`
+	CreatedAt string `json:"created_at,omitempty"`
+}
+
+type PoliciesResponse struct {
+	Rules    []PolicyRule `json:"rules"`
+	Enforced bool         `json:"enforced"`
+}
+
+var _ = registerPolicyEngine("store", func() (policyEngine, error) { return policies, nil })
+
+// policyStore keeps the rules in one object in the files bucket, so every
+// instance shares them. Each instance caches them and checks the object's
+// ETag at most once per POLICY_RELOAD_INTERVAL.
+type policyStore struct {
+	mu        sync.Mutex
+	rules     []PolicyRule
+	etag      string
+	checkedAt time.Time
+}
+
+var policies = &policyStore{}
+
+// current returns the rules, reloading them if they may be stale.
+func (s *policyStore) current(ctx context.Context) ([]PolicyRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.checkedAt.IsZero() && time.Since(s.checkedAt) < policyReloadInterval {
+		return s.rules, nil
+	}
+
+	head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(policyRulesKey),
+	})
+	switch {
+	case isNotFound(err):
+		s.rules, s.etag = nil, ""
+	case err != nil:
+		return nil, err
+	case aws.ToString(head.ETag) != s.etag:
+		rules, etag, err := readPolicyRules(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.rules, s.etag = rules, etag
+	}
+	s.checkedAt = time.Now()
+	return s.rules, nil
+}
+
+func readPolicyRules(ctx context.Context) ([]PolicyRule, string, error) {
+	result, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(policyRulesKey),
+	})
+	if isNotFound(err) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	defer result.Body.Close()
+
+	var rules []PolicyRule
+	if err := json.NewDecoder(result.Body).Decode(&rules); err != nil {
+		return nil, "", fmt.Errorf("decoding %s: %w", policyRulesKey, err)
+	}
+	return rules, aws.ToString(result.ETag), nil
+}
+
+// update applies change to the stored rules. The write is conditional on the
+// rules it read, so concurrent admins retry rather than overwrite each other.
+func (s *policyStore) update(ctx context.Context, change func([]PolicyRule) ([]PolicyRule, error)) error {
+	for attempt := 0; attempt < policyUpdateAttempts; attempt++ {
+		rules, etag, err := readPolicyRules(ctx)
+		if err != nil {
+			return err
+		}
+		updated, err := change(append([]PolicyRule{}, rules...))
+		if err != nil {
+			return err
+		}
+
+		body, err := json.MarshalIndent(updated, "", "  ")
+		if err != nil {
+			return err
+		}
+		input := &s3.PutObjectInput{
+			Bucket:      aws.String(bucketName),
+			Key:         aws.String(policyRulesKey),
+			Body:        bytes.NewReader(body),
+			ContentType: aws.String("application/json"),
+		}
+		if etag == "" {
+			input.IfNoneMatch = aws.String("*")
+		} else {
+			input.IfMatch = aws.String(etag)
+		}
+		result, err := s3Client.PutObject(ctx, input)
+		if isPreconditionFailed(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		// This instance sees its own change straight away
+		s.mu.Lock()
+		s.rules, s.etag, s.checkedAt = updated, aws.ToString(result.ETag), time.Now()
+		s.mu.Unlock()
+		return nil
+	}
+	return fmt.Errorf("policy rules kept changing; gave up after %d attempts", policyUpdateAttempts)
+}
+
+// decide allows when a rule allows and none denies. Rules scoped to a tenant
+// only apply to its requests.
+func (s *policyStore) decide(ctx context.Context, input PolicyInput) (policyDecision, error) {
+	rules, err := s.current(ctx)
+	if err != nil {
+		return policyDecision{}, err
+	}
+
+	resource := input.Resource.Name
+	if resource == "" {
+		resource = input.Resource.Prefix
+	}
+	decision := policyDecision{Reason: "no policy rule allows " + input.Action}
+	for _, rule := range rules {
+		if rule.Tenant != "" && rule.Tenant != input.Principal.Tenant {
+			continue
+		}
+		if !wildcardMatch(rule.Subject, input.Principal.Subject) || !wildcardMatch(rule.Action, input.Action) || !wildcardMatch(rule.Resource, resource) {
+			continue
+		}
+		if rule.Effect == effectDeny {
+			return policyDecision{Reason: "denied by policy rule " + rule.ID}, nil
+		}
+		decision = policyDecision{Allow: true}
+	}
+	return decision, nil
+}
+
+// wildcardMatch matches s against pattern, where each * stands for any run of
+// characters.
+func wildcardMatch(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
+
+var errPolicyNotFound = errors.New("policy rule not found")
+
+// validatePolicyRule fills in defaults and checks the rest.
+func validatePolicyRule(rule *PolicyRule) error {
+	if rule.Effect == "" {
+		rule.Effect = effectAllow
+	}
+	switch {
+	case rule.Subject == "" || rule.Action == "" || rule.Resource == "":
+		return fmt.Errorf("subject, action and resource are required; use * to match anything")
+	case rule.Effect != effectAllow && rule.Effect != effectDeny:
+		return fmt.Errorf("effect must be allow or deny")
+	}
+	return nil
+}
+
+func decodePolicyRule(w http.ResponseWriter, r *http.Request) (PolicyRule, bool) {
+	var rule PolicyRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		respondInvalidBody(w, err)
+		return rule, false
+	}
+	if err := validatePolicyRule(&rule); err != nil {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid policy rule",
+			Details: err.Error(),
+		})
+		return rule, false
+	}
+	return rule, true
+}
+
+func respondPolicyError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errPolicyNotFound) {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{
+			Error: "Policy rule not found",
+		})
+		return
+	}
+	respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+		Error:   "Failed to update policy rules",
+		Details: err.Error(),
+	})
+}
+
+func listPoliciesHandler(w http.ResponseWriter, r *http.Request) {
+	rules, _, err := readPolicyRules(r.Context())
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to read policy rules",
+			Details: err.Error(),
+		})
+		return
+	}
+	if rules == nil {
+		rules = []PolicyRule{}
+	}
+	respondJSON(w, http.StatusOK, PoliciesResponse{Rules: rules, Enforced: authzPolicy == policyEngine(policies)})
+}
+
+func createPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	rule, ok := decodePolicyRule(w, r)
+	if !ok {
+		return
+	}
+	rule.ID = newJobID()
+	rule.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+
+	if err := policies.update(r.Context(), func(rules []PolicyRule) ([]PolicyRule, error) {
+		return append(rules, rule), nil
+	}); err != nil {
+		respondPolicyError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusCreated, rule)
+}
+
+func getPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	rules, _, err := readPolicyRules(r.Context())
+	if err != nil {
+		respondPolicyError(w, err)
+		return
+	}
+	for _, rule := range rules {
+		if rule.ID == mux.Vars(r)["id"] {
+			respondJSON(w, http.StatusOK, rule)
+			return
+		}
+	}
+	respondPolicyError(w, errPolicyNotFound)
+}
+
+func updatePolicyHandler(w http.ResponseWriter, r *http.Request) {
+	rule, ok := decodePolicyRule(w, r)
+	if !ok {
+		return
+	}
+	id := mux.Vars(r)["id"]
+
+	if err := policies.update(r.Context(), func(rules []PolicyRule) ([]PolicyRule, error) {
+		for i := range rules {
+			if rules[i].ID == id {
+				rule.ID, rule.CreatedAt = id, rules[i].CreatedAt
+				rules[i] = rule
+				return rules, nil
+			}
+		}
+		return nil, errPolicyNotFound
+	}); err != nil {
+		respondPolicyError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, rule)
+}
+
+func deletePolicyHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if err := policies.update(r.Context(), func(rules []PolicyRule) ([]PolicyRule, error) {
+		for i := range rules {
+			if rules[i].ID == id {
+				return append(rules[:i], rules[i+1:]...), nil
+			}
+		}
+		return nil, errPolicyNotFound
+	}); err != nil {
+		respondPolicyError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, MessageResponse{Message: "Policy rule deleted"})
+}
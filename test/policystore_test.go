@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestPolicyStore(t *testing.T) {
+	srv, fake := newTestServer(t)
+	override(t, &adminToken, "admin-secret")
+	store := &policyStore{}
+	override(t, &policies, store)
+	override(t, &authzPolicy, policyEngine(store))
+	admin := []string{"Authorization", "Bearer admin-secret"}
+
+	var list PoliciesResponse
+	call(t, srv, "GET", "/api/admin/policies", nil, admin...).decode(t, &list)
+	if !list.Enforced || list.Rules == nil || len(list.Rules) != 0 {
+		t.Fatalf("initial policies %+v", list)
+	}
+
+	// With no rules everything is denied
+	expectStatus(t, call(t, srv, "GET", "/api/files", nil), http.StatusForbidden)
+
+	var allow PolicyRule
+	created := call(t, srv, "POST", "/api/admin/policies", PolicyRule{Subject: "*", Action: "*", Resource: "*"}, admin...)
+	expectStatus(t, created, http.StatusCreated)
+	created.decode(t, &allow)
+	if allow.ID == "" || allow.Effect != effectAllow {
+		t.Fatalf("created %+v", allow)
+	}
+	mustUpload(t, srv, "/api", "reports/q3.txt", "numbers")
+	mustUpload(t, srv, "/api", "hr/salaries.csv", "numbers")
+
+	var deny PolicyRule
+	call(t, srv, "POST", "/api/admin/policies", PolicyRule{Subject: "*", Action: "get*", Resource: "hr/*", Effect: effectDeny}, admin...).decode(t, &deny)
+	expectStatus(t, call(t, srv, "GET", "/api/files/reports/q3.txt", nil), http.StatusOK)
+	expectStatus(t, call(t, srv, "GET", "/api/files/hr/salaries.csv", nil), http.StatusForbidden)
+	expectStatus(t, call(t, srv, "GET", "/api/files/hr/salaries.csv/metadata", nil), http.StatusOK)
+
+	// Rules are stored where every instance reads them, and hidden from tenants
+	if _, _, ok := fake.Object(bucketName, policyRulesKey); !ok {
+		t.Fatal("rules were not stored")
+	}
+	var files FilesResponse
+	call(t, srv, "GET", "/api/files", nil).decode(t, &files)
+	for _, name := range files.Files {
+		if isReservedKey(name) {
+			t.Fatalf("listing shows %s", name)
+		}
+	}
+
+	deny.Action = "*"
+	expectStatus(t, call(t, srv, "PUT", "/api/admin/policies/"+deny.ID, deny, admin...), http.StatusOK)
+	expectStatus(t, call(t, srv, "GET", "/api/files/hr/salaries.csv/metadata", nil), http.StatusForbidden)
+
+	expectStatus(t, call(t, srv, "DELETE", "/api/admin/policies/"+deny.ID, nil, admin...), http.StatusOK)
+	expectStatus(t, call(t, srv, "GET", "/api/files/hr/salaries.csv", nil), http.StatusOK)
+	expectStatus(t, call(t, srv, "GET", "/api/admin/policies/"+deny.ID, nil, admin...), http.StatusNotFound)
+	expectStatus(t, call(t, srv, "DELETE", "/api/admin/policies/"+deny.ID, nil, admin...), http.StatusNotFound)
+
+	expectStatus(t, call(t, srv, "POST", "/api/admin/policies", PolicyRule{Subject: "*"}, admin...), http.StatusBadRequest)
+	expectStatus(t, call(t, srv, "POST", "/api/admin/policies", PolicyRule{Subject: "*", Action: "*", Resource: "*", Effect: "maybe"}, admin...), http.StatusBadRequest)
+}
+
+// Another instance's change is picked up once the reload interval passes.
+func TestPolicyStoreReload(t *testing.T) {
+	srv, _ := newTestServer(t)
+	override(t, &adminToken, "admin-secret")
+	override(t, &policies, &policyStore{})
+	other := &policyStore{}
+	override(t, &authzPolicy, policyEngine(other))
+	override(t, &policyReloadInterval, 0)
+
+	expectStatus(t, call(t, srv, "GET", "/api/files", nil), http.StatusForbidden)
+	expectStatus(t, call(t, srv, "POST", "/api/admin/policies", PolicyRule{Subject: "*", Action: "listFiles", Resource: "*"},
+		"Authorization", "Bearer admin-secret"), http.StatusCreated)
+	expectStatus(t, call(t, srv, "GET", "/api/files", nil), http.StatusOK)
+}
+
+func TestWildcardMatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"*", "", true},
+		{"*", "a/b/c", true},
+		{"reports/*", "reports/2024/q3.txt", true},
+		{"reports/*", "reports", false},
+		{"*.csv", "hr/salaries.csv", true},
+		{"get*", "getFile", true},
+		{"get*", "listFiles", false},
+		{"a*b*c", "aXbYc", true},
+		{"a*b*c", "aXcYb", false},
+		{"exact", "exact", true},
+		{"exact", "exactly", false},
+	} {
+		if got := wildcardMatch(tc.pattern, tc.s); got != tc.want {
+			t.Errorf("wildcardMatch(%q, %q) = %v", tc.pattern, tc.s, got)
+		}
+	}
+}
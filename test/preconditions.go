@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+var errPreconditionFailed = errors.New("the file has changed since it was read")
+
+// checkIfMatch resolves an If-Match header against the object currently at
+// key. It returns the object's ETag when the header allows replacing it, for
+// the write to pass on to S3 so a change made in between is still caught.
+// The ETag is also returned with errPreconditionFailed, to tell the client
+// what it would have to match.
+func checkIfMatch(ctx context.Context, bucket, key, header string) (string, error) {
+	head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if isNotFound(err) {
+		return "", errPreconditionFailed
+	}
+	if err != nil {
+		return "", err
+	}
+
+	current := aws.ToString(head.ETag)
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		// If-Match compares strongly, so a weak tag never matches
+		if candidate == "*" || (!strings.HasPrefix(candidate, "W/") && storedETag(candidate) == current) {
+			return current, nil
+		}
+	}
+	return current, errPreconditionFailed
+}
+
+// storedETag turns an ETag as served back into the one S3 stores: downloads
+// served compressed carry the encoding as a suffix, and tags copied out of
+// JSON may have lost their quotes.
+func storedETag(etag string) string {
+	if !strings.HasPrefix(etag, `"`) {
+		etag = `"` + etag + `"`
+	}
+	for _, encoding := range []string{encodingZstd, encodingGzip, encodingDeflate} {
+		if trimmed, ok := strings.CutSuffix(etag, "-"+encoding+`"`); ok {
+			return trimmed + `"`
+		}
+	}
+	return etag
+}
+
+// respondPreconditionFailed answers a write whose If-Match didn't hold with
+// 412, or with 500 when the current version couldn't be looked up.
+func respondPreconditionFailed(w http.ResponseWriter, current string, err error) {
+	if !errors.Is(err, errPreconditionFailed) && !isPreconditionFailed(err) {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to check If-Match",
+			Details: err.Error(),
+		})
+		return
+	}
+	if current != "" {
+		w.Header().Set("ETag", current)
+	}
+	respondJSON(w, http.StatusPreconditionFailed, ErrorResponse{
+		Error:   "Precondition failed",
+		Details: errPreconditionFailed.Error() + "; fetch it again for its current ETag",
+	})
+}
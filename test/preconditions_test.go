@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestIfMatchUpload(t *testing.T) {
+	srv, _ := newTestServer(t)
+	mustUpload(t, srv, "/api", "notes.txt", "first draft")
+
+	download := call(t, srv, "GET", "/api/files/notes.txt", nil)
+	expectStatus(t, download, http.StatusOK)
+	first := download.Header.Get("ETag")
+	if first == "" {
+		t.Fatal("download has no ETag")
+	}
+
+	// Two editors start from the same version; the second one to save loses
+	saved := call(t, srv, "POST", "/api/upload", upload("notes.txt", "alice's edit"), "If-Match", first)
+	expectStatus(t, saved, http.StatusOK)
+	second := saved.Header.Get("ETag")
+	if second == "" || second == first {
+		t.Fatalf("upload ETag %q after %q", second, first)
+	}
+	conflict := call(t, srv, "POST", "/api/upload", upload("notes.txt", "bob's edit"), "If-Match", first)
+	expectStatus(t, conflict, http.StatusPreconditionFailed)
+	if got := conflict.Header.Get("ETag"); got != second {
+		t.Fatalf("412 names ETag %q, want %q", got, second)
+	}
+	if got := call(t, srv, "GET", "/api/files/notes.txt", nil); string(got.body) != "alice's edit" {
+		t.Fatalf("lost update: %q", got.body)
+	}
+
+	for _, tc := range []struct {
+		name, file, ifMatch string
+		want                int
+	}{
+		{"unquoted", "notes.txt", second[1 : len(second)-1], http.StatusOK},
+		{"weak", "notes.txt", "W/" + second, http.StatusPreconditionFailed},
+		{"any version", "notes.txt", "*", http.StatusOK},
+		{"missing file", "other.txt", "*", http.StatusPreconditionFailed},
+		{"list", "notes.txt", `"stale", *`, http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			expectStatus(t, call(t, srv, "POST", "/api/upload", upload(tc.file, "v"), "If-Match", tc.ifMatch), tc.want)
+		})
+	}
+
+	// A conditional write replaces the file rather than picking a new name
+	var resp MessageResponse
+	call(t, srv, "POST", "/api/upload?on_conflict=number", upload("notes.txt", "v2"), "If-Match", "*").decode(t, &resp)
+	if resp.Filename != "notes.txt" {
+		t.Fatalf("conditional upload wrote %q", resp.Filename)
+	}
+}
+
+func TestIfMatchDelete(t *testing.T) {
+	for _, soft := range []bool{false, true} {
+		srv, _ := newTestServer(t)
+		override(t, &softDeleteEnabled, soft)
+		mustUpload(t, srv, "/api", "notes.txt", "draft")
+		etag := call(t, srv, "GET", "/api/files/notes.txt", nil).Header.Get("ETag")
+		mustUpload(t, srv, "/api", "notes.txt", "changed")
+
+		expectStatus(t, call(t, srv, "DELETE", "/api/files/notes.txt", nil, "If-Match", etag), http.StatusPreconditionFailed)
+		expectStatus(t, call(t, srv, "GET", "/api/files/notes.txt", nil), http.StatusOK)
+
+		current := call(t, srv, "GET", "/api/files/notes.txt", nil).Header.Get("ETag")
+		expectStatus(t, call(t, srv, "DELETE", "/api/files/notes.txt", nil, "If-Match", current), http.StatusOK)
+		expectStatus(t, call(t, srv, "GET", "/api/files/notes.txt", nil), http.StatusNotFound)
+	}
+}
+
+func TestStoredETag(t *testing.T) {
+	for in, want := range map[string]string{
+		`"abc"`:      `"abc"`,
+		`abc`:        `"abc"`,
+		`"abc-zstd"`: `"abc"`,
+		`"abc-gzip"`: `"abc"`,
+		`"abc-2"`:    `"abc-2"`,
+	} {
+		if got := storedETag(in); got != want {
+			t.Errorf("storedETag(%s) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestOverwriteProtection(t *testing.T) {
+	srv, _ := newTestServer(t)
+	mustUpload(t, srv, "/api", "notes.txt", "original")
+
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("notes.txt", "x"), "If-None-Match", "*"), http.StatusConflict)
+	expectStatus(t, call(t, srv, "POST", "/api/upload?overwrite=false", upload("notes.txt", "x")), http.StatusConflict)
+	expectStatus(t, call(t, srv, "POST", "/api/upload?overwrite=false", upload("new.txt", "x")), http.StatusOK)
+	expectStatus(t, call(t, srv, "POST", "/api/upload?overwrite=maybe", upload("notes.txt", "x")), http.StatusBadRequest)
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("notes.txt", "x"), "If-None-Match", "*", "If-Match", "*"), http.StatusBadRequest)
+	if got := call(t, srv, "GET", "/api/files/notes.txt", nil); string(got.body) != "original" {
+		t.Fatalf("protected file replaced with %q", got.body)
+	}
+
+	// Renaming strategies still apply
+	var resp MessageResponse
+	call(t, srv, "POST", "/api/upload?overwrite=false&on_conflict=number", upload("notes.txt", "x")).decode(t, &resp)
+	if resp.Filename != "notes (1).txt" {
+		t.Fatalf("numbered upload wrote %q", resp.Filename)
+	}
+
+	override(t, &preventOverwrite, true)
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("notes.txt", "x")), http.StatusConflict)
+	expectStatus(t, call(t, srv, "POST", "/api/upload?overwrite=true", upload("notes.txt", "x")), http.StatusOK)
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("notes.txt", "y"), "If-Match", "*"), http.StatusOK)
+}
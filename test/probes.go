@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// livezHandler answers as long as the process can serve HTTP. It never
+// touches AWS, so an S3 outage gets pods taken out of rotation by /readyz
+// rather than restarted.
+func livezHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprintln(w, "ok")
+}
+
+// readyzHandler reports whether this instance should get traffic: it isn't
+// shutting down, the AWS client could be set up from the configuration, and
+// the files bucket answers. Each check is listed in the Kubernetes style, [+]
+// or [-].
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+
+	checks := []string{"shutdown", "config", "storage"}
+	failed := map[string]error{}
+	if draining.Load() {
+		failed["shutdown"] = errors.New("draining connections")
+	}
+	if _, err := s3Client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucketName)}); errors.Is(err, errStorageNotReady) {
+		failed["config"] = err
+	} else if err != nil {
+		failed["storage"] = err
+	}
+
+	var report strings.Builder
+	for _, name := range checks {
+		if err, ok := failed[name]; ok {
+			fmt.Fprintf(&report, "[-]%s failed: %v\n", name, err)
+		} else if _, ok := failed["config"]; ok && name == "storage" {
+			fmt.Fprintf(&report, "[-]%s skipped\n", name)
+		} else {
+			fmt.Fprintf(&report, "[+]%s ok\n", name)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if len(failed) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "%sreadyz check failed\n", report.String())
+		return
+	}
+	fmt.Fprintf(w, "%sreadyz check passed\n", report.String())
+}
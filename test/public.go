@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+)
+
+// Object metadata marking a file as readable without credentials. Anything
+// without it is private, so overwriting a public file without asking for
+// public visibility again takes it private.
+const metaVisibility = "visibility"
+
+const (
+	visibilityPublic  = "public"
+	visibilityPrivate = "private"
+)
+
+// How long browsers and CDNs may cache a public file. Overwrites can be served
+// stale for this long, so uploads that change often are better given a new
+// name, e.g. with on_conflict=hash.
+var publicCacheTTL = durationFromEnv("PUBLIC_CACHE_TTL", 7*24*time.Hour)
+
+// applyVisibility records the upload's visibility on the object. Public files
+// are served by raw key from the files bucket, so named buckets can't hold
+// them.
+func applyVisibility(req UploadRequest, ns namespace, input *s3.PutObjectInput) error {
+	switch req.Visibility {
+	case "", visibilityPrivate:
+		return nil
+	case visibilityPublic:
+		if ns.Bucket != bucketName {
+			return fmt.Errorf("public files must be uploaded to the default bucket")
+		}
+		if input.Metadata == nil {
+			input.Metadata = map[string]string{}
+		}
+		input.Metadata[metaVisibility] = visibilityPublic
+		return nil
+	default:
+		return fmt.Errorf("visibility must be %s or %s", visibilityPublic, visibilityPrivate)
+	}
+}
+
+// publicURL is where a public file can be fetched without credentials. It
+// uses the raw key, tenant prefix included, as there is no caller to resolve
+// a namespace from.
+func publicURL(key string) string {
+	return (&url.URL{Path: "/api/public/" + key}).EscapedPath()
+}
+
+func fileVisibility(metadata map[string]string) string {
+	if metadata[metaVisibility] == visibilityPublic {
+		return visibilityPublic
+	}
+	return visibilityPrivate
+}
+
+// publicFileHandler serves files uploaded with public visibility. Private and
+// missing files are indistinguishable, so the route can't be used to probe
+// for names.
+func publicFileHandler(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["filename"]
+	if key == "" || isReservedKey(key) {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{
+			Error: "File not found",
+		})
+		return
+	}
+	if r.URL.Query().Get("mirror") == "best" && redirectToMirror(w, r, key) {
+		return
+	}
+	if r.Header.Get("Range") != "" && servePublicRange(w, r, key) {
+		return
+	}
+
+	result, contentEncoding, err := getObjectNegotiated(r.Context(), &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	}, r.Header.Get("Accept-Encoding"))
+	if err != nil {
+		if isNotFound(err) {
+			respondJSON(w, http.StatusNotFound, ErrorResponse{
+				Error: "File not found",
+			})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to read file",
+			Details: err.Error(),
+		})
+		return
+	}
+	defer result.Body.Close()
+
+	if fileVisibility(result.Metadata) != visibilityPublic {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{
+			Error: "File not found",
+		})
+		return
+	}
+	if err := linkDownloadHooks(r.Context(), bucketName, key); err != nil {
+		respondHookError(w, "Download", err)
+		return
+	}
+
+	etag := publicETag(aws.ToString(result.ETag), contentEncoding)
+
+	enableCORS(w)
+	setCacheControl(w, "public", publicCacheTTL)
+	w.Header().Set("Vary", "Accept-Encoding")
+	w.Header().Set("Accept-Ranges", "bytes")
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	// Served inline from the API's origin, so uploaded HTML must not be able
+	// to run script against it
+	w.Header().Set("Content-Type", publicContentType(key, aws.ToString(result.ContentType)))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	if contentEncoding != "" {
+		w.Header().Set("Content-Encoding", contentEncoding)
+	}
+	if result.ContentLength != nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(*result.ContentLength, 10))
+	}
+	if _, err := io.Copy(w, result.Body); err != nil {
+		slog.WarnContext(r.Context(), "Public download interrupted", "key", key, "err", err)
+	}
+}
+
+// servePublicRange answers a Range request for a public file, and reports
+// whether it did. Download managers and torrent web seeds fetch pieces this
+// way.
+func servePublicRange(w http.ResponseWriter, r *http.Request, key string) bool {
+	head, err := s3Client.HeadObject(r.Context(), &s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil || fileVisibility(head.Metadata) != visibilityPublic {
+		// The full download path reports these
+		return false
+	}
+	return serveRange(w, r, bucketName, key, head, func() {
+		setCacheControl(w, "public", publicCacheTTL)
+		w.Header().Set("Content-Type", publicContentType(key, aws.ToString(head.ContentType)))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Security-Policy", "sandbox")
+	})
+}
+
+// publicContentType guesses from the extension, since uploads don't carry a
+// content type, falling back to whatever the object was stored with.
+func publicContentType(key, stored string) string {
+	if contentType := mime.TypeByExtension(path.Ext(key)); contentType != "" {
+		return contentType
+	}
+	if stored != "" {
+		return stored
+	}
+	return "application/octet-stream"
+}
+
+// publicETag distinguishes a compressed representation from the decoded one,
+// as caches may hold both under the same URL.
+func publicETag(etag, contentEncoding string) string {
+	if etag == "" || contentEncoding == "" {
+		return etag
+	}
+	return strings.TrimSuffix(etag, `"`) + "-" + contentEncoding + `"`
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, comparing
+// weakly as RFC 9110 asks for GET: another content coding of the same
+// content matches too.
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	etag = storedETag(strings.TrimPrefix(etag, "W/"))
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || storedETag(strings.TrimPrefix(candidate, "W/")) == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestPublicFiles(t *testing.T) {
+	srv, _ := newTestServer(t)
+	override(t, &apiKeys, map[string]string{"acme-key": "acme"})
+
+	req := upload("avatars/me.png", "\x89PNG avatar")
+	req.Visibility = visibilityPublic
+	resp := call(t, srv, "POST", "/api/upload", req, "X-API-Key", "acme-key")
+	expectStatus(t, resp, http.StatusOK)
+	var msg MessageResponse
+	resp.decode(t, &msg)
+	if msg.PublicURL != "/api/public/tenants/acme/avatars/me.png" {
+		t.Fatalf("public url %q", msg.PublicURL)
+	}
+	mustUpload(t, srv, "/api", "avatars/private.png", "secret", "X-API-Key", "acme-key")
+
+	got := call(t, srv, "GET", msg.PublicURL, nil)
+	expectStatus(t, got, http.StatusOK)
+	if string(got.body) != "\x89PNG avatar" || got.Header.Get("Content-Type") != "image/png" {
+		t.Fatalf("public download: %q %v", got.body, got.Header)
+	}
+	if got.Header.Get("Cache-Control") != "public, max-age=604800" || got.Header.Get("Content-Security-Policy") != "sandbox" {
+		t.Fatalf("headers %v", got.Header)
+	}
+	etag := got.Header.Get("ETag")
+	expectStatus(t, call(t, srv, "GET", msg.PublicURL, nil, "If-None-Match", etag), http.StatusNotModified)
+
+	var meta FileMetadata
+	call(t, srv, "GET", "/api/files/avatars/me.png/metadata", nil, "X-API-Key", "acme-key").decode(t, &meta)
+	if meta.Visibility != visibilityPublic {
+		t.Fatalf("metadata visibility %q", meta.Visibility)
+	}
+
+	// Private, missing and internal files look the same
+	for _, path := range []string{
+		"/api/public/tenants/acme/avatars/private.png",
+		"/api/public/tenants/acme/avatars/nope.png",
+		"/api/public/.acl/tenants/acme/avatars/me.png",
+	} {
+		expectStatus(t, call(t, srv, "GET", path, nil), http.StatusNotFound)
+	}
+
+	// Overwriting without asking again takes the file private
+	mustUpload(t, srv, "/api", "avatars/me.png", "replaced", "X-API-Key", "acme-key")
+	expectStatus(t, call(t, srv, "GET", msg.PublicURL, nil), http.StatusNotFound)
+
+	req.Visibility = "world"
+	expectStatus(t, call(t, srv, "POST", "/api/upload", req, "X-API-Key", "acme-key"), http.StatusBadRequest)
+}
+
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{``, false},
+		{`"abc"`, true},
+		{`W/"abc"`, true},
+		{`"x", "abc"`, true},
+		{`*`, true},
+		{`"abcd"`, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.header, `"abc"`); got != tt.want {
+			t.Errorf("etagMatches(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestPublicRanges(t *testing.T) {
+	srv, _ := newTestServer(t)
+	override(t, &storagePolicies, map[string]storagePolicy{"packed/": {Compression: encodingZstd}})
+	content := "0123456789abcdefghij"
+	for _, name := range []string{"plain.bin", "packed/data.bin"} {
+		req := upload(name, content)
+		req.Visibility = visibilityPublic
+		expectStatus(t, call(t, srv, "POST", "/api/upload", req), http.StatusOK)
+
+		resp := call(t, srv, "GET", "/api/public/"+name, nil, "Range", "bytes=5-9")
+		expectStatus(t, resp, http.StatusPartialContent)
+		if string(resp.body) != "56789" || resp.Header.Get("Content-Range") != "bytes 5-9/20" {
+			t.Fatalf("%s: %q %v", name, resp.body, resp.Header)
+		}
+		resp = call(t, srv, "GET", "/api/public/"+name, nil, "Range", "bytes=-3")
+		if string(resp.body) != "hij" {
+			t.Fatalf("%s suffix: %q", name, resp.body)
+		}
+		resp = call(t, srv, "GET", "/api/public/"+name, nil, "Range", "bytes=20-")
+		expectStatus(t, resp, http.StatusRequestedRangeNotSatisfiable)
+		if resp.Header.Get("Content-Range") != "bytes */20" {
+			t.Fatalf("%s: Content-Range %q", name, resp.Header.Get("Content-Range"))
+		}
+		// Ranges it can't serve get the whole file
+		resp = call(t, srv, "GET", "/api/public/"+name, nil, "Range", "bytes=0-1,5-6")
+		expectStatus(t, resp, http.StatusOK)
+		if string(resp.body) != content {
+			t.Fatalf("%s: %q", name, resp.body)
+		}
+	}
+}
+
+func TestParseByteRange(t *testing.T) {
+	tests := []struct {
+		header     string
+		start, end int64
+		ok         bool
+		err        error
+	}{
+		{"bytes=0-0", 0, 0, true, nil},
+		{"bytes=10-", 10, 99, true, nil},
+		{"bytes=90-200", 90, 99, true, nil},
+		{"bytes=-10", 90, 99, true, nil},
+		{"bytes=-500", 0, 99, true, nil},
+		{"bytes=100-", 0, 0, true, errRangeNotSatisfiable},
+		{"bytes=-0", 0, 0, true, errRangeNotSatisfiable},
+		{"bytes=5-2", 0, 0, false, nil},
+		{"bytes=0-1,3-4", 0, 0, false, nil},
+		{"items=0-1", 0, 0, false, nil},
+		{"bytes=x-1", 0, 0, false, nil},
+	}
+	for _, tt := range tests {
+		start, end, ok, err := parseByteRange(tt.header, 100)
+		if start != tt.start || end != tt.end || ok != tt.ok || err != tt.err {
+			t.Errorf("%s: %d-%d %v %v", tt.header, start, end, ok, err)
+		}
+	}
+}
+
+func TestPublicTorrent(t *testing.T) {
+	override(t, &torrentMinBytes, 1000)
+	override(t, &torrentTrackers, []string{"udp://tracker.example.com:1337/announce"})
+	srv, fake := newTestServer(t)
+	content := strings.Repeat("release build ", 100)
+	req := upload("releases/app.tar.gz", content)
+	req.Visibility = visibilityPublic
+	expectStatus(t, call(t, srv, "POST", "/api/upload", req), http.StatusOK)
+	req = upload("releases/small.txt", "tiny")
+	req.Visibility = visibilityPublic
+	expectStatus(t, call(t, srv, "POST", "/api/upload", req), http.StatusOK)
+
+	resp := call(t, srv, "GET", "/api/public/releases/app.tar.gz/torrent", nil)
+	expectStatus(t, resp, http.StatusOK)
+	sum := sha1.Sum([]byte(content))
+	var info bytes.Buffer
+	bencode(&info, map[string]any{"length": int64(len(content)), "name": "app.tar.gz", "piece length": int64(256 << 10), "pieces": sum[:]})
+	for _, part := range []string{"8:url-list", srv.URL + "/api/public/releases/app.tar.gz", "4:info" + info.String(), "8:announce"} {
+		if !bytes.Contains(resp.body, []byte(part)) {
+			t.Fatalf("torrent %q lacks %q", resp.body, part)
+		}
+	}
+	infoHash := sha1.Sum(info.Bytes())
+	if magnet := resp.Header.Get("X-Magnet-URI"); !strings.HasPrefix(magnet, "magnet:?xt=urn:btih:"+hex.EncodeToString(infoHash[:])+"&") {
+		t.Fatalf("magnet %q", magnet)
+	}
+	if _, metadata, ok := fake.Object(bucketName, torrentPrefix+"releases/app.tar.gz"); !ok || metadata[metaInfoHash] != hex.EncodeToString(infoHash[:]) {
+		t.Fatalf("info dictionary was not cached: %v", metadata)
+	}
+
+	// Web seeds fetch pieces by range
+	resp = call(t, srv, "GET", "/api/public/releases/app.tar.gz", nil, "Range", "bytes=0-13")
+	expectStatus(t, resp, http.StatusPartialContent)
+
+	expectStatus(t, call(t, srv, "GET", "/api/public/releases/small.txt/torrent", nil), http.StatusNotFound)
+	mustUpload(t, srv, "/api", "releases/private.tar.gz", content)
+	expectStatus(t, call(t, srv, "GET", "/api/public/releases/private.tar.gz/torrent", nil), http.StatusNotFound)
+}
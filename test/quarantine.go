@@ -0,0 +1,849 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/gorilla/mux"
+)
+
+// With QUARANTINE_CHECKS set, an upload isn't stored under its name when it
+// arrives. It is held under .quarantine/pending/ while the checks listed
+// run in the background, in order, and stored under its name once every
+// one of them has approved it:
+//
+//   - clamav scans it with clamd, instead of scanning as it is uploaded
+//   - plugins runs the upload plugins on it, instead of on the upload
+//   - webhook asks QUARANTINE_WEBHOOK_URL, which may approve or reject it,
+//     or leave it pending to decide later through the admin API
+//   - manual leaves it pending for an admin to decide
+//
+// Where each upload has got to is kept under .quarantine/status/, one
+// record per name, which a later upload of the name replaces.
+var (
+	quarantineChecks        = splitList(os.Getenv("QUARANTINE_CHECKS"))
+	quarantineWebhookURL    = os.Getenv("QUARANTINE_WEBHOOK_URL")
+	quarantineWebhookSecret = os.Getenv("QUARANTINE_WEBHOOK_SECRET")
+
+	// A check that fails, as opposed to rejecting the upload, is tried this
+	// many times, waiting twice as long before each retry as the last
+	quarantineCheckAttempts = intFromEnv("QUARANTINE_CHECK_ATTEMPTS", 3)
+	quarantineRetryDelay    = durationFromEnv("QUARANTINE_RETRY_DELAY", 5*time.Second)
+
+	uploadReviews = newCounterVec("upload_reviews_total", "Held uploads decided, by decision: approved or rejected.", "decision")
+)
+
+const (
+	reviewCheckClamAV  = "clamav"
+	reviewCheckPlugins = "plugins"
+	reviewCheckWebhook = "webhook"
+	reviewCheckManual  = "manual"
+
+	reviewPending  = "pending"
+	reviewApproved = "approved"
+	reviewRejected = "rejected"
+
+	// What a webhook or admin decides
+	decisionApprove = "approve"
+	decisionReject  = "reject"
+
+	quarantinePendingPrefix = quarantinePrefix + "pending/"
+	quarantineStatusPrefix  = quarantinePrefix + "status/"
+
+	reviewJobKind = "upload_review"
+
+	// Set on a file that was held, once it is approved
+	metaReviewStatus = "review-status"
+
+	quarantineSignatureHeader = "X-Quarantine-Signature"
+)
+
+var reviewChecks = []string{reviewCheckClamAV, reviewCheckPlugins, reviewCheckWebhook, reviewCheckManual}
+
+var (
+	errReviewSuperseded = errors.New("upload was replaced by a newer one")
+	errReviewDecided    = errors.New("upload has already been decided")
+)
+
+// ReviewRecord is where an upload held for review has got to.
+type ReviewRecord struct {
+	Filename string `json:"filename"`
+	ID       string `json:"id"`
+	// pending, approved or rejected
+	Status     string        `json:"status"`
+	Reason     string        `json:"reason,omitempty"`
+	Checks     []ReviewCheck `json:"checks"`
+	Size       int64         `json:"size"`
+	UploadedBy string        `json:"uploaded_by,omitempty"`
+	UploadedAt string        `json:"uploaded_at"`
+	// The check or admin that decided, and when
+	DecidedBy string `json:"decided_by,omitempty"`
+	DecidedAt string `json:"decided_at,omitempty"`
+}
+
+// ReviewCheck is how one of the checks went.
+type ReviewCheck struct {
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	Reason    string `json:"reason,omitempty"`
+	CheckedAt string `json:"checked_at,omitempty"`
+}
+
+type ReviewsResponse struct {
+	Reviews []ReviewRecord `json:"reviews"`
+}
+
+type ReviewDecisionRequest struct {
+	// approve or reject
+	Decision string `json:"decision"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// ReviewWebhookRequest is what QUARANTINE_WEBHOOK_URL is sent for each held
+// upload. It answers with a ReviewWebhookReply.
+type ReviewWebhookRequest struct {
+	ID          string `json:"id"`
+	Tenant      string `json:"tenant,omitempty"`
+	Filename    string `json:"filename"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type,omitempty"`
+	UploadedBy  string `json:"uploaded_by,omitempty"`
+	// Base64, as JSON has it
+	Content []byte `json:"content"`
+}
+
+type ReviewWebhookReply struct {
+	// approve, reject or pending
+	Decision string `json:"decision"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// reviewRecord is what is stored for a held upload: its review, and what
+// to store it with once it is approved.
+type reviewRecord struct {
+	Review ReviewRecord `json:"review"`
+	Upload heldUpload   `json:"upload"`
+}
+
+// heldUpload is the part of an upload's PutObject input that is kept while
+// it is held. The preconditions are checked again when it is stored.
+type heldUpload struct {
+	ContentType    string            `json:"content_type,omitempty"`
+	Tagging        string            `json:"tagging,omitempty"`
+	Metadata       map[string]string `json:"metadata,omitempty"`
+	ObjectLockMode string            `json:"object_lock_mode,omitempty"`
+	RetainUntil    *time.Time        `json:"retain_until,omitempty"`
+	LegalHold      bool              `json:"legal_hold,omitempty"`
+	Checksum       string            `json:"checksum,omitempty"`
+	IfMatch        string            `json:"if_match,omitempty"`
+	IfNoneMatch    string            `json:"if_none_match,omitempty"`
+	ExpiresAt      *time.Time        `json:"expires_at,omitempty"`
+	// Whether the uploader becomes the file's owner
+	Owner bool `json:"owner,omitempty"`
+}
+
+func newHeldUpload(input *s3.PutObjectInput, expiresAt time.Time, owner bool) heldUpload {
+	held := heldUpload{
+		ContentType:    aws.ToString(input.ContentType),
+		Tagging:        aws.ToString(input.Tagging),
+		Metadata:       maps.Clone(input.Metadata),
+		ObjectLockMode: string(input.ObjectLockMode),
+		RetainUntil:    input.ObjectLockRetainUntilDate,
+		LegalHold:      input.ObjectLockLegalHoldStatus == types.ObjectLockLegalHoldStatusOn,
+		Checksum:       string(input.ChecksumAlgorithm),
+		IfMatch:        aws.ToString(input.IfMatch),
+		IfNoneMatch:    aws.ToString(input.IfNoneMatch),
+		Owner:          owner,
+	}
+	if !expiresAt.IsZero() {
+		held.ExpiresAt = aws.Time(expiresAt)
+	}
+	return held
+}
+
+// input is what to store the upload under key with.
+func (h heldUpload) input(bucket, key string) *s3.PutObjectInput {
+	input := &s3.PutObjectInput{
+		Bucket:                    aws.String(bucket),
+		Key:                       aws.String(key),
+		Metadata:                  maps.Clone(h.Metadata),
+		ObjectLockMode:            types.ObjectLockMode(h.ObjectLockMode),
+		ObjectLockRetainUntilDate: h.RetainUntil,
+		ChecksumAlgorithm:         types.ChecksumAlgorithm(h.Checksum),
+	}
+	if input.Metadata == nil {
+		input.Metadata = map[string]string{}
+	}
+	if h.ContentType != "" {
+		input.ContentType = aws.String(h.ContentType)
+	}
+	if h.Tagging != "" {
+		input.Tagging = aws.String(h.Tagging)
+	}
+	if h.LegalHold {
+		input.ObjectLockLegalHoldStatus = types.ObjectLockLegalHoldStatusOn
+	}
+	if h.IfMatch != "" {
+		input.IfMatch = aws.String(h.IfMatch)
+	}
+	if h.IfNoneMatch != "" {
+		input.IfNoneMatch = aws.String(h.IfNoneMatch)
+	}
+	return input
+}
+
+func quarantineEnabled() bool { return len(quarantineChecks) > 0 }
+
+// reviewDefers reports whether check runs on held uploads, and so not as
+// they are uploaded.
+func reviewDefers(check string) bool { return contains(quarantineChecks, check) }
+
+// checkQuarantineSettings reports a problem with the QUARANTINE_ settings.
+func checkQuarantineSettings() error {
+	for _, check := range quarantineChecks {
+		if !contains(reviewChecks, check) {
+			return fmt.Errorf("QUARANTINE_CHECKS can only list %s, not %q", strings.Join(reviewChecks, ", "), check)
+		}
+	}
+	switch {
+	case reviewDefers(reviewCheckClamAV) && !antivirusEnabled():
+		return errors.New("QUARANTINE_CHECKS=clamav needs CLAMAV_ADDRESS")
+	case reviewDefers(reviewCheckWebhook) && quarantineWebhookURL == "":
+		return errors.New("QUARANTINE_CHECKS=webhook needs QUARANTINE_WEBHOOK_URL")
+	case quarantineCheckAttempts < 1:
+		return errors.New("QUARANTINE_CHECK_ATTEMPTS must be at least 1")
+	}
+	return nil
+}
+
+func heldKey(ns namespace, review ReviewRecord) string {
+	return ns.key(quarantinePendingPrefix + review.ID + "/" + review.Filename)
+}
+
+func reviewRecordKey(ns namespace, name string) string {
+	return ns.key(quarantineStatusPrefix + name + ".json")
+}
+
+// holdUpload stores content where it waits for review, rather than under
+// input.Key, and starts reviewing it. expiresAt is zero for an upload that
+// doesn't expire, and owner says whether subject becomes the file's owner.
+func holdUpload(ctx context.Context, subject string, input *s3.PutObjectInput, content []byte, expiresAt time.Time, owner bool) (*ReviewRecord, error) {
+	ns := namespaceFrom(ctx)
+	name := ns.name(aws.ToString(input.Key))
+	rec := &reviewRecord{
+		Review: ReviewRecord{
+			Filename:   name,
+			ID:         newJobID(),
+			Status:     reviewPending,
+			Size:       int64(len(content)),
+			UploadedBy: subject,
+			UploadedAt: time.Now().UTC().Format(time.RFC3339),
+		},
+		Upload: newHeldUpload(input, expiresAt, owner),
+	}
+	for _, check := range quarantineChecks {
+		rec.Review.Checks = append(rec.Review.Checks, ReviewCheck{Name: check, Status: reviewPending})
+	}
+	if err := putHeld(ctx, rec, content); err != nil {
+		return nil, err
+	}
+
+	replaced, _, err := readReview(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writeReview(ctx, rec, ""); err != nil {
+		return nil, err
+	}
+	// Its review stops when it finds it has been replaced, but one waiting on
+	// a decision isn't running to find out
+	if replaced != nil && replaced.Review.Status == reviewPending {
+		deleteHeld(ctx, replaced.Review)
+	}
+
+	if _, _, err := startDurableJob(ctx, reviewJobKind, reviewParams{Filename: name, ID: rec.Review.ID}, ""); err != nil {
+		return nil, err
+	}
+	slog.InfoContext(ctx, "Held upload for review", "key", name, "review", rec.Review.ID, "checks", strings.Join(quarantineChecks, ","))
+	return &rec.Review, nil
+}
+
+// holdForReview answers an upload request by holding input for review.
+// An upload that may not overwrite is refused now if the file exists, as
+// well as when it is approved.
+func holdForReview(w http.ResponseWriter, r *http.Request, input *s3.PutObjectInput, content []byte, expiresAt time.Time, owner bool) {
+	ctx := r.Context()
+	if aws.ToString(input.IfNoneMatch) == "*" {
+		exists, err := objectExists(ctx, aws.ToString(input.Key))
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+				Error:   "Upload failed",
+				Details: err.Error(),
+			})
+			return
+		}
+		if exists {
+			respondJSON(w, http.StatusConflict, ErrorResponse{
+				Error:   "File already exists",
+				Details: "upload with overwrite=true or If-Match to replace it",
+			})
+			return
+		}
+	}
+
+	review, err := holdUpload(ctx, requestSubject(r), input, content, expiresAt, owner)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Upload failed",
+			Details: err.Error(),
+		})
+		return
+	}
+	respondJSON(w, http.StatusAccepted, MessageResponse{
+		Message:      "File uploaded and held for review",
+		Filename:     review.Filename,
+		ReviewStatus: review.Status,
+	})
+}
+
+// putHeld stores the held copy of an upload, encoded as the file will be
+// so one that is to be encrypted isn't kept in the clear meanwhile.
+func putHeld(ctx context.Context, rec *reviewRecord, content []byte) error {
+	ns := namespaceFrom(ctx)
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(ns.Bucket),
+		Key:    aws.String(heldKey(ns, rec.Review)),
+	}
+	if rec.Upload.ContentType != "" {
+		input.ContentType = aws.String(rec.Upload.ContentType)
+	}
+	if err := encodeObject(input, rec.Review.Filename, content); err != nil {
+		return err
+	}
+	_, err := s3Client.PutObject(ctx, input)
+	return err
+}
+
+func readHeld(ctx context.Context, review ReviewRecord) ([]byte, error) {
+	ns := namespaceFrom(ctx)
+	result, err := getObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(ns.Bucket),
+		Key:    aws.String(heldKey(ns, review)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer result.Body.Close()
+	return io.ReadAll(result.Body)
+}
+
+func deleteHeld(ctx context.Context, review ReviewRecord) {
+	ns := namespaceFrom(ctx)
+	if _, err := s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(ns.Bucket),
+		Key:    aws.String(heldKey(ns, review)),
+	}); err != nil {
+		slog.WarnContext(ctx, "Failed to remove held upload", "key", review.Filename, "review", review.ID, "err", err)
+	}
+}
+
+// readReview reads the review record of name, or returns nil if it has
+// none, with the etag to update it conditionally on.
+func readReview(ctx context.Context, name string) (*reviewRecord, string, error) {
+	ns := namespaceFrom(ctx)
+	result, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(ns.Bucket),
+		Key:    aws.String(reviewRecordKey(ns, name)),
+	})
+	if isNotFound(err) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	defer result.Body.Close()
+	var rec reviewRecord
+	if err := json.NewDecoder(result.Body).Decode(&rec); err != nil {
+		return nil, "", fmt.Errorf("review record of %s: %w", name, err)
+	}
+	return &rec, aws.ToString(result.ETag), nil
+}
+
+// writeReview stores rec, conditionally on the stored record still having
+// etag unless it is empty, and returns the new etag.
+func writeReview(ctx context.Context, rec *reviewRecord, etag string) (string, error) {
+	body, err := json.Marshal(rec)
+	if err != nil {
+		return "", err
+	}
+	ns := namespaceFrom(ctx)
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(ns.Bucket),
+		Key:         aws.String(reviewRecordKey(ns, rec.Review.Filename)),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	}
+	if etag != "" {
+		input.IfMatch = aws.String(etag)
+	}
+	result, err := s3Client.PutObject(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(result.ETag), nil
+}
+
+// updateReview applies change to the review record of upload id of name,
+// trying again if the record changes underneath it. It fails with
+// errReviewSuperseded once the name has been uploaded again.
+func updateReview(ctx context.Context, name, id string, change func(*reviewRecord) error) (*reviewRecord, error) {
+	for attempt := 0; attempt < policyUpdateAttempts; attempt++ {
+		rec, etag, err := readReview(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if rec == nil || rec.Review.ID != id {
+			return nil, errReviewSuperseded
+		}
+		if err := change(rec); err != nil {
+			return rec, err
+		}
+		_, err = writeReview(ctx, rec, etag)
+		if isPreconditionFailed(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return rec, nil
+	}
+	return nil, fmt.Errorf("review of %s kept changing; gave up after %d attempts", name, policyUpdateAttempts)
+}
+
+var _ = registerDurableJob(reviewJobKind, runReview)
+
+type reviewParams struct {
+	Filename string `json:"filename"`
+	ID       string `json:"id"`
+}
+
+// runReview runs the checks on a held upload that haven't yet decided it,
+// in order. The record is the checkpoint: a resumed review starts from the
+// first check still pending.
+func runReview(ctx context.Context, job *Job) (map[string]string, error) {
+	var params reviewParams
+	if err := job.decodeParams(&params); err != nil {
+		return nil, err
+	}
+	rec, _, err := readReview(ctx, params.Filename)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil || rec.Review.ID != params.ID {
+		deleteHeld(ctx, ReviewRecord{Filename: params.Filename, ID: params.ID})
+		return map[string]string{"status": "superseded"}, nil
+	}
+	if rec.Review.Status != reviewPending {
+		return map[string]string{"status": rec.Review.Status}, nil
+	}
+	content, err := readHeld(ctx, rec.Review)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, check := range rec.Review.Checks {
+		if check.Status != reviewPending {
+			continue
+		}
+		status, reason, err := reviewUpload(ctx, check.Name, rec, &content)
+		if err != nil {
+			// Left pending; an admin can still decide it
+			reason, status = "check failed: "+err.Error(), reviewPending
+		}
+		_, updateErr := updateReview(ctx, params.Filename, params.ID, func(r *reviewRecord) error {
+			r.Review.Size = int64(len(content))
+			r.Review.Checks[i] = ReviewCheck{Name: check.Name, Status: status, Reason: reason, CheckedAt: time.Now().UTC().Format(time.RFC3339)}
+			return nil
+		})
+		if errors.Is(updateErr, errReviewSuperseded) {
+			deleteHeld(ctx, rec.Review)
+			return map[string]string{"status": "superseded"}, nil
+		}
+		if updateErr != nil {
+			return nil, updateErr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s check: %w", check.Name, err)
+		}
+
+		switch status {
+		case reviewPending:
+			return map[string]string{"status": reviewPending, "waiting_on": check.Name}, nil
+		case reviewRejected:
+			if reason != "" {
+				reason = check.Name + ": " + reason
+			}
+			review, err := decideReview(ctx, params.Filename, params.ID, reviewRejected, reason, check.Name)
+			return reviewResult(review), err
+		}
+	}
+	review, err := decideReview(ctx, params.Filename, params.ID, reviewApproved, "", "checks")
+	return reviewResult(review), err
+}
+
+func reviewResult(review *ReviewRecord) map[string]string {
+	if review == nil {
+		return nil
+	}
+	return map[string]string{"status": review.Status}
+}
+
+// reviewUpload runs one check on a held upload, trying again after it
+// fails, and returns what it decided.
+func reviewUpload(ctx context.Context, check string, rec *reviewRecord, content *[]byte) (status, reason string, err error) {
+	delay := quarantineRetryDelay
+	for attempt := 1; ; attempt++ {
+		status, reason, err = runReviewCheck(ctx, check, rec, content)
+		if err == nil || attempt >= quarantineCheckAttempts {
+			return status, reason, err
+		}
+		slog.WarnContext(ctx, "Review check failed", "check", check, "key", rec.Review.Filename, "attempt", attempt, "err", err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return "", "", ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+// runReviewCheck runs check once. The plugins check may replace content,
+// which is then stored again as the held copy.
+func runReviewCheck(ctx context.Context, check string, rec *reviewRecord, content *[]byte) (string, string, error) {
+	switch check {
+	case reviewCheckClamAV:
+		signature, err := scanContent(ctx, *content)
+		if err != nil {
+			uploadsScanned.add(1, "failed")
+			return "", "", err
+		}
+		if signature == "" {
+			uploadsScanned.add(1, scanClean)
+			return reviewApproved, "", nil
+		}
+		uploadsScanned.add(1, scanInfected)
+		if clamavAction == scanActionQuarantine {
+			if _, err := quarantineUpload(ctx, rec.Review.UploadedBy, rec.Review.Filename, signature, *content); err != nil {
+				slog.ErrorContext(ctx, "Failed to quarantine infected upload", "key", rec.Review.Filename, "err", err)
+			}
+		}
+		return reviewRejected, "infected with " + signature, nil
+
+	case reviewCheckPlugins:
+		replaced, err := runUploadPlugins(ctx, rec.Review.Filename, *content)
+		var rejected errRejected
+		if errors.As(err, &rejected) {
+			return reviewRejected, rejected.problems[0].Message, nil
+		}
+		if err != nil {
+			return "", "", err
+		}
+		if validator, problems := validateUpload(rec.Review.Filename, replaced); len(problems) > 0 {
+			return reviewRejected, validator + " validator: " + problems[0].Message, nil
+		}
+		if !bytes.Equal(replaced, *content) {
+			if err := putHeld(ctx, rec, replaced); err != nil {
+				return "", "", err
+			}
+			*content = replaced
+		}
+		return reviewApproved, "", nil
+
+	case reviewCheckWebhook:
+		body, err := json.Marshal(ReviewWebhookRequest{
+			ID:          rec.Review.ID,
+			Tenant:      namespaceFrom(ctx).Tenant,
+			Filename:    rec.Review.Filename,
+			Size:        int64(len(*content)),
+			ContentType: rec.Upload.ContentType,
+			UploadedBy:  rec.Review.UploadedBy,
+			Content:     *content,
+		})
+		if err != nil {
+			return "", "", err
+		}
+		var reply ReviewWebhookReply
+		if err := exchangeSigned(ctx, quarantineWebhookURL, currentSetting(&quarantineWebhookSecret), quarantineSignatureHeader, body, &reply); err != nil {
+			return "", "", err
+		}
+		switch reply.Decision {
+		case decisionApprove:
+			return reviewApproved, reply.Reason, nil
+		case decisionReject:
+			return reviewRejected, reply.Reason, nil
+		case reviewPending:
+			return reviewPending, reply.Reason, nil
+		}
+		return "", "", fmt.Errorf("webhook answered decision %q; want %s, %s or %s", reply.Decision, decisionApprove, decisionReject, reviewPending)
+
+	case reviewCheckManual:
+		return reviewPending, "waiting for an admin to decide", nil
+	}
+	return "", "", fmt.Errorf("unknown check %q", check)
+}
+
+// decideReview settles a pending upload: an approved one is stored under
+// its name, and a rejected one thrown away. Only the first decision counts;
+// deciding one already decided fails with errReviewDecided.
+func decideReview(ctx context.Context, name, id, status, reason, by string) (*ReviewRecord, error) {
+	rec, err := updateReview(ctx, name, id, func(r *reviewRecord) error {
+		if r.Review.Status != reviewPending {
+			return errReviewDecided
+		}
+		r.Review.Status, r.Review.Reason = status, reason
+		r.Review.DecidedBy, r.Review.DecidedAt = by, time.Now().UTC().Format(time.RFC3339)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if status == reviewApproved {
+		err := promoteUpload(ctx, rec)
+		if err != nil && !isPreconditionFailed(err) {
+			// Back to pending, to be decided again
+			updateReview(ctx, name, id, func(r *reviewRecord) error {
+				r.Review.Status, r.Review.Reason, r.Review.DecidedBy, r.Review.DecidedAt = reviewPending, "storing it failed: "+err.Error(), "", ""
+				return nil
+			})
+			return nil, err
+		}
+		if err != nil {
+			status, reason = reviewRejected, "the file was created or changed while the upload was held"
+			if rec, err = updateReview(ctx, name, id, func(r *reviewRecord) error {
+				r.Review.Status, r.Review.Reason = status, reason
+				return nil
+			}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	deleteHeld(ctx, rec.Review)
+	uploadReviews.add(1, status)
+	slog.InfoContext(ctx, "Decided held upload", "key", name, "review", id, "decision", status, "reason", reason, "by", by)
+	return &rec.Review, nil
+}
+
+// promoteUpload stores an approved upload under its name, and does what
+// storing it on upload would have done then.
+func promoteUpload(ctx context.Context, rec *reviewRecord) error {
+	content, err := readHeld(ctx, rec.Review)
+	if err != nil {
+		return err
+	}
+	ns := namespaceFrom(ctx)
+	key := ns.key(rec.Review.Filename)
+	input := rec.Upload.input(ns.Bucket, key)
+	input.Metadata[metaReviewStatus] = reviewApproved
+	if reviewDefers(reviewCheckClamAV) {
+		input.Metadata[metaScanStatus] = scanClean
+	}
+	if _, err := putObject(ctx, input, content); err != nil {
+		return err
+	}
+
+	recordEvent(ctx, eventUploaded, key, map[string]string{"size": strconv.Itoa(len(content))})
+	usage.add(ns.Tenant, int64(len(content)), 1)
+	afterUploadHooks(ctx, rec.Review.UploadedBy, input, int64(len(content)))
+	if aclsEnabled && rec.Upload.Owner {
+		if err := putACLEntry(ctx, key, roleOwner, rec.Review.UploadedBy); err != nil {
+			slog.WarnContext(ctx, "Failed to record owner", "key", key, "err", err)
+		}
+	}
+	if rec.Upload.ExpiresAt != nil {
+		if err := writeExpiryMarker(ctx, key, *rec.Upload.ExpiresAt); err != nil {
+			slog.WarnContext(ctx, "Failed to schedule expiry", "key", key, "err", err)
+		}
+	}
+	return nil
+}
+
+// listReviews reads the review record of every upload in ctx's namespace
+// that has one, with the given status unless it is empty.
+func listReviews(ctx context.Context, status string) ([]ReviewRecord, error) {
+	ns := namespaceFrom(ctx)
+	prefix := ns.key(quarantineStatusPrefix)
+	var names []string
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(ns.Bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			names = append(names, strings.TrimSuffix(strings.TrimPrefix(aws.ToString(obj.Key), prefix), ".json"))
+		}
+	}
+
+	records := make([]*reviewRecord, len(names))
+	if err := fanOut(ctx, len(names), func(ctx context.Context, i int) error {
+		rec, _, err := readReview(ctx, names[i])
+		records[i] = rec
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	reviews := []ReviewRecord{}
+	for _, rec := range records {
+		if rec != nil && (status == "" || rec.Review.Status == status) {
+			reviews = append(reviews, rec.Review)
+		}
+	}
+	return reviews, nil
+}
+
+func listReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	respondReviews(w, r)
+}
+
+// adminListReviewsHandler lists the held uploads of any tenant.
+func adminListReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	ns, ok := adminReviewNamespace(w, r)
+	if !ok {
+		return
+	}
+	respondReviews(w, r.WithContext(withNamespace(r.Context(), ns)))
+}
+
+func respondReviews(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status != "" && status != reviewPending && status != reviewApproved && status != reviewRejected {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid status",
+			Details: "must be one of pending, approved, rejected",
+		})
+		return
+	}
+	reviews, err := listReviews(r.Context(), status)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to list reviews",
+			Details: err.Error(),
+		})
+		return
+	}
+	respondJSON(w, http.StatusOK, ReviewsResponse{Reviews: reviews})
+}
+
+func getReviewHandler(w http.ResponseWriter, r *http.Request) {
+	rec, _, err := readReview(r.Context(), mux.Vars(r)["filename"])
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to read review",
+			Details: err.Error(),
+		})
+		return
+	}
+	if rec == nil {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{
+			Error: "No upload of this file has been held for review",
+		})
+		return
+	}
+	respondJSON(w, http.StatusOK, rec.Review)
+}
+
+// decideReviewHandler approves or rejects a tenant's pending upload, for
+// the manual check or a webhook that answered pending.
+func decideReviewHandler(w http.ResponseWriter, r *http.Request) {
+	ns, ok := adminReviewNamespace(w, r)
+	if !ok {
+		return
+	}
+	var req ReviewDecisionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondInvalidBody(w, err)
+		return
+	}
+	var status string
+	switch req.Decision {
+	case decisionApprove:
+		status = reviewApproved
+	case decisionReject:
+		status = reviewRejected
+	default:
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid decision",
+			Details: "must be approve or reject",
+		})
+		return
+	}
+
+	ctx := withNamespace(r.Context(), ns)
+	name := mux.Vars(r)["filename"]
+	rec, _, err := readReview(ctx, name)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to read review",
+			Details: err.Error(),
+		})
+		return
+	}
+	if rec == nil {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{
+			Error: "No upload of this file has been held for review",
+		})
+		return
+	}
+	review, err := decideReview(ctx, name, rec.Review.ID, status, strings.TrimSpace(req.Reason), requestSubject(r))
+	if errors.Is(err, errReviewDecided) || errors.Is(err, errReviewSuperseded) {
+		respondJSON(w, http.StatusConflict, ErrorResponse{
+			Error:   "Upload is not pending",
+			Details: err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to decide upload",
+			Details: err.Error(),
+		})
+		return
+	}
+	respondJSON(w, http.StatusOK, review)
+}
+
+// adminReviewNamespace is the namespace of the tenant named by ?tenant=, or
+// the default one.
+func adminReviewNamespace(w http.ResponseWriter, r *http.Request) (namespace, bool) {
+	tenant := r.URL.Query().Get("tenant")
+	if tenant == "" {
+		return rootNamespace(), true
+	}
+	if !validTenantID.MatchString(tenant) {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid tenant",
+			Details: "tenant is not a valid tenant id",
+		})
+		return namespace{}, false
+	}
+	return namespaceFor(tenant), true
+}
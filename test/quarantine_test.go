@@ -0,0 +1,238 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"test-api/internal/fakes3"
+)
+
+// waitReview polls the review of name until done says it has got far enough.
+func waitReview(t *testing.T, srv *httptest.Server, name string, done func(ReviewRecord) bool, headers ...string) ReviewRecord {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var review ReviewRecord
+		call(t, srv, "GET", "/api/quarantine/"+name, nil, headers...).decode(t, &review)
+		if done(review) {
+			return review
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("review of %s got no further than %+v", name, review)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func decided(review ReviewRecord) bool { return review.Status != reviewPending }
+
+// waiting reports whether the review is waiting on its last check.
+func waiting(review ReviewRecord) bool {
+	return len(review.Checks) > 0 && review.Checks[len(review.Checks)-1].Reason != ""
+}
+
+func heldKeys(fake *fakes3.Client) []string {
+	var held []string
+	for _, key := range fake.Keys(bucketName) {
+		if strings.Contains(key, quarantinePendingPrefix) {
+			held = append(held, key)
+		}
+	}
+	return held
+}
+
+func TestQuarantineReview(t *testing.T) {
+	srv, fake := newTestServer(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanned := fakeClamd(t, listener)
+	override(t, &clamavAddress, "tcp://"+listener.Addr().String())
+	override(t, &clamavAction, scanActionReject)
+
+	var asked atomic.Int32
+	moderator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte("moderator-secret"))
+		mac.Write(body)
+		if r.Header.Get(quarantineSignatureHeader) != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+		asked.Add(1)
+		var req ReviewWebhookRequest
+		json.Unmarshal(body, &req)
+		reply := ReviewWebhookReply{Decision: decisionApprove}
+		if strings.Contains(string(req.Content), "password") {
+			reply = ReviewWebhookReply{Decision: decisionReject, Reason: "contains a password"}
+		}
+		json.NewEncoder(w).Encode(reply)
+	}))
+	defer moderator.Close()
+	override(t, &quarantineChecks, []string{reviewCheckClamAV, reviewCheckWebhook})
+	override(t, &quarantineWebhookURL, moderator.URL)
+	override(t, &quarantineWebhookSecret, "moderator-secret")
+	override(t, &quarantineRetryDelay, time.Millisecond)
+
+	resp := call(t, srv, "POST", "/api/upload", upload("notes.txt", "hello"))
+	expectStatus(t, resp, http.StatusAccepted)
+	var held MessageResponse
+	resp.decode(t, &held)
+	if held.ReviewStatus != reviewPending || held.Filename != "notes.txt" {
+		t.Errorf("held upload answered %+v", held)
+	}
+	review := waitReview(t, srv, "notes.txt", decided)
+	if review.Status != reviewApproved || len(review.Checks) != 2 || review.Checks[0].Status != reviewApproved || review.Checks[1].Status != reviewApproved {
+		t.Fatalf("clean upload reviewed as %+v", review)
+	}
+	body, meta, _ := fake.Object(bucketName, "notes.txt")
+	if string(body) != "hello" || meta[metaReviewStatus] != reviewApproved || meta[metaScanStatus] != scanClean {
+		t.Errorf("approved upload stored as %q with %v", body, meta)
+	}
+	var metadata FileMetadata
+	call(t, srv, "GET", "/api/files/notes.txt/metadata", nil).decode(t, &metadata)
+	if metadata.ReviewStatus != reviewApproved || metadata.ScanStatus != scanClean {
+		t.Errorf("metadata review status %q, scan status %q", metadata.ReviewStatus, metadata.ScanStatus)
+	}
+
+	// Rejected by the first check, the webhook isn't asked
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("invoice.pdf", eicar)), http.StatusAccepted)
+	review = waitReview(t, srv, "invoice.pdf", decided)
+	if review.Status != reviewRejected || review.Reason != "clamav: infected with Eicar-Test-Signature" || review.Checks[1].Status != reviewPending {
+		t.Errorf("infected upload reviewed as %+v", review)
+	}
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("config.txt", "password=hunter2")), http.StatusAccepted)
+	review = waitReview(t, srv, "config.txt", decided)
+	if review.Status != reviewRejected || review.Reason != "webhook: contains a password" || review.DecidedBy != reviewCheckWebhook {
+		t.Errorf("moderated upload reviewed as %+v", review)
+	}
+	for _, name := range []string{"invoice.pdf", "config.txt"} {
+		if _, _, ok := fake.Object(bucketName, name); ok {
+			t.Errorf("rejected %s was stored", name)
+		}
+	}
+	if held := heldKeys(fake); len(held) != 0 {
+		t.Errorf("held copies left behind: %v", held)
+	}
+	if n, m := scanned.Load(), asked.Load(); n != 3 || m != 2 {
+		t.Errorf("%d uploads scanned and %d moderated", n, m)
+	}
+
+	var list ReviewsResponse
+	call(t, srv, "GET", "/api/quarantine?status=rejected", nil).decode(t, &list)
+	if len(list.Reviews) != 2 {
+		t.Errorf("listed rejected uploads %+v", list.Reviews)
+	}
+	var files FilesResponse
+	call(t, srv, "GET", "/api/files", nil).decode(t, &files)
+	if len(files.Files) != 1 || files.Files[0] != "notes.txt" {
+		t.Errorf("listed files %v", files.Files)
+	}
+	expectStatus(t, call(t, srv, "GET", "/api/quarantine?status=maybe", nil), http.StatusBadRequest)
+
+	// Without overwrite, an existing file is refused straight away
+	expectStatus(t, call(t, srv, "POST", "/api/upload?overwrite=false", upload("notes.txt", "again")), http.StatusConflict)
+
+	// A check that keeps failing leaves the upload pending
+	listener.Close()
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("later.txt", "hello")), http.StatusAccepted)
+	review = waitReview(t, srv, "later.txt", func(r ReviewRecord) bool { return r.Checks[0].Reason != "" })
+	if review.Status != reviewPending || !strings.HasPrefix(review.Checks[0].Reason, "check failed") {
+		t.Errorf("upload with clamd down reviewed as %+v", review)
+	}
+}
+
+func TestQuarantineManualDecision(t *testing.T) {
+	srv, fake := newTestServer(t)
+	override(t, &apiKeys, map[string]string{"acme-key": "acme"})
+	override(t, &adminToken, "admin-secret")
+	override(t, &webdavEnabled, true)
+	override(t, &quarantineChecks, []string{reviewCheckManual})
+	admin := []string{"Authorization", "Bearer admin-secret"}
+	acme := []string{"X-API-Key", "acme-key"}
+
+	expectStatus(t, call(t, srv, "POST", "/api/upload?overwrite=true", upload("report.txt", "draft"), acme...), http.StatusAccepted)
+	first := waitReview(t, srv, "report.txt", waiting, acme...)
+	// A later upload of the name takes the place of the one held
+	expectStatus(t, call(t, srv, "POST", "/api/upload?overwrite=true", upload("report.txt", "final"), acme...), http.StatusAccepted)
+	second := waitReview(t, srv, "report.txt", waiting, acme...)
+	if first.ID == second.ID {
+		t.Fatal("second upload kept the first one's review")
+	}
+	// Uploads through storeFile are held too
+	if resp := call(t, srv, "PUT", "/api/dav/notes.txt", "hello", acme...); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("WebDAV upload answered %d", resp.StatusCode)
+	}
+	waitReview(t, srv, "notes.txt", waiting, acme...)
+	if held := heldKeys(fake); len(held) != 2 {
+		t.Errorf("held %v", held)
+	}
+
+	var list ReviewsResponse
+	call(t, srv, "GET", "/api/admin/quarantine?tenant=acme&status=pending", nil, admin...).decode(t, &list)
+	if len(list.Reviews) != 2 {
+		t.Fatalf("listed pending uploads %+v", list.Reviews)
+	}
+	expectStatus(t, call(t, srv, "GET", "/api/admin/quarantine?tenant=../x", nil, admin...), http.StatusBadRequest)
+
+	var review ReviewRecord
+	resp := call(t, srv, "POST", "/api/admin/quarantine/report.txt?tenant=acme", ReviewDecisionRequest{Decision: decisionApprove}, admin...)
+	expectStatus(t, resp, http.StatusOK)
+	resp.decode(t, &review)
+	if review.Status != reviewApproved || review.ID != second.ID {
+		t.Errorf("approved %+v", review)
+	}
+	if body, _, _ := fake.Object(bucketName, "tenants/acme/report.txt"); string(body) != "final" {
+		t.Errorf("approved upload stored as %q", body)
+	}
+
+	resp = call(t, srv, "POST", "/api/admin/quarantine/notes.txt?tenant=acme", ReviewDecisionRequest{Decision: decisionReject, Reason: "not wanted"}, admin...)
+	expectStatus(t, resp, http.StatusOK)
+	resp.decode(t, &review)
+	if review.Status != reviewRejected || review.Reason != "not wanted" {
+		t.Errorf("rejected %+v", review)
+	}
+	if _, _, ok := fake.Object(bucketName, "tenants/acme/notes.txt"); ok {
+		t.Error("rejected upload was stored")
+	}
+	if held := heldKeys(fake); len(held) != 0 {
+		t.Errorf("held copies left behind: %v", held)
+	}
+
+	expectStatus(t, call(t, srv, "POST", "/api/admin/quarantine/notes.txt?tenant=acme", ReviewDecisionRequest{Decision: decisionApprove}, admin...), http.StatusConflict)
+	expectStatus(t, call(t, srv, "POST", "/api/admin/quarantine/missing.txt?tenant=acme", ReviewDecisionRequest{Decision: decisionApprove}, admin...), http.StatusNotFound)
+	expectStatus(t, call(t, srv, "POST", "/api/admin/quarantine/report.txt?tenant=acme", ReviewDecisionRequest{Decision: "maybe"}, admin...), http.StatusBadRequest)
+	expectStatus(t, call(t, srv, "GET", "/api/quarantine/missing.txt", nil, acme...), http.StatusNotFound)
+}
+
+func TestQuarantineSettings(t *testing.T) {
+	override(t, &quarantineChecks, []string{reviewCheckManual, "magic"})
+	if err := checkQuarantineSettings(); err == nil || !strings.Contains(err.Error(), `"magic"`) {
+		t.Errorf("unknown check: %v", err)
+	}
+	override(t, &clamavAddress, "")
+	override(t, &quarantineChecks, []string{reviewCheckClamAV})
+	if err := checkQuarantineSettings(); err == nil || !strings.Contains(err.Error(), "CLAMAV_ADDRESS") {
+		t.Errorf("clamav without clamd: %v", err)
+	}
+	override(t, &quarantineWebhookURL, "")
+	override(t, &quarantineChecks, []string{reviewCheckWebhook})
+	if err := checkQuarantineSettings(); err == nil || !strings.Contains(err.Error(), "QUARANTINE_WEBHOOK_URL") {
+		t.Errorf("webhook without a URL: %v", err)
+	}
+	override(t, &quarantineChecks, []string{reviewCheckManual, reviewCheckPlugins})
+	if err := checkQuarantineSettings(); err != nil {
+		t.Errorf("valid settings: %v", err)
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// defaultTenant owns every object while tenancy is disabled.
+const defaultTenant = "default"
+
+var (
+	// Bytes a tenant may store; 0 means unlimited. TENANT_QUOTAS overrides the
+	// default per tenant, e.g. TENANT_QUOTAS="acme=10737418240,globex=0"
+	defaultQuota = int64(intFromEnv("STORAGE_QUOTA_BYTES", 0))
+	tenantQuotas = parseByteLimits("TENANT_QUOTAS")
+
+	usageRefreshInterval = durationFromEnv("USAGE_REFRESH_INTERVAL", 5*time.Minute)
+
+	usage = &usageTracker{entries: map[string]*tenantUsage{}}
+
+	// Percentages of the quota past which uploads carry X-Quota-Warning and
+	// notifications go out, once per crossing
+	quotaWarningThresholds = parseThresholds(envOr("QUOTA_WARNING_THRESHOLDS", "80"))
+
+	// Warnings are posted to QUOTA_WEBHOOK_URL, signed with the secret, to the
+	// tenant's own webhook when it has one, and mailed to QUOTA_WARNING_EMAIL
+	quotaWebhookURL    = os.Getenv("QUOTA_WEBHOOK_URL")
+	quotaWebhookSecret = os.Getenv("QUOTA_WEBHOOK_SECRET")
+	quotaWarningEmail  = splitList(os.Getenv("QUOTA_WARNING_EMAIL"))
+
+	quotaWarnings = &quotaWarningState{crossed: map[string]bool{}}
+)
+
+// Markers under this prefix of the files bucket record which thresholds each
+// tenant is past, so instances notify once between them.
+const quotaWarningPrefix = ".quota/warnings/"
+
+const eventQuotaWarning = "quota_warning"
+
+// QuotaWarning is the notification sent when a tenant's usage passes a
+// warning threshold.
+type QuotaWarning struct {
+	Event            string  `json:"event"`
+	Tenant           string  `json:"tenant"`
+	ThresholdPercent int     `json:"threshold_percent"`
+	UsedBytes        int64   `json:"used_bytes"`
+	QuotaBytes       int64   `json:"quota_bytes"`
+	PercentUsed      float64 `json:"percent_used"`
+	Time             string  `json:"time"`
+}
+
+type UsageResponse struct {
+	Tenant         string   `json:"tenant"`
+	UsedBytes      int64    `json:"used_bytes"`
+	Objects        int64    `json:"objects"`
+	QuotaBytes     int64    `json:"quota_bytes,omitempty"`
+	RemainingBytes *int64   `json:"remaining_bytes,omitempty"`
+	PercentUsed    *float64 `json:"percent_used,omitempty"`
+	MeasuredAt     string   `json:"measured_at"`
+}
+
+// parseByteLimits reads a per-tenant setting of byte counts, such as
+// TENANT_QUOTAS.
+func parseByteLimits(setting string) map[string]int64 {
+	limits := map[string]int64{}
+	for tenant, limit := range parseAssignments(os.Getenv(setting)) {
+		n, err := strconv.ParseInt(limit, 10, 64)
+		if err != nil {
+			fatal("Invalid byte limit", "setting", setting, "tenant", tenant, "value", limit)
+		}
+		limits[tenant] = n
+	}
+	return limits
+}
+
+func parseThresholds(value string) []int {
+	var thresholds []int
+	for _, part := range splitList(value) {
+		n, err := strconv.Atoi(part)
+		if err != nil || n <= 0 || n >= 100 {
+			fatal("Invalid QUOTA_WARNING_THRESHOLDS: each must be a percentage between 0 and 100", "value", part)
+		}
+		thresholds = append(thresholds, n)
+	}
+	sort.Ints(thresholds)
+	return thresholds
+}
+
+func quotaFor(tenant string) int64 {
+	limit, _ := quotaLimit(tenant)
+	return limit
+}
+
+// quotaLimit is the tenant's quota and whether it is the tenant's own or the
+// default.
+func quotaLimit(tenant string) (int64, string) {
+	if record, ok := registeredTenants.cached(tenant); ok && record.QuotaBytes != nil {
+		return *record.QuotaBytes, "tenant"
+	}
+	if limit, ok := tenantQuotas[tenant]; ok {
+		return limit, "tenant"
+	}
+	return defaultQuota, "default"
+}
+
+type tenantUsage struct {
+	bytes       int64
+	objects     int64
+	refreshedAt time.Time
+}
+
+// usageTracker caches per-tenant consumption. Totals are recounted from a
+// listing once they are older than usageRefreshInterval, and adjusted in
+// between as uploads succeed.
+type usageTracker struct {
+	mu      sync.Mutex
+	entries map[string]*tenantUsage
+}
+
+func (u *usageTracker) get(ctx context.Context, tenant string) (tenantUsage, error) {
+	u.mu.Lock()
+	entry, ok := u.entries[tenant]
+	if ok && time.Since(entry.refreshedAt) < usageRefreshInterval {
+		defer u.mu.Unlock()
+		return *entry, nil
+	}
+	u.mu.Unlock()
+
+	// A tenant's usage spans its own namespace and its share of named buckets
+	namespaces := tenantNamespaces(tenant)
+	parts := make([]tenantUsage, len(namespaces))
+	err := fanOut(ctx, len(namespaces), func(ctx context.Context, i int) error {
+		var err error
+		parts[i], err = measureUsage(ctx, namespaces[i])
+		return err
+	})
+	if err != nil {
+		return tenantUsage{}, err
+	}
+
+	measured := tenantUsage{refreshedAt: time.Now()}
+	for _, part := range parts {
+		measured.bytes += part.bytes
+		measured.objects += part.objects
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.entries[tenant] = &measured
+	return measured, nil
+}
+
+func (u *usageTracker) add(tenant string, bytes, objects int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if entry, ok := u.entries[tenant]; ok {
+		entry.bytes += bytes
+		entry.objects += objects
+	}
+}
+
+func measureUsage(ctx context.Context, ns namespace) (tenantUsage, error) {
+	measured := tenantUsage{refreshedAt: time.Now()}
+
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(ns.Bucket),
+		Prefix: aws.String(ns.Prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return tenantUsage{}, err
+		}
+		for _, obj := range page.Contents {
+			measured.bytes += aws.ToInt64(obj.Size)
+			measured.objects++
+		}
+	}
+
+	return measured, nil
+}
+
+// errQuotaExceeded carries the numbers behind a rejected upload.
+type errQuotaExceeded struct {
+	used, limit, size int64
+	scope             string
+}
+
+func (e errQuotaExceeded) Error() string {
+	return fmt.Sprintf("upload of %d bytes would exceed the quota: %d of %d bytes used", e.size, e.used, e.limit)
+}
+
+// checkQuota returns errQuotaExceeded if storing size more bytes would take
+// the tenant past its quota.
+func checkQuota(ctx context.Context, tenant string, size int64) error {
+	limit, scope := quotaLimit(tenant)
+	if limit <= 0 {
+		return nil
+	}
+
+	current, err := usage.get(ctx, tenant)
+	if err != nil {
+		return err
+	}
+	if current.bytes+size > limit {
+		statusCounts.quotaRefused(tenant)
+		return errQuotaExceeded{used: current.bytes, limit: limit, size: size, scope: scope}
+	}
+	return nil
+}
+
+// quotaWarningState remembers which thresholds this instance knows each
+// tenant is past (true) or below (false); unknown ones are checked against
+// the markers.
+type quotaWarningState struct {
+	mu      sync.Mutex
+	crossed map[string]bool
+}
+
+// warnQuota sets X-Quota-Warning once an upload has taken the tenant past a
+// warning threshold, and notifies about thresholds newly crossed.
+func warnQuota(ctx context.Context, w http.ResponseWriter, tenant string) {
+	limit := quotaFor(tenant)
+	if limit <= 0 || len(quotaWarningThresholds) == 0 {
+		return
+	}
+	current, err := usage.get(ctx, tenant)
+	if err != nil {
+		return
+	}
+	percent := float64(current.bytes) / float64(limit) * 100
+
+	highest := 0
+	for _, threshold := range quotaWarningThresholds {
+		if percent >= float64(threshold) {
+			highest = threshold
+		}
+	}
+	if highest > 0 {
+		w.Header().Set("X-Quota-Warning", fmt.Sprintf("used=%d; limit=%d; threshold=%d", current.bytes, limit, highest))
+	}
+
+	for _, threshold := range quotaWarningThresholds {
+		past := percent >= float64(threshold)
+		newly, err := quotaWarnings.transition(ctx, tenant, threshold, past)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to record quota warning", "tenant", tenant, "threshold", threshold, "err", err)
+			continue
+		}
+		if newly {
+			warning := QuotaWarning{
+				Event:            eventQuotaWarning,
+				Tenant:           tenant,
+				ThresholdPercent: threshold,
+				UsedBytes:        current.bytes,
+				QuotaBytes:       limit,
+				PercentUsed:      percent,
+				Time:             time.Now().UTC().Format(time.RFC3339),
+			}
+			// Notifying mustn't hold up the upload's response
+			go notifyQuotaWarning(context.WithoutCancel(ctx), warning)
+		}
+	}
+}
+
+// transition records whether tenant is past threshold, and reports whether
+// this instance is the one that saw it cross.
+func (s *quotaWarningState) transition(ctx context.Context, tenant string, threshold int, past bool) (bool, error) {
+	id := tenant + "/" + strconv.Itoa(threshold)
+	s.mu.Lock()
+	known, ok := s.crossed[id]
+	s.mu.Unlock()
+	if ok && known == past {
+		return false, nil
+	}
+
+	key := quotaWarningPrefix + id
+	claimed := false
+	if past {
+		_, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:      aws.String(bucketName),
+			Key:         aws.String(key),
+			Body:        strings.NewReader(""),
+			IfNoneMatch: aws.String("*"),
+		})
+		if err != nil && !isPreconditionFailed(err) {
+			return false, err
+		}
+		claimed = err == nil
+	} else {
+		// Dropping back below re-arms the warning
+		if _, err := s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(key),
+		}); err != nil && !isNotFound(err) {
+			return false, err
+		}
+	}
+
+	s.mu.Lock()
+	s.crossed[id] = past
+	s.mu.Unlock()
+	return claimed, nil
+}
+
+func notifyQuotaWarning(ctx context.Context, warning QuotaWarning) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	body, err := json.Marshal(warning)
+	if err != nil {
+		return
+	}
+
+	if quotaWebhookURL != "" {
+		if err := postSigned(ctx, quotaWebhookURL, currentSetting(&quotaWebhookSecret), "X-Quota-Signature", body); err != nil {
+			slog.ErrorContext(ctx, "Quota warning webhook failed", "tenant", warning.Tenant, "err", err)
+		}
+	}
+	if record, ok := registeredTenants.cached(warning.Tenant); ok && record.Webhook != nil {
+		hook := record.Webhook
+		if len(hook.Events) == 0 || contains(hook.Events, eventQuotaWarning) {
+			if err := postSigned(ctx, hook.URL, hook.Secret, "X-Quota-Signature", body); err != nil {
+				slog.ErrorContext(ctx, "Tenant quota warning webhook failed", "tenant", warning.Tenant, "err", err)
+			}
+		}
+	}
+	if len(quotaWarningEmail) > 0 {
+		subject := fmt.Sprintf("Tenant %s has used %d%% of its storage quota", warning.Tenant, warning.ThresholdPercent)
+		text := fmt.Sprintf("Tenant %s is using %d of its %d bytes (%.1f%%).\nUploads are rejected once the quota is reached.\n",
+			warning.Tenant, warning.UsedBytes, warning.QuotaBytes, warning.PercentUsed)
+		if err := sendEmail(ctx, quotaWarningEmail, subject, text); err != nil {
+			slog.ErrorContext(ctx, "Quota warning email failed", "tenant", warning.Tenant, "err", err)
+		}
+	}
+}
+
+// usageReport is a tenant's usage against its quota, as the usage route and
+// GraphQL report it.
+func usageReport(ctx context.Context, tenant string) (UsageResponse, error) {
+	current, err := usage.get(ctx, tenant)
+	if err != nil {
+		return UsageResponse{}, err
+	}
+
+	response := UsageResponse{
+		Tenant:     tenant,
+		UsedBytes:  current.bytes,
+		Objects:    current.objects,
+		MeasuredAt: current.refreshedAt.UTC().Format(time.RFC3339),
+	}
+	if limit := quotaFor(tenant); limit > 0 {
+		remaining := limit - current.bytes
+		if remaining < 0 {
+			remaining = 0
+		}
+		percent := float64(current.bytes) / float64(limit) * 100
+		response.QuotaBytes = limit
+		response.RemainingBytes = &remaining
+		response.PercentUsed = &percent
+	}
+	return response, nil
+}
+
+func usageHandler(w http.ResponseWriter, r *http.Request) {
+	response, err := usageReport(r.Context(), requestTenant(r))
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to measure usage",
+			Details: err.Error(),
+		})
+		return
+	}
+	respondJSON(w, http.StatusOK, response)
+}
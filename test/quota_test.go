@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestQuotaWarnings(t *testing.T) {
+	warnings := make(chan QuotaWarning, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte("hook-secret"))
+		mac.Write(body)
+		if r.Header.Get("X-Quota-Signature") != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var warning QuotaWarning
+		json.Unmarshal(body, &warning)
+		warnings <- warning
+	}))
+	defer hook.Close()
+	override(t, &quotaWebhookURL, hook.URL)
+	override(t, &quotaWebhookSecret, "hook-secret")
+	override(t, &defaultQuota, 100)
+	override(t, &quotaWarningThresholds, []int{50, 90})
+	override(t, &quotaWarnings, &quotaWarningState{crossed: map[string]bool{}})
+	srv, fake := newTestServer(t)
+
+	resp := call(t, srv, "POST", "/api/upload", upload("a.txt", string(make([]byte, 40))))
+	expectStatus(t, resp, http.StatusOK)
+	if got := resp.Header.Get("X-Quota-Warning"); got != "" {
+		t.Fatalf("warning below the thresholds: %q", got)
+	}
+
+	resp = call(t, srv, "POST", "/api/upload", upload("b.txt", string(make([]byte, 20))))
+	expectStatus(t, resp, http.StatusOK)
+	if got := resp.Header.Get("X-Quota-Warning"); got != "used=60; limit=100; threshold=50" {
+		t.Fatalf("warning %q", got)
+	}
+	select {
+	case warning := <-warnings:
+		if warning.Tenant != defaultTenant || warning.ThresholdPercent != 50 || warning.UsedBytes != 60 {
+			t.Fatalf("warning %+v", warning)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no warning was posted")
+	}
+
+	// Still past 50%, so nobody is told again, including instances that
+	// haven't seen the crossing themselves
+	override(t, &quotaWarnings, &quotaWarningState{crossed: map[string]bool{}})
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("c.txt", "x")), http.StatusOK)
+	select {
+	case warning := <-warnings:
+		t.Fatalf("warned again: %+v", warning)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if _, _, ok := fake.Object(bucketName, quotaWarningPrefix+"default/50"); !ok {
+		t.Fatal("crossing was not recorded")
+	}
+
+	// Past the quota itself, uploads are refused
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("d.txt", string(make([]byte, 50)))), http.StatusRequestEntityTooLarge)
+}
+
+// Falling back below a threshold re-arms its warning.
+func TestQuotaWarningRearms(t *testing.T) {
+	newTestServer(t)
+	state := &quotaWarningState{crossed: map[string]bool{}}
+	ctx := context.Background()
+
+	for i, step := range []struct {
+		past, notify bool
+	}{{true, true}, {true, false}, {false, false}, {true, true}} {
+		newly, err := state.transition(ctx, "acme", 80, step.past)
+		if err != nil || newly != step.notify {
+			t.Fatalf("step %d: notify %v, %v", i, newly, err)
+		}
+	}
+	if _, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(quotaWarningPrefix + "acme/80"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestObjectSizeLimits(t *testing.T) {
+	override(t, &maxObjectBytes, 10)
+	override(t, &tenantMaxObjectBytes, map[string]int64{"acme": 4})
+	override(t, &maxMultipartObjectBytes, 1000)
+	override(t, &apiKeys, map[string]string{"acme-key": "acme", "globex-key": "globex"})
+	srv, _ := newTestServer(t)
+
+	var problem ErrorResponse
+	resp := call(t, srv, "POST", "/api/upload", upload("a.txt", "hello"), "X-API-Key", "acme-key")
+	expectStatus(t, resp, http.StatusRequestEntityTooLarge)
+	resp.decode(t, &problem)
+	if problem.Limit == nil || *problem.Limit != (LimitHint{Kind: limitObjectSize, Bytes: 4, Scope: "tenant", Size: 5}) {
+		t.Fatalf("problem %+v", problem)
+	}
+
+	// Other tenants get the default
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("a.txt", "hello"), "X-API-Key", "globex-key"), http.StatusOK)
+	resp = call(t, srv, "POST", "/api/upload", upload("b.txt", "hello world"), "X-API-Key", "globex-key")
+	expectStatus(t, resp, http.StatusRequestEntityTooLarge)
+	resp.decode(t, &problem)
+	if problem.Limit.Scope != "default" || problem.Limit.Bytes != 10 {
+		t.Fatalf("problem %+v", problem)
+	}
+
+	if limit, scope := objectSizeLimit("acme", true); limit != 1000 || scope != "default" {
+		t.Fatalf("multipart limit %d (%s)", limit, scope)
+	}
+}
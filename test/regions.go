@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+var (
+	// Copies of the files bucket in other regions, kept in sync by S3
+	// replication, e.g. STORAGE_READ_REGIONS="eu-west-1=files-eu,ap-southeast-2=files-ap".
+	// Downloads are served from the fastest healthy copy; everything else
+	// uses the files bucket.
+	readRegions = parseAssignments(os.Getenv("STORAGE_READ_REGIONS"))
+
+	// How often every region's latency and health are measured, and how long
+	// a region that failed a call is passed over unless nothing else is left
+	regionProbeInterval = durationFromEnv("STORAGE_REGION_PROBE_INTERVAL", 30*time.Second)
+	regionCooldown      = durationFromEnv("STORAGE_REGION_COOLDOWN", 30*time.Second)
+
+	regions *regionPool
+)
+
+type RegionStatus struct {
+	Region    string  `json:"region"`
+	Bucket    string  `json:"bucket"`
+	Primary   bool    `json:"primary"`
+	Healthy   bool    `json:"healthy"`
+	LatencyMS float64 `json:"latency_ms,omitempty"`
+	LastError string  `json:"last_error,omitempty"`
+	FailedAt  string  `json:"failed_at,omitempty"`
+}
+
+type RegionsResponse struct {
+	Regions []RegionStatus `json:"regions"`
+}
+
+// regionEndpoint is one copy of the files bucket and a client for its region.
+type regionEndpoint struct {
+	region string
+	bucket string
+	client s3API
+
+	mu       sync.Mutex
+	latency  time.Duration
+	measured bool
+	failedAt time.Time
+	lastErr  string
+}
+
+// observe folds a successful call's latency into a moving average, and
+// marks the region healthy again.
+func (e *regionEndpoint) observe(d time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.measured {
+		d = (e.latency*7 + d*3) / 10
+	}
+	e.latency, e.measured = d, true
+	e.failedAt = time.Time{}
+}
+
+func (e *regionEndpoint) fail(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.failedAt = time.Now()
+	e.lastErr = err.Error()
+}
+
+func (e *regionEndpoint) status(primary bool) RegionStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	status := RegionStatus{
+		Region:    e.region,
+		Bucket:    e.bucket,
+		Primary:   primary,
+		Healthy:   e.failedAt.IsZero() || time.Since(e.failedAt) >= regionCooldown,
+		LatencyMS: float64(e.latency.Microseconds()) / 1000,
+		LastError: e.lastErr,
+	}
+	if !e.failedAt.IsZero() {
+		status.FailedAt = e.failedAt.UTC().Format(time.RFC3339)
+	}
+	return status
+}
+
+// regionPool holds the files bucket's region first, then its copies.
+type regionPool struct {
+	endpoints []*regionEndpoint
+}
+
+func newRegionPool(primary *regionEndpoint, replicas ...*regionEndpoint) *regionPool {
+	return &regionPool{endpoints: append([]*regionEndpoint{primary}, replicas...)}
+}
+
+func (p *regionPool) primary() *regionEndpoint {
+	return p.endpoints[0]
+}
+
+// ranked orders the regions to try: healthy ones fastest first, the primary
+// winning ties and unmeasured regions last, then the ones cooling down after
+// a failure.
+func (p *regionPool) ranked() []*regionEndpoint {
+	type candidate struct {
+		endpoint *regionEndpoint
+		status   RegionStatus
+		measured bool
+	}
+	candidates := make([]candidate, len(p.endpoints))
+	for i, e := range p.endpoints {
+		e.mu.Lock()
+		measured := e.measured
+		e.mu.Unlock()
+		candidates[i] = candidate{e, e.status(i == 0), measured}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.status.Healthy != b.status.Healthy {
+			return a.status.Healthy
+		}
+		if a.measured != b.measured {
+			return a.measured
+		}
+		return a.status.LatencyMS < b.status.LatencyMS
+	})
+	ranked := make([]*regionEndpoint, len(candidates))
+	for i, c := range candidates {
+		ranked[i] = c.endpoint
+	}
+	return ranked
+}
+
+// routed reports whether a read of key in bucket may be served by a copy.
+// Bookkeeping objects are read back right after being written, often to
+// make a conditional write, so they always come from the files bucket.
+func (p *regionPool) routed(bucket, key string) bool {
+	return bucket == p.primary().bucket && !isReservedKey(key) && !strings.Contains(key, "/.")
+}
+
+// read makes call against the best region, moving on to the next when one
+// fails. A copy that hasn't got the object, or not this version of it, may
+// just be behind, so the files bucket has the final say.
+func (p *regionPool) read(ctx context.Context, bucket, key string, call func(*regionEndpoint) error) error {
+	if !p.routed(bucket, key) {
+		return call(p.primary())
+	}
+	var err error
+	for _, e := range p.ranked() {
+		start := time.Now()
+		err = call(e)
+		switch {
+		case err == nil:
+			e.observe(time.Since(start))
+			return nil
+		case isNotFound(err) || isPreconditionFailed(err):
+			if e == p.primary() {
+				return err
+			}
+			return call(p.primary())
+		case ctx.Err() != nil:
+			return err
+		}
+		e.fail(err)
+	}
+	return err
+}
+
+// probe measures every region with a HeadBucket, which also brings regions
+// that have recovered back into use.
+func (p *regionPool) probe(ctx context.Context) {
+	for _, e := range p.endpoints {
+		probeCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		start := time.Now()
+		_, err := e.client.HeadBucket(probeCtx, &s3.HeadBucketInput{Bucket: aws.String(e.bucket)})
+		cancel()
+		if err != nil {
+			e.fail(err)
+			continue
+		}
+		e.observe(time.Since(start))
+	}
+}
+
+func startRegionProbes(ctx context.Context) {
+	if regions == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(regionProbeInterval)
+		defer ticker.Stop()
+
+		for {
+			regions.probe(ctx)
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// regionalS3 serves downloads from the pool and everything else from the
+// files bucket's own region.
+type regionalS3 struct {
+	s3API
+	pool *regionPool
+}
+
+func withRegions(pool *regionPool) s3API {
+	return regionalS3{s3API: pool.primary().client, pool: pool}
+}
+
+func (c regionalS3) GetObject(ctx context.Context, in *s3.GetObjectInput, opts ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	var out *s3.GetObjectOutput
+	err := c.pool.read(ctx, aws.ToString(in.Bucket), aws.ToString(in.Key), func(e *regionEndpoint) error {
+		copied := *in
+		copied.Bucket = aws.String(e.bucket)
+		var err error
+		out, err = e.client.GetObject(ctx, &copied, opts...)
+		return err
+	})
+	return out, err
+}
+
+func (c regionalS3) HeadObject(ctx context.Context, in *s3.HeadObjectInput, opts ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	var out *s3.HeadObjectOutput
+	err := c.pool.read(ctx, aws.ToString(in.Bucket), aws.ToString(in.Key), func(e *regionEndpoint) error {
+		copied := *in
+		copied.Bucket = aws.String(e.bucket)
+		var err error
+		out, err = e.client.HeadObject(ctx, &copied, opts...)
+		return err
+	})
+	return out, err
+}
+
+func regionsHandler(w http.ResponseWriter, r *http.Request) {
+	response := RegionsResponse{Regions: []RegionStatus{}}
+	if regions != nil {
+		for i, e := range regions.endpoints {
+			response.Regions = append(response.Regions, e.status(i == 0))
+		}
+	}
+	respondJSON(w, http.StatusOK, response)
+}
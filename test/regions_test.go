@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"test-api/internal/fakes3"
+)
+
+func TestRegionalReads(t *testing.T) {
+	ctx := context.Background()
+	fake := fakes3.New(bucketName, "files-eu")
+	replica := &flakyS3{Client: fake}
+	put := func(bucket, key, body string) {
+		t.Helper()
+		if _, err := fake.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String(bucket), Key: aws.String(key), Body: strings.NewReader(body)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put(bucketName, "a.txt", "primary")
+	put("files-eu", "a.txt", "replica")
+	put(bucketName, "b.txt", "primary")
+	put(bucketName, quotaWarningPrefix+"acme/80", "primary")
+	put("files-eu", quotaWarningPrefix+"acme/80", "replica")
+
+	home := &regionEndpoint{region: "us-east-1", bucket: bucketName, client: fake}
+	eu := &regionEndpoint{region: "eu-west-1", bucket: "files-eu", client: replica}
+	home.observe(50 * time.Millisecond)
+	eu.observe(time.Millisecond)
+	pool := newRegionPool(home, eu)
+	client := withRegions(pool)
+
+	read := func(key string) string {
+		t.Helper()
+		out, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucketName), Key: aws.String(key)})
+		if err != nil {
+			t.Fatalf("%s: %v", key, err)
+		}
+		defer out.Body.Close()
+		body, _ := io.ReadAll(out.Body)
+		return string(body)
+	}
+
+	if got := read("a.txt"); got != "replica" {
+		t.Fatalf("a.txt came from the %s", got)
+	}
+	// Not replicated yet
+	if got := read("b.txt"); got != "primary" {
+		t.Fatalf("b.txt came from the %s", got)
+	}
+	// Bookkeeping never comes from a copy
+	if got := read(quotaWarningPrefix + "acme/80"); got != "primary" {
+		t.Fatalf("marker came from the %s", got)
+	}
+
+	// A failing region is skipped until it answers a probe again
+	replica.err = errors.New("connection reset")
+	if got := read("a.txt"); got != "primary" {
+		t.Fatalf("a.txt came from the %s", got)
+	}
+	if pool.ranked()[0] != home || eu.status(false).Healthy {
+		t.Fatalf("eu-west-1 still in use: %+v", eu.status(false))
+	}
+	replica.err = nil
+	pool.probe(ctx)
+	if pool.ranked()[0] != eu {
+		t.Fatalf("eu-west-1 did not recover: %+v", eu.status(false))
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+)
+
+// SIGHUP reloads the configuration file. The settings below take effect
+// for the next request, without a restart; changes to any other setting
+// are logged and wait for one. The environment and the command line can't
+// change without a restart either, and still override the file.
+var reloadableSettings = map[string]bool{
+	"cors.allow_origin":       true,
+	"limits.rate_limit_rps":   true,
+	"limits.rate_limit_burst": true,
+	"auth.api_keys":           true,
+	"log.level":               true,
+}
+
+// reloadMu guards the settings that change while requests run, other than
+// those in rateLimiters and logLevel, which have their own locks. They are
+// replaced whole, never modified, so readers can keep what they read.
+var reloadMu sync.RWMutex
+
+func currentSetting[T any](setting *T) T {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	return *setting
+}
+
+func setReloadable[T any](setting *T, value T) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	*setting = value
+}
+
+// reloadConfig loads the configuration again and applies the reloadable
+// settings that changed. A configuration with any problem is not applied
+// at all, so a bad edit leaves the server as it was.
+func reloadConfig() error {
+	next, err := loadConfig(configPath, cmdline.getenv)
+	if err != nil {
+		return err
+	}
+	if err := checkReloadedAPIKeys(next.Auth.APIKeys); err != nil {
+		return fmt.Errorf("auth.api_keys (API_KEYS): %w", err)
+	}
+
+	current := map[string]any{}
+	forEachSetting(reflect.ValueOf(&settings).Elem(), "", func(key, _ string, field reflect.Value) {
+		current[key] = field.Interface()
+	})
+	var changed, needRestart []string
+	forEachSetting(reflect.ValueOf(&next).Elem(), "", func(key, _ string, field reflect.Value) {
+		if reflect.DeepEqual(current[key], field.Interface()) {
+			return
+		}
+		if key == "auth.api_keys" && secretSources["API_KEYS"] != "" {
+			// The secret store has the keys
+			return
+		}
+		if reloadableSettings[key] {
+			changed = append(changed, key)
+		} else {
+			needRestart = append(needRestart, key)
+		}
+	})
+
+	for _, key := range changed {
+		switch key {
+		case "cors.allow_origin":
+			setReloadable(&corsOrigin, []string{next.CORS.AllowOrigin}[:1:1])
+		case "limits.rate_limit_rps", "limits.rate_limit_burst":
+			limiters.setRates(float64(next.Limits.RateLimitRPS), float64(next.Limits.RateLimitBurst))
+		case "auth.api_keys":
+			setReloadable(&apiKeys, next.Auth.APIKeys)
+		case "log.level":
+			// Only when the file changed it, so a level set through the admin
+			// API lasts until then
+			logLevel.UnmarshalText([]byte(next.Log.Level))
+		}
+	}
+	// Requests don't read settings, only startup and reloads do. The rest
+	// keep their startup values, so they are reported again until a restart.
+	settings.CORS, settings.Log = next.CORS, next.Log
+	settings.Limits.RateLimitRPS, settings.Limits.RateLimitBurst = next.Limits.RateLimitRPS, next.Limits.RateLimitBurst
+	settings.Auth.APIKeys = next.Auth.APIKeys
+
+	slog.Info("Reloaded configuration", "changed", changed)
+	if len(needRestart) > 0 {
+		slog.Warn("Settings changed that take effect on restart", "settings", needRestart)
+	}
+	return nil
+}
+
+// checkReloadedAPIKeys refuses keys for invalid tenants, as startup does,
+// and refuses to turn tenancy on or off, which would move every request to
+// another namespace while it runs.
+func checkReloadedAPIKeys(keys map[string]string) error {
+	for _, tenant := range keys {
+		if !validTenantID.MatchString(tenant) {
+			return fmt.Errorf("invalid tenant id %q", tenant)
+		}
+	}
+	others := jwtEnabled() || oidcEnabled() || tenantRegistryEnabled
+	if !others && (len(keys) > 0) != (len(currentSetting(&apiKeys)) > 0) {
+		return errors.New("adding the first key or removing the last turns tenancy on or off, which needs a restart")
+	}
+	return nil
+}
+
+// startReloader reloads the configuration on every SIGHUP. Nothing is
+// restarted, so requests in flight carry on.
+func startReloader(ctx context.Context) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hangups)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hangups:
+				if err := reloadConfig(); err != nil {
+					slog.Error("Configuration not reloaded; keeping the current one", "config", describeConfig(), "err", err)
+				}
+			}
+		}
+	}()
+}
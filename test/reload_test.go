@@ -0,0 +1,82 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestReloadConfig(t *testing.T) {
+	path := writeConfig(t, "config.yaml", `
+cors:
+  allow_origin: https://old.example.com
+auth:
+  api_keys: {old-key: acme}
+`)
+	loaded, err := loadConfig(path, noEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	override(t, &configPath, path)
+	override(t, &settings, loaded)
+	override(t, &apiKeys, loaded.Auth.APIKeys)
+	override(t, &corsOrigin, []string{loaded.CORS.AllowOrigin})
+	override(t, &rateLimitRPS, 0)
+	override(t, &rateLimitBurst, 20)
+	override(t, &limiters, &rateLimiters{buckets: map[string]*tokenBucket{}})
+	t.Cleanup(func() { logLevel.Set(slog.LevelInfo) })
+	srv, _ := newTestServer(t)
+
+	// A level set through the admin API outlasts reloads that don't change it
+	logLevel.Set(slog.LevelWarn)
+	os.WriteFile(path, []byte(`
+server:
+  read_timeout: 1m
+cors:
+  allow_origin: https://new.example.com
+limits:
+  rate_limit_rps: 1
+  rate_limit_burst: 1
+auth:
+  api_keys: {new-key: acme}
+`), 0o600)
+	if err := reloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if logLevel.Level() != slog.LevelWarn {
+		t.Errorf("log level reset to %v", logLevel.Level())
+	}
+	if settings.Server.ReadTimeout != loaded.Server.ReadTimeout {
+		t.Errorf("read timeout changed to %v without a restart", settings.Server.ReadTimeout)
+	}
+
+	expectStatus(t, call(t, srv, "GET", "/api/files", nil, "X-API-Key", "old-key"), http.StatusUnauthorized)
+	got := call(t, srv, "GET", "/api/files", nil, "X-API-Key", "new-key")
+	expectStatus(t, got, http.StatusOK)
+	if origin := got.Header.Get("Access-Control-Allow-Origin"); origin != "https://new.example.com" {
+		t.Errorf("CORS origin %q", origin)
+	}
+	expectStatus(t, call(t, srv, "GET", "/api/files", nil, "X-API-Key", "new-key"), http.StatusTooManyRequests)
+
+	os.WriteFile(path, []byte("log:\n  level: debug\n"), 0o600)
+	if err := reloadConfig(); err == nil || !strings.Contains(err.Error(), "tenancy on or off") {
+		t.Errorf("removing every key reloaded: %v", err)
+	}
+	os.WriteFile(path, []byte("log:\n  level: loud\nauth:\n  api_keys: {new-key: acme}\n"), 0o600)
+	if err := reloadConfig(); err == nil {
+		t.Error("invalid configuration reloaded")
+	}
+	if logLevel.Level() != slog.LevelWarn || currentSetting(&apiKeys)["new-key"] != "acme" {
+		t.Errorf("a rejected reload changed settings")
+	}
+
+	os.WriteFile(path, []byte("log:\n  level: debug\nauth:\n  api_keys: {new-key: acme}\n"), 0o600)
+	if err := reloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if logLevel.Level() != slog.LevelDebug {
+		t.Errorf("log level %v after the file changed it", logLevel.Level())
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+var (
+	markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+	markdownPolicy = newMarkdownPolicy(os.Getenv("MARKDOWN_ALLOWED_ELEMENTS"))
+)
+
+// newMarkdownPolicy builds the sanitizer applied to rendered Markdown. With no
+// explicit element list the user-generated-content policy is used.
+func newMarkdownPolicy(allowed string) *bluemonday.Policy {
+	if strings.TrimSpace(allowed) == "" {
+		return bluemonday.UGCPolicy()
+	}
+
+	policy := bluemonday.NewPolicy()
+	for _, element := range strings.Split(allowed, ",") {
+		element = strings.ToLower(strings.TrimSpace(element))
+		if element == "" {
+			continue
+		}
+		policy.AllowElements(element)
+
+		switch element {
+		case "a":
+			policy.AllowAttrs("href", "title").OnElements("a")
+			policy.RequireNoFollowOnLinks(true)
+		case "img":
+			policy.AllowAttrs("src", "alt", "title").OnElements("img")
+		}
+	}
+	policy.AllowStandardURLs()
+
+	return policy
+}
+
+func isMarkdownFile(filename string) bool {
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".md", ".markdown", ".mdown", ".mkd":
+		return true
+	}
+	return false
+}
+
+func renderFileHandler(w http.ResponseWriter, r *http.Request) {
+	filename := mux.Vars(r)["filename"]
+
+	if filename == "" {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Missing filename parameter",
+		})
+		return
+	}
+
+	ns := requestNamespace(r)
+	if !authorizeFile(w, r, ns.key(filename), permRead) {
+		return
+	}
+	result, err := getObject(r.Context(), &s3.GetObjectInput{
+		Bucket: aws.String(ns.Bucket),
+		Key:    aws.String(ns.key(filename)),
+	})
+	if err != nil {
+		status, message := http.StatusInternalServerError, "Failed to read file"
+		if isNotFound(err) {
+			status, message = http.StatusNotFound, "File not found"
+		}
+		respondJSON(w, status, ErrorResponse{
+			Error:   message,
+			Details: err.Error(),
+		})
+		return
+	}
+	defer result.Body.Close()
+
+	contentType := aws.ToString(result.ContentType)
+	if !isMarkdownFile(filename) && !strings.HasPrefix(contentType, "text/markdown") {
+		respondJSON(w, http.StatusUnsupportedMediaType, ErrorResponse{
+			Error: "File is not Markdown",
+		})
+		return
+	}
+
+	source, err := io.ReadAll(result.Body)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to read file",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	var rendered bytes.Buffer
+	if err := markdown.Convert(source, &rendered); err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to render Markdown",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	enableCORS(w)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(markdownPolicy.SanitizeBytes(rendered.Bytes()))
+}
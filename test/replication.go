@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	replicationQueueSize   = 1000
+	replicationMaxAttempts = 3
+	replicationMaxFailures = 50
+)
+
+type replicationOp int
+
+const (
+	replicatePut replicationOp = iota
+	replicateDelete
+)
+
+type replicationJob struct {
+	op       replicationOp
+	bucket   string
+	key      string
+	queuedAt time.Time
+}
+
+type ReplicationFailure struct {
+	Key      string `json:"key"`
+	Error    string `json:"error"`
+	FailedAt string `json:"failed_at"`
+}
+
+type ReplicationStatus struct {
+	Enabled       bool                 `json:"enabled"`
+	TargetBucket  string               `json:"target_bucket,omitempty"`
+	TargetRegion  string               `json:"target_region,omitempty"`
+	Pending       int                  `json:"pending"`
+	Replicated    int64                `json:"replicated"`
+	Failed        int64                `json:"failed"`
+	Dropped       int64                `json:"dropped"`
+	LastLagMillis int64                `json:"last_lag_ms"`
+	MaxLagMillis  int64                `json:"max_lag_ms"`
+	OldestPending string               `json:"oldest_pending,omitempty"`
+	LastSuccess   string               `json:"last_success,omitempty"`
+	Failures      []ReplicationFailure `json:"recent_failures"`
+}
+
+// replicator asynchronously copies written objects to a secondary bucket,
+// which may live in another region.
+type replicator struct {
+	client  s3API
+	bucket  string
+	region  string
+	deletes bool
+	queue   chan replicationJob
+
+	mu          sync.Mutex
+	inFlight    map[*replicationJob]struct{}
+	replicated  int64
+	failed      int64
+	dropped     int64
+	lastLag     time.Duration
+	maxLag      time.Duration
+	lastSuccess time.Time
+	failures    []ReplicationFailure
+}
+
+var objectReplicator *replicator
+
+// startReplicator enables replication when REPLICA_BUCKET is set.
+func startReplicator(ctx context.Context) error {
+	bucket := os.Getenv("REPLICA_BUCKET")
+	if bucket == "" {
+		return nil
+	}
+
+	var opts []func(*config.LoadOptions) error
+	region := os.Getenv("REPLICA_REGION")
+	if region != "" {
+		opts = append(opts, config.WithRegion(region))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return err
+	}
+
+	deletes, _ := strconv.ParseBool(os.Getenv("REPLICATE_DELETES"))
+	rep := &replicator{
+		client:   s3.NewFromConfig(cfg),
+		bucket:   bucket,
+		region:   cfg.Region,
+		deletes:  deletes,
+		queue:    make(chan replicationJob, replicationQueueSize),
+		inFlight: map[*replicationJob]struct{}{},
+	}
+
+	for i := 0; i < intFromEnv("REPLICATION_WORKERS", 4); i++ {
+		go rep.run(ctx)
+	}
+
+	objectReplicator = rep
+	slog.Info("Replicating writes", "bucket", bucket, "region", cfg.Region)
+	return nil
+}
+
+// replicateObject schedules key, in the bucket of ctx's namespace, to be
+// copied to the replica bucket. Internal bookkeeping objects stay local.
+func replicateObject(ctx context.Context, key string) {
+	ns := namespaceFrom(ctx)
+	if objectReplicator != nil && !isReservedKey(ns.name(key)) {
+		objectReplicator.enqueue(replicatePut, ns.Bucket, key)
+	}
+}
+
+// replicateDeletion schedules key to be removed from the replica bucket when
+// REPLICATE_DELETES is enabled.
+func replicateDeletion(ctx context.Context, key string) {
+	ns := namespaceFrom(ctx)
+	if objectReplicator != nil && objectReplicator.deletes && !isReservedKey(ns.name(key)) {
+		objectReplicator.enqueue(replicateDelete, ns.Bucket, key)
+	}
+}
+
+// replicaKey keeps objects from tenants' dedicated buckets apart in the single
+// replica bucket by prefixing them with their source bucket.
+func replicaKey(bucket, key string) string {
+	if bucket == bucketName {
+		return key
+	}
+	return bucket + "/" + key
+}
+
+func (rep *replicator) enqueue(op replicationOp, bucket, key string) {
+	select {
+	case rep.queue <- replicationJob{op: op, bucket: bucket, key: key, queuedAt: time.Now()}:
+	default:
+		// Never block a request on replication; record the miss instead
+		rep.mu.Lock()
+		rep.dropped++
+		rep.recordFailure(key, "replication queue full")
+		rep.mu.Unlock()
+	}
+}
+
+func (rep *replicator) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-rep.queue:
+			rep.process(ctx, &job)
+		}
+	}
+}
+
+func (rep *replicator) process(ctx context.Context, job *replicationJob) {
+	rep.mu.Lock()
+	rep.inFlight[job] = struct{}{}
+	rep.mu.Unlock()
+
+	var err error
+	for attempt := 1; attempt <= replicationMaxAttempts; attempt++ {
+		if err = rep.apply(ctx, job); err == nil || isNotFound(err) {
+			// A source that vanished was deleted after being queued; nothing to copy
+			err = nil
+			break
+		}
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	delete(rep.inFlight, job)
+
+	// Events are recorded against the source bucket
+	ctx = withNamespace(ctx, namespace{Bucket: job.bucket})
+	if err != nil {
+		rep.failed++
+		rep.recordFailure(job.key, err.Error())
+		recordEvent(ctx, eventReplicationFailed, job.key, map[string]string{"error": err.Error()})
+		slog.ErrorContext(ctx, "Replication failed", "key", job.key, "err", err)
+		return
+	}
+
+	lag := time.Since(job.queuedAt)
+	rep.replicated++
+	rep.lastLag = lag
+	if lag > rep.maxLag {
+		rep.maxLag = lag
+	}
+	rep.lastSuccess = time.Now()
+	recordEvent(ctx, eventReplicated, job.key, map[string]string{"bucket": rep.bucket})
+}
+
+// apply copies the stored bytes and metadata as-is, so storage encodings and
+// integrity hashes carry over to the replica.
+func (rep *replicator) apply(ctx context.Context, job *replicationJob) error {
+	if job.op == replicateDelete {
+		_, err := rep.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(rep.bucket),
+			Key:    aws.String(replicaKey(job.bucket, job.key)),
+		})
+		return err
+	}
+
+	source, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(job.bucket),
+		Key:    aws.String(job.key),
+	})
+	if err != nil {
+		return err
+	}
+	defer source.Body.Close()
+
+	_, err = rep.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(rep.bucket),
+		Key:           aws.String(replicaKey(job.bucket, job.key)),
+		Body:          source.Body,
+		ContentLength: source.ContentLength,
+		ContentType:   source.ContentType,
+		Metadata:      source.Metadata,
+	})
+	return err
+}
+
+// recordFailure must be called with rep.mu held.
+func (rep *replicator) recordFailure(key, message string) {
+	rep.failures = append(rep.failures, ReplicationFailure{
+		Key:      key,
+		Error:    message,
+		FailedAt: time.Now().UTC().Format(time.RFC3339),
+	})
+	if len(rep.failures) > replicationMaxFailures {
+		rep.failures = rep.failures[len(rep.failures)-replicationMaxFailures:]
+	}
+}
+
+func (rep *replicator) status() ReplicationStatus {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+
+	status := ReplicationStatus{
+		Enabled:       true,
+		TargetBucket:  rep.bucket,
+		TargetRegion:  rep.region,
+		Pending:       len(rep.queue) + len(rep.inFlight),
+		Replicated:    rep.replicated,
+		Failed:        rep.failed,
+		Dropped:       rep.dropped,
+		LastLagMillis: rep.lastLag.Milliseconds(),
+		MaxLagMillis:  rep.maxLag.Milliseconds(),
+		Failures:      append([]ReplicationFailure{}, rep.failures...),
+	}
+
+	var oldest time.Time
+	for job := range rep.inFlight {
+		if oldest.IsZero() || job.queuedAt.Before(oldest) {
+			oldest = job.queuedAt
+		}
+	}
+	if !oldest.IsZero() {
+		status.OldestPending = oldest.UTC().Format(time.RFC3339)
+	}
+	if !rep.lastSuccess.IsZero() {
+		status.LastSuccess = rep.lastSuccess.UTC().Format(time.RFC3339)
+	}
+
+	return status
+}
+
+func replicationStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if objectReplicator == nil {
+		respondJSON(w, http.StatusOK, ReplicationStatus{Failures: []ReplicationFailure{}})
+		return
+	}
+	respondJSON(w, http.StatusOK, objectReplicator.status())
+}
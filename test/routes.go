@@ -0,0 +1,348 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// middleware wraps a handler, e.g. to authenticate or log the request.
+type middleware func(http.Handler) http.Handler
+
+type route struct {
+	Method  string
+	Path    string
+	Handler http.HandlerFunc
+	Summary string
+}
+
+// routeGroup declares routes that share a path prefix and a middleware chain.
+// Nested groups inherit both, with their own prefix and middleware applied
+// after the parent's.
+type routeGroup struct {
+	Name       string
+	Prefix     string
+	Middleware []middleware
+	Routes     []route
+	Groups     []routeGroup
+}
+
+var requestTimeout = settings.Server.RequestTimeout
+
+// apiRoutes is the whole API. Routes are registered depth first in declaration
+// order, and keys may contain slashes, so routes with a suffix after
+// {filename:.+} must come before the catch-all ones.
+func apiRoutes() routeGroup {
+	return routeGroup{
+		Name:       "api",
+		Prefix:     "/api",
+		Middleware: []middleware{identifyRequests, logAccess, logRequests, instrumentRequests, limitRequestBody, captureRequests, compressResponses},
+		Groups: []routeGroup{
+			{
+				Name: "public",
+				Routes: []route{
+					{"GET", "/health", healthHandler, "Health check with component status"},
+					{"GET", "/openapi.json", openAPIHandler, "OpenAPI description of this API"},
+					{"GET", "/docs/collection", collectionHandler, "Postman collection of every route"},
+					{"GET", "/console", consoleHandler, "Interactive API console, when the admin API is enabled"},
+					{"GET", "/console/{asset}", consoleAssetHandler, "Script and styles of the API console"},
+				},
+			},
+			{
+				// Share and public downloads stream, so they have no request
+				// or server timeout
+				Name:       "share",
+				Middleware: []middleware{rateLimit, streamResponses},
+				Routes: []route{
+					{"GET", "/share/{token}", shareDownloadHandler, "Download a shared file without credentials"},
+					{"POST", "/share/{token}", shareUnlockHandler, "Download a password-protected shared file"},
+					{"GET", "/public/{filename:.+}/torrent", publicTorrentHandler, "Torrent of a large public file, web-seeded by its public URL"},
+					{"GET", "/public/{filename:.+}/mirrors", publicMirrorsHandler, "Mirrors of a public file, healthiest first"},
+					{"GET", "/public/{filename:.+}", publicFileHandler, "Download a public file without credentials"},
+				},
+			},
+			{
+				// The runner's requests carry the cache token in the path, and
+				// archives stream
+				Name:       "actions-cache",
+				Prefix:     "/actions-cache/{token}/_apis/artifactcache",
+				Middleware: []middleware{authorizeActionsCache, rateLimit, streamResponses},
+				Routes: []route{
+					{"GET", "/cache", getActionsCacheHandler, "Find the cache to restore for a key or restore keys"},
+					{"POST", "/caches", reserveActionsCacheHandler, "Reserve a cache to save"},
+					{"PATCH", "/caches/{id}", uploadActionsCacheHandler, "Upload a chunk of a reserved cache"},
+					{"POST", "/caches/{id}", commitActionsCacheHandler, "Finish saving a reserved cache"},
+					{"GET", "/caches/{id}/progress", actionsCacheProgressHandler, "Server-sent events reporting a reserved cache's upload progress"},
+					{"GET", "/artifacts/{id}", downloadActionsCacheHandler, "Download a cache archive"},
+				},
+			},
+			{
+				// S3 clients sign each request, and objects stream
+				Name:       "s3",
+				Prefix:     "/s3",
+				Middleware: []middleware{authorizeS3Gateway, rateLimit, meterRequests, accountCosts, auditRequests, rejectFrozen, streamResponses, authorizePolicy},
+				Routes: []route{
+					{"GET", "", s3ListBucketsHandler, "S3 ListBuckets"},
+					{"GET", "/", s3ListBucketsHandler, "S3 ListBuckets"},
+					{"GET", "/{bucket}/{filename:.+}", s3GetObjectHandler, "S3 GetObject"},
+					{"HEAD", "/{bucket}/{filename:.+}", s3HeadObjectHandler, "S3 HeadObject"},
+					{"PUT", "/{bucket}/{filename:.+}", s3PutObjectHandler, "S3 PutObject"},
+					{"DELETE", "/{bucket}/{filename:.+}", s3DeleteObjectHandler, "S3 DeleteObject"},
+					{"GET", "/{bucket}", s3ListObjectsHandler, "S3 ListObjectsV2"},
+					{"HEAD", "/{bucket}", s3HeadBucketHandler, "S3 HeadBucket"},
+				},
+			},
+			{
+				Name:       "auth",
+				Prefix:     "/auth",
+				Middleware: []middleware{rateLimit, withTimeout(requestTimeout)},
+				Routes: []route{
+					{"GET", "/login", loginHandler, "Start an OIDC browser login"},
+					{"GET", "/callback", callbackHandler, "Finish an OIDC login and set the session cookie"},
+					{"GET", "/logout", logoutHandler, "End the browser session"},
+				},
+			},
+			{
+				Name:       "tenant",
+				Middleware: []middleware{tenantMiddleware, rateLimit, meterRequests, accountCosts, auditRequests, sanitizeKeys, rejectFrozen},
+				Groups: append(fileRouteGroups(),
+					routeGroup{
+						Name:       "service",
+						Middleware: []middleware{withTimeout(requestTimeout), authorizePolicy},
+						Routes: []route{
+							{"GET", "/capabilities", capabilitiesHandler, "Enabled subsystems and their limits"},
+							{"GET", "/auth/session", sessionHandler, "Show the signed-in caller"},
+							{"GET", "/usage", usageHandler, "Storage used against the quota"},
+							{"GET", "/usage/costs", usageCostsHandler, "Storage calls, bytes and cache hits of the tenant's requests, by credential"},
+							{"GET", "/replication/status", replicationStatusHandler, "Replication lag and failures"},
+							{"POST", "/exports", createExportHandler, "Start a listing export"},
+							{"GET", "/exports/{id}", getExportHandler, "Show an export job"},
+							{"GET", "/buckets", listBucketsHandler, "List named buckets"},
+							{"GET", "/audit", auditHandler, "Search the audit log of changes"},
+							{"GET", "/quarantine", listReviewsHandler, "List uploads held for review and how they were decided"},
+							{"GET", "/quarantine/{filename:.+}", getReviewHandler, "Show whether the last upload of a file is pending, approved or rejected"},
+						},
+					},
+					routeGroup{
+						Name:       "build-cache",
+						Prefix:     "/build-cache",
+						Middleware: []middleware{requireBuildCache, streamResponses, authorizePolicy},
+						Routes: []route{
+							{"GET", "/{kind:ac|cas|gradle}/{hash}", getBuildCacheHandler, "Fetch a Bazel or Gradle build cache entry"},
+							{"PUT", "/{kind:ac|cas|gradle}/{hash}", putBuildCacheHandler, "Store a Bazel or Gradle build cache entry"},
+						},
+					},
+					routeGroup{
+						Name:       "webdav",
+						Prefix:     "/dav",
+						Middleware: []middleware{requireWebDAV, streamResponses, authorizePolicy},
+						Routes:     webdavRoutes(),
+					},
+					routeGroup{
+						Name:       "buckets",
+						Prefix:     "/buckets/{bucket}",
+						Middleware: []middleware{bucketMiddleware},
+						Groups:     fileRouteGroups(),
+					},
+				),
+			},
+			adminRoutes(),
+		},
+	}
+}
+
+// adminRoutes is the admin API. It is never rate limited, so operators can
+// still act when clients are being turned away.
+func adminRoutes() routeGroup {
+	return routeGroup{
+		Name:       "admin",
+		Prefix:     "/admin",
+		Middleware: []middleware{requireAdmin, auditAdminRequests, withTimeout(requestTimeout)},
+		Routes: []route{
+			{"GET", "/debug/object/{key:.+}", debugObjectHandler, "Everything known about a raw key"},
+			{"POST", "/captures/token", createCaptureTokenHandler, "Issue a token that opts requests into capture"},
+			{"GET", "/captures", listCapturesHandler, "List captured requests"},
+			{"GET", "/captures/{id}", getCaptureHandler, "Show a captured request and its response"},
+			{"DELETE", "/captures/{id}", deleteCaptureHandler, "Delete a captured request"},
+			{"GET", "/shadow/status", shadowStatusHandler, "Shadow traffic comparisons and recent mismatches"},
+			{"GET", "/slos", latencySLOsHandler, "Latency budgets per route and how fast they're being spent"},
+			{"GET", "/policies", listPoliciesHandler, "List authorization policy rules"},
+			{"POST", "/policies", createPolicyHandler, "Add an authorization policy rule"},
+			{"GET", "/policies/{id}", getPolicyHandler, "Show an authorization policy rule"},
+			{"PUT", "/policies/{id}", updatePolicyHandler, "Replace an authorization policy rule"},
+			{"DELETE", "/policies/{id}", deletePolicyHandler, "Delete an authorization policy rule"},
+			{"GET", "/freezes", listFreezesHandler, "List the content freezes in force"},
+			{"POST", "/freezes", createFreezeHandler, "Freeze writes under a prefix, optionally until a set time"},
+			{"DELETE", "/freezes/{id}", deleteFreezeHandler, "Lift a content freeze"},
+			{"GET", "/plugins", listPluginsHandler, "List the plugin versions in the catalog and how they are enabled"},
+			{"PUT", "/plugins/{name}/{version}", enablePluginHandler, "Enable a plugin version for some or every tenant, or change its config"},
+			{"DELETE", "/plugins/{name}/{version}", disablePluginHandler, "Disable a plugin version"},
+			{"GET", "/webhooks", listWebhooksHandler, "List webhooks and this instance's deliveries to them"},
+			{"POST", "/webhooks", createWebhookHandler, "Register a webhook for file uploads, deletes and moves"},
+			{"DELETE", "/webhooks/{id}", deleteWebhookHandler, "Delete a webhook"},
+			{"GET", "/tenants", listTenantsHandler, "List tenants onboarded through the registry"},
+			{"POST", "/tenants", createTenantHandler, "Onboard a tenant and issue its API keys"},
+			{"GET", "/tenants/{id}", getTenantHandler, "Show an onboarded tenant"},
+			{"POST", "/tenants/{id}/offboard", offboardTenantHandler, "Revoke a tenant's keys, export its files and purge them"},
+			{"GET", "/jobs/{id}", getJobHandler, "Show any background job"},
+			{"POST", "/billing/reports", createBillingReportHandler, "Build a month's usage report"},
+			{"GET", "/billing/reports/{month}", getBillingReportHandler, "Download a month's usage report as JSON or CSV"},
+			{"POST", "/status-reports", createStatusReportHandler, "Build a week's status report, and optionally send it"},
+			{"GET", "/status-reports/{week}", getStatusReportHandler, "Show a week's status report"},
+			{"GET", "/costs", adminCostsHandler, "Request costs of every tenant, heaviest first"},
+			{"GET", "/audit", adminAuditHandler, "Search any tenant's audit log, or the admin API's"},
+			{"GET", "/quarantine", adminListReviewsHandler, "List any tenant's uploads held for review"},
+			{"POST", "/quarantine/{filename:.+}", decideReviewHandler, "Approve or reject a pending upload"},
+			{"GET", "/log-level", getLogLevelHandler, "Show the log level"},
+			{"PUT", "/log-level", setLogLevelHandler, "Change the log level until this instance restarts"},
+			{"GET", "/storage/regions", regionsHandler, "Health and latency of each region's copy of the files bucket"},
+		},
+	}
+}
+
+// opsRoutes is what the admin listener serves: the admin API and the health
+// report, with the request logging and metrics of the API.
+func opsRoutes() routeGroup {
+	return routeGroup{
+		Name:       "api",
+		Prefix:     "/api",
+		Middleware: []middleware{identifyRequests, logAccess, logRequests, instrumentRequests, limitRequestBody, compressResponses},
+		Groups: []routeGroup{
+			{
+				Name: "public",
+				Routes: []route{
+					{"GET", "/health", healthHandler, "Health check with component status"},
+				},
+			},
+			adminRoutes(),
+		},
+	}
+}
+
+// withoutGroup returns g without the groups named name, at any depth.
+func withoutGroup(g routeGroup, name string) routeGroup {
+	var groups []routeGroup
+	for _, child := range g.Groups {
+		if child.Name != name {
+			groups = append(groups, withoutGroup(child, name))
+		}
+	}
+	g.Groups = groups
+	return g
+}
+
+// fileRouteGroups are the file, folder and trash routes, served both for the
+// files bucket and for each named bucket. Streaming responses are exempt from
+// the request and server timeouts.
+func fileRouteGroups() []routeGroup {
+	return []routeGroup{
+		{
+			Name:       "streaming",
+			Middleware: []middleware{streamResponses, authorizePolicy},
+			Routes: []route{
+				{"GET", "/files/{filename:.+}/tail", tailFileHandler, "Tail a file, optionally following appends"},
+				{"GET", "/events/ws", fileEventsSocketHandler, "Push changes to files over a WebSocket as they happen"},
+			},
+		},
+		{
+			Name:       "files",
+			Middleware: []middleware{withTimeout(requestTimeout), authorizePolicy, shadowReads},
+			Routes: []route{
+				{"POST", "/upload", uploadHandler, "Upload a file"},
+				{"GET", "/files", listFilesHandler, "List files"},
+				{"GET", "/files/feed", filesFeedHandler, "Atom feed of the newest files under a prefix"},
+				{"GET", "/files/{filename:.+}/render", renderFileHandler, "Render Markdown as HTML"},
+				{"GET", "/files/{filename:.+}/versions", listVersionsHandler, "List versions of a file"},
+				{"POST", "/files/{filename:.+}/versions/{version_id}/restore", restoreVersionHandler, "Restore a version"},
+				{"GET", "/files/{filename:.+}/checksums", fileChecksumsHandler, "MD5, SHA-1 or stored digests of a file"},
+				{"GET", "/files/{filename:.+}/metadata", fileMetadataHandler, "Show file metadata"},
+				{"GET", "/files/{filename:.+}/legal-hold", getLegalHoldHandler, "Show the legal hold"},
+				{"POST", "/files/{filename:.+}/share", createShareHandler, "Create an expiring share link"},
+				{"GET", "/files/{filename:.+}/acl", getACLHandler, "Show who can access a file"},
+				{"POST", "/files/{filename:.+}/acl/grants", grantAccessHandler, "Grant read or write access"},
+				{"DELETE", "/files/{filename:.+}/acl/grants", revokeAccessHandler, "Revoke a principal's access"},
+				{"PUT", "/files/{filename:.+}/legal-hold", setLegalHoldHandler, "Set or clear the legal hold"},
+				{"GET", "/files/{filename:.+}/mirrors", getMirrorsHandler, "List a public file's mirrors and their health"},
+				{"PUT", "/files/{filename:.+}/mirrors", setMirrorsHandler, "Set the mirrors a public file can also be downloaded from"},
+				{"GET", "/files/{filename:.+}", getFileHandler, "Download a file"},
+				{"DELETE", "/files/{filename:.+}", deleteFileHandler, "Delete a file"},
+				{"GET", "/checksums", checksumFileHandler, "md5sum-style checksum file of everything under a prefix"},
+				{"GET", "/browse/{path:.*}", browseHandler, "Browse folders as HTML indexes and download files, for rclone's http backend"},
+				{"HEAD", "/browse/{path:.*}", headBrowseHandler, "Size, date and ETag of a browsed file"},
+				{"POST", "/folders", createFolderHandler, "Create a folder marker"},
+				{"DELETE", "/folders/{prefix:.+}", deleteFolderHandler, "Delete everything under a prefix"},
+				{"GET", "/trash", listTrashHandler, "List trashed files"},
+				{"POST", "/trash/{filename:.+}/restore", restoreTrashHandler, "Restore a file from the trash"},
+				{"GET", "/channels", listChannelsHandler, "List release channels and what each serves"},
+				{"GET", "/channels/{channel}", getChannelHandler, "Show a release channel and its history"},
+				{"GET", "/channels/{channel}/download", downloadChannelHandler, "Download the version a channel serves"},
+				{"POST", "/channels/{channel}/promote", promoteChannelHandler, "Release a file version to a channel"},
+				{"POST", "/channels/{channel}/rollback", rollbackChannelHandler, "Put a channel's earlier release back"},
+				{"GET", "/aliases", listAliasesHandler, "List aliases, or one file's with ?target="},
+				{"GET", "/aliases/{alias:.+}", getAliasHandler, "Show the file an alias points at"},
+				{"PUT", "/aliases/{alias:.+}", putAliasHandler, "Give a file another name without copying it"},
+				{"DELETE", "/aliases/{alias:.+}", deleteAliasHandler, "Remove an alias, keeping its file"},
+				{"PUT", "/latest/{prefix:.+}", setLatestHandler, "Point a prefix's latest pointer at a file, or at its newest"},
+				{"POST", "/graphql", graphqlHandler, "Query files, folders, tags and usage with GraphQL"},
+				{"GET", "/graphql/schema", graphqlSchemaHandler, "The GraphQL schema, in the schema language"},
+			},
+		},
+	}
+}
+
+// registeredRoute is a route as served, with its full path and the name of
+// the group that declared it.
+type registeredRoute struct {
+	Method  string
+	Path    string
+	Summary string
+	Group   string
+	Handler http.HandlerFunc
+}
+
+// walkRoutes calls fn for every route in g, depth first, with the middleware
+// chain that applies to it.
+func walkRoutes(g routeGroup, prefix string, chain []middleware, fn func(registeredRoute, []middleware)) {
+	prefix += g.Prefix
+	chain = append(append([]middleware{}, chain...), g.Middleware...)
+
+	for _, rt := range g.Routes {
+		fn(registeredRoute{
+			Method:  rt.Method,
+			Path:    prefix + rt.Path,
+			Summary: rt.Summary,
+			Group:   g.Name,
+			Handler: rt.Handler,
+		}, chain)
+	}
+	for _, child := range g.Groups {
+		walkRoutes(child, prefix, chain, fn)
+	}
+}
+
+// buildRouter registers every route in g on a new router, each wrapped in its
+// middleware chain with the outermost group's middleware running first.
+func buildRouter(g routeGroup) *mux.Router {
+	r := mux.NewRouter()
+	walkRoutes(g, "", nil, func(rt registeredRoute, chain []middleware) {
+		var handler http.Handler = rt.Handler
+		for i := len(chain) - 1; i >= 0; i-- {
+			handler = chain[i](handler)
+		}
+		// Named after the operation, which is the action policies decide on
+		r.Handle(rt.Path, handler).Methods(rt.Method).Name(operationID(rt.Handler))
+	})
+
+	// Prometheus scrapes outside the API, so API keys and the request log
+	// don't apply
+	r.Handle("/metrics", http.HandlerFunc(metricsHandler)).Methods("GET")
+
+	// Kubernetes probes, kept terse and unauthenticated; /api/health is the
+	// report for people
+	r.Handle("/livez", http.HandlerFunc(livezHandler)).Methods("GET")
+	r.Handle("/readyz", http.HandlerFunc(readyzHandler)).Methods("GET")
+
+	// Handle preflight CORS requests
+	r.Methods("OPTIONS").HandlerFunc(optionsHandler)
+	return r
+}
@@ -0,0 +1,697 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+)
+
+// The S3 gateway serves a small part of the S3 API under /api/s3, so S3
+// SDKs and tools can use the file store with path-style addressing. Requests
+// are signed with SigV4 using keys configured here, and go through the same
+// ACLs, quotas, freezes, policies and audit log as the REST routes.
+const (
+	sigV4Algorithm  = "AWS4-HMAC-SHA256"
+	sigV4Time       = "20060102T150405Z"
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	s3Namespace     = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+	// Signed requests older or newer than this are refused, as S3 does
+	s3MaxClockSkew = 15 * time.Minute
+	s3MaxListKeys  = 1000
+)
+
+var (
+	// e.g. S3_GATEWAY_KEYS="AKIAEXAMPLE:wJalrXUtnFEMI/K7MDENG=acme"
+	s3GatewayKeys = parseS3GatewayKeys(os.Getenv("S3_GATEWAY_KEYS"))
+
+	// The name S3 clients use for the files bucket; named buckets keep theirs
+	s3GatewayBucket = envOr("S3_GATEWAY_BUCKET", "files")
+)
+
+// s3GatewayKey is an access key's secret and the tenant it acts for.
+type s3GatewayKey struct {
+	secret string
+	tenant string
+}
+
+func parseS3GatewayKeys(value string) map[string]s3GatewayKey {
+	keys := map[string]s3GatewayKey{}
+	for credential, tenant := range parseAssignments(value) {
+		id, secret, ok := strings.Cut(credential, ":")
+		if !ok || id == "" || secret == "" {
+			fatal("S3_GATEWAY_KEYS entries must be <access key id>:<secret>=<tenant>", "access_key_id", id)
+		}
+		keys[id] = s3GatewayKey{secret: secret, tenant: tenant}
+	}
+	return keys
+}
+
+// s3Error is an error as S3 reports it, which SDKs turn into typed errors
+// by its code.
+type s3Error struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e s3Error) Error() string { return e.code + ": " + e.message }
+
+var (
+	errS3AccessDenied = s3Error{http.StatusForbidden, "AccessDenied", "Access Denied"}
+	errS3NoSuchKey    = s3Error{http.StatusNotFound, "NoSuchKey", "The specified key does not exist."}
+	errS3NoSuchBucket = s3Error{http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist"}
+)
+
+func respondS3Error(w http.ResponseWriter, r *http.Request, err error) {
+	var e s3Error
+	if !errors.As(err, &e) {
+		e = s3Error{http.StatusInternalServerError, "InternalError", err.Error()}
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(e.status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	respondXMLBody(w, struct {
+		XMLName   xml.Name `xml:"Error"`
+		Code      string
+		Message   string
+		Resource  string
+		RequestId string
+	}{Code: e.code, Message: e.message, Resource: r.URL.Path, RequestId: w.Header().Get(requestIDHeader)})
+}
+
+// s3HookError answers for an operation a hook vetoed as S3 would for one
+// the caller may not do. Other errors are returned as they are.
+func s3HookError(err error) error {
+	var vetoed errVetoed
+	if errors.As(err, &vetoed) {
+		return s3Error{http.StatusForbidden, "AccessDenied", vetoed.Error()}
+	}
+	return err
+}
+
+// s3PreconditionError answers for a write whose If-Match or If-None-Match
+// didn't hold, as S3 does. Other errors are returned as they are.
+func s3PreconditionError(err error) error {
+	if errors.Is(err, errPreconditionFailed) || isPreconditionFailed(err) {
+		return s3Error{http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold"}
+	}
+	return err
+}
+
+func respondXML(w http.ResponseWriter, body any) {
+	w.Header().Set("Content-Type", "application/xml")
+	respondXMLBody(w, body)
+}
+
+func respondXMLBody(w http.ResponseWriter, body any) {
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(body)
+}
+
+// authorizeS3Gateway checks the request's SigV4 signature, then scopes it
+// to the key's tenant and to the bucket in the path.
+func authorizeS3Gateway(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(s3GatewayKeys) == 0 {
+			respondS3Error(w, r, s3Error{http.StatusNotFound, "NotImplemented", "The S3 gateway is disabled"})
+			return
+		}
+		id, key, err := verifySigV4(r, time.Now())
+		if err != nil {
+			respondS3Error(w, r, err)
+			return
+		}
+
+		ctx := r.Context()
+		if tenancyEnabled() {
+			p := principal{Subject: key.tenant, Tenant: key.tenant, Method: "sigv4"}
+			ctx = context.WithValue(ctx, principalKey{}, p)
+		}
+		setLogPrincipal(ctx, "s3:"+id)
+		ns := namespaceFor(key.tenant)
+		if bucket, ok := mux.Vars(r)["bucket"]; ok && bucket != s3GatewayBucket {
+			name, ok := namedBuckets[bucket]
+			if !ok {
+				respondS3Error(w, r, errS3NoSuchBucket)
+				return
+			}
+			ns = namespaceInBucket(key.tenant, name)
+		}
+		next.ServeHTTP(w, r.WithContext(withNamespace(ctx, ns)))
+	})
+}
+
+// verifySigV4 checks that r was signed, in its Authorization header or
+// its query, by a configured key within the allowed time, and that a body
+// whose hash was signed has that hash.
+func verifySigV4(r *http.Request, now time.Time) (string, s3GatewayKey, error) {
+	query := r.URL.Query()
+	var credential, signedHeaders, signature, date, payloadHash string
+	var expires time.Duration
+	if algorithm := query.Get("X-Amz-Algorithm"); algorithm != "" {
+		if algorithm != sigV4Algorithm {
+			return "", s3GatewayKey{}, s3Error{http.StatusBadRequest, "AuthorizationQueryParametersError", "X-Amz-Algorithm must be " + sigV4Algorithm}
+		}
+		credential, signedHeaders = query.Get("X-Amz-Credential"), query.Get("X-Amz-SignedHeaders")
+		signature, date = query.Get("X-Amz-Signature"), query.Get("X-Amz-Date")
+		payloadHash = unsignedPayload
+		seconds, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+		if err != nil || seconds < 1 || seconds > 7*24*60*60 {
+			return "", s3GatewayKey{}, s3Error{http.StatusBadRequest, "AuthorizationQueryParametersError", "X-Amz-Expires must be between 1 and 604800 seconds"}
+		}
+		expires = time.Duration(seconds) * time.Second
+	} else {
+		params, ok := strings.CutPrefix(r.Header.Get("Authorization"), sigV4Algorithm+" ")
+		if !ok {
+			return "", s3GatewayKey{}, s3Error{http.StatusForbidden, "AccessDenied", "Requests must be signed with " + sigV4Algorithm}
+		}
+		for _, param := range strings.Split(params, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			switch name {
+			case "Credential":
+				credential = value
+			case "SignedHeaders":
+				signedHeaders = value
+			case "Signature":
+				signature = value
+			}
+		}
+		date, payloadHash = r.Header.Get("X-Amz-Date"), r.Header.Get("X-Amz-Content-Sha256")
+		if payloadHash == "" {
+			return "", s3GatewayKey{}, s3Error{http.StatusBadRequest, "InvalidRequest", "Missing required header for this request: x-amz-content-sha256"}
+		}
+	}
+
+	// Credential is <access key id>/<date>/<region>/s3/aws4_request
+	scope := strings.Split(credential, "/")
+	if len(scope) != 5 || scope[3] != "s3" || scope[4] != "aws4_request" || signedHeaders == "" || signature == "" {
+		return "", s3GatewayKey{}, s3Error{http.StatusBadRequest, "AuthorizationHeaderMalformed", "The authorization header is malformed"}
+	}
+	// Without the host, a signature could be replayed against another server
+	if !contains(strings.Split(signedHeaders, ";"), "host") {
+		return "", s3GatewayKey{}, s3Error{http.StatusBadRequest, "AuthorizationHeaderMalformed", "SignedHeaders must include host"}
+	}
+	id := scope[0]
+	key, ok := s3GatewayKeys[id]
+	if !ok {
+		return "", s3GatewayKey{}, s3Error{http.StatusForbidden, "InvalidAccessKeyId", "The access key ID you provided does not exist in our records."}
+	}
+	signedAt, err := time.Parse(sigV4Time, date)
+	if err != nil || signedAt.Format("20060102") != scope[1] {
+		return "", s3GatewayKey{}, s3Error{http.StatusBadRequest, "AuthorizationHeaderMalformed", "X-Amz-Date is missing or doesn't match the credential scope"}
+	}
+	switch {
+	case signedAt.After(now.Add(s3MaxClockSkew)) || expires == 0 && signedAt.Before(now.Add(-s3MaxClockSkew)):
+		return "", s3GatewayKey{}, s3Error{http.StatusForbidden, "RequestTimeTooSkewed", "The difference between the request time and the current time is too large."}
+	case expires > 0 && now.After(signedAt.Add(expires)):
+		return "", s3GatewayKey{}, s3Error{http.StatusForbidden, "AccessDenied", "Request has expired"}
+	}
+
+	canonical := strings.Join([]string{
+		r.Method,
+		s3Escape(r.URL.Path, false),
+		canonicalQuery(query),
+		canonicalHeaders(r, signedHeaders),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	digest := sha256.Sum256([]byte(canonical))
+	stringToSign := strings.Join([]string{sigV4Algorithm, date, strings.Join(scope[1:], "/"), hex.EncodeToString(digest[:])}, "\n")
+
+	signingKey := []byte("AWS4" + key.secret)
+	for _, part := range scope[1:] {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	expected := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return "", s3GatewayKey{}, s3Error{http.StatusForbidden, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided."}
+	}
+
+	switch {
+	case payloadHash == unsignedPayload:
+	case strings.HasPrefix(payloadHash, "STREAMING-"):
+		return "", s3GatewayKey{}, s3Error{http.StatusNotImplemented, "NotImplemented", "Chunked uploads are not supported; sign the whole payload or send UNSIGNED-PAYLOAD"}
+	default:
+		// Bodies are already capped by limitRequestBody
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return "", s3GatewayKey{}, s3Error{http.StatusBadRequest, "IncompleteBody", err.Error()}
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if sum := sha256.Sum256(body); hex.EncodeToString(sum[:]) != payloadHash {
+			return "", s3GatewayKey{}, s3Error{http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed."}
+		}
+	}
+	return id, key, nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape percent-encodes everything but unreserved characters, and
+// slashes unless asked to, the way SigV4 canonicalizes paths and queries.
+func s3Escape(s string, slash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~' || c == '/' && !slash {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// canonicalQuery is every query parameter but the signature, sorted.
+func canonicalQuery(query map[string][]string) string {
+	var params []string
+	for name, values := range query {
+		if name == "X-Amz-Signature" {
+			continue
+		}
+		for _, value := range values {
+			params = append(params, s3Escape(name, true)+"="+s3Escape(value, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// canonicalHeaders lists the signed headers with their values trimmed. Go
+// keeps Host and Content-Length out of the header map.
+func canonicalHeaders(r *http.Request, signedHeaders string) string {
+	var b strings.Builder
+	for _, name := range strings.Split(signedHeaders, ";") {
+		var value string
+		switch name {
+		case "host":
+			value = r.Host
+		case "content-length":
+			value = r.Header.Get("Content-Length")
+			if value == "" {
+				value = strconv.FormatInt(r.ContentLength, 10)
+			}
+		default:
+			// Values shares its slice with the header, so trim into a copy
+			var values []string
+			for _, v := range r.Header.Values(name) {
+				values = append(values, strings.Join(strings.Fields(v), " "))
+			}
+			value = strings.Join(values, ",")
+		}
+		b.WriteString(name + ":" + value + "\n")
+	}
+	return b.String()
+}
+
+type s3Bucket struct {
+	Name         string
+	CreationDate string
+}
+
+type s3ListBucketsResult struct {
+	XMLName xml.Name   `xml:"ListAllMyBucketsResult"`
+	Xmlns   string     `xml:"xmlns,attr"`
+	Owner   s3Owner    `xml:"Owner"`
+	Buckets []s3Bucket `xml:"Buckets>Bucket"`
+}
+
+type s3Owner struct {
+	ID          string
+	DisplayName string
+}
+
+// The buckets have no creation date of their own
+var s3EpochDate = time.Unix(0, 0).UTC().Format(time.RFC3339)
+
+func s3ListBucketsHandler(w http.ResponseWriter, r *http.Request) {
+	tenant := requestTenant(r)
+	result := s3ListBucketsResult{Xmlns: s3Namespace, Owner: s3Owner{ID: tenant, DisplayName: tenant}}
+	for _, name := range append([]string{s3GatewayBucket}, namedBucketNames()...) {
+		result.Buckets = append(result.Buckets, s3Bucket{Name: name, CreationDate: s3EpochDate})
+	}
+	respondXML(w, result)
+}
+
+func s3HeadBucketHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+type s3Object struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+type s3CommonPrefix struct {
+	Prefix string
+}
+
+type s3ListObjectsResult struct {
+	XMLName               xml.Name         `xml:"ListBucketResult"`
+	Xmlns                 string           `xml:"xmlns,attr"`
+	Name                  string           `xml:"Name"`
+	Prefix                string           `xml:"Prefix"`
+	Delimiter             string           `xml:"Delimiter,omitempty"`
+	StartAfter            string           `xml:"StartAfter,omitempty"`
+	EncodingType          string           `xml:"EncodingType,omitempty"`
+	MaxKeys               int32            `xml:"MaxKeys"`
+	KeyCount              int              `xml:"KeyCount"`
+	IsTruncated           bool             `xml:"IsTruncated"`
+	ContinuationToken     string           `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string           `xml:"NextContinuationToken,omitempty"`
+	Contents              []s3Object       `xml:"Contents"`
+	CommonPrefixes        []s3CommonPrefix `xml:"CommonPrefixes"`
+}
+
+// s3ListObjectsHandler is ListObjectsV2. Files the caller can't read and
+// reserved prefixes are left out, so a page can hold fewer keys than asked
+// for even when more follow.
+func s3ListObjectsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("list-type") != "2" {
+		respondS3Error(w, r, s3Error{http.StatusNotImplemented, "NotImplemented", "Only ListObjectsV2 is supported"})
+		return
+	}
+	ctx := r.Context()
+	ns := requestNamespace(r)
+
+	maxKeys := int32(s3MaxListKeys)
+	if value := query.Get("max-keys"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			respondS3Error(w, r, s3Error{http.StatusBadRequest, "InvalidArgument", "max-keys must be a non-negative integer"})
+			return
+		}
+		maxKeys = int32(min(n, s3MaxListKeys))
+	}
+	prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+	input := &s3.ListObjectsV2Input{
+		Bucket:  aws.String(ns.Bucket),
+		Prefix:  aws.String(ns.key(prefix)),
+		MaxKeys: aws.Int32(maxKeys),
+	}
+	if delimiter != "" {
+		input.Delimiter = aws.String(delimiter)
+	}
+	if token := query.Get("continuation-token"); token != "" {
+		input.ContinuationToken = aws.String(token)
+	}
+	if after := query.Get("start-after"); after != "" {
+		input.StartAfter = aws.String(ns.key(after))
+	}
+	page, err := s3Client.ListObjectsV2(ctx, input)
+	if err != nil {
+		respondS3Error(w, r, err)
+		return
+	}
+
+	var names []string
+	for _, obj := range page.Contents {
+		if name := ns.name(aws.ToString(obj.Key)); !isReservedKey(name) {
+			names = append(names, name)
+		}
+	}
+	names, err = readableNames(ctx, requestSubject(r), names)
+	if err != nil {
+		respondS3Error(w, r, err)
+		return
+	}
+	keys := make([]string, len(names))
+	for i, name := range names {
+		keys[i] = ns.key(name)
+	}
+	heads, err := headObjects(ctx, ns.Bucket, keys)
+	if err != nil {
+		respondS3Error(w, r, err)
+		return
+	}
+
+	// Clients ask for URL encoding so any key survives the XML
+	escape := func(s string) string { return s }
+	if query.Get("encoding-type") == "url" {
+		escape = func(s string) string { return s3Escape(s, false) }
+	}
+	result := s3ListObjectsResult{
+		Xmlns:                 s3Namespace,
+		Name:                  mux.Vars(r)["bucket"],
+		Prefix:                escape(prefix),
+		Delimiter:             escape(delimiter),
+		StartAfter:            escape(query.Get("start-after")),
+		EncodingType:          query.Get("encoding-type"),
+		MaxKeys:               maxKeys,
+		IsTruncated:           aws.ToBool(page.IsTruncated),
+		ContinuationToken:     query.Get("continuation-token"),
+		NextContinuationToken: aws.ToString(page.NextContinuationToken),
+	}
+	for i, head := range heads {
+		result.Contents = append(result.Contents, s3Object{
+			Key:          escape(names[i]),
+			LastModified: aws.ToTime(head.LastModified).UTC().Format(time.RFC3339),
+			ETag:         aws.ToString(head.ETag),
+			Size:         originalSize(head),
+			StorageClass: "STANDARD",
+		})
+	}
+	for _, common := range page.CommonPrefixes {
+		if name := ns.name(aws.ToString(common.Prefix)); !isReservedKey(name) {
+			result.CommonPrefixes = append(result.CommonPrefixes, s3CommonPrefix{Prefix: escape(name)})
+		}
+	}
+	result.KeyCount = len(result.Contents) + len(result.CommonPrefixes)
+	respondXML(w, result)
+}
+
+// s3ObjectKey is the storage key of the object in r's path, provided the
+// caller has perm on it. Subresources such as ?tagging or multipart uploads'
+// ?partNumber aren't supported, and mustn't be mistaken for the object. The
+// SDKs add x-id, naming the operation, to every request.
+func s3ObjectKey(r *http.Request, perm string) (string, *fileACL, error) {
+	for param := range r.URL.Query() {
+		if param != "x-id" && !strings.HasPrefix(param, "X-Amz-") {
+			return "", nil, s3Error{http.StatusNotImplemented, "NotImplemented", param + " is not supported"}
+		}
+	}
+	name, err := sanitizeName(mux.Vars(r)["filename"])
+	if err != nil {
+		return "", nil, s3Error{http.StatusBadRequest, "InvalidArgument", err.Error()}
+	}
+	key := requestNamespace(r).key(name)
+	acl, err := checkAccess(r.Context(), requestSubject(r), key, perm)
+	if errors.Is(err, errAccessDenied) {
+		return "", nil, errS3AccessDenied
+	}
+	return key, acl, err
+}
+
+func setS3ObjectHeaders(w http.ResponseWriter, etag, contentType string, modified time.Time) {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	w.Header().Set("Accept-Ranges", "bytes")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+}
+
+func s3HeadObjectHandler(w http.ResponseWriter, r *http.Request) {
+	key, _, err := s3ObjectKey(r, permRead)
+	if err != nil {
+		respondS3Error(w, r, err)
+		return
+	}
+	head, err := s3Client.HeadObject(r.Context(), &s3.HeadObjectInput{
+		Bucket: aws.String(requestNamespace(r).Bucket),
+		Key:    aws.String(key),
+	})
+	if isNotFound(err) {
+		err = errS3NoSuchKey
+	}
+	if err != nil {
+		respondS3Error(w, r, err)
+		return
+	}
+	setS3ObjectHeaders(w, aws.ToString(head.ETag), aws.ToString(head.ContentType), aws.ToTime(head.LastModified))
+	w.Header().Set("Content-Length", strconv.FormatInt(originalSize(head), 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+// s3GetObjectHandler streams the object as uploaded. A ranged request reads
+// the whole object, since storage encodings leave no way to seek in it.
+func s3GetObjectHandler(w http.ResponseWriter, r *http.Request) {
+	key, _, err := s3ObjectKey(r, permRead)
+	if err != nil {
+		respondS3Error(w, r, err)
+		return
+	}
+	if err := runHooks(r.Context(), &hookEvent{Point: hookOnDownload, Subject: requestSubject(r), Filename: requestNamespace(r).name(key)}); err != nil {
+		respondS3Error(w, r, s3HookError(err))
+		return
+	}
+	result, err := getObject(r.Context(), &s3.GetObjectInput{
+		Bucket: aws.String(requestNamespace(r).Bucket),
+		Key:    aws.String(key),
+	})
+	if isNotFound(err) {
+		err = errS3NoSuchKey
+	}
+	if err != nil {
+		respondS3Error(w, r, err)
+		return
+	}
+	defer result.Body.Close()
+
+	modified := aws.ToTime(result.LastModified)
+	setS3ObjectHeaders(w, aws.ToString(result.ETag), aws.ToString(result.ContentType), modified)
+	if r.Header.Get("Range") != "" {
+		content, err := io.ReadAll(result.Body)
+		if err != nil {
+			respondS3Error(w, r, err)
+			return
+		}
+		http.ServeContent(w, r, "", modified, bytes.NewReader(content))
+		return
+	}
+	if result.ContentLength != nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(*result.ContentLength, 10))
+	}
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, result.Body)
+}
+
+func s3PutObjectHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Amz-Copy-Source") != "" {
+		respondS3Error(w, r, s3Error{http.StatusNotImplemented, "NotImplemented", "CopyObject is not supported"})
+		return
+	}
+	ctx := r.Context()
+	ns := requestNamespace(r)
+	tooLarge := func(size int64) bool {
+		limit, _ := objectSizeLimit(ns.Tenant, false)
+		return limit > 0 && size > limit
+	}
+	if tooLarge(r.ContentLength) {
+		respondS3Error(w, r, s3Error{http.StatusBadRequest, "EntityTooLarge", "Your proposed upload exceeds the maximum allowed object size."})
+		return
+	}
+	key, acl, err := s3ObjectKey(r, permWrite)
+	if err != nil {
+		respondS3Error(w, r, err)
+		return
+	}
+	// Existing files are replaced as an upload would replace them: by
+	// default unless UPLOAD_PREVENT_OVERWRITE is set, and with If-Match or
+	// ?overwrite=true whatever it is set to
+	overwrite, err := overwriteAllowed(r)
+	if err != nil {
+		respondS3Error(w, r, s3Error{http.StatusBadRequest, "InvalidArgument", err.Error()})
+		return
+	}
+	content, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondS3Error(w, r, s3Error{http.StatusBadRequest, "IncompleteBody", err.Error()})
+		return
+	}
+	if tooLarge(int64(len(content))) {
+		respondS3Error(w, r, s3Error{http.StatusBadRequest, "EntityTooLarge", "Your proposed upload exceeds the maximum allowed object size."})
+		return
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(ns.Bucket),
+		Key:    aws.String(key),
+	}
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		current, err := checkIfMatch(ctx, ns.Bucket, key, ifMatch)
+		if err != nil {
+			respondS3Error(w, r, s3PreconditionError(err))
+			return
+		}
+		input.IfMatch = aws.String(current)
+	}
+	if !overwrite {
+		input.IfNoneMatch = aws.String("*")
+	}
+	result, err := storeFile(ctx, requestSubject(r), input, content, acl)
+	var rejected errRejected
+	var exceeded errQuotaExceeded
+	var vetoed errVetoed
+	switch {
+	case isPreconditionFailed(err):
+		err = s3PreconditionError(err)
+	case errors.As(err, &rejected):
+		err = s3Error{http.StatusBadRequest, "InvalidArgument", rejected.Error()}
+	case errors.As(err, &vetoed):
+		err = s3HookError(err)
+	case errors.As(err, &exceeded):
+		err = s3Error{http.StatusInsufficientStorage, "QuotaExceeded", exceeded.Error()}
+	}
+	if err != nil {
+		respondS3Error(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", aws.ToString(result.ETag))
+	w.WriteHeader(http.StatusOK)
+}
+
+// s3DeleteObjectHandler succeeds for keys that don't exist, as S3 does.
+// Files with aliases are kept, as a DELETE of them would be.
+func s3DeleteObjectHandler(w http.ResponseWriter, r *http.Request) {
+	key, acl, err := s3ObjectKey(r, permWrite)
+	if err != nil {
+		respondS3Error(w, r, err)
+		return
+	}
+	ctx := r.Context()
+	ns := requestNamespace(r)
+	if exists, err := objectExists(ctx, key); err != nil || !exists {
+		if err != nil {
+			respondS3Error(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	aliases, err := aliasesOf(ctx, ns, ns.name(key))
+	if err != nil {
+		respondS3Error(w, r, err)
+		return
+	}
+	if len(aliases) > 0 {
+		respondS3Error(w, r, s3Error{http.StatusConflict, "OperationAborted", fmt.Sprintf("the file has aliases: %s", strings.Join(aliases, ", "))})
+		return
+	}
+	if err := removeFile(ctx, key, "", acl); err != nil {
+		respondS3Error(w, r, s3HookError(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+// gatewayClient is an S3 SDK client of the gateway, signing with secret.
+func gatewayClient(t *testing.T, rawURL, id, secret string, client *http.Client) *s3.Client {
+	t.Helper()
+	return s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(rawURL + "/api/s3"),
+		UsePathStyle: true,
+		HTTPClient:   client,
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: id, SecretAccessKey: secret}, nil
+		}),
+		RetryMaxAttempts: 1,
+	})
+}
+
+func s3ErrorCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return ""
+}
+
+func TestS3Gateway(t *testing.T) {
+	srv, _ := newTestServer(t)
+	override(t, &s3GatewayKeys, map[string]s3GatewayKey{"AKIDEXAMPLE": {secret: "gateway-secret"}})
+	ctx := context.Background()
+	gw := gatewayClient(t, srv.URL, "AKIDEXAMPLE", "gateway-secret", srv.Client())
+	mustUpload(t, srv, "/api", "reports/q1.csv", "a,b\n1,2\n")
+
+	if _, err := gw.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String("files"),
+		Key:         aws.String("reports/q2 final.csv"),
+		Body:        strings.NewReader("c,d\n3,4\n"),
+		ContentType: aws.String("text/csv"),
+	}); err != nil {
+		t.Fatalf("PutObject: %v", err)
+	}
+	if got := call(t, srv, "GET", "/api/files/reports/q2 final.csv", nil); string(got.body) != "c,d\n3,4\n" {
+		t.Errorf("file put through the gateway has %q", got.body)
+	}
+
+	object, err := gw.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("files"), Key: aws.String("reports/q1.csv")})
+	if err != nil {
+		t.Fatalf("GetObject: %v", err)
+	}
+	body, _ := io.ReadAll(object.Body)
+	object.Body.Close()
+	if string(body) != "a,b\n1,2\n" {
+		t.Errorf("GetObject read %q", body)
+	}
+	ranged, err := gw.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("files"), Key: aws.String("reports/q1.csv"), Range: aws.String("bytes=4-6")})
+	if err != nil {
+		t.Fatalf("ranged GetObject: %v", err)
+	}
+	body, _ = io.ReadAll(ranged.Body)
+	ranged.Body.Close()
+	if string(body) != "1,2" {
+		t.Errorf("ranged GetObject read %q", body)
+	}
+
+	head, err := gw.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("files"), Key: aws.String("reports/q2 final.csv")})
+	if err != nil || aws.ToInt64(head.ContentLength) != 8 || aws.ToString(head.ContentType) != "text/csv" {
+		t.Errorf("HeadObject: %+v, %v", head, err)
+	}
+	if _, err := gw.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("files"), Key: aws.String("missing.txt")}); s3ErrorCode(err) != "NoSuchKey" {
+		t.Errorf("GetObject of a missing key: %v", err)
+	}
+
+	mustUpload(t, srv, "/api", "top.txt", "top")
+	list, err := gw.ListObjectsV2(ctx, &s3.ListObjectsV2Input{Bucket: aws.String("files"), Delimiter: aws.String("/")})
+	if err != nil {
+		t.Fatalf("ListObjectsV2: %v", err)
+	}
+	if len(list.Contents) != 1 || aws.ToString(list.Contents[0].Key) != "top.txt" || aws.ToInt64(list.Contents[0].Size) != 3 {
+		t.Errorf("root listing %+v", list.Contents)
+	}
+	if len(list.CommonPrefixes) != 1 || aws.ToString(list.CommonPrefixes[0].Prefix) != "reports/" {
+		t.Errorf("root prefixes %+v", list.CommonPrefixes)
+	}
+	list, err = gw.ListObjectsV2(ctx, &s3.ListObjectsV2Input{Bucket: aws.String("files"), Prefix: aws.String("reports/")})
+	if err != nil || len(list.Contents) != 2 || aws.ToString(list.Contents[1].Key) != "reports/q2 final.csv" {
+		t.Errorf("reports listing %+v, %v", list, err)
+	}
+
+	if _, err := gw.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String("files"), Key: aws.String("reports/q1.csv")}); err != nil {
+		t.Fatalf("DeleteObject: %v", err)
+	}
+	expectStatus(t, call(t, srv, "GET", "/api/files/reports/q1.csv", nil), http.StatusNotFound)
+	if _, err := gw.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String("files"), Key: aws.String("reports/q1.csv")}); err != nil {
+		t.Errorf("deleting a missing key: %v", err)
+	}
+
+	buckets, err := gw.ListBuckets(ctx, &s3.ListBucketsInput{})
+	if err != nil || len(buckets.Buckets) == 0 || aws.ToString(buckets.Buckets[0].Name) != "files" {
+		t.Errorf("ListBuckets: %+v, %v", buckets, err)
+	}
+	if _, err := gw.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String("nope")}); err == nil {
+		t.Error("HeadBucket of an unknown bucket succeeded")
+	}
+
+	// Presigned URLs carry the signature in the query
+	presigned, err := s3.NewPresignClient(gw).PresignGetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("files"), Key: aws.String("top.txt")}, s3.WithPresignExpires(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := srv.Client().Get(presigned.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "top" {
+		t.Errorf("presigned GET: %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestS3GatewayRefusals(t *testing.T) {
+	srv, _ := newTestServer(t)
+	ctx := context.Background()
+	get := &s3.GetObjectInput{Bucket: aws.String("files"), Key: aws.String("a.txt")}
+
+	if _, err := gatewayClient(t, srv.URL, "AKIDEXAMPLE", "gateway-secret", srv.Client()).GetObject(ctx, get); s3ErrorCode(err) != "NotImplemented" {
+		t.Errorf("disabled gateway: %v", err)
+	}
+
+	override(t, &s3GatewayKeys, map[string]s3GatewayKey{"AKIDEXAMPLE": {secret: "gateway-secret"}})
+	mustUpload(t, srv, "/api", "a.txt", "a")
+	for _, tc := range []struct {
+		id, secret, code string
+	}{
+		{"AKIDEXAMPLE", "wrong-secret", "SignatureDoesNotMatch"},
+		{"AKIDUNKNOWN", "gateway-secret", "InvalidAccessKeyId"},
+	} {
+		if _, err := gatewayClient(t, srv.URL, tc.id, tc.secret, srv.Client()).GetObject(ctx, get); s3ErrorCode(err) != tc.code {
+			t.Errorf("%s/%s: got %v, want %s", tc.id, tc.secret, err, tc.code)
+		}
+	}
+	expectStatus(t, call(t, srv, "GET", "/api/s3/files/a.txt", nil), http.StatusForbidden)
+
+	gw := gatewayClient(t, srv.URL, "AKIDEXAMPLE", "gateway-secret", srv.Client())
+	if _, err := gw.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String("files"), Key: aws.String(".aliases/names/x"), Body: strings.NewReader("x")}); s3ErrorCode(err) != "InvalidArgument" {
+		t.Errorf("PutObject into a reserved prefix: %v", err)
+	}
+
+	// Replacing a file follows the upload default, as overwrite=false
+	// uploads do
+	put := func(body string, options ...func(*s3.PutObjectInput)) error {
+		input := &s3.PutObjectInput{Bucket: aws.String("files"), Key: aws.String("a.txt"), Body: strings.NewReader(body)}
+		for _, option := range options {
+			option(input)
+		}
+		_, err := gw.PutObject(ctx, input)
+		return err
+	}
+	if err := put("b", func(in *s3.PutObjectInput) { in.IfNoneMatch = aws.String("*") }); s3ErrorCode(err) != "PreconditionFailed" {
+		t.Errorf("PutObject with If-None-Match over an existing file: %v", err)
+	}
+	override(t, &preventOverwrite, true)
+	if err := put("b"); s3ErrorCode(err) != "PreconditionFailed" {
+		t.Errorf("PutObject over an existing file: %v", err)
+	}
+	if got := call(t, srv, "GET", "/api/files/a.txt", nil); string(got.body) != "a" {
+		t.Errorf("refused PutObject left %q", got.body)
+	}
+	head, err := gw.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("files"), Key: aws.String("a.txt")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := put("c", func(in *s3.PutObjectInput) { in.IfMatch = aws.String(`"stale"`) }); s3ErrorCode(err) != "PreconditionFailed" {
+		t.Errorf("PutObject with a stale If-Match: %v", err)
+	}
+	if err := put("c", func(in *s3.PutObjectInput) { in.IfMatch = head.ETag }); err != nil {
+		t.Errorf("PutObject with If-Match: %v", err)
+	}
+	if got := call(t, srv, "GET", "/api/files/a.txt", nil); string(got.body) != "c" {
+		t.Errorf("PutObject with If-Match left %q", got.body)
+	}
+	if err := put("new", func(in *s3.PutObjectInput) { in.Key = aws.String("b.txt") }); err != nil {
+		t.Errorf("PutObject of a new file: %v", err)
+	}
+
+	override(t, &maxObjectBytes, int64(4))
+	if _, err := gw.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String("files"), Key: aws.String("big.bin"), Body: strings.NewReader("too large")}); s3ErrorCode(err) != "EntityTooLarge" {
+		t.Errorf("PutObject over the size limit: %v", err)
+	}
+}
+
+func TestSigV4SignedHeaders(t *testing.T) {
+	override(t, &s3GatewayKeys, map[string]s3GatewayKey{"AKIDEXAMPLE": {secret: "gateway-secret"}})
+	now := time.Now().UTC()
+	signed := func(headers string) *http.Request {
+		r, _ := http.NewRequest("GET", "http://gateway/api/s3/files/a.txt", nil)
+		r.Header.Set("X-Amz-Date", now.Format(sigV4Time))
+		r.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+		r.Header.Set("X-Amz-Meta-Note", "  spaced   out  ")
+		r.Header.Set("Authorization", sigV4Algorithm+" Credential=AKIDEXAMPLE/"+now.Format("20060102")+"/us-east-1/s3/aws4_request, SignedHeaders="+headers+", Signature=0000")
+		return r
+	}
+
+	_, _, err := verifySigV4(signed("x-amz-content-sha256;x-amz-date"), now)
+	if e, ok := err.(s3Error); !ok || e.code != "AuthorizationHeaderMalformed" {
+		t.Errorf("signature without host: %v", err)
+	}
+
+	// Checking a signature leaves the headers it trims alone
+	r := signed("host;x-amz-content-sha256;x-amz-date;x-amz-meta-note")
+	_, _, err = verifySigV4(r, now)
+	if e, ok := err.(s3Error); !ok || e.code != "SignatureDoesNotMatch" {
+		t.Errorf("wrong signature: %v", err)
+	}
+	if got := r.Header.Get("X-Amz-Meta-Note"); got != "  spaced   out  " {
+		t.Errorf("header changed to %q", got)
+	}
+}
@@ -0,0 +1,157 @@
+// Generates src/generated.ts from openapi.json: an interface per schema and a
+// method per operation. Run with `npm run generate` after updating the spec.
+import { readFileSync, writeFileSync } from "node:fs";
+import { dirname, join } from "node:path";
+import { fileURLToPath } from "node:url";
+
+const root = join(dirname(fileURLToPath(import.meta.url)), "..");
+const spec = JSON.parse(readFileSync(join(root, "openapi.json"), "utf8"));
+
+const identifier = /^[A-Za-z_$][A-Za-z0-9_$]*$/;
+const propertyName = (name) => (identifier.test(name) ? name : JSON.stringify(name));
+
+function typeOf(schema, indent) {
+  if (schema.$ref) {
+    return schema.$ref.split("/").pop();
+  }
+  switch (schema.type) {
+    case "string":
+      return "string";
+    case "integer":
+    case "number":
+      return "number";
+    case "boolean":
+      return "boolean";
+    case "array":
+      return `${typeOf(schema.items, indent)}[]`;
+    case "object":
+      if (schema.properties) {
+        return objectType(schema, indent);
+      }
+      if (schema.additionalProperties) {
+        return `Record<string, ${typeOf(schema.additionalProperties, indent)}>`;
+      }
+      return "Record<string, unknown>";
+  }
+  return "unknown";
+}
+
+function objectType(schema, indent) {
+  const required = new Set(schema.required ?? []);
+  const fields = Object.keys(schema.properties)
+    .sort()
+    .map((name) => {
+      const optional = required.has(name) ? "" : "?";
+      return `${indent}  ${propertyName(name)}${optional}: ${typeOf(schema.properties[name], indent + "  ")};`;
+    });
+  return `{\n${fields.join("\n")}\n${indent}}`;
+}
+
+function bodyOf(op) {
+  const content = op.requestBody?.content ?? {};
+  if (content["application/json"]) {
+    return { kind: "json", schema: content["application/json"].schema };
+  }
+  if (content["application/x-www-form-urlencoded"]) {
+    return { kind: "form", schema: content["application/x-www-form-urlencoded"].schema };
+  }
+  return null;
+}
+
+// Only JSON responses are decoded; files, text and HTML come back as the raw
+// Response so callers can stream them.
+function responseOf(op) {
+  const [status, success] = Object.entries(op.responses).find(([code]) => code.startsWith("2")) ?? [];
+  if (!status) {
+    return null;
+  }
+  const json = success.content?.["application/json"];
+  return json ? { kind: "json", type: typeOf(json.schema, "  ") } : { kind: "raw", type: "Response" };
+}
+
+const out = [
+  "// Code generated by scripts/generate.mjs from openapi.json. DO NOT EDIT.",
+  "",
+];
+
+for (const name of Object.keys(spec.components.schemas).sort()) {
+  out.push(`export interface ${name} ${objectType(spec.components.schemas[name], "")}`, "");
+}
+
+out.push(
+  "export interface RequestOptions {",
+  "  signal?: AbortSignal;",
+  "  headers?: Record<string, string>;",
+  "}",
+  "",
+  "export interface OperationSpec {",
+  "  id: string;",
+  "  method: string;",
+  "  path: string;",
+  "  pathParams: readonly string[];",
+  "  queryParams: readonly string[];",
+  "  headerParams: readonly string[];",
+  "  body: \"json\" | \"form\" | null;",
+  "}",
+  "",
+);
+
+const operations = [];
+for (const path of Object.keys(spec.paths).sort()) {
+  for (const method of Object.keys(spec.paths[path]).sort()) {
+    const op = spec.paths[path][method];
+    const response = responseOf(op);
+    if (response) {
+      operations.push({ path, method, op, response });
+    }
+  }
+}
+operations.sort((a, b) => a.op.operationId.localeCompare(b.op.operationId));
+
+out.push("export const operations = {");
+for (const { path, method, op } of operations) {
+  const params = op.parameters ?? [];
+  const names = (where) => JSON.stringify(params.filter((p) => p.in === where).map((p) => p.name));
+  out.push(
+    `  ${op.operationId}: {`,
+    `    id: ${JSON.stringify(op.operationId)},`,
+    `    method: ${JSON.stringify(method.toUpperCase())},`,
+    `    path: ${JSON.stringify(path)},`,
+    `    pathParams: ${names("path")},`,
+    `    queryParams: ${names("query")},`,
+    `    headerParams: ${names("header")},`,
+    `    body: ${JSON.stringify(bodyOf(op)?.kind ?? null)},`,
+    "  },",
+  );
+}
+out.push("} as const;", "");
+
+out.push(
+  "export abstract class GeneratedClient {",
+  "  protected abstract call(op: OperationSpec, args: Record<string, unknown>, options?: RequestOptions): Promise<Response>;",
+  "  protected abstract callJSON<T>(op: OperationSpec, args: Record<string, unknown>, options?: RequestOptions): Promise<T>;",
+);
+for (const { op, response } of operations) {
+  const fields = (op.parameters ?? []).map((p) => {
+    const optional = p.required ? "" : "?";
+    return `${propertyName(p.name)}${optional}: ${typeOf(p.schema, "    ")}`;
+  });
+  const body = bodyOf(op);
+  if (body) {
+    fields.push(`body: ${typeOf(body.schema, "    ")}`);
+  }
+  const required = (op.parameters ?? []).some((p) => p.required) || op.requestBody;
+  const args = fields.length === 0 ? "args: Record<string, never> = {}" : `args: { ${fields.join("; ")} }${required ? "" : " = {}"}`;
+  const call = response.kind === "json" ? `this.callJSON<${response.type}>` : "this.call";
+
+  out.push(
+    "",
+    `  /** ${op.summary} */`,
+    `  ${op.operationId}(${args}, options?: RequestOptions): Promise<${response.type}> {`,
+    `    return ${call}(operations.${op.operationId}, args, options);`,
+    "  }",
+  );
+}
+out.push("}", "");
+
+writeFileSync(join(root, "src", "generated.ts"), out.join("\n"));
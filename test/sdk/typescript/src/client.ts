@@ -0,0 +1,257 @@
+import { errorFromResponse } from "./errors.js";
+import { GeneratedClient } from "./generated.js";
+import type { MessageResponse, OperationSpec, RequestOptions, UploadRequest } from "./generated.js";
+
+export interface ClientOptions {
+  /** Where the API is served from; defaults to the current origin in browsers. */
+  baseUrl?: string;
+  /** Sent as X-API-Key. */
+  apiKey?: string;
+  /** A JWT sent as a bearer token, or a function returning a fresh one. */
+  token?: string | (() => string | Promise<string>);
+  /** Whether to send the browser session cookie; defaults to same-origin. */
+  credentials?: RequestCredentials;
+  fetch?: typeof fetch;
+}
+
+/** Upload settings other than the filename and content. */
+export type UploadFields = Omit<UploadRequest, "filename" | "content">;
+
+export interface UploadOptions extends RequestOptions, UploadFields {
+  /** Called as the content is read, with the bytes read so far and the total if known. */
+  onProgress?: (loaded: number, total?: number) => void;
+}
+
+export type UploadSource = Blob | ArrayBuffer | Uint8Array | ReadableStream<Uint8Array>;
+
+/**
+ * Client for the files API. Every operation in the OpenAPI spec is a method;
+ * failures throw an ApiError subclass.
+ */
+export class Client extends GeneratedClient {
+  private readonly baseUrl: string;
+  private readonly options: ClientOptions;
+  private readonly fetchFn: typeof fetch;
+
+  constructor(options: ClientOptions = {}) {
+    super();
+    this.options = options;
+    this.baseUrl = (options.baseUrl ?? "").replace(/\/+$/, "");
+    this.fetchFn = options.fetch ?? globalThis.fetch.bind(globalThis);
+  }
+
+  /** Uploads a file, streaming its content as it is base64 encoded. */
+  async uploadFile(filename: string, source: UploadSource, options: UploadOptions = {}): Promise<MessageResponse> {
+    const { onProgress, signal, headers, ...fields } = options;
+    const total = sizeOf(source);
+    const body = uploadBody(filename, toStream(source), fields, (loaded) => onProgress?.(loaded, total));
+
+    const response = await this.send("POST", "/api/upload", await requestBody(body), {
+      signal,
+      headers: { "Content-Type": "application/json", ...headers },
+    });
+    return (await response.json()) as MessageResponse;
+  }
+
+  /** Downloads a file as a stream instead of buffering it. */
+  async downloadStream(filename: string, options?: RequestOptions & { versionId?: string }): Promise<ReadableStream<Uint8Array>> {
+    const response = await this.getFile({ filename, version_id: options?.versionId }, options);
+    if (!response.body) {
+      throw new Error("response has no body");
+    }
+    return response.body;
+  }
+
+  /** The URL that starts a browser login, returning to returnTo afterwards. */
+  loginUrl(returnTo?: string): string {
+    const query = returnTo ? `?return_to=${encodeURIComponent(returnTo)}` : "";
+    return `${this.baseUrl}/api/auth/login${query}`;
+  }
+
+  logoutUrl(): string {
+    return `${this.baseUrl}/api/auth/logout`;
+  }
+
+  protected async call(op: OperationSpec, args: Record<string, unknown>, options?: RequestOptions): Promise<Response> {
+    let path = op.path;
+    for (const name of op.pathParams) {
+      // Keys may contain slashes, which stay separators
+      const value = String(args[name] ?? "");
+      path = path.replace(`{${name}}`, value.split("/").map(encodeURIComponent).join("/"));
+    }
+    const query = new URLSearchParams();
+    for (const name of op.queryParams) {
+      const value = args[name];
+      if (value !== undefined && value !== null) {
+        query.set(name, String(value));
+      }
+    }
+    const search = query.toString();
+
+    const headers: Record<string, string> = { ...options?.headers };
+    for (const name of op.headerParams) {
+      const value = args[name];
+      if (value !== undefined && value !== null) {
+        headers[name] = String(value);
+      }
+    }
+    let body: BodyInit | undefined;
+    if (op.body === "json") {
+      headers["Content-Type"] = "application/json";
+      body = JSON.stringify(args.body);
+    } else if (op.body === "form") {
+      body = new URLSearchParams(args.body as Record<string, string>);
+    }
+    return this.send(op.method, search ? `${path}?${search}` : path, body, { signal: options?.signal, headers });
+  }
+
+  protected async callJSON<T>(op: OperationSpec, args: Record<string, unknown>, options?: RequestOptions): Promise<T> {
+    const response = await this.call(op, args, options);
+    return (await response.json()) as T;
+  }
+
+  private async send(method: string, path: string, body: BodyInit | undefined, options: RequestOptions): Promise<Response> {
+    const headers: Record<string, string> = { ...options.headers };
+    if (this.options.apiKey) {
+      headers["X-API-Key"] = this.options.apiKey;
+    }
+    const token = typeof this.options.token === "function" ? await this.options.token() : this.options.token;
+    if (token) {
+      headers["Authorization"] = `Bearer ${token}`;
+    }
+
+    const init: RequestInit & { duplex?: "half" } = {
+      method,
+      headers,
+      body,
+      signal: options.signal,
+      credentials: this.options.credentials ?? "same-origin",
+    };
+    if (body instanceof ReadableStream) {
+      init.duplex = "half";
+    }
+    const response = await this.fetchFn(this.baseUrl + path, init);
+    if (!response.ok) {
+      throw await errorFromResponse(response);
+    }
+    return response;
+  }
+}
+
+function sizeOf(source: UploadSource): number | undefined {
+  if (source instanceof Blob) {
+    return source.size;
+  }
+  if (source instanceof ArrayBuffer || source instanceof Uint8Array) {
+    return source.byteLength;
+  }
+  return undefined;
+}
+
+function toStream(source: UploadSource): ReadableStream<Uint8Array> {
+  if (source instanceof ReadableStream) {
+    return source;
+  }
+  if (source instanceof Blob) {
+    return source.stream() as ReadableStream<Uint8Array>;
+  }
+  const bytes = source instanceof Uint8Array ? source : new Uint8Array(source);
+  return new ReadableStream({
+    start(controller) {
+      controller.enqueue(bytes);
+      controller.close();
+    },
+  });
+}
+
+/**
+ * uploadBody streams the JSON upload request, base64 encoding the content a
+ * chunk at a time so large files are never held in memory as one string.
+ */
+export function uploadBody(
+  filename: string,
+  content: ReadableStream<Uint8Array>,
+  fields: UploadFields = {},
+  onProgress?: (loaded: number) => void,
+): ReadableStream<Uint8Array> {
+  const encoder = new TextEncoder();
+  const head = JSON.stringify({ ...fields, filename });
+  const reader = content.getReader();
+  let pending = new Uint8Array(0);
+  let loaded = 0;
+
+  return new ReadableStream<Uint8Array>({
+    start(controller) {
+      controller.enqueue(encoder.encode(`${head.slice(0, -1)},"content":"`));
+    },
+    async pull(controller) {
+      const { done, value } = await reader.read();
+      if (done) {
+        controller.enqueue(encoder.encode(`${base64(pending)}"}`));
+        controller.close();
+        return;
+      }
+      loaded += value.byteLength;
+      onProgress?.(loaded);
+
+      // Encode whole 3-byte groups and carry the rest, so chunks join up
+      // into one valid base64 string
+      const bytes = concat(pending, value);
+      const whole = bytes.byteLength - (bytes.byteLength % 3);
+      pending = bytes.slice(whole);
+      controller.enqueue(encoder.encode(base64(bytes.subarray(0, whole))));
+    },
+    cancel(reason) {
+      return reader.cancel(reason);
+    },
+  });
+}
+
+function concat(a: Uint8Array, b: Uint8Array): Uint8Array {
+  if (a.byteLength === 0) {
+    return b;
+  }
+  const out = new Uint8Array(a.byteLength + b.byteLength);
+  out.set(a);
+  out.set(b, a.byteLength);
+  return out;
+}
+
+function base64(bytes: Uint8Array): string {
+  let binary = "";
+  for (let i = 0; i < bytes.byteLength; i += 0x8000) {
+    binary += String.fromCharCode(...bytes.subarray(i, i + 0x8000));
+  }
+  return btoa(binary);
+}
+
+let streamingSupported: boolean | undefined;
+
+/**
+ * Streams the body where fetch supports streaming requests, and buffers it
+ * into a Blob elsewhere, e.g. in browsers without HTTP/2 streaming uploads.
+ */
+async function requestBody(body: ReadableStream<Uint8Array>): Promise<BodyInit> {
+  if (streamingSupported === undefined) {
+    streamingSupported = supportsRequestStreams();
+  }
+  return streamingSupported ? body : new Response(body).blob();
+}
+
+function supportsRequestStreams(): boolean {
+  try {
+    let duplexAccessed = false;
+    const init = {
+      body: new ReadableStream(),
+      method: "POST",
+      get duplex() {
+        duplexAccessed = true;
+        return "half";
+      },
+    };
+    const hasContentType = new Request("http://localhost", init as RequestInit).headers.has("Content-Type");
+    return duplexAccessed && !hasContentType;
+  } catch {
+    return false;
+  }
+}
@@ -0,0 +1,87 @@
+import type { ErrorResponse, RetryHint, ValidationError } from "./generated.js";
+
+/**
+ * ApiError is thrown for every non-2xx response. Subclasses identify the
+ * common cases, so callers can branch with instanceof instead of comparing
+ * status codes.
+ */
+export class ApiError extends Error {
+  readonly status: number;
+  readonly details?: string;
+  readonly validationErrors: ValidationError[];
+  /** The server's backoff hint while storage is degraded. */
+  readonly retry?: RetryHint;
+  /** Seconds to wait before retrying, from Retry-After, if the server sent one. */
+  readonly retryAfterSeconds?: number;
+  readonly response: Response;
+
+  constructor(response: Response, body: Partial<ErrorResponse>) {
+    super(body.error || `HTTP ${response.status}`);
+    this.name = new.target.name;
+    this.status = response.status;
+    this.details = body.details;
+    this.validationErrors = body.validation_errors ?? [];
+    this.retry = body.retry;
+    this.retryAfterSeconds = parseRetryAfter(response.headers.get("Retry-After")) ?? body.retry?.after_seconds;
+    this.response = response;
+  }
+
+  /** Whether the request may succeed if retried later. */
+  get retryable(): boolean {
+    return this.status === 429 || this.status === 503 || this.retryAfterSeconds !== undefined;
+  }
+}
+
+export class BadRequestError extends ApiError {}
+export class UnauthorizedError extends ApiError {}
+export class ForbiddenError extends ApiError {}
+export class NotFoundError extends ApiError {}
+export class ConflictError extends ApiError {}
+/** The resource existed but is gone for good, such as a used-up share link. */
+export class GoneError extends ApiError {}
+export class PayloadTooLargeError extends ApiError {}
+export class UnsupportedMediaTypeError extends ApiError {}
+/** The upload was rejected by a content validator; see validationErrors. */
+export class ValidationFailedError extends ApiError {}
+export class RateLimitError extends ApiError {}
+export class ServiceUnavailableError extends ApiError {}
+
+const errorClasses: Record<number, typeof ApiError> = {
+  400: BadRequestError,
+  401: UnauthorizedError,
+  403: ForbiddenError,
+  404: NotFoundError,
+  409: ConflictError,
+  410: GoneError,
+  413: PayloadTooLargeError,
+  415: UnsupportedMediaTypeError,
+  422: ValidationFailedError,
+  429: RateLimitError,
+  503: ServiceUnavailableError,
+};
+
+/** Builds the error for a failed response, reading its JSON body if it has one. */
+export async function errorFromResponse(response: Response): Promise<ApiError> {
+  let body: Partial<ErrorResponse> = {};
+  try {
+    if (response.headers.get("Content-Type")?.includes("application/json")) {
+      body = (await response.json()) as Partial<ErrorResponse>;
+    }
+  } catch {
+    // Keep the status even if the body is unreadable
+  }
+  const ErrorClass = errorClasses[response.status] ?? ApiError;
+  return new ErrorClass(response, body);
+}
+
+function parseRetryAfter(value: string | null): number | undefined {
+  if (!value) {
+    return undefined;
+  }
+  const seconds = Number(value);
+  if (Number.isFinite(seconds)) {
+    return Math.max(0, seconds);
+  }
+  const date = Date.parse(value);
+  return Number.isNaN(date) ? undefined : Math.max(0, Math.ceil((date - Date.now()) / 1000));
+}
@@ -0,0 +1,2815 @@
+// Code generated by scripts/generate.mjs from openapi.json. DO NOT EDIT.
+
+export interface ACLGrant {
+  permission: string;
+  principal: string;
+}
+
+export interface ACLResponse {
+  filename: string;
+  grants: ACLGrant[];
+  owner?: string;
+  restricted: boolean;
+}
+
+export interface ActionsCacheCommitRequest {
+  size: number;
+}
+
+export interface ActionsCacheEntry {
+  archiveLocation: string;
+  cacheKey: string;
+  cacheVersion: string;
+  creationTime: string;
+  scope: string;
+}
+
+export interface ActionsCacheReserveRequest {
+  cacheSize?: number;
+  key: string;
+  version: string;
+}
+
+export interface ActionsCacheReserveResponse {
+  cacheId: number;
+}
+
+export interface Alias {
+  alias: string;
+  created_at: string;
+  created_by: string;
+  target: string;
+}
+
+export interface AliasRequest {
+  target: string;
+}
+
+export interface AliasesResponse {
+  aliases: Alias[];
+}
+
+export interface AuditRecord {
+  action: string;
+  actor: string;
+  auth_method?: string;
+  bucket?: string;
+  duration_ms: number;
+  id: string;
+  key?: string;
+  method: string;
+  path: string;
+  result: string;
+  source_ip: string;
+  status: number;
+  tenant: string;
+  time: string;
+  user_agent?: string;
+}
+
+export interface AuditResponse {
+  records: AuditRecord[];
+  truncated: boolean;
+}
+
+export interface BillingReport {
+  complete: boolean;
+  generated_at: string;
+  month: string;
+  records: UsageRecord[];
+}
+
+export interface BillingReportRequest {
+  month: string;
+  push?: boolean;
+}
+
+export interface BucketDiagnosis {
+  bucket: string;
+  bucket_exists?: boolean;
+  credentials_valid?: boolean;
+  error?: string;
+  latency_ms: number;
+  listable?: boolean;
+  reachable?: boolean;
+}
+
+export interface BucketsResponse {
+  buckets: string[];
+}
+
+export interface CapabilitiesResponse {
+  capabilities: Record<string, Capability>;
+  tenant: string;
+  version: string;
+}
+
+export interface Capability {
+  enabled: boolean;
+  limits?: Record<string, number>;
+  options?: Record<string, unknown>;
+}
+
+export interface CaptureSummary {
+  captured_at: string;
+  id: string;
+  size: number;
+}
+
+export interface CaptureTokenRequest {
+  expires_in?: string;
+}
+
+export interface CaptureTokenResponse {
+  expires_at: string;
+  header: string;
+  token: string;
+}
+
+export interface CapturedExchange {
+  captured_at: string;
+  duration_ms: number;
+  id: string;
+  request: CapturedRequest;
+  response: CapturedResponse;
+}
+
+export interface CapturedRequest {
+  body?: string;
+  body_truncated?: boolean;
+  header: Record<string, string[]>;
+  method: string;
+  path: string;
+  query?: string;
+}
+
+export interface CapturedResponse {
+  body?: string;
+  body_truncated?: boolean;
+  header: Record<string, string[]>;
+  status: number;
+}
+
+export interface CapturesResponse {
+  captures: CaptureSummary[];
+}
+
+export interface Channel {
+  current?: ChannelRelease;
+  history: ChannelRelease[];
+  name: string;
+  pending: ChannelRelease[];
+}
+
+export interface ChannelRelease {
+  action: string;
+  actor: string;
+  created_at: string;
+  effective_at: string;
+  etag?: string;
+  filename: string;
+  note?: string;
+  release: number;
+  rolled_back_to?: number;
+  size: number;
+  version_id: string;
+}
+
+export interface ChannelsResponse {
+  channels: Channel[];
+}
+
+export interface ClientCost {
+  CostTotals: CostTotals;
+  client: string;
+}
+
+export interface ComponentStatus {
+  checked_at?: string;
+  critical: boolean;
+  error?: string;
+  last_error?: string;
+  last_error_at?: string;
+  latency_ms: number;
+  status: string;
+}
+
+export interface CostTotals {
+  bytes_read: number;
+  bytes_written: number;
+  cache_hits: number;
+  requests: number;
+  s3_operations: number;
+}
+
+export interface CostsResponse {
+  since: string;
+  tenants: TenantCost[];
+}
+
+export interface CreateTenantRequest {
+  bucket?: string;
+  id: string;
+  keys?: number;
+  max_multipart_object_bytes?: number;
+  max_object_bytes?: number;
+  quota_bytes?: number;
+  webhook?: TenantWebhook;
+}
+
+export interface DayStatus {
+  client_errors: number;
+  date: string;
+  requests: number;
+  server_errors: number;
+}
+
+export interface ErrorResponse {
+  details?: string;
+  error: string;
+  limit?: LimitHint;
+  request_id?: string;
+  retry?: RetryHint;
+  validation_errors?: ValidationError[];
+}
+
+export interface ExportRequest {
+  source: string;
+}
+
+export interface FileChecksums {
+  checksums: Record<string, string>;
+  filename: string;
+}
+
+export interface FileEvent {
+  details?: Record<string, string>;
+  key: string;
+  time: string;
+  type: string;
+}
+
+export interface FileMetadata {
+  content_type?: string;
+  etag?: string;
+  expires_at?: string;
+  filename: string;
+  hash?: string;
+  hash_algorithm?: string;
+  last_modified?: string;
+  review_status?: string;
+  scan_status?: string;
+  size: number;
+  tags?: Record<string, string>;
+  ttl_seconds?: number;
+  version_id?: string;
+  visibility: string;
+}
+
+export interface FileVersion {
+  delete_marker?: boolean;
+  etag?: string;
+  is_latest: boolean;
+  last_modified: string;
+  size: number;
+  version_id: string;
+}
+
+export interface FilesResponse {
+  files: string[];
+  scan_status?: Record<string, string>;
+}
+
+export interface FolderDeleteResponse {
+  count: number;
+  dry_run: boolean;
+  errors?: FolderError[];
+  keys: string[];
+  message: string;
+  prefix: string;
+}
+
+export interface FolderError {
+  error: string;
+  key: string;
+}
+
+export interface FolderRequest {
+  path: string;
+}
+
+export interface Freeze {
+  created_at: string;
+  id: string;
+  prefix: string;
+  reason: string;
+  tenant?: string;
+  until?: string;
+}
+
+export interface FreezeRequest {
+  duration?: string;
+  prefix: string;
+  reason: string;
+  tenant?: string;
+  until?: string;
+}
+
+export interface FreezesResponse {
+  freezes: Freeze[];
+}
+
+export interface GraphQLRequest {
+  operationName?: string;
+  query: string;
+  variables?: Record<string, unknown>;
+}
+
+export interface HealthResponse {
+  bucket: string;
+  components: Record<string, ComponentStatus>;
+  status: string;
+  storage?: BucketDiagnosis[];
+  timestamp: string;
+  version: string;
+}
+
+export interface Job {
+  completed_at?: string;
+  created_at: string;
+  error?: string;
+  id: string;
+  progress: number;
+  result?: Record<string, string>;
+  resumed?: number;
+  started_at?: string;
+  status: string;
+  tenant: string;
+  type: string;
+}
+
+export interface LatestRequest {
+  target?: string;
+}
+
+export interface LegalHoldRequest {
+  enabled: boolean;
+}
+
+export interface LegalHoldResponse {
+  enabled: boolean;
+  filename: string;
+}
+
+export interface LimitHint {
+  bytes: number;
+  kind: string;
+  scope?: string;
+  size?: number;
+}
+
+export interface LogLevelRequest {
+  level: string;
+}
+
+export interface LogLevelResponse {
+  level: string;
+}
+
+export interface MessageResponse {
+  filename?: string;
+  message: string;
+  public_url?: string;
+  review_status?: string;
+}
+
+export interface Mirror {
+  checked_at?: string;
+  error?: string;
+  latency_ms?: number;
+  status: string;
+  url: string;
+}
+
+export interface MirrorsRequest {
+  urls: string[];
+}
+
+export interface MirrorsResponse {
+  filename: string;
+  mirrors: Mirror[];
+  origin: string;
+}
+
+export interface ObjectDebugPolicy {
+  compression?: string;
+  encrypt: boolean;
+  reserved: boolean;
+}
+
+export interface ObjectDebugReplicate {
+  in_sync: boolean;
+  replica_error?: string;
+  replica_etag?: string;
+  target_bucket: string;
+}
+
+export interface ObjectDebugResponse {
+  head?: Record<string, unknown>;
+  head_error?: string;
+  key: string;
+  recent_events: FileEvent[];
+  replication?: ObjectDebugReplicate;
+  storage_policy: ObjectDebugPolicy;
+  tags?: Record<string, string>;
+  trash?: ObjectDebugTrash;
+}
+
+export interface ObjectDebugTrash {
+  deleted_at: string;
+  key: string;
+  purge_at: string;
+}
+
+export interface OffboardTenantRequest {
+  export?: boolean;
+  purge?: boolean;
+}
+
+export interface PluginEnableRequest {
+  config?: unknown;
+  pattern?: string;
+  tenants?: string[];
+}
+
+export interface PluginEnablement {
+  config?: unknown;
+  name: string;
+  pattern: string;
+  tenants?: string[];
+  updated_at: string;
+  version: string;
+}
+
+export interface PluginVersion {
+  config_schema?: unknown;
+  description?: string;
+  enablement?: PluginEnablement;
+  hooks: string[];
+  name: string;
+  version: string;
+}
+
+export interface PluginsResponse {
+  plugins: PluginVersion[];
+}
+
+export interface PoliciesResponse {
+  enforced: boolean;
+  rules: PolicyRule[];
+}
+
+export interface PolicyRule {
+  action: string;
+  created_at?: string;
+  effect: string;
+  id: string;
+  resource: string;
+  subject: string;
+  tenant?: string;
+}
+
+export interface PromoteRequest {
+  effective_at?: string;
+  filename?: string;
+  from?: string;
+  note?: string;
+  version_id?: string;
+}
+
+export interface QuotaBreach {
+  refused: number;
+  tenant: string;
+}
+
+export interface RegionStatus {
+  bucket: string;
+  failed_at?: string;
+  healthy: boolean;
+  last_error?: string;
+  latency_ms?: number;
+  primary: boolean;
+  region: string;
+}
+
+export interface RegionsResponse {
+  regions: RegionStatus[];
+}
+
+export interface ReplicationFailure {
+  error: string;
+  failed_at: string;
+  key: string;
+}
+
+export interface ReplicationStatus {
+  dropped: number;
+  enabled: boolean;
+  failed: number;
+  last_lag_ms: number;
+  last_success?: string;
+  max_lag_ms: number;
+  oldest_pending?: string;
+  pending: number;
+  recent_failures: ReplicationFailure[];
+  replicated: number;
+  target_bucket?: string;
+  target_region?: string;
+}
+
+export interface RestoreResponse {
+  filename: string;
+  message: string;
+  restored_version_id: string;
+  version_id?: string;
+}
+
+export interface RetryHint {
+  after_seconds: number;
+  reason: string;
+}
+
+export interface ReviewCheck {
+  checked_at?: string;
+  name: string;
+  reason?: string;
+  status: string;
+}
+
+export interface ReviewDecisionRequest {
+  decision: string;
+  reason?: string;
+}
+
+export interface ReviewRecord {
+  checks: ReviewCheck[];
+  decided_at?: string;
+  decided_by?: string;
+  filename: string;
+  id: string;
+  reason?: string;
+  size: number;
+  status: string;
+  uploaded_at: string;
+  uploaded_by?: string;
+}
+
+export interface ReviewsResponse {
+  reviews: ReviewRecord[];
+}
+
+export interface RollbackRequest {
+  note?: string;
+  release?: number;
+}
+
+export interface SLOStatus {
+  budget: string;
+  budget_remaining: number;
+  burn_rate: number;
+  objective: number;
+  over_budget: number;
+  requests: number;
+  route: string;
+  status: string;
+}
+
+export interface SLOStatusResponse {
+  routes: SLOStatus[];
+  window: string;
+}
+
+export interface SessionResponse {
+  expires_at?: string;
+  method: string;
+  subject: string;
+  tenant: string;
+}
+
+export interface ShadowMismatch {
+  at: string;
+  method: string;
+  path: string;
+  reason: string;
+}
+
+export interface ShadowStatus {
+  dropped: number;
+  enabled: boolean;
+  failed: number;
+  matched: number;
+  mirrored: number;
+  mismatched: number;
+  pending: number;
+  recent_mismatches: ShadowMismatch[];
+  sample_rate: number;
+  target?: string;
+}
+
+export interface SharePasswordForm {
+  password: string;
+}
+
+export interface ShareRequest {
+  expires_in?: string;
+  max_downloads?: number;
+  password?: string;
+}
+
+export interface ShareResponse {
+  expires_at: string;
+  filename: string;
+  max_downloads?: number;
+  password_protected?: boolean;
+  token: string;
+  url: string;
+}
+
+export interface StatusReport {
+  audit_truncated?: boolean;
+  audited: boolean;
+  complete: boolean;
+  days: DayStatus[];
+  from: string;
+  generated_at: string;
+  quota_breaches: QuotaBreach[];
+  storage: TenantStorage[];
+  to: string;
+  top_uploaders: UploaderCount[];
+  webhook_failures: number;
+  week: string;
+}
+
+export interface StatusReportRequest {
+  send?: boolean;
+  week: string;
+}
+
+export interface TenantCost {
+  CostTotals: CostTotals;
+  clients: ClientCost[];
+  tenant: string;
+}
+
+export interface TenantJobResponse {
+  api_keys?: string[];
+  job?: Job;
+  tenant: TenantRecord;
+}
+
+export interface TenantKey {
+  created_at: string;
+  id: string;
+  sha256: string;
+}
+
+export interface TenantRecord {
+  bucket?: string;
+  created_at: string;
+  id: string;
+  keys: TenantKey[];
+  max_multipart_object_bytes?: number;
+  max_object_bytes?: number;
+  prefix?: string;
+  quota_bytes?: number;
+  status: string;
+  updated_at?: string;
+  webhook?: TenantWebhook;
+}
+
+export interface TenantStorage {
+  bytes: number;
+  bytes_growth?: number;
+  objects: number;
+  objects_growth?: number;
+  tenant: string;
+}
+
+export interface TenantWebhook {
+  events?: string[];
+  secret?: string;
+  url: string;
+}
+
+export interface TenantsResponse {
+  tenants: TenantRecord[];
+}
+
+export interface TrashEntry {
+  deleted_at: string;
+  filename: string;
+  purge_at: string;
+  size: number;
+}
+
+export interface TrashResponse {
+  items: TrashEntry[];
+}
+
+export interface UploadRequest {
+  content: string;
+  expires_at?: string;
+  expires_in?: string;
+  filename: string;
+  legal_hold?: boolean;
+  on_conflict?: string;
+  retain_until?: string;
+  retention_days?: number;
+  retention_mode?: string;
+  tags?: Record<string, string>;
+  visibility?: string;
+}
+
+export interface UploaderCount {
+  actor: string;
+  tenant: string;
+  uploads: number;
+}
+
+export interface UsageRecord {
+  BillingPeriodEnd: string;
+  BillingPeriodStart: string;
+  ChargeDescription: string;
+  ConsumedQuantity: number;
+  ConsumedUnit: string;
+  SubAccountId: string;
+}
+
+export interface UsageResponse {
+  measured_at: string;
+  objects: number;
+  percent_used?: number;
+  quota_bytes?: number;
+  remaining_bytes?: number;
+  tenant: string;
+  used_bytes: number;
+}
+
+export interface ValidationError {
+  field?: string;
+  message: string;
+  row?: number;
+}
+
+export interface VersionsResponse {
+  filename: string;
+  versioning: string;
+  versions: FileVersion[];
+}
+
+export interface Webhook {
+  created_at: string;
+  events?: string[];
+  id: string;
+  prefix?: string;
+  secret?: string;
+  tenant?: string;
+  url: string;
+}
+
+export interface WebhookDeliveries {
+  delivered: number;
+  failed: number;
+  last_error?: string;
+  last_error_at?: string;
+  retrying: number;
+}
+
+export interface WebhookRequest {
+  events?: string[];
+  prefix?: string;
+  secret?: string;
+  tenant?: string;
+  url: string;
+}
+
+export interface WebhookStatus {
+  Webhook: Webhook;
+  deliveries: WebhookDeliveries;
+}
+
+export interface WebhooksResponse {
+  dropped: number;
+  webhooks: WebhookStatus[];
+}
+
+export interface RequestOptions {
+  signal?: AbortSignal;
+  headers?: Record<string, string>;
+}
+
+export interface OperationSpec {
+  id: string;
+  method: string;
+  path: string;
+  pathParams: readonly string[];
+  queryParams: readonly string[];
+  headerParams: readonly string[];
+  body: "json" | "form" | null;
+}
+
+export const operations = {
+  actionsCacheProgress: {
+    id: "actionsCacheProgress",
+    method: "GET",
+    path: "/api/actions-cache/{token}/_apis/artifactcache/caches/{id}/progress",
+    pathParams: ["token","id"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  adminAudit: {
+    id: "adminAudit",
+    method: "GET",
+    path: "/api/admin/audit",
+    pathParams: [],
+    queryParams: ["tenant","from","to","actor","action","key","result","limit"],
+    headerParams: [],
+    body: null,
+  },
+  adminCosts: {
+    id: "adminCosts",
+    method: "GET",
+    path: "/api/admin/costs",
+    pathParams: [],
+    queryParams: ["tenant"],
+    headerParams: [],
+    body: null,
+  },
+  adminListReviews: {
+    id: "adminListReviews",
+    method: "GET",
+    path: "/api/admin/quarantine",
+    pathParams: [],
+    queryParams: ["tenant","status"],
+    headerParams: [],
+    body: null,
+  },
+  audit: {
+    id: "audit",
+    method: "GET",
+    path: "/api/audit",
+    pathParams: [],
+    queryParams: ["from","to","actor","action","key","result","limit"],
+    headerParams: [],
+    body: null,
+  },
+  browse: {
+    id: "browse",
+    method: "GET",
+    path: "/api/browse/{path}",
+    pathParams: ["path"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  browseInBucket: {
+    id: "browseInBucket",
+    method: "GET",
+    path: "/api/buckets/{bucket}/browse/{path}",
+    pathParams: ["bucket","path"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  capabilities: {
+    id: "capabilities",
+    method: "GET",
+    path: "/api/capabilities",
+    pathParams: [],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  checksumFile: {
+    id: "checksumFile",
+    method: "GET",
+    path: "/api/checksums",
+    pathParams: [],
+    queryParams: ["prefix","hash"],
+    headerParams: [],
+    body: null,
+  },
+  checksumFileInBucket: {
+    id: "checksumFileInBucket",
+    method: "GET",
+    path: "/api/buckets/{bucket}/checksums",
+    pathParams: ["bucket"],
+    queryParams: ["prefix","hash"],
+    headerParams: [],
+    body: null,
+  },
+  collection: {
+    id: "collection",
+    method: "GET",
+    path: "/api/docs/collection",
+    pathParams: [],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  commitActionsCache: {
+    id: "commitActionsCache",
+    method: "POST",
+    path: "/api/actions-cache/{token}/_apis/artifactcache/caches/{id}",
+    pathParams: ["token","id"],
+    queryParams: [],
+    headerParams: [],
+    body: "json",
+  },
+  console: {
+    id: "console",
+    method: "GET",
+    path: "/api/console",
+    pathParams: [],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  consoleAsset: {
+    id: "consoleAsset",
+    method: "GET",
+    path: "/api/console/{asset}",
+    pathParams: ["asset"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  createBillingReport: {
+    id: "createBillingReport",
+    method: "POST",
+    path: "/api/admin/billing/reports",
+    pathParams: [],
+    queryParams: [],
+    headerParams: [],
+    body: "json",
+  },
+  createCaptureToken: {
+    id: "createCaptureToken",
+    method: "POST",
+    path: "/api/admin/captures/token",
+    pathParams: [],
+    queryParams: [],
+    headerParams: [],
+    body: "json",
+  },
+  createExport: {
+    id: "createExport",
+    method: "POST",
+    path: "/api/exports",
+    pathParams: [],
+    queryParams: [],
+    headerParams: ["Idempotency-Key"],
+    body: "json",
+  },
+  createFolder: {
+    id: "createFolder",
+    method: "POST",
+    path: "/api/folders",
+    pathParams: [],
+    queryParams: [],
+    headerParams: [],
+    body: "json",
+  },
+  createFolderInBucket: {
+    id: "createFolderInBucket",
+    method: "POST",
+    path: "/api/buckets/{bucket}/folders",
+    pathParams: ["bucket"],
+    queryParams: [],
+    headerParams: [],
+    body: "json",
+  },
+  createFreeze: {
+    id: "createFreeze",
+    method: "POST",
+    path: "/api/admin/freezes",
+    pathParams: [],
+    queryParams: [],
+    headerParams: [],
+    body: "json",
+  },
+  createPolicy: {
+    id: "createPolicy",
+    method: "POST",
+    path: "/api/admin/policies",
+    pathParams: [],
+    queryParams: [],
+    headerParams: [],
+    body: "json",
+  },
+  createShare: {
+    id: "createShare",
+    method: "POST",
+    path: "/api/files/{filename}/share",
+    pathParams: ["filename"],
+    queryParams: [],
+    headerParams: [],
+    body: "json",
+  },
+  createShareInBucket: {
+    id: "createShareInBucket",
+    method: "POST",
+    path: "/api/buckets/{bucket}/files/{filename}/share",
+    pathParams: ["bucket","filename"],
+    queryParams: [],
+    headerParams: [],
+    body: "json",
+  },
+  createStatusReport: {
+    id: "createStatusReport",
+    method: "POST",
+    path: "/api/admin/status-reports",
+    pathParams: [],
+    queryParams: [],
+    headerParams: [],
+    body: "json",
+  },
+  createTenant: {
+    id: "createTenant",
+    method: "POST",
+    path: "/api/admin/tenants",
+    pathParams: [],
+    queryParams: [],
+    headerParams: [],
+    body: "json",
+  },
+  createWebhook: {
+    id: "createWebhook",
+    method: "POST",
+    path: "/api/admin/webhooks",
+    pathParams: [],
+    queryParams: [],
+    headerParams: [],
+    body: "json",
+  },
+  debugObject: {
+    id: "debugObject",
+    method: "GET",
+    path: "/api/admin/debug/object/{key}",
+    pathParams: ["key"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  decideReview: {
+    id: "decideReview",
+    method: "POST",
+    path: "/api/admin/quarantine/{filename}",
+    pathParams: ["filename"],
+    queryParams: ["tenant"],
+    headerParams: [],
+    body: "json",
+  },
+  deleteAlias: {
+    id: "deleteAlias",
+    method: "DELETE",
+    path: "/api/aliases/{alias}",
+    pathParams: ["alias"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  deleteAliasInBucket: {
+    id: "deleteAliasInBucket",
+    method: "DELETE",
+    path: "/api/buckets/{bucket}/aliases/{alias}",
+    pathParams: ["bucket","alias"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  deleteCapture: {
+    id: "deleteCapture",
+    method: "DELETE",
+    path: "/api/admin/captures/{id}",
+    pathParams: ["id"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  deleteFile: {
+    id: "deleteFile",
+    method: "DELETE",
+    path: "/api/files/{filename}",
+    pathParams: ["filename"],
+    queryParams: [],
+    headerParams: ["If-Match"],
+    body: null,
+  },
+  deleteFileInBucket: {
+    id: "deleteFileInBucket",
+    method: "DELETE",
+    path: "/api/buckets/{bucket}/files/{filename}",
+    pathParams: ["bucket","filename"],
+    queryParams: [],
+    headerParams: ["If-Match"],
+    body: null,
+  },
+  deleteFolder: {
+    id: "deleteFolder",
+    method: "DELETE",
+    path: "/api/folders/{prefix}",
+    pathParams: ["prefix"],
+    queryParams: ["dry_run"],
+    headerParams: [],
+    body: null,
+  },
+  deleteFolderInBucket: {
+    id: "deleteFolderInBucket",
+    method: "DELETE",
+    path: "/api/buckets/{bucket}/folders/{prefix}",
+    pathParams: ["bucket","prefix"],
+    queryParams: ["dry_run"],
+    headerParams: [],
+    body: null,
+  },
+  deleteFreeze: {
+    id: "deleteFreeze",
+    method: "DELETE",
+    path: "/api/admin/freezes/{id}",
+    pathParams: ["id"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  deletePolicy: {
+    id: "deletePolicy",
+    method: "DELETE",
+    path: "/api/admin/policies/{id}",
+    pathParams: ["id"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  deleteWebhook: {
+    id: "deleteWebhook",
+    method: "DELETE",
+    path: "/api/admin/webhooks/{id}",
+    pathParams: ["id"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  disablePlugin: {
+    id: "disablePlugin",
+    method: "DELETE",
+    path: "/api/admin/plugins/{name}/{version}",
+    pathParams: ["name","version"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  downloadActionsCache: {
+    id: "downloadActionsCache",
+    method: "GET",
+    path: "/api/actions-cache/{token}/_apis/artifactcache/artifacts/{id}",
+    pathParams: ["token","id"],
+    queryParams: [],
+    headerParams: ["Range"],
+    body: null,
+  },
+  downloadChannel: {
+    id: "downloadChannel",
+    method: "GET",
+    path: "/api/channels/{channel}/download",
+    pathParams: ["channel"],
+    queryParams: ["at"],
+    headerParams: [],
+    body: null,
+  },
+  downloadChannelInBucket: {
+    id: "downloadChannelInBucket",
+    method: "GET",
+    path: "/api/buckets/{bucket}/channels/{channel}/download",
+    pathParams: ["bucket","channel"],
+    queryParams: ["at"],
+    headerParams: [],
+    body: null,
+  },
+  enablePlugin: {
+    id: "enablePlugin",
+    method: "PUT",
+    path: "/api/admin/plugins/{name}/{version}",
+    pathParams: ["name","version"],
+    queryParams: [],
+    headerParams: [],
+    body: "json",
+  },
+  fileChecksums: {
+    id: "fileChecksums",
+    method: "GET",
+    path: "/api/files/{filename}/checksums",
+    pathParams: ["filename"],
+    queryParams: ["hash"],
+    headerParams: [],
+    body: null,
+  },
+  fileChecksumsInBucket: {
+    id: "fileChecksumsInBucket",
+    method: "GET",
+    path: "/api/buckets/{bucket}/files/{filename}/checksums",
+    pathParams: ["bucket","filename"],
+    queryParams: ["hash"],
+    headerParams: [],
+    body: null,
+  },
+  fileMetadata: {
+    id: "fileMetadata",
+    method: "GET",
+    path: "/api/files/{filename}/metadata",
+    pathParams: ["filename"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  fileMetadataInBucket: {
+    id: "fileMetadataInBucket",
+    method: "GET",
+    path: "/api/buckets/{bucket}/files/{filename}/metadata",
+    pathParams: ["bucket","filename"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  filesFeed: {
+    id: "filesFeed",
+    method: "GET",
+    path: "/api/files/feed",
+    pathParams: [],
+    queryParams: ["prefix"],
+    headerParams: [],
+    body: null,
+  },
+  filesFeedInBucket: {
+    id: "filesFeedInBucket",
+    method: "GET",
+    path: "/api/buckets/{bucket}/files/feed",
+    pathParams: ["bucket"],
+    queryParams: ["prefix"],
+    headerParams: [],
+    body: null,
+  },
+  getACL: {
+    id: "getACL",
+    method: "GET",
+    path: "/api/files/{filename}/acl",
+    pathParams: ["filename"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  getACLInBucket: {
+    id: "getACLInBucket",
+    method: "GET",
+    path: "/api/buckets/{bucket}/files/{filename}/acl",
+    pathParams: ["bucket","filename"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  getActionsCache: {
+    id: "getActionsCache",
+    method: "GET",
+    path: "/api/actions-cache/{token}/_apis/artifactcache/cache",
+    pathParams: ["token"],
+    queryParams: ["keys","version"],
+    headerParams: [],
+    body: null,
+  },
+  getAlias: {
+    id: "getAlias",
+    method: "GET",
+    path: "/api/aliases/{alias}",
+    pathParams: ["alias"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  getAliasInBucket: {
+    id: "getAliasInBucket",
+    method: "GET",
+    path: "/api/buckets/{bucket}/aliases/{alias}",
+    pathParams: ["bucket","alias"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  getBillingReport: {
+    id: "getBillingReport",
+    method: "GET",
+    path: "/api/admin/billing/reports/{month}",
+    pathParams: ["month"],
+    queryParams: ["format"],
+    headerParams: [],
+    body: null,
+  },
+  getBuildCache: {
+    id: "getBuildCache",
+    method: "GET",
+    path: "/api/build-cache/{kind}/{hash}",
+    pathParams: ["kind","hash"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  getCapture: {
+    id: "getCapture",
+    method: "GET",
+    path: "/api/admin/captures/{id}",
+    pathParams: ["id"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  getChannel: {
+    id: "getChannel",
+    method: "GET",
+    path: "/api/channels/{channel}",
+    pathParams: ["channel"],
+    queryParams: ["at"],
+    headerParams: [],
+    body: null,
+  },
+  getChannelInBucket: {
+    id: "getChannelInBucket",
+    method: "GET",
+    path: "/api/buckets/{bucket}/channels/{channel}",
+    pathParams: ["bucket","channel"],
+    queryParams: ["at"],
+    headerParams: [],
+    body: null,
+  },
+  getExport: {
+    id: "getExport",
+    method: "GET",
+    path: "/api/exports/{id}",
+    pathParams: ["id"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  getFile: {
+    id: "getFile",
+    method: "GET",
+    path: "/api/files/{filename}",
+    pathParams: ["filename"],
+    queryParams: ["version_id"],
+    headerParams: [],
+    body: null,
+  },
+  getFileInBucket: {
+    id: "getFileInBucket",
+    method: "GET",
+    path: "/api/buckets/{bucket}/files/{filename}",
+    pathParams: ["bucket","filename"],
+    queryParams: ["version_id"],
+    headerParams: [],
+    body: null,
+  },
+  getJob: {
+    id: "getJob",
+    method: "GET",
+    path: "/api/admin/jobs/{id}",
+    pathParams: ["id"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  getLegalHold: {
+    id: "getLegalHold",
+    method: "GET",
+    path: "/api/files/{filename}/legal-hold",
+    pathParams: ["filename"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  getLegalHoldInBucket: {
+    id: "getLegalHoldInBucket",
+    method: "GET",
+    path: "/api/buckets/{bucket}/files/{filename}/legal-hold",
+    pathParams: ["bucket","filename"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  getLogLevel: {
+    id: "getLogLevel",
+    method: "GET",
+    path: "/api/admin/log-level",
+    pathParams: [],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  getMirrors: {
+    id: "getMirrors",
+    method: "GET",
+    path: "/api/files/{filename}/mirrors",
+    pathParams: ["filename"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  getMirrorsInBucket: {
+    id: "getMirrorsInBucket",
+    method: "GET",
+    path: "/api/buckets/{bucket}/files/{filename}/mirrors",
+    pathParams: ["bucket","filename"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  getPolicy: {
+    id: "getPolicy",
+    method: "GET",
+    path: "/api/admin/policies/{id}",
+    pathParams: ["id"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  getReview: {
+    id: "getReview",
+    method: "GET",
+    path: "/api/quarantine/{filename}",
+    pathParams: ["filename"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  getStatusReport: {
+    id: "getStatusReport",
+    method: "GET",
+    path: "/api/admin/status-reports/{week}",
+    pathParams: ["week"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  getTenant: {
+    id: "getTenant",
+    method: "GET",
+    path: "/api/admin/tenants/{id}",
+    pathParams: ["id"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  grantAccess: {
+    id: "grantAccess",
+    method: "POST",
+    path: "/api/files/{filename}/acl/grants",
+    pathParams: ["filename"],
+    queryParams: [],
+    headerParams: [],
+    body: "json",
+  },
+  grantAccessInBucket: {
+    id: "grantAccessInBucket",
+    method: "POST",
+    path: "/api/buckets/{bucket}/files/{filename}/acl/grants",
+    pathParams: ["bucket","filename"],
+    queryParams: [],
+    headerParams: [],
+    body: "json",
+  },
+  graphql: {
+    id: "graphql",
+    method: "POST",
+    path: "/api/graphql",
+    pathParams: [],
+    queryParams: [],
+    headerParams: [],
+    body: "json",
+  },
+  graphqlInBucket: {
+    id: "graphqlInBucket",
+    method: "POST",
+    path: "/api/buckets/{bucket}/graphql",
+    pathParams: ["bucket"],
+    queryParams: [],
+    headerParams: [],
+    body: "json",
+  },
+  graphqlSchema: {
+    id: "graphqlSchema",
+    method: "GET",
+    path: "/api/graphql/schema",
+    pathParams: [],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  graphqlSchemaInBucket: {
+    id: "graphqlSchemaInBucket",
+    method: "GET",
+    path: "/api/buckets/{bucket}/graphql/schema",
+    pathParams: ["bucket"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  headBrowse: {
+    id: "headBrowse",
+    method: "HEAD",
+    path: "/api/browse/{path}",
+    pathParams: ["path"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  headBrowseInBucket: {
+    id: "headBrowseInBucket",
+    method: "HEAD",
+    path: "/api/buckets/{bucket}/browse/{path}",
+    pathParams: ["bucket","path"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  health: {
+    id: "health",
+    method: "GET",
+    path: "/api/health",
+    pathParams: [],
+    queryParams: ["deep"],
+    headerParams: [],
+    body: null,
+  },
+  latencySLOs: {
+    id: "latencySLOs",
+    method: "GET",
+    path: "/api/admin/slos",
+    pathParams: [],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  listAliases: {
+    id: "listAliases",
+    method: "GET",
+    path: "/api/aliases",
+    pathParams: [],
+    queryParams: ["target"],
+    headerParams: [],
+    body: null,
+  },
+  listAliasesInBucket: {
+    id: "listAliasesInBucket",
+    method: "GET",
+    path: "/api/buckets/{bucket}/aliases",
+    pathParams: ["bucket"],
+    queryParams: ["target"],
+    headerParams: [],
+    body: null,
+  },
+  listBuckets: {
+    id: "listBuckets",
+    method: "GET",
+    path: "/api/buckets",
+    pathParams: [],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  listCaptures: {
+    id: "listCaptures",
+    method: "GET",
+    path: "/api/admin/captures",
+    pathParams: [],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  listChannels: {
+    id: "listChannels",
+    method: "GET",
+    path: "/api/channels",
+    pathParams: [],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  listChannelsInBucket: {
+    id: "listChannelsInBucket",
+    method: "GET",
+    path: "/api/buckets/{bucket}/channels",
+    pathParams: ["bucket"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  listFiles: {
+    id: "listFiles",
+    method: "GET",
+    path: "/api/files",
+    pathParams: [],
+    queryParams: ["scan_status"],
+    headerParams: [],
+    body: null,
+  },
+  listFilesInBucket: {
+    id: "listFilesInBucket",
+    method: "GET",
+    path: "/api/buckets/{bucket}/files",
+    pathParams: ["bucket"],
+    queryParams: ["scan_status"],
+    headerParams: [],
+    body: null,
+  },
+  listFreezes: {
+    id: "listFreezes",
+    method: "GET",
+    path: "/api/admin/freezes",
+    pathParams: [],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  listPlugins: {
+    id: "listPlugins",
+    method: "GET",
+    path: "/api/admin/plugins",
+    pathParams: [],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  listPolicies: {
+    id: "listPolicies",
+    method: "GET",
+    path: "/api/admin/policies",
+    pathParams: [],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  listReviews: {
+    id: "listReviews",
+    method: "GET",
+    path: "/api/quarantine",
+    pathParams: [],
+    queryParams: ["status"],
+    headerParams: [],
+    body: null,
+  },
+  listTenants: {
+    id: "listTenants",
+    method: "GET",
+    path: "/api/admin/tenants",
+    pathParams: [],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  listTrash: {
+    id: "listTrash",
+    method: "GET",
+    path: "/api/trash",
+    pathParams: [],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  listTrashInBucket: {
+    id: "listTrashInBucket",
+    method: "GET",
+    path: "/api/buckets/{bucket}/trash",
+    pathParams: ["bucket"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  listVersions: {
+    id: "listVersions",
+    method: "GET",
+    path: "/api/files/{filename}/versions",
+    pathParams: ["filename"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  listVersionsInBucket: {
+    id: "listVersionsInBucket",
+    method: "GET",
+    path: "/api/buckets/{bucket}/files/{filename}/versions",
+    pathParams: ["bucket","filename"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  listWebhooks: {
+    id: "listWebhooks",
+    method: "GET",
+    path: "/api/admin/webhooks",
+    pathParams: [],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  offboardTenant: {
+    id: "offboardTenant",
+    method: "POST",
+    path: "/api/admin/tenants/{id}/offboard",
+    pathParams: ["id"],
+    queryParams: [],
+    headerParams: ["Idempotency-Key"],
+    body: "json",
+  },
+  openAPI: {
+    id: "openAPI",
+    method: "GET",
+    path: "/api/openapi.json",
+    pathParams: [],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  promoteChannel: {
+    id: "promoteChannel",
+    method: "POST",
+    path: "/api/channels/{channel}/promote",
+    pathParams: ["channel"],
+    queryParams: [],
+    headerParams: [],
+    body: "json",
+  },
+  promoteChannelInBucket: {
+    id: "promoteChannelInBucket",
+    method: "POST",
+    path: "/api/buckets/{bucket}/channels/{channel}/promote",
+    pathParams: ["bucket","channel"],
+    queryParams: [],
+    headerParams: [],
+    body: "json",
+  },
+  publicFile: {
+    id: "publicFile",
+    method: "GET",
+    path: "/api/public/{filename}",
+    pathParams: ["filename"],
+    queryParams: ["mirror"],
+    headerParams: ["If-None-Match","Range"],
+    body: null,
+  },
+  publicMirrors: {
+    id: "publicMirrors",
+    method: "GET",
+    path: "/api/public/{filename}/mirrors",
+    pathParams: ["filename"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  publicTorrent: {
+    id: "publicTorrent",
+    method: "GET",
+    path: "/api/public/{filename}/torrent",
+    pathParams: ["filename"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  putAlias: {
+    id: "putAlias",
+    method: "PUT",
+    path: "/api/aliases/{alias}",
+    pathParams: ["alias"],
+    queryParams: [],
+    headerParams: ["If-Match","If-None-Match"],
+    body: "json",
+  },
+  putAliasInBucket: {
+    id: "putAliasInBucket",
+    method: "PUT",
+    path: "/api/buckets/{bucket}/aliases/{alias}",
+    pathParams: ["bucket","alias"],
+    queryParams: [],
+    headerParams: ["If-Match","If-None-Match"],
+    body: "json",
+  },
+  putBuildCache: {
+    id: "putBuildCache",
+    method: "PUT",
+    path: "/api/build-cache/{kind}/{hash}",
+    pathParams: ["kind","hash"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  regions: {
+    id: "regions",
+    method: "GET",
+    path: "/api/admin/storage/regions",
+    pathParams: [],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  renderFile: {
+    id: "renderFile",
+    method: "GET",
+    path: "/api/files/{filename}/render",
+    pathParams: ["filename"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  renderFileInBucket: {
+    id: "renderFileInBucket",
+    method: "GET",
+    path: "/api/buckets/{bucket}/files/{filename}/render",
+    pathParams: ["bucket","filename"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  replicationStatus: {
+    id: "replicationStatus",
+    method: "GET",
+    path: "/api/replication/status",
+    pathParams: [],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  reserveActionsCache: {
+    id: "reserveActionsCache",
+    method: "POST",
+    path: "/api/actions-cache/{token}/_apis/artifactcache/caches",
+    pathParams: ["token"],
+    queryParams: [],
+    headerParams: [],
+    body: "json",
+  },
+  restoreTrash: {
+    id: "restoreTrash",
+    method: "POST",
+    path: "/api/trash/{filename}/restore",
+    pathParams: ["filename"],
+    queryParams: ["overwrite"],
+    headerParams: [],
+    body: null,
+  },
+  restoreTrashInBucket: {
+    id: "restoreTrashInBucket",
+    method: "POST",
+    path: "/api/buckets/{bucket}/trash/{filename}/restore",
+    pathParams: ["bucket","filename"],
+    queryParams: ["overwrite"],
+    headerParams: [],
+    body: null,
+  },
+  restoreVersion: {
+    id: "restoreVersion",
+    method: "POST",
+    path: "/api/files/{filename}/versions/{version_id}/restore",
+    pathParams: ["filename","version_id"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  restoreVersionInBucket: {
+    id: "restoreVersionInBucket",
+    method: "POST",
+    path: "/api/buckets/{bucket}/files/{filename}/versions/{version_id}/restore",
+    pathParams: ["bucket","filename","version_id"],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  revokeAccess: {
+    id: "revokeAccess",
+    method: "DELETE",
+    path: "/api/files/{filename}/acl/grants",
+    pathParams: ["filename"],
+    queryParams: ["principal"],
+    headerParams: [],
+    body: null,
+  },
+  revokeAccessInBucket: {
+    id: "revokeAccessInBucket",
+    method: "DELETE",
+    path: "/api/buckets/{bucket}/files/{filename}/acl/grants",
+    pathParams: ["bucket","filename"],
+    queryParams: ["principal"],
+    headerParams: [],
+    body: null,
+  },
+  rollbackChannel: {
+    id: "rollbackChannel",
+    method: "POST",
+    path: "/api/channels/{channel}/rollback",
+    pathParams: ["channel"],
+    queryParams: [],
+    headerParams: [],
+    body: "json",
+  },
+  rollbackChannelInBucket: {
+    id: "rollbackChannelInBucket",
+    method: "POST",
+    path: "/api/buckets/{bucket}/channels/{channel}/rollback",
+    pathParams: ["bucket","channel"],
+    queryParams: [],
+    headerParams: [],
+    body: "json",
+  },
+  session: {
+    id: "session",
+    method: "GET",
+    path: "/api/auth/session",
+    pathParams: [],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  setLatest: {
+    id: "setLatest",
+    method: "PUT",
+    path: "/api/latest/{prefix}",
+    pathParams: ["prefix"],
+    queryParams: [],
+    headerParams: ["If-Match"],
+    body: "json",
+  },
+  setLatestInBucket: {
+    id: "setLatestInBucket",
+    method: "PUT",
+    path: "/api/buckets/{bucket}/latest/{prefix}",
+    pathParams: ["bucket","prefix"],
+    queryParams: [],
+    headerParams: ["If-Match"],
+    body: "json",
+  },
+  setLegalHold: {
+    id: "setLegalHold",
+    method: "PUT",
+    path: "/api/files/{filename}/legal-hold",
+    pathParams: ["filename"],
+    queryParams: [],
+    headerParams: [],
+    body: "json",
+  },
+  setLegalHoldInBucket: {
+    id: "setLegalHoldInBucket",
+    method: "PUT",
+    path: "/api/buckets/{bucket}/files/{filename}/legal-hold",
+    pathParams: ["bucket","filename"],
+    queryParams: [],
+    headerParams: [],
+    body: "json",
+  },
+  setLogLevel: {
+    id: "setLogLevel",
+    method: "PUT",
+    path: "/api/admin/log-level",
+    pathParams: [],
+    queryParams: [],
+    headerParams: [],
+    body: "json",
+  },
+  setMirrors: {
+    id: "setMirrors",
+    method: "PUT",
+    path: "/api/files/{filename}/mirrors",
+    pathParams: ["filename"],
+    queryParams: [],
+    headerParams: [],
+    body: "json",
+  },
+  setMirrorsInBucket: {
+    id: "setMirrorsInBucket",
+    method: "PUT",
+    path: "/api/buckets/{bucket}/files/{filename}/mirrors",
+    pathParams: ["bucket","filename"],
+    queryParams: [],
+    headerParams: [],
+    body: "json",
+  },
+  shadowStatus: {
+    id: "shadowStatus",
+    method: "GET",
+    path: "/api/admin/shadow/status",
+    pathParams: [],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  shareDownload: {
+    id: "shareDownload",
+    method: "GET",
+    path: "/api/share/{token}",
+    pathParams: ["token"],
+    queryParams: [],
+    headerParams: ["X-Share-Password"],
+    body: null,
+  },
+  shareUnlock: {
+    id: "shareUnlock",
+    method: "POST",
+    path: "/api/share/{token}",
+    pathParams: ["token"],
+    queryParams: [],
+    headerParams: [],
+    body: "form",
+  },
+  tailFile: {
+    id: "tailFile",
+    method: "GET",
+    path: "/api/files/{filename}/tail",
+    pathParams: ["filename"],
+    queryParams: ["lines","follow"],
+    headerParams: [],
+    body: null,
+  },
+  tailFileInBucket: {
+    id: "tailFileInBucket",
+    method: "GET",
+    path: "/api/buckets/{bucket}/files/{filename}/tail",
+    pathParams: ["bucket","filename"],
+    queryParams: ["lines","follow"],
+    headerParams: [],
+    body: null,
+  },
+  updatePolicy: {
+    id: "updatePolicy",
+    method: "PUT",
+    path: "/api/admin/policies/{id}",
+    pathParams: ["id"],
+    queryParams: [],
+    headerParams: [],
+    body: "json",
+  },
+  upload: {
+    id: "upload",
+    method: "POST",
+    path: "/api/upload",
+    pathParams: [],
+    queryParams: ["on_conflict","overwrite"],
+    headerParams: ["If-Match","If-None-Match"],
+    body: "json",
+  },
+  uploadActionsCache: {
+    id: "uploadActionsCache",
+    method: "PATCH",
+    path: "/api/actions-cache/{token}/_apis/artifactcache/caches/{id}",
+    pathParams: ["token","id"],
+    queryParams: [],
+    headerParams: ["Content-Range"],
+    body: null,
+  },
+  uploadInBucket: {
+    id: "uploadInBucket",
+    method: "POST",
+    path: "/api/buckets/{bucket}/upload",
+    pathParams: ["bucket"],
+    queryParams: ["on_conflict","overwrite"],
+    headerParams: ["If-Match","If-None-Match"],
+    body: "json",
+  },
+  usage: {
+    id: "usage",
+    method: "GET",
+    path: "/api/usage",
+    pathParams: [],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+  usageCosts: {
+    id: "usageCosts",
+    method: "GET",
+    path: "/api/usage/costs",
+    pathParams: [],
+    queryParams: [],
+    headerParams: [],
+    body: null,
+  },
+} as const;
+
+export abstract class GeneratedClient {
+  protected abstract call(op: OperationSpec, args: Record<string, unknown>, options?: RequestOptions): Promise<Response>;
+  protected abstract callJSON<T>(op: OperationSpec, args: Record<string, unknown>, options?: RequestOptions): Promise<T>;
+
+  /** Server-sent events reporting a reserved cache's upload progress */
+  actionsCacheProgress(args: { token: string; id: string }, options?: RequestOptions): Promise<Response> {
+    return this.call(operations.actionsCacheProgress, args, options);
+  }
+
+  /** Search any tenant's audit log, or the admin API's */
+  adminAudit(args: { tenant?: string; from?: string; to?: string; actor?: string; action?: string; key?: string; result?: string; limit?: number } = {}, options?: RequestOptions): Promise<AuditResponse> {
+    return this.callJSON<AuditResponse>(operations.adminAudit, args, options);
+  }
+
+  /** Request costs of every tenant, heaviest first */
+  adminCosts(args: { tenant?: string } = {}, options?: RequestOptions): Promise<CostsResponse> {
+    return this.callJSON<CostsResponse>(operations.adminCosts, args, options);
+  }
+
+  /** List any tenant's uploads held for review */
+  adminListReviews(args: { tenant?: string; status?: string } = {}, options?: RequestOptions): Promise<ReviewsResponse> {
+    return this.callJSON<ReviewsResponse>(operations.adminListReviews, args, options);
+  }
+
+  /** Search the audit log of changes */
+  audit(args: { from?: string; to?: string; actor?: string; action?: string; key?: string; result?: string; limit?: number } = {}, options?: RequestOptions): Promise<AuditResponse> {
+    return this.callJSON<AuditResponse>(operations.audit, args, options);
+  }
+
+  /** Browse folders as HTML indexes and download files, for rclone's http backend */
+  browse(args: { path: string }, options?: RequestOptions): Promise<Response> {
+    return this.call(operations.browse, args, options);
+  }
+
+  /** Browse folders as HTML indexes and download files, for rclone's http backend */
+  browseInBucket(args: { bucket: string; path: string }, options?: RequestOptions): Promise<Response> {
+    return this.call(operations.browseInBucket, args, options);
+  }
+
+  /** Enabled subsystems and their limits */
+  capabilities(args: Record<string, never> = {}, options?: RequestOptions): Promise<CapabilitiesResponse> {
+    return this.callJSON<CapabilitiesResponse>(operations.capabilities, args, options);
+  }
+
+  /** md5sum-style checksum file of everything under a prefix */
+  checksumFile(args: { prefix?: string; hash?: string } = {}, options?: RequestOptions): Promise<Response> {
+    return this.call(operations.checksumFile, args, options);
+  }
+
+  /** md5sum-style checksum file of everything under a prefix */
+  checksumFileInBucket(args: { bucket: string; prefix?: string; hash?: string }, options?: RequestOptions): Promise<Response> {
+    return this.call(operations.checksumFileInBucket, args, options);
+  }
+
+  /** Postman collection of every route */
+  collection(args: Record<string, never> = {}, options?: RequestOptions): Promise<Record<string, unknown>> {
+    return this.callJSON<Record<string, unknown>>(operations.collection, args, options);
+  }
+
+  /** Finish saving a reserved cache */
+  commitActionsCache(args: { token: string; id: string; body: ActionsCacheCommitRequest }, options?: RequestOptions): Promise<Response> {
+    return this.call(operations.commitActionsCache, args, options);
+  }
+
+  /** Interactive API console, when the admin API is enabled */
+  console(args: Record<string, never> = {}, options?: RequestOptions): Promise<Response> {
+    return this.call(operations.console, args, options);
+  }
+
+  /** Script and styles of the API console */
+  consoleAsset(args: { asset: string }, options?: RequestOptions): Promise<Response> {
+    return this.call(operations.consoleAsset, args, options);
+  }
+
+  /** Build a month's usage report */
+  createBillingReport(args: { body: BillingReportRequest }, options?: RequestOptions): Promise<Job> {
+    return this.callJSON<Job>(operations.createBillingReport, args, options);
+  }
+
+  /** Issue a token that opts requests into capture */
+  createCaptureToken(args: { body: CaptureTokenRequest }, options?: RequestOptions): Promise<CaptureTokenResponse> {
+    return this.callJSON<CaptureTokenResponse>(operations.createCaptureToken, args, options);
+  }
+
+  /** Start a listing export */
+  createExport(args: { "Idempotency-Key"?: string; body: ExportRequest }, options?: RequestOptions): Promise<Job> {
+    return this.callJSON<Job>(operations.createExport, args, options);
+  }
+
+  /** Create a folder marker */
+  createFolder(args: { body: FolderRequest }, options?: RequestOptions): Promise<MessageResponse> {
+    return this.callJSON<MessageResponse>(operations.createFolder, args, options);
+  }
+
+  /** Create a folder marker */
+  createFolderInBucket(args: { bucket: string; body: FolderRequest }, options?: RequestOptions): Promise<MessageResponse> {
+    return this.callJSON<MessageResponse>(operations.createFolderInBucket, args, options);
+  }
+
+  /** Freeze writes under a prefix, optionally until a set time */
+  createFreeze(args: { body: FreezeRequest }, options?: RequestOptions): Promise<Freeze> {
+    return this.callJSON<Freeze>(operations.createFreeze, args, options);
+  }
+
+  /** Add an authorization policy rule */
+  createPolicy(args: { body: PolicyRule }, options?: RequestOptions): Promise<PolicyRule> {
+    return this.callJSON<PolicyRule>(operations.createPolicy, args, options);
+  }
+
+  /** Create an expiring share link */
+  createShare(args: { filename: string; body: ShareRequest }, options?: RequestOptions): Promise<ShareResponse> {
+    return this.callJSON<ShareResponse>(operations.createShare, args, options);
+  }
+
+  /** Create an expiring share link */
+  createShareInBucket(args: { bucket: string; filename: string; body: ShareRequest }, options?: RequestOptions): Promise<ShareResponse> {
+    return this.callJSON<ShareResponse>(operations.createShareInBucket, args, options);
+  }
+
+  /** Build a week's status report, and optionally send it */
+  createStatusReport(args: { body: StatusReportRequest }, options?: RequestOptions): Promise<Job> {
+    return this.callJSON<Job>(operations.createStatusReport, args, options);
+  }
+
+  /** Onboard a tenant and issue its API keys */
+  createTenant(args: { body: CreateTenantRequest }, options?: RequestOptions): Promise<TenantJobResponse> {
+    return this.callJSON<TenantJobResponse>(operations.createTenant, args, options);
+  }
+
+  /** Register a webhook for file uploads, deletes and moves */
+  createWebhook(args: { body: WebhookRequest }, options?: RequestOptions): Promise<Webhook> {
+    return this.callJSON<Webhook>(operations.createWebhook, args, options);
+  }
+
+  /** Everything known about a raw key */
+  debugObject(args: { key: string }, options?: RequestOptions): Promise<ObjectDebugResponse> {
+    return this.callJSON<ObjectDebugResponse>(operations.debugObject, args, options);
+  }
+
+  /** Approve or reject a pending upload */
+  decideReview(args: { filename: string; tenant?: string; body: ReviewDecisionRequest }, options?: RequestOptions): Promise<ReviewRecord> {
+    return this.callJSON<ReviewRecord>(operations.decideReview, args, options);
+  }
+
+  /** Remove an alias, keeping its file */
+  deleteAlias(args: { alias: string }, options?: RequestOptions): Promise<Response> {
+    return this.call(operations.deleteAlias, args, options);
+  }
+
+  /** Remove an alias, keeping its file */
+  deleteAliasInBucket(args: { bucket: string; alias: string }, options?: RequestOptions): Promise<Response> {
+    return this.call(operations.deleteAliasInBucket, args, options);
+  }
+
+  /** Delete a captured request */
+  deleteCapture(args: { id: string }, options?: RequestOptions): Promise<MessageResponse> {
+    return this.callJSON<MessageResponse>(operations.deleteCapture, args, options);
+  }
+
+  /** Delete a file */
+  deleteFile(args: { filename: string; "If-Match"?: string }, options?: RequestOptions): Promise<MessageResponse> {
+    return this.callJSON<MessageResponse>(operations.deleteFile, args, options);
+  }
+
+  /** Delete a file */
+  deleteFileInBucket(args: { bucket: string; filename: string; "If-Match"?: string }, options?: RequestOptions): Promise<MessageResponse> {
+    return this.callJSON<MessageResponse>(operations.deleteFileInBucket, args, options);
+  }
+
+  /** Delete everything under a prefix */
+  deleteFolder(args: { prefix: string; dry_run?: boolean }, options?: RequestOptions): Promise<FolderDeleteResponse> {
+    return this.callJSON<FolderDeleteResponse>(operations.deleteFolder, args, options);
+  }
+
+  /** Delete everything under a prefix */
+  deleteFolderInBucket(args: { bucket: string; prefix: string; dry_run?: boolean }, options?: RequestOptions): Promise<FolderDeleteResponse> {
+    return this.callJSON<FolderDeleteResponse>(operations.deleteFolderInBucket, args, options);
+  }
+
+  /** Lift a content freeze */
+  deleteFreeze(args: { id: string }, options?: RequestOptions): Promise<MessageResponse> {
+    return this.callJSON<MessageResponse>(operations.deleteFreeze, args, options);
+  }
+
+  /** Delete an authorization policy rule */
+  deletePolicy(args: { id: string }, options?: RequestOptions): Promise<MessageResponse> {
+    return this.callJSON<MessageResponse>(operations.deletePolicy, args, options);
+  }
+
+  /** Delete a webhook */
+  deleteWebhook(args: { id: string }, options?: RequestOptions): Promise<MessageResponse> {
+    return this.callJSON<MessageResponse>(operations.deleteWebhook, args, options);
+  }
+
+  /** Disable a plugin version */
+  disablePlugin(args: { name: string; version: string }, options?: RequestOptions): Promise<MessageResponse> {
+    return this.callJSON<MessageResponse>(operations.disablePlugin, args, options);
+  }
+
+  /** Download a cache archive */
+  downloadActionsCache(args: { token: string; id: string; Range?: string }, options?: RequestOptions): Promise<Response> {
+    return this.call(operations.downloadActionsCache, args, options);
+  }
+
+  /** Download the version a channel serves */
+  downloadChannel(args: { channel: string; at?: string }, options?: RequestOptions): Promise<Response> {
+    return this.call(operations.downloadChannel, args, options);
+  }
+
+  /** Download the version a channel serves */
+  downloadChannelInBucket(args: { bucket: string; channel: string; at?: string }, options?: RequestOptions): Promise<Response> {
+    return this.call(operations.downloadChannelInBucket, args, options);
+  }
+
+  /** Enable a plugin version for some or every tenant, or change its config */
+  enablePlugin(args: { name: string; version: string; body: PluginEnableRequest }, options?: RequestOptions): Promise<PluginEnablement> {
+    return this.callJSON<PluginEnablement>(operations.enablePlugin, args, options);
+  }
+
+  /** MD5, SHA-1 or stored digests of a file */
+  fileChecksums(args: { filename: string; hash?: string }, options?: RequestOptions): Promise<FileChecksums> {
+    return this.callJSON<FileChecksums>(operations.fileChecksums, args, options);
+  }
+
+  /** MD5, SHA-1 or stored digests of a file */
+  fileChecksumsInBucket(args: { bucket: string; filename: string; hash?: string }, options?: RequestOptions): Promise<FileChecksums> {
+    return this.callJSON<FileChecksums>(operations.fileChecksumsInBucket, args, options);
+  }
+
+  /** Show file metadata */
+  fileMetadata(args: { filename: string }, options?: RequestOptions): Promise<FileMetadata> {
+    return this.callJSON<FileMetadata>(operations.fileMetadata, args, options);
+  }
+
+  /** Show file metadata */
+  fileMetadataInBucket(args: { bucket: string; filename: string }, options?: RequestOptions): Promise<FileMetadata> {
+    return this.callJSON<FileMetadata>(operations.fileMetadataInBucket, args, options);
+  }
+
+  /** Atom feed of the newest files under a prefix */
+  filesFeed(args: { prefix?: string } = {}, options?: RequestOptions): Promise<Response> {
+    return this.call(operations.filesFeed, args, options);
+  }
+
+  /** Atom feed of the newest files under a prefix */
+  filesFeedInBucket(args: { bucket: string; prefix?: string }, options?: RequestOptions): Promise<Response> {
+    return this.call(operations.filesFeedInBucket, args, options);
+  }
+
+  /** Show who can access a file */
+  getACL(args: { filename: string }, options?: RequestOptions): Promise<ACLResponse> {
+    return this.callJSON<ACLResponse>(operations.getACL, args, options);
+  }
+
+  /** Show who can access a file */
+  getACLInBucket(args: { bucket: string; filename: string }, options?: RequestOptions): Promise<ACLResponse> {
+    return this.callJSON<ACLResponse>(operations.getACLInBucket, args, options);
+  }
+
+  /** Find the cache to restore for a key or restore keys */
+  getActionsCache(args: { token: string; keys?: string; version?: string }, options?: RequestOptions): Promise<ActionsCacheEntry> {
+    return this.callJSON<ActionsCacheEntry>(operations.getActionsCache, args, options);
+  }
+
+  /** Show the file an alias points at */
+  getAlias(args: { alias: string }, options?: RequestOptions): Promise<Alias> {
+    return this.callJSON<Alias>(operations.getAlias, args, options);
+  }
+
+  /** Show the file an alias points at */
+  getAliasInBucket(args: { bucket: string; alias: string }, options?: RequestOptions): Promise<Alias> {
+    return this.callJSON<Alias>(operations.getAliasInBucket, args, options);
+  }
+
+  /** Download a month's usage report as JSON or CSV */
+  getBillingReport(args: { month: string; format?: string }, options?: RequestOptions): Promise<BillingReport> {
+    return this.callJSON<BillingReport>(operations.getBillingReport, args, options);
+  }
+
+  /** Fetch a Bazel or Gradle build cache entry */
+  getBuildCache(args: { kind: string; hash: string }, options?: RequestOptions): Promise<Response> {
+    return this.call(operations.getBuildCache, args, options);
+  }
+
+  /** Show a captured request and its response */
+  getCapture(args: { id: string }, options?: RequestOptions): Promise<CapturedExchange> {
+    return this.callJSON<CapturedExchange>(operations.getCapture, args, options);
+  }
+
+  /** Show a release channel and its history */
+  getChannel(args: { channel: string; at?: string }, options?: RequestOptions): Promise<Channel> {
+    return this.callJSON<Channel>(operations.getChannel, args, options);
+  }
+
+  /** Show a release channel and its history */
+  getChannelInBucket(args: { bucket: string; channel: string; at?: string }, options?: RequestOptions): Promise<Channel> {
+    return this.callJSON<Channel>(operations.getChannelInBucket, args, options);
+  }
+
+  /** Show an export job */
+  getExport(args: { id: string }, options?: RequestOptions): Promise<Job> {
+    return this.callJSON<Job>(operations.getExport, args, options);
+  }
+
+  /** Download a file */
+  getFile(args: { filename: string; version_id?: string }, options?: RequestOptions): Promise<Response> {
+    return this.call(operations.getFile, args, options);
+  }
+
+  /** Download a file */
+  getFileInBucket(args: { bucket: string; filename: string; version_id?: string }, options?: RequestOptions): Promise<Response> {
+    return this.call(operations.getFileInBucket, args, options);
+  }
+
+  /** Show any background job */
+  getJob(args: { id: string }, options?: RequestOptions): Promise<Job> {
+    return this.callJSON<Job>(operations.getJob, args, options);
+  }
+
+  /** Show the legal hold */
+  getLegalHold(args: { filename: string }, options?: RequestOptions): Promise<LegalHoldResponse> {
+    return this.callJSON<LegalHoldResponse>(operations.getLegalHold, args, options);
+  }
+
+  /** Show the legal hold */
+  getLegalHoldInBucket(args: { bucket: string; filename: string }, options?: RequestOptions): Promise<LegalHoldResponse> {
+    return this.callJSON<LegalHoldResponse>(operations.getLegalHoldInBucket, args, options);
+  }
+
+  /** Show the log level */
+  getLogLevel(args: Record<string, never> = {}, options?: RequestOptions): Promise<LogLevelResponse> {
+    return this.callJSON<LogLevelResponse>(operations.getLogLevel, args, options);
+  }
+
+  /** List a public file's mirrors and their health */
+  getMirrors(args: { filename: string }, options?: RequestOptions): Promise<MirrorsResponse> {
+    return this.callJSON<MirrorsResponse>(operations.getMirrors, args, options);
+  }
+
+  /** List a public file's mirrors and their health */
+  getMirrorsInBucket(args: { bucket: string; filename: string }, options?: RequestOptions): Promise<MirrorsResponse> {
+    return this.callJSON<MirrorsResponse>(operations.getMirrorsInBucket, args, options);
+  }
+
+  /** Show an authorization policy rule */
+  getPolicy(args: { id: string }, options?: RequestOptions): Promise<PolicyRule> {
+    return this.callJSON<PolicyRule>(operations.getPolicy, args, options);
+  }
+
+  /** Show whether the last upload of a file is pending, approved or rejected */
+  getReview(args: { filename: string }, options?: RequestOptions): Promise<ReviewRecord> {
+    return this.callJSON<ReviewRecord>(operations.getReview, args, options);
+  }
+
+  /** Show a week's status report */
+  getStatusReport(args: { week: string }, options?: RequestOptions): Promise<StatusReport> {
+    return this.callJSON<StatusReport>(operations.getStatusReport, args, options);
+  }
+
+  /** Show an onboarded tenant */
+  getTenant(args: { id: string }, options?: RequestOptions): Promise<TenantRecord> {
+    return this.callJSON<TenantRecord>(operations.getTenant, args, options);
+  }
+
+  /** Grant read or write access */
+  grantAccess(args: { filename: string; body: ACLGrant }, options?: RequestOptions): Promise<ACLResponse> {
+    return this.callJSON<ACLResponse>(operations.grantAccess, args, options);
+  }
+
+  /** Grant read or write access */
+  grantAccessInBucket(args: { bucket: string; filename: string; body: ACLGrant }, options?: RequestOptions): Promise<ACLResponse> {
+    return this.callJSON<ACLResponse>(operations.grantAccessInBucket, args, options);
+  }
+
+  /** Query files, folders, tags and usage with GraphQL */
+  graphql(args: { body: GraphQLRequest }, options?: RequestOptions): Promise<Record<string, unknown>> {
+    return this.callJSON<Record<string, unknown>>(operations.graphql, args, options);
+  }
+
+  /** Query files, folders, tags and usage with GraphQL */
+  graphqlInBucket(args: { bucket: string; body: GraphQLRequest }, options?: RequestOptions): Promise<Record<string, unknown>> {
+    return this.callJSON<Record<string, unknown>>(operations.graphqlInBucket, args, options);
+  }
+
+  /** The GraphQL schema, in the schema language */
+  graphqlSchema(args: Record<string, never> = {}, options?: RequestOptions): Promise<Response> {
+    return this.call(operations.graphqlSchema, args, options);
+  }
+
+  /** The GraphQL schema, in the schema language */
+  graphqlSchemaInBucket(args: { bucket: string }, options?: RequestOptions): Promise<Response> {
+    return this.call(operations.graphqlSchemaInBucket, args, options);
+  }
+
+  /** Size, date and ETag of a browsed file */
+  headBrowse(args: { path: string }, options?: RequestOptions): Promise<Response> {
+    return this.call(operations.headBrowse, args, options);
+  }
+
+  /** Size, date and ETag of a browsed file */
+  headBrowseInBucket(args: { bucket: string; path: string }, options?: RequestOptions): Promise<Response> {
+    return this.call(operations.headBrowseInBucket, args, options);
+  }
+
+  /** Health check with component status */
+  health(args: { deep?: boolean } = {}, options?: RequestOptions): Promise<HealthResponse> {
+    return this.callJSON<HealthResponse>(operations.health, args, options);
+  }
+
+  /** Latency budgets per route and how fast they're being spent */
+  latencySLOs(args: Record<string, never> = {}, options?: RequestOptions): Promise<SLOStatusResponse> {
+    return this.callJSON<SLOStatusResponse>(operations.latencySLOs, args, options);
+  }
+
+  /** List aliases, or one file's with ?target= */
+  listAliases(args: { target?: string } = {}, options?: RequestOptions): Promise<AliasesResponse> {
+    return this.callJSON<AliasesResponse>(operations.listAliases, args, options);
+  }
+
+  /** List aliases, or one file's with ?target= */
+  listAliasesInBucket(args: { bucket: string; target?: string }, options?: RequestOptions): Promise<AliasesResponse> {
+    return this.callJSON<AliasesResponse>(operations.listAliasesInBucket, args, options);
+  }
+
+  /** List named buckets */
+  listBuckets(args: Record<string, never> = {}, options?: RequestOptions): Promise<BucketsResponse> {
+    return this.callJSON<BucketsResponse>(operations.listBuckets, args, options);
+  }
+
+  /** List captured requests */
+  listCaptures(args: Record<string, never> = {}, options?: RequestOptions): Promise<CapturesResponse> {
+    return this.callJSON<CapturesResponse>(operations.listCaptures, args, options);
+  }
+
+  /** List release channels and what each serves */
+  listChannels(args: Record<string, never> = {}, options?: RequestOptions): Promise<ChannelsResponse> {
+    return this.callJSON<ChannelsResponse>(operations.listChannels, args, options);
+  }
+
+  /** List release channels and what each serves */
+  listChannelsInBucket(args: { bucket: string }, options?: RequestOptions): Promise<ChannelsResponse> {
+    return this.callJSON<ChannelsResponse>(operations.listChannelsInBucket, args, options);
+  }
+
+  /** List files */
+  listFiles(args: { scan_status?: boolean } = {}, options?: RequestOptions): Promise<FilesResponse> {
+    return this.callJSON<FilesResponse>(operations.listFiles, args, options);
+  }
+
+  /** List files */
+  listFilesInBucket(args: { bucket: string; scan_status?: boolean }, options?: RequestOptions): Promise<FilesResponse> {
+    return this.callJSON<FilesResponse>(operations.listFilesInBucket, args, options);
+  }
+
+  /** List the content freezes in force */
+  listFreezes(args: Record<string, never> = {}, options?: RequestOptions): Promise<FreezesResponse> {
+    return this.callJSON<FreezesResponse>(operations.listFreezes, args, options);
+  }
+
+  /** List the plugin versions in the catalog and how they are enabled */
+  listPlugins(args: Record<string, never> = {}, options?: RequestOptions): Promise<PluginsResponse> {
+    return this.callJSON<PluginsResponse>(operations.listPlugins, args, options);
+  }
+
+  /** List authorization policy rules */
+  listPolicies(args: Record<string, never> = {}, options?: RequestOptions): Promise<PoliciesResponse> {
+    return this.callJSON<PoliciesResponse>(operations.listPolicies, args, options);
+  }
+
+  /** List uploads held for review and how they were decided */
+  listReviews(args: { status?: string } = {}, options?: RequestOptions): Promise<ReviewsResponse> {
+    return this.callJSON<ReviewsResponse>(operations.listReviews, args, options);
+  }
+
+  /** List tenants onboarded through the registry */
+  listTenants(args: Record<string, never> = {}, options?: RequestOptions): Promise<TenantsResponse> {
+    return this.callJSON<TenantsResponse>(operations.listTenants, args, options);
+  }
+
+  /** List trashed files */
+  listTrash(args: Record<string, never> = {}, options?: RequestOptions): Promise<TrashResponse> {
+    return this.callJSON<TrashResponse>(operations.listTrash, args, options);
+  }
+
+  /** List trashed files */
+  listTrashInBucket(args: { bucket: string }, options?: RequestOptions): Promise<TrashResponse> {
+    return this.callJSON<TrashResponse>(operations.listTrashInBucket, args, options);
+  }
+
+  /** List versions of a file */
+  listVersions(args: { filename: string }, options?: RequestOptions): Promise<VersionsResponse> {
+    return this.callJSON<VersionsResponse>(operations.listVersions, args, options);
+  }
+
+  /** List versions of a file */
+  listVersionsInBucket(args: { bucket: string; filename: string }, options?: RequestOptions): Promise<VersionsResponse> {
+    return this.callJSON<VersionsResponse>(operations.listVersionsInBucket, args, options);
+  }
+
+  /** List webhooks and this instance's deliveries to them */
+  listWebhooks(args: Record<string, never> = {}, options?: RequestOptions): Promise<WebhooksResponse> {
+    return this.callJSON<WebhooksResponse>(operations.listWebhooks, args, options);
+  }
+
+  /** Revoke a tenant's keys, export its files and purge them */
+  offboardTenant(args: { id: string; "Idempotency-Key"?: string; body: OffboardTenantRequest }, options?: RequestOptions): Promise<TenantJobResponse> {
+    return this.callJSON<TenantJobResponse>(operations.offboardTenant, args, options);
+  }
+
+  /** OpenAPI description of this API */
+  openAPI(args: Record<string, never> = {}, options?: RequestOptions): Promise<Record<string, unknown>> {
+    return this.callJSON<Record<string, unknown>>(operations.openAPI, args, options);
+  }
+
+  /** Release a file version to a channel */
+  promoteChannel(args: { channel: string; body: PromoteRequest }, options?: RequestOptions): Promise<Channel> {
+    return this.callJSON<Channel>(operations.promoteChannel, args, options);
+  }
+
+  /** Release a file version to a channel */
+  promoteChannelInBucket(args: { bucket: string; channel: string; body: PromoteRequest }, options?: RequestOptions): Promise<Channel> {
+    return this.callJSON<Channel>(operations.promoteChannelInBucket, args, options);
+  }
+
+  /** Download a public file without credentials */
+  publicFile(args: { filename: string; mirror?: string; "If-None-Match"?: string; Range?: string }, options?: RequestOptions): Promise<Response> {
+    return this.call(operations.publicFile, args, options);
+  }
+
+  /** Mirrors of a public file, healthiest first */
+  publicMirrors(args: { filename: string }, options?: RequestOptions): Promise<MirrorsResponse> {
+    return this.callJSON<MirrorsResponse>(operations.publicMirrors, args, options);
+  }
+
+  /** Torrent of a large public file, web-seeded by its public URL */
+  publicTorrent(args: { filename: string }, options?: RequestOptions): Promise<Response> {
+    return this.call(operations.publicTorrent, args, options);
+  }
+
+  /** Give a file another name without copying it */
+  putAlias(args: { alias: string; "If-Match"?: string; "If-None-Match"?: string; body: AliasRequest }, options?: RequestOptions): Promise<Alias> {
+    return this.callJSON<Alias>(operations.putAlias, args, options);
+  }
+
+  /** Give a file another name without copying it */
+  putAliasInBucket(args: { bucket: string; alias: string; "If-Match"?: string; "If-None-Match"?: string; body: AliasRequest }, options?: RequestOptions): Promise<Alias> {
+    return this.callJSON<Alias>(operations.putAliasInBucket, args, options);
+  }
+
+  /** Store a Bazel or Gradle build cache entry */
+  putBuildCache(args: { kind: string; hash: string }, options?: RequestOptions): Promise<Response> {
+    return this.call(operations.putBuildCache, args, options);
+  }
+
+  /** Health and latency of each region's copy of the files bucket */
+  regions(args: Record<string, never> = {}, options?: RequestOptions): Promise<RegionsResponse> {
+    return this.callJSON<RegionsResponse>(operations.regions, args, options);
+  }
+
+  /** Render Markdown as HTML */
+  renderFile(args: { filename: string }, options?: RequestOptions): Promise<Response> {
+    return this.call(operations.renderFile, args, options);
+  }
+
+  /** Render Markdown as HTML */
+  renderFileInBucket(args: { bucket: string; filename: string }, options?: RequestOptions): Promise<Response> {
+    return this.call(operations.renderFileInBucket, args, options);
+  }
+
+  /** Replication lag and failures */
+  replicationStatus(args: Record<string, never> = {}, options?: RequestOptions): Promise<ReplicationStatus> {
+    return this.callJSON<ReplicationStatus>(operations.replicationStatus, args, options);
+  }
+
+  /** Reserve a cache to save */
+  reserveActionsCache(args: { token: string; body: ActionsCacheReserveRequest }, options?: RequestOptions): Promise<ActionsCacheReserveResponse> {
+    return this.callJSON<ActionsCacheReserveResponse>(operations.reserveActionsCache, args, options);
+  }
+
+  /** Restore a file from the trash */
+  restoreTrash(args: { filename: string; overwrite?: boolean }, options?: RequestOptions): Promise<MessageResponse> {
+    return this.callJSON<MessageResponse>(operations.restoreTrash, args, options);
+  }
+
+  /** Restore a file from the trash */
+  restoreTrashInBucket(args: { bucket: string; filename: string; overwrite?: boolean }, options?: RequestOptions): Promise<MessageResponse> {
+    return this.callJSON<MessageResponse>(operations.restoreTrashInBucket, args, options);
+  }
+
+  /** Restore a version */
+  restoreVersion(args: { filename: string; version_id: string }, options?: RequestOptions): Promise<RestoreResponse> {
+    return this.callJSON<RestoreResponse>(operations.restoreVersion, args, options);
+  }
+
+  /** Restore a version */
+  restoreVersionInBucket(args: { bucket: string; filename: string; version_id: string }, options?: RequestOptions): Promise<RestoreResponse> {
+    return this.callJSON<RestoreResponse>(operations.restoreVersionInBucket, args, options);
+  }
+
+  /** Revoke a principal's access */
+  revokeAccess(args: { filename: string; principal?: string }, options?: RequestOptions): Promise<ACLResponse> {
+    return this.callJSON<ACLResponse>(operations.revokeAccess, args, options);
+  }
+
+  /** Revoke a principal's access */
+  revokeAccessInBucket(args: { bucket: string; filename: string; principal?: string }, options?: RequestOptions): Promise<ACLResponse> {
+    return this.callJSON<ACLResponse>(operations.revokeAccessInBucket, args, options);
+  }
+
+  /** Put a channel's earlier release back */
+  rollbackChannel(args: { channel: string; body: RollbackRequest }, options?: RequestOptions): Promise<Channel> {
+    return this.callJSON<Channel>(operations.rollbackChannel, args, options);
+  }
+
+  /** Put a channel's earlier release back */
+  rollbackChannelInBucket(args: { bucket: string; channel: string; body: RollbackRequest }, options?: RequestOptions): Promise<Channel> {
+    return this.callJSON<Channel>(operations.rollbackChannelInBucket, args, options);
+  }
+
+  /** Show the signed-in caller */
+  session(args: Record<string, never> = {}, options?: RequestOptions): Promise<SessionResponse> {
+    return this.callJSON<SessionResponse>(operations.session, args, options);
+  }
+
+  /** Point a prefix's latest pointer at a file, or at its newest */
+  setLatest(args: { prefix: string; "If-Match"?: string; body: LatestRequest }, options?: RequestOptions): Promise<Alias> {
+    return this.callJSON<Alias>(operations.setLatest, args, options);
+  }
+
+  /** Point a prefix's latest pointer at a file, or at its newest */
+  setLatestInBucket(args: { bucket: string; prefix: string; "If-Match"?: string; body: LatestRequest }, options?: RequestOptions): Promise<Alias> {
+    return this.callJSON<Alias>(operations.setLatestInBucket, args, options);
+  }
+
+  /** Set or clear the legal hold */
+  setLegalHold(args: { filename: string; body: LegalHoldRequest }, options?: RequestOptions): Promise<LegalHoldResponse> {
+    return this.callJSON<LegalHoldResponse>(operations.setLegalHold, args, options);
+  }
+
+  /** Set or clear the legal hold */
+  setLegalHoldInBucket(args: { bucket: string; filename: string; body: LegalHoldRequest }, options?: RequestOptions): Promise<LegalHoldResponse> {
+    return this.callJSON<LegalHoldResponse>(operations.setLegalHoldInBucket, args, options);
+  }
+
+  /** Change the log level until this instance restarts */
+  setLogLevel(args: { body: LogLevelRequest }, options?: RequestOptions): Promise<LogLevelResponse> {
+    return this.callJSON<LogLevelResponse>(operations.setLogLevel, args, options);
+  }
+
+  /** Set the mirrors a public file can also be downloaded from */
+  setMirrors(args: { filename: string; body: MirrorsRequest }, options?: RequestOptions): Promise<MirrorsResponse> {
+    return this.callJSON<MirrorsResponse>(operations.setMirrors, args, options);
+  }
+
+  /** Set the mirrors a public file can also be downloaded from */
+  setMirrorsInBucket(args: { bucket: string; filename: string; body: MirrorsRequest }, options?: RequestOptions): Promise<MirrorsResponse> {
+    return this.callJSON<MirrorsResponse>(operations.setMirrorsInBucket, args, options);
+  }
+
+  /** Shadow traffic comparisons and recent mismatches */
+  shadowStatus(args: Record<string, never> = {}, options?: RequestOptions): Promise<ShadowStatus> {
+    return this.callJSON<ShadowStatus>(operations.shadowStatus, args, options);
+  }
+
+  /** Download a shared file without credentials */
+  shareDownload(args: { token: string; "X-Share-Password"?: string }, options?: RequestOptions): Promise<Response> {
+    return this.call(operations.shareDownload, args, options);
+  }
+
+  /** Download a password-protected shared file */
+  shareUnlock(args: { token: string; body: SharePasswordForm }, options?: RequestOptions): Promise<Response> {
+    return this.call(operations.shareUnlock, args, options);
+  }
+
+  /** Tail a file, optionally following appends */
+  tailFile(args: { filename: string; lines?: number; follow?: boolean }, options?: RequestOptions): Promise<Response> {
+    return this.call(operations.tailFile, args, options);
+  }
+
+  /** Tail a file, optionally following appends */
+  tailFileInBucket(args: { bucket: string; filename: string; lines?: number; follow?: boolean }, options?: RequestOptions): Promise<Response> {
+    return this.call(operations.tailFileInBucket, args, options);
+  }
+
+  /** Replace an authorization policy rule */
+  updatePolicy(args: { id: string; body: PolicyRule }, options?: RequestOptions): Promise<PolicyRule> {
+    return this.callJSON<PolicyRule>(operations.updatePolicy, args, options);
+  }
+
+  /** Upload a file */
+  upload(args: { on_conflict?: string; overwrite?: boolean; "If-Match"?: string; "If-None-Match"?: string; body: UploadRequest }, options?: RequestOptions): Promise<MessageResponse> {
+    return this.callJSON<MessageResponse>(operations.upload, args, options);
+  }
+
+  /** Upload a chunk of a reserved cache */
+  uploadActionsCache(args: { token: string; id: string; "Content-Range"?: string }, options?: RequestOptions): Promise<Response> {
+    return this.call(operations.uploadActionsCache, args, options);
+  }
+
+  /** Upload a file */
+  uploadInBucket(args: { bucket: string; on_conflict?: string; overwrite?: boolean; "If-Match"?: string; "If-None-Match"?: string; body: UploadRequest }, options?: RequestOptions): Promise<MessageResponse> {
+    return this.callJSON<MessageResponse>(operations.uploadInBucket, args, options);
+  }
+
+  /** Storage used against the quota */
+  usage(args: Record<string, never> = {}, options?: RequestOptions): Promise<UsageResponse> {
+    return this.callJSON<UsageResponse>(operations.usage, args, options);
+  }
+
+  /** Storage calls, bytes and cache hits of the tenant's requests, by credential */
+  usageCosts(args: Record<string, never> = {}, options?: RequestOptions): Promise<CostsResponse> {
+    return this.callJSON<CostsResponse>(operations.usageCosts, args, options);
+  }
+}
@@ -0,0 +1,4 @@
+export { Client, uploadBody } from "./client.js";
+export type { ClientOptions, UploadFields, UploadOptions, UploadSource } from "./client.js";
+export * from "./errors.js";
+export * from "./generated.js";
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// secretProvider fetches a secret by the reference after its provider's
+// name, e.g. "prod/files/api-keys" in "secretsmanager:prod/files/api-keys".
+type secretProvider interface {
+	fetch(ctx context.Context, ref string) (string, error)
+}
+
+// secretProviders are the providers SECRET_SOURCES may name. Secrets Manager
+// and SSM Parameter Store need their own SDK clients, so they are only built
+// in with -tags awssecrets.
+var secretProviders = map[string]func(ctx context.Context) (secretProvider, error){}
+
+func registerSecretProvider(name string, open func(ctx context.Context) (secretProvider, error)) bool {
+	secretProviders[name] = open
+	return true
+}
+
+var (
+	// Settings to read from a secret store instead of the environment, e.g.
+	// SECRET_SOURCES="API_KEYS=secretsmanager:prod/files#api_keys,QUOTA_WEBHOOK_SECRET=ssm:/files/quota-webhook"
+	secretSources = parseAssignments(os.Getenv("SECRET_SOURCES"))
+
+	// How often secrets are fetched again, so rotations are picked up; 0
+	// reads them only at startup
+	secretsRefreshInterval = durationFromEnv("SECRETS_REFRESH_INTERVAL", 5*time.Minute)
+
+	// The providers opened so far, and the last value of each setting
+	openSecretProviders = map[string]secretProvider{}
+	secretValues        = map[string]string{}
+)
+
+// secretTargets are the settings SECRET_SOURCES can supply, and how each
+// is applied. Refreshes apply them while requests run, so they are set
+// under reloadMu and read with currentSetting.
+var secretTargets = map[string]func(value string, startup bool) error{
+	"API_KEYS": func(value string, startup bool) error {
+		keys := parseAssignments(value)
+		// Key/value secrets in Secrets Manager are JSON objects
+		if strings.HasPrefix(strings.TrimSpace(value), "{") {
+			keys = map[string]string{}
+			if err := json.Unmarshal([]byte(value), &keys); err != nil {
+				return fmt.Errorf("want key=tenant pairs or a JSON object of them: %w", err)
+			}
+		}
+		if startup {
+			for _, tenant := range keys {
+				if !validTenantID.MatchString(tenant) {
+					return fmt.Errorf("invalid tenant id %q", tenant)
+				}
+			}
+		} else if err := checkReloadedAPIKeys(keys); err != nil {
+			return err
+		}
+		setReloadable(&apiKeys, keys)
+		return nil
+	},
+	"BILLING_WEBHOOK_SECRET":    stringSecret(&billingWebhookSecret),
+	"QUOTA_WEBHOOK_SECRET":      stringSecret(&quotaWebhookSecret),
+	"OIDC_CLIENT_SECRET":        stringSecret(&oidcClientSecret),
+	"KAFKA_SASL_PASSWORD":       stringSecret(&kafkaSASLPassword),
+	"NATS_TOKEN":                stringSecret(&natsToken),
+	"QUARANTINE_WEBHOOK_SECRET": stringSecret(&quarantineWebhookSecret),
+	"SESSION_SECRET": func(value string, _ bool) error {
+		setReloadable(&sessionSecret, []byte(value))
+		return nil
+	},
+}
+
+func stringSecret(target *string) func(string, bool) error {
+	return func(value string, _ bool) error {
+		setReloadable(target, value)
+		return nil
+	}
+}
+
+// loadSecrets fetches every secret in SECRET_SOURCES and applies those that
+// changed. At startup any failure is returned, since the server can't run
+// without its credentials; later ones are logged, keeping the last value.
+func loadSecrets(ctx context.Context, startup bool) error {
+	for _, name := range sortedKeys(secretSources) {
+		apply, ok := secretTargets[name]
+		if !ok {
+			return fmt.Errorf("SECRET_SOURCES names %s, which can't come from a secret", name)
+		}
+		value, err := fetchSecret(ctx, secretSources[name])
+		if err == nil && value == secretValues[name] {
+			continue
+		}
+		if err == nil {
+			err = apply(value, startup)
+		}
+		if err != nil {
+			err = fmt.Errorf("%s from %s: %w", name, secretSources[name], err)
+			if startup {
+				return err
+			}
+			slog.ErrorContext(ctx, "Failed to refresh secret; keeping the last value", "setting", name, "err", err)
+			continue
+		}
+		secretValues[name] = value
+		if !startup {
+			slog.InfoContext(ctx, "Refreshed secret", "setting", name)
+		}
+	}
+	return nil
+}
+
+// fetchSecret resolves a "<provider>:<ref>[#<field>]" source. With a field,
+// the secret is a JSON object and the value is that field's.
+func fetchSecret(ctx context.Context, source string) (string, error) {
+	name, ref, ok := strings.Cut(source, ":")
+	if !ok || ref == "" {
+		return "", fmt.Errorf("want <provider>:<name>")
+	}
+	provider, ok := openSecretProviders[name]
+	if !ok {
+		open, found := secretProviders[name]
+		if !found {
+			return "", fmt.Errorf("this build has no secret provider %q", name)
+		}
+		var err error
+		if provider, err = open(ctx); err != nil {
+			return "", err
+		}
+		openSecretProviders[name] = provider
+	}
+
+	ref, field, hasField := strings.Cut(ref, "#")
+	value, err := provider.fetch(ctx, ref)
+	if err != nil || !hasField {
+		return value, err
+	}
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(value), &fields); err != nil {
+		return "", fmt.Errorf("secret isn't a JSON object, so has no field %q", field)
+	}
+	switch v := fields[field].(type) {
+	case string:
+		return v, nil
+	case nil:
+		return "", fmt.Errorf("secret has no field %q", field)
+	default:
+		// e.g. API keys kept as a nested object
+		encoded, err := json.Marshal(v)
+		return string(encoded), err
+	}
+}
+
+func startSecretRefresher(ctx context.Context) {
+	if len(secretSources) == 0 || secretsRefreshInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(secretsRefreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				loadSecrets(ctx, false)
+			}
+		}
+	}()
+}
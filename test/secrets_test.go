@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// fakeSecrets is a secret store holding secrets by reference.
+type fakeSecrets map[string]string
+
+func (f fakeSecrets) fetch(_ context.Context, ref string) (string, error) {
+	value, ok := f[ref]
+	if !ok {
+		return "", errors.New("ResourceNotFoundException")
+	}
+	return value, nil
+}
+
+func useFakeSecrets(t *testing.T, sources map[string]string, store fakeSecrets) {
+	t.Helper()
+	override(t, &secretSources, sources)
+	override(t, &secretProviders, map[string]func(context.Context) (secretProvider, error){
+		"fake": func(context.Context) (secretProvider, error) { return store, nil },
+	})
+	override(t, &openSecretProviders, map[string]secretProvider{})
+	override(t, &secretValues, map[string]string{})
+}
+
+func TestSecrets(t *testing.T) {
+	override(t, &apiKeys, map[string]string{"env-key": "acme"})
+	override(t, &quotaWebhookSecret, "")
+	store := fakeSecrets{
+		"prod/files":    `{"api_keys": {"secret-key": "acme"}, "other": 1}`,
+		"quota-webhook": "s3cr3t",
+	}
+	useFakeSecrets(t, map[string]string{
+		"API_KEYS":             "fake:prod/files#api_keys",
+		"QUOTA_WEBHOOK_SECRET": "fake:quota-webhook",
+	}, store)
+	srv, _ := newTestServer(t)
+
+	if err := loadSecrets(context.Background(), true); err != nil {
+		t.Fatal(err)
+	}
+	if currentSetting(&quotaWebhookSecret) != "s3cr3t" {
+		t.Errorf("quota webhook secret %q", quotaWebhookSecret)
+	}
+	expectStatus(t, call(t, srv, "GET", "/api/files", nil, "X-API-Key", "env-key"), http.StatusUnauthorized)
+	expectStatus(t, call(t, srv, "GET", "/api/files", nil, "X-API-Key", "secret-key"), http.StatusOK)
+
+	// A rotation is picked up by the next refresh
+	store["prod/files"] = `{"api_keys": {"rotated-key": "acme"}}`
+	loadSecrets(context.Background(), false)
+	expectStatus(t, call(t, srv, "GET", "/api/files", nil, "X-API-Key", "secret-key"), http.StatusUnauthorized)
+	expectStatus(t, call(t, srv, "GET", "/api/files", nil, "X-API-Key", "rotated-key"), http.StatusOK)
+
+	// A refresh that fails keeps the last value
+	delete(store, "quota-webhook")
+	store["prod/files"] = `{"api_keys": {"bad-key": "not a tenant"}}`
+	if err := loadSecrets(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, call(t, srv, "GET", "/api/files", nil, "X-API-Key", "rotated-key"), http.StatusOK)
+	if currentSetting(&quotaWebhookSecret) != "s3cr3t" {
+		t.Errorf("quota webhook secret lost on a failed refresh")
+	}
+}
+
+func TestSecretSourceProblems(t *testing.T) {
+	override(t, &apiKeys, apiKeys)
+	for sources, want := range map[string]string{
+		"API_KEYS=fake:missing":             "ResourceNotFoundException",
+		"API_KEYS=fake:keys#absent":         `no field "absent"`,
+		"API_KEYS=fake:plain#field":         "isn't a JSON object",
+		"API_KEYS=vault:keys":               `no secret provider "vault"`,
+		"API_KEYS=keys":                     "want <provider>:<name>",
+		"API_KEYS=fake:bad-tenant":          `invalid tenant id "not a tenant"`,
+		"STORAGE_ENCRYPTION_KEY=fake:plain": "can't come from a secret",
+	} {
+		useFakeSecrets(t, parseAssignments(sources), fakeSecrets{
+			"keys":       `{"k": "acme"}`,
+			"plain":      "k=acme",
+			"bad-tenant": "k=not a tenant",
+		})
+		err := loadSecrets(context.Background(), true)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want %q", sources, err, want)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+var (
+	// Serve the admin API, health report, probes and metrics on this address
+	// as well, e.g. 127.0.0.1:9090, and stop serving the admin API on PORT.
+	// The listener has its own connections and no rate limit, so operators
+	// can reach the instance while clients are saturating the public one.
+	adminAddr = settings.Server.AdminAddr
+
+	// A client gets this long to send its request headers, so slow-loris
+	// clients can't hold connections open by trickling them
+	serverReadHeaderTimeout = settings.Server.ReadHeaderTimeout
+	// and this long to send the whole request, body included
+	serverReadTimeout = settings.Server.ReadTimeout
+	// The response must be written within this long of the request being
+	// read. Keep it above REQUEST_TIMEOUT; streaming routes are exempt.
+	serverWriteTimeout = settings.Server.WriteTimeout
+	// Idle keep-alive connections are closed after this long
+	serverIdleTimeout    = settings.Server.IdleTimeout
+	serverMaxHeaderBytes = settings.Server.MaxHeaderBytes
+)
+
+// newServer is the server the API listens with.
+func newServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: serverReadHeaderTimeout,
+		ReadTimeout:       serverReadTimeout,
+		WriteTimeout:      serverWriteTimeout,
+		IdleTimeout:       serverIdleTimeout,
+		MaxHeaderBytes:    serverMaxHeaderBytes,
+	}
+}
+
+// streamResponses lifts the server's read and write deadlines for routes
+// whose responses legitimately take longer than them, such as downloads of
+// large files and followed tails. A read deadline passing mid-response
+// would cancel the request, so it goes too.
+func streamResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		rc.SetReadDeadline(time.Time{})
+		rc.SetWriteDeadline(time.Time{})
+		next.ServeHTTP(w, r)
+	})
+}
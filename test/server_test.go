@@ -0,0 +1,103 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startServer serves handler with the configured timeouts.
+func startServer(t *testing.T, handler http.Handler) string {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(handler)
+	srv.Config = newServer("", handler)
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv.Listener.Addr().String()
+}
+
+func TestServerTimeouts(t *testing.T) {
+	override(t, &serverReadHeaderTimeout, 50*time.Millisecond)
+	override(t, &serverWriteTimeout, 50*time.Millisecond)
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(150 * time.Millisecond)
+		io.WriteString(w, "done")
+	})
+	mux := http.NewServeMux()
+	mux.Handle("/slow", slow)
+	mux.Handle("/stream", streamResponses(slow))
+	addr := startServer(t, mux)
+
+	// A client that never finishes its headers is cut off
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /slow HTTP/1.1\r\nHost: example.com\r\n")
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Fatalf("connection with unfinished headers was kept open: %v", err)
+	}
+
+	if _, err := http.Get("http://" + addr + "/slow"); err == nil {
+		t.Fatal("response outlived the write timeout")
+	}
+	resp, err := http.Get("http://" + addr + "/stream")
+	if err != nil {
+		t.Fatalf("streaming route hit the write timeout: %v", err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); string(body) != "done" {
+		t.Fatalf("streamed %q", body)
+	}
+}
+
+func TestServerMaxHeaderBytes(t *testing.T) {
+	override(t, &serverMaxHeaderBytes, 1024)
+	addr := startServer(t, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	req, _ := http.NewRequest("GET", "http://"+addr+"/", nil)
+	req.Header.Set("X-Padding", strings.Repeat("x", 8<<10))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestHeaderFieldsTooLarge {
+		t.Fatalf("oversized headers got %d", resp.StatusCode)
+	}
+}
+
+func TestAdminListener(t *testing.T) {
+	override(t, &adminToken, "admin-secret")
+	admin := []string{"Authorization", "Bearer admin-secret"}
+	override(t, &rateLimitRPS, 1)
+	override(t, &rateLimitBurst, 1)
+	override(t, &limiters, &rateLimiters{buckets: map[string]*tokenBucket{}})
+	srv, _ := newTestServer(t)
+	public := httptest.NewServer(buildRouter(withoutGroup(apiRoutes(), "admin")))
+	t.Cleanup(public.Close)
+	ops := httptest.NewServer(buildRouter(opsRoutes()))
+	t.Cleanup(ops.Close)
+
+	// Clients have used up the rate limit
+	call(t, srv, "GET", "/api/files", nil)
+	expectStatus(t, call(t, srv, "GET", "/api/files", nil), http.StatusTooManyRequests)
+
+	for i := 0; i < 3; i++ {
+		expectStatus(t, call(t, ops, "GET", "/api/admin/log-level", nil, admin...), http.StatusOK)
+	}
+	expectStatus(t, call(t, ops, "GET", "/api/health", nil), http.StatusOK)
+	expectStatus(t, call(t, ops, "GET", "/readyz", nil), http.StatusOK)
+	// The preflight handler matches every path, so unrouted requests are
+	// answered 405 rather than 404
+	expectStatus(t, call(t, ops, "GET", "/api/files", nil), http.StatusMethodNotAllowed)
+
+	// With its own listener, the admin API is no longer served with the rest
+	expectStatus(t, call(t, public, "GET", "/api/admin/log-level", nil, admin...), http.StatusMethodNotAllowed)
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// SFTP serves the tenant's files to partners whose tools can only deliver
+// over SFTP. It logs in with an API key or JWT as the password, as WebDAV
+// and the build cache do with Basic auth, and uses the WebDAV file system,
+// so folders, ACLs, validators, size limits and quotas work the same way.
+// The SSH server needs golang.org/x/crypto/ssh and github.com/pkg/sftp, so
+// it is only built in with -tags sftp.
+var (
+	sftpAddr        = os.Getenv("SFTP_ADDR")
+	sftpHostKeyFile = os.Getenv("SFTP_HOST_KEY_FILE")
+)
+
+// serveSFTP is set by builds with SFTP support. It serves SSH connections
+// from listener until ctx ends, with the PEM private key hostKey.
+var serveSFTP func(ctx context.Context, listener net.Listener, hostKey []byte) error
+
+func registerSFTP(serve func(ctx context.Context, listener net.Listener, hostKey []byte) error) bool {
+	serveSFTP = serve
+	return true
+}
+
+// errFrozen is what SFTP clients are told when a content freeze refuses a
+// write; their protocol has no status for it.
+var errFrozen = errors.New("content freeze")
+
+// startSFTP starts the SFTP listener, if SFTP_ADDR asks for one.
+func startSFTP(ctx context.Context) error {
+	switch {
+	case sftpAddr == "":
+		return nil
+	case serveSFTP == nil:
+		return errors.New("SFTP_ADDR is not supported by this build")
+	case !tenancyEnabled():
+		// Without credentials there would be nothing to log in with
+		return errors.New("SFTP_ADDR needs API keys, JWTs or the tenant registry")
+	case sftpHostKeyFile == "":
+		return errors.New("SFTP_ADDR needs SFTP_HOST_KEY_FILE")
+	}
+	hostKey, err := os.ReadFile(sftpHostKeyFile)
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", sftpAddr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := serveSFTP(ctx, listener, hostKey); err != nil {
+			slog.Error("SFTP server stopped", "err", err)
+		}
+	}()
+	slog.Info("SFTP server listening", "addr", listener.Addr().String())
+	return nil
+}
+
+// sftpSession is one logged in SFTP connection. Its methods take paths as
+// SFTP gives them, from a leading slash, and return errors the os package
+// recognizes, which the sftp package turns into protocol statuses.
+type sftpSession struct {
+	ctx       context.Context
+	fs        davFS
+	principal principal
+	remote    string
+	client    string
+}
+
+// sftpLogin checks password as the API key or JWT of a request would be
+// checked. The user name is only logged, as keys say who they are for.
+func sftpLogin(ctx context.Context, user, password, remote, client string) (*sftpSession, error) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+	if err != nil {
+		return nil, err
+	}
+	r.SetBasicAuth(user, password)
+	p, err := authenticate(r)
+	if err != nil {
+		slog.InfoContext(ctx, "SFTP login failed", "user", user, "remote", remote, "err", err)
+		return nil, err
+	}
+	ctx = context.WithValue(ctx, principalKey{}, p)
+	s := &sftpSession{
+		ctx:       withNamespace(ctx, namespaceFor(p.Tenant)),
+		fs:        davFS{subject: requestSubject(r.WithContext(ctx))},
+		principal: p,
+		remote:    remote,
+		client:    client,
+	}
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		s.remote = host
+	}
+	return s, nil
+}
+
+// authorize asks the authorization policy whether the session may take
+// action on name, a folder if dir is set.
+func (s *sftpSession) authorize(action, name string, dir bool) error {
+	if authzPolicy == nil {
+		return nil
+	}
+	name = strings.Trim(name, "/")
+	ns := namespaceFrom(s.ctx)
+	input := PolicyInput{
+		Action: action,
+		Method: "SFTP",
+		Path:   "/" + name,
+		Principal: PolicyPrincipal{
+			Subject: s.fs.subject,
+			Tenant:  ns.Tenant,
+			Method:  s.principal.Method,
+			Claims:  s.principal.Claims,
+		},
+		Resource: PolicyResource{Bucket: ns.Bucket},
+	}
+	switch {
+	case dir && name != "":
+		input.Resource.Prefix = name + "/"
+	case !dir:
+		input.Resource.Name = name
+		if err := describeResource(s.ctx, ns, &input.Resource); err != nil {
+			return err
+		}
+	}
+	decision, err := authzPolicy.decide(s.ctx, input)
+	if err != nil {
+		return err
+	}
+	if !decision.Allow {
+		slog.InfoContext(s.ctx, "SFTP request denied by policy", "action", action, "path", input.Path, "reason", decision.Reason)
+		return &os.PathError{Op: action, Path: input.Path, Err: os.ErrPermission}
+	}
+	return nil
+}
+
+// checkFrozen refuses writes to names, files or folders, under a freeze.
+func (s *sftpSession) checkFrozen(names ...string) error {
+	var files, prefixes []string
+	for _, name := range names {
+		if name = strings.Trim(name, "/"); name != "" {
+			files, prefixes = append(files, name), append(prefixes, name+"/")
+		}
+	}
+	f, frozen, err := frozenBy(s.ctx, namespaceFrom(s.ctx).Tenant, files, prefixes)
+	if err != nil || !frozen {
+		return err
+	}
+	if f.Until != "" {
+		return fmt.Errorf("%w on %q until %s: %s", errFrozen, f.Prefix, f.Until, f.Reason)
+	}
+	return fmt.Errorf("%w on %q: %s", errFrozen, f.Prefix, f.Reason)
+}
+
+// change makes a write that isn't a file upload, and records it. The
+// policy is asked about the first of names, a folder if dir is set.
+func (s *sftpSession) change(action, method string, names []string, dir bool, write func() error) error {
+	start := time.Now()
+	err := s.authorize(action, names[0], dir)
+	if err == nil {
+		err = s.checkFrozen(names...)
+	}
+	if err == nil {
+		err = write()
+	}
+	s.audit(start, action, method, names[0], err)
+	return err
+}
+
+// audit records a write, with a status as the REST API would have
+// answered it, so the audit log reads the same for both.
+func (s *sftpSession) audit(start time.Time, action, method, name string, err error) {
+	if len(auditSinks) == 0 {
+		return
+	}
+	status := http.StatusOK
+	var rejected errRejected
+	var exceeded errQuotaExceeded
+	var vetoed errVetoed
+	switch {
+	case err == nil:
+	case errors.Is(err, os.ErrPermission), errors.As(err, &vetoed):
+		status = http.StatusForbidden
+	case errors.Is(err, os.ErrNotExist):
+		status = http.StatusNotFound
+	case errors.Is(err, os.ErrExist):
+		status = http.StatusConflict
+	case errors.Is(err, errFrozen):
+		status = http.StatusLocked
+	case errors.As(err, &rejected):
+		status = http.StatusUnprocessableEntity
+	case errors.As(err, &exceeded):
+		status = http.StatusInsufficientStorage
+	default:
+		status = http.StatusInternalServerError
+	}
+	name = strings.Trim(name, "/")
+	writeAudit(context.WithoutCancel(s.ctx), AuditRecord{
+		ID:         captureID(start),
+		Time:       start.UTC().Format(time.RFC3339Nano),
+		Tenant:     namespaceFrom(s.ctx).Tenant,
+		Actor:      s.fs.subject,
+		AuthMethod: s.principal.Method,
+		Action:     action,
+		Method:     method,
+		Path:       "/" + name,
+		Key:        name,
+		Status:     status,
+		Result:     auditResult(status),
+		SourceIP:   s.remote,
+		UserAgent:  s.client,
+		DurationMS: time.Since(start).Milliseconds(),
+	})
+}
+
+// stat describes a file or folder.
+func (s *sftpSession) stat(name string) (os.FileInfo, error) {
+	if err := s.authorize("sftpList", name, false); err != nil {
+		return nil, err
+	}
+	return s.fs.Stat(s.ctx, name)
+}
+
+// list is a folder's subfolders and the files in it the session can read.
+func (s *sftpSession) list(name string) ([]os.FileInfo, error) {
+	if err := s.authorize("sftpList", name, true); err != nil {
+		return nil, err
+	}
+	f, err := s.fs.OpenFile(s.ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdir(-1)
+}
+
+// open reads a file for download. SFTP reads at offsets, often several at
+// once, so the whole file is fetched first.
+func (s *sftpSession) open(name string) (io.ReaderAt, error) {
+	if err := s.authorize("sftpRead", name, false); err != nil {
+		return nil, err
+	}
+	f, err := s.fs.OpenFile(s.ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err != nil || info.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrInvalid}
+	}
+	content, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(content), nil
+}
+
+// create opens a file for upload. It is stored when the client closes it.
+func (s *sftpSession) create(name string) (*sftpWriter, error) {
+	start := time.Now()
+	err := s.authorize("sftpWrite", name, false)
+	if err == nil {
+		err = s.checkFrozen(name)
+	}
+	var f webdav.File
+	if err == nil {
+		f, err = s.fs.OpenFile(s.ctx, name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0)
+	}
+	if err != nil {
+		s.audit(start, "sftpWrite", "PUT", name, err)
+		return nil, err
+	}
+	limit, _ := objectSizeLimit(namespaceFrom(s.ctx).Tenant, false)
+	return &sftpWriter{s: s, name: name, file: f, start: start, limit: limit}, nil
+}
+
+func (s *sftpSession) mkdir(name string) error {
+	return s.change("sftpWrite", "MKDIR", []string{name}, true, func() error {
+		return s.fs.Mkdir(s.ctx, name, 0)
+	})
+}
+
+// remove deletes a file, or a folder if dir is set, which must be empty as
+// SFTP clients expect; they delete a tree from the bottom up.
+func (s *sftpSession) remove(name string, dir bool) error {
+	method := "REMOVE"
+	if dir {
+		method = "RMDIR"
+	}
+	return s.change("sftpRemove", method, []string{name}, dir, func() error {
+		info, err := s.fs.Stat(s.ctx, name)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() != dir {
+			return &os.PathError{Op: strings.ToLower(method), Path: name, Err: os.ErrInvalid}
+		}
+		if dir {
+			clean, err := davName(name)
+			if err != nil {
+				return err
+			}
+			ns := namespaceFrom(s.ctx)
+			keys, err := listPrefix(s.ctx, ns.key(clean+"/"))
+			if err != nil {
+				return err
+			}
+			for _, key := range keys {
+				if key != ns.key(clean+"/") {
+					return &os.PathError{Op: "rmdir", Path: name, Err: syscall.ENOTEMPTY}
+				}
+			}
+		}
+		return s.fs.RemoveAll(s.ctx, name)
+	})
+}
+
+// rename moves a file or folder. Only a POSIX rename may replace what is
+// at newName.
+func (s *sftpSession) rename(oldName, newName string, replace bool) error {
+	return s.change("sftpRename", "RENAME", []string{oldName, newName}, false, func() error {
+		if err := s.authorize("sftpRename", newName, false); err != nil {
+			return err
+		}
+		if _, err := s.fs.Stat(s.ctx, newName); err == nil && !replace {
+			return &os.PathError{Op: "rename", Path: newName, Err: os.ErrExist}
+		}
+		return s.fs.Rename(s.ctx, oldName, newName)
+	})
+}
+
+// sftpWriter buffers an upload, whose chunks may arrive out of order, and
+// hands it to the WebDAV writer to store on Close.
+type sftpWriter struct {
+	s     *sftpSession
+	name  string
+	file  webdav.File
+	start time.Time
+	limit int64
+
+	mu      sync.Mutex
+	buf     []byte
+	aborted bool
+	closed  bool
+}
+
+func (w *sftpWriter) WriteAt(p []byte, off int64) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	end := off + int64(len(p))
+	if w.limit > 0 && end > w.limit {
+		return 0, fmt.Errorf("files may be at most %d bytes", w.limit)
+	}
+	if end > int64(len(w.buf)) {
+		w.buf = append(w.buf, make([]byte, end-int64(len(w.buf)))...)
+	}
+	return copy(w.buf[off:], p), nil
+}
+
+// TransferError is called by the sftp package when the connection drops
+// with the file open, so the part that arrived isn't stored.
+func (w *sftpWriter) TransferError(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.aborted = true
+}
+
+func (w *sftpWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed || w.aborted {
+		return nil
+	}
+	w.closed = true
+	_, err := w.file.Write(w.buf)
+	if err == nil {
+		err = w.file.Close()
+	}
+	w.s.audit(w.start, "sftpWrite", "PUT", w.name, err)
+	return err
+}
@@ -0,0 +1,172 @@
+//go:build sftp
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+var _ = registerSFTP(serveSSH)
+
+// serveSSH accepts SSH connections that log in with a password and open
+// the sftp subsystem. Shells, port forwarding and public keys are refused.
+func serveSSH(ctx context.Context, listener net.Listener, hostKey []byte) error {
+	signer, err := ssh.ParsePrivateKey(hostKey)
+	if err != nil {
+		return err
+	}
+
+	// A login's session is picked up once its handshake completes
+	var logins sync.Map
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			s, err := sftpLogin(ctx, conn.User(), string(password), conn.RemoteAddr().String(), string(conn.ClientVersion()))
+			if err != nil {
+				return nil, errors.New("invalid credentials")
+			}
+			logins.Store(string(conn.SessionID()), s)
+			return &ssh.Permissions{}, nil
+		},
+	}
+	config.AddHostKey(signer)
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			server, channels, requests, err := ssh.NewServerConn(conn, config)
+			if err != nil {
+				slog.Debug("SSH handshake failed", "remote", conn.RemoteAddr().String(), "err", err)
+				return
+			}
+			defer server.Close()
+			login, ok := logins.LoadAndDelete(string(server.SessionID()))
+			if !ok {
+				return
+			}
+			go ssh.DiscardRequests(requests)
+			serveSSHChannels(login.(*sftpSession), channels)
+		}()
+	}
+}
+
+func serveSSHChannels(s *sftpSession, channels <-chan ssh.NewChannel) {
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sftp sessions are served")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			slog.WarnContext(s.ctx, "Failed to accept SSH channel", "err", err)
+			continue
+		}
+		go func() {
+			for req := range requests {
+				// The payload is the subsystem's name, after its length
+				req.Reply(req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp", nil)
+			}
+		}()
+		go func() {
+			defer channel.Close()
+			handler := sftpHandler{s}
+			server := sftp.NewRequestServer(channel, sftp.Handlers{
+				FileGet:  handler,
+				FilePut:  handler,
+				FileCmd:  handler,
+				FileList: handler,
+			})
+			if err := server.Serve(); err != nil && !errors.Is(err, io.EOF) {
+				slog.WarnContext(s.ctx, "SFTP session ended", "err", err)
+			}
+			server.Close()
+		}()
+	}
+}
+
+// sftpHandler maps the sftp package's requests to the session's methods.
+type sftpHandler struct {
+	s *sftpSession
+}
+
+func (h sftpHandler) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	return h.s.open(r.Filepath)
+}
+
+func (h sftpHandler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	w, err := h.s.create(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (h sftpHandler) Filecmd(r *sftp.Request) error {
+	switch r.Method {
+	case "Setstat":
+		// Clients set times and modes after an upload; objects keep their own
+		return nil
+	case "Rename":
+		return h.s.rename(r.Filepath, r.Target, false)
+	case "PosixRename":
+		return h.s.rename(r.Filepath, r.Target, true)
+	case "Mkdir":
+		return h.s.mkdir(r.Filepath)
+	case "Remove":
+		return h.s.remove(r.Filepath, false)
+	case "Rmdir":
+		return h.s.remove(r.Filepath, true)
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+func (h sftpHandler) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	switch r.Method {
+	case "List":
+		entries, err := h.s.list(r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		return sftpEntries(entries), nil
+	case "Stat", "Lstat":
+		info, err := h.s.stat(r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		return sftpEntries{info}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+// sftpEntries pages a listing out to the sftp package.
+type sftpEntries []os.FileInfo
+
+func (l sftpEntries) ListAt(entries []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(entries, l[offset:])
+	if n < len(entries) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestSFTPSession(t *testing.T) {
+	srv, _ := newTestServer(t)
+	override(t, &apiKeys, map[string]string{"acme-key": "acme"})
+	override(t, &auditSinks, []auditSink{s3AuditSink{}})
+	ctx := context.Background()
+	acme := []string{"X-API-Key", "acme-key"}
+
+	if _, err := sftpLogin(ctx, "acme", "wrong-key", "203.0.113.9:50022", "SSH-2.0-OpenSSH_9.6"); err == nil {
+		t.Fatal("logged in with a wrong key")
+	}
+	s, err := sftpLogin(ctx, "acme", "acme-key", "203.0.113.9:50022", "SSH-2.0-OpenSSH_9.6")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.mkdir("/inbox"); err != nil {
+		t.Fatal(err)
+	}
+	// Chunks may arrive out of order
+	w, err := s.create("/inbox/orders.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteAt([]byte("3,4\n"), 4)
+	w.WriteAt([]byte("1,2\n"), 0)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := call(t, srv, "GET", "/api/files/inbox/orders.csv", nil, acme...); string(got.body) != "1,2\n3,4\n" {
+		t.Errorf("file uploaded over SFTP has %q", got.body)
+	}
+
+	r, err := s.open("/inbox/orders.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part := make([]byte, 3)
+	if _, err := r.ReadAt(part, 4); err != nil || string(part) != "3,4" {
+		t.Errorf("read %q, %v", part, err)
+	}
+	entries, err := s.list("/inbox")
+	if err != nil || len(entries) != 1 || entries[0].Name() != "orders.csv" || entries[0].Size() != 8 {
+		t.Errorf("listing %v, %v", entries, err)
+	}
+
+	if err := s.remove("/inbox", true); err == nil {
+		t.Error("removed a folder with a file in it")
+	}
+	if err := s.rename("/inbox/orders.csv", "/inbox/done.csv", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.stat("/inbox/orders.csv"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("renamed file still stats: %v", err)
+	}
+	if err := s.remove("/inbox/done.csv", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.remove("/inbox", true); err != nil {
+		t.Errorf("removing the emptied folder: %v", err)
+	}
+
+	var log AuditResponse
+	call(t, srv, "GET", "/api/audit", nil, acme...).decode(t, &log)
+	if len(log.Records) != 6 {
+		t.Fatalf("audit log %+v", log.Records)
+	}
+	if refused := log.Records[3]; refused.Method != "RMDIR" || refused.Status != http.StatusConflict {
+		t.Errorf("refused rmdir record %+v", refused)
+	}
+	if put := log.Records[4]; put.Action != "sftpWrite" || put.Method != "PUT" || put.Key != "inbox/orders.csv" ||
+		put.Actor != "acme" || put.SourceIP != "203.0.113.9" || put.UserAgent != "SSH-2.0-OpenSSH_9.6" {
+		t.Errorf("upload record %+v", put)
+	}
+}
+
+func TestSFTPSessionRefusals(t *testing.T) {
+	srv, _ := newTestServer(t)
+	override(t, &apiKeys, map[string]string{"acme-key": "acme"})
+	override(t, &adminToken, "admin-secret")
+	admin := []string{"Authorization", "Bearer admin-secret"}
+	s, err := sftpLogin(context.Background(), "acme", "acme-key", "203.0.113.9:50022", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectStatus(t, call(t, srv, "POST", "/api/admin/freezes", FreezeRequest{Prefix: "releases/", Reason: "Release window"}, admin...), http.StatusCreated)
+	if _, err := s.create("/releases/app.tar.gz"); !errors.Is(err, errFrozen) {
+		t.Errorf("upload under a freeze: %v", err)
+	}
+	if err := s.mkdir("/releases"); !errors.Is(err, errFrozen) {
+		t.Errorf("folder under a freeze: %v", err)
+	}
+
+	override(t, &maxObjectBytes, int64(4))
+	w, err := s.create("/big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.WriteAt([]byte("too large"), 0); err == nil {
+		t.Error("wrote past the size limit")
+	}
+
+	// A dropped connection doesn't store what arrived
+	w, err = s.create("/partial.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteAt([]byte("par"), 0)
+	w.TransferError(io.ErrUnexpectedEOF)
+	w.Close()
+	if _, err := s.stat("/partial.bin"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("interrupted upload was stored: %v", err)
+	}
+
+	store := &policyStore{}
+	override(t, &policies, store)
+	override(t, &authzPolicy, policyEngine(store))
+	expectStatus(t, call(t, srv, "POST", "/api/admin/policies", PolicyRule{Subject: "*", Action: "sftpList", Resource: "*"}, admin...), http.StatusCreated)
+	if _, err := s.list("/"); err != nil {
+		t.Errorf("listing allowed by policy: %v", err)
+	}
+	if _, err := s.create("/notes.txt"); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("upload not allowed by policy: %v", err)
+	}
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	shadowQueueSize     = 1000
+	shadowMaxMismatches = 50
+
+	// Marks a mirrored request so a shadow running this code doesn't mirror
+	// it again
+	shadowHeader = "X-Shadow-Request"
+)
+
+var (
+	shadowTimeout = durationFromEnv("SHADOW_TIMEOUT", 10*time.Second)
+	// Responses up to this size are kept for comparing JSON structurally;
+	// larger ones are compared by hash
+	shadowMaxBody = int64(intFromEnv("SHADOW_MAX_BODY_BYTES", 1<<20))
+)
+
+type shadowJob struct {
+	method     string
+	path       string
+	header     http.Header
+	status     int
+	body       []byte
+	truncated  bool
+	size       int64
+	sum        []byte
+	observedAt time.Time
+}
+
+type ShadowMismatch struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+	At     string `json:"at"`
+}
+
+type ShadowStatus struct {
+	Enabled    bool             `json:"enabled"`
+	Target     string           `json:"target,omitempty"`
+	SampleRate float64          `json:"sample_rate"`
+	Pending    int              `json:"pending"`
+	Mirrored   int64            `json:"mirrored"`
+	Matched    int64            `json:"matched"`
+	Mismatched int64            `json:"mismatched"`
+	Failed     int64            `json:"failed"`
+	Dropped    int64            `json:"dropped"`
+	Mismatches []ShadowMismatch `json:"recent_mismatches"`
+}
+
+// shadowMirror replays a sample of read requests against a second deployment,
+// e.g. one in front of a bucket being migrated to, and compares its answers
+// with the ones already sent to the client.
+type shadowMirror struct {
+	client       *http.Client
+	target       *url.URL
+	sampleRate   float64
+	ignoreFields map[string]bool
+	queue        chan shadowJob
+
+	mu         sync.Mutex
+	inFlight   int
+	mirrored   int64
+	matched    int64
+	mismatched int64
+	failed     int64
+	dropped    int64
+	mismatches []ShadowMismatch
+}
+
+var trafficShadow *shadowMirror
+
+// startShadow enables mirroring when SHADOW_URL is set.
+func startShadow(ctx context.Context) error {
+	target := os.Getenv("SHADOW_URL")
+	if target == "" {
+		return nil
+	}
+	u, err := url.Parse(strings.TrimSuffix(target, "/"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("SHADOW_URL must be an absolute http(s) URL, got %q", target)
+	}
+
+	rate := 0.01
+	if v := os.Getenv("SHADOW_SAMPLE_RATE"); v != "" {
+		if rate, err = strconv.ParseFloat(v, 64); err != nil || rate < 0 || rate > 1 {
+			return fmt.Errorf("SHADOW_SAMPLE_RATE must be between 0 and 1, got %q", v)
+		}
+	}
+
+	shadow := newShadowMirror(u, rate, os.Getenv("SHADOW_IGNORE_FIELDS"))
+	for i := 0; i < intFromEnv("SHADOW_WORKERS", 2); i++ {
+		go shadow.run(ctx)
+	}
+
+	trafficShadow = shadow
+	slog.Info("Mirroring reads", "rate", rate, "target", u.Redacted())
+	return nil
+}
+
+// newShadowMirror takes the fields to ignore as a comma separated list.
+func newShadowMirror(target *url.URL, rate float64, ignoreFields string) *shadowMirror {
+	// Each deployment assigns its own request IDs
+	ignore := map[string]bool{"request_id": true}
+	for _, field := range strings.Split(ignoreFields, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			ignore[field] = true
+		}
+	}
+	return &shadowMirror{
+		client: &http.Client{
+			Timeout: shadowTimeout,
+			// A redirect is an answer to compare, not one to follow
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		target:       target,
+		sampleRate:   rate,
+		ignoreFields: ignore,
+		queue:        make(chan shadowJob, shadowQueueSize),
+	}
+}
+
+// shadowRecorder keeps what the comparison needs of a response on its way to
+// the client: the status, a hash of the whole body and, up to a limit, the
+// body itself.
+type shadowRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int64
+	sum    hash.Hash
+	body   *cappedBuffer
+}
+
+func (rec *shadowRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *shadowRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.size += int64(len(b))
+	rec.sum.Write(b)
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *shadowRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// shadowReads mirrors a sample of GET and HEAD requests once they have been
+// answered. The client's response is never delayed or changed by the shadow.
+func shadowReads(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		shadow := trafficShadow
+		if shadow == nil || (r.Method != http.MethodGet && r.Method != http.MethodHead) ||
+			r.Header.Get(shadowHeader) != "" || rand.Float64() >= shadow.sampleRate {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &shadowRecorder{ResponseWriter: w, sum: sha256.New(), body: &cappedBuffer{max: shadowMaxBody}}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		shadow.enqueue(shadowJob{
+			method:     r.Method,
+			path:       r.URL.RequestURI(),
+			header:     r.Header.Clone(),
+			status:     rec.status,
+			body:       rec.body.Bytes(),
+			truncated:  rec.body.truncated,
+			size:       rec.size,
+			sum:        rec.sum.Sum(nil),
+			observedAt: time.Now(),
+		})
+	})
+}
+
+func (s *shadowMirror) enqueue(job shadowJob) {
+	select {
+	case s.queue <- job:
+	default:
+		s.mu.Lock()
+		s.dropped++
+		s.mu.Unlock()
+	}
+}
+
+func (s *shadowMirror) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-s.queue:
+			s.process(ctx, job)
+		}
+	}
+}
+
+func (s *shadowMirror) process(ctx context.Context, job shadowJob) {
+	s.mu.Lock()
+	s.inFlight++
+	s.mu.Unlock()
+
+	reason, err := s.mirror(ctx, job)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inFlight--
+	s.mirrored++
+
+	switch {
+	case err != nil:
+		s.failed++
+		s.recordMismatch(job, "shadow request failed: "+err.Error())
+	case reason != "":
+		s.mismatched++
+		s.recordMismatch(job, reason)
+		slog.Warn("Shadow mismatch", "method", job.method, "path", job.path, "reason", reason)
+	default:
+		s.matched++
+	}
+}
+
+// mirror sends the request to the shadow with the client's own headers, so
+// the shadow must accept the same credentials, and describes how its answer
+// differs.
+func (s *shadowMirror) mirror(ctx context.Context, job shadowJob) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, job.method, s.target.String()+job.path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header = job.header
+	req.Header.Set(shadowHeader, "1")
+	// Ask for the encoding the client got, and stop the transport from
+	// asking for gzip and decoding it when the client asked for none
+	if req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", "identity")
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	sum := sha256.New()
+	body := &cappedBuffer{max: shadowMaxBody}
+	size, err := io.Copy(io.MultiWriter(sum, body), resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != job.status {
+		return fmt.Sprintf("status %d, shadow %d", job.status, resp.StatusCode), nil
+	}
+	if !job.truncated && !body.truncated && strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		return s.compareJSON(job.body, body.Bytes()), nil
+	}
+	if size != job.size || !bytes.Equal(sum.Sum(nil), job.sum) {
+		return fmt.Sprintf("body of %d bytes, shadow %d bytes with a different hash", job.size, size), nil
+	}
+	return "", nil
+}
+
+// compareJSON compares bodies as values, so key order and whitespace don't
+// count, leaving out SHADOW_IGNORE_FIELDS at any depth, e.g. timestamps that
+// differ between backends by design.
+func (s *shadowMirror) compareJSON(primary, shadow []byte) string {
+	var a, b interface{}
+	if json.Unmarshal(primary, &a) != nil || json.Unmarshal(shadow, &b) != nil {
+		if !bytes.Equal(primary, shadow) {
+			return "bodies differ"
+		}
+		return ""
+	}
+	if !reflect.DeepEqual(s.strip(a), s.strip(b)) {
+		return "JSON bodies differ"
+	}
+	return ""
+}
+
+func (s *shadowMirror) strip(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, field := range v {
+			if s.ignoreFields[k] {
+				delete(v, k)
+				continue
+			}
+			v[k] = s.strip(field)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = s.strip(v[i])
+		}
+	}
+	return v
+}
+
+// recordMismatch must be called with s.mu held.
+func (s *shadowMirror) recordMismatch(job shadowJob, reason string) {
+	s.mismatches = append(s.mismatches, ShadowMismatch{
+		Method: job.method,
+		Path:   job.path,
+		Reason: reason,
+		At:     job.observedAt.UTC().Format(time.RFC3339),
+	})
+	if len(s.mismatches) > shadowMaxMismatches {
+		s.mismatches = s.mismatches[len(s.mismatches)-shadowMaxMismatches:]
+	}
+}
+
+func (s *shadowMirror) status() ShadowStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return ShadowStatus{
+		Enabled:    true,
+		Target:     s.target.Redacted(),
+		SampleRate: s.sampleRate,
+		Pending:    len(s.queue) + s.inFlight,
+		Mirrored:   s.mirrored,
+		Matched:    s.matched,
+		Mismatched: s.mismatched,
+		Failed:     s.failed,
+		Dropped:    s.dropped,
+		Mismatches: append([]ShadowMismatch{}, s.mismatches...),
+	}
+}
+
+func shadowStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if trafficShadow == nil {
+		respondJSON(w, http.StatusOK, ShadowStatus{Mismatches: []ShadowMismatch{}})
+		return
+	}
+	respondJSON(w, http.StatusOK, trafficShadow.status())
+}
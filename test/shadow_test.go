@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startTestShadow mirrors every read to handler until the test ends.
+func startTestShadow(t *testing.T, handler http.Handler, ignoreFields string) *shadowMirror {
+	t.Helper()
+	target := httptest.NewServer(handler)
+	t.Cleanup(target.Close)
+	u, _ := url.Parse(target.URL)
+
+	shadow := newShadowMirror(u, 1, ignoreFields)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go shadow.run(ctx)
+	override(t, &trafficShadow, shadow)
+	return shadow
+}
+
+// waitMirrored waits for n requests to have been compared.
+func waitMirrored(t *testing.T, shadow *shadowMirror, n int64) ShadowStatus {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status := shadow.status()
+		if status.Mirrored >= n && status.Pending == 0 {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("mirrored %d of %d requests", status.Mirrored, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestShadowMatchingBackend(t *testing.T) {
+	srv, _ := newTestServer(t)
+	mustUpload(t, srv, "/api", "report.txt", "quarterly numbers")
+	// The shadow serves the same storage, so every answer matches
+	shadow := startTestShadow(t, buildRouter(apiRoutes()), "")
+
+	expectStatus(t, call(t, srv, "GET", "/api/files/report.txt", nil), http.StatusOK)
+	expectStatus(t, call(t, srv, "GET", "/api/files", nil), http.StatusOK)
+	expectStatus(t, call(t, srv, "GET", "/api/files/missing.txt", nil), http.StatusNotFound)
+	// Writes are never mirrored
+	mustUpload(t, srv, "/api", "other.txt", "x")
+
+	status := waitMirrored(t, shadow, 3)
+	if status.Mirrored != 3 || status.Matched != 3 || status.Mismatched+status.Failed != 0 {
+		t.Fatalf("status %+v", status)
+	}
+}
+
+func TestShadowMismatch(t *testing.T) {
+	srv, _ := newTestServer(t)
+	mustUpload(t, srv, "/api", "report.txt", "quarterly numbers")
+	shadow := startTestShadow(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(shadowHeader) == "" {
+			t.Errorf("mirrored request is not marked")
+		}
+		if strings.HasSuffix(r.URL.Path, "/metadata") {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"filename": "elsewhere.txt"}`))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}), "")
+
+	expectStatus(t, call(t, srv, "GET", "/api/files/report.txt", nil), http.StatusOK)
+	expectStatus(t, call(t, srv, "GET", "/api/files/report.txt/metadata", nil), http.StatusOK)
+
+	status := waitMirrored(t, shadow, 2)
+	if status.Mismatched != 2 || len(status.Mismatches) != 2 {
+		t.Fatalf("status %+v", status)
+	}
+	reasons := map[string]string{}
+	for _, m := range status.Mismatches {
+		reasons[m.Path] = m.Reason
+	}
+	if got := reasons["/api/files/report.txt"]; got != "status 200, shadow 404" {
+		t.Errorf("download mismatch %q", got)
+	}
+	if got := reasons["/api/files/report.txt/metadata"]; got != "JSON bodies differ" {
+		t.Errorf("metadata mismatch %q", got)
+	}
+}
+
+func TestShadowIgnoreFields(t *testing.T) {
+	shadow := newShadowMirror(&url.URL{}, 1, "last_modified, etag")
+	primary := `{"files": [{"name": "a", "last_modified": "2024-01-01T00:00:00Z", "etag": "1"}]}`
+	mirrored := `{"files":[{"etag":"2","name":"a","last_modified":"2025-06-01T00:00:00Z"}]}`
+	if reason := shadow.compareJSON([]byte(primary), []byte(mirrored)); reason != "" {
+		t.Fatalf("ignored fields compared: %s", reason)
+	}
+	if reason := shadow.compareJSON([]byte(primary), []byte(`{"files": []}`)); reason == "" {
+		t.Fatal("different listings matched")
+	}
+}
+
+func TestShadowStatusDisabled(t *testing.T) {
+	srv, _ := newTestServer(t)
+	override(t, &adminToken, "admin-secret")
+	var status ShadowStatus
+	call(t, srv, "GET", "/api/admin/shadow/status", nil, "Authorization", "Bearer admin-secret").decode(t, &status)
+	if status.Enabled || status.Mismatches == nil {
+		t.Fatalf("status %+v", status)
+	}
+}
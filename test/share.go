@@ -0,0 +1,471 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/gorilla/mux"
+)
+
+// Share records live at the root of the files bucket whichever bucket and
+// tenant the shared file belongs to, since share downloads carry no
+// credentials to resolve a namespace from. Records are named by a hash of the
+// token so a bucket listing doesn't reveal working links.
+const sharePrefix = ".shares/"
+
+var (
+	shareDefaultTTL = durationFromEnv("SHARE_DEFAULT_TTL", 24*time.Hour)
+	shareMaxTTL     = durationFromEnv("SHARE_MAX_TTL", 7*24*time.Hour)
+)
+
+// Attempts at counting a download before giving up on concurrent ones
+const shareCountAttempts = 5
+
+// PBKDF2 rounds for share passwords. Each download of a protected link pays
+// for one hash, so this trades brute-force cost against download latency.
+var sharePasswordIterations = intFromEnv("SHARE_PASSWORD_ITERATIONS", 210000)
+
+// Header a client sends a share password in, instead of posting the form
+const sharePasswordHeader = "X-Share-Password"
+
+var (
+	errShareNotFound         = errors.New("share link not found")
+	errShareExpired          = errors.New("share link has expired")
+	errShareExhausted        = errors.New("share link has no downloads left")
+	errSharePasswordRequired = errors.New("share link requires a password")
+	errSharePasswordWrong    = errors.New("incorrect password")
+)
+
+type ShareRequest struct {
+	ExpiresIn    string `json:"expires_in,omitempty"`
+	MaxDownloads int    `json:"max_downloads,omitempty"`
+	Password     string `json:"password,omitempty"`
+}
+
+type ShareResponse struct {
+	Token             string `json:"token"`
+	URL               string `json:"url"`
+	Filename          string `json:"filename"`
+	ExpiresAt         string `json:"expires_at"`
+	MaxDownloads      int    `json:"max_downloads,omitempty"`
+	PasswordProtected bool   `json:"password_protected,omitempty"`
+}
+
+// SharePasswordForm is what the password page posts.
+type SharePasswordForm struct {
+	Password string `json:"password"`
+}
+
+// shareRecord is what a token grants. A MaxDownloads of 0 means unlimited.
+type shareRecord struct {
+	Bucket       string    `json:"bucket"`
+	Key          string    `json:"key"`
+	Filename     string    `json:"filename"`
+	CreatedBy    string    `json:"created_by,omitempty"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	MaxDownloads int       `json:"max_downloads,omitempty"`
+	Downloads    int       `json:"downloads"`
+	PasswordHash string    `json:"password_hash,omitempty"`
+}
+
+// pbkdf2SHA256 derives a 32-byte key, per RFC 8018 with HMAC-SHA256. One
+// block is all a 32-byte key needs.
+func pbkdf2SHA256(password, salt []byte, iterations int) []byte {
+	mac := hmac.New(sha256.New, password)
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+	key := append([]byte(nil), u...)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
+
+// hashSharePassword returns pbkdf2-sha256$<iterations>$<salt>$<key>, so the
+// iteration count can be raised without breaking existing links.
+func hashSharePassword(password string) string {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		panic(err)
+	}
+	key := pbkdf2SHA256([]byte(password), salt, sharePasswordIterations)
+	encode := base64.RawStdEncoding.EncodeToString
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", sharePasswordIterations, encode(salt), encode(key))
+}
+
+func checkSharePassword(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return false
+	}
+	salt, err1 := base64.RawStdEncoding.DecodeString(parts[2])
+	want, err2 := base64.RawStdEncoding.DecodeString(parts[3])
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	return hmac.Equal(pbkdf2SHA256([]byte(password), salt, iterations), want)
+}
+
+// validShareToken accepts only tokens shaped like randomToken's, so anything
+// else is rejected before it costs a storage call. The decoder skips line
+// breaks, hence the re-encoding check.
+func validShareToken(token string) bool {
+	b, err := base64.RawURLEncoding.Strict().DecodeString(token)
+	return err == nil && len(b) == 32 && base64.RawURLEncoding.EncodeToString(b) == token
+}
+
+func shareRecordKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return sharePrefix + hex.EncodeToString(sum[:])
+}
+
+func putShareRecord(ctx context.Context, token string, rec shareRecord, etag *string) error {
+	body, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(bucketName),
+		Key:         aws.String(shareRecordKey(token)),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+		IfMatch:     etag,
+	}
+	if etag == nil {
+		input.IfNoneMatch = aws.String("*")
+	}
+	_, err = s3Client.PutObject(ctx, input)
+	return err
+}
+
+func loadShareRecord(ctx context.Context, token string) (shareRecord, string, error) {
+	result, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(shareRecordKey(token)),
+	})
+	if isNotFound(err) {
+		return shareRecord{}, "", errShareNotFound
+	}
+	if err != nil {
+		return shareRecord{}, "", err
+	}
+	defer result.Body.Close()
+
+	var rec shareRecord
+	if err := json.NewDecoder(result.Body).Decode(&rec); err != nil {
+		return shareRecord{}, "", fmt.Errorf("decoding share record: %w", err)
+	}
+	return rec, aws.ToString(result.ETag), nil
+}
+
+func deleteShareRecord(ctx context.Context, token string) {
+	if _, err := s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(shareRecordKey(token)),
+	}); err != nil {
+		slog.WarnContext(ctx, "Failed to remove share record", "err", err)
+	}
+}
+
+func isPreconditionFailed(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "PreconditionFailed"
+}
+
+// claimShareDownload checks the password and counts one download against the
+// token's record, and returns the record. The count is written conditionally
+// on the record not having changed, so concurrent downloads can't overrun the
+// limit.
+func claimShareDownload(ctx context.Context, token, password string) (shareRecord, error) {
+	checked := false
+	for attempt := 0; attempt < shareCountAttempts; attempt++ {
+		rec, etag, err := loadShareRecord(ctx, token)
+		if err != nil {
+			return shareRecord{}, err
+		}
+		if !time.Now().Before(rec.ExpiresAt) {
+			deleteShareRecord(ctx, token)
+			return shareRecord{}, errShareExpired
+		}
+		if rec.PasswordHash != "" && !checked {
+			if password == "" {
+				return shareRecord{}, errSharePasswordRequired
+			}
+			if !checkSharePassword(rec.PasswordHash, password) {
+				return shareRecord{}, errSharePasswordWrong
+			}
+			// The hash is expensive, so retries after a lost race skip it
+			checked = true
+		}
+		if rec.MaxDownloads == 0 {
+			return rec, nil
+		}
+		if rec.Downloads >= rec.MaxDownloads {
+			return shareRecord{}, errShareExhausted
+		}
+
+		rec.Downloads++
+		err = putShareRecord(ctx, token, rec, aws.String(etag))
+		if isPreconditionFailed(err) {
+			continue
+		}
+		return rec, err
+	}
+	return shareRecord{}, fmt.Errorf("share link is busy, try again")
+}
+
+func createShareHandler(w http.ResponseWriter, r *http.Request) {
+	var req ShareRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondInvalidBody(w, err)
+			return
+		}
+	}
+
+	ttl := shareDefaultTTL
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 {
+			respondJSON(w, http.StatusBadRequest, ErrorResponse{
+				Error: "expires_in must be a positive duration such as 24h",
+			})
+			return
+		}
+		ttl = d
+	}
+	if ttl > shareMaxTTL {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error: fmt.Sprintf("expires_in may be at most %s", shareMaxTTL),
+		})
+		return
+	}
+	if req.MaxDownloads < 0 {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error: "max_downloads must not be negative",
+		})
+		return
+	}
+
+	ctx := r.Context()
+	ns := requestNamespace(r)
+	filename := mux.Vars(r)["filename"]
+	key := ns.key(filename)
+	if !authorizeFile(w, r, key, permRead) {
+		return
+	}
+
+	exists, err := objectExists(ctx, key)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to check file",
+			Details: err.Error(),
+		})
+		return
+	}
+	if !exists {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{
+			Error: "File not found",
+		})
+		return
+	}
+
+	token := randomToken()
+	rec := shareRecord{
+		Bucket:       ns.Bucket,
+		Key:          key,
+		Filename:     filename,
+		CreatedBy:    requestSubject(r),
+		ExpiresAt:    time.Now().Add(ttl).UTC(),
+		MaxDownloads: req.MaxDownloads,
+	}
+	if req.Password != "" {
+		rec.PasswordHash = hashSharePassword(req.Password)
+	}
+	if err := putShareRecord(ctx, token, rec, nil); err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to create share link",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	recordEvent(ctx, eventShared, key, map[string]string{
+		"expires_at":    rec.ExpiresAt.Format(time.RFC3339),
+		"max_downloads": strconv.Itoa(rec.MaxDownloads),
+		"password":      strconv.FormatBool(rec.PasswordHash != ""),
+	})
+	respondJSON(w, http.StatusCreated, ShareResponse{
+		Token:             token,
+		URL:               "/api/share/" + token,
+		Filename:          filename,
+		ExpiresAt:         rec.ExpiresAt.Format(time.RFC3339),
+		MaxDownloads:      rec.MaxDownloads,
+		PasswordProtected: rec.PasswordHash != "",
+	})
+}
+
+// shareDownloadHandler streams a shared file to anyone holding the token.
+// Links with a password take it in the X-Share-Password header; browsers are
+// shown a form that posts it to shareUnlockHandler instead.
+func shareDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	serveShare(w, r, r.Header.Get(sharePasswordHeader))
+}
+
+// shareUnlockHandler takes the password from the share page's form.
+func shareUnlockHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 4096)
+	if err := r.ParseForm(); err != nil {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid form",
+			Details: err.Error(),
+		})
+		return
+	}
+	serveShare(w, r, r.PostForm.Get("password"))
+}
+
+var sharePasswordPage = template.Must(template.New("share").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Protected file</title></head>
+<body>
+<form method="post">
+<p>This file is protected. Enter the password to download it.</p>
+{{if .}}<p role="alert">{{.}}</p>{{end}}
+<input type="password" name="password" autofocus required>
+<button type="submit">Download</button>
+</form>
+</body>
+</html>
+`))
+
+func wantsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// servePasswordPage answers a protected link opened in a browser with the
+// password form, repeating it with a message after a wrong password.
+func servePasswordPage(w http.ResponseWriter, message string) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusUnauthorized)
+	sharePasswordPage.Execute(w, message)
+}
+
+// serveShare streams the file behind the token. Unknown, expired and used-up
+// links are told apart so a recipient knows whether to ask for a new one.
+func serveShare(w http.ResponseWriter, r *http.Request, password string) {
+	token := mux.Vars(r)["token"]
+	if !validShareToken(token) {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{
+			Error: "Share link not found",
+		})
+		return
+	}
+
+	ctx := r.Context()
+	rec, err := claimShareDownload(ctx, token, password)
+	switch {
+	case errors.Is(err, errSharePasswordRequired), errors.Is(err, errSharePasswordWrong):
+		if wantsHTML(r) {
+			message := ""
+			if errors.Is(err, errSharePasswordWrong) {
+				message = "Incorrect password, try again."
+			}
+			servePasswordPage(w, message)
+			return
+		}
+		respondJSON(w, http.StatusUnauthorized, ErrorResponse{
+			Error:   "Password required",
+			Details: err.Error(),
+		})
+		return
+	case errors.Is(err, errShareNotFound):
+		respondJSON(w, http.StatusNotFound, ErrorResponse{
+			Error: "Share link not found",
+		})
+		return
+	case errors.Is(err, errShareExpired), errors.Is(err, errShareExhausted):
+		respondJSON(w, http.StatusGone, ErrorResponse{
+			Error:   "Share link is no longer valid",
+			Details: err.Error(),
+		})
+		return
+	case err != nil:
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to open share link",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	result, contentEncoding, err := getObjectNegotiated(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(rec.Bucket),
+		Key:    aws.String(rec.Key),
+	}, r.Header.Get("Accept-Encoding"))
+	if err != nil {
+		status, message := http.StatusInternalServerError, "Failed to read file"
+		if isNotFound(err) {
+			// The file went away after it was shared
+			status, message = http.StatusGone, "Shared file no longer exists"
+		}
+		respondJSON(w, status, ErrorResponse{
+			Error:   message,
+			Details: err.Error(),
+		})
+		return
+	}
+	defer result.Body.Close()
+	if err := linkDownloadHooks(ctx, rec.Bucket, rec.Key); err != nil {
+		respondHookError(w, "Download", err)
+		return
+	}
+
+	recordEvent(ctx, eventShareDownloaded, rec.Key, map[string]string{
+		"downloads": strconv.Itoa(rec.Downloads),
+	})
+
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", attachmentDisposition(rec.Filename))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Vary", "Accept-Encoding")
+	if contentEncoding != "" {
+		w.Header().Set("Content-Encoding", contentEncoding)
+	}
+	if result.ContentLength != nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(*result.ContentLength, 10))
+	}
+	if _, err := io.Copy(w, result.Body); err != nil {
+		slog.WarnContext(r.Context(), "Share download interrupted", "key", rec.Key, "err", err)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShareLinks(t *testing.T) {
+	srv, fake := newTestServer(t)
+	mustUpload(t, srv, "/api", "docs/report.txt", "quarterly")
+
+	created := call(t, srv, "POST", "/api/files/docs/report.txt/share", ShareRequest{ExpiresIn: "1h", MaxDownloads: 2})
+	expectStatus(t, created, http.StatusCreated)
+	var share ShareResponse
+	created.decode(t, &share)
+	if share.URL != "/api/share/"+share.Token || share.MaxDownloads != 2 {
+		t.Fatalf("share %+v", share)
+	}
+	for _, key := range fake.Keys(bucketName) {
+		if strings.Contains(key, share.Token) {
+			t.Fatalf("token stored in the clear in %s", key)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		got := call(t, srv, "GET", share.URL, nil)
+		expectStatus(t, got, http.StatusOK)
+		if string(got.body) != "quarterly" || got.Header.Get("Content-Disposition") != "attachment; filename=report.txt" {
+			t.Fatalf("download %d: %q %v", i, got.body, got.Header)
+		}
+	}
+	expectStatus(t, call(t, srv, "GET", share.URL, nil), http.StatusGone)
+
+	var files FilesResponse
+	call(t, srv, "GET", "/api/files", nil).decode(t, &files)
+	if len(files.Files) != 1 {
+		t.Fatalf("share records listed as files: %v", files.Files)
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		body   interface{}
+		status int
+	}{
+		{"missing file", "/api/files/nope.txt/share", nil, http.StatusNotFound},
+		{"bad ttl", "/api/files/docs/report.txt/share", ShareRequest{ExpiresIn: "soon"}, http.StatusBadRequest},
+		{"ttl over max", "/api/files/docs/report.txt/share", ShareRequest{ExpiresIn: "720h"}, http.StatusBadRequest},
+		{"negative downloads", "/api/files/docs/report.txt/share", ShareRequest{MaxDownloads: -1}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expectStatus(t, call(t, srv, "POST", tt.path, tt.body), tt.status)
+		})
+	}
+
+	expectStatus(t, call(t, srv, "GET", "/api/share/"+randomToken(), nil), http.StatusNotFound)
+	expectStatus(t, call(t, srv, "GET", "/api/share/not-a-token", nil), http.StatusNotFound)
+}
+
+func TestShareLinkExpires(t *testing.T) {
+	srv, fake := newTestServer(t)
+	mustUpload(t, srv, "/api", "a.txt", "hello")
+
+	var share ShareResponse
+	call(t, srv, "POST", "/api/files/a.txt/share", nil).decode(t, &share)
+	if _, err := time.Parse(time.RFC3339, share.ExpiresAt); err != nil || share.MaxDownloads != 0 {
+		t.Fatalf("share %+v", share)
+	}
+
+	rec, etag, err := loadShareRecord(context.Background(), share.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.ExpiresAt = time.Now().Add(-time.Second)
+	if err := putShareRecord(context.Background(), share.Token, rec, &etag); err != nil {
+		t.Fatal(err)
+	}
+
+	expectStatus(t, call(t, srv, "GET", share.URL, nil), http.StatusGone)
+	if _, _, ok := fake.Object(bucketName, shareRecordKey(share.Token)); ok {
+		t.Fatal("expired share record left behind")
+	}
+}
+
+func TestShareLinkRequiresReadAccess(t *testing.T) {
+	srv, _ := newTestServer(t)
+	idp := newFakeIdP(t, nil)
+	override(t, &jwtJWKSURL, idp.URL+"/jwks")
+	override(t, &jwtTenantClaim, "tenant")
+	override(t, &aclsEnabled, true)
+	alice, bob := idp.bearer(t, "alice"), idp.bearer(t, "bob")
+
+	mustUpload(t, srv, "/api", "alice.txt", "private", "Authorization", alice)
+	expectStatus(t, call(t, srv, "POST", "/api/files/alice.txt/share", nil, "Authorization", bob), http.StatusForbidden)
+
+	var share ShareResponse
+	call(t, srv, "POST", "/api/files/alice.txt/share", nil, "Authorization", alice).decode(t, &share)
+	if got := call(t, srv, "GET", share.URL, nil); string(got.body) != "private" {
+		t.Fatalf("share download %d %q", got.StatusCode, got.body)
+	}
+}
+
+func TestPasswordProtectedShareLink(t *testing.T) {
+	srv, fake := newTestServer(t)
+	override(t, &sharePasswordIterations, 10)
+	mustUpload(t, srv, "/api", "a.txt", "secret")
+
+	var share ShareResponse
+	call(t, srv, "POST", "/api/files/a.txt/share", ShareRequest{Password: "hunter2", MaxDownloads: 2}).decode(t, &share)
+	if !share.PasswordProtected {
+		t.Fatalf("share %+v", share)
+	}
+	stored, _, _ := fake.Object(bucketName, shareRecordKey(share.Token))
+	if strings.Contains(string(stored), "hunter2") {
+		t.Fatal("password stored in the clear")
+	}
+
+	missing := call(t, srv, "GET", share.URL, nil)
+	expectStatus(t, missing, http.StatusUnauthorized)
+	if missing.errorMessage(t) != "Password required" {
+		t.Fatalf("body %s", missing.body)
+	}
+	expectStatus(t, call(t, srv, "GET", share.URL, nil, sharePasswordHeader, "wrong"), http.StatusUnauthorized)
+
+	page := call(t, srv, "GET", share.URL, nil, "Accept", "text/html")
+	expectStatus(t, page, http.StatusUnauthorized)
+	if !strings.Contains(string(page.body), `name="password"`) {
+		t.Fatalf("page %s", page.body)
+	}
+	form := []string{"Content-Type", "application/x-www-form-urlencoded", "Accept", "text/html"}
+	retry := call(t, srv, "POST", share.URL, "password=wrong", form...)
+	if retry.StatusCode != http.StatusUnauthorized || !strings.Contains(string(retry.body), "Incorrect password") {
+		t.Fatalf("wrong password: %d %s", retry.StatusCode, retry.body)
+	}
+
+	// Failed attempts don't use up downloads
+	if got := call(t, srv, "POST", share.URL, "password=hunter2", form...); string(got.body) != "secret" {
+		t.Fatalf("form download %d %q", got.StatusCode, got.body)
+	}
+	if got := call(t, srv, "GET", share.URL, nil, sharePasswordHeader, "hunter2"); string(got.body) != "secret" {
+		t.Fatalf("header download %d %q", got.StatusCode, got.body)
+	}
+	expectStatus(t, call(t, srv, "GET", share.URL, nil, sharePasswordHeader, "hunter2"), http.StatusGone)
+}
+
+func TestPBKDF2Vectors(t *testing.T) {
+	// The commonly published PBKDF2-HMAC-SHA256 vectors
+	tests := []struct {
+		iterations int
+		want       string
+	}{
+		{1, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{2, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+		{4096, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+	}
+	for _, tt := range tests {
+		if got := hex.EncodeToString(pbkdf2SHA256([]byte("password"), []byte("salt"), tt.iterations)); got != tt.want {
+			t.Errorf("%d iterations: %s", tt.iterations, got)
+		}
+	}
+
+	hash := hashSharePassword("pw")
+	if !checkSharePassword(hash, "pw") || checkSharePassword(hash, "pW") || checkSharePassword("garbage", "pw") {
+		t.Fatal("password check")
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+var (
+	// On SIGTERM or SIGINT in-flight requests get this long to finish before
+	// their connections are closed
+	shutdownTimeout = durationFromEnv("SHUTDOWN_TIMEOUT", 30*time.Second)
+
+	// draining is set once shutdown starts, so /readyz takes the instance out
+	// of rotation while it finishes what it has
+	draining atomic.Bool
+
+	openUploads = &uploadTracker{open: map[string]openUpload{}}
+)
+
+// serve runs the servers until one fails or a signal asks them to stop, then
+// drains them: no new connections are accepted, in-flight requests get
+// shutdownTimeout,
+// durable jobs are handed back for another instance to resume, and
+// multipart uploads left incomplete are aborted so their parts aren't
+// billed forever. A second signal exits at once.
+func serve(servers ...*http.Server) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	served := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func() {
+			if srv.TLSConfig != nil {
+				// The certificates come from the config
+				served <- srv.ListenAndServeTLS("", "")
+				return
+			}
+			served <- srv.ListenAndServe()
+		}()
+	}
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+	stop()
+
+	draining.Store(true)
+	closeEventSubscribers()
+	slog.Info("Shutting down; draining connections", "timeout", shutdownTimeout.String())
+	drainCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	var drained sync.WaitGroup
+	for _, srv := range servers {
+		drained.Add(1)
+		go func() {
+			defer drained.Done()
+			if err := srv.Shutdown(drainCtx); err != nil {
+				slog.Warn("Requests still running at the shutdown deadline; closing their connections", "addr", srv.Addr, "err", err)
+				srv.Close()
+			}
+		}()
+	}
+	drained.Wait()
+
+	abortCtx, cancelAbort := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelAbort()
+	suspendJobs(abortCtx)
+	openUploads.abortAll(abortCtx)
+	slog.Info("API server stopped")
+	return nil
+}
+
+type openUpload struct {
+	bucket string
+	key    string
+}
+
+// uploadTracker remembers multipart uploads this instance started and
+// hasn't completed or aborted, by upload ID.
+type uploadTracker struct {
+	mu   sync.Mutex
+	open map[string]openUpload
+}
+
+func (t *uploadTracker) add(id string, upload openUpload) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.open[id] = upload
+}
+
+func (t *uploadTracker) remove(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.open, id)
+}
+
+func (t *uploadTracker) snapshot() map[string]openUpload {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	open := make(map[string]openUpload, len(t.open))
+	for id, upload := range t.open {
+		open[id] = upload
+	}
+	return open
+}
+
+// abortAll aborts every upload still open, and returns how many it aborted.
+func (t *uploadTracker) abortAll(ctx context.Context) int {
+	aborted := 0
+	for id, upload := range t.snapshot() {
+		_, err := s3Client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(upload.bucket),
+			Key:      aws.String(upload.key),
+			UploadId: aws.String(id),
+		})
+		// Gone already means someone else finished or aborted it
+		var apiErr smithy.APIError
+		if err != nil && !(errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchUpload") {
+			slog.ErrorContext(ctx, "Failed to abort incomplete multipart upload", "bucket", upload.bucket, "key", upload.key, "err", err)
+			continue
+		}
+		t.remove(id)
+		aborted++
+		slog.InfoContext(ctx, "Aborted incomplete multipart upload", "bucket", upload.bucket, "key", upload.key)
+	}
+	return aborted
+}
+
+// trackedS3 records multipart uploads in openUploads as they start and
+// finish.
+type trackedS3 struct {
+	s3API
+	uploads *uploadTracker
+}
+
+func trackUploads(client s3API) s3API {
+	return trackedS3{s3API: client, uploads: openUploads}
+}
+
+func (c trackedS3) CreateMultipartUpload(ctx context.Context, in *s3.CreateMultipartUploadInput, opts ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	out, err := c.s3API.CreateMultipartUpload(ctx, in, opts...)
+	if err == nil {
+		c.uploads.add(aws.ToString(out.UploadId), openUpload{bucket: aws.ToString(in.Bucket), key: aws.ToString(in.Key)})
+	}
+	return out, err
+}
+
+func (c trackedS3) CompleteMultipartUpload(ctx context.Context, in *s3.CompleteMultipartUploadInput, opts ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	out, err := c.s3API.CompleteMultipartUpload(ctx, in, opts...)
+	if err == nil {
+		c.uploads.remove(aws.ToString(in.UploadId))
+	}
+	return out, err
+}
+
+func (c trackedS3) AbortMultipartUpload(ctx context.Context, in *s3.AbortMultipartUploadInput, opts ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	out, err := c.s3API.AbortMultipartUpload(ctx, in, opts...)
+	if err == nil {
+		c.uploads.remove(aws.ToString(in.UploadId))
+	}
+	return out, err
+}
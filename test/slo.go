@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sloOK        = "ok"
+	sloBurning   = "burning"
+	sloExhausted = "exhausted"
+
+	// Share of requests that must meet a budget given without an objective
+	defaultSLOObjective = 99.0
+	// The burn rate is the pace over this many of the latest minutes
+	sloBurnMinutes = 5
+)
+
+var (
+	// Requests are judged over the trailing window, in minute buckets
+	sloWindow = durationFromEnv("LATENCY_SLO_WINDOW", time.Hour)
+	// A route that keeps missing its budget is logged at most this often
+	sloWarnInterval = durationFromEnv("LATENCY_SLO_WARN_INTERVAL", time.Minute)
+
+	latencyBudgets = newSLOTracker(parseLatencySLOs(settings.Limits.LatencySLOs), sloWindow)
+)
+
+// latencySLO is a route's latency budget: objective percent of its requests
+// are to be answered within budget.
+type latencySLO struct {
+	budget    time.Duration
+	objective float64
+}
+
+// parseLatencySLO parses a route's SLO, e.g. "500ms" or "500ms@99.9".
+func parseLatencySLO(value string) (latencySLO, error) {
+	budget, objective, hasObjective := strings.Cut(value, "@")
+	slo := latencySLO{objective: defaultSLOObjective}
+	var err error
+	if slo.budget, err = time.ParseDuration(budget); err != nil || slo.budget <= 0 {
+		return slo, fmt.Errorf("%q is not a positive duration", budget)
+	}
+	if hasObjective {
+		slo.objective, err = strconv.ParseFloat(strings.TrimSuffix(objective, "%"), 64)
+		if err != nil || slo.objective <= 0 || slo.objective >= 100 {
+			return slo, fmt.Errorf("%q is not a percentage above 0 and below 100", objective)
+		}
+	}
+	return slo, nil
+}
+
+func parseLatencySLOs(values map[string]string) map[string]latencySLO {
+	slos := map[string]latencySLO{}
+	for route, value := range values {
+		// Invalid SLOs are reported by main
+		if slo, err := parseLatencySLO(value); err == nil {
+			slos[route] = slo
+		}
+	}
+	return slos
+}
+
+// checkLatencySLORoutes reports SLOs set for routes that don't exist, which
+// would otherwise never be measured.
+func checkLatencySLORoutes(g routeGroup) error {
+	known := map[string]bool{}
+	walkRoutes(g, "", nil, func(rt registeredRoute, _ []middleware) {
+		known[operationID(rt.Handler)] = true
+	})
+	var unknown []string
+	for route := range latencyBudgets.slos {
+		if !known[route] {
+			unknown = append(unknown, route)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("limits.latency_slos (LATENCY_SLOS): no such route: %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+type sloBucket struct {
+	minute      int64
+	total, slow int64
+}
+
+type sloRoute struct {
+	buckets []sloBucket
+	// Misses since the last warning, and when it was logged
+	unwarned int64
+	warnedAt time.Time
+}
+
+// sloTracker counts each budgeted route's requests, and those over budget,
+// in a ring of minute buckets covering the window.
+type sloTracker struct {
+	slos   map[string]latencySLO
+	window time.Duration
+
+	mu     sync.Mutex
+	routes map[string]*sloRoute
+	slow   map[string]float64
+}
+
+func newSLOTracker(slos map[string]latencySLO, window time.Duration) *sloTracker {
+	return &sloTracker{slos: slos, window: window, routes: map[string]*sloRoute{}, slow: map[string]float64{}}
+}
+
+func (t *sloTracker) minutes() int {
+	return max(1, int(t.window/time.Minute))
+}
+
+// observe records a request to route that took elapsed, and logs a warning
+// if it went over budget.
+func (t *sloTracker) observe(route, method string, elapsed time.Duration, now time.Time) {
+	slo, ok := t.slos[route]
+	if !ok {
+		return
+	}
+	missed := elapsed > slo.budget
+
+	t.mu.Lock()
+	r := t.routes[route]
+	if r == nil {
+		r = &sloRoute{buckets: make([]sloBucket, t.minutes())}
+		t.routes[route] = r
+	}
+	minute := now.Unix() / 60
+	b := &r.buckets[minute%int64(len(r.buckets))]
+	if b.minute != minute {
+		*b = sloBucket{minute: minute}
+	}
+	b.total++
+	if !missed {
+		t.mu.Unlock()
+		return
+	}
+	b.slow++
+	t.slow[route]++
+	r.unwarned++
+	var misses int64
+	if now.Sub(r.warnedAt) >= sloWarnInterval {
+		misses, r.unwarned, r.warnedAt = r.unwarned, 0, now
+	}
+	t.mu.Unlock()
+
+	if misses > 0 {
+		status := t.status(route, now)
+		slog.Warn("Request over its latency budget",
+			"route", route, "method", method,
+			"duration_ms", elapsed.Milliseconds(), "budget_ms", slo.budget.Milliseconds(),
+			"over_budget", misses, "burn_rate", status.BurnRate)
+	}
+}
+
+// SLOStatus is how a route's latency budget is holding up.
+type SLOStatus struct {
+	Route      string  `json:"route"`
+	Budget     string  `json:"budget"`
+	Objective  float64 `json:"objective"`
+	Requests   int64   `json:"requests"`
+	OverBudget int64   `json:"over_budget"`
+	// Share of the window's error budget left, below 0 once overspent
+	BudgetRemaining float64 `json:"budget_remaining"`
+	// How fast the error budget was spent over the last few minutes: above
+	// 1, the pace would overspend it
+	BurnRate float64 `json:"burn_rate"`
+	Status   string  `json:"status"`
+}
+
+type SLOStatusResponse struct {
+	Window string      `json:"window"`
+	Routes []SLOStatus `json:"routes"`
+}
+
+// errorBudgetSpent is the share of the allowed misses that were used.
+func errorBudgetSpent(slo latencySLO, requests, slow int64) float64 {
+	if requests == 0 {
+		return 0
+	}
+	return float64(slow) / float64(requests) / (1 - slo.objective/100)
+}
+
+func (t *sloTracker) status(route string, now time.Time) SLOStatus {
+	slo := t.slos[route]
+	s := SLOStatus{Route: route, Budget: slo.budget.String(), Objective: slo.objective, Status: sloOK}
+
+	var recent, recentSlow int64
+	t.mu.Lock()
+	if r := t.routes[route]; r != nil {
+		minute := now.Unix() / 60
+		for _, b := range r.buckets {
+			if b.minute > minute-int64(len(r.buckets)) {
+				s.Requests += b.total
+				s.OverBudget += b.slow
+			}
+			if b.minute > minute-sloBurnMinutes {
+				recent += b.total
+				recentSlow += b.slow
+			}
+		}
+	}
+	t.mu.Unlock()
+
+	s.BudgetRemaining = 1 - errorBudgetSpent(slo, s.Requests, s.OverBudget)
+	s.BurnRate = errorBudgetSpent(slo, recent, recentSlow)
+	switch {
+	case s.BudgetRemaining <= 0:
+		s.Status = sloExhausted
+	case s.BurnRate > 1:
+		s.Status = sloBurning
+	}
+	return s
+}
+
+func (t *sloTracker) report(now time.Time) SLOStatusResponse {
+	resp := SLOStatusResponse{Window: t.window.String(), Routes: []SLOStatus{}}
+	for _, route := range sortedKeys(t.slos) {
+		resp.Routes = append(resp.Routes, t.status(route, now))
+	}
+	return resp
+}
+
+func (t *sloTracker) writeTo(w io.Writer) {
+	now := time.Now()
+	report := t.report(now)
+
+	fmt.Fprintf(w, "# HELP http_request_latency_budget_seconds Latency budget of routes with an SLO.\n# TYPE http_request_latency_budget_seconds gauge\n")
+	for _, route := range sortedKeys(t.slos) {
+		fmt.Fprintf(w, "http_request_latency_budget_seconds%s %s\n", formatLabels([]string{"route"}, route), formatValue(t.slos[route].budget.Seconds()))
+	}
+	t.mu.Lock()
+	fmt.Fprintf(w, "# HELP http_requests_over_budget_total Requests answered slower than their route's latency budget.\n# TYPE http_requests_over_budget_total counter\n")
+	for _, route := range sortedKeys(t.slow) {
+		fmt.Fprintf(w, "http_requests_over_budget_total%s %s\n", formatLabels([]string{"route"}, route), formatValue(t.slow[route]))
+	}
+	t.mu.Unlock()
+	fmt.Fprintf(w, "# HELP latency_slo_burn_rate Pace the latency error budget was spent at over the last five minutes; above 1 overspends it.\n# TYPE latency_slo_burn_rate gauge\n")
+	for _, s := range report.Routes {
+		fmt.Fprintf(w, "latency_slo_burn_rate%s %s\n", formatLabels([]string{"route"}, s.Route), formatValue(s.BurnRate))
+	}
+	fmt.Fprintf(w, "# HELP latency_slo_budget_remaining Share of the latency error budget left over the window.\n# TYPE latency_slo_budget_remaining gauge\n")
+	for _, s := range report.Routes {
+		fmt.Fprintf(w, "latency_slo_budget_remaining%s %s\n", formatLabels([]string{"route"}, s.Route), formatValue(s.BudgetRemaining))
+	}
+}
+
+func latencySLOsHandler(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, latencyBudgets.report(time.Now()))
+}
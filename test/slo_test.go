@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLatencySLOs(t *testing.T) {
+	logs := captureLogs(t)
+	srv, _ := newTestServer(t)
+	override(t, &adminToken, "admin-secret")
+	override(t, &latencyBudgets, newSLOTracker(map[string]latencySLO{
+		"listFiles": {budget: time.Hour, objective: 99},
+		"upload":    {budget: time.Nanosecond, objective: 50},
+	}, time.Hour))
+
+	for range 2 {
+		expectStatus(t, call(t, srv, "POST", "/api/upload", upload("slow.txt", "abc")), http.StatusOK)
+		expectStatus(t, call(t, srv, "GET", "/api/files", nil), http.StatusOK)
+	}
+
+	var status SLOStatusResponse
+	call(t, srv, "GET", "/api/admin/slos", nil, "Authorization", "Bearer admin-secret").decode(t, &status)
+	if status.Window != "1h0m0s" || len(status.Routes) != 2 {
+		t.Fatalf("status %+v", status)
+	}
+	if list := status.Routes[0]; list.Route != "listFiles" || list.Requests != 2 || list.OverBudget != 0 || list.Status != sloOK || list.BudgetRemaining != 1 {
+		t.Errorf("listFiles %+v", list)
+	}
+	if up := status.Routes[1]; up.Requests != 2 || up.OverBudget != 2 || up.Status != sloExhausted || up.BurnRate != 2 {
+		t.Errorf("upload %+v", up)
+	}
+
+	// The second miss is within the warning interval
+	var warnings int
+	for _, entry := range logs.entries(t) {
+		if entry["msg"] == "Request over its latency budget" {
+			warnings++
+			if entry["route"] != "upload" || entry["over_budget"] != float64(1) {
+				t.Errorf("warning %v", entry)
+			}
+		}
+	}
+	if warnings != 1 {
+		t.Errorf("%d warnings, want 1", warnings)
+	}
+
+	var metrics bytes.Buffer
+	latencyBudgets.writeTo(&metrics)
+	samples := scrape(t, metrics.Bytes())
+	for name, want := range map[string]string{
+		`http_request_latency_budget_seconds{route="listFiles"}`: "3600",
+		`http_requests_over_budget_total{route="upload"}`:        "2",
+		`latency_slo_burn_rate{route="upload"}`:                  "2",
+		`latency_slo_budget_remaining{route="listFiles"}`:        "1",
+	} {
+		if samples[name] != want {
+			t.Errorf("%s = %q, want %q", name, samples[name], want)
+		}
+	}
+}
+
+func TestLatencySLOWindow(t *testing.T) {
+	slos := newSLOTracker(map[string]latencySLO{"getFile": {budget: 100 * time.Millisecond, objective: 75}}, 10*time.Minute)
+	start := time.Date(2024, 5, 6, 12, 0, 0, 0, time.UTC)
+
+	// One slow request in four spends the whole budget. Spread over the
+	// window a few aren't a worry, crammed into the last minutes they are
+	for i := range 19 {
+		slos.observe("getFile", "GET", 10*time.Millisecond, start.Add(time.Duration(i)*30*time.Second))
+	}
+	slos.observe("getFile", "GET", time.Second, start)
+	now := start.Add(9 * time.Minute)
+	if s := slos.status("getFile", now); s.Requests != 20 || math.Abs(s.BudgetRemaining-0.8) > 1e-9 || s.Status != sloOK {
+		t.Errorf("status %+v", s)
+	}
+	for range 4 {
+		slos.observe("getFile", "GET", time.Second, now)
+	}
+	if s := slos.status("getFile", now); s.BudgetRemaining <= 0 || s.BurnRate <= 1 || s.Status != sloBurning {
+		t.Errorf("status %+v", s)
+	}
+
+	// Minutes that have left the window no longer count
+	if s := slos.status("getFile", start.Add(20*time.Minute)); s.Requests != 0 || s.BudgetRemaining != 1 {
+		t.Errorf("status after the window %+v", s)
+	}
+}
+
+func TestParseLatencySLO(t *testing.T) {
+	for value, want := range map[string]latencySLO{
+		"500ms":     {budget: 500 * time.Millisecond, objective: 99},
+		"2s@99.9":   {budget: 2 * time.Second, objective: 99.9},
+		"250ms@95%": {budget: 250 * time.Millisecond, objective: 95},
+	} {
+		if got, err := parseLatencySLO(value); err != nil || got != want {
+			t.Errorf("%s: %+v, %v", value, got, err)
+		}
+	}
+	for _, value := range []string{"", "fast", "0s", "1s@100", "1s@0", "1s@high"} {
+		if _, err := parseLatencySLO(value); err == nil {
+			t.Errorf("%q parsed", value)
+		}
+	}
+}
@@ -60,6 +60,27 @@ async function uploadTest() {
     }
 }
 
+// Show who the session cookie or API key belongs to
+async function whoAmI() {
+    try {
+        const response = await fetch(`${API_BASE}/auth/session`);
+        const data = await response.json();
+        displayResult('api-results', data, !response.ok);
+    } catch (error) {
+        displayResult('api-results', { error: error.message }, true);
+    }
+}
+
+// Sign in through the OIDC provider, coming back to this page
+function signIn() {
+    const returnTo = window.location.pathname + window.location.search;
+    window.location.href = `${API_BASE}/auth/login?return_to=${encodeURIComponent(returnTo)}`;
+}
+
+function signOut() {
+    window.location.href = `${API_BASE}/auth/logout`;
+}
+
 // Upload user-selected file
 async function uploadFile() {
     const fileInput = document.getElementById('fileInput');
@@ -0,0 +1,660 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+)
+
+// Status reports live at the root of the files bucket. Like billing meter
+// readings, request counts carry their numbers in their keys, by week and
+// day.
+const (
+	statusReportPrefix        = ".reports/"
+	statusReportCountsPrefix  = statusReportPrefix + "counts/"
+	statusReportQuotaPrefix   = statusReportPrefix + "quota/"
+	statusReportReportsPrefix = statusReportPrefix + "weeks/"
+
+	statusReportDayFormat = "20060102"
+)
+
+var (
+	// A summary of each week, Monday to Sunday in UTC, is mailed to
+	// STATUS_REPORT_EMAIL and posted to the Slack incoming webhook
+	// STATUS_REPORT_SLACK_URL shortly after the week ends
+	statusReportEmail    = splitList(os.Getenv("STATUS_REPORT_EMAIL"))
+	statusReportSlackURL = os.Getenv("STATUS_REPORT_SLACK_URL")
+
+	// How often this instance writes out its request counts and checks
+	// whether last week's report has been sent
+	statusReportFlushInterval = durationFromEnv("STATUS_REPORT_FLUSH_INTERVAL", 5*time.Minute)
+
+	// Audit records read for one report, across tenants; past it the
+	// uploader figures are marked incomplete
+	statusReportAuditLimit = intFromEnv("STATUS_REPORT_AUDIT_LIMIT", 100000)
+
+	statusReportTopUploaders = 10
+
+	statusCounts = newStatusMeter()
+)
+
+// uploadActions are the audited operations that store a file. The WebDAV
+// and SFTP ones only do with the PUT method.
+var uploadActions = map[string]bool{"upload": true, "s3PutObject": true, "webdav": true, "sftpWrite": true}
+
+func statusReportsEnabled() bool {
+	return len(statusReportEmail) > 0 || statusReportSlackURL != ""
+}
+
+// StatusReport summarizes a week of the service for its operators.
+// Storage is as it stood when the report was built, and growth is since
+// the previous week's report.
+type StatusReport struct {
+	Week            string          `json:"week"`
+	From            string          `json:"from"`
+	To              string          `json:"to"`
+	GeneratedAt     string          `json:"generated_at"`
+	Complete        bool            `json:"complete"`
+	Storage         []TenantStorage `json:"storage"`
+	TopUploaders    []UploaderCount `json:"top_uploaders"`
+	QuotaBreaches   []QuotaBreach   `json:"quota_breaches"`
+	WebhookFailures int64           `json:"webhook_failures"`
+	Days            []DayStatus     `json:"days"`
+	Audited         bool            `json:"audited"`
+	AuditTruncated  bool            `json:"audit_truncated,omitempty"`
+}
+
+type TenantStorage struct {
+	Tenant        string `json:"tenant"`
+	Bytes         int64  `json:"bytes"`
+	Objects       int64  `json:"objects"`
+	BytesGrowth   *int64 `json:"bytes_growth,omitempty"`
+	ObjectsGrowth *int64 `json:"objects_growth,omitempty"`
+}
+
+type UploaderCount struct {
+	Tenant  string `json:"tenant"`
+	Actor   string `json:"actor"`
+	Uploads int64  `json:"uploads"`
+}
+
+// QuotaBreach counts a tenant's writes refused for its quota.
+type QuotaBreach struct {
+	Tenant  string `json:"tenant"`
+	Refused int64  `json:"refused"`
+}
+
+// DayStatus is a day's requests, across instances.
+type DayStatus struct {
+	Date         string `json:"date"`
+	Requests     int64  `json:"requests"`
+	ClientErrors int64  `json:"client_errors"`
+	ServerErrors int64  `json:"server_errors"`
+}
+
+type StatusReportRequest struct {
+	Week string `json:"week"`
+	Send bool   `json:"send,omitempty"`
+}
+
+type statusCount struct {
+	requests, clientErrors, serverErrors, webhookFailures int64
+}
+
+type refusalKey struct {
+	week, tenant string
+}
+
+// statusMeter counts requests and failed webhook deliveries by day, and
+// writes refused for quota by week and tenant, until they are flushed to
+// storage as one reading per instance and interval.
+type statusMeter struct {
+	mu       sync.Mutex
+	counts   map[string]*statusCount
+	refused  map[refusalKey]int64
+	instance string
+}
+
+func newStatusMeter() *statusMeter {
+	return &statusMeter{counts: map[string]*statusCount{}, refused: map[refusalKey]int64{}, instance: newJobID()}
+}
+
+func (m *statusMeter) record(now time.Time, update func(*statusCount)) {
+	if !statusReportsEnabled() {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	day := now.UTC().Format(statusReportDayFormat)
+	c, ok := m.counts[day]
+	if !ok {
+		c = &statusCount{}
+		m.counts[day] = c
+	}
+	update(c)
+}
+
+func (m *statusMeter) request(status int) {
+	m.record(time.Now(), func(c *statusCount) {
+		c.requests++
+		switch {
+		case status >= 500:
+			c.serverErrors++
+		case status >= 400:
+			c.clientErrors++
+		}
+	})
+}
+
+func (m *statusMeter) webhookFailed() {
+	m.record(time.Now(), func(c *statusCount) { c.webhookFailures++ })
+}
+
+func (m *statusMeter) quotaRefused(tenant string) {
+	if !statusReportsEnabled() {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.refused[refusalKey{isoWeek(time.Now()), tenant}]++
+}
+
+// flush writes out the counts so far. Counts that fail to be written are
+// kept for the next flush.
+func (m *statusMeter) flush(ctx context.Context) error {
+	m.mu.Lock()
+	counts, refused := m.counts, m.refused
+	m.counts, m.refused = map[string]*statusCount{}, map[refusalKey]int64{}
+	m.mu.Unlock()
+
+	var errs []error
+	for day, c := range counts {
+		date, _ := time.Parse(statusReportDayFormat, day)
+		reading := fmt.Sprintf("%s%s/%s/%s-%d-%d-%d-%d-%d", statusReportCountsPrefix, isoWeek(date), day, m.instance, time.Now().UnixNano(), c.requests, c.clientErrors, c.serverErrors, c.webhookFailures)
+		_, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(reading),
+			Body:   bytes.NewReader(nil),
+		})
+		if err != nil {
+			errs = append(errs, err)
+			m.mu.Lock()
+			if kept, ok := m.counts[day]; ok {
+				kept.requests += c.requests
+				kept.clientErrors += c.clientErrors
+				kept.serverErrors += c.serverErrors
+				kept.webhookFailures += c.webhookFailures
+			} else {
+				m.counts[day] = c
+			}
+			m.mu.Unlock()
+		}
+	}
+	for key, n := range refused {
+		reading := fmt.Sprintf("%s%s/%s/%s-%d-%d", statusReportQuotaPrefix, key.week, key.tenant, m.instance, time.Now().UnixNano(), n)
+		_, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(reading),
+			Body:   bytes.NewReader(nil),
+		})
+		if err != nil {
+			errs = append(errs, err)
+			m.mu.Lock()
+			m.refused[key] += n
+			m.mu.Unlock()
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// startStatusReports flushes request counts in the background, and sends
+// the previous week's report once it has ended.
+func startStatusReports(ctx context.Context) {
+	if !statusReportsEnabled() {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(statusReportFlushInterval)
+		defer ticker.Stop()
+
+		for {
+			if err := statusCounts.flush(ctx); err != nil {
+				slog.ErrorContext(ctx, "Status report count flush failed", "err", err)
+			}
+			// Other instances flush the week's last counts within an
+			// interval of it ending, so give them time before closing it
+			settled := time.Now().UTC().Add(-2 * statusReportFlushInterval)
+			previous := isoWeek(settled.AddDate(0, 0, -7))
+			if err := closeStatusWeek(ctx, previous); err != nil {
+				slog.ErrorContext(ctx, "Status report failed", "week", previous, "err", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// closeStatusWeek writes a finished week's report unless some instance
+// already has, and sends it if this instance wrote it. A report built
+// while the week was still running is replaced.
+func closeStatusWeek(ctx context.Context, week string) error {
+	stored, etag, err := readStatusReport(ctx, week)
+	switch {
+	case isNotFound(err):
+		etag = "*"
+	case err != nil:
+		return err
+	case stored.Complete:
+		return nil
+	}
+	report, err := buildStatusReport(ctx, week, time.Now())
+	if err != nil {
+		return err
+	}
+	written, err := storeStatusReport(ctx, report, etag)
+	if err != nil || !written {
+		return err
+	}
+	return sendStatusReport(ctx, report)
+}
+
+func statusReportKey(week string) string {
+	return statusReportReportsPrefix + week + ".json"
+}
+
+// isoWeek names the ISO 8601 week t falls in, e.g. 2024-W19.
+func isoWeek(t time.Time) string {
+	year, week := t.UTC().ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
+
+// statusWeek is the week as a half-open interval in UTC, Monday to Monday.
+func statusWeek(week string) (time.Time, time.Time, error) {
+	var year, n int
+	if _, err := fmt.Sscanf(week, "%4d-W%2d", &year, &n); err != nil || n < 1 || n > 53 || week != fmt.Sprintf("%d-W%02d", year, n) {
+		return time.Time{}, time.Time{}, fmt.Errorf("week must look like 2024-W19")
+	}
+	// January 4th is always in the first week
+	jan4 := time.Date(year, time.January, 4, 0, 0, 0, 0, time.UTC)
+	start := jan4.AddDate(0, 0, -(int(jan4.Weekday())+6)%7+(n-1)*7)
+	if isoWeek(start) != week {
+		return time.Time{}, time.Time{}, fmt.Errorf("%d has no week %d", year, n)
+	}
+	return start, start.AddDate(0, 0, 7), nil
+}
+
+// buildStatusReport gathers a week's figures: storage from the usage
+// counts, uploads from the audit log, and requests, quota refusals and
+// webhook failures from the counts every instance flushed.
+func buildStatusReport(ctx context.Context, week string, now time.Time) (StatusReport, error) {
+	start, end, err := statusWeek(week)
+	if err != nil {
+		return StatusReport{}, err
+	}
+	report := StatusReport{
+		Week:          week,
+		From:          start.Format(time.RFC3339),
+		To:            end.Format(time.RFC3339),
+		GeneratedAt:   now.UTC().Format(time.RFC3339),
+		Complete:      !now.Before(end),
+		Storage:       []TenantStorage{},
+		TopUploaders:  []UploaderCount{},
+		QuotaBreaches: []QuotaBreach{},
+		Days:          []DayStatus{},
+		Audited:       auditQueryable(),
+	}
+
+	tenants := []string{defaultTenant}
+	if tenancyEnabled() {
+		if tenants, err = knownTenants(ctx); err != nil {
+			return report, err
+		}
+	}
+	previous, _, err := readStatusReport(ctx, isoWeek(start.AddDate(0, 0, -7)))
+	if err != nil && !isNotFound(err) {
+		return report, err
+	}
+	before := map[string]TenantStorage{}
+	for _, stored := range previous.Storage {
+		before[stored.Tenant] = stored
+	}
+	for _, tenant := range tenants {
+		current, err := usage.get(ctx, tenant)
+		if err != nil {
+			return report, fmt.Errorf("counting %s: %w", tenant, err)
+		}
+		stored := TenantStorage{Tenant: tenant, Bytes: current.bytes, Objects: current.objects}
+		if last, ok := before[tenant]; ok {
+			bytesGrowth, objectsGrowth := current.bytes-last.Bytes, current.objects-last.Objects
+			stored.BytesGrowth, stored.ObjectsGrowth = &bytesGrowth, &objectsGrowth
+		}
+		report.Storage = append(report.Storage, stored)
+	}
+
+	if report.Audited {
+		if err := addAuditFigures(ctx, &report, tenants, start, end); err != nil {
+			return report, err
+		}
+	}
+
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		report.Days = append(report.Days, DayStatus{Date: day.Format(time.DateOnly)})
+	}
+	days := map[string]*DayStatus{}
+	for i := range report.Days {
+		date, _ := time.Parse(time.DateOnly, report.Days[i].Date)
+		days[date.Format(statusReportDayFormat)] = &report.Days[i]
+	}
+	err = listBillingEntries(ctx, statusReportCountsPrefix+week+"/", func(day string, fields []string) {
+		status, ok := days[day]
+		if !ok || len(fields) != 6 {
+			return
+		}
+		var n [4]int64
+		for i := range n {
+			var err error
+			if n[i], err = strconv.ParseInt(fields[2+i], 10, 64); err != nil {
+				return
+			}
+		}
+		status.Requests += n[0]
+		status.ClientErrors += n[1]
+		status.ServerErrors += n[2]
+		report.WebhookFailures += n[3]
+	})
+	if err != nil {
+		return report, err
+	}
+
+	breaches := map[string]int64{}
+	err = listBillingEntries(ctx, statusReportQuotaPrefix+week+"/", func(tenant string, fields []string) {
+		if len(fields) != 3 {
+			return
+		}
+		if n, err := strconv.ParseInt(fields[2], 10, 64); err == nil {
+			breaches[tenant] += n
+		}
+	})
+	for tenant, n := range breaches {
+		report.QuotaBreaches = append(report.QuotaBreaches, QuotaBreach{Tenant: tenant, Refused: n})
+	}
+	sort.Slice(report.QuotaBreaches, func(i, j int) bool {
+		return report.QuotaBreaches[i].Tenant < report.QuotaBreaches[j].Tenant
+	})
+	return report, err
+}
+
+// addAuditFigures counts each uploader's stored files from the week's
+// audit records.
+func addAuditFigures(ctx context.Context, report *StatusReport, tenants []string, start, end time.Time) error {
+	uploads := map[[2]string]int64{}
+	remaining := statusReportAuditLimit
+	for _, tenant := range tenants {
+		if remaining <= 0 {
+			report.AuditTruncated = true
+			break
+		}
+		records, err := readAudit(ctx, tenant, auditQuery{From: start, To: end.Add(-time.Nanosecond), Limit: remaining})
+		if err != nil {
+			return fmt.Errorf("reading %s's audit log: %w", tenant, err)
+		}
+		remaining -= len(records.Records)
+		report.AuditTruncated = report.AuditTruncated || records.Truncated
+		for _, record := range records.Records {
+			if record.Result == auditResultSuccess && uploadActions[record.Action] && (record.Method == "POST" || record.Method == "PUT") {
+				uploads[[2]string{record.Tenant, record.Actor}]++
+			}
+		}
+	}
+
+	for who, n := range uploads {
+		report.TopUploaders = append(report.TopUploaders, UploaderCount{Tenant: who[0], Actor: who[1], Uploads: n})
+	}
+	sort.Slice(report.TopUploaders, func(i, j int) bool {
+		a, b := report.TopUploaders[i], report.TopUploaders[j]
+		if a.Uploads != b.Uploads {
+			return a.Uploads > b.Uploads
+		}
+		return a.Tenant+"/"+a.Actor < b.Tenant+"/"+b.Actor
+	})
+	report.TopUploaders = report.TopUploaders[:min(len(report.TopUploaders), statusReportTopUploaders)]
+	return nil
+}
+
+// readStatusReport returns a stored report and its ETag.
+func readStatusReport(ctx context.Context, week string) (StatusReport, string, error) {
+	var report StatusReport
+	result, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(statusReportKey(week)),
+	})
+	if err != nil {
+		return report, "", err
+	}
+	defer result.Body.Close()
+	err = json.NewDecoder(result.Body).Decode(&report)
+	return report, aws.ToString(result.ETag), err
+}
+
+// storeStatusReport writes the report. With match "*" it only creates it,
+// and with an ETag it only replaces that version, so of instances closing
+// the same week only one writes it; it says whether it did.
+func storeStatusReport(ctx context.Context, report StatusReport, match string) (bool, error) {
+	body, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return false, err
+	}
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(bucketName),
+		Key:         aws.String(statusReportKey(report.Week)),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	}
+	switch match {
+	case "":
+	case "*":
+		input.IfNoneMatch = aws.String("*")
+	default:
+		input.IfMatch = aws.String(match)
+	}
+	if _, err := s3Client.PutObject(ctx, input); isPreconditionFailed(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// sendStatusReport mails the report and posts it to Slack, whichever are
+// set up.
+func sendStatusReport(ctx context.Context, report StatusReport) error {
+	subject := fmt.Sprintf("Storage status for %s", report.Week)
+	text := statusReportText(report)
+	var errs []error
+	if len(statusReportEmail) > 0 {
+		if err := sendEmail(ctx, statusReportEmail, subject, text); err != nil {
+			errs = append(errs, fmt.Errorf("emailing the report: %w", err))
+		}
+	}
+	if statusReportSlackURL != "" {
+		// A code block keeps the columns lined up
+		body, err := json.Marshal(map[string]string{"text": "*" + subject + "*\n```\n" + text + "```"})
+		if err == nil {
+			err = postSigned(ctx, statusReportSlackURL, "", "", body)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("posting the report to Slack: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// statusReportText is the report as plain text for people to read.
+func statusReportText(report StatusReport) string {
+	var b strings.Builder
+	from, _ := time.Parse(time.RFC3339, report.From)
+	to, _ := time.Parse(time.RFC3339, report.To)
+	fmt.Fprintf(&b, "Week %s, %s to %s (UTC)\n", report.Week, from.Format(time.DateOnly), to.AddDate(0, 0, -1).Format(time.DateOnly))
+
+	b.WriteString("\nStorage\n")
+	for _, stored := range report.Storage {
+		fmt.Fprintf(&b, "  %-20s %10s in %d files", stored.Tenant, formatBytes(stored.Bytes), stored.Objects)
+		if stored.BytesGrowth != nil {
+			fmt.Fprintf(&b, " (%s, %+d files)", signedBytes(*stored.BytesGrowth), *stored.ObjectsGrowth)
+		}
+		b.WriteString("\n")
+	}
+
+	b.WriteString("\nTop uploaders\n")
+	switch {
+	case !report.Audited:
+		b.WriteString("  Needs the s3 audit sink\n")
+	case len(report.TopUploaders) == 0:
+		b.WriteString("  No uploads\n")
+	}
+	for _, uploader := range report.TopUploaders {
+		fmt.Fprintf(&b, "  %-30s %d uploads\n", uploader.Tenant+"/"+uploader.Actor, uploader.Uploads)
+	}
+
+	if report.AuditTruncated {
+		fmt.Fprintf(&b, "  (from the first %d audit records only)\n", statusReportAuditLimit)
+	}
+
+	b.WriteString("\nQuota breaches\n")
+	if len(report.QuotaBreaches) == 0 {
+		b.WriteString("  None\n")
+	}
+	for _, breach := range report.QuotaBreaches {
+		fmt.Fprintf(&b, "  %-20s %d writes refused\n", breach.Tenant, breach.Refused)
+	}
+
+	fmt.Fprintf(&b, "\nFailed webhook deliveries: %d\n", report.WebhookFailures)
+
+	b.WriteString("\nRequests            total  4xx    5xx\n")
+	for _, day := range report.Days {
+		fmt.Fprintf(&b, "  %s  %8d  %-6d %d\n", day.Date, day.Requests, day.ClientErrors, day.ServerErrors)
+	}
+	return b.String()
+}
+
+// formatBytes is a size in decimal units, as storage is billed.
+func formatBytes(n int64) string {
+	const unit = 1000
+	if n < unit && n > -unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, prefix := float64(n), 0
+	for value >= unit*unit || value <= -unit*unit {
+		value /= unit
+		prefix++
+	}
+	return fmt.Sprintf("%.1f %cB", value/unit, "kMGTPE"[prefix])
+}
+
+func signedBytes(n int64) string {
+	if n < 0 {
+		return formatBytes(n)
+	}
+	return "+" + formatBytes(n)
+}
+
+func requireStatusReports(w http.ResponseWriter) bool {
+	if !statusReportsEnabled() {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{
+			Error:   "Status reports are disabled",
+			Details: "set STATUS_REPORT_EMAIL or STATUS_REPORT_SLACK_URL",
+		})
+		return false
+	}
+	return true
+}
+
+// createStatusReportHandler (re)builds a week's report in a job, for weeks
+// still running or to resend one that didn't arrive.
+func createStatusReportHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireStatusReports(w) {
+		return
+	}
+	req := StatusReportRequest{Week: isoWeek(time.Now())}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondInvalidBody(w, err)
+			return
+		}
+	}
+	if _, _, err := statusWeek(req.Week); err != nil {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid week",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	job := startJob(context.Background(), "status-report", func(ctx context.Context, job *Job) (map[string]string, error) {
+		// Include what this instance has counted but not yet written out
+		if err := statusCounts.flush(ctx); err != nil {
+			return nil, err
+		}
+		report, err := buildStatusReport(ctx, req.Week, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		if _, err := storeStatusReport(ctx, report, ""); err != nil {
+			return nil, err
+		}
+		result := map[string]string{"week": report.Week, "key": statusReportKey(report.Week)}
+		if req.Send {
+			if err := sendStatusReport(ctx, report); err != nil {
+				return nil, err
+			}
+			result["sent"] = "true"
+		}
+		return result, nil
+	})
+	respondJSON(w, http.StatusAccepted, job.snapshot())
+}
+
+func getStatusReportHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireStatusReports(w) {
+		return
+	}
+	result, err := s3Client.GetObject(r.Context(), &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(statusReportKey(mux.Vars(r)["week"])),
+	})
+	if err != nil {
+		status, message := http.StatusInternalServerError, "Failed to read status report"
+		if isNotFound(err) {
+			status, message = http.StatusNotFound, "Status report not found"
+		}
+		respondJSON(w, status, ErrorResponse{
+			Error:   message,
+			Details: err.Error(),
+		})
+		return
+	}
+	defer result.Body.Close()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, result.Body)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusReport(t *testing.T) {
+	posts := make(chan string, 10)
+	slack := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var message struct {
+			Text string `json:"text"`
+		}
+		json.NewDecoder(r.Body).Decode(&message)
+		posts <- message.Text
+	}))
+	defer slack.Close()
+
+	srv, _ := newTestServer(t)
+	override(t, &statusReportSlackURL, slack.URL)
+	override(t, &statusCounts, newStatusMeter())
+	override(t, &apiKeys, map[string]string{"acme-key": "acme", "globex-key": "globex"})
+	override(t, &auditSinks, []auditSink{s3AuditSink{}})
+	override(t, &defaultQuota, 10)
+	ctx := context.Background()
+	now := time.Now()
+	week := isoWeek(now)
+
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		expectStatus(t, call(t, srv, "POST", "/api/upload", upload(name, "abc"), "X-API-Key", "acme-key"), http.StatusOK)
+	}
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("d.txt", "abc"), "X-API-Key", "acme-key"), http.StatusRequestEntityTooLarge)
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("x.txt", "x"), "X-API-Key", "globex-key"), http.StatusOK)
+	expectStatus(t, call(t, srv, "GET", "/api/files/missing.txt", nil, "X-API-Key", "globex-key"), http.StatusNotFound)
+	if err := statusCounts.flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := buildStatusReport(ctx, week, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Complete || !report.Audited || len(report.Days) != 7 {
+		t.Fatalf("report %+v", report)
+	}
+	if len(report.TopUploaders) != 2 || report.TopUploaders[0] != (UploaderCount{Tenant: "acme", Actor: "acme", Uploads: 3}) {
+		t.Errorf("top uploaders %+v", report.TopUploaders)
+	}
+	if len(report.QuotaBreaches) != 1 || report.QuotaBreaches[0] != (QuotaBreach{Tenant: "acme", Refused: 1}) {
+		t.Errorf("quota breaches %+v", report.QuotaBreaches)
+	}
+	var requests, clientErrors int64
+	for _, day := range report.Days {
+		requests, clientErrors = requests+day.Requests, clientErrors+day.ClientErrors
+	}
+	if requests != 6 || clientErrors != 2 {
+		t.Errorf("%d requests, %d client errors; want 6 and 2", requests, clientErrors)
+	}
+	for _, stored := range report.Storage {
+		if stored.Tenant == "acme" && (stored.Bytes != 9 || stored.Objects != 3 || stored.BytesGrowth != nil) {
+			t.Errorf("acme storage %+v", stored)
+		}
+	}
+
+	// Last week's report is sent once, with growth since the one before,
+	// and replaces one built while the week was running
+	earlier, running := report, report
+	earlier.Week = isoWeek(now.AddDate(0, 0, -14))
+	running.Week = isoWeek(now.AddDate(0, 0, -7))
+	for _, stored := range []StatusReport{earlier, running} {
+		if written, err := storeStatusReport(ctx, stored, "*"); err != nil || !written {
+			t.Fatal(written, err)
+		}
+	}
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("y.txt", "more"), "X-API-Key", "globex-key"), http.StatusOK)
+	for range 2 {
+		if err := closeStatusWeek(ctx, running.Week); err != nil {
+			t.Fatal(err)
+		}
+	}
+	text := <-posts
+	if !strings.Contains(text, "Storage status for "+running.Week) || !strings.Contains(text, "5 B in 2 files (+4 B, +1 files)") {
+		t.Errorf("posted report:\n%s", text)
+	}
+	select {
+	case text := <-posts:
+		t.Errorf("report posted again:\n%s", text)
+	default:
+	}
+}
+
+func TestStatusWeek(t *testing.T) {
+	for week, monday := range map[string]string{
+		"2024-W01": "2024-01-01",
+		"2021-W01": "2021-01-04",
+		"2020-W53": "2020-12-28",
+	} {
+		start, end, err := statusWeek(week)
+		if err != nil || start.Format(time.DateOnly) != monday || end.Sub(start) != 7*24*time.Hour {
+			t.Errorf("%s: %v to %v, %v; want from %s", week, start, end, err, monday)
+		}
+	}
+	for _, week := range []string{"2021-W53", "2024-W1", "2024-19", "2024-W00"} {
+		if _, _, err := statusWeek(week); err == nil {
+			t.Errorf("%s parsed", week)
+		}
+	}
+}
+
+func TestStatusReportHandlers(t *testing.T) {
+	srv, _ := newTestServer(t)
+	override(t, &adminToken, "admin-secret")
+	admin := []string{"Authorization", "Bearer admin-secret"}
+
+	expectStatus(t, call(t, srv, "POST", "/api/admin/status-reports", nil, admin...), http.StatusNotFound)
+	override(t, &statusReportEmail, []string{"ops@example.com"})
+	override(t, &statusCounts, newStatusMeter())
+	expectStatus(t, call(t, srv, "POST", "/api/admin/status-reports", StatusReportRequest{Week: "2024-W60"}, admin...), http.StatusBadRequest)
+
+	var job Job
+	got := call(t, srv, "POST", "/api/admin/status-reports", StatusReportRequest{Week: "2024-W19"}, admin...)
+	expectStatus(t, got, http.StatusAccepted)
+	got.decode(t, &job)
+	if done := waitJob(t, srv, job.ID); done.Status != jobSucceeded {
+		t.Fatalf("job %+v", done)
+	}
+
+	got = call(t, srv, "GET", "/api/admin/status-reports/2024-W19", nil, admin...)
+	expectStatus(t, got, http.StatusOK)
+	var report StatusReport
+	got.decode(t, &report)
+	if report.Week != "2024-W19" || !report.Complete || report.From != "2024-05-06T00:00:00Z" {
+		t.Errorf("report %+v", report)
+	}
+	if text := statusReportText(report); !strings.Contains(text, "Week 2024-W19, 2024-05-06 to 2024-05-12") {
+		t.Errorf("report text:\n%s", text)
+	}
+	expectStatus(t, call(t, srv, "GET", "/api/admin/status-reports/2024-W20", nil, admin...), http.StatusNotFound)
+}
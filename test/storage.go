@@ -0,0 +1,459 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Object metadata recording how stored bytes differ from what the client sent.
+// Encodings are listed in the order they were applied.
+const (
+	metaStorageEncoding = "storage-encoding"
+	metaOriginalSize    = "original-size"
+)
+
+const (
+	encodingGzip      = "gzip"
+	encodingZstd      = "zstd"
+	encodingAESGCMKey = "aes-256-gcm-key"
+	policyEncrypted   = "encrypt"
+
+	// Objects encrypted before the ciphertext was bound to the key can
+	// still be read, but nothing is encrypted this way any more
+	encodingAESGCM = "aes-256-gcm"
+)
+
+// reservedPrefixes hold internal bookkeeping objects that are hidden from
+// regular file listings.
+var reservedPrefixes = []string{trashPrefix, expiryPrefix, aclPrefix, sharePrefix, capturePrefix, policyPrefix, auditPrefix, tenantRegistryPrefix, billingPrefix, quotaWarningPrefix, torrentPrefix, jobPrefix, actionsCachePrefix, buildCachePrefix, channelPrefix, mirrorPrefix, aliasPrefix, freezePrefix, statusReportPrefix, webhookPrefix, pluginPrefix, quarantinePrefix}
+
+func isReservedKey(key string) bool {
+	for _, prefix := range reservedPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// storagePolicy describes the transforms applied to objects under a prefix.
+type storagePolicy struct {
+	Compression string
+	Encrypt     bool
+}
+
+var (
+	// e.g. STORAGE_POLICIES="archive/=zstd,secure/=gzip+encrypt"
+	storagePolicies = parseStoragePolicies(os.Getenv("STORAGE_POLICIES"))
+
+	encryptionKey []byte
+)
+
+func init() {
+	needsKey := false
+	for _, policy := range storagePolicies {
+		needsKey = needsKey || policy.Encrypt
+	}
+
+	if encoded := os.Getenv("STORAGE_ENCRYPTION_KEY"); encoded != "" {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			fatal("STORAGE_ENCRYPTION_KEY must be 32 base64 encoded bytes")
+		}
+		encryptionKey = key
+	} else if needsKey {
+		fatal("STORAGE_POLICIES requires encryption but STORAGE_ENCRYPTION_KEY is not set")
+	}
+}
+
+func parseStoragePolicies(value string) map[string]storagePolicy {
+	policies := map[string]storagePolicy{}
+	for prefix, spec := range parseAssignments(value) {
+		var policy storagePolicy
+		for _, part := range strings.Split(spec, "+") {
+			switch part = strings.TrimSpace(part); part {
+			case encodingGzip, encodingZstd:
+				policy.Compression = part
+			case policyEncrypted:
+				policy.Encrypt = true
+			default:
+				fatal("Unknown storage policy", "policy", part, "prefix", prefix)
+			}
+		}
+		policies[prefix] = policy
+	}
+	return policies
+}
+
+// storagePolicyFor returns the policy of the longest configured prefix matching key.
+func storagePolicyFor(key string) storagePolicy {
+	var policy storagePolicy
+	longest := -1
+	for prefix, p := range storagePolicies {
+		if strings.HasPrefix(key, prefix) && len(prefix) > longest {
+			policy, longest = p, len(prefix)
+		}
+	}
+	return policy
+}
+
+// putObject stores content under input.Key, applying the storage policy of its
+// prefix. Callers set everything on the input except Body.
+func putObject(ctx context.Context, input *s3.PutObjectInput, content []byte) (*s3.PutObjectOutput, error) {
+	// Policies are configured against the names tenants see
+	if err := encodeObject(input, namespaceFrom(ctx).name(aws.ToString(input.Key)), content); err != nil {
+		return nil, err
+	}
+	result, err := s3Client.PutObject(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	replicateObject(ctx, aws.ToString(input.Key))
+	return result, nil
+}
+
+// encodeObject sets input's Body to content as the storage policy for name
+// says to store it, and records how in its metadata.
+func encodeObject(input *s3.PutObjectInput, name string, content []byte) error {
+	policy := storagePolicyFor(name)
+
+	var encodings []string
+	stored := content
+
+	if policy.Compression != "" {
+		compressed, err := compress(policy.Compression, stored)
+		if err != nil {
+			return err
+		}
+		stored = compressed
+		encodings = append(encodings, policy.Compression)
+	} else if autoCompressionFor(aws.ToString(input.Key), content) {
+		compressed, err := compress(encodingZstd, stored)
+		if err != nil {
+			return err
+		}
+		// Keep the original when compression doesn't pay off
+		if len(compressed) < len(stored) {
+			stored = compressed
+			encodings = append(encodings, encodingZstd)
+		}
+	}
+
+	if policy.Encrypt {
+		encrypted, err := encrypt(stored, sealedKey(aws.ToString(input.Key)))
+		if err != nil {
+			return err
+		}
+		stored = encrypted
+		encodings = append(encodings, encodingAESGCMKey)
+	}
+
+	if input.Metadata == nil {
+		input.Metadata = map[string]string{}
+	}
+	input.Metadata[metaHashAlgorithm] = hashAlgorithm
+	input.Metadata[metaContentHash] = contentHash(content)
+	if len(encodings) > 0 {
+		input.Metadata[metaStorageEncoding] = strings.Join(encodings, ",")
+		input.Metadata[metaOriginalSize] = strconv.Itoa(len(content))
+	}
+
+	input.Body = bytes.NewReader(stored)
+	return nil
+}
+
+// originalSize is the size of an object as uploaded, before any storage
+// encodings.
+func originalSize(head *s3.HeadObjectOutput) int64 {
+	if size, err := strconv.ParseInt(head.Metadata[metaOriginalSize], 10, 64); err == nil {
+		return size
+	}
+	return aws.ToInt64(head.ContentLength)
+}
+
+// headObjects looks up keys in parallel. Listings give stored sizes, which
+// compression changes, so listings that report sizes as uploaded need this.
+func headObjects(ctx context.Context, bucket string, keys []string) ([]*s3.HeadObjectOutput, error) {
+	heads := make([]*s3.HeadObjectOutput, len(keys))
+	err := fanOut(ctx, len(keys), func(ctx context.Context, i int) error {
+		head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(keys[i]),
+		})
+		heads[i] = head
+		return err
+	})
+	return heads, err
+}
+
+// getObject fetches an object and reverses any storage encodings, so Body and
+// ContentLength describe the content as originally uploaded.
+func getObject(ctx context.Context, input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	result, err := s3Client.GetObject(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := decodeResult(result, aws.ToString(input.Key)); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// decodeResult replaces the body of an object fetched from key with its
+// decoded content.
+func decodeResult(result *s3.GetObjectOutput, key string) error {
+	encodings := storageEncodings(result.Metadata)
+	if len(encodings) == 0 {
+		return nil
+	}
+
+	body, err := decode(result.Body, encodings, key)
+	if err != nil {
+		result.Body.Close()
+		return err
+	}
+	result.Body = body
+
+	if size, err := strconv.ParseInt(result.Metadata[metaOriginalSize], 10, 64); err == nil {
+		result.ContentLength = aws.Int64(size)
+	} else {
+		result.ContentLength = nil
+	}
+
+	return nil
+}
+
+func storageEncodings(metadata map[string]string) []string {
+	value := metadata[metaStorageEncoding]
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+func compress(algorithm string, content []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	var writer io.WriteCloser
+	switch algorithm {
+	case encodingGzip:
+		writer = gzip.NewWriter(&buf)
+	case encodingZstd:
+		zw, err := zstd.NewWriter(&buf)
+		if err != nil {
+			return nil, err
+		}
+		writer = zw
+	default:
+		return nil, fmt.Errorf("unknown compression %q", algorithm)
+	}
+
+	if _, err := writer.Write(content); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sealedKey is the key an object's encryption is bound to, so its bytes
+// don't decrypt under any other: its own, or for a trashed file the one
+// it is restored to, so it can go to the trash and back as it is.
+func sealedKey(key string) string {
+	ns := namespaceOfKey(key)
+	return ns.key(strings.TrimPrefix(ns.name(key), trashPrefix))
+}
+
+// encrypt seals content with the object key as additional data.
+func encrypt(content []byte, key string) ([]byte, error) {
+	gcm, err := newGCM()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, content, []byte(key)), nil
+}
+
+// decrypt opens content sealed for key, which is nil for objects stored
+// as encodingAESGCM.
+func decrypt(content, key []byte) ([]byte, error) {
+	gcm, err := newGCM()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(content) < gcm.NonceSize() {
+		return nil, fmt.Errorf("encrypted object is truncated")
+	}
+	nonce, sealed := content[:gcm.NonceSize()], content[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, sealed, key)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting object: %w", err)
+	}
+	return plain, nil
+}
+
+// resealed returns an object fetched from one key encrypted for another,
+// with the metadata to store it under.
+func resealed(sealed []byte, metadata map[string]string, from, to string) ([]byte, map[string]string, error) {
+	encodings := storageEncodings(metadata)
+	var aad []byte
+	switch encodings[len(encodings)-1] {
+	case encodingAESGCMKey:
+		aad = []byte(sealedKey(from))
+	case encodingAESGCM:
+	default:
+		return nil, nil, fmt.Errorf("%s isn't encrypted", from)
+	}
+	plain, err := decrypt(sealed, aad)
+	if err != nil {
+		return nil, nil, err
+	}
+	if sealed, err = encrypt(plain, sealedKey(to)); err != nil {
+		return nil, nil, err
+	}
+	encodings[len(encodings)-1] = encodingAESGCMKey
+	metadata = maps.Clone(metadata)
+	metadata[metaStorageEncoding] = strings.Join(encodings, ",")
+	return sealed, metadata, nil
+}
+
+// resealObject copies an encrypted object to a key it isn't sealed for, by
+// encrypting it again, which CopyObject can't. It reports false, having
+// copied nothing, when the object isn't encrypted.
+func resealObject(ctx context.Context, from, to, etag string) (bool, error) {
+	bucket := bucketFor(ctx)
+	input := &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(from)}
+	if etag != "" {
+		input.IfMatch = aws.String(etag)
+	}
+	result, err := s3Client.GetObject(ctx, input)
+	if err != nil {
+		return false, err
+	}
+	defer result.Body.Close()
+	if !encrypted(result.Metadata) {
+		return false, nil
+	}
+	sealed, err := io.ReadAll(result.Body)
+	if err != nil {
+		return false, err
+	}
+	content, metadata, err := resealed(sealed, result.Metadata, from, to)
+	if err != nil {
+		return false, err
+	}
+
+	put := &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(to),
+		Body:        bytes.NewReader(content),
+		ContentType: result.ContentType,
+		Metadata:    metadata,
+	}
+	// CopyObject would have copied the tags, expiry among them
+	if aws.ToInt32(result.TagCount) > 0 {
+		tags, err := s3Client.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{Bucket: aws.String(bucket), Key: aws.String(from), VersionId: result.VersionId})
+		if err != nil {
+			return false, err
+		}
+		values := url.Values{}
+		for _, tag := range tags.TagSet {
+			values.Set(aws.ToString(tag.Key), aws.ToString(tag.Value))
+		}
+		put.Tagging = aws.String(values.Encode())
+	}
+	_, err = s3Client.PutObject(ctx, put)
+	return true, err
+}
+
+func encrypted(metadata map[string]string) bool {
+	encodings := storageEncodings(metadata)
+	return len(encodings) > 0 && (encodings[len(encodings)-1] == encodingAESGCMKey || encodings[len(encodings)-1] == encodingAESGCM)
+}
+
+func newGCM() (cipher.AEAD, error) {
+	if encryptionKey == nil {
+		return nil, fmt.Errorf("STORAGE_ENCRYPTION_KEY is not configured")
+	}
+	block, err := aes.NewCipher(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// decode undoes encodings in reverse order of application, for an object
+// fetched from key.
+func decode(body io.ReadCloser, encodings []string, key string) (io.ReadCloser, error) {
+	reader := body
+
+	for i := len(encodings) - 1; i >= 0; i-- {
+		switch encodings[i] {
+		case encodingAESGCMKey, encodingAESGCM:
+			sealed, err := io.ReadAll(reader)
+			if err != nil {
+				return nil, err
+			}
+			var aad []byte
+			if encodings[i] == encodingAESGCMKey {
+				aad = []byte(sealedKey(key))
+			}
+			plain, err := decrypt(sealed, aad)
+			if err != nil {
+				return nil, err
+			}
+			reader = io.NopCloser(bytes.NewReader(plain))
+		case encodingGzip:
+			gz, err := gzip.NewReader(reader)
+			if err != nil {
+				return nil, err
+			}
+			reader = gz
+		case encodingZstd:
+			zr, err := zstd.NewReader(reader)
+			if err != nil {
+				return nil, err
+			}
+			reader = zr.IOReadCloser()
+		default:
+			return nil, fmt.Errorf("unknown storage encoding %q", encodings[i])
+		}
+	}
+
+	// Closing the outermost decoder doesn't close the S3 body underneath
+	return readCloser{Reader: reader, close: func() error {
+		reader.Close()
+		return body.Close()
+	}}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (rc readCloser) Close() error { return rc.close() }
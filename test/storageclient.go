@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+var (
+	// At startup the AWS client gets STORAGE_INIT_ATTEMPTS tries of up to
+	// STORAGE_INIT_TIMEOUT each. If all fail the server starts anyway and
+	// storage calls try again at most every STORAGE_RETRY_INTERVAL.
+	storageInitAttempts  = intFromEnv("STORAGE_INIT_ATTEMPTS", 3)
+	storageInitTimeout   = durationFromEnv("STORAGE_INIT_TIMEOUT", 10*time.Second)
+	storageRetryInterval = durationFromEnv("STORAGE_RETRY_INTERVAL", 30*time.Second)
+
+	storage = &lazyS3{connect: newS3Client}
+
+	s3Client   s3API = trackUploads(withBreaker(storage))
+	bucketName       = settings.Storage.Bucket
+)
+
+var errStorageNotReady = errors.New("storage unavailable: no AWS client")
+
+func newS3Client(ctx context.Context) (s3API, error) {
+	var options []func(*config.LoadOptions) error
+	if settings.Storage.Region != "" {
+		options = append(options, config.WithRegion(settings.Storage.Region))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, options...)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	client := s3API(s3.NewFromConfig(cfg))
+	if len(readRegions) > 0 {
+		replicas := make([]*regionEndpoint, 0, len(readRegions))
+		for _, region := range sortedKeys(readRegions) {
+			replicas = append(replicas, &regionEndpoint{
+				region: region,
+				bucket: readRegions[region],
+				client: s3.NewFromConfig(cfg, func(o *s3.Options) { o.Region = region }),
+			})
+		}
+		regions = newRegionPool(&regionEndpoint{region: cfg.Region, bucket: bucketName, client: client}, replicas...)
+		client = withRegions(regions)
+		// The client may only be made long after startup, once storage recovers
+		startRegionProbes(context.WithoutCancel(ctx))
+	}
+	return withStorageMetrics(client), nil
+}
+
+// lazyS3 builds the S3 client on first use rather than at import time, so a
+// missing or broken AWS configuration leaves the server up in a degraded
+// state instead of killing it. Concurrent first calls wait for one attempt.
+type lazyS3 struct {
+	connect func(context.Context) (s3API, error)
+
+	client atomic.Pointer[s3API]
+
+	mu      sync.Mutex
+	lastErr error
+	triedAt time.Time
+}
+
+// start connects at startup, retrying with a growing pause, and reports the
+// last failure if every attempt failed.
+func (l *lazyS3) start(ctx context.Context) error {
+	var err error
+	for attempt := 1; attempt <= max(storageInitAttempts, 1); attempt++ {
+		if attempt > 1 {
+			select {
+			case <-time.After(time.Duration(attempt-1) * time.Second):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if _, err = l.get(ctx, true); err == nil {
+			return nil
+		}
+		slog.WarnContext(ctx, "Failed to set up storage client", "attempt", attempt, "err", err)
+	}
+	return err
+}
+
+// get returns the client, connecting first if there isn't one yet. Unless
+// force is set, a recent failure is returned rather than tried again.
+func (l *lazyS3) get(ctx context.Context, force bool) (s3API, error) {
+	if client := l.client.Load(); client != nil {
+		return *client, nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if client := l.client.Load(); client != nil {
+		return *client, nil
+	}
+	if !force && !l.triedAt.IsZero() && time.Since(l.triedAt) < storageRetryInterval {
+		return nil, fmt.Errorf("%w: %v", errStorageNotReady, l.lastErr)
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), storageInitTimeout)
+	defer cancel()
+	client, err := l.connect(ctx)
+	l.triedAt = time.Now()
+	if err != nil {
+		l.lastErr = err
+		return nil, fmt.Errorf("%w: %v", errStorageNotReady, err)
+	}
+	l.client.Store(&client)
+	return client, nil
+}
+
+func (l *lazyS3) PutObject(ctx context.Context, in *s3.PutObjectInput, opts ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	client, err := l.get(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	return client.PutObject(ctx, in, opts...)
+}
+
+func (l *lazyS3) UploadPart(ctx context.Context, in *s3.UploadPartInput, opts ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	client, err := l.get(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	return client.UploadPart(ctx, in, opts...)
+}
+
+func (l *lazyS3) CreateMultipartUpload(ctx context.Context, in *s3.CreateMultipartUploadInput, opts ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	client, err := l.get(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	return client.CreateMultipartUpload(ctx, in, opts...)
+}
+
+func (l *lazyS3) CompleteMultipartUpload(ctx context.Context, in *s3.CompleteMultipartUploadInput, opts ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	client, err := l.get(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	return client.CompleteMultipartUpload(ctx, in, opts...)
+}
+
+func (l *lazyS3) AbortMultipartUpload(ctx context.Context, in *s3.AbortMultipartUploadInput, opts ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	client, err := l.get(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	return client.AbortMultipartUpload(ctx, in, opts...)
+}
+
+func (l *lazyS3) GetObject(ctx context.Context, in *s3.GetObjectInput, opts ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	client, err := l.get(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	return client.GetObject(ctx, in, opts...)
+}
+
+func (l *lazyS3) HeadObject(ctx context.Context, in *s3.HeadObjectInput, opts ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	client, err := l.get(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	return client.HeadObject(ctx, in, opts...)
+}
+
+func (l *lazyS3) CopyObject(ctx context.Context, in *s3.CopyObjectInput, opts ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	client, err := l.get(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	return client.CopyObject(ctx, in, opts...)
+}
+
+func (l *lazyS3) DeleteObject(ctx context.Context, in *s3.DeleteObjectInput, opts ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	client, err := l.get(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	return client.DeleteObject(ctx, in, opts...)
+}
+
+func (l *lazyS3) DeleteObjects(ctx context.Context, in *s3.DeleteObjectsInput, opts ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	client, err := l.get(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	return client.DeleteObjects(ctx, in, opts...)
+}
+
+func (l *lazyS3) ListObjectsV2(ctx context.Context, in *s3.ListObjectsV2Input, opts ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	client, err := l.get(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	return client.ListObjectsV2(ctx, in, opts...)
+}
+
+func (l *lazyS3) ListObjectVersions(ctx context.Context, in *s3.ListObjectVersionsInput, opts ...func(*s3.Options)) (*s3.ListObjectVersionsOutput, error) {
+	client, err := l.get(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	return client.ListObjectVersions(ctx, in, opts...)
+}
+
+func (l *lazyS3) GetObjectTagging(ctx context.Context, in *s3.GetObjectTaggingInput, opts ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error) {
+	client, err := l.get(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	return client.GetObjectTagging(ctx, in, opts...)
+}
+
+func (l *lazyS3) GetObjectLegalHold(ctx context.Context, in *s3.GetObjectLegalHoldInput, opts ...func(*s3.Options)) (*s3.GetObjectLegalHoldOutput, error) {
+	client, err := l.get(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	return client.GetObjectLegalHold(ctx, in, opts...)
+}
+
+func (l *lazyS3) PutObjectLegalHold(ctx context.Context, in *s3.PutObjectLegalHoldInput, opts ...func(*s3.Options)) (*s3.PutObjectLegalHoldOutput, error) {
+	client, err := l.get(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	return client.PutObjectLegalHold(ctx, in, opts...)
+}
+
+func (l *lazyS3) GetBucketVersioning(ctx context.Context, in *s3.GetBucketVersioningInput, opts ...func(*s3.Options)) (*s3.GetBucketVersioningOutput, error) {
+	client, err := l.get(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	return client.GetBucketVersioning(ctx, in, opts...)
+}
+
+func (l *lazyS3) HeadBucket(ctx context.Context, in *s3.HeadBucketInput, opts ...func(*s3.Options)) (*s3.HeadBucketOutput, error) {
+	client, err := l.get(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	return client.HeadBucket(ctx, in, opts...)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Without a client the server stays up, reports storage as unavailable, and
+// recovers once one can be made.
+func TestDegradedStorage(t *testing.T) {
+	srv, fake := newTestServer(t)
+	override(t, &storageInitAttempts, 2)
+
+	var connects atomic.Int32
+	healthy := false
+	lazy := &lazyS3{connect: func(context.Context) (s3API, error) {
+		connects.Add(1)
+		if !healthy {
+			return nil, errors.New("no credentials")
+		}
+		return fake, nil
+	}}
+	override(t, &s3Client, s3API(lazy))
+
+	if err := lazy.start(context.Background()); err == nil || connects.Load() != 2 {
+		t.Fatalf("start after %d attempts: %v", connects.Load(), err)
+	}
+
+	var health HealthResponse
+	resp := call(t, srv, "GET", "/api/health", nil)
+	expectStatus(t, resp, http.StatusServiceUnavailable)
+	resp.decode(t, &health)
+	if storage := health.Components["storage"]; storage.Status != "error" || !strings.Contains(storage.Error, "no credentials") {
+		t.Fatalf("storage %+v", storage)
+	}
+	// Failures aren't retried on every call
+	if connects.Load() != 2 {
+		t.Fatalf("connected %d times", connects.Load())
+	}
+
+	healthy = true
+	override(t, &storageRetryInterval, 0)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s3Client.HeadBucket(context.Background(), &s3.HeadBucketInput{Bucket: aws.String(bucketName)}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if connects.Load() != 3 {
+		t.Fatalf("concurrent first calls connected %d times", connects.Load()-2)
+	}
+}
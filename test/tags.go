@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// S3 allows ten tags per object; one is kept back for expires-at.
+const maxUserTags = 9
+
+// The characters S3 allows in tag keys and values.
+var tagChars = regexp.MustCompile(`^[\p{L}\p{Z}\p{N}_.:/=+\-@]*$`)
+
+// reservedTags are the tags the service sets itself.
+var reservedTags = map[string]bool{tagExpiresAt: true}
+
+// applyTags stores an upload's tags as object tags, which lifecycle rules
+// and cost reports can select on.
+func applyTags(req UploadRequest, input *s3.PutObjectInput) error {
+	if len(req.Tags) == 0 {
+		return nil
+	}
+	if len(req.Tags) > maxUserTags {
+		return fmt.Errorf("at most %d tags are allowed", maxUserTags)
+	}
+	values := url.Values{}
+	for key, value := range req.Tags {
+		switch {
+		case key == "" || len(key) > 128:
+			return fmt.Errorf("tag keys must be 1 to 128 characters")
+		case len(value) > 256:
+			return fmt.Errorf("tag %s: values must be at most 256 characters", key)
+		case !tagChars.MatchString(key) || !tagChars.MatchString(value):
+			return fmt.Errorf("tag %s: only letters, digits, spaces and _ . : / = + - @ are allowed", key)
+		case reservedTags[key]:
+			return fmt.Errorf("tag %s is set by the service", key)
+		}
+		values.Set(key, value)
+	}
+	// Encode sorts by key
+	input.Tagging = aws.String(values.Encode())
+	return nil
+}
+
+// userTags is tags without the ones the service sets, or nil if that
+// leaves none.
+func userTags(tags map[string]string) map[string]string {
+	var user map[string]string
+	for key, value := range tags {
+		if reservedTags[key] {
+			continue
+		}
+		if user == nil {
+			user = map[string]string{}
+		}
+		user[key] = value
+	}
+	return user
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultTailLines = 100
+	maxTailLines     = 10000
+	tailChunkSize    = 64 * 1024
+)
+
+var tailPollInterval = durationFromEnv("TAIL_POLL_INTERVAL", 2*time.Second)
+
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	if value := os.Getenv(name); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			return d
+		}
+	}
+	return fallback
+}
+
+// readRange fetches bytes [start, end) of an object.
+func readRange(ctx context.Context, key string, start, end int64) ([]byte, error) {
+	result, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketFor(ctx)),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", start, end-1)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer result.Body.Close()
+
+	return io.ReadAll(result.Body)
+}
+
+// errEncodedObject is returned for objects stored compressed or encrypted,
+// whose stored bytes can't be range-read as text.
+var errEncodedObject = errors.New("tailing is not supported for compressed or encrypted objects")
+
+func objectSize(ctx context.Context, key string) (int64, error) {
+	head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucketFor(ctx)),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(storageEncodings(head.Metadata)) > 0 {
+		return 0, errEncodedObject
+	}
+	return aws.ToInt64(head.ContentLength), nil
+}
+
+// lastLines reads the object backwards in chunks until it has collected the
+// requested number of lines or reached the start of the object.
+func lastLines(ctx context.Context, key string, size int64, lines int) ([]byte, error) {
+	var tail []byte
+	end := size
+
+	for end > 0 {
+		start := end - tailChunkSize
+		if start < 0 {
+			start = 0
+		}
+
+		chunk, err := readRange(ctx, key, start, end)
+		if err != nil {
+			return nil, err
+		}
+		tail = append(chunk, tail...)
+		end = start
+
+		// A trailing newline terminates the last line rather than starting a new one
+		if bytes.Count(bytes.TrimSuffix(tail, []byte("\n")), []byte("\n")) >= lines {
+			break
+		}
+	}
+
+	trimmed := bytes.TrimSuffix(tail, []byte("\n"))
+	for i, seen := len(trimmed)-1, 0; i >= 0; i-- {
+		if trimmed[i] == '\n' {
+			seen++
+			if seen == lines {
+				return tail[i+1:], nil
+			}
+		}
+	}
+
+	return tail, nil
+}
+
+func tailFileHandler(w http.ResponseWriter, r *http.Request) {
+	filename := mux.Vars(r)["filename"]
+
+	if filename == "" {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Missing filename parameter",
+		})
+		return
+	}
+
+	lines := defaultTailLines
+	if value := r.URL.Query().Get("lines"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxTailLines {
+			respondJSON(w, http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid lines parameter",
+				Details: fmt.Sprintf("must be between 1 and %d", maxTailLines),
+			})
+			return
+		}
+		lines = n
+	}
+	follow, _ := strconv.ParseBool(r.URL.Query().Get("follow"))
+
+	ctx := r.Context()
+	key := requestNamespace(r).key(filename)
+	if !authorizeFile(w, r, key, permRead) {
+		return
+	}
+
+	size, err := objectSize(ctx, key)
+	if errors.Is(err, errEncodedObject) {
+		respondJSON(w, http.StatusConflict, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		status, message := http.StatusInternalServerError, "Failed to read file"
+		if isNotFound(err) {
+			status, message = http.StatusNotFound, "File not found"
+		}
+		respondJSON(w, status, ErrorResponse{
+			Error:   message,
+			Details: err.Error(),
+		})
+		return
+	}
+
+	content, err := lastLines(ctx, key, size, lines)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to read file",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	enableCORS(w)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(content)
+
+	if !follow {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return
+	}
+	flusher.Flush()
+
+	// Poll for appended data until the client goes away
+	ticker := time.NewTicker(tailPollInterval)
+	defer ticker.Stop()
+
+	offset := size
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		current, err := objectSize(ctx, key)
+		if err != nil {
+			return
+		}
+		if current < offset {
+			// The object was replaced with something shorter; start again from the top
+			offset = 0
+		}
+		if current == offset {
+			continue
+		}
+
+		appended, err := readRange(ctx, key, offset, current)
+		if err != nil {
+			return
+		}
+		offset = current
+
+		if _, err := w.Write(appended); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+var (
+	// Tenancy is enabled by mapping API keys to tenants, e.g.
+	// API_KEYS="k3y-one=acme,k3y-two=globex", or by accepting JWTs (see jwt.go).
+	// Without either every request acts for the default tenant and sees the
+	// whole bucket.
+	apiKeys = settings.Auth.APIKeys
+
+	// Tenants may be given a bucket of their own, e.g.
+	// TENANT_BUCKETS="acme=acme-files". The rest share the default bucket under
+	// TENANT_KEY_PREFIX followed by their id.
+	tenantBuckets   = parseAssignments(os.Getenv("TENANT_BUCKETS"))
+	tenantKeyPrefix = envOr("TENANT_KEY_PREFIX", "tenants/")
+
+	validTenantID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+func init() {
+	for _, tenant := range apiKeys {
+		if !validTenantID.MatchString(tenant) {
+			fatal("Invalid tenant id in API_KEYS", "tenant", tenant)
+		}
+	}
+}
+
+func tenancyEnabled() bool {
+	return len(currentSetting(&apiKeys)) > 0 || jwtEnabled() || oidcEnabled() || tenantRegistryEnabled
+}
+
+// principal is the authenticated caller of a request.
+type principal struct {
+	Subject string
+	Tenant  string
+	Method  string
+	Claims  map[string]interface{}
+}
+
+type principalKey struct{}
+
+func requestPrincipal(r *http.Request) (principal, bool) {
+	p, ok := r.Context().Value(principalKey{}).(principal)
+	return p, ok
+}
+
+// namespace is the slice of storage a tenant can see: a bucket and a key
+// prefix within it. Handlers work with names relative to the namespace and
+// convert them with key and name at the storage boundary.
+type namespace struct {
+	Tenant string
+	Bucket string
+	Prefix string
+}
+
+func (ns namespace) key(name string) string {
+	return ns.Prefix + name
+}
+
+func (ns namespace) name(key string) string {
+	return strings.TrimPrefix(key, ns.Prefix)
+}
+
+// rootNamespace is the whole default bucket. It is what requests see when
+// tenancy is off, and what background work on raw keys uses.
+func rootNamespace() namespace {
+	return namespace{Tenant: defaultTenant, Bucket: bucketName}
+}
+
+func namespaceFor(tenant string) namespace {
+	if !tenancyEnabled() {
+		return rootNamespace()
+	}
+	if bucket, ok := tenantBuckets[tenant]; ok {
+		return namespace{Tenant: tenant, Bucket: bucket}
+	}
+	if record, ok := registeredTenants.cached(tenant); ok && record.Bucket != "" {
+		return namespace{Tenant: tenant, Bucket: record.Bucket}
+	}
+	return sharedNamespace(tenant, bucketName)
+}
+
+// sharedNamespace is tenant's prefix within a bucket shared with other tenants.
+func sharedNamespace(tenant, bucket string) namespace {
+	return namespace{Tenant: tenant, Bucket: bucket, Prefix: tenantKeyPrefix + tenant + "/"}
+}
+
+// namespaceOfKey finds the namespace a raw key in the default bucket belongs to.
+func namespaceOfKey(key string) namespace {
+	if tenancyEnabled() {
+		if rest, ok := strings.CutPrefix(key, tenantKeyPrefix); ok {
+			if tenant, _, ok := strings.Cut(rest, "/"); ok && validTenantID.MatchString(tenant) {
+				return sharedNamespace(tenant, bucketName)
+			}
+		}
+	}
+	return rootNamespace()
+}
+
+// knownTenants lists every tenant with an API key or a dedicated bucket, or
+// onboarded through the registry. JWT
+// and OIDC tenants aren't configured up front, so they are found from the
+// tenant prefixes in the files bucket.
+func knownTenants(ctx context.Context) ([]string, error) {
+	seen := map[string]bool{}
+	for _, tenant := range currentSetting(&apiKeys) {
+		seen[tenant] = true
+	}
+	for tenant := range tenantBuckets {
+		seen[tenant] = true
+	}
+	registered, err := registeredTenantIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, tenant := range registered {
+		seen[tenant] = true
+	}
+
+	if jwtEnabled() || oidcEnabled() {
+		paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+			Bucket:    aws.String(bucketName),
+			Prefix:    aws.String(tenantKeyPrefix),
+			Delimiter: aws.String("/"),
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, err
+			}
+			for _, p := range page.CommonPrefixes {
+				tenant := strings.TrimSuffix(strings.TrimPrefix(aws.ToString(p.Prefix), tenantKeyPrefix), "/")
+				if validTenantID.MatchString(tenant) {
+					seen[tenant] = true
+				}
+			}
+		}
+	}
+
+	tenants := make([]string, 0, len(seen))
+	for tenant := range seen {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+	return tenants, nil
+}
+
+// tenantNamespaces lists every namespace of tenant: its own, plus its share of
+// each named bucket.
+func tenantNamespaces(tenant string) []namespace {
+	namespaces := []namespace{namespaceFor(tenant)}
+	for _, name := range namedBucketNames() {
+		namespaces = append(namespaces, namespaceInBucket(tenant, namedBuckets[name]))
+	}
+	return namespaces
+}
+
+// knownNamespaces lists every namespace background workers need to visit.
+func knownNamespaces(ctx context.Context) ([]namespace, error) {
+	if !tenancyEnabled() {
+		return tenantNamespaces(defaultTenant), nil
+	}
+
+	tenants, err := knownTenants(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var namespaces []namespace
+	for _, tenant := range tenants {
+		namespaces = append(namespaces, tenantNamespaces(tenant)...)
+	}
+	return namespaces, nil
+}
+
+// knownBuckets lists every configured bucket, default first.
+func knownBuckets() []string {
+	buckets := []string{bucketName}
+	for _, tenant := range sortedKeys(tenantBuckets) {
+		if !contains(buckets, tenantBuckets[tenant]) {
+			buckets = append(buckets, tenantBuckets[tenant])
+		}
+	}
+	for _, name := range namedBucketNames() {
+		if !contains(buckets, namedBuckets[name]) {
+			buckets = append(buckets, namedBuckets[name])
+		}
+	}
+	return buckets
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+type namespaceKey struct{}
+
+func withNamespace(ctx context.Context, ns namespace) context.Context {
+	return context.WithValue(ctx, namespaceKey{}, ns)
+}
+
+// namespaceFrom returns the namespace carried by ctx, or the root namespace
+// when there is none.
+func namespaceFrom(ctx context.Context) namespace {
+	if ns, ok := ctx.Value(namespaceKey{}).(namespace); ok {
+		return ns
+	}
+	return rootNamespace()
+}
+
+// bucketFor returns the bucket storage calls made with ctx should target.
+func bucketFor(ctx context.Context) string {
+	return namespaceFrom(ctx).Bucket
+}
+
+func requestNamespace(r *http.Request) namespace {
+	return namespaceFrom(r.Context())
+}
+
+// requestTenant identifies the tenant a request acts for.
+func requestTenant(r *http.Request) string {
+	return requestNamespace(r).Tenant
+}
+
+// requestCredential returns the API key or JWT the request carries, as a
+// bearer token or, for API keys, in X-API-Key or as a Basic auth password,
+// which is all build tools like Gradle can send.
+func requestCredential(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+	key, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return key
+}
+
+func tenantForKey(key string) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+	// Compare against every key so timing doesn't reveal how close a guess was
+	var tenant string
+	for candidate, t := range currentSetting(&apiKeys) {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(candidate)) == 1 {
+			tenant = t
+		}
+	}
+	return tenant, tenant != ""
+}
+
+// authenticate resolves the request's credential to a principal. Browsers
+// signed in through OIDC send no credential, only the session cookie.
+func authenticate(r *http.Request) (principal, error) {
+	credential := requestCredential(r)
+	if credential == "" && oidcEnabled() {
+		if p, ok := sessionPrincipal(r); ok {
+			return p, nil
+		}
+	}
+	if jwtEnabled() && looksLikeJWT(credential) {
+		return jwtPrincipal(r.Context(), credential)
+	}
+	if tenant, ok := tenantForKey(credential); ok {
+		return principal{Subject: tenant, Tenant: tenant, Method: "api_key"}, nil
+	}
+	if tenant, ok, err := registeredTenants.tenantForKey(r.Context(), credential); err != nil {
+		return principal{}, fmt.Errorf("checking tenant registry: %w", err)
+	} else if ok {
+		return principal{Subject: tenant, Tenant: tenant, Method: "api_key"}, nil
+	}
+	if credential == "" {
+		return principal{}, errors.New("no credentials")
+	}
+	return principal{}, errors.New("unrecognized credentials")
+}
+
+// tenantMiddleware authenticates the request's API key, JWT or session and scopes the
+// rest of the request to that tenant's namespace.
+func tenantMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !tenancyEnabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		p, err := authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			respondJSON(w, http.StatusUnauthorized, ErrorResponse{
+				Error:   "Valid API key or token required",
+				Details: err.Error(),
+			})
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), principalKey{}, p)
+		setLogPrincipal(ctx, requestSubject(r.WithContext(ctx)))
+		next.ServeHTTP(w, r.WithContext(withNamespace(ctx, namespaceFor(p.Tenant))))
+	})
+}
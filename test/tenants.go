@@ -0,0 +1,693 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+)
+
+const (
+	tenantRegistryPrefix = ".tenants/"
+	tenantRegistryKey    = tenantRegistryPrefix + "registry.json"
+
+	tenantProvisioning = "provisioning"
+	tenantActive       = "active"
+	tenantOffboarding  = "offboarding"
+	tenantOffboarded   = "offboarded"
+	tenantFailed       = "failed"
+
+	maxTenantKeys        = 10
+	tenantUpdateAttempts = 5
+)
+
+var (
+	// Tenants can be onboarded through the admin API once TENANT_REGISTRY is
+	// set. They sit alongside any configured with API_KEYS.
+	tenantRegistryEnabled, _ = strconv.ParseBool(os.Getenv("TENANT_REGISTRY"))
+
+	// Other instances see onboarded tenants and revoked keys within this long
+	tenantReloadInterval = durationFromEnv("TENANT_RELOAD_INTERVAL", 30*time.Second)
+
+	// Offboarding copies a tenant's files here before purging them
+	tenantExportBucket = os.Getenv("TENANT_EXPORT_BUCKET")
+
+	registeredTenants = &tenantRegistry{}
+)
+
+// TenantRecord is a tenant onboarded through the admin API. Its API keys are
+// only kept as hashes; the keys themselves are shown once, on creation.
+type TenantRecord struct {
+	ID                      string         `json:"id"`
+	Status                  string         `json:"status"`
+	Bucket                  string         `json:"bucket,omitempty"`
+	Prefix                  string         `json:"prefix,omitempty"`
+	QuotaBytes              *int64         `json:"quota_bytes,omitempty"`
+	MaxObjectBytes          *int64         `json:"max_object_bytes,omitempty"`
+	MaxMultipartObjectBytes *int64         `json:"max_multipart_object_bytes,omitempty"`
+	Keys                    []TenantKey    `json:"keys"`
+	Webhook                 *TenantWebhook `json:"webhook,omitempty"`
+	CreatedAt               string         `json:"created_at"`
+	UpdatedAt               string         `json:"updated_at,omitempty"`
+}
+
+type TenantKey struct {
+	ID        string `json:"id"`
+	SHA256    string `json:"sha256"`
+	CreatedAt string `json:"created_at"`
+}
+
+// TenantWebhook is where the tenant wants to be told about changes to its
+// files.
+type TenantWebhook struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events,omitempty"`
+	Secret string   `json:"secret,omitempty"`
+}
+
+type CreateTenantRequest struct {
+	ID                      string         `json:"id"`
+	Bucket                  string         `json:"bucket,omitempty"`
+	QuotaBytes              *int64         `json:"quota_bytes,omitempty"`
+	MaxObjectBytes          *int64         `json:"max_object_bytes,omitempty"`
+	MaxMultipartObjectBytes *int64         `json:"max_multipart_object_bytes,omitempty"`
+	Keys                    *int           `json:"keys,omitempty"`
+	Webhook                 *TenantWebhook `json:"webhook,omitempty"`
+}
+
+type OffboardTenantRequest struct {
+	Export *bool `json:"export,omitempty"`
+	Purge  *bool `json:"purge,omitempty"`
+}
+
+type TenantJobResponse struct {
+	Tenant  TenantRecord `json:"tenant"`
+	APIKeys []string     `json:"api_keys,omitempty"`
+	Job     *Job         `json:"job"`
+}
+
+type TenantsResponse struct {
+	Tenants []TenantRecord `json:"tenants"`
+}
+
+// tenantRegistry keeps onboarded tenants in one object in the files bucket,
+// cached by each instance like the policy rules.
+type tenantRegistry struct {
+	mu        sync.Mutex
+	records   map[string]TenantRecord
+	etag      string
+	checkedAt time.Time
+}
+
+// current returns the registered tenants, reloading them if they may be stale.
+func (g *tenantRegistry) current(ctx context.Context) (map[string]TenantRecord, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.checkedAt.IsZero() && time.Since(g.checkedAt) < tenantReloadInterval {
+		return g.records, nil
+	}
+
+	head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(tenantRegistryKey),
+	})
+	switch {
+	case isNotFound(err):
+		g.records, g.etag = nil, ""
+	case err != nil:
+		return nil, err
+	case aws.ToString(head.ETag) != g.etag:
+		records, etag, err := readTenantRegistry(ctx)
+		if err != nil {
+			return nil, err
+		}
+		g.records, g.etag = records, etag
+	}
+	g.checkedAt = time.Now()
+	return g.records, nil
+}
+
+// cached looks a tenant up without going to storage. Requests have loaded the
+// registry while authenticating by the time they need this.
+func (g *tenantRegistry) cached(id string) (TenantRecord, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	record, ok := g.records[id]
+	return record, ok
+}
+
+func readTenantRegistry(ctx context.Context) (map[string]TenantRecord, string, error) {
+	result, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(tenantRegistryKey),
+	})
+	if isNotFound(err) {
+		return map[string]TenantRecord{}, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	defer result.Body.Close()
+
+	var list []TenantRecord
+	if err := json.NewDecoder(result.Body).Decode(&list); err != nil {
+		return nil, "", fmt.Errorf("decoding %s: %w", tenantRegistryKey, err)
+	}
+	records := make(map[string]TenantRecord, len(list))
+	for _, record := range list {
+		records[record.ID] = record
+	}
+	return records, aws.ToString(result.ETag), nil
+}
+
+// update applies change to the stored registry with a conditional write, so
+// concurrent changes retry rather than overwrite each other.
+func (g *tenantRegistry) update(ctx context.Context, change func(map[string]TenantRecord) error) error {
+	for attempt := 0; attempt < tenantUpdateAttempts; attempt++ {
+		records, etag, err := readTenantRegistry(ctx)
+		if err != nil {
+			return err
+		}
+		if err := change(records); err != nil {
+			return err
+		}
+
+		list := make([]TenantRecord, 0, len(records))
+		for _, record := range records {
+			list = append(list, record)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+		body, err := json.MarshalIndent(list, "", "  ")
+		if err != nil {
+			return err
+		}
+		input := &s3.PutObjectInput{
+			Bucket:      aws.String(bucketName),
+			Key:         aws.String(tenantRegistryKey),
+			Body:        bytes.NewReader(body),
+			ContentType: aws.String("application/json"),
+		}
+		if etag == "" {
+			input.IfNoneMatch = aws.String("*")
+		} else {
+			input.IfMatch = aws.String(etag)
+		}
+		result, err := s3Client.PutObject(ctx, input)
+		if isPreconditionFailed(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		g.mu.Lock()
+		g.records, g.etag, g.checkedAt = records, aws.ToString(result.ETag), time.Now()
+		g.mu.Unlock()
+		return nil
+	}
+	return fmt.Errorf("tenant registry kept changing; gave up after %d attempts", tenantUpdateAttempts)
+}
+
+// setStatus moves a tenant on to status, applying change too if given.
+func (g *tenantRegistry) setStatus(ctx context.Context, id, status string, change func(*TenantRecord)) error {
+	return g.update(ctx, func(records map[string]TenantRecord) error {
+		record, ok := records[id]
+		if !ok {
+			return errTenantNotFound
+		}
+		record.Status = status
+		record.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+		if change != nil {
+			change(&record)
+		}
+		records[id] = record
+		return nil
+	})
+}
+
+// tenantForKey finds the active tenant whose API key hashes to key's hash.
+func (g *tenantRegistry) tenantForKey(ctx context.Context, key string) (string, bool, error) {
+	if !tenantRegistryEnabled || key == "" {
+		return "", false, nil
+	}
+	records, err := g.current(ctx)
+	if err != nil {
+		return "", false, err
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	hash := []byte(hex.EncodeToString(sum[:]))
+	var tenant string
+	for _, record := range records {
+		if record.Status != tenantActive {
+			continue
+		}
+		for _, k := range record.Keys {
+			if subtle.ConstantTimeCompare(hash, []byte(k.SHA256)) == 1 {
+				tenant = record.ID
+			}
+		}
+	}
+	return tenant, tenant != "", nil
+}
+
+var errTenantNotFound = errors.New("tenant not found")
+
+// newTenantKey returns a fresh API key and the record of it that is stored.
+func newTenantKey(now time.Time) (string, TenantKey) {
+	b := make([]byte, 24)
+	rand.Read(b)
+	key := hex.EncodeToString(b)
+	sum := sha256.Sum256([]byte(key))
+	hash := hex.EncodeToString(sum[:])
+	return key, TenantKey{ID: hash[:12], SHA256: hash, CreatedAt: now.Format(time.RFC3339)}
+}
+
+// configuredTenant reports whether id is already configured outside the
+// registry, through API_KEYS or TENANT_BUCKETS.
+func configuredTenant(id string) bool {
+	if _, ok := tenantBuckets[id]; ok {
+		return true
+	}
+	for _, tenant := range currentSetting(&apiKeys) {
+		if tenant == id {
+			return true
+		}
+	}
+	return false
+}
+
+func validateCreateTenant(req *CreateTenantRequest) error {
+	if req.Keys == nil {
+		one := 1
+		req.Keys = &one
+	}
+	switch {
+	case !validTenantID.MatchString(req.ID):
+		return fmt.Errorf("id may only contain letters, digits, - and _")
+	case configuredTenant(req.ID):
+		return fmt.Errorf("tenant %s is configured through the environment", req.ID)
+	case *req.Keys < 0 || *req.Keys > maxTenantKeys:
+		return fmt.Errorf("keys must be between 0 and %d", maxTenantKeys)
+	case req.QuotaBytes != nil && *req.QuotaBytes < 0:
+		return fmt.Errorf("quota_bytes must not be negative")
+	case req.MaxObjectBytes != nil && *req.MaxObjectBytes < 0:
+		return fmt.Errorf("max_object_bytes must not be negative")
+	case req.MaxMultipartObjectBytes != nil && *req.MaxMultipartObjectBytes < 0:
+		return fmt.Errorf("max_multipart_object_bytes must not be negative")
+	}
+	if req.Webhook != nil {
+		u, err := url.Parse(req.Webhook.URL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("webhook url must be an absolute http or https URL")
+		}
+	}
+	return nil
+}
+
+// redactedTenant is record as the admin API shows it, without the webhook
+// signing secret.
+func redactedTenant(record TenantRecord) TenantRecord {
+	if record.Webhook != nil && record.Webhook.Secret != "" {
+		webhook := *record.Webhook
+		webhook.Secret = redacted
+		record.Webhook = &webhook
+	}
+	if record.Keys == nil {
+		record.Keys = []TenantKey{}
+	}
+	return record
+}
+
+func requireTenantRegistry(w http.ResponseWriter) bool {
+	if !tenantRegistryEnabled {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{
+			Error:   "Tenant registry is disabled",
+			Details: "set TENANT_REGISTRY=true to onboard tenants through the admin API",
+		})
+		return false
+	}
+	return true
+}
+
+func respondTenantError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errTenantNotFound) {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{
+			Error: "Tenant not found",
+		})
+		return
+	}
+	respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+		Error:   "Failed to update tenant registry",
+		Details: err.Error(),
+	})
+}
+
+func listTenantsHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireTenantRegistry(w) {
+		return
+	}
+	records, _, err := readTenantRegistry(r.Context())
+	if err != nil {
+		respondTenantError(w, err)
+		return
+	}
+	response := TenantsResponse{Tenants: []TenantRecord{}}
+	for _, id := range sortedTenantIDs(records) {
+		response.Tenants = append(response.Tenants, redactedTenant(records[id]))
+	}
+	respondJSON(w, http.StatusOK, response)
+}
+
+func sortedTenantIDs(records map[string]TenantRecord) []string {
+	ids := make([]string, 0, len(records))
+	for id := range records {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func getTenantHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireTenantRegistry(w) {
+		return
+	}
+	records, _, err := readTenantRegistry(r.Context())
+	if err != nil {
+		respondTenantError(w, err)
+		return
+	}
+	record, ok := records[mux.Vars(r)["id"]]
+	if !ok {
+		respondTenantError(w, errTenantNotFound)
+		return
+	}
+	respondJSON(w, http.StatusOK, redactedTenant(record))
+}
+
+// createTenantHandler registers the tenant and issues its keys straight
+// away, then provisions it in a job. The keys work once the job has made the
+// tenant active.
+func createTenantHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireTenantRegistry(w) {
+		return
+	}
+	var req CreateTenantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondInvalidBody(w, err)
+		return
+	}
+	if err := validateCreateTenant(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid tenant",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	now := time.Now().UTC()
+	record := TenantRecord{
+		ID:                      req.ID,
+		Status:                  tenantProvisioning,
+		Bucket:                  req.Bucket,
+		QuotaBytes:              req.QuotaBytes,
+		MaxObjectBytes:          req.MaxObjectBytes,
+		MaxMultipartObjectBytes: req.MaxMultipartObjectBytes,
+		Webhook:                 req.Webhook,
+		CreatedAt:               now.Format(time.RFC3339),
+	}
+	if record.Bucket == "" {
+		record.Prefix = sharedNamespace(req.ID, bucketName).Prefix
+	}
+	var keys []string
+	for i := 0; i < *req.Keys; i++ {
+		key, stored := newTenantKey(now)
+		keys = append(keys, key)
+		record.Keys = append(record.Keys, stored)
+	}
+
+	errTaken := errors.New("tenant exists")
+	err := registeredTenants.update(r.Context(), func(records map[string]TenantRecord) error {
+		if _, ok := records[req.ID]; ok {
+			return errTaken
+		}
+		records[req.ID] = record
+		return nil
+	})
+	if errors.Is(err, errTaken) {
+		respondJSON(w, http.StatusConflict, ErrorResponse{
+			Error:   "Tenant already exists",
+			Details: "offboarded tenants keep their id, so pick another",
+		})
+		return
+	}
+	if err != nil {
+		respondTenantError(w, err)
+		return
+	}
+
+	job := startJob(withNamespace(context.Background(), namespaceFor(req.ID)), "onboard", onboardTenant)
+	respondJSON(w, http.StatusAccepted, TenantJobResponse{
+		Tenant:  redactedTenant(record),
+		APIKeys: keys,
+		Job:     job.snapshot(),
+	})
+}
+
+// onboardTenant checks the tenant's storage can be reached before letting
+// its keys in.
+func onboardTenant(ctx context.Context, job *Job) (result map[string]string, err error) {
+	ns := namespaceFrom(ctx)
+	defer func() {
+		if err != nil {
+			registeredTenants.setStatus(ctx, ns.Tenant, tenantFailed, nil)
+		}
+	}()
+
+	if _, err := s3Client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(ns.Bucket)}); err != nil {
+		return nil, fmt.Errorf("checking bucket %s: %w", ns.Bucket, err)
+	}
+	job.addProgress(1)
+
+	if err := registeredTenants.setStatus(ctx, ns.Tenant, tenantActive, nil); err != nil {
+		return nil, err
+	}
+	job.addProgress(1)
+	return map[string]string{"tenant": ns.Tenant, "status": tenantActive}, nil
+}
+
+// offboardTenantHandler revokes the tenant's keys before answering, so it is
+// locked out even while its files are still being exported and purged.
+func offboardTenantHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireTenantRegistry(w) {
+		return
+	}
+	var req OffboardTenantRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondInvalidBody(w, err)
+			return
+		}
+	}
+	export := req.Export == nil || *req.Export
+	purge := req.Purge == nil || *req.Purge
+	if export && tenantExportBucket == "" {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "Tenant exports are not configured",
+			Details: "set TENANT_EXPORT_BUCKET, or pass \"export\": false",
+		})
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	var record TenantRecord
+	if err := registeredTenants.setStatus(r.Context(), id, tenantOffboarding, func(t *TenantRecord) {
+		t.Keys = nil
+		record = *t
+	}); err != nil {
+		respondTenantError(w, err)
+		return
+	}
+
+	params := offboardParams{Export: export, Purge: purge}
+	job, existing, err := startDurableJob(withNamespace(context.Background(), namespaceFor(id)), "offboard", params, r.Header.Get(idempotencyHeader))
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to start offboarding",
+			Details: err.Error(),
+		})
+		return
+	}
+	status := http.StatusAccepted
+	if existing {
+		status = http.StatusOK
+	}
+	respondJSON(w, status, TenantJobResponse{
+		Tenant: redactedTenant(record),
+		Job:    job.snapshot(),
+	})
+}
+
+var _ = registerDurableJob("offboard", offboardTenant)
+
+type offboardParams struct {
+	Export bool `json:"export"`
+	Purge  bool `json:"purge"`
+}
+
+// offboardCheckpoint records the buckets an offboarding has finished with,
+// and the files of the current one it has already exported.
+type offboardCheckpoint struct {
+	Done     []string `json:"done,omitempty"`
+	Exported []string `json:"exported,omitempty"`
+	Counted  struct {
+		Exported int `json:"exported"`
+		Purged   int `json:"purged"`
+	} `json:"counted"`
+}
+
+// offboardTenant hands the tenant's files back and removes them from every
+// bucket it has files in. The tenant stays registered as offboarded, so its
+// id isn't reused by someone else. Exports are copies, so a resumed job
+// copying a file again is harmless, but it skips the ones checkpointed.
+func offboardTenant(ctx context.Context, job *Job) (map[string]string, error) {
+	var params offboardParams
+	if err := job.decodeParams(&params); err != nil {
+		return nil, err
+	}
+	var cp offboardCheckpoint
+	if _, err := job.resumeFrom(&cp); err != nil {
+		return nil, err
+	}
+	tenant := namespaceFrom(ctx).Tenant
+	result := map[string]string{"tenant": tenant}
+	if params.Export {
+		result["export"] = "s3://" + tenantExportBucket + "/" + tenant + "/" + job.ID + "/"
+	}
+
+	done := map[string]bool{}
+	for _, bucket := range cp.Done {
+		done[bucket] = true
+	}
+	for _, ns := range tenantNamespaces(tenant) {
+		if done[ns.Bucket] {
+			continue
+		}
+		nsCtx := withNamespace(ctx, ns)
+		keys, err := listPrefix(nsCtx, ns.Prefix)
+		if err != nil {
+			return nil, err
+		}
+
+		if params.Export {
+			copied := map[string]bool{}
+			for _, key := range cp.Exported {
+				copied[key] = true
+			}
+			// Bookkeeping such as trash and ACL markers stays behind
+			var files []string
+			for _, key := range keys {
+				if !isReservedKey(ns.name(key)) && !copied[key] {
+					files = append(files, key)
+				}
+			}
+			destination := tenant + "/" + job.ID + "/" + ns.Bucket + "/"
+			var mu sync.Mutex
+			if err := fanOut(ctx, len(files), func(ctx context.Context, i int) error {
+				_, err := s3Client.CopyObject(ctx, &s3.CopyObjectInput{
+					Bucket:     aws.String(tenantExportBucket),
+					Key:        aws.String(destination + ns.name(files[i])),
+					CopySource: aws.String(ns.Bucket + "/" + url.PathEscape(files[i])),
+				})
+				if err != nil {
+					return fmt.Errorf("exporting %s: %w", files[i], err)
+				}
+				job.addProgress(1)
+				mu.Lock()
+				defer mu.Unlock()
+				cp.Exported = append(cp.Exported, files[i])
+				cp.Counted.Exported++
+				return job.saveCheckpoint(ctx, cp, false)
+			}); err != nil {
+				return nil, err
+			}
+		}
+
+		if params.Purge {
+			failures, err := deleteKeys(nsCtx, keys)
+			if err != nil {
+				return nil, err
+			}
+			if len(failures) > 0 {
+				return nil, fmt.Errorf("%d objects could not be purged, e.g. %s: %s", len(failures), failures[0].Key, failures[0].Error)
+			}
+			job.addProgress(int64(len(keys)))
+			cp.Counted.Purged += len(keys)
+		}
+
+		cp.Done, cp.Exported = append(cp.Done, ns.Bucket), nil
+		if err := job.saveCheckpoint(ctx, cp, true); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := registeredTenants.setStatus(ctx, tenant, tenantOffboarded, nil); err != nil {
+		return nil, err
+	}
+	result["exported"] = strconv.Itoa(cp.Counted.Exported)
+	result["purged"] = strconv.Itoa(cp.Counted.Purged)
+	return result, nil
+}
+
+func getJobHandler(w http.ResponseWriter, r *http.Request) {
+	job, err := findJob(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to read job",
+			Details: err.Error(),
+		})
+		return
+	}
+	if job == nil {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{
+			Error: "Job not found",
+		})
+		return
+	}
+	respondJSON(w, http.StatusOK, job.snapshot())
+}
+
+// registeredTenantIDs lists tenants onboarded through the registry that
+// haven't been offboarded.
+func registeredTenantIDs(ctx context.Context) ([]string, error) {
+	if !tenantRegistryEnabled {
+		return nil, nil
+	}
+	records, err := registeredTenants.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, id := range sortedTenantIDs(records) {
+		if records[id].Status != tenantOffboarded {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// enableTenantRegistry turns on onboarding and returns the admin credentials.
+func enableTenantRegistry(t *testing.T) []string {
+	t.Helper()
+	override(t, &tenantRegistryEnabled, true)
+	override(t, &registeredTenants, &tenantRegistry{})
+	override(t, &adminToken, "admin-secret")
+	return []string{"Authorization", "Bearer admin-secret"}
+}
+
+// waitJob polls the admin API until the job has finished.
+func waitJob(t *testing.T, srv *httptest.Server, id string) *Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job := &Job{}
+		call(t, srv, "GET", "/api/admin/jobs/"+id, nil, "Authorization", "Bearer admin-secret").decode(t, job)
+		if job.Status == jobSucceeded || job.Status == jobFailed {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s still %s", id, job.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTenantLifecycle(t *testing.T) {
+	admin := enableTenantRegistry(t)
+	srv, fake := newTestServer(t)
+	fake.AddBucket("tenant-exports")
+	override(t, &tenantExportBucket, "tenant-exports")
+
+	quota := int64(10)
+	var created TenantJobResponse
+	resp := call(t, srv, "POST", "/api/admin/tenants", CreateTenantRequest{
+		ID:         "acme",
+		QuotaBytes: &quota,
+		Webhook:    &TenantWebhook{URL: "https://hooks.example.com/acme", Secret: "s3cret"},
+	}, admin...)
+	expectStatus(t, resp, http.StatusAccepted)
+	resp.decode(t, &created)
+	if len(created.APIKeys) != 1 || created.Tenant.Status != tenantProvisioning || created.Tenant.Webhook.Secret != redacted {
+		t.Fatalf("created %+v", created)
+	}
+	if job := waitJob(t, srv, created.Job.ID); job.Status != jobSucceeded {
+		t.Fatalf("onboarding %s: %s", job.Status, job.Error)
+	}
+
+	key := []string{"X-API-Key", created.APIKeys[0]}
+	mustUpload := func(name, content string) {
+		t.Helper()
+		expectStatus(t, call(t, srv, "POST", "/api/upload", upload(name, content), key...), http.StatusOK)
+	}
+	mustUpload("reports/q3.txt", "numbers")
+	if _, _, ok := fake.Object(bucketName, "tenants/acme/reports/q3.txt"); !ok {
+		t.Fatal("upload was not stored under the tenant prefix")
+	}
+	// The quota given at onboarding applies
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("big.txt", "more than ten bytes"), key...), http.StatusRequestEntityTooLarge)
+
+	expectStatus(t, call(t, srv, "POST", "/api/admin/tenants", CreateTenantRequest{ID: "acme"}, admin...), http.StatusConflict)
+
+	var offboarded TenantJobResponse
+	resp = call(t, srv, "POST", "/api/admin/tenants/acme/offboard", nil, admin...)
+	expectStatus(t, resp, http.StatusAccepted)
+	resp.decode(t, &offboarded)
+	// Locked out before the job has done anything
+	expectStatus(t, call(t, srv, "GET", "/api/files", nil, key...), http.StatusUnauthorized)
+
+	job := waitJob(t, srv, offboarded.Job.ID)
+	if job.Status != jobSucceeded || job.Result["exported"] != "1" || job.Result["purged"] != "1" {
+		t.Fatalf("offboarding %s: %s %v", job.Status, job.Error, job.Result)
+	}
+	if _, _, ok := fake.Object("tenant-exports", "acme/"+job.ID+"/"+bucketName+"/reports/q3.txt"); !ok {
+		t.Fatalf("export missing; have %v", fake.Keys("tenant-exports"))
+	}
+	if _, _, ok := fake.Object(bucketName, "tenants/acme/reports/q3.txt"); ok {
+		t.Fatal("file was not purged")
+	}
+
+	var record TenantRecord
+	call(t, srv, "GET", "/api/admin/tenants/acme", nil, admin...).decode(t, &record)
+	if record.Status != tenantOffboarded || len(record.Keys) != 0 {
+		t.Fatalf("offboarded tenant %+v", record)
+	}
+}
+
+func TestTenantValidation(t *testing.T) {
+	admin := enableTenantRegistry(t)
+	srv, _ := newTestServer(t)
+	override(t, &apiKeys, map[string]string{"globex-key": "globex"})
+	tooMany := maxTenantKeys + 1
+
+	for name, req := range map[string]CreateTenantRequest{
+		"bad id":          {ID: "no/slashes"},
+		"configured":      {ID: "globex"},
+		"too many keys":   {ID: "acme", Keys: &tooMany},
+		"bad webhook url": {ID: "acme", Webhook: &TenantWebhook{URL: "ftp://example.com"}},
+	} {
+		if resp := call(t, srv, "POST", "/api/admin/tenants", req, admin...); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: got %d", name, resp.StatusCode)
+		}
+	}
+	expectStatus(t, call(t, srv, "POST", "/api/admin/tenants/nobody/offboard", OffboardTenantRequest{Export: new(bool)}, admin...), http.StatusNotFound)
+	// Exporting needs somewhere to put the files
+	expectStatus(t, call(t, srv, "POST", "/api/admin/tenants/nobody/offboard", nil, admin...), http.StatusBadRequest)
+
+	// A dedicated bucket that can't be reached fails onboarding
+	var created TenantJobResponse
+	call(t, srv, "POST", "/api/admin/tenants", CreateTenantRequest{ID: "initech", Bucket: "missing-bucket"}, admin...).decode(t, &created)
+	if job := waitJob(t, srv, created.Job.ID); job.Status != jobFailed {
+		t.Fatalf("onboarding %s: %s", job.Status, job.Error)
+	}
+	expectStatus(t, call(t, srv, "GET", "/api/files", nil, "X-API-Key", created.APIKeys[0]), http.StatusUnauthorized)
+}
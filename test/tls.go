@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// Serve HTTPS directly, for deployments with no load balancer to
+	// terminate TLS. The certificate and key are PEM files, re-read when
+	// they change so a renewed certificate is picked up without a restart.
+	tlsCertFile = os.Getenv("TLS_CERT_FILE")
+	tlsKeyFile  = os.Getenv("TLS_KEY_FILE")
+
+	// Or get certificates for these host names from Let's Encrypt, cached in
+	// TLS_AUTOCERT_CACHE_DIR. Needs a build with -tags autocert.
+	tlsAutocertDomains = splitList(os.Getenv("TLS_AUTOCERT_DOMAINS"))
+
+	// Clients must present a certificate signed by a CA in this bundle,
+	// unless TLS_CLIENT_AUTH=optional, which only verifies the ones given.
+	tlsClientCAFile = os.Getenv("TLS_CLIENT_CA_FILE")
+	tlsClientAuth   = envOr("TLS_CLIENT_AUTH", "require")
+
+	// How often the certificate files are checked for changes
+	tlsReloadInterval = durationFromEnv("TLS_RELOAD_INTERVAL", time.Minute)
+)
+
+// newAutocertConfig is set by builds with ACME support.
+var newAutocertConfig func(domains []string) (*tls.Config, error)
+
+func registerAutocert(open func(domains []string) (*tls.Config, error)) bool {
+	newAutocertConfig = open
+	return true
+}
+
+// serverTLSConfig is the TLS configuration the server listens with, or nil
+// to serve plain HTTP.
+func serverTLSConfig() (*tls.Config, error) {
+	var config *tls.Config
+	switch {
+	case tlsCertFile != "" && len(tlsAutocertDomains) > 0:
+		return nil, errors.New("set either TLS_CERT_FILE or TLS_AUTOCERT_DOMAINS, not both")
+	case tlsCertFile != "" || tlsKeyFile != "":
+		if tlsCertFile == "" || tlsKeyFile == "" {
+			return nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+		}
+		certs, err := newCertReloader(tlsCertFile, tlsKeyFile, tlsReloadInterval)
+		if err != nil {
+			return nil, err
+		}
+		config = &tls.Config{GetCertificate: certs.getCertificate}
+	case len(tlsAutocertDomains) > 0:
+		if newAutocertConfig == nil {
+			return nil, errors.New("TLS_AUTOCERT_DOMAINS is not supported by this build")
+		}
+		var err error
+		if config, err = newAutocertConfig(tlsAutocertDomains); err != nil {
+			return nil, err
+		}
+	case tlsClientCAFile != "":
+		return nil, errors.New("TLS_CLIENT_CA_FILE needs TLS_CERT_FILE or TLS_AUTOCERT_DOMAINS")
+	default:
+		return nil, nil
+	}
+	config.MinVersion = tls.VersionTLS12
+
+	if tlsClientCAFile != "" {
+		pool, err := loadCertPool(tlsClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		switch tlsClientAuth {
+		case "require":
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		case "optional":
+			config.ClientAuth = tls.VerifyClientCertIfGiven
+		default:
+			return nil, fmt.Errorf("TLS_CLIENT_AUTH must be require or optional, not %q", tlsClientAuth)
+		}
+	}
+	return config, nil
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	bundle, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("%s has no PEM certificates", file)
+	}
+	return pool, nil
+}
+
+// certReloader serves a certificate from files, loading them again once
+// they have changed. A renewal that fails to load keeps the old
+// certificate in use.
+type certReloader struct {
+	certFile, keyFile string
+	interval          time.Duration
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	modified time.Time
+	checked  time.Time
+}
+
+func newCertReloader(certFile, keyFile string, interval time.Duration) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile, interval: interval}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *certReloader) load() error {
+	info, err := os.Stat(c.certFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.cert, c.modified = &cert, info.ModTime()
+	return nil
+}
+
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.checked) >= c.interval {
+		c.checked = time.Now()
+		if info, err := os.Stat(c.certFile); err == nil && !info.ModTime().Equal(c.modified) {
+			if err := c.load(); err != nil {
+				slog.Warn("Failed to reload TLS certificate; serving the previous one", "file", c.certFile, "err", err)
+			} else {
+				slog.Info("Reloaded TLS certificate", "file", c.certFile)
+			}
+		}
+	}
+	return c.cert, nil
+}
+
+// clientCertSubject names the verified client certificate a request came
+// with, if any.
+func clientCertSubject(state *tls.ConnectionState) string {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	subject := state.VerifiedChains[0][0].Subject
+	if subject.CommonName != "" {
+		return subject.CommonName
+	}
+	return strings.TrimSpace(subject.String())
+}
@@ -0,0 +1,25 @@
+//go:build autocert
+
+package main
+
+import (
+	"crypto/tls"
+	"os"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+var _ = registerAutocert(newAutocertTLSConfig)
+
+// newAutocertTLSConfig gets certificates from Let's Encrypt with the
+// TLS-ALPN-01 challenge, which is answered on the HTTPS port itself, so no
+// port 80 listener is needed.
+func newAutocertTLSConfig(domains []string) (*tls.Config, error) {
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Cache:      autocert.DirCache(envOr("TLS_AUTOCERT_CACHE_DIR", "autocert")),
+		Email:      os.Getenv("TLS_AUTOCERT_EMAIL"),
+	}
+	return manager.TLSConfig(), nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// issueCert signs a certificate for name with parent, or self-signs a CA
+// when parent is nil.
+func issueCert(t *testing.T, name string, parent *testCert, usage x509.ExtKeyUsage) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+func (c *testCert) writePEM(t *testing.T, certFile, keyFile string) {
+	t.Helper()
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if keyFile == "" {
+		return
+	}
+	der, _ := x509.MarshalECPrivateKey(c.key)
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := issueCert(t, "test CA", nil, x509.ExtKeyUsageAny)
+	server := issueCert(t, "api", ca, x509.ExtKeyUsageServerAuth)
+	client := issueCert(t, "ci-runner", ca, x509.ExtKeyUsageClientAuth)
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+	server.writePEM(t, certFile, keyFile)
+	ca.writePEM(t, caFile, "")
+
+	override(t, &tlsCertFile, certFile)
+	override(t, &tlsKeyFile, keyFile)
+	override(t, &tlsClientCAFile, caFile)
+	override(t, &tlsReloadInterval, 0)
+	config, err := serverTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Fatalf("client auth %v", config.ClientAuth)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, clientCertSubject(r.TLS))
+	})}
+	go srv.Serve(tls.NewListener(listener, config))
+	t.Cleanup(func() { srv.Close() })
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(certs ...tls.Certificate) (string, *x509.Certificate, error) {
+		transport := &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}
+		defer transport.CloseIdleConnections()
+		resp, err := (&http.Client{Transport: transport}).Get("https://" + listener.Addr().String() + "/")
+		if err != nil {
+			return "", nil, err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body), resp.TLS.PeerCertificates[0], nil
+	}
+
+	if _, _, err := get(); err == nil {
+		t.Fatal("served a client without a certificate")
+	}
+	stranger := issueCert(t, "stranger", issueCert(t, "other CA", nil, x509.ExtKeyUsageAny), x509.ExtKeyUsageClientAuth)
+	if _, _, err := get(stranger.tlsCertificate()); err == nil {
+		t.Fatal("served a client with a certificate from another CA")
+	}
+	subject, served, err := get(client.tlsCertificate())
+	if err != nil || subject != "ci-runner" || !served.Equal(server.cert) {
+		t.Fatalf("subject %q, err %v", subject, err)
+	}
+
+	// A renewed certificate is picked up by new connections
+	renewed := issueCert(t, "api", ca, x509.ExtKeyUsageServerAuth)
+	renewed.writePEM(t, certFile, keyFile)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	if _, served, err = get(client.tlsCertificate()); err != nil || !served.Equal(renewed.cert) {
+		t.Fatalf("still serving the old certificate: %v", err)
+	}
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	if config, err := serverTLSConfig(); config != nil || err != nil {
+		t.Fatalf("TLS without configuration: %v %v", config, err)
+	}
+
+	override(t, &tlsKeyFile, "tls.key")
+	if _, err := serverTLSConfig(); err == nil {
+		t.Fatal("accepted a key without a certificate")
+	}
+	override(t, &tlsKeyFile, "")
+	override(t, &tlsClientCAFile, "ca.crt")
+	if _, err := serverTLSConfig(); err == nil {
+		t.Fatal("accepted a client CA without a server certificate")
+	}
+	override(t, &tlsAutocertDomains, []string{"files.example.com"})
+	override(t, &newAutocertConfig, nil)
+	if _, err := serverTLSConfig(); err == nil {
+		t.Fatal("accepted autocert in a build without it")
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+)
+
+var (
+	// Public files at least this large can be fetched as torrents web-seeded
+	// by their public URL, so peers share the distribution bandwidth.
+	// TORRENT_TRACKERS lists announce URLs; without any, clients find peers
+	// through DHT and the web seed alone.
+	torrentMinBytes = int64(intFromEnv("TORRENT_MIN_BYTES", 64<<20))
+	torrentTrackers = splitList(os.Getenv("TORRENT_TRACKERS"))
+)
+
+// Hashing a large file takes a full read of it, so each file's info
+// dictionary is kept under torrentPrefix, tagged with the ETag it describes.
+const (
+	torrentPrefix   = ".torrents/"
+	metaTorrentETag = "source-etag"
+	metaInfoHash    = "info-hash"
+)
+
+// bencode encodes strings, byte strings, integers, lists and dictionaries
+// as BitTorrent does, with dictionary keys sorted.
+func bencode(buf *bytes.Buffer, v any) {
+	switch v := v.(type) {
+	case string:
+		fmt.Fprintf(buf, "%d:%s", len(v), v)
+	case []byte:
+		fmt.Fprintf(buf, "%d:", len(v))
+		buf.Write(v)
+	case int64:
+		fmt.Fprintf(buf, "i%de", v)
+	case []any:
+		buf.WriteByte('l')
+		for _, item := range v {
+			bencode(buf, item)
+		}
+		buf.WriteByte('e')
+	case map[string]any:
+		buf.WriteByte('d')
+		for _, key := range sortedKeys(v) {
+			bencode(buf, key)
+			bencode(buf, v[key])
+		}
+		buf.WriteByte('e')
+	case rawBencode:
+		buf.Write(v)
+	default:
+		panic(fmt.Sprintf("bencode: unsupported %T", v))
+	}
+}
+
+// rawBencode is already encoded, like a cached info dictionary.
+type rawBencode []byte
+
+// torrentPieceLength keeps torrents to around 2000 pieces: a power of two
+// from 256 KiB to 16 MiB.
+func torrentPieceLength(size int64) int64 {
+	length := int64(256 << 10)
+	for length < 16<<20 && size/length > 2000 {
+		length *= 2
+	}
+	return length
+}
+
+// torrentInfo hashes the file's content, as uploaded, into a bencoded info
+// dictionary.
+func torrentInfo(ctx context.Context, key string, etag *string) ([]byte, error) {
+	result, err := getObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucketName), Key: aws.String(key), IfMatch: etag})
+	if err != nil {
+		return nil, err
+	}
+	defer result.Body.Close()
+
+	size := aws.ToInt64(result.ContentLength)
+	pieceLength := torrentPieceLength(size)
+	var pieces []byte
+	var length int64
+	piece := make([]byte, pieceLength)
+	for {
+		n, err := io.ReadFull(result.Body, piece)
+		if n > 0 {
+			sum := sha1.Sum(piece[:n])
+			pieces = append(pieces, sum[:]...)
+			length += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var info bytes.Buffer
+	bencode(&info, map[string]any{
+		"length":       length,
+		"name":         path.Base(key),
+		"piece length": pieceLength,
+		"pieces":       pieces,
+	})
+	return info.Bytes(), nil
+}
+
+// cachedTorrentInfo returns the file's info dictionary, hashing the file
+// only when it changed since the last torrent was made.
+func cachedTorrentInfo(ctx context.Context, key string, etag *string) ([]byte, error) {
+	cacheKey := torrentPrefix + key
+	if cached, err := s3Client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucketName), Key: aws.String(cacheKey)}); err == nil {
+		defer cached.Body.Close()
+		if cached.Metadata[metaTorrentETag] == aws.ToString(etag) {
+			return io.ReadAll(cached.Body)
+		}
+	} else if !isNotFound(err) {
+		return nil, err
+	}
+
+	info, err := torrentInfo(ctx, key, etag)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum(info)
+	if _, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucketName),
+		Key:         aws.String(cacheKey),
+		Body:        bytes.NewReader(info),
+		ContentType: aws.String("application/x-bittorrent"),
+		Metadata:    map[string]string{metaTorrentETag: aws.ToString(etag), metaInfoHash: hex.EncodeToString(sum[:])},
+	}); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// magnetURI links to the same torrent without the file: its info hash, name,
+// size, web seed and trackers.
+func magnetURI(info []byte, name string, size int64, webSeed string) string {
+	sum := sha1.Sum(info)
+	query := url.Values{"dn": {name}, "xl": {strconv.FormatInt(size, 10)}, "ws": {webSeed}}
+	if len(torrentTrackers) > 0 {
+		query["tr"] = torrentTrackers
+	}
+	return "magnet:?xt=urn:btih:" + hex.EncodeToString(sum[:]) + "&" + query.Encode()
+}
+
+// publicTorrentHandler serves a .torrent of a large public file, web-seeded
+// by its public URL, with a magnet link in X-Magnet-URI. Files that aren't
+// public, or are too small to be worth sharing, have no torrent.
+func publicTorrentHandler(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["filename"]
+	noTorrent := func(details string) {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "Torrent not found", Details: details})
+	}
+	if key == "" || isReservedKey(key) {
+		noTorrent("")
+		return
+	}
+	head, err := s3Client.HeadObject(r.Context(), &s3.HeadObjectInput{Bucket: aws.String(bucketName), Key: aws.String(key)})
+	if err != nil && !isNotFound(err) {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to read file", Details: err.Error()})
+		return
+	}
+	if err != nil || fileVisibility(head.Metadata) != visibilityPublic {
+		noTorrent("")
+		return
+	}
+	size := aws.ToInt64(head.ContentLength)
+	if original, err := strconv.ParseInt(head.Metadata[metaOriginalSize], 10, 64); err == nil {
+		size = original
+	}
+	if size < torrentMinBytes {
+		noTorrent(fmt.Sprintf("torrents are made for public files of at least %d bytes", torrentMinBytes))
+		return
+	}
+
+	info, err := cachedTorrentInfo(r.Context(), key, head.ETag)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to build torrent", Details: err.Error()})
+		return
+	}
+
+	webSeed := requestBaseURL(r) + publicURL(key)
+	torrent := map[string]any{
+		"info":          rawBencode(info),
+		"url-list":      []any{webSeed},
+		"created by":    "test-api",
+		"creation date": aws.ToTime(head.LastModified).Unix(),
+	}
+	if len(torrentTrackers) > 0 {
+		torrent["announce"] = torrentTrackers[0]
+		tiers := make([]any, len(torrentTrackers))
+		for i, tracker := range torrentTrackers {
+			tiers[i] = []any{tracker}
+		}
+		torrent["announce-list"] = tiers
+	}
+	var body bytes.Buffer
+	bencode(&body, torrent)
+
+	enableCORS(w)
+	setCacheControl(w, "public", publicCacheTTL)
+	w.Header().Set("Content-Type", "application/x-bittorrent")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(key)+".torrent"))
+	w.Header().Set("X-Magnet-URI", magnetURI(info, path.Base(key), size, webSeed))
+	w.WriteHeader(http.StatusOK)
+	w.Write(body.Bytes())
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/gorilla/mux"
+)
+
+const trashPrefix = ".trash/"
+
+var (
+	softDeleteEnabled, _ = strconv.ParseBool(os.Getenv("SOFT_DELETE"))
+
+	trashRetention     = time.Duration(intFromEnv("TRASH_RETENTION_DAYS", 30)) * 24 * time.Hour
+	trashPurgeInterval = durationFromEnv("TRASH_PURGE_INTERVAL", time.Hour)
+
+	// Caps on the trash so soft delete can't silently double storage; 0 disables a cap
+	trashMaxBytes   = int64(intFromEnv("TRASH_MAX_BYTES", 0))
+	trashMaxObjects = intFromEnv("TRASH_MAX_OBJECTS", 0)
+)
+
+func intFromEnv(name string, fallback int) int {
+	if value := os.Getenv(name); value != "" {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+	}
+	return fallback
+}
+
+type TrashEntry struct {
+	Filename  string `json:"filename"`
+	Size      int64  `json:"size"`
+	DeletedAt string `json:"deleted_at"`
+	PurgeAt   string `json:"purge_at"`
+}
+
+type TrashResponse struct {
+	Items []TrashEntry `json:"items"`
+}
+
+func copyObject(ctx context.Context, from, to, etag string) error {
+	if encryptionKey != nil && sealedKey(from) != sealedKey(to) {
+		if copied, err := resealObject(ctx, from, to, etag); copied || err != nil {
+			if err == nil {
+				replicateObject(ctx, to)
+			}
+			return err
+		}
+	}
+	bucket := bucketFor(ctx)
+	input := &s3.CopyObjectInput{
+		Bucket:     aws.String(bucket),
+		Key:        aws.String(to),
+		CopySource: aws.String(fmt.Sprintf("%s/%s", bucket, url.PathEscape(from))),
+	}
+	if etag != "" {
+		input.CopySourceIfMatch = aws.String(etag)
+	}
+	_, err := s3Client.CopyObject(ctx, input)
+	if err != nil {
+		return err
+	}
+
+	replicateObject(ctx, to)
+	return nil
+}
+
+// trashKey is where key is kept once deleted: under the trash prefix of the
+// namespace it belongs to, so each tenant has a trash of its own.
+func trashKey(ctx context.Context, key string) string {
+	ns := namespaceFrom(ctx)
+	return ns.key(trashPrefix + ns.name(key))
+}
+
+// moveToTrash copies the object under the trash prefix before removing it. The
+// copy's LastModified doubles as the deletion time. A non-empty etag makes
+// both steps conditional on the object still having it.
+func moveToTrash(ctx context.Context, key, etag string) error {
+	if err := copyObject(ctx, key, trashKey(ctx, key), etag); err != nil {
+		return err
+	}
+
+	input := &s3.DeleteObjectInput{
+		Bucket: aws.String(bucketFor(ctx)),
+		Key:    aws.String(key),
+	}
+	if etag != "" {
+		input.IfMatch = aws.String(etag)
+	}
+	_, err := s3Client.DeleteObject(ctx, input)
+	if err != nil {
+		return err
+	}
+	replicateDeletion(ctx, key)
+
+	// The delete itself succeeded, so a failed eviction is only logged
+	if err := enforceTrashLimits(ctx); err != nil {
+		slog.WarnContext(ctx, "Failed to enforce trash limits", "err", err)
+	}
+	return nil
+}
+
+// listTrash returns every object in the trash of ctx's namespace, oldest first.
+func listTrash(ctx context.Context) ([]types.Object, error) {
+	var objects []types.Object
+
+	ns := namespaceFrom(ctx)
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(ns.Bucket),
+		Prefix: aws.String(ns.key(trashPrefix)),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, page.Contents...)
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		return aws.ToTime(objects[i].LastModified).Before(aws.ToTime(objects[j].LastModified))
+	})
+	return objects, nil
+}
+
+// enforceTrashLimits evicts the oldest tombstones until the trash fits within
+// the configured byte and object caps.
+func enforceTrashLimits(ctx context.Context) error {
+	if trashMaxBytes <= 0 && trashMaxObjects <= 0 {
+		return nil
+	}
+
+	objects, err := listTrash(ctx)
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for _, obj := range objects {
+		total += aws.ToInt64(obj.Size)
+	}
+
+	var evict []string
+	count := len(objects)
+	for _, obj := range objects {
+		overBytes := trashMaxBytes > 0 && total > trashMaxBytes
+		overCount := trashMaxObjects > 0 && count > trashMaxObjects
+		if !overBytes && !overCount {
+			break
+		}
+		evict = append(evict, aws.ToString(obj.Key))
+		total -= aws.ToInt64(obj.Size)
+		count--
+	}
+
+	if len(evict) == 0 {
+		return nil
+	}
+
+	failures, err := deleteKeys(ctx, evict)
+	if err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Evicted objects from trash to stay within limits", "count", len(evict)-len(failures))
+	forgetTrashedACLs(ctx, evict, failures)
+	return nil
+}
+
+// forgetTrashedACLs removes the ACLs of trash entries that are gone for good,
+// as deleting the file would have. An ACL is kept when a file has since been
+// uploaded under the same name, as it is that file's now.
+func forgetTrashedACLs(ctx context.Context, purged []string, failures []FolderError) {
+	if !aclsEnabled {
+		return
+	}
+	failed := map[string]bool{}
+	for _, f := range failures {
+		failed[f.Key] = true
+	}
+	ns := namespaceFrom(ctx)
+	fanOut(ctx, len(purged), func(ctx context.Context, i int) error {
+		if failed[purged[i]] {
+			return nil
+		}
+		key := ns.key(strings.TrimPrefix(ns.name(purged[i]), trashPrefix))
+		if exists, err := objectExists(ctx, key); exists || err != nil {
+			return nil
+		}
+		acl, err := loadACL(ctx, key)
+		if err == nil && acl != nil {
+			err = deleteACLEntries(ctx, acl.entries)
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to remove ACL of purged file", "key", key, "err", err)
+		}
+		return nil
+	})
+}
+
+func listTrashHandler(w http.ResponseWriter, r *http.Request) {
+	objects, err := listTrash(r.Context())
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to list trash",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	prefix := requestNamespace(r).key(trashPrefix)
+	response := TrashResponse{Items: []TrashEntry{}}
+	for _, obj := range objects {
+		deletedAt := aws.ToTime(obj.LastModified)
+		response.Items = append(response.Items, TrashEntry{
+			Filename:  strings.TrimPrefix(aws.ToString(obj.Key), prefix),
+			Size:      aws.ToInt64(obj.Size),
+			DeletedAt: deletedAt.UTC().Format(time.RFC3339),
+			PurgeAt:   deletedAt.Add(trashRetention).UTC().Format(time.RFC3339),
+		})
+	}
+
+	respondJSON(w, http.StatusOK, response)
+}
+
+func restoreTrashHandler(w http.ResponseWriter, r *http.Request) {
+	filename := mux.Vars(r)["filename"]
+	ctx := r.Context()
+	key := requestNamespace(r).key(filename)
+	trashed := trashKey(ctx, key)
+
+	// Don't clobber a file that has since been re-uploaded unless asked to
+	if overwrite, _ := strconv.ParseBool(r.URL.Query().Get("overwrite")); !overwrite {
+		exists, err := objectExists(ctx, key)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+				Error:   "Restore failed",
+				Details: err.Error(),
+			})
+			return
+		}
+		if exists {
+			respondJSON(w, http.StatusConflict, ErrorResponse{
+				Error:   "File already exists",
+				Details: "pass overwrite=true to replace it",
+			})
+			return
+		}
+	}
+
+	if err := copyObject(ctx, trashed, key, ""); err != nil {
+		if isNotFound(err) {
+			respondJSON(w, http.StatusNotFound, ErrorResponse{
+				Error: "File not found in trash",
+			})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Restore failed",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	_, err := s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucketFor(ctx)),
+		Key:    aws.String(trashed),
+	})
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Restored file but failed to remove it from trash",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	recordEvent(ctx, eventRestored, key, nil)
+
+	respondJSON(w, http.StatusOK, MessageResponse{
+		Message:  "File restored successfully",
+		Filename: filename,
+	})
+}
+
+// purgeTrash permanently removes objects older than the retention period from
+// the trash of ctx's namespace.
+func purgeTrash(ctx context.Context) error {
+	cutoff := time.Now().Add(-trashRetention)
+
+	objects, err := listTrash(ctx)
+	if err != nil {
+		return err
+	}
+
+	var expired []string
+	for _, obj := range objects {
+		if aws.ToTime(obj.LastModified).Before(cutoff) {
+			expired = append(expired, aws.ToString(obj.Key))
+		}
+	}
+
+	if len(expired) == 0 {
+		return enforceTrashLimits(ctx)
+	}
+
+	failures, err := deleteKeys(ctx, expired)
+	if err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Purged objects from trash", "count", len(expired)-len(failures))
+	for _, f := range failures {
+		slog.WarnContext(ctx, "Failed to purge from trash", "key", f.Key, "err", f.Error)
+	}
+	forgetTrashedACLs(ctx, expired, failures)
+
+	return enforceTrashLimits(ctx)
+}
+
+func startTrashPurger(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(trashPurgeInterval)
+		defer ticker.Stop()
+
+		for {
+			namespaces, err := knownNamespaces(ctx)
+			if err != nil {
+				slog.ErrorContext(ctx, "Trash purge failed to list tenants", "err", err)
+			}
+			for _, ns := range namespaces {
+				if err := purgeTrash(withNamespace(ctx, ns)); err != nil {
+					slog.ErrorContext(ctx, "Trash purge failed", "tenant", ns.Tenant, "err", err)
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+var (
+	// How often a progress stream checks for chunks other instances stored
+	uploadProgressInterval = durationFromEnv("UPLOAD_PROGRESS_INTERVAL", time.Second)
+	// A stream that has had nothing to report sends a comment this often, so
+	// proxies don't close it
+	uploadProgressKeepAlive = durationFromEnv("UPLOAD_PROGRESS_KEEPALIVE", 15*time.Second)
+)
+
+// UploadProgress is sent as a progress event whenever more of a reserved
+// cache has been received, and as the committed event that ends the stream.
+type UploadProgress struct {
+	CacheID int64 `json:"cache_id"`
+	// Bytes of the stored chunks, and of those still arriving at this instance
+	BytesReceived  int64 `json:"bytes_received"`
+	PartsCompleted int   `json:"parts_completed"`
+	// The size the runner reserved, if it said, or that it committed
+	Size      int64 `json:"size,omitempty"`
+	Committed bool  `json:"committed"`
+}
+
+// chunkCounter counts the bytes of chunks this instance is still receiving,
+// by cache and part.
+type chunkCounter struct {
+	mu     sync.Mutex
+	chunks map[int64]map[int32]int64
+}
+
+var receivingChunks = &chunkCounter{chunks: map[int64]map[int32]int64{}}
+
+func (c *chunkCounter) add(id int64, number int32, n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	parts := c.chunks[id]
+	if parts == nil {
+		parts = map[int32]int64{}
+		c.chunks[id] = parts
+	}
+	parts[number] += n
+}
+
+// done forgets a chunk once it has been stored or has failed.
+func (c *chunkCounter) done(id int64, number int32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.chunks[id], number)
+	if len(c.chunks[id]) == 0 {
+		delete(c.chunks, id)
+	}
+}
+
+// received is how much of each part of a cache has arrived so far.
+func (c *chunkCounter) received(id int64) map[int32]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	parts := make(map[int32]int64, len(c.chunks[id]))
+	for number, n := range c.chunks[id] {
+		parts[number] = n
+	}
+	return parts
+}
+
+func (c *chunkCounter) reader(id int64, number int32, body io.Reader) io.Reader {
+	return &countingChunk{Reader: body, counter: c, id: id, number: number}
+}
+
+type countingChunk struct {
+	io.Reader
+	counter *chunkCounter
+	id      int64
+	number  int32
+}
+
+func (r *countingChunk) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.counter.add(r.id, r.number, int64(n))
+	return n, err
+}
+
+// loadStoredChunks adds the chunk records stored under prefix that aren't in
+// parts yet, so each is only read once however long the stream runs.
+func loadStoredChunks(ctx context.Context, prefix string, parts map[string]actionsCachePart) error {
+	input := &s3.ListObjectsV2Input{Bucket: aws.String(bucketFor(ctx)), Prefix: aws.String(prefix)}
+	for {
+		page, err := s3Client.ListObjectsV2(ctx, input)
+		if err != nil {
+			return err
+		}
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+			if _, ok := parts[key]; ok {
+				continue
+			}
+			var part actionsCachePart
+			if _, err := loadActionsCacheRecord(ctx, key, &part); err != nil {
+				return err
+			}
+			parts[key] = part
+		}
+		if !aws.ToBool(page.IsTruncated) {
+			return nil
+		}
+		input.ContinuationToken = page.NextContinuationToken
+	}
+}
+
+// actionsCacheProgressHandler streams a reserved cache's upload progress as
+// server-sent events, until it is committed or the client goes away. The path
+// carries the token, so a browser's EventSource can open it.
+func actionsCacheProgressHandler(w http.ResponseWriter, r *http.Request) {
+	upload, ok := findActionsCacheUpload(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	ns := requestNamespace(r)
+	uploadKey := actionsCacheUploadKey(ns, upload.ID)
+	prefix := actionsCachePartsPrefix(ns, upload.ID)
+
+	enableCORS(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Stops nginx holding events back
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+
+	send := func(event string, progress UploadProgress) error {
+		data, err := json.Marshal(progress)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	stored := map[string]actionsCachePart{}
+	var last UploadProgress
+	lastSent := time.Now()
+	ticker := time.NewTicker(uploadProgressInterval)
+	defer ticker.Stop()
+	for first := true; ; first = false {
+		if !first {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			// Commit is the one thing that can happen elsewhere to end the stream
+			if _, err := loadActionsCacheRecord(ctx, uploadKey, &upload); err != nil {
+				return
+			}
+		}
+		if upload.Committed {
+			send("committed", UploadProgress{CacheID: upload.ID, BytesReceived: upload.Size, Size: upload.Size, Committed: true})
+			return
+		}
+		if err := loadStoredChunks(ctx, prefix, stored); err != nil {
+			return
+		}
+
+		progress := UploadProgress{CacheID: upload.ID, PartsCompleted: len(stored), Size: upload.Size}
+		done := map[int32]bool{}
+		for _, part := range stored {
+			progress.BytesReceived += part.End - part.Start + 1
+			done[part.Number] = true
+		}
+		for number, n := range receivingChunks.received(upload.ID) {
+			// A chunk sent again after it was stored is counted once
+			if !done[number] {
+				progress.BytesReceived += n
+			}
+		}
+		// A chunk can be stored between listing the records and counting
+		// what's arriving, and must not seem to go backwards
+		progress.BytesReceived = max(progress.BytesReceived, last.BytesReceived)
+
+		var err error
+		switch {
+		case first || progress != last:
+			err = send("progress", progress)
+			last, lastSent = progress, time.Now()
+		case time.Since(lastSent) >= uploadProgressKeepAlive:
+			if _, err = io.WriteString(w, ": keep-alive\n\n"); err == nil {
+				err = rc.Flush()
+			}
+			lastSent = time.Now()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
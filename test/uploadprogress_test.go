@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// nextUploadEvent reads a server-sent event, skipping comments.
+func nextUploadEvent(t *testing.T, events *bufio.Reader) (string, UploadProgress) {
+	t.Helper()
+	var name string
+	var progress UploadProgress
+	for {
+		line, err := events.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading events: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &progress); err != nil {
+				t.Fatal(err)
+			}
+		case line == "" && name != "":
+			return name, progress
+		}
+	}
+}
+
+// waitUploadEvent reads events until one that done accepts, skipping the
+// ones the server sends while it is still catching up.
+func waitUploadEvent(t *testing.T, events *bufio.Reader, done func(string, UploadProgress) bool) (string, UploadProgress) {
+	t.Helper()
+	for {
+		if name, p := nextUploadEvent(t, events); done(name, p) {
+			return name, p
+		}
+	}
+}
+
+func TestActionsCacheProgress(t *testing.T) {
+	srv, _ := newActionsCacheServer(t)
+	override(t, &uploadProgressInterval, 10*time.Millisecond)
+	content := bytes.Repeat([]byte("x"), actionsCachePartAlign+10)
+
+	reserved := call(t, srv, "POST", actionsCacheBase+"/caches", ActionsCacheReserveRequest{Key: "go-mod", Version: "v1", CacheSize: int64(len(content))})
+	expectStatus(t, reserved, http.StatusCreated)
+	var res ActionsCacheReserveResponse
+	reserved.decode(t, &res)
+	path := fmt.Sprintf("%s/caches/%d", actionsCacheBase, res.CacheID)
+	chunk := func(start, end int) {
+		expectStatus(t, call(t, srv, "PATCH", path, content[start:end],
+			"Content-Type", "application/octet-stream", "Content-Range", fmt.Sprintf("bytes %d-%d/*", start, end-1)), http.StatusNoContent)
+	}
+	chunk(actionsCachePartAlign, len(content))
+
+	// Gives up on events that never come
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", srv.URL+path+"/progress", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status %d, type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	events := bufio.NewReader(resp.Body)
+	if name, p := nextUploadEvent(t, events); name != "progress" || p.BytesReceived != 10 || p.PartsCompleted != 1 || p.Size != int64(len(content)) {
+		t.Errorf("first event %s %+v", name, p)
+	}
+
+	// Bytes still arriving count before their chunk is stored
+	receivingChunks.add(res.CacheID, 1, 512)
+	if name, p := nextUploadEvent(t, events); name != "progress" || p.BytesReceived != 522 || p.PartsCompleted != 1 {
+		t.Errorf("arriving chunk %s %+v", name, p)
+	}
+	receivingChunks.done(res.CacheID, 1)
+	chunk(0, actionsCachePartAlign)
+	if name, p := waitUploadEvent(t, events, func(_ string, p UploadProgress) bool { return p.PartsCompleted == 2 }); name != "progress" || p.BytesReceived != int64(len(content)) {
+		t.Errorf("stored chunk %s %+v", name, p)
+	}
+
+	expectStatus(t, call(t, srv, "POST", path, ActionsCacheCommitRequest{Size: int64(len(content))}), http.StatusNoContent)
+	if name, p := waitUploadEvent(t, events, func(_ string, p UploadProgress) bool { return p.Committed }); name != "committed" || p.BytesReceived != int64(len(content)) {
+		t.Errorf("commit event %s %+v", name, p)
+	}
+	if _, err := events.ReadString('\n'); err == nil {
+		t.Error("stream still open after the commit")
+	}
+
+	expectStatus(t, call(t, srv, "GET", actionsCacheBase+"/caches/12345/progress", nil), http.StatusNotFound)
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// Stop collecting after this many problems so a badly broken file doesn't
+// produce a multi-megabyte error response.
+const maxValidationErrors = 100
+
+type ValidationError struct {
+	Row     int    `json:"row,omitempty"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// uploadValidator inspects the content of an upload before it is stored.
+// Validators are registered with registerUploadValidator and run in order; the
+// first validator that reports problems rejects the upload.
+type uploadValidator interface {
+	Name() string
+	Matches(filename string) bool
+	Validate(content []byte) []ValidationError
+}
+
+var uploadValidators []uploadValidator
+
+func registerUploadValidator(v uploadValidator) {
+	uploadValidators = append(uploadValidators, v)
+}
+
+func init() {
+	// Assignments are "<key pattern>=<schema file>" pairs, e.g.
+	// UPLOAD_JSON_SCHEMAS="reports/*.json=schemas/report.schema.json"
+	for pattern, schemaPath := range parseAssignments(os.Getenv("UPLOAD_JSON_SCHEMAS")) {
+		v, err := newJSONSchemaValidator(pattern, schemaPath)
+		if err != nil {
+			fatal("Failed to load JSON schema", "schema", schemaPath, "err", err)
+		}
+		registerUploadValidator(v)
+	}
+
+	for pattern, schemaPath := range parseAssignments(os.Getenv("UPLOAD_CSV_SCHEMAS")) {
+		v, err := newCSVValidator(pattern, schemaPath)
+		if err != nil {
+			fatal("Failed to load CSV schema", "schema", schemaPath, "err", err)
+		}
+		registerUploadValidator(v)
+	}
+}
+
+// parseAssignments parses comma separated "key=value" pairs.
+func parseAssignments(value string) map[string]string {
+	assignments := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || key == "" {
+			continue
+		}
+		assignments[strings.TrimSpace(key)] = strings.TrimSpace(val)
+	}
+	return assignments
+}
+
+// splitList parses a comma-separated setting, dropping blank entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// validateUpload runs every matching validator, returning the name of the
+// validator that rejected the content along with its findings.
+func validateUpload(filename string, content []byte) (string, []ValidationError) {
+	for _, v := range uploadValidators {
+		if !v.Matches(filename) {
+			continue
+		}
+		if problems := v.Validate(content); len(problems) > 0 {
+			return v.Name(), problems
+		}
+	}
+	return "", nil
+}
+
+func matchPattern(pattern, filename string) bool {
+	ok, err := path.Match(pattern, filename)
+	return err == nil && ok
+}
+
+type jsonSchemaValidator struct {
+	pattern string
+	schema  *jsonschema.Schema
+}
+
+func newJSONSchemaValidator(pattern, schemaPath string) (*jsonSchemaValidator, error) {
+	schema, err := jsonschema.Compile(schemaPath)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonSchemaValidator{pattern: pattern, schema: schema}, nil
+}
+
+func (v *jsonSchemaValidator) Name() string { return "json-schema" }
+
+func (v *jsonSchemaValidator) Matches(filename string) bool {
+	return matchPattern(v.pattern, filename)
+}
+
+func (v *jsonSchemaValidator) Validate(content []byte) []ValidationError {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return []ValidationError{{Message: fmt.Sprintf("malformed JSON: %v", err)}}
+	}
+
+	err := v.schema.Validate(document)
+	if err == nil {
+		return nil
+	}
+
+	var schemaErr *jsonschema.ValidationError
+	if !errors.As(err, &schemaErr) {
+		return []ValidationError{{Message: err.Error()}}
+	}
+
+	// Report only the leaves of the error tree; they carry the specific cause
+	var problems []ValidationError
+	var collect func(*jsonschema.ValidationError)
+	collect = func(e *jsonschema.ValidationError) {
+		if len(problems) >= maxValidationErrors {
+			return
+		}
+		if len(e.Causes) == 0 {
+			problems = append(problems, ValidationError{
+				Field:   e.InstanceLocation,
+				Message: e.Message,
+			})
+			return
+		}
+		for _, cause := range e.Causes {
+			collect(cause)
+		}
+	}
+	collect(schemaErr)
+
+	return problems
+}
+
+// CSVSchema describes the expected header and column types of a CSV file.
+type CSVSchema struct {
+	Columns           []CSVColumn `json:"columns"`
+	AllowExtraColumns bool        `json:"allow_extra_columns"`
+}
+
+type CSVColumn struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"` // string, integer, number, boolean or date
+	Required bool   `json:"required"`
+}
+
+type csvValidator struct {
+	pattern string
+	schema  CSVSchema
+}
+
+func newCSVValidator(pattern, schemaPath string) (*csvValidator, error) {
+	raw, err := os.ReadFile(schemaPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var schema CSVSchema
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		return nil, err
+	}
+	for _, col := range schema.Columns {
+		switch col.Type {
+		case "", "string", "integer", "number", "boolean", "date":
+		default:
+			return nil, fmt.Errorf("column %q has unknown type %q", col.Name, col.Type)
+		}
+	}
+
+	return &csvValidator{pattern: pattern, schema: schema}, nil
+}
+
+func (v *csvValidator) Name() string { return "csv" }
+
+func (v *csvValidator) Matches(filename string) bool {
+	return matchPattern(v.pattern, filename)
+}
+
+func (v *csvValidator) Validate(content []byte) []ValidationError {
+	reader := csv.NewReader(bytes.NewReader(content))
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return []ValidationError{{Row: 1, Message: fmt.Sprintf("unreadable header: %v", err)}}
+	}
+
+	var problems []ValidationError
+
+	index := map[string]int{}
+	for i, name := range header {
+		index[strings.TrimSpace(name)] = i
+	}
+	for _, col := range v.schema.Columns {
+		if _, ok := index[col.Name]; !ok {
+			problems = append(problems, ValidationError{Row: 1, Field: col.Name, Message: "missing column"})
+		}
+	}
+	if !v.schema.AllowExtraColumns {
+		known := map[string]bool{}
+		for _, col := range v.schema.Columns {
+			known[col.Name] = true
+		}
+		for _, name := range header {
+			if !known[strings.TrimSpace(name)] {
+				problems = append(problems, ValidationError{Row: 1, Field: name, Message: "unexpected column"})
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+
+	for row := 2; len(problems) < maxValidationErrors; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			problems = append(problems, ValidationError{Row: row, Message: err.Error()})
+			break
+		}
+		if len(record) != len(header) {
+			problems = append(problems, ValidationError{
+				Row:     row,
+				Message: fmt.Sprintf("expected %d fields, got %d", len(header), len(record)),
+			})
+			continue
+		}
+
+		for _, col := range v.schema.Columns {
+			value := strings.TrimSpace(record[index[col.Name]])
+			if value == "" {
+				if col.Required {
+					problems = append(problems, ValidationError{Row: row, Field: col.Name, Message: "value is required"})
+				}
+				continue
+			}
+			if err := checkCSVValue(col.Type, value); err != nil {
+				problems = append(problems, ValidationError{Row: row, Field: col.Name, Message: err.Error()})
+			}
+		}
+	}
+
+	if len(problems) > maxValidationErrors {
+		problems = problems[:maxValidationErrors]
+	}
+	return problems
+}
+
+func checkCSVValue(kind, value string) error {
+	var err error
+	switch kind {
+	case "integer":
+		_, err = strconv.ParseInt(value, 10, 64)
+	case "number":
+		_, err = strconv.ParseFloat(value, 64)
+	case "boolean":
+		_, err = strconv.ParseBool(value)
+	case "date":
+		_, err = time.Parse("2006-01-02", value)
+	}
+	if err != nil {
+		return fmt.Errorf("%q is not a valid %s", value, kind)
+	}
+	return nil
+}
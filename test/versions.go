@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+)
+
+type FileVersion struct {
+	VersionID    string `json:"version_id"`
+	IsLatest     bool   `json:"is_latest"`
+	DeleteMarker bool   `json:"delete_marker,omitempty"`
+	Size         int64  `json:"size"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified"`
+}
+
+type VersionsResponse struct {
+	Filename   string        `json:"filename"`
+	Versioning string        `json:"versioning"`
+	Versions   []FileVersion `json:"versions"`
+}
+
+type RestoreResponse struct {
+	Message         string `json:"message"`
+	Filename        string `json:"filename"`
+	RestoredVersion string `json:"restored_version_id"`
+	VersionID       string `json:"version_id,omitempty"`
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func listVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	filename := mux.Vars(r)["filename"]
+	ns := requestNamespace(r)
+	key := ns.key(filename)
+	if !authorizeFile(w, r, key, permRead) {
+		return
+	}
+
+	versioning, err := s3Client.GetBucketVersioning(r.Context(), &s3.GetBucketVersioningInput{
+		Bucket: aws.String(ns.Bucket),
+	})
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to read bucket versioning",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	response := VersionsResponse{
+		Filename:   filename,
+		Versioning: string(versioning.Status),
+		Versions:   []FileVersion{},
+	}
+	if response.Versioning == "" {
+		response.Versioning = "Disabled"
+	}
+
+	paginator := s3.NewListObjectVersionsPaginator(s3Client, &s3.ListObjectVersionsInput{
+		Bucket: aws.String(ns.Bucket),
+		Prefix: aws.String(key),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(r.Context())
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+				Error:   "Failed to list versions",
+				Details: err.Error(),
+			})
+			return
+		}
+
+		// The prefix also matches longer keys, so keep exact matches only
+		for _, v := range page.Versions {
+			if aws.ToString(v.Key) != key {
+				continue
+			}
+			response.Versions = append(response.Versions, FileVersion{
+				VersionID:    aws.ToString(v.VersionId),
+				IsLatest:     aws.ToBool(v.IsLatest),
+				Size:         aws.ToInt64(v.Size),
+				ETag:         aws.ToString(v.ETag),
+				LastModified: formatTime(v.LastModified),
+			})
+		}
+		for _, m := range page.DeleteMarkers {
+			if aws.ToString(m.Key) != key {
+				continue
+			}
+			response.Versions = append(response.Versions, FileVersion{
+				VersionID:    aws.ToString(m.VersionId),
+				IsLatest:     aws.ToBool(m.IsLatest),
+				DeleteMarker: true,
+				LastModified: formatTime(m.LastModified),
+			})
+		}
+	}
+
+	if len(response.Versions) == 0 {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{
+			Error: "File not found",
+		})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, response)
+}
+
+// restoreVersionHandler makes an old version current again by copying it over
+// the key, which creates a new version and leaves the history intact.
+func restoreVersionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	filename, versionID := vars["filename"], vars["version_id"]
+	ctx := r.Context()
+	ns := requestNamespace(r)
+	key := ns.key(filename)
+	if !authorizeFile(w, r, key, permWrite) {
+		return
+	}
+
+	result, err := s3Client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(ns.Bucket),
+		Key:        aws.String(key),
+		CopySource: aws.String(fmt.Sprintf("%s/%s?versionId=%s", ns.Bucket, url.PathEscape(key), url.QueryEscape(versionID))),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			respondJSON(w, http.StatusNotFound, ErrorResponse{
+				Error:   "Version not found",
+				Details: err.Error(),
+			})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Restore failed",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	replicateObject(ctx, key)
+	recordEvent(ctx, eventVersionRestored, key, map[string]string{"version_id": versionID})
+
+	respondJSON(w, http.StatusOK, RestoreResponse{
+		Message:         "Version restored successfully",
+		Filename:        filename,
+		RestoredVersion: versionID,
+		VersionID:       aws.ToString(result.VersionId),
+	})
+}
@@ -0,0 +1,556 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"golang.org/x/net/webdav"
+)
+
+// WebDAV serves the tenant's files under /api/dav/, so they can be mounted
+// as a network drive in Finder, Explorer or davfs2. Clients authenticate
+// with an API key as the Basic auth password, as for the build cache.
+// Collections are prefixes; MKCOL makes the same folder marker as
+// POST /folders, so empty folders show up.
+const webdavPrefix = "/api/dav"
+
+var (
+	webdavEnabled, _ = strconv.ParseBool(os.Getenv("WEBDAV"))
+
+	// Locks are held in memory by the instance that granted them, so with
+	// several instances clients must stick to one. Finder only mounts
+	// read-write if it can lock.
+	webdavLocks = webdav.NewMemLS()
+
+	webdavMethods = []string{"OPTIONS", "GET", "HEAD", "PUT", "DELETE", "MKCOL", "COPY", "MOVE", "PROPFIND", "PROPPATCH", "LOCK", "UNLOCK"}
+)
+
+// webdavRoutes routes every WebDAV method to the one handler, which
+// dispatches on the method itself.
+func webdavRoutes() []route {
+	var routes []route
+	for _, method := range webdavMethods {
+		routes = append(routes, route{method, "/{path:.*}", webdavHandler, "WebDAV " + method})
+	}
+	return routes
+}
+
+func requireWebDAV(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !webdavEnabled {
+			respondJSON(w, http.StatusNotFound, ErrorResponse{
+				Error: "WebDAV is disabled",
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func webdavHandler(w http.ResponseWriter, r *http.Request) {
+	// The body is only read once the file is open, so uploads that can't
+	// fit are turned away first with the status WebDAV clients understand
+	if r.Method == http.MethodPut && r.ContentLength > 0 {
+		ns := requestNamespace(r)
+		if limit, _ := objectSizeLimit(ns.Tenant, false); limit > 0 && r.ContentLength > limit {
+			http.Error(w, fmt.Sprintf("files may be at most %d bytes", limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err := checkQuota(r.Context(), ns.Tenant, r.ContentLength); err != nil {
+			http.Error(w, err.Error(), http.StatusInsufficientStorage)
+			return
+		}
+	}
+
+	handler := &webdav.Handler{
+		Prefix:     webdavPrefix,
+		FileSystem: davFS{subject: requestSubject(r)},
+		LockSystem: webdavLocks,
+		Logger: func(r *http.Request, err error) {
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				slog.WarnContext(r.Context(), "WebDAV request failed", "method", r.Method, "path", r.URL.Path, "err", err)
+			}
+		},
+	}
+	handler.ServeHTTP(w, r)
+}
+
+// davFS is the files of the request's namespace, as subject may use them.
+// Names are as WebDAV gives them: slash separated, from a leading slash.
+type davFS struct {
+	subject string
+}
+
+// davName turns a WebDAV path into a file name; the root is "".
+func davName(name string) (string, error) {
+	name = strings.Trim(name, "/")
+	if name == "" {
+		return "", nil
+	}
+	clean, err := sanitizeName(name)
+	if err != nil {
+		return "", &os.PathError{Op: "open", Path: name, Err: os.ErrInvalid}
+	}
+	return clean, nil
+}
+
+// davPermission maps an access check to the error WebDAV expects.
+func davPermission(err error) error {
+	if errors.Is(err, errAccessDenied) {
+		return os.ErrPermission
+	}
+	return err
+}
+
+// davInfo describes a file or folder. Its ETag is the object's, and is
+// filled in by Close for a file being written.
+type davInfo struct {
+	name     string
+	size     int64
+	modified time.Time
+	etag     string
+	dir      bool
+}
+
+func (i *davInfo) Name() string {
+	if i.name == "" {
+		return "/"
+	}
+	return path.Base(i.name)
+}
+
+func (i *davInfo) Size() int64        { return i.size }
+func (i *davInfo) ModTime() time.Time { return i.modified }
+func (i *davInfo) IsDir() bool        { return i.dir }
+func (i *davInfo) Sys() any           { return nil }
+
+func (i *davInfo) Mode() os.FileMode {
+	if i.dir {
+		return os.ModeDir | 0o755
+	}
+	return 0o644
+}
+
+func (i *davInfo) ETag(context.Context) (string, error) {
+	if i.etag == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return i.etag, nil
+}
+
+// ContentType is from the extension, so listings don't read every file to
+// sniff it.
+func (i *davInfo) ContentType(context.Context) (string, error) {
+	if ctype := mime.TypeByExtension(path.Ext(i.name)); ctype != "" {
+		return ctype, nil
+	}
+	return "application/octet-stream", nil
+}
+
+func headInfo(name string, head *s3.HeadObjectOutput) *davInfo {
+	return &davInfo{name: name, size: originalSize(head), modified: aws.ToTime(head.LastModified), etag: aws.ToString(head.ETag)}
+}
+
+func (fs davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	name, err := davName(name)
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	if name == "" {
+		return &davInfo{dir: true}, nil
+	}
+
+	ns := namespaceFrom(ctx)
+	head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(ns.Bucket),
+		Key:    aws.String(ns.key(name)),
+	})
+	if err == nil {
+		// Files the caller can't read aren't there, as in listings
+		if _, err := checkAccess(ctx, fs.subject, ns.key(name), permRead); err != nil {
+			if errors.Is(err, errAccessDenied) {
+				return nil, os.ErrNotExist
+			}
+			return nil, err
+		}
+		return headInfo(name, head), nil
+	}
+	if !isNotFound(err) {
+		return nil, err
+	}
+
+	// A folder is a prefix with anything under it, its marker included
+	listing, err := s3Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(ns.Bucket),
+		Prefix:  aws.String(ns.key(name + "/")),
+		MaxKeys: aws.Int32(1),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(listing.Contents) == 0 {
+		return nil, os.ErrNotExist
+	}
+	return &davInfo{name: name, dir: true}, nil
+}
+
+func (fs davFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	name, err := davName(name)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		return os.ErrExist
+	}
+	if _, err := fs.Stat(ctx, name); err == nil {
+		return os.ErrExist
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if parent := path.Dir(name); parent != "." {
+		if info, err := fs.Stat(ctx, parent); err != nil || !info.IsDir() {
+			return os.ErrNotExist
+		}
+	}
+
+	ns := namespaceFrom(ctx)
+	_, err = s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(ns.Bucket),
+		Key:    aws.String(ns.key(name + "/")),
+		Body:   bytes.NewReader(nil),
+	})
+	return err
+}
+
+func (fs davFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	name, err := davName(name)
+	if err != nil {
+		return nil, err
+	}
+	ns := namespaceFrom(ctx)
+
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC) != 0 {
+		if name == "" || isReservedKey(name) {
+			return nil, os.ErrPermission
+		}
+		if parent := path.Dir(name); parent != "." {
+			if info, err := fs.Stat(ctx, parent); err != nil || !info.IsDir() {
+				return nil, os.ErrNotExist
+			}
+		}
+		acl, err := checkAccess(ctx, fs.subject, ns.key(name), permWrite)
+		if err != nil {
+			return nil, davPermission(err)
+		}
+		return &davWriter{ctx: ctx, fs: fs, info: &davInfo{name: name, modified: time.Now()}, acl: acl}, nil
+	}
+
+	info, err := fs.Stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &davDir{ctx: ctx, fs: fs, info: info.(*davInfo)}, nil
+	}
+	return &davReader{ctx: ctx, fs: fs, info: info.(*davInfo)}, nil
+}
+
+func (fs davFS) RemoveAll(ctx context.Context, name string) error {
+	name, err := davName(name)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		return os.ErrPermission
+	}
+	keys, acls, err := fs.keysUnder(ctx, name)
+	if err != nil {
+		return err
+	}
+	// Files go the way a DELETE of each would, to the trash if it's on, as
+	// drives make deleting a whole folder very easy
+	ns := namespaceFrom(ctx)
+	for _, key := range keys {
+		if strings.HasSuffix(key, "/") {
+			_, err = s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+				Bucket: aws.String(ns.Bucket),
+				Key:    aws.String(key),
+			})
+		} else {
+			err = removeFile(ctx, key, "", acls[key])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keysUnder is name's key, if it is a file, or every key under it if it is
+// a folder, with their ACLs, provided the caller may write all of them and
+// none is an alias's target.
+func (fs davFS) keysUnder(ctx context.Context, name string) ([]string, map[string]*fileACL, error) {
+	ns := namespaceFrom(ctx)
+	info, err := fs.Stat(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	keys := []string{ns.key(name)}
+	if info.IsDir() {
+		if keys, err = listPrefix(ctx, ns.key(name+"/")); err != nil {
+			return nil, nil, err
+		}
+	}
+	acls := map[string]*fileACL{}
+	for _, key := range keys {
+		acl, err := checkAccess(ctx, fs.subject, key, permWrite)
+		if err != nil {
+			return nil, nil, davPermission(err)
+		}
+		if acl != nil {
+			acls[key] = acl
+		}
+		if strings.HasSuffix(key, "/") {
+			continue
+		}
+		aliases, err := aliasesOf(ctx, ns, ns.name(key))
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(aliases) > 0 {
+			return nil, nil, fmt.Errorf("%s has aliases: %w", ns.name(key), os.ErrPermission)
+		}
+	}
+	return keys, acls, nil
+}
+
+// Rename copies each object to its new name and then removes it, as S3 has
+// no rename; the copies keep their storage encodings and metadata.
+func (fs davFS) Rename(ctx context.Context, oldName, newName string) error {
+	oldName, err := davName(oldName)
+	if err != nil {
+		return err
+	}
+	newName, err = davName(newName)
+	if err != nil {
+		return err
+	}
+	if oldName == "" || newName == "" || isReservedKey(newName) || strings.HasPrefix(newName+"/", oldName+"/") {
+		return os.ErrPermission
+	}
+
+	ns := namespaceFrom(ctx)
+	keys, acls, err := fs.keysUnder(ctx, oldName)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		to := ns.key(newName + strings.TrimPrefix(ns.name(key), oldName))
+		acl, err := checkAccess(ctx, fs.subject, to, permWrite)
+		if err != nil {
+			return davPermission(err)
+		}
+		if err := copyObject(ctx, key, to, ""); err != nil {
+			return err
+		}
+		if aclsEnabled && acl == nil && !strings.HasSuffix(to, "/") {
+			if err := putACLEntry(ctx, to, roleOwner, fs.subject); err != nil {
+				slog.WarnContext(ctx, "Failed to record owner", "key", to, "err", err)
+			}
+		}
+		// The file is at its new name, so it isn't trashed
+		if _, err := s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(ns.Bucket),
+			Key:    aws.String(key),
+		}); err != nil {
+			return err
+		}
+		replicateDeletion(ctx, key)
+		if acl := acls[key]; acl != nil {
+			if err := deleteACLEntries(ctx, acl.entries); err != nil {
+				slog.WarnContext(ctx, "Failed to remove ACL", "key", key, "err", err)
+			}
+		}
+		recordEvent(ctx, eventMoved, key, map[string]string{"to": to})
+	}
+	return nil
+}
+
+// davReader reads a file. The content is fetched on the first read, so
+// requests that only seek to find the size don't download it.
+type davReader struct {
+	ctx     context.Context
+	fs      davFS
+	info    *davInfo
+	content []byte
+	fetched bool
+	offset  int64
+}
+
+func (f *davReader) Read(p []byte) (int, error) {
+	if !f.fetched {
+		// Here rather than on opening, which listings do too
+		if err := runHooks(f.ctx, &hookEvent{Point: hookOnDownload, Subject: f.fs.subject, Filename: f.info.name}); err != nil {
+			return 0, err
+		}
+		ns := namespaceFrom(f.ctx)
+		result, err := getObject(f.ctx, &s3.GetObjectInput{
+			Bucket: aws.String(ns.Bucket),
+			Key:    aws.String(ns.key(f.info.name)),
+		})
+		if err != nil {
+			return 0, err
+		}
+		f.content, err = io.ReadAll(result.Body)
+		result.Body.Close()
+		if err != nil {
+			return 0, err
+		}
+		f.fetched = true
+	}
+	n, err := bytes.NewReader(f.content).ReadAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *davReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.size
+	}
+	if offset < 0 {
+		return 0, os.ErrInvalid
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *davReader) Stat() (os.FileInfo, error)         { return f.info, nil }
+func (f *davReader) Readdir(int) ([]os.FileInfo, error) { return nil, os.ErrInvalid }
+func (f *davReader) Write([]byte) (int, error)          { return 0, os.ErrPermission }
+func (f *davReader) Close() error                       { return nil }
+
+// davWriter buffers a file being written and stores it on Close, through
+// the same checks and storage policies as an upload.
+type davWriter struct {
+	ctx  context.Context
+	fs   davFS
+	info *davInfo
+	acl  *fileACL
+	buf  bytes.Buffer
+}
+
+func (f *davWriter) Write(p []byte) (int, error) {
+	if limit, _ := objectSizeLimit(namespaceFrom(f.ctx).Tenant, false); limit > 0 && int64(f.buf.Len()+len(p)) > limit {
+		return 0, fmt.Errorf("files may be at most %d bytes", limit)
+	}
+	n, err := f.buf.Write(p)
+	f.info.size = int64(f.buf.Len())
+	return n, err
+}
+
+func (f *davWriter) Close() error {
+	ns := namespaceFrom(f.ctx)
+	result, err := storeFile(f.ctx, f.fs.subject, &s3.PutObjectInput{
+		Bucket: aws.String(ns.Bucket),
+		Key:    aws.String(ns.key(f.info.name)),
+	}, f.buf.Bytes(), f.acl)
+	if err != nil {
+		return err
+	}
+	f.info.etag = aws.ToString(result.ETag)
+	return nil
+}
+
+func (f *davWriter) Stat() (os.FileInfo, error)         { return f.info, nil }
+func (f *davWriter) Read([]byte) (int, error)           { return 0, os.ErrPermission }
+func (f *davWriter) Seek(int64, int) (int64, error)     { return 0, os.ErrInvalid }
+func (f *davWriter) Readdir(int) ([]os.FileInfo, error) { return nil, os.ErrInvalid }
+
+// davDir lists a folder: its subfolders, and the files in it the caller
+// can read, with their sizes as uploaded.
+type davDir struct {
+	ctx  context.Context
+	fs   davFS
+	info *davInfo
+	read bool
+}
+
+func (d *davDir) Readdir(count int) ([]os.FileInfo, error) {
+	if d.read {
+		if count > 0 {
+			return nil, io.EOF
+		}
+		return nil, nil
+	}
+	d.read = true
+
+	ns := namespaceFrom(d.ctx)
+	prefix := ""
+	if d.info.name != "" {
+		prefix = d.info.name + "/"
+	}
+	var entries []os.FileInfo
+	var names []string
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(ns.Bucket),
+		Prefix:    aws.String(ns.key(prefix)),
+		Delimiter: aws.String("/"),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(d.ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, common := range page.CommonPrefixes {
+			dir := strings.TrimSuffix(ns.name(aws.ToString(common.Prefix)), "/")
+			if !isReservedKey(dir + "/") {
+				entries = append(entries, &davInfo{name: dir, dir: true})
+			}
+		}
+		for _, obj := range page.Contents {
+			name := ns.name(aws.ToString(obj.Key))
+			if !isReservedKey(name) && !strings.HasSuffix(name, "/") {
+				names = append(names, name)
+			}
+		}
+	}
+	names, err := readableNames(d.ctx, d.fs.subject, names)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, len(names))
+	for i, name := range names {
+		keys[i] = ns.key(name)
+	}
+	heads, err := headObjects(d.ctx, ns.Bucket, keys)
+	if err != nil {
+		return nil, err
+	}
+	for i, head := range heads {
+		entries = append(entries, headInfo(names[i], head))
+	}
+	return entries, nil
+}
+
+func (d *davDir) Stat() (os.FileInfo, error)     { return d.info, nil }
+func (d *davDir) Read([]byte) (int, error)       { return 0, os.ErrInvalid }
+func (d *davDir) Seek(int64, int) (int64, error) { return 0, os.ErrInvalid }
+func (d *davDir) Write([]byte) (int, error)      { return 0, os.ErrPermission }
+func (d *davDir) Close() error                   { return nil }
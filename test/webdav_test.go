@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestWebDAV(t *testing.T) {
+	srv, fake := newTestServer(t)
+	override(t, &webdavEnabled, true)
+	mustUpload(t, srv, "/api", "docs/readme.txt", "hello")
+
+	expectStatus(t, call(t, srv, "PUT", "/api/dav/docs/notes.txt", "written over dav"), http.StatusCreated)
+	if got := call(t, srv, "GET", "/api/files/docs/notes.txt", nil); string(got.body) != "written over dav" {
+		t.Errorf("file written over WebDAV has %q", got.body)
+	}
+	if got := call(t, srv, "GET", "/api/dav/docs/readme.txt", nil); string(got.body) != "hello" {
+		t.Errorf("uploaded file read over WebDAV as %q", got.body)
+	}
+
+	expectStatus(t, call(t, srv, "MKCOL", "/api/dav/empty", nil), http.StatusCreated)
+	expectStatus(t, call(t, srv, "MKCOL", "/api/dav/empty", nil), http.StatusMethodNotAllowed)
+	if _, _, ok := fake.Object(bucketName, "empty/"); !ok {
+		t.Error("MKCOL left no folder marker")
+	}
+
+	listing := call(t, srv, "PROPFIND", "/api/dav/", nil, "Depth", "1")
+	expectStatus(t, listing, http.StatusMultiStatus)
+	for _, want := range []string{"/api/dav/docs/", "/api/dav/empty/"} {
+		if !strings.Contains(string(listing.body), "<D:href>"+want+"</D:href>") {
+			t.Errorf("root listing lacks %s:\n%s", want, listing.body)
+		}
+	}
+	if strings.Contains(string(listing.body), ".aliases") {
+		t.Errorf("root listing shows a reserved prefix:\n%s", listing.body)
+	}
+	listing = call(t, srv, "PROPFIND", "/api/dav/docs/", nil, "Depth", "1")
+	if !strings.Contains(string(listing.body), "<D:getcontentlength>5</D:getcontentlength>") {
+		t.Errorf("folder listing lacks readme.txt's size:\n%s", listing.body)
+	}
+
+	got := call(t, srv, "MOVE", "/api/dav/docs", nil, "Destination", srv.URL+"/api/dav/archive")
+	expectStatus(t, got, http.StatusCreated)
+	expectStatus(t, call(t, srv, "GET", "/api/files/docs/readme.txt", nil), http.StatusNotFound)
+	if got := call(t, srv, "GET", "/api/files/archive/readme.txt", nil); string(got.body) != "hello" {
+		t.Errorf("moved file has %q", got.body)
+	}
+
+	expectStatus(t, call(t, srv, "DELETE", "/api/dav/archive/notes.txt", nil), http.StatusNoContent)
+	expectStatus(t, call(t, srv, "GET", "/api/files/archive/notes.txt", nil), http.StatusNotFound)
+	expectStatus(t, call(t, srv, "GET", "/api/dav/archive/notes.txt", nil), http.StatusNotFound)
+}
+
+func TestWebDAVRefusals(t *testing.T) {
+	srv, fake := newTestServer(t)
+	mustUpload(t, srv, "/api", "app.tar.gz", "build")
+
+	expectStatus(t, call(t, srv, "PROPFIND", "/api/dav/", nil), http.StatusNotFound)
+	override(t, &webdavEnabled, true)
+
+	// A file can't be deleted out from under its aliases this way either;
+	// the webdav package answers every failed delete with 405
+	expectStatus(t, call(t, srv, "PUT", "/api/aliases/latest.tar.gz", AliasRequest{Target: "app.tar.gz"}), http.StatusCreated)
+	expectStatus(t, call(t, srv, "DELETE", "/api/dav/app.tar.gz", nil), http.StatusMethodNotAllowed)
+	expectStatus(t, call(t, srv, "GET", "/api/files/app.tar.gz", nil), http.StatusOK)
+
+	// and a PUT it can't open, other than for a missing folder, with 404
+	expectStatus(t, call(t, srv, "PUT", "/api/dav/.aliases/names/x", "x"), http.StatusNotFound)
+	expectStatus(t, call(t, srv, "PUT", "/api/dav/missing/file.txt", "x"), http.StatusConflict)
+	if _, _, ok := fake.Object(bucketName, ".aliases/names/x"); ok {
+		t.Error("PUT wrote into a reserved prefix")
+	}
+}
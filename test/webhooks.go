@@ -0,0 +1,526 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+)
+
+const (
+	webhookPrefix = ".webhooks/"
+	webhooksKey   = webhookPrefix + "webhooks.json"
+
+	webhookQueueSize       = 1000
+	webhookSignatureHeader = "X-Webhook-Signature"
+)
+
+var (
+	// Other instances pick up registered webhooks within this long
+	webhookReloadInterval = durationFromEnv("WEBHOOK_RELOAD_INTERVAL", 10*time.Second)
+
+	// A failed delivery is tried again after WEBHOOK_RETRY_DELAY, doubling
+	// each time up to WEBHOOK_MAX_RETRY_DELAY, until WEBHOOK_MAX_ATTEMPTS
+	webhookMaxAttempts   = intFromEnv("WEBHOOK_MAX_ATTEMPTS", 6)
+	webhookRetryDelay    = durationFromEnv("WEBHOOK_RETRY_DELAY", time.Second)
+	webhookMaxRetryDelay = durationFromEnv("WEBHOOK_MAX_RETRY_DELAY", 5*time.Minute)
+)
+
+// webhookEvents are the file events webhooks are sent for, by the name
+// webhooks subscribe with. Moving a file to the trash deletes it as far as
+// its listing goes.
+var webhookEvents = map[string]string{
+	eventUploaded: "upload",
+	eventDeleted:  "delete",
+	eventTrashed:  "delete",
+	eventMoved:    "move",
+}
+
+// Webhook is an operator's subscription to file events, optionally only for
+// one tenant's files, some events or names under a prefix.
+type Webhook struct {
+	ID        string   `json:"id"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events,omitempty"`
+	Tenant    string   `json:"tenant,omitempty"`
+	Prefix    string   `json:"prefix,omitempty"`
+	Secret    string   `json:"secret,omitempty"`
+	CreatedAt string   `json:"created_at"`
+}
+
+// WebhookRequest registers a webhook. Without a secret one is made up, and
+// shown in the response only.
+type WebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events,omitempty"`
+	Tenant string   `json:"tenant,omitempty"`
+	Prefix string   `json:"prefix,omitempty"`
+	Secret string   `json:"secret,omitempty"`
+}
+
+// WebhookDeliveries counts what this instance has sent to a webhook.
+type WebhookDeliveries struct {
+	Delivered   int64  `json:"delivered"`
+	Failed      int64  `json:"failed"`
+	Retrying    int64  `json:"retrying"`
+	LastError   string `json:"last_error,omitempty"`
+	LastErrorAt string `json:"last_error_at,omitempty"`
+}
+
+type WebhookStatus struct {
+	Webhook
+	Deliveries WebhookDeliveries `json:"deliveries"`
+}
+
+type WebhooksResponse struct {
+	Webhooks []WebhookStatus `json:"webhooks"`
+	// Events left undelivered because the queue was full
+	Dropped int64 `json:"dropped"`
+}
+
+// WebhookPayload is the body POSTed for an event. Retries send the same ID,
+// so receivers can tell a retry from a new event.
+type WebhookPayload struct {
+	ID      string            `json:"id"`
+	Event   string            `json:"event"`
+	Tenant  string            `json:"tenant,omitempty"`
+	Name    string            `json:"name"`
+	To      string            `json:"to,omitempty"`
+	Time    string            `json:"time"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// matches reports whether the webhook wants p.
+func (h Webhook) matches(p WebhookPayload) bool {
+	if h.Tenant != "" && h.Tenant != p.Tenant {
+		return false
+	}
+	if len(h.Events) > 0 && !contains(h.Events, p.Event) {
+		return false
+	}
+	return strings.HasPrefix(p.Name, h.Prefix) || (p.To != "" && strings.HasPrefix(p.To, h.Prefix))
+}
+
+// webhookStore keeps the webhooks in one object in the files bucket, cached
+// like the freezes.
+type webhookStore struct {
+	mu        sync.Mutex
+	webhooks  []Webhook
+	etag      string
+	checkedAt time.Time
+}
+
+var webhooks = &webhookStore{}
+
+// current returns the registered webhooks, reloading them if they may be
+// stale.
+func (s *webhookStore) current(ctx context.Context) ([]Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.checkedAt.IsZero() || time.Since(s.checkedAt) >= webhookReloadInterval {
+		head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(webhooksKey),
+		})
+		switch {
+		case isNotFound(err):
+			s.webhooks, s.etag = nil, ""
+		case err != nil:
+			return nil, err
+		case aws.ToString(head.ETag) != s.etag:
+			stored, etag, err := readWebhooks(ctx)
+			if err != nil {
+				return nil, err
+			}
+			s.webhooks, s.etag = stored, etag
+		}
+		s.checkedAt = time.Now()
+	}
+	return s.webhooks, nil
+}
+
+func readWebhooks(ctx context.Context) ([]Webhook, string, error) {
+	result, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(webhooksKey),
+	})
+	if isNotFound(err) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	defer result.Body.Close()
+
+	var stored []Webhook
+	if err := json.NewDecoder(result.Body).Decode(&stored); err != nil {
+		return nil, "", fmt.Errorf("decoding %s: %w", webhooksKey, err)
+	}
+	return stored, aws.ToString(result.ETag), nil
+}
+
+// update applies change to the webhooks, conditionally on what it read as
+// the policy store does.
+func (s *webhookStore) update(ctx context.Context, change func([]Webhook) ([]Webhook, error)) error {
+	for attempt := 0; attempt < policyUpdateAttempts; attempt++ {
+		stored, etag, err := readWebhooks(ctx)
+		if err != nil {
+			return err
+		}
+		updated, err := change(stored)
+		if err != nil {
+			return err
+		}
+
+		body, err := json.MarshalIndent(updated, "", "  ")
+		if err != nil {
+			return err
+		}
+		input := &s3.PutObjectInput{
+			Bucket:      aws.String(bucketName),
+			Key:         aws.String(webhooksKey),
+			Body:        bytes.NewReader(body),
+			ContentType: aws.String("application/json"),
+		}
+		if etag == "" {
+			input.IfNoneMatch = aws.String("*")
+		} else {
+			input.IfMatch = aws.String(etag)
+		}
+		result, err := s3Client.PutObject(ctx, input)
+		if isPreconditionFailed(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		s.webhooks, s.etag, s.checkedAt = updated, aws.ToString(result.ETag), time.Now()
+		s.mu.Unlock()
+		return nil
+	}
+	return fmt.Errorf("webhooks kept changing; gave up after %d attempts", policyUpdateAttempts)
+}
+
+// webhookTarget is somewhere a payload is sent: a registered webhook, or an
+// onboarded tenant's own. name keys the delivery counts.
+type webhookTarget struct {
+	name, url, secret string
+}
+
+// webhookDispatcher delivers file events to the webhooks that want them.
+// Events wait in a queue so recording them never waits on a receiver, and
+// failed deliveries are retried from timers, so a slow receiver doesn't hold
+// up the others.
+type webhookDispatcher struct {
+	queue chan WebhookPayload
+
+	mu         sync.Mutex
+	dropped    int64
+	deliveries map[string]*WebhookDeliveries
+	// Targets whose latest delivery gave up
+	failing map[string]bool
+}
+
+var webhookDeliveries *webhookDispatcher
+
+// startWebhooks starts delivering events to webhooks, with WEBHOOK_WORKERS
+// deliveries at a time.
+func startWebhooks(ctx context.Context) {
+	d := &webhookDispatcher{
+		queue:      make(chan WebhookPayload, webhookQueueSize),
+		deliveries: map[string]*WebhookDeliveries{},
+		failing:    map[string]bool{},
+	}
+	for range intFromEnv("WEBHOOK_WORKERS", 4) {
+		go d.run(ctx)
+	}
+	webhookDeliveries = d
+}
+
+// notifyWebhooks queues event for delivery if webhooks are sent for it.
+func notifyWebhooks(ns namespace, event FileEvent) {
+	d := webhookDeliveries
+	name, ok := webhookEvents[event.Type]
+	if d == nil || !ok || isReservedKey(ns.name(event.Key)) {
+		return
+	}
+	p := WebhookPayload{
+		ID:     newJobID(),
+		Event:  name,
+		Tenant: ns.Tenant,
+		Name:   ns.name(event.Key),
+		Time:   event.Time,
+	}
+	details := map[string]string{}
+	for k, v := range event.Details {
+		details[k] = v
+	}
+	if to, ok := details["to"]; ok {
+		p.To = ns.name(to)
+		delete(details, "to")
+	}
+	if event.Type == eventTrashed {
+		details["trashed"] = "true"
+	}
+	if len(details) > 0 {
+		p.Details = details
+	}
+
+	select {
+	case d.queue <- p:
+	default:
+		d.mu.Lock()
+		d.dropped++
+		d.mu.Unlock()
+		slog.Error("Webhook queue full; event not delivered", "event", p.Event, "name", p.Name, "tenant", p.Tenant)
+	}
+}
+
+func (d *webhookDispatcher) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case p := <-d.queue:
+			d.dispatch(ctx, p)
+		}
+	}
+}
+
+// dispatch sends p to every registered webhook that wants it, and to the
+// webhook of its tenant if it has one.
+func (d *webhookDispatcher) dispatch(ctx context.Context, p WebhookPayload) {
+	hooks, err := webhooks.current(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load webhooks", "event", p.Event, "name", p.Name, "err", err)
+		return
+	}
+	var targets []webhookTarget
+	for _, h := range hooks {
+		if h.matches(p) {
+			targets = append(targets, webhookTarget{name: h.ID, url: h.URL, secret: h.Secret})
+		}
+	}
+	if record, ok := registeredTenants.cached(p.Tenant); ok && record.Webhook != nil {
+		hook := record.Webhook
+		if len(hook.Events) == 0 || contains(hook.Events, p.Event) {
+			targets = append(targets, webhookTarget{name: "tenant:" + p.Tenant, url: hook.URL, secret: hook.Secret})
+		}
+	}
+	if len(targets) == 0 {
+		return
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		return
+	}
+	for _, target := range targets {
+		d.deliver(ctx, target, p, body, 1)
+	}
+}
+
+// deliver makes one attempt at sending body to target, and schedules the
+// next if it fails.
+func (d *webhookDispatcher) deliver(ctx context.Context, target webhookTarget, p WebhookPayload, body []byte, attempt int) {
+	sendCtx, cancel := context.WithTimeout(ctx, webhookClient.Timeout)
+	err := postSigned(sendCtx, target.url, target.secret, webhookSignatureHeader, body)
+	cancel()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	counts := d.deliveries[target.name]
+	if counts == nil {
+		counts = &WebhookDeliveries{}
+		d.deliveries[target.name] = counts
+	}
+	if attempt > 1 {
+		counts.Retrying--
+	}
+	if err == nil {
+		counts.Delivered++
+		delete(d.failing, target.name)
+		return
+	}
+	counts.LastError, counts.LastErrorAt = err.Error(), time.Now().UTC().Format(time.RFC3339)
+	if attempt >= webhookMaxAttempts || ctx.Err() != nil {
+		counts.Failed++
+		d.failing[target.name] = true
+		slog.ErrorContext(ctx, "Webhook delivery failed; giving up", "webhook", target.name, "event", p.Event, "name", p.Name, "attempts", attempt, "err", err)
+		return
+	}
+	counts.Retrying++
+	delay := webhookBackoff(attempt)
+	slog.WarnContext(ctx, "Webhook delivery failed; retrying", "webhook", target.name, "event", p.Event, "name", p.Name, "attempt", attempt, "retry_in", delay.String(), "err", err)
+	time.AfterFunc(delay, func() { d.deliver(ctx, target, p, body, attempt+1) })
+}
+
+// webhookBackoff is how long to wait after the given failed attempt: the
+// retry delay doubled for each earlier one, give or take a fifth so that
+// receivers coming back aren't hit by every retry at once.
+func webhookBackoff(attempt int) time.Duration {
+	delay := webhookRetryDelay << (attempt - 1)
+	if delay <= 0 || delay > webhookMaxRetryDelay {
+		delay = webhookMaxRetryDelay
+	}
+	return time.Duration(float64(delay) * (0.8 + 0.4*rand.Float64()))
+}
+
+func (d *webhookDispatcher) counts(name string) WebhookDeliveries {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if counts := d.deliveries[name]; counts != nil {
+		return *counts
+	}
+	return WebhookDeliveries{}
+}
+
+// checkWebhooks fails while any webhook's latest delivery has given up, or
+// events are being dropped.
+func checkWebhooks(ctx context.Context) error {
+	d := webhookDeliveries
+	if len(d.queue) == cap(d.queue) {
+		return fmt.Errorf("webhook queue is full")
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.failing) > 0 {
+		return fmt.Errorf("deliveries failing to %s", strings.Join(sortedKeys(d.failing), ", "))
+	}
+	return nil
+}
+
+// validateWebhook checks req, and turns it into a webhook registered now.
+func validateWebhook(req WebhookRequest) (Webhook, error) {
+	hook := Webhook{
+		ID:        newJobID(),
+		URL:       req.URL,
+		Events:    req.Events,
+		Tenant:    req.Tenant,
+		Secret:    req.Secret,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return hook, errors.New("url must be an absolute http or https URL")
+	}
+	known := map[string]bool{}
+	for _, name := range webhookEvents {
+		known[name] = true
+	}
+	for _, event := range req.Events {
+		if !known[event] {
+			return hook, fmt.Errorf("unknown event %q; webhooks are sent for %s", event, strings.Join(sortedKeys(known), ", "))
+		}
+	}
+	if req.Tenant != "" && !validTenantID.MatchString(req.Tenant) {
+		return hook, errors.New("tenant may only contain letters, digits, - and _")
+	}
+	if req.Prefix != "" {
+		prefix, err := sanitizePrefix(req.Prefix)
+		if err != nil {
+			return hook, err
+		}
+		hook.Prefix = prefix
+	}
+	if hook.Secret == "" {
+		hook.Secret = randomToken()
+	}
+	return hook, nil
+}
+
+func listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	stored, _, err := readWebhooks(r.Context())
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to read webhooks",
+			Details: err.Error(),
+		})
+		return
+	}
+	resp := WebhooksResponse{Webhooks: []WebhookStatus{}}
+	for _, h := range stored {
+		h.Secret = redacted
+		status := WebhookStatus{Webhook: h}
+		if webhookDeliveries != nil {
+			status.Deliveries = webhookDeliveries.counts(h.ID)
+		}
+		resp.Webhooks = append(resp.Webhooks, status)
+	}
+	sort.Slice(resp.Webhooks, func(i, j int) bool { return resp.Webhooks[i].CreatedAt < resp.Webhooks[j].CreatedAt })
+	if webhookDeliveries != nil {
+		webhookDeliveries.mu.Lock()
+		resp.Dropped = webhookDeliveries.dropped
+		webhookDeliveries.mu.Unlock()
+	}
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// createWebhookHandler registers a webhook. The response is the only place
+// its secret is shown.
+func createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var req WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondInvalidBody(w, err)
+		return
+	}
+	hook, err := validateWebhook(req)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid webhook",
+			Details: err.Error(),
+		})
+		return
+	}
+	if err := webhooks.update(r.Context(), func(stored []Webhook) ([]Webhook, error) {
+		return append(stored, hook), nil
+	}); err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to update webhooks",
+			Details: err.Error(),
+		})
+		return
+	}
+	respondJSON(w, http.StatusCreated, hook)
+}
+
+var errWebhookNotFound = errors.New("webhook not found")
+
+func deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	err := webhooks.update(r.Context(), func(stored []Webhook) ([]Webhook, error) {
+		for i := range stored {
+			if stored[i].ID == id {
+				return append(stored[:i], stored[i+1:]...), nil
+			}
+		}
+		return nil, errWebhookNotFound
+	})
+	switch {
+	case errors.Is(err, errWebhookNotFound):
+		respondJSON(w, http.StatusNotFound, ErrorResponse{
+			Error: "Webhook not found",
+		})
+	case err != nil:
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to update webhooks",
+			Details: err.Error(),
+		})
+	default:
+		respondJSON(w, http.StatusOK, MessageResponse{Message: "Webhook deleted"})
+	}
+}
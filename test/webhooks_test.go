@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver records what is POSTed to it, failing the first fail
+// requests.
+type webhookReceiver struct {
+	*httptest.Server
+	mu         sync.Mutex
+	fail       int
+	received   []WebhookPayload
+	signatures []string
+}
+
+func newWebhookReceiver(t *testing.T, fail int) *webhookReceiver {
+	t.Helper()
+	rcv := &webhookReceiver{fail: fail}
+	rcv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var p WebhookPayload
+		json.Unmarshal(body, &p)
+		rcv.mu.Lock()
+		defer rcv.mu.Unlock()
+		rcv.received = append(rcv.received, p)
+		rcv.signatures = append(rcv.signatures, r.Header.Get(webhookSignatureHeader)+" "+string(body))
+		if rcv.fail > 0 {
+			rcv.fail--
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(rcv.Close)
+	return rcv
+}
+
+// wait returns the first n payloads received, failing if they don't come.
+func (rcv *webhookReceiver) wait(t *testing.T, n int) []WebhookPayload {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rcv.mu.Lock()
+		got := append([]WebhookPayload{}, rcv.received...)
+		rcv.mu.Unlock()
+		if len(got) >= n {
+			return got[:n]
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d webhook requests, want %d: %+v", len(got), n, got)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func startTestWebhooks(t *testing.T) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	override(t, &webhooks, &webhookStore{})
+	override(t, &webhookDeliveries, nil)
+	override(t, &webhookRetryDelay, time.Millisecond)
+	startWebhooks(ctx)
+}
+
+func TestWebhooks(t *testing.T) {
+	srv, _ := newTestServer(t)
+	startTestWebhooks(t)
+	override(t, &adminToken, "admin-secret")
+	admin := []string{"Authorization", "Bearer admin-secret"}
+	rcv := newWebhookReceiver(t, 1)
+
+	var hook Webhook
+	resp := call(t, srv, "POST", "/api/admin/webhooks", WebhookRequest{URL: rcv.URL, Events: []string{"upload", "delete"}, Prefix: "incoming/"}, admin...)
+	expectStatus(t, resp, http.StatusCreated)
+	resp.decode(t, &hook)
+	if hook.ID == "" || hook.Secret == "" || hook.Prefix != "incoming/" {
+		t.Fatalf("webhook %+v", hook)
+	}
+
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("other/skip.txt", "abc")), http.StatusOK)
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("incoming/a.txt", "abc")), http.StatusOK)
+	// The first attempt failed, and was retried with the same ID
+	got := rcv.wait(t, 2)
+	if got[0].ID != got[1].ID || got[1].Event != "upload" || got[1].Name != "incoming/a.txt" || got[1].Details["size"] != "3" {
+		t.Fatalf("deliveries %+v", got)
+	}
+	rcv.mu.Lock()
+	signature, body, _ := strings.Cut(rcv.signatures[1], " ")
+	rcv.mu.Unlock()
+	mac := hmac.New(sha256.New, []byte(hook.Secret))
+	mac.Write([]byte(body))
+	if signature != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("signature %q", signature)
+	}
+
+	expectStatus(t, call(t, srv, "DELETE", "/api/files/incoming/a.txt", nil), http.StatusOK)
+	if p := rcv.wait(t, 3)[2]; p.Event != "delete" || p.Name != "incoming/a.txt" || p.ID == got[0].ID {
+		t.Errorf("delete delivery %+v", p)
+	}
+
+	// Counted once the receiver has answered
+	var list WebhooksResponse
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		call(t, srv, "GET", "/api/admin/webhooks", nil, admin...).decode(t, &list)
+		if len(list.Webhooks) != 1 || list.Webhooks[0].Secret != redacted {
+			t.Fatalf("list %+v", list)
+		}
+		if list.Webhooks[0].Deliveries.Delivered == 2 || time.Now().After(deadline) {
+			break
+		}
+	}
+	if d := list.Webhooks[0].Deliveries; d.Delivered != 2 || d.Failed != 0 || d.Retrying != 0 || d.LastError == "" {
+		t.Errorf("deliveries %+v", d)
+	}
+
+	expectStatus(t, call(t, srv, "DELETE", "/api/admin/webhooks/"+hook.ID, nil, admin...), http.StatusOK)
+	expectStatus(t, call(t, srv, "DELETE", "/api/admin/webhooks/"+hook.ID, nil, admin...), http.StatusNotFound)
+	expectStatus(t, call(t, srv, "POST", "/api/admin/webhooks", WebhookRequest{URL: rcv.URL, Events: []string{"uploaded"}}, admin...), http.StatusBadRequest)
+	expectStatus(t, call(t, srv, "POST", "/api/admin/webhooks", WebhookRequest{URL: "ftp://example.com"}, admin...), http.StatusBadRequest)
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	srv, _ := newTestServer(t)
+	startTestWebhooks(t)
+	override(t, &webhookMaxAttempts, 3)
+	override(t, &adminToken, "admin-secret")
+	rcv := newWebhookReceiver(t, 100)
+	expectStatus(t, call(t, srv, "POST", "/api/admin/webhooks", WebhookRequest{URL: rcv.URL, Secret: "s3cret"}, "Authorization", "Bearer admin-secret"), http.StatusCreated)
+
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("a.txt", "abc")), http.StatusOK)
+	rcv.wait(t, 3)
+	deadline := time.Now().Add(5 * time.Second)
+	for checkWebhooks(context.Background()) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("health check still passing after delivery gave up")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	// Nothing is tried after the last attempt
+	time.Sleep(20 * time.Millisecond)
+	rcv.mu.Lock()
+	attempts := len(rcv.received)
+	rcv.mu.Unlock()
+	if attempts != 3 {
+		t.Errorf("%d attempts, want 3", attempts)
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	override(t, &webhookRetryDelay, time.Second)
+	override(t, &webhookMaxRetryDelay, 10*time.Second)
+	for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 5: 10 * time.Second, 70: 10 * time.Second} {
+		if got := webhookBackoff(attempt); got < want*8/10 || got > want*12/10 {
+			t.Errorf("attempt %d: %s, want about %s", attempt, got, want)
+		}
+	}
+}
+
+func TestTenantWebhookFileEvents(t *testing.T) {
+	admin := enableTenantRegistry(t)
+	srv, _ := newTestServer(t)
+	startTestWebhooks(t)
+	rcv := newWebhookReceiver(t, 0)
+
+	var created TenantJobResponse
+	resp := call(t, srv, "POST", "/api/admin/tenants", CreateTenantRequest{
+		ID:      "acme",
+		Webhook: &TenantWebhook{URL: rcv.URL, Events: []string{"upload"}, Secret: "s3cret"},
+	}, admin...)
+	expectStatus(t, resp, http.StatusAccepted)
+	resp.decode(t, &created)
+	waitJob(t, srv, created.Job.ID)
+
+	key := []string{"X-API-Key", created.APIKeys[0]}
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("q3.txt", "numbers"), key...), http.StatusOK)
+	expectStatus(t, call(t, srv, "DELETE", "/api/files/q3.txt", nil, key...), http.StatusOK)
+	expectStatus(t, call(t, srv, "POST", "/api/upload", upload("q4.txt", "numbers"), key...), http.StatusOK)
+	// Named as the tenant sees them, and only the events it asked for
+	got := rcv.wait(t, 2)
+	if got[0].Tenant != "acme" || got[0].Name != "q3.txt" || got[1].Name != "q4.txt" {
+		t.Errorf("deliveries %+v", got)
+	}
+}